@@ -0,0 +1,100 @@
+package stapled
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+// AccessLog controls the log line written for each request to the
+// responder. Requests answered from memory are sampled since on a
+// busy responder they are the bulk of the traffic, requests that
+// missed the cache or failed are always logged
+type AccessLog struct {
+	Enabled bool
+	// HitSample logs one in every HitSample requests answered from
+	// memory, every one is logged if it is less than two
+	HitSample int
+	// HashClientIPs logs a keyed hash of client addresses instead of
+	// the addresses, including in warnings about rejected requests.
+	// The key is random and changes when stapled restarts, so
+	// requests from the same client can only be correlated within a
+	// single run
+	HashClientIPs bool
+}
+
+// WithAccessLog configures the responder access log
+func WithAccessLog(al AccessLog) Option {
+	return func(s *Server) error {
+		if al.HitSample < 0 {
+			return errors.New("access log hit sample must not be negative")
+		}
+		s.accessLog = al
+		if al.HashClientIPs {
+			s.clientKey = make([]byte, 32)
+			if _, err := rand.Read(s.clientKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// clientAddr returns the address of the client that sent r, or a
+// hash of it if client addresses are hashed
+func (s *Server) clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if s.clientKey == nil {
+		return host
+	}
+	mac := hmac.New(common.NewSHA256, s.clientKey)
+	mac.Write([]byte(host))
+	return "client-" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// statusWriter records the status code written to a
+// http.ResponseWriter
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// logAccess writes the access log line for a request, result is hit,
+// miss, or error. serial is nil if the request couldn't be parsed
+func (s *Server) logAccess(r *http.Request, status int, result string, serial *big.Int, d time.Duration) {
+	sample, ok := s.accessSample(result)
+	if !ok {
+		return
+	}
+	serialHex := "-"
+	if serial != nil {
+		serialHex = serial.Text(16)
+	}
+	s.log.Info("[access] client=%s method=%s serial=%s result=%s status=%d duration=%s sample=%d", s.clientAddr(r), r.Method, serialHex, result, status, d, sample)
+}
+
+// accessSample checks if a request with result should be logged, and
+// returns the sample rate it was logged at
+func (s *Server) accessSample(result string) (int, bool) {
+	if result != "hit" || s.accessLog.HitSample < 2 {
+		return 1, true
+	}
+	n := atomic.AddUint64(&s.accessHits, 1)
+	return s.accessLog.HitSample, n%uint64(s.accessLog.HitSample) == 1
+}
@@ -0,0 +1,679 @@
+package stapled
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/mcache"
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+	"github.com/rolandshoemaker/stapled/version"
+)
+
+// acceptsGzip checks if the client listed gzip in Accept-Encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc = strings.TrimSpace(enc)
+		if i := strings.Index(enc, ";"); i >= 0 {
+			if strings.TrimSpace(enc[i+1:]) == "q=0" {
+				continue
+			}
+			enc = strings.TrimSpace(enc[:i])
+		}
+		if enc == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON writes v as JSON, compressing it with gzip if the client
+// accepts it
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	s.writeJSONStatus(w, r, http.StatusOK, v)
+}
+
+func (s *Server) writeJSONStatus(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	var out io.Writer = w
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		defer gw.Close()
+		out = gw
+	}
+	w.WriteHeader(code)
+	err := json.NewEncoder(out).Encode(v)
+	if err != nil {
+		s.log.Err("[admin] Failed to write JSON response: %s", err)
+	}
+}
+
+func (s *Server) versionHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, r, version.Get(s.features))
+}
+
+// entryMetadata describes the response held for a entry, the
+// response fields are empty if the entry has no response
+type entryMetadata struct {
+	Name           string     `json:"name"`
+	ID             string     `json:"id"`
+	Source         string     `json:"source,omitempty"`
+	Serial         string     `json:"serial"`
+	Fingerprint    string     `json:"fingerprint,omitempty"` // hex SHA-256 of the certificate
+	Status         string     `json:"status,omitempty"`
+	ThisUpdate     *time.Time `json:"thisUpdate,omitempty"`
+	NextUpdate     *time.Time `json:"nextUpdate,omitempty"`
+	LastSync       *time.Time `json:"lastSync,omitempty"`
+	Responder      string     `json:"responder,omitempty"`
+	ResponseSHA256 string     `json:"responseSHA256,omitempty"`
+	BoostedUntil   *time.Time `json:"boostedUntil,omitempty"`
+}
+
+func newEntryMetadata(info mcache.EntryInfo) entryMetadata {
+	md := entryMetadata{
+		Name:   info.Name,
+		ID:     info.ID,
+		Source: info.Source,
+		Serial: fmt.Sprintf("%X", info.Serial),
+	}
+	if info.Fingerprint != [32]byte{} {
+		md.Fingerprint = hex.EncodeToString(info.Fingerprint[:])
+	}
+	if !info.ThisUpdate.IsZero() {
+		md.Status = statusNames[info.Status]
+		md.ThisUpdate = &info.ThisUpdate
+		md.NextUpdate = &info.NextUpdate
+		md.LastSync = &info.LastSync
+		md.Responder = info.Responder
+		md.ResponseSHA256 = hex.EncodeToString(info.ResponseDigest[:])
+	}
+	if !info.BoostedUntil.IsZero() {
+		md.BoostedUntil = &info.BoostedUntil
+	}
+	return md
+}
+
+var statusNames = map[int]string{
+	ocsp.Good:    "good",
+	ocsp.Revoked: "revoked",
+	ocsp.Unknown: "unknown",
+}
+
+// certPath identifies a certificate in a admin path, either by its
+// issuer key hash and serial or, if fingerprint is set, by its SHA-256
+// fingerprint
+type certPath struct {
+	issuerKeyHash []byte
+	serial        *big.Int
+	fingerprint   string
+}
+
+// parseCertPath parses a path of the form <prefix><issuer key
+// hash>/<serial> or <prefix><SHA-256 fingerprint>, writing a error
+// response if it is malformed
+func parseCertPath(w http.ResponseWriter, r *http.Request, prefix string) (certPath, bool) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return certPath{}, false
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if len(parts) == 1 {
+		if _, ok := mcache.ParseFingerprint(parts[0]); ok {
+			return certPath{fingerprint: parts[0]}, true
+		}
+	}
+	if len(parts) != 2 {
+		http.Error(w, fmt.Sprintf("expected %s<issuer key hash>/<serial> or %s<SHA-256 fingerprint>", prefix, prefix), http.StatusBadRequest)
+		return certPath{}, false
+	}
+	issuerKeyHash, err := hex.DecodeString(parts[0])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid issuer key hash: %s", err), http.StatusBadRequest)
+		return certPath{}, false
+	}
+	serial, ok := new(big.Int).SetString(parts[1], 16)
+	if !ok {
+		http.Error(w, "invalid serial", http.StatusBadRequest)
+		return certPath{}, false
+	}
+	return certPath{issuerKeyHash: issuerKeyHash, serial: serial}, true
+}
+
+// lookupEntry returns the metadata for the entry for the certificate
+// identified by cp
+func (s *Server) lookupEntry(cp certPath) (mcache.EntryInfo, bool) {
+	if cp.fingerprint != "" {
+		return s.c.Find(cp.fingerprint)
+	}
+	return s.c.LookupEntry(cp.issuerKeyHash, cp.serial)
+}
+
+// entryHandler serves the metadata for the entry identified by
+// /entry/<hex issuer key hash>/<hex serial>, the issuer key hash may
+// use any of the supported hashes, or /entry/<SHA-256 fingerprint>
+func (s *Server) entryHandler(w http.ResponseWriter, r *http.Request) {
+	cp, ok := parseCertPath(w, r, "/entry/")
+	if !ok {
+		return
+	}
+	info, present := s.lookupEntry(cp)
+	if !present {
+		http.Error(w, "entry not found", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, r, newEntryMetadata(info))
+}
+
+// fetchAttempt is a upstream request made for a entry
+type fetchAttempt struct {
+	Time       time.Time `json:"time"`
+	Responder  string    `json:"responder"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Latency    string    `json:"latency"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// historyHandler serves the most recent upstream requests made for the
+// entry identified by /history/<hex issuer key hash>/<hex serial> or
+// /history/<SHA-256 fingerprint>, oldest first
+func (s *Server) historyHandler(w http.ResponseWriter, r *http.Request) {
+	cp, ok := parseCertPath(w, r, "/history/")
+	if !ok {
+		return
+	}
+	var attempts []mcache.FetchAttempt
+	var present bool
+	if cp.fingerprint != "" {
+		attempts, present = s.c.FindHistory(cp.fingerprint)
+	} else {
+		attempts, present = s.c.FetchHistory(cp.issuerKeyHash, cp.serial)
+	}
+	if !present {
+		http.Error(w, "entry not found", http.StatusNotFound)
+		return
+	}
+	history := make([]fetchAttempt, len(attempts))
+	for i, a := range attempts {
+		history[i] = fetchAttempt{
+			Time:       a.Time,
+			Responder:  a.Responder,
+			StatusCode: a.StatusCode,
+			Latency:    a.Latency.String(),
+			Error:      a.Error,
+		}
+	}
+	s.writeJSON(w, r, history)
+}
+
+const (
+	// defaultListLimit is the number of entries listed by /entries
+	// when no limit is requested
+	defaultListLimit = 1000
+	// maxListLimit is the largest limit accepted by /entries
+	maxListLimit = 10000
+)
+
+// entryList is a page of entries, Next is the cursor for the following
+// page and is empty on the last page
+type entryList struct {
+	Entries []entryMetadata `json:"entries"`
+	Total   int             `json:"total"`
+	Next    string          `json:"next,omitempty"`
+}
+
+// entriesHandler serves the metadata for the entries in the cache,
+// sorted by name. The limit query parameter sets the page size and
+// after, the next cursor from the previous page, the entry the page
+// starts after. Since the cursor is a name, entries being added or
+// removed between requests doesn't cause others to be skipped
+func (s *Server) entriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := defaultListLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxListLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	after := r.URL.Query().Get("after")
+
+	infos := s.c.Entries()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	start := sort.Search(len(infos), func(i int) bool { return infos[i].Name > after })
+	list := entryList{Entries: []entryMetadata{}, Total: len(infos)}
+	for _, info := range infos[start:] {
+		if len(list.Entries) == limit {
+			list.Next = list.Entries[limit-1].Name
+			break
+		}
+		list.Entries = append(list.Entries, newEntryMetadata(info))
+	}
+	s.writeJSON(w, r, list)
+}
+
+// certStatus is the revocation status of a certificate
+type certStatus struct {
+	Status           string     `json:"status"`
+	RevokedAt        *time.Time `json:"revokedAt,omitempty"`
+	RevocationReason *int       `json:"revocationReason,omitempty"`
+	ThisUpdate       time.Time  `json:"thisUpdate"`
+	NextUpdate       time.Time  `json:"nextUpdate"`
+}
+
+// statusHandler serves the revocation status of the certificate
+// identified by /status/<hex issuer key hash>/<hex serial>, so that
+// services can use the cache to check the status of certificates,
+// such as mTLS client certificates, that aren't stapled. If the
+// certificate isn't in the cache it is added using the upstream
+// responders as long as its issuer is known. Certificates can also be
+// identified by /status/<SHA-256 fingerprint>, but only if they are
+// already in the cache
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	cp, ok := parseCertPath(w, r, "/status/")
+	if !ok {
+		return
+	}
+	var info mcache.EntryInfo
+	var err error
+	if cp.fingerprint != "" {
+		var present bool
+		if info, present = s.c.Find(cp.fingerprint); !present {
+			http.Error(w, "entry not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		info, err = s.c.LookupStatus(cp.issuerKeyHash, cp.serial, s.upstreamResponders)
+	}
+	if errors.Is(err, mcache.ErrNoIssuer) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.log.Err("[admin] Failed to look up status of serial %X: %s", cp.serial, err)
+		http.Error(w, fmt.Sprintf("failed to look up status: %s", err), http.StatusBadGateway)
+		return
+	}
+	if info.ThisUpdate.IsZero() {
+		http.Error(w, "no response is available", http.StatusServiceUnavailable)
+		return
+	}
+	status := certStatus{
+		Status:     statusNames[info.Status],
+		ThisUpdate: info.ThisUpdate,
+		NextUpdate: info.NextUpdate,
+	}
+	if info.Status == ocsp.Revoked {
+		status.RevokedAt = &info.RevokedAt
+		status.RevocationReason = &info.RevocationReason
+	}
+	s.writeJSON(w, r, status)
+}
+
+// unstapleable is a must-staple certificate that can't currently be
+// stapled
+type unstapleable struct {
+	Name   string `json:"name"`
+	ID     string `json:"id"`
+	Serial string `json:"serial"`
+	Reason string `json:"reason"` // no-response, expired, or revoked
+}
+
+// mustStapleReport lists the must-staple certificates without a valid
+// response, Ready is true if there aren't any
+type mustStapleReport struct {
+	Ready      bool           `json:"ready"`
+	MustStaple int            `json:"mustStaple"`
+	Missing    []unstapleable `json:"missing"`
+}
+
+// mustStapleHandler serves a mustStapleReport for the entries created
+// from certificates with the must-staple extension. Clients that
+// enforce must-staple hard-fail handshakes without a staple, so
+// deployment tooling can check this before sending traffic to a
+// frontend, the status code is 503 if any of them are missing a valid
+// response
+func (s *Server) mustStapleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	now := s.clk.Now()
+	report := mustStapleReport{Missing: []unstapleable{}}
+	for _, info := range s.c.Entries() {
+		if !info.MustStaple {
+			continue
+		}
+		report.MustStaple++
+		var reason string
+		switch {
+		case info.ThisUpdate.IsZero():
+			reason = "no-response"
+		case !info.NextUpdate.After(now):
+			reason = "expired"
+		case info.Status == ocsp.Revoked:
+			reason = "revoked"
+		default:
+			continue
+		}
+		report.Missing = append(report.Missing, unstapleable{
+			Name:   info.Name,
+			ID:     info.ID,
+			Serial: fmt.Sprintf("%X", info.Serial),
+			Reason: reason,
+		})
+	}
+	sort.Slice(report.Missing, func(i, j int) bool { return report.Missing[i].Name < report.Missing[j].Name })
+	report.Ready = len(report.Missing) == 0
+	code := http.StatusOK
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	s.writeJSONStatus(w, r, code, report)
+}
+
+// readyReport is the body of the /ready endpoint
+type readyReport struct {
+	Ready    bool           `json:"ready"`
+	Watchers []watcherStats `json:"watchers"`
+	Clock    *clockOffset   `json:"clock,omitempty"`
+}
+
+// readyHandler reports if every watched folder has been scanned
+// successfully recently, so that a folder which can no longer be read
+// fails readiness checks instead of silently serving a stale set of
+// entries. A folder is stale if it hasn't been scanned successfully
+// for three scan intervals, the status code is 503 if any are. With a
+// strict clock check the clock being too far off also fails readiness
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report := readyReport{Ready: true, Watchers: s.watcherStats(), Clock: s.clockReport()}
+	cutoff := s.clk.Now().Add(-3 * s.certFolderInterval)
+	for _, stats := range report.Watchers {
+		if stats.LastScan.IsZero() || stats.LastScan.Before(cutoff) {
+			report.Ready = false
+		}
+	}
+	if report.Clock != nil && report.Clock.Exceeded && s.clockCheck.Strict {
+		report.Ready = false
+	}
+	code := http.StatusOK
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	s.writeJSONStatus(w, r, code, report)
+}
+
+// driftMetric is the ProducedAt drift of a responder in seconds
+type driftMetric struct {
+	Last    float64 `json:"last"`
+	Average float64 `json:"average"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Samples int     `json:"samples"`
+}
+
+// verifyMetric describes the response verification pool
+type verifyMetric struct {
+	Workers       int     `json:"workers"`
+	Queued        int     `json:"queued"`
+	Verified      int64   `json:"verified"`
+	Failed        int64   `json:"failed"`
+	WaitedSeconds float64 `json:"waitedSeconds"`
+}
+
+type retryBudgetMetric struct {
+	Requests int64 `json:"requests"`
+	Retries  int64 `json:"retries"`
+	Rejected int64 `json:"rejected"`
+}
+
+// lifetimeMetric is a histogram of the remaining lifetime of the
+// responses for the certificates of a issuer, Buckets maps the upper
+// bound of each bucket in seconds, or +Inf, to the number of responses
+// that expire within it and after the previous bucket
+type lifetimeMetric struct {
+	Issuer             string         `json:"issuer"`
+	IssuerKeyHash      string         `json:"issuerKeyHash"`
+	Buckets            map[string]int `json:"buckets"`
+	Expired            int            `json:"expired"`
+	NoResponse         int            `json:"noResponse"`
+	MinValiditySeconds float64        `json:"minValiditySeconds"`
+	MaxValiditySeconds float64        `json:"maxValiditySeconds"`
+	AvgValiditySeconds float64        `json:"avgValiditySeconds"`
+}
+
+func lifetimeMetrics(lifetimes []mcache.IssuerLifetimes, buckets []time.Duration) []lifetimeMetric {
+	metrics := make([]lifetimeMetric, 0, len(lifetimes))
+	for _, il := range lifetimes {
+		lm := lifetimeMetric{
+			Issuer:             il.Issuer,
+			IssuerKeyHash:      il.IssuerKeyHash,
+			Buckets:            make(map[string]int, len(il.Counts)),
+			Expired:            il.Expired,
+			NoResponse:         il.NoResponse,
+			MinValiditySeconds: il.MinValidity.Seconds(),
+			MaxValiditySeconds: il.MaxValidity.Seconds(),
+			AvgValiditySeconds: il.AvgValidity.Seconds(),
+		}
+		for i, count := range il.Counts {
+			le := "+Inf"
+			if i < len(buckets) {
+				le = strconv.FormatFloat(buckets[i].Seconds(), 'f', -1, 64)
+			}
+			lm.Buckets[le] = count
+		}
+		metrics = append(metrics, lm)
+	}
+	return metrics
+}
+
+type metrics struct {
+	ProducedAtDrift   map[string]driftMetric       `json:"producedAtDrift"`
+	Verification      verifyMetric                 `json:"verification"`
+	RetryBudget       map[string]retryBudgetMetric `json:"retryBudget"`
+	RefreshesSkipped  int64                        `json:"refreshesSkipped"`
+	UnknownResponses  int64                        `json:"unknownResponses"`
+	ResponseLifetimes []lifetimeMetric             `json:"responseLifetimes"`
+	WatchFolders      []watcherStats               `json:"watchFolders"`
+	DiskCheck         *DiskReport                  `json:"diskCheck,omitempty"`
+	AIAFailures       map[string]int64             `json:"aiaFailures"`
+}
+
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	vs := stapledOCSP.DefaultVerifyPool().Stats()
+	m := metrics{
+		ProducedAtDrift: map[string]driftMetric{},
+		RetryBudget:     map[string]retryBudgetMetric{},
+		Verification: verifyMetric{
+			Workers:       vs.Workers,
+			Queued:        vs.Queued,
+			Verified:      vs.Verified,
+			Failed:        vs.Failed,
+			WaitedSeconds: vs.Waited.Seconds(),
+		},
+	}
+	for responder, stats := range s.c.ResponderDrift() {
+		m.ProducedAtDrift[responder] = driftMetric{
+			Last:    stats.Last.Seconds(),
+			Average: stats.Average.Seconds(),
+			Min:     stats.Min.Seconds(),
+			Max:     stats.Max.Seconds(),
+			Samples: stats.Samples,
+		}
+	}
+	cs := s.c.Stats(0)
+	m.RefreshesSkipped = cs.RefreshSkipped
+	m.UnknownResponses = cs.Unknown
+	m.ResponseLifetimes = lifetimeMetrics(s.c.Lifetimes(nil), mcache.DefaultLifetimeBuckets)
+	m.WatchFolders = s.watcherStats()
+	m.DiskCheck = s.diskReport
+	m.AIAFailures = s.c.AIAFailures()
+	for host, stats := range s.c.RetryBudget() {
+		m.RetryBudget[host] = retryBudgetMetric{
+			Requests: stats.Requests,
+			Retries:  stats.Retries,
+			Rejected: stats.Rejected,
+		}
+	}
+	s.writeJSON(w, r, m)
+}
+
+// lifetimesHandler serves the remaining lifetime histograms of each
+// issuer, by default using mcache.DefaultLifetimeBuckets. The buckets
+// can be replaced with a comma separated list of durations in the
+// buckets query parameter
+func (s *Server) lifetimesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	buckets := mcache.DefaultLifetimeBuckets
+	if b := r.URL.Query().Get("buckets"); b != "" {
+		buckets = nil
+		for _, field := range strings.Split(b, ",") {
+			d, err := time.ParseDuration(strings.TrimSpace(field))
+			if err != nil || d <= 0 || (len(buckets) > 0 && d <= buckets[len(buckets)-1]) {
+				http.Error(w, "buckets must be increasing positive durations", http.StatusBadRequest)
+				return
+			}
+			buckets = append(buckets, d)
+		}
+	}
+	s.writeJSON(w, r, lifetimeMetrics(s.c.Lifetimes(buckets), buckets))
+}
+
+// proxyConfig replaces the proxies and responder rewrites used to
+// fetch responses, an empty proxy list sends requests directly
+type proxyConfig struct {
+	Proxies           []string          `json:"proxies"`
+	ResponderRewrites map[string]string `json:"responderRewrites"`
+}
+
+// proxiesHandler replaces the proxy configuration when a proxyConfig
+// is POSTed to /proxies, the change isn't persisted
+func (s *Server) proxiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.transport == nil {
+		http.Error(w, "proxies can't be reloaded", http.StatusNotImplemented)
+		return
+	}
+	var pc proxyConfig
+	if err := json.NewDecoder(r.Body).Decode(&pc); err != nil {
+		http.Error(w, fmt.Sprintf("invalid proxy configuration: %s", err), http.StatusBadRequest)
+		return
+	}
+	var proxyFunc func(*http.Request) (*url.URL, error)
+	if len(pc.Proxies) > 0 {
+		var err error
+		if proxyFunc, err = common.ProxyFunc(pc.Proxies); err != nil {
+			http.Error(w, fmt.Sprintf("invalid proxies: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	s.transport.Reload(proxyFunc, pc.ResponderRewrites)
+	s.log.Info("[admin] Replaced proxy configuration with %d proxies and %d responder rewrites", len(pc.Proxies), len(pc.ResponderRewrites))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxImportSize is the largest DER OCSP response that will be
+// accepted by /import
+const maxImportSize = 1 << 16
+
+// importResult lists the entries a imported response replaced the
+// response for
+type importResult struct {
+	Entries []string `json:"entries"`
+}
+
+// importHandler replaces the cached response for the certificate a
+// DER response POSTed to /import is for, once it has been verified,
+// so that responses fetched elsewhere can be ferried into air-gapped
+// environments
+func (s *Server) importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxImportSize+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read response: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxImportSize {
+		http.Error(w, "response is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	names, err := s.c.Import(body)
+	if errors.Is(err, mcache.ErrNoEntry) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	for _, name := range names {
+		s.log.Info("[admin] Imported response for '%s'", name)
+	}
+	if err != nil {
+		s.log.Warning("[admin] Rejected imported response: %s", err)
+		http.Error(w, fmt.Sprintf("invalid response: %s", err), http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, r, importResult{Entries: names})
+}
+
+func (s *Server) initAdmin() {
+	m := http.NewServeMux()
+	m.HandleFunc("/version", s.versionHandler)
+	m.HandleFunc("/metrics", s.metricsHandler)
+	m.HandleFunc("/entry/", s.entryHandler)
+	m.HandleFunc("/entries", s.entriesHandler)
+	m.HandleFunc("/must-staple", s.mustStapleHandler)
+	m.HandleFunc("/ready", s.readyHandler)
+	m.HandleFunc("/lifetimes", s.lifetimesHandler)
+	m.HandleFunc("/status/", s.statusHandler)
+	m.HandleFunc("/proxies", s.proxiesHandler)
+	m.HandleFunc("/import", s.importHandler)
+	m.HandleFunc("/issuer/", s.issuerHandler)
+	m.HandleFunc("/history/", s.historyHandler)
+	m.HandleFunc("/clients", s.clientsHandler)
+	m.HandleFunc("/clients/", s.clientsHandler)
+	m.HandleFunc("/misses", s.missesHandler)
+	m.HandleFunc("/boost", s.boostHandler)
+	m.HandleFunc("/responses", s.responsesHandler)
+	m.HandleFunc("/standby", s.standbyHandler)
+	m.HandleFunc("/promote", s.promoteHandler)
+	m.HandleFunc("/efficiency", s.efficiencyHandler)
+	if s.prometheus != nil {
+		m.Handle("/prometheus", s.prometheus)
+	}
+	s.admin.Handler = m
+}
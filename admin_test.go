@@ -0,0 +1,456 @@
+package stapled
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/mcache"
+	"github.com/rolandshoemaker/stapled/version"
+)
+
+func TestVersionHandler(t *testing.T) {
+	s := &Server{features: []string{"admin"}}
+	w := httptest.NewRecorder()
+	s.versionHandler(w, httptest.NewRequest("GET", "/version", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Unexpected Content-Type: %q", ct)
+	}
+	var info version.Info
+	err := json.Unmarshal(w.Body.Bytes(), &info)
+	if err != nil {
+		t.Fatalf("Failed to parse version response: %s", err)
+	}
+	if info.Version != version.Version || len(info.Features) != 1 || info.Features[0] != "admin" {
+		t.Fatalf("Unexpected version response: %+v", info)
+	}
+}
+
+func TestEntryHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	_, keyHash, err := common.HashNameAndPKI(crypto.SHA256.New(), tf.issuer.RawSubject, tf.issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		t.Fatalf("Failed to hash issuer: %s", err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		tf.s.entryHandler(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/entry/" + hex.EncodeToString(keyHash) + "/539")
+	if w.Code != 200 {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body)
+	}
+	var md entryMetadata
+	if err = json.Unmarshal(w.Body.Bytes(), &md); err != nil {
+		t.Fatalf("Failed to parse entry response: %s", err)
+	}
+	digest := sha256.Sum256(tf.response)
+	if md.Serial != "539" || md.Status != "good" || md.Responder != tf.upstream.URL || md.ResponseSHA256 != hex.EncodeToString(digest[:]) {
+		t.Fatalf("Unexpected entry response: %+v", md)
+	}
+	if md.ThisUpdate == nil || md.NextUpdate == nil || md.LastSync == nil || !md.LastSync.Equal(tf.fc.Now()) {
+		t.Fatalf("Unexpected entry times: %+v", md)
+	}
+
+	fingerprint := sha256.Sum256(tf.certDER)
+	if md.Fingerprint != hex.EncodeToString(fingerprint[:]) {
+		t.Fatalf("Unexpected fingerprint: %s", md.Fingerprint)
+	}
+	// scanners usually print fingerprints in upper case separated by
+	// colons
+	colons := strings.ToUpper(hex.EncodeToString(fingerprint[:1]))
+	for _, b := range fingerprint[1:] {
+		colons += ":" + strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+	for _, path := range []string{"/entry/" + md.Fingerprint, "/entry/" + colons} {
+		if w = get(path); w.Code != 200 || !strings.Contains(w.Body.String(), `"serial":"539"`) {
+			t.Fatalf("Unexpected response for %s: %d %s", path, w.Code, w.Body)
+		}
+	}
+
+	for path, code := range map[string]int{
+		"/entry/" + strings.Repeat("00", 32):                  404,
+		"/entry/" + hex.EncodeToString(keyHash) + "/53a":      404,
+		"/entry/" + hex.EncodeToString(keyHash[:20]) + "/539": 404,
+		"/entry/zz/539": 400,
+		"/entry/00/zz":  400,
+		"/entry/00":     400,
+	} {
+		if w := get(path); w.Code != code {
+			t.Fatalf("Expected status code %d for %s, got %d", code, path, w.Code)
+		}
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	_, keyHash, err := common.HashNameAndPKI(crypto.SHA1.New(), tf.issuer.RawSubject, tf.issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		t.Fatalf("Failed to hash issuer: %s", err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		tf.s.historyHandler(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	w := get("/history/" + hex.EncodeToString(keyHash) + "/539")
+	var history []fetchAttempt
+	if err = json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatalf("Failed to parse history: %s", err)
+	}
+	if len(history) != 1 || history[0].Responder != tf.upstream.URL || history[0].StatusCode != http.StatusOK || history[0].Error != "" {
+		t.Fatalf("Unexpected history: %+v", history)
+	}
+	if w = get("/history/" + hex.EncodeToString(keyHash) + "/1"); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a unknown entry, got %d", w.Code)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	_, keyHash, err := common.HashNameAndPKI(crypto.SHA1.New(), tf.issuer.RawSubject, tf.issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		t.Fatalf("Failed to hash issuer: %s", err)
+	}
+	path := "/status/" + hex.EncodeToString(keyHash) + "/539"
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		tf.s.statusHandler(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	checkGood := func() {
+		w := get(path)
+		if w.Code != 200 {
+			t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body)
+		}
+		var status certStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Failed to parse status response: %s", err)
+		}
+		if status.Status != "good" || status.RevokedAt != nil || status.NextUpdate.IsZero() {
+			t.Fatalf("Unexpected status response: %+v", status)
+		}
+	}
+	checkGood()
+
+	// once removed the entry should be recreated from the upstream
+	// responders using the cached issuer
+	name := tf.s.c.Entries()[0].Name
+	if err = tf.s.c.Remove(name); err != nil {
+		t.Fatalf("Failed to remove entry: %s", err)
+	}
+	if w := get(path); w.Code != 502 {
+		t.Fatalf("Expected 502 without upstream responders, got %d", w.Code)
+	}
+	tf.s.upstreamResponders = []string{tf.upstream.URL}
+	checkGood()
+	if entries := tf.s.c.Entries(); len(entries) != 1 {
+		t.Fatalf("Expected the looked up entry to be cached, got %d entries", len(entries))
+	}
+
+	if w := get("/status/" + hex.EncodeToString(make([]byte, 20)) + "/539"); w.Code != 404 {
+		t.Fatalf("Expected 404 for unknown issuer, got %d", w.Code)
+	}
+}
+
+func TestImportHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	post := func(body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		tf.s.importHandler(w, httptest.NewRequest("POST", "/import", bytes.NewReader(body)))
+		return w
+	}
+	sign := func(key crypto.Signer, serial int64, thisUpdate time.Time) []byte {
+		resp, err := ocsp.CreateResponse(tf.issuer, tf.issuer, ocsp.Response{
+			SerialNumber: big.NewInt(serial),
+			Status:       ocsp.Revoked,
+			RevokedAt:    thisUpdate,
+			ThisUpdate:   thisUpdate,
+			NextUpdate:   thisUpdate.Add(time.Hour * 24),
+		}, key)
+		if err != nil {
+			t.Fatalf("ocsp.CreateResponse failed: %s", err)
+		}
+		return resp
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+
+	now := tf.fc.Now()
+	for _, test := range []struct {
+		name string
+		body []byte
+		code int
+	}{
+		{"garbage", []byte{1, 2, 3}, 400},
+		{"unknown serial", sign(tf.key, 1338, now), 404},
+		{"wrong signer", sign(otherKey, 1337, now), 400},
+		{"older than current", sign(tf.key, 1337, now.Add(-2*time.Hour)), 400},
+		{"expired", sign(tf.key, 1337, now.Add(-48*time.Hour)), 400},
+	} {
+		if w := post(test.body); w.Code != test.code {
+			t.Fatalf("Expected status code %d for %s, got %d: %s", test.code, test.name, w.Code, w.Body)
+		}
+	}
+	if info := tf.s.c.Entries()[0]; info.Status != ocsp.Good {
+		t.Fatalf("Rejected response replaced the cached response, status is %d", info.Status)
+	}
+
+	body := sign(tf.key, 1337, now)
+	w := post(body)
+	if w.Code != 200 {
+		t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body)
+	}
+	var result importResult
+	if err = json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse import response: %s", err)
+	}
+	info := tf.s.c.Entries()[0]
+	if len(result.Entries) != 1 || result.Entries[0] != info.Name {
+		t.Fatalf("Unexpected import response: %+v", result)
+	}
+	if info.Status != ocsp.Revoked || info.ResponseDigest != sha256.Sum256(body) {
+		t.Fatalf("Imported response wasn't cached: %+v", info)
+	}
+
+	w = httptest.NewRecorder()
+	tf.s.importHandler(w, httptest.NewRequest("GET", "/import", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405 for GET, got %d", w.Code)
+	}
+}
+
+func TestEntriesHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	cert, err := x509.ParseCertificate(tf.certDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	for _, name := range []string{"leaf-2", "leaf-1"} {
+		err = tf.s.c.AddCertificate(name, cert, mcache.CertificateOptions{Issuer: tf.issuer, Responders: []string{tf.upstream.URL}})
+		if err != nil {
+			t.Fatalf("AddCertificate failed: %s", err)
+		}
+	}
+	list := func(query string, gzipped bool) entryList {
+		r := httptest.NewRequest("GET", "/entries"+query, nil)
+		if gzipped {
+			r.Header.Set("Accept-Encoding", "deflate, gzip")
+		}
+		w := httptest.NewRecorder()
+		tf.s.entriesHandler(w, r)
+		if w.Code != 200 {
+			t.Fatalf("Unexpected status code %d: %s", w.Code, w.Body)
+		}
+		var body io.Reader = w.Body
+		if gzipped {
+			if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+				t.Fatalf("Unexpected Content-Encoding: %q", ce)
+			}
+			if body, err = gzip.NewReader(w.Body); err != nil {
+				t.Fatalf("gzip.NewReader failed: %s", err)
+			}
+		}
+		var l entryList
+		if err = json.NewDecoder(body).Decode(&l); err != nil {
+			t.Fatalf("Failed to parse entries response: %s", err)
+		}
+		return l
+	}
+
+	first := list("?limit=2", true)
+	if first.Total != 3 || len(first.Entries) != 2 || first.Entries[1].Name != "leaf-1" || first.Next != "leaf-1" {
+		t.Fatalf("Unexpected first page: %+v", first)
+	}
+	if first.Entries[0].Status != "good" {
+		t.Fatalf("Unexpected entry metadata: %+v", first.Entries[0])
+	}
+	second := list("?limit=2&after="+first.Next, false)
+	if len(second.Entries) != 1 || second.Entries[0].Name != "leaf-2" || second.Next != "" {
+		t.Fatalf("Unexpected second page: %+v", second)
+	}
+
+	w := httptest.NewRecorder()
+	tf.s.entriesHandler(w, httptest.NewRequest("GET", "/entries?limit=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for invalid limit, got %d", w.Code)
+	}
+}
+
+func TestMustStapleHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	get := func() (int, mustStapleReport) {
+		w := httptest.NewRecorder()
+		tf.s.mustStapleHandler(w, httptest.NewRequest("GET", "/must-staple", nil))
+		var report mustStapleReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Failed to parse must-staple response: %s", err)
+		}
+		return w.Code, report
+	}
+	if code, report := get(); code != 200 || !report.Ready || report.MustStaple != 0 {
+		t.Fatalf("Unexpected must-staple response without any must-staple entries: %d %+v", code, report)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1337),
+		Subject:      pkix.Name{CommonName: "must-staple"},
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}, Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05}},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, tf.issuer, tf.key.Public(), tf.key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	err = tf.s.c.AddCertificate("must-staple", cert, mcache.CertificateOptions{Issuer: tf.issuer, Responders: []string{tf.upstream.URL}})
+	if err != nil {
+		t.Fatalf("AddCertificate failed: %s", err)
+	}
+	if code, report := get(); code != 200 || !report.Ready || report.MustStaple != 1 {
+		t.Fatalf("Unexpected must-staple response with a valid response: %d %+v", code, report)
+	}
+
+	tf.fc.Add(time.Hour * 48)
+	code, report := get()
+	if code != http.StatusServiceUnavailable || report.Ready || len(report.Missing) != 1 {
+		t.Fatalf("Unexpected must-staple response with a expired response: %d %+v", code, report)
+	}
+	if m := report.Missing[0]; m.Name != "must-staple" || m.Reason != "expired" || m.Serial != "539" {
+		t.Fatalf("Unexpected missing entry: %+v", m)
+	}
+}
+
+func TestLifetimesHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	get := func(query string) (int, []lifetimeMetric) {
+		w := httptest.NewRecorder()
+		tf.s.lifetimesHandler(w, httptest.NewRequest("GET", "/lifetimes"+query, nil))
+		var lifetimes []lifetimeMetric
+		if w.Code == 200 {
+			if err := json.Unmarshal(w.Body.Bytes(), &lifetimes); err != nil {
+				t.Fatalf("Failed to parse lifetimes response: %s", err)
+			}
+		}
+		return w.Code, lifetimes
+	}
+
+	// the fixture response was produced a hour ago and expires in a day
+	code, lifetimes := get("")
+	if code != 200 || len(lifetimes) != 1 {
+		t.Fatalf("Unexpected lifetimes response: %d %+v", code, lifetimes)
+	}
+	lm := lifetimes[0]
+	if lm.Issuer != "CN=issuer" || lm.Buckets["86400"] != 1 || len(lm.Buckets) != len(mcache.DefaultLifetimeBuckets)+1 {
+		t.Fatalf("Unexpected lifetimes: %+v", lm)
+	}
+	if lm.MinValiditySeconds != 25*3600 || lm.AvgValiditySeconds != 25*3600 {
+		t.Fatalf("Unexpected validity: %+v", lm)
+	}
+
+	code, lifetimes = get("?buckets=1h,12h")
+	if code != 200 || len(lifetimes) != 1 || lifetimes[0].Buckets["+Inf"] != 1 || lifetimes[0].Buckets["3600"] != 0 {
+		t.Fatalf("Unexpected lifetimes with custom buckets: %d %+v", code, lifetimes)
+	}
+
+	tf.fc.Add(48 * time.Hour)
+	if _, lifetimes = get(""); lifetimes[0].Expired != 1 {
+		t.Fatalf("Expired response wasn't counted: %+v", lifetimes[0])
+	}
+
+	if code, _ = get("?buckets=12h,1h"); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for decreasing buckets, got %d", code)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	get := func() (int, readyReport) {
+		w := httptest.NewRecorder()
+		tf.s.readyHandler(w, httptest.NewRequest("GET", "/ready", nil))
+		var report readyReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Failed to parse ready response: %s", err)
+		}
+		return w.Code, report
+	}
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	f := newCertFolder(tempDir, mcache.CertificateOptions{})
+	tf.s.certFolders = append(tf.s.certFolders, f)
+	if code, report := get(); code != http.StatusServiceUnavailable || report.Ready {
+		t.Fatalf("Unexpected ready response before the folder was scanned: %d %+v", code, report)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(tempDir, "not-a-cert"), []byte("nope"), os.ModePerm); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	tf.s.checkCertDirectory(f)
+	code, report := get()
+	if code != 200 || !report.Ready || len(report.Watchers) != 1 {
+		t.Fatalf("Unexpected ready response after a scan: %d %+v", code, report)
+	}
+	if w := report.Watchers[0]; w.Files != 1 || w.Scans != 1 || w.Added != 1 || w.Errors != 1 {
+		t.Fatalf("Unexpected watcher stats: %+v", w)
+	}
+
+	os.RemoveAll(tempDir)
+	tf.fc.Add(tf.s.certFolderInterval * 4)
+	tf.s.checkCertDirectory(f)
+	code, report = get()
+	if code != http.StatusServiceUnavailable || report.Ready {
+		t.Fatalf("Unexpected ready response after the folder was removed: %d %+v", code, report)
+	}
+	if w := report.Watchers[0]; w.Scans != 1 || w.Errors != 2 || w.LastError == "" {
+		t.Fatalf("Unexpected watcher stats after a failed scan: %+v", w)
+	}
+}
@@ -0,0 +1,93 @@
+package stapled
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultBoostTTL      = time.Hour
+	defaultBoostInterval = 5 * time.Minute
+	// maxBoostTTL keeps a forgotten boost from refreshing entries
+	// more often than needed for long
+	maxBoostTTL = 24 * time.Hour
+	// maxBoostEntries is the most entries a single request can boost
+	maxBoostEntries = 1000
+	maxBoostSize    = 1 << 20
+)
+
+// boostRequest is the body of a POST to /boost, TTL and Interval are
+// Go durations such as "90m"
+type boostRequest struct {
+	Entries  []string `json:"entries"` // names, IDs, or certificate fingerprints
+	TTL      string   `json:"ttl"`
+	Interval string   `json:"interval"`
+}
+
+// boostResult is the response to a POST to /boost
+type boostResult struct {
+	Boosted []string  `json:"boosted"`
+	Missing []string  `json:"missing"`
+	Until   time.Time `json:"until"`
+}
+
+// parseBoostDuration parses a optional duration from a boostRequest
+func parseBoostDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("'%s' isn't positive", s)
+	}
+	return d, nil
+}
+
+// boostHandler boosts the refreshes of a set of entries, for instance
+// ahead of deploying their certificates, when a boostRequest is
+// POSTed to /boost, see mcache.EntryCache.Boost. A GET lists when the
+// boost of each boosted entry expires
+func (s *Server) boostHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		s.writeJSON(w, r, s.c.Boosts())
+		return
+	case "POST":
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var br boostRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBoostSize)).Decode(&br); err != nil {
+		http.Error(w, fmt.Sprintf("invalid boost request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(br.Entries) == 0 || len(br.Entries) > maxBoostEntries {
+		http.Error(w, fmt.Sprintf("between 1 and %d entries must be boosted", maxBoostEntries), http.StatusBadRequest)
+		return
+	}
+	ttl, err := parseBoostDuration(br.TTL, defaultBoostTTL)
+	if err == nil && ttl > maxBoostTTL {
+		err = fmt.Errorf("longer than %s", maxBoostTTL)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ttl: %s", err), http.StatusBadRequest)
+		return
+	}
+	interval, err := parseBoostDuration(br.Interval, defaultBoostInterval)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid interval: %s", err), http.StatusBadRequest)
+		return
+	}
+	until := s.clk.Now().Add(ttl)
+	boosted, missing := s.c.Boost(br.Entries, until, interval)
+	s.log.Info("[admin] Boosted refreshes of %d entries until %s, every %s", len(boosted), until, interval)
+	s.writeJSON(w, r, boostResult{Boosted: boosted, Missing: missing, Until: until})
+}
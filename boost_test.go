@@ -0,0 +1,56 @@
+package stapled
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBoostHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	fingerprint := sha256.Sum256(tf.certDER)
+	call := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		tf.s.boostHandler(w, httptest.NewRequest(method, "/boost", strings.NewReader(body)))
+		return w
+	}
+
+	w := call("POST", `{"entries": ["`+hex.EncodeToString(fingerprint[:])+`", "missing"], "ttl": "2h"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status boosting entries: %d %s", w.Code, w.Body)
+	}
+	var result boostResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse boost result: %s", err)
+	}
+	until := tf.fc.Now().Add(2 * time.Hour)
+	if len(result.Boosted) != 1 || len(result.Missing) != 1 || result.Missing[0] != "missing" || !result.Until.Equal(until) {
+		t.Fatalf("Unexpected boost result: %+v", result)
+	}
+	w = call("GET", "")
+	var boosts map[string]time.Time
+	if err := json.Unmarshal(w.Body.Bytes(), &boosts); err != nil {
+		t.Fatalf("Failed to parse boosts: %s", err)
+	}
+	if !boosts[result.Boosted[0]].Equal(until) {
+		t.Fatalf("Unexpected boosts: %v", boosts)
+	}
+
+	for _, body := range []string{
+		`{"entries": []}`,
+		`{"entries": ["a"], "ttl": "48h"}`,
+		`{"entries": ["a"], "interval": "-1m"}`,
+		`{"entries": ["a"], "ttl": "soon"}`,
+		`entries`,
+	} {
+		if w = call("POST", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+}
@@ -0,0 +1,621 @@
+package stapled
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/mcache"
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+)
+
+// configChecker collects the problems found with a configuration
+type configChecker struct {
+	lines    map[string]int
+	problems []config.Problem
+}
+
+func (cc *configChecker) add(warning bool, key, msg string, args ...interface{}) {
+	cc.problems = append(cc.problems, config.Problem{
+		Key:     key,
+		Line:    cc.lines[key],
+		Warning: warning,
+		Message: fmt.Sprintf(msg, args...),
+	})
+}
+
+func (cc *configChecker) addr(key, addr string) {
+	if addr == "" {
+		return
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		cc.add(false, key, "invalid address '%s': %s", addr, err)
+		return
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			cc.add(false, key, "invalid port '%s'", port)
+		}
+	}
+}
+
+// manifest checks that a manifest can be parsed and that its serials
+// and issuer files are valid, issuer hashes can't be checked without
+// loading the issuer folder
+func (cc *configChecker) manifest(key, filename string) {
+	if filename == "" {
+		return
+	}
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		cc.add(false, key, "%s", err)
+		return
+	}
+	entries, err := ParseManifest(filename, contents)
+	if err != nil {
+		cc.add(false, key, "%s", err)
+		return
+	}
+	checked := map[string]bool{}
+	for i, me := range entries {
+		if _, err := parseManifestSerial(me.Serial); err != nil {
+			cc.add(false, key, "entry %d: %s", i, err)
+		}
+		if _, ok := issuerKeyHash(me.Issuer); !ok && !checked[me.Issuer] {
+			checked[me.Issuer] = true
+			if _, err := common.ReadCertificate(me.Issuer); err != nil {
+				cc.add(false, key, "entry %d: failed to load issuer '%s': %s", i, me.Issuer, err)
+			}
+		}
+	}
+}
+
+func (cc *configChecker) folder(key, path string) {
+	if path == "" {
+		return
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		cc.add(false, key, "%s", err)
+		return
+	}
+	if !fi.IsDir() {
+		cc.add(false, key, "'%s' is not a directory", path)
+	}
+}
+
+func (cc *configChecker) certificate(key, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := common.ReadCertificate(path); err != nil {
+		cc.add(false, key, "failed to load certificate '%s': %s", path, err)
+	}
+}
+
+func (cc *configChecker) extensions(key string, defs []config.RequestExtension) {
+	for i, def := range defs {
+		if _, err := stapledOCSP.ParseRequestExtension(def.OID, def.Value, def.Critical); err != nil {
+			cc.add(false, fmt.Sprintf("%s[%d]", key, i), "%s", err)
+		}
+	}
+}
+
+func (cc *configChecker) responseName(key, tmpl string) {
+	if tmpl == "" {
+		return
+	}
+	if _, err := mcache.ParseResponseName(tmpl); err != nil {
+		cc.add(false, key, "%s", err)
+	}
+}
+
+func (cc *configChecker) unknownStatus(key, name string) {
+	if _, err := mcache.ParseUnknownPolicy(name); err != nil {
+		cc.add(false, key, "%s", err)
+	}
+}
+
+func (cc *configChecker) urls(key string, urls []string) {
+	for i, u := range urls {
+		key := fmt.Sprintf("%s[%d]", key, i)
+		parsed, err := url.Parse(u)
+		if err != nil {
+			cc.add(false, key, "%s", err)
+			continue
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			cc.add(false, key, "'%s' is not a http or https URL", u)
+		}
+	}
+}
+
+// CheckConfig parses and validates a configuration, returning every
+// problem found. As well as problems with the structure of the
+// configuration it checks addresses and URLs are well formed and
+// that the files and folders it references exist
+func CheckConfig(src []byte) []config.Problem {
+	conf, problems := config.CheckSyntax(src)
+	if conf == nil {
+		return problems
+	}
+	cc := &configChecker{lines: config.KeyLines(src), problems: problems}
+
+	cc.addr("http.addr", conf.HTTP.Addr)
+	if conf.HTTP.RequestCacheSize < 0 {
+		cc.add(false, "http.request-cache-size", "must not be negative")
+	}
+	if conf.HTTP.AccessLog.HitSample < 0 {
+		cc.add(false, "http.access-log.hit-sample", "must not be negative")
+	}
+	if !conf.HTTP.AccessLog.Enabled && (conf.HTTP.AccessLog.HitSample != 0 || conf.HTTP.AccessLog.HashClientIPs) {
+		cc.add(true, "http.access-log", "settings have no effect unless enabled is set")
+	}
+	inventory := conf.HTTP.ClientInventory
+	if inventory.MaxSerials < 0 {
+		cc.add(false, "http.client-inventory.max-serials", "must not be negative")
+	}
+	if inventory.MaxClients < 0 {
+		cc.add(false, "http.client-inventory.max-clients", "must not be negative")
+	}
+	if !inventory.Enabled && (inventory.MaxSerials != 0 || inventory.MaxClients != 0) {
+		cc.add(true, "http.client-inventory", "settings have no effect unless enabled is set")
+	}
+	if conf.HTTP.MissLog.MaxRequests < 0 {
+		cc.add(false, "http.miss-log.max-requests", "must not be negative")
+	}
+	if !conf.HTTP.MissLog.Enabled && conf.HTTP.MissLog.MaxRequests != 0 {
+		cc.add(true, "http.miss-log", "settings have no effect unless enabled is set")
+	}
+	shadow := conf.HTTP.Shadow
+	if shadow.Rate < 0 || shadow.Rate > 1 {
+		cc.add(false, "http.shadow.rate", "must be between 0 and 1")
+	}
+	if shadow.Timeout.Duration < 0 {
+		cc.add(false, "http.shadow.timeout", "must not be negative")
+	}
+	if shadow.MaxConcurrent < 0 {
+		cc.add(false, "http.shadow.max-concurrent", "must not be negative")
+	}
+	if shadow.Rate == 0 && (shadow.Timeout.Duration != 0 || shadow.MaxConcurrent != 0) {
+		cc.add(true, "http.shadow", "settings have no effect unless rate is set")
+	}
+	expired := conf.HTTP.ExpiredCertificates
+	if policy, err := ParseExpiredPolicy(expired.Policy); err != nil {
+		cc.add(false, "http.expired-certificates.policy", "%s", err)
+	} else if expired.Grace.Duration < 0 {
+		cc.add(false, "http.expired-certificates.grace", "must not be negative")
+	} else if policy != GraceExpired && expired.Grace.Duration != 0 {
+		cc.add(true, "http.expired-certificates.grace", "has no effect unless policy is grace")
+	}
+	if rt := responderTLS(conf); rt != (ResponderTLS{}) {
+		if _, err := responderTLSConfig(rt); err != nil {
+			cc.add(false, "http.tls", "%s", err)
+		}
+	}
+	cc.addr("admin.addr", conf.Admin.Addr)
+	cc.addr("dns.addr", conf.DNS.Addr)
+	if conf.DNS.Addr != "" && conf.DNS.Zone == "" {
+		cc.add(false, "dns", "zone is required when addr is set")
+	}
+
+	defs := conf.Definitions
+	cc.folder("definitions.cert-watch-folder", defs.CertWatchFolder)
+	cc.folder("definitions.issuer-folder", defs.IssuerFolder)
+	for i, wf := range defs.CertWatchFolders {
+		key := fmt.Sprintf("definitions.cert-watch-folders[%d]", i)
+		if wf.Folder == "" {
+			cc.add(false, key, "folder is required")
+		}
+		cc.folder(key+".folder", wf.Folder)
+		cc.certificate(key+".issuer", wf.Issuer)
+		cc.urls(key+".responders", wf.Responders)
+		cc.extensions(key+".request-extensions", wf.RequestExtensions)
+		cc.responseName(key+".response-name", wf.ResponseName)
+		cc.unknownStatus(key+".unknown-status", wf.UnknownStatus)
+		if _, err := common.ProxyFunc(wf.Proxies); len(wf.Proxies) > 0 && err != nil {
+			cc.add(false, key+".proxies", "%s", err)
+		}
+	}
+	for i, def := range defs.Certificates {
+		key := fmt.Sprintf("definitions.certificates[%d]", i)
+		if def.Certificate == "" {
+			cc.add(false, key, "certificate is required")
+		}
+		cc.certificate(key+".certificate", def.Certificate)
+		cc.certificate(key+".issuer", def.Issuer)
+		cc.urls(key+".responders", def.Responders)
+		cc.extensions(key+".request-extensions", def.RequestExtensions)
+		cc.responseName(key+".response-name", def.ResponseName)
+		cc.unknownStatus(key+".unknown-status", def.UnknownStatus)
+	}
+	if conf.LightweightProfile {
+		for i, wf := range defs.CertWatchFolders {
+			if len(wf.RequestExtensions) > 0 {
+				cc.add(false, fmt.Sprintf("definitions.cert-watch-folders[%d].request-extensions", i), "not allowed by lightweight-profile")
+			}
+			if wf.Nonce {
+				cc.add(false, fmt.Sprintf("definitions.cert-watch-folders[%d].nonce", i), "not allowed by lightweight-profile")
+			}
+		}
+		for i, def := range defs.Certificates {
+			if len(def.RequestExtensions) > 0 {
+				cc.add(false, fmt.Sprintf("definitions.certificates[%d].request-extensions", i), "not allowed by lightweight-profile")
+			}
+			if def.Nonce {
+				cc.add(false, fmt.Sprintf("definitions.certificates[%d].nonce", i), "not allowed by lightweight-profile")
+			}
+		}
+		if len(conf.Fetcher.NonceResponders) > 0 {
+			cc.add(false, "fetcher.nonce-responders", "not allowed by lightweight-profile")
+		}
+	}
+
+	switch defs.NoResponderPolicy {
+	case "", "warn", "skip", "fail":
+	default:
+		cc.add(false, "definitions.no-responder-policy", "unknown policy '%s', expected warn, skip, or fail", defs.NoResponderPolicy)
+	}
+	cc.unknownStatus("definitions.unknown-status", defs.UnknownStatus)
+	cc.manifest("definitions.manifest", defs.Manifest)
+	for i, ir := range defs.IssuerResponders {
+		key := fmt.Sprintf("definitions.issuer-responders[%d]", i)
+		switch {
+		case ir.SKID == "" && ir.Subject == "":
+			cc.add(false, key, "skid or subject is required")
+		case ir.SKID != "" && ir.Subject != "":
+			cc.add(true, key, "subject is ignored when skid is set")
+		}
+		if _, err := hex.DecodeString(strings.Replace(ir.SKID, ":", "", -1)); err != nil {
+			cc.add(false, key+".skid", "%s", err)
+		}
+		if len(ir.Responders) == 0 {
+			cc.add(false, key+".responders", "at least one responder is required")
+		}
+		cc.urls(key+".responders", ir.Responders)
+	}
+
+	if conf.Limits.MaxProcs < 0 {
+		cc.add(false, "limits.max-procs", "must not be negative")
+	}
+	if min := conf.Limits.MinFileDescriptors; min > 0 {
+		if limit, err := openFileLimit(); err == nil && limit < min {
+			cc.add(true, "limits.min-file-descriptors", "the current open file limit is %d, stapled won't start unless it is raised", limit)
+		}
+	}
+
+	if conf.Clock.MaxOffset.Duration < 0 {
+		cc.add(false, "clock.max-offset", "must not be negative")
+	}
+	if conf.Clock.MaxOffset.Duration == 0 && (conf.Clock.Strict || len(conf.Clock.URLs) > 0 || conf.Clock.Interval.Duration != 0) {
+		cc.add(true, "clock", "settings have no effect unless max-offset is set")
+	}
+	cc.urls("clock.urls", conf.Clock.URLs)
+
+	if conf.Cluster.LeaderElection {
+		if _, _, err := clusterIdentity(conf); err != nil {
+			cc.add(false, "cluster", "%s", err)
+		}
+	}
+	if conf.Cluster.RefreshLease.Duration < 0 {
+		cc.add(false, "cluster.refresh-lease", "must not be negative")
+	}
+	standby := conf.Cluster.Standby
+	if standby.Active != "" {
+		cc.urls("cluster.standby.active", []string{standby.Active})
+	}
+	if standby.Interval.Duration < 0 {
+		cc.add(false, "cluster.standby.interval", "must not be negative")
+	}
+	if !standby.Enabled && (standby.Active != "" || standby.Interval.Duration != 0) {
+		cc.add(true, "cluster.standby", "settings have no effect unless enabled is set")
+	}
+
+	if conf.Faults.FetchDropRate < 0 || conf.Faults.FetchDropRate > 1 {
+		cc.add(false, "faults.fetch-drop-rate", "must be between 0 and 1")
+	}
+	if conf.Faults.CorruptRate < 0 || conf.Faults.CorruptRate > 1 {
+		cc.add(false, "faults.corrupt-rate", "must be between 0 and 1")
+	}
+	if configFaults(conf).Enabled() {
+		cc.add(true, "faults", "fault injection is enabled, this must never be used in production")
+	}
+
+	cc.addr("metrics.statsd-addr", conf.Metrics.StatsDAddr)
+	if conf.Metrics.Prometheus && conf.Admin.Addr == "" {
+		cc.add(true, "metrics.prometheus", "metrics are only served on the admin listener, which isn't configured")
+	}
+	if conf.Metrics.LogInterval.Duration < 0 {
+		cc.add(false, "metrics.log-interval", "must not be negative")
+	}
+	if conf.Metrics.EfficiencyInterval.Duration < 0 {
+		cc.add(false, "metrics.efficiency-interval", "must not be negative")
+	}
+	if conf.Tracing.OTLPEndpoint != "" {
+		if u, err := url.Parse(conf.Tracing.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			cc.add(false, "tracing.otlp-endpoint", "must be a http or https URL")
+		}
+	} else if len(conf.Tracing.Headers) > 0 || conf.Tracing.ServiceName != "" {
+		cc.add(true, "tracing", "settings have no effect unless otlp-endpoint is set")
+	}
+	if conf.Tracing.Interval.Duration < 0 {
+		cc.add(false, "tracing.interval", "must not be negative")
+	}
+
+	if conf.Export.BundlePath != "" {
+		cc.folder("export.bundle-path", filepath.Dir(conf.Export.BundlePath))
+	}
+	if conf.Export.PackPath != "" {
+		cc.folder("export.pack-path", filepath.Dir(conf.Export.PackPath))
+	}
+	cc.folder("export.issuer-folder", conf.Export.IssuerFolder)
+	if conf.Replica.PackedResponses != "" {
+		if _, err := os.Stat(conf.Replica.PackedResponses); err != nil {
+			cc.add(true, "replica.packed-responses", "%s, responses will be served from it once it has been written", err)
+		}
+	}
+	if conf.Replica.CheckInterval.Duration < 0 {
+		cc.add(false, "replica.check-interval", "must not be negative")
+	}
+	switch conf.Export.BundleFormat {
+	case "", BundleConcat, BundleTar, BundleDirectory:
+	default:
+		cc.add(false, "export.bundle-format", "unknown format '%s', expected %s, %s, or %s", conf.Export.BundleFormat, BundleConcat, BundleTar, BundleDirectory)
+	}
+
+	cc.folder("disk.cache-folder", conf.Disk.CacheFolder)
+	if conf.Disk.ControlFiles && conf.Disk.CacheFolder == "" {
+		cc.add(false, "disk.control-files", "requires disk.cache-folder to be set")
+	}
+	if conf.Disk.CheckConsistency && conf.Disk.CacheFolder == "" {
+		cc.add(false, "disk.check-consistency", "requires disk.cache-folder to be set")
+	}
+	if conf.Disk.PruneOrphans {
+		switch {
+		case !conf.Disk.CheckConsistency:
+			cc.add(false, "disk.prune-orphans", "requires disk.check-consistency to be set")
+		case len(conf.Fetcher.UpstreamResponders) > 0 || len(conf.Cloud.AWSACM) > 0 || len(conf.Cloud.GCP) > 0:
+			cc.add(true, "disk.prune-orphans", "orphans won't be pruned since entries from upstream responders or cloud sources aren't known at startup")
+		}
+	}
+	// don't run the key command, it may have side effects or be slow
+	if len(conf.Disk.EncryptionKeyCommand) == 0 {
+		if _, err := diskEncryptionKey(conf); err != nil {
+			cc.add(false, "disk", "invalid encryption key: %s", err)
+		}
+	} else if _, err := exec.LookPath(conf.Disk.EncryptionKeyCommand[0]); err != nil {
+		cc.add(false, "disk.encryption-key-command", "%s", err)
+	}
+
+	if _, err := proxySource(conf); err != nil {
+		key := "fetcher.proxies"
+		if len(conf.Fetcher.Proxies) == 0 {
+			key = "fetcher.proxy-pac"
+		}
+		cc.add(false, key, "%s", err)
+	}
+	if len(conf.Fetcher.Proxies) > 0 && conf.Fetcher.ProxyPAC != "" {
+		cc.add(true, "fetcher.proxy-pac", "ignored because fetcher.proxies is set")
+	}
+	if proxy := conf.Fetcher.Quorum.Proxy; proxy != "" {
+		if u, err := url.Parse(proxy); err != nil || u.Host == "" {
+			cc.add(false, "fetcher.quorum.proxy", "'%s' is not a proxy URL such as http://127.0.0.1:8080", proxy)
+		}
+		if !conf.Fetcher.Quorum.Enabled {
+			cc.add(true, "fetcher.quorum.proxy", "has no effect unless fetcher.quorum.enabled is set")
+		}
+	}
+	cc.urls("fetcher.upstream-responders", conf.Fetcher.UpstreamResponders)
+	for prefix, replacement := range conf.Fetcher.ResponderRewrites {
+		if u, err := url.Parse(replacement); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			cc.add(false, "fetcher.responder-rewrites", "replacement for '%s', '%s', is not a http or https URL", prefix, replacement)
+		}
+	}
+	if conf.Fetcher.LocalAddr != "" {
+		if _, err := resolveLocalAddr(conf.Fetcher.LocalAddr); err != nil {
+			cc.add(false, "fetcher.local-addr", "%s", err)
+		}
+	}
+	for key, addrs := range map[string]map[string]string{
+		"fetcher.proxy-local-addrs":     conf.Fetcher.ProxyLocalAddrs,
+		"fetcher.responder-local-addrs": conf.Fetcher.ResponderLocalAddrs,
+	} {
+		for u, addr := range addrs {
+			if _, err := hostPort(u); err != nil {
+				cc.add(false, key, "invalid URL '%s': %s", u, err)
+			}
+			if _, err := resolveLocalAddr(addr); err != nil {
+				cc.add(false, key, "local address for '%s': %s", u, err)
+			}
+		}
+	}
+	for u, method := range conf.Fetcher.ResponderMethods {
+		if _, err := hostPort(u); err != nil {
+			cc.add(false, "fetcher.responder-methods", "invalid URL '%s': %s", u, err)
+		}
+		if _, err := stapledOCSP.ParseMethod(method); err != nil {
+			cc.add(false, "fetcher.responder-methods", "method for '%s': %s", u, err)
+		}
+	}
+	cc.urls("fetcher.nonce-responders", conf.Fetcher.NonceResponders)
+	if _, err := rootsConfig(conf.Fetcher.ProxyCA); err != nil {
+		cc.add(false, "fetcher.proxy-ca", "%s", err)
+	}
+	if _, err := rootsConfig(conf.Fetcher.ResponderCA); err != nil {
+		cc.add(false, "fetcher.responder-ca", "%s", err)
+	}
+	if _, err := parseResponderRoots(conf.Fetcher.ResponderCAs); err != nil {
+		cc.add(false, "fetcher.responder-cas", "%s", err)
+	}
+	if _, err := parseResponderPins(conf.Fetcher.ResponderPins); err != nil {
+		cc.add(false, "fetcher.responder-pins", "%s", err)
+	}
+	if conf.Fetcher.BackoffJitter < 0 {
+		cc.add(false, "fetcher.backoff-jitter", "must not be negative")
+	}
+	for name, bc := range conf.Fetcher.BackoffClasses {
+		key := fmt.Sprintf("fetcher.backoff-classes.%s", name)
+		if _, err := stapledOCSP.ParseFailureClass(name); err != nil {
+			cc.add(false, key, "%s", err)
+		}
+		if bc.Delay.Duration < 0 {
+			cc.add(false, key+".delay", "must not be negative")
+		}
+	}
+	if conf.Fetcher.RetryBudget < 0 || conf.Fetcher.RetryBudget > 1 {
+		cc.add(false, "fetcher.retry-budget", "must be between 0 and 1")
+	}
+	if conf.Fetcher.RetryBudgetMin < 0 {
+		cc.add(false, "fetcher.retry-budget-min", "must not be negative")
+	}
+	if conf.Fetcher.MaxConcurrentRefreshes < 0 {
+		cc.add(false, "fetcher.max-concurrent-refreshes", "must not be negative")
+	}
+	switch conf.Fetcher.ResponderCheck {
+	case "", "trust", "warn", "check":
+	default:
+		cc.add(false, "fetcher.responder-check", "unknown policy '%s', expected trust, warn, or check", conf.Fetcher.ResponderCheck)
+	}
+	if conf.Fetcher.ResponderCheckDepth < 0 {
+		cc.add(false, "fetcher.responder-check-depth", "must not be negative")
+	}
+	if conf.Fetcher.RampUpThreshold < 0 {
+		cc.add(false, "fetcher.ramp-up-threshold", "must not be negative")
+	}
+
+	for i, src := range conf.Cloud.AWSACM {
+		key := fmt.Sprintf("cloud.aws-acm[%d]", i)
+		if src.Region == "" {
+			cc.add(false, key, "region is required")
+		}
+		if (src.AccessKeyID == "") != (src.SecretAccessKey == "") {
+			cc.add(false, key, "access-key-id and secret-access-key must be set together")
+		}
+	}
+	for i, src := range conf.Cloud.GCP {
+		if src.Project == "" {
+			cc.add(false, fmt.Sprintf("cloud.gcp[%d]", i), "project is required")
+		}
+	}
+	if conf.Cloud.Interval.Duration < 0 {
+		cc.add(false, "cloud.interval", "must not be negative")
+	}
+
+	switch conf.StableBackings.Selection {
+	case "", "first", "freshest":
+	default:
+		cc.add(false, "stable-backings.selection", "unknown selection '%s', expected first or freshest", conf.StableBackings.Selection)
+	}
+	if conf.StableBackings.MissMemo.Duration < 0 {
+		cc.add(false, "stable-backings.miss-memo", "must not be negative")
+	}
+	wb := conf.StableBackings.WriteBehind
+	if wb.QueueSize < 0 {
+		cc.add(false, "stable-backings.write-behind.queue-size", "must not be negative")
+	}
+	if wb.BatchSize < 0 {
+		cc.add(false, "stable-backings.write-behind.batch-size", "must not be negative")
+	}
+	if wb.Interval.Duration < 0 {
+		cc.add(false, "stable-backings.write-behind.interval", "must not be negative")
+	}
+	if wb.MaxAttempts < 0 {
+		cc.add(false, "stable-backings.write-behind.max-attempts", "must not be negative")
+	}
+	for i, eb := range conf.StableBackings.External {
+		key := fmt.Sprintf("stable-backings.external[%d]", i)
+		switch {
+		case eb.Plugin == "" && len(eb.Command) == 0:
+			cc.add(false, key, "one of plugin or command must be set")
+		case eb.Plugin != "" && len(eb.Command) > 0:
+			cc.add(false, key, "only one of plugin or command can be set")
+		case eb.Plugin != "":
+			if _, err := os.Stat(eb.Plugin); err != nil {
+				cc.add(false, key+".plugin", "%s", err)
+			}
+			if eb.Timeout.Duration != 0 {
+				cc.add(true, key+".timeout", "ignored, it is only used for commands")
+			}
+		default:
+			if _, err := exec.LookPath(eb.Command[0]); err != nil {
+				cc.add(false, key+".command", "%s", err)
+			}
+			if len(eb.Options) > 0 {
+				cc.add(true, key+".options", "ignored, options are only passed to plugins")
+			}
+		}
+		if eb.Timeout.Duration < 0 {
+			cc.add(false, key+".timeout", "must not be negative")
+		}
+	}
+	s3 := conf.StableBackings.S3
+	if s3.Bucket == "" && (s3.Prefix != "" || s3.Region != "" || s3.Endpoint != "" || s3.AccessKeyID != "") {
+		cc.add(true, "stable-backings.s3", "settings have no effect unless bucket is set")
+	}
+	if s3.Endpoint != "" {
+		if u, err := url.Parse(s3.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			cc.add(false, "stable-backings.s3.endpoint", "must be a http or https URL")
+		}
+	}
+	if (s3.AccessKeyID == "") != (s3.SecretAccessKey == "") {
+		cc.add(false, "stable-backings.s3", "access-key-id and secret-access-key must both be set")
+	}
+	if s3.Timeout.Duration < 0 {
+		cc.add(false, "stable-backings.s3.timeout", "must not be negative")
+	}
+	if s3.ConsistencyWindow.Duration < 0 {
+		cc.add(false, "stable-backings.s3.consistency-window", "must not be negative")
+	}
+	if wb.Enabled && conf.Disk.CacheFolder == "" && s3.Bucket == "" && len(conf.StableBackings.External) == 0 {
+		cc.add(true, "stable-backings.write-behind.enabled", "there are no stable backings to write to, disk.cache-folder isn't set")
+	}
+	if conf.Syslog.StatsInterval.Duration < 0 {
+		cc.add(false, "syslog.stats-interval", "must not be negative")
+	}
+
+	if conf.GameDay.Interval.Duration < 0 {
+		cc.add(false, "game-day.interval", "must not be negative")
+	}
+	if conf.GameDay.Sample < 0 {
+		cc.add(false, "game-day.sample", "must not be negative")
+	}
+	if conf.GameDay.Timeout.Duration < 0 {
+		cc.add(false, "game-day.timeout", "must not be negative")
+	}
+	if conf.GameDay.Interval.Duration > 0 && len(conf.Notifications.Notifiers) == 0 {
+		cc.add(true, "game-day.interval", "broken fallbacks are only logged, no notifiers are configured")
+	}
+
+	for i, def := range conf.Notifications.Notifiers {
+		if _, err := notifierTarget(i, def, http.DefaultClient); err != nil {
+			cc.add(false, fmt.Sprintf("notifications.notifiers[%d]", i), "%s", err)
+		}
+	}
+
+	if len(conf.SupportedHashes) == 0 {
+		cc.add(false, "supported-hashes", "at least one supported hash must be configured")
+	} else if sha1Disabled(conf) {
+		key := "disable-sha1"
+		if conf.ApprovedHashesOnly {
+			key = "approved-hashes-only"
+		}
+		if len(withoutSHA1(conf.SupportedHashes)) == 0 {
+			cc.add(false, key, "SHA-1 is the only supported hash")
+		} else if len(withoutSHA1(conf.SupportedHashes)) != len(conf.SupportedHashes) {
+			cc.add(true, "supported-hashes.sha1", "ignored because %s is set", key)
+		}
+	}
+	config.SortProblems(cc.problems)
+	return cc.problems
+}
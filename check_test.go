@@ -0,0 +1,71 @@
+package stapled
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "stapled-check")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	notCert := filepath.Join(tmpDir, "not-a-cert")
+	if err = ioutil.WriteFile(notCert, []byte("hello"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	src := fmt.Sprintf(`definitions:
+  cert-watch-folder: %s
+  certificates:
+    - certificate: %s
+http:
+  addr: 0.0.0.0
+admin:
+  addr: 127.0.0.1:7777
+fetcher:
+  upstream-responders:
+    - ftp://example.com
+stable-backings:
+  selection: newest
+notifications:
+  notifiers:
+    - type: slack
+supported-hashes:
+  sha256: true
+`, tmpDir, notCert)
+	problems := CheckConfig([]byte(src))
+	expected := map[string]int{
+		"definitions.certificates[0].certificate": 4,
+		"http.addr":                      6,
+		"fetcher.upstream-responders[0]": 11,
+		"stable-backings.selection":      13,
+		"notifications.notifiers[0]":     16,
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+	for _, p := range problems {
+		if p.Warning {
+			t.Fatalf("Unexpected warning: %s", p)
+		}
+		if line, present := expected[p.Key]; !present || line != p.Line {
+			t.Fatalf("Unexpected problem on line %d: %s", p.Line, p)
+		}
+	}
+
+	if problems = CheckConfig([]byte("http:\n  addr: 127.0.0.1:8080\nsupported-hashes:\n  sha1: true\n")); len(problems) != 0 {
+		t.Fatalf("Expected no problems, got %v", problems)
+	}
+
+	// a section that can't be decoded doesn't stop the others from
+	// being checked
+	problems = CheckConfig([]byte("http:\n  addr: 0.0.0.0\nfetcher:\n  timeout: 10 seconds\nsupported-hashes:\n  sha1: true\n"))
+	if len(problems) != 2 || problems[0].Key != "http.addr" || problems[1].Key != "fetcher.timeout" {
+		t.Fatalf("Expected problems with http.addr and fetcher.timeout, got %v", problems)
+	}
+}
@@ -0,0 +1,275 @@
+package stapled
+
+import (
+	"container/list"
+	"errors"
+	"hash/maphash"
+	"math"
+	"math/big"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientInventory records which clients request responses for each
+// serial, so that the frontends that depend on stapled for a
+// certificate can be found before its entry is removed. Distinct
+// clients and User-Agents are estimated with a fixed size sketch, and
+// only the clients which sent the most requests are kept exactly
+type ClientInventory struct {
+	Enabled bool
+	// MaxSerials is how many serials are tracked, the least recently
+	// requested serial is forgotten once there are more
+	MaxSerials int
+	// MaxClients is how many clients are kept for each serial
+	MaxClients int
+}
+
+const (
+	defaultInventorySerials = 10000
+	defaultInventoryClients = 20
+)
+
+// WithClientInventory configures the client inventory served on the
+// admin listener at /clients
+func WithClientInventory(ci ClientInventory) Option {
+	return func(s *Server) error {
+		if ci.MaxSerials < 0 || ci.MaxClients < 0 {
+			return errors.New("client inventory limits must not be negative")
+		}
+		if !ci.Enabled {
+			return nil
+		}
+		if ci.MaxSerials == 0 {
+			ci.MaxSerials = defaultInventorySerials
+		}
+		if ci.MaxClients == 0 {
+			ci.MaxClients = defaultInventoryClients
+		}
+		s.clients = newClientInventory(ci.MaxSerials, ci.MaxClients)
+		return nil
+	}
+}
+
+// hllPrecision is the number of bits of each hash used to pick a
+// hyperLogLog register, giving a standard error of about 6.5%
+const hllPrecision = 8
+
+// hyperLogLog estimates the number of distinct values added to it
+type hyperLogLog [1 << hllPrecision]uint8
+
+func (h *hyperLogLog) add(x uint64) {
+	i := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h[i] {
+		h[i] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	m := float64(len(h))
+	sum, zeros := 0.0, 0
+	for _, r := range h {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// clientCount is a client which requested responses for a serial.
+// Once a serial has more clients than are kept the client with the
+// fewest requests is replaced, and its count inherited, so Requests
+// may be overcounted by up to Overcount
+type clientCount struct {
+	Addr      string    `json:"addr"`
+	UserAgent string    `json:"userAgent"`
+	Requests  uint64    `json:"requests"`
+	Overcount uint64    `json:"overcount,omitempty"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// serialClients are the clients which requested responses for a serial
+type serialClients struct {
+	serial    string
+	requests  uint64
+	firstSeen time.Time
+	lastSeen  time.Time
+	addrs     hyperLogLog
+	agents    hyperLogLog
+	clients   []*clientCount
+}
+
+// clientInventory tracks the clients of up to maxSerials serials,
+// forgetting the least recently requested serial first
+type clientInventory struct {
+	maxSerials int
+	maxClients int
+	seed       maphash.Seed
+
+	mu      sync.Mutex
+	serials map[string]*list.Element
+	lru     *list.List // of *serialClients, most recently requested first
+}
+
+func newClientInventory(maxSerials, maxClients int) *clientInventory {
+	return &clientInventory{
+		maxSerials: maxSerials,
+		maxClients: maxClients,
+		seed:       maphash.MakeSeed(),
+		serials:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (ci *clientInventory) hash(s string) uint64 {
+	var h maphash.Hash
+	h.SetSeed(ci.seed)
+	h.WriteString(s)
+	return h.Sum64()
+}
+
+// record records a request from addr with userAgent for serial
+func (ci *clientInventory) record(serial *big.Int, addr, userAgent string, now time.Time) {
+	if ci == nil {
+		return
+	}
+	addrHash, agentHash := ci.hash(addr), ci.hash(userAgent)
+	key := serial.Text(16)
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	var sc *serialClients
+	if elem, present := ci.serials[key]; present {
+		ci.lru.MoveToFront(elem)
+		sc = elem.Value.(*serialClients)
+	} else {
+		sc = &serialClients{serial: key, firstSeen: now}
+		ci.serials[key] = ci.lru.PushFront(sc)
+		if ci.lru.Len() > ci.maxSerials {
+			oldest := ci.lru.Back()
+			ci.lru.Remove(oldest)
+			delete(ci.serials, oldest.Value.(*serialClients).serial)
+		}
+	}
+	sc.requests++
+	sc.lastSeen = now
+	sc.addrs.add(addrHash)
+	sc.agents.add(agentHash)
+	var min *clientCount
+	for _, cc := range sc.clients {
+		if cc.Addr == addr && cc.UserAgent == userAgent {
+			cc.Requests++
+			cc.LastSeen = now
+			return
+		}
+		if min == nil || cc.Requests < min.Requests {
+			min = cc
+		}
+	}
+	if len(sc.clients) < ci.maxClients {
+		sc.clients = append(sc.clients, &clientCount{Addr: addr, UserAgent: userAgent, Requests: 1, LastSeen: now})
+		return
+	}
+	*min = clientCount{Addr: addr, UserAgent: userAgent, Requests: min.Requests + 1, Overcount: min.Requests, LastSeen: now}
+}
+
+// serialSummary summarizes the clients of a serial
+type serialSummary struct {
+	Serial             string    `json:"serial"`
+	Requests           uint64    `json:"requests"`
+	DistinctAddrs      uint64    `json:"distinctAddrs"`      // estimated
+	DistinctUserAgents uint64    `json:"distinctUserAgents"` // estimated
+	FirstSeen          time.Time `json:"firstSeen"`
+	LastSeen           time.Time `json:"lastSeen"`
+}
+
+func (sc *serialClients) summary() serialSummary {
+	return serialSummary{
+		Serial:             sc.serial,
+		Requests:           sc.requests,
+		DistinctAddrs:      sc.addrs.estimate(),
+		DistinctUserAgents: sc.agents.estimate(),
+		FirstSeen:          sc.firstSeen,
+		LastSeen:           sc.lastSeen,
+	}
+}
+
+// serialReport is the body of /clients/<hex serial>
+type serialReport struct {
+	serialSummary
+	Clients []clientCount `json:"clients"` // most requests first
+}
+
+// summaries returns the summary of every tracked serial, sorted by
+// serial
+func (ci *clientInventory) summaries() []serialSummary {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	summaries := make([]serialSummary, 0, ci.lru.Len())
+	for elem := ci.lru.Front(); elem != nil; elem = elem.Next() {
+		summaries = append(summaries, elem.Value.(*serialClients).summary())
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Serial < summaries[j].Serial })
+	return summaries
+}
+
+// report returns the clients of serial, a hex serial number
+func (ci *clientInventory) report(serial string) (serialReport, bool) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	elem, present := ci.serials[serial]
+	if !present {
+		return serialReport{}, false
+	}
+	sc := elem.Value.(*serialClients)
+	report := serialReport{serialSummary: sc.summary(), Clients: make([]clientCount, len(sc.clients))}
+	for i, cc := range sc.clients {
+		report.Clients[i] = *cc
+	}
+	sort.Slice(report.Clients, func(i, j int) bool {
+		if report.Clients[i].Requests != report.Clients[j].Requests {
+			return report.Clients[i].Requests > report.Clients[j].Requests
+		}
+		return report.Clients[i].Addr < report.Clients[j].Addr
+	})
+	return report, true
+}
+
+// clientsHandler serves the serials clients have requested responses
+// for at /clients, and the clients of a serial at /clients/<hex serial>
+func (s *Server) clientsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.clients == nil {
+		http.Error(w, "client inventory isn't enabled", http.StatusNotImplemented)
+		return
+	}
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/clients"), "/")
+	if path == "" {
+		s.writeJSON(w, r, s.clients.summaries())
+		return
+	}
+	serial, ok := new(big.Int).SetString(path, 16)
+	if !ok {
+		http.Error(w, "invalid serial", http.StatusBadRequest)
+		return
+	}
+	report, present := s.clients.report(serial.Text(16))
+	if !present {
+		http.Error(w, "no requests have been seen for serial", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, r, report)
+}
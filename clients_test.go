@@ -0,0 +1,85 @@
+package stapled
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+func TestClientInventory(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	if err := WithClientInventory(ClientInventory{Enabled: true, MaxClients: 2})(tf.s); err != nil {
+		t.Fatalf("WithClientInventory failed: %s", err)
+	}
+
+	for _, ua := range []string{"nginx", "nginx", "haproxy"} {
+		tf.get(http.Header{"User-Agent": []string{ua}})
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		tf.s.clientsHandler(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	w := get("/clients")
+	var summaries []serialSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summaries); err != nil {
+		t.Fatalf("Failed to parse /clients: %s", err)
+	}
+	if len(summaries) != 1 || summaries[0].Serial != "539" || summaries[0].Requests != 3 || summaries[0].DistinctAddrs != 1 || summaries[0].DistinctUserAgents != 2 {
+		t.Fatalf("Unexpected serial summaries: %+v", summaries)
+	}
+	w = get("/clients/0539")
+	var report serialReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to parse /clients/0539: %s", err)
+	}
+	if len(report.Clients) != 2 || report.Clients[0].UserAgent != "nginx" || report.Clients[0].Requests != 2 || report.Clients[0].Addr != "192.0.2.1" {
+		t.Fatalf("Unexpected clients: %+v", report.Clients)
+	}
+	if w = get("/clients/1"); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a serial without requests, got %d", w.Code)
+	}
+	if w = get("/clients/zz"); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a invalid serial, got %d", w.Code)
+	}
+
+	// the client with the fewest requests is replaced once there are
+	// too many, inheriting its count
+	now := time.Now()
+	tf.s.clients.record(big.NewInt(1337), "192.0.2.2", "curl", now)
+	report, _ = tf.s.clients.report("539")
+	if len(report.Clients) != 2 || report.Clients[1].UserAgent != "curl" || report.Clients[1].Requests != 2 || report.Clients[1].Overcount != 1 {
+		t.Fatalf("Expected the least active client to be replaced, got %+v", report.Clients)
+	}
+
+	// the least recently requested serial is forgotten
+	ci := newClientInventory(2, 1)
+	for i := int64(1); i <= 3; i++ {
+		ci.record(big.NewInt(i), "192.0.2.1", "nginx", now)
+	}
+	if _, present := ci.report("1"); present || len(ci.summaries()) != 2 {
+		t.Fatal("Expected the least recently requested serial to be forgotten")
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		var h hyperLogLog
+		for i := 0; i < n; i++ {
+			// a fixed hash so the estimates don't change between runs
+			sum := common.Sum256([]byte(fmt.Sprintf("client-%d", i)))
+			h.add(binary.BigEndian.Uint64(sum[:8]))
+		}
+		if e := float64(h.estimate()); e < float64(n)*0.8 || e > float64(n)*1.2 {
+			t.Fatalf("Estimate of %d distinct values is too far off: %f", n, e)
+		}
+	}
+}
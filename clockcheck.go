@@ -0,0 +1,188 @@
+package stapled
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClockCheck compares the local clock with the Date headers returned
+// by responders, since every response is verified against the local
+// clock a clock that has drifted makes valid responses look expired
+// or not yet valid
+type ClockCheck struct {
+	// URLs are sent HEAD requests, if empty the responders of the
+	// entries are used
+	URLs      []string
+	Threshold time.Duration // offsets larger than this are logged as critical
+	Interval  time.Duration // how often to check, one hour by default
+	// Strict fails readiness while the offset is above Threshold
+	Strict bool
+}
+
+// clockCheckMaxURLs limits how many responders are asked for the time
+// when the responders of the entries are used
+const clockCheckMaxURLs = 5
+
+// clockOffset is the result of the last clock check
+type clockOffset struct {
+	Offset    time.Duration `json:"offset"` // responder time minus local time, the median if there are several
+	Samples   int           `json:"samples"`
+	Checked   time.Time     `json:"checked"`
+	Exceeded  bool          `json:"exceeded"` // the offset is above the threshold
+	LastError string        `json:"lastError,omitempty"`
+}
+
+// clockState holds the result of the clock checks
+type clockState struct {
+	mu     sync.Mutex
+	offset clockOffset
+}
+
+// WithClockCheck periodically compares the local clock with the Date
+// headers returned by responders
+func WithClockCheck(cc ClockCheck) Option {
+	return func(s *Server) error {
+		if cc.Threshold <= 0 {
+			return errors.New("clock check threshold must be positive")
+		}
+		if cc.Interval == 0 {
+			cc.Interval = time.Hour
+		}
+		s.clockCheck = &cc
+		s.clockState = &clockState{}
+		return nil
+	}
+}
+
+// clockCheckURLs returns the URLs to ask for the time
+func (s *Server) clockCheckURLs() []string {
+	if len(s.clockCheck.URLs) > 0 {
+		return s.clockCheck.URLs
+	}
+	seen := map[string]bool{}
+	urls := []string{}
+	for _, u := range s.upstreamResponders {
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	for _, info := range s.c.Entries() {
+		for _, u := range info.Responders {
+			if !seen[u] {
+				seen[u] = true
+				urls = append(urls, u)
+			}
+		}
+	}
+	sort.Strings(urls)
+	if len(urls) > clockCheckMaxURLs {
+		urls = urls[:clockCheckMaxURLs]
+	}
+	return urls
+}
+
+// sampleClock returns the offset between the Date header returned by
+// u and the local clock, corrected for half the round trip
+func (s *Server) sampleClock(client *http.Client, u string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequest("HEAD", u, nil)
+	if err != nil {
+		return 0, err
+	}
+	sent := s.clk.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	received := s.clk.Now()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.New("response has no valid Date header")
+	}
+	// Date has a resolution of a second so it is compared with the
+	// middle of that second
+	return date.Add(time.Second / 2).Sub(sent.Add(received.Sub(sent) / 2)), nil
+}
+
+// checkClock samples the offset from each URL and records the median
+func (s *Server) checkClock() {
+	client := &http.Client{}
+	if s.transport != nil {
+		client.Transport = s.transport
+	}
+	var offsets []time.Duration
+	var lastErr error
+	for _, u := range s.clockCheckURLs() {
+		offset, err := s.sampleClock(client, u)
+		if err != nil {
+			s.log.Warning("[clock] Failed to get the time from '%s': %s", u, err)
+			lastErr = err
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	result := clockOffset{Checked: s.clk.Now(), Samples: len(offsets)}
+	if lastErr != nil {
+		result.LastError = lastErr.Error()
+	}
+	if len(offsets) == 0 {
+		// keep the previous offset rather than assuming the clock is
+		// fine because the responders are unreachable
+		s.clockState.mu.Lock()
+		result.Offset, result.Exceeded = s.clockState.offset.Offset, s.clockState.offset.Exceeded
+		s.clockState.offset = result
+		s.clockState.mu.Unlock()
+		return
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	result.Offset = offsets[len(offsets)/2]
+	result.Exceeded = abs(result.Offset) > s.clockCheck.Threshold
+	if result.Exceeded {
+		s.log.Crit("[clock] Local clock is %s from the time reported by responders, more than the %s threshold, responses may fail verification", result.Offset, s.clockCheck.Threshold)
+	} else {
+		s.log.Info("[clock] Local clock is %s from the time reported by responders", result.Offset)
+	}
+	s.clockState.mu.Lock()
+	s.clockState.offset = result
+	s.clockState.mu.Unlock()
+}
+
+// clockReport returns the result of the last clock check, or nil if
+// the clock isn't checked
+func (s *Server) clockReport() *clockOffset {
+	if s.clockState == nil {
+		return nil
+	}
+	s.clockState.mu.Lock()
+	defer s.clockState.mu.Unlock()
+	offset := s.clockState.offset
+	return &offset
+}
+
+func (s *Server) watchClock() {
+	s.checkClock()
+	ticker := time.NewTicker(s.clockCheck.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.checkClock()
+		}
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
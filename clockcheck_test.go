@@ -0,0 +1,44 @@
+package stapled
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClockCheck(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	skew := 10 * time.Minute
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", tf.fc.Now().Add(skew).UTC().Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+	if err := WithClockCheck(ClockCheck{URLs: []string{srv.URL}, Threshold: time.Minute, Strict: true})(tf.s); err != nil {
+		t.Fatalf("WithClockCheck failed: %s", err)
+	}
+
+	tf.s.checkClock()
+	report := tf.s.clockReport()
+	if !report.Exceeded || report.Samples != 1 || abs(report.Offset-skew) > time.Second {
+		t.Fatalf("Unexpected clock report: %+v", report)
+	}
+	w := httptest.NewRecorder()
+	tf.s.readyHandler(w, httptest.NewRequest("GET", "/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 from /ready with a skewed clock, got %d", w.Code)
+	}
+
+	// a unreachable responder doesn't clear a known offset
+	srv.Close()
+	tf.s.checkClock()
+	if report = tf.s.clockReport(); !report.Exceeded || report.LastError == "" {
+		t.Fatalf("Unexpected clock report after the responder went away: %+v", report)
+	}
+
+	// the upstream responders and the entry responder, deduplicated
+	if urls := (&Server{clockCheck: &ClockCheck{}, c: tf.s.c, upstreamResponders: []string{"http://b", "http://a", "http://b"}}).clockCheckURLs(); len(urls) != 3 {
+		t.Fatalf("Unexpected responders used for the clock check: %v", urls)
+	}
+}
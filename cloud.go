@@ -0,0 +1,112 @@
+package stapled
+
+import (
+	"context"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/cloud"
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+// cloudSource is a cloud provider API that certificates are listed
+// from along with the options used to create entries for them
+type cloudSource struct {
+	source cloud.Source
+	opts   mcache.CertificateOptions
+	// entries added for the source and the SHA-256 fingerprints of
+	// their certificates, which change when a certificate is renewed
+	// in place under the same name
+	names map[string][32]byte
+}
+
+// WithCloudSource adds entries for the certificates listed by source,
+// which is periodically polled for added and removed certificates,
+// using opts. If opts doesn't contain a issuer the issuer in the chain
+// returned by the source is used, and if it doesn't contain any
+// responders the upstream responders are used
+func WithCloudSource(source cloud.Source, opts mcache.CertificateOptions) Option {
+	return func(s *Server) error {
+		s.cloudSources = append(s.cloudSources, &cloudSource{source, opts, make(map[string][32]byte)})
+		return nil
+	}
+}
+
+// WithCloudInterval sets how often the cloud sources are polled, the
+// default is 15 minutes
+func WithCloudInterval(interval time.Duration) Option {
+	return func(s *Server) error {
+		s.cloudInterval = interval
+		return nil
+	}
+}
+
+func (s *Server) checkCloudSource(cs *cloudSource) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	certs, err := cs.source.List(ctx)
+	if err != nil {
+		// keep the existing entries rather than removing everything
+		// when the API is unavailable
+		s.log.Err("[cloud] Failed to list certificates from '%s': %s", cs.source.Name(), err)
+		return
+	}
+	listed := make(map[string]struct{}, len(certs))
+	for _, cert := range certs {
+		listed[cert.Name] = struct{}{}
+		fingerprint := common.Sum256(cert.Certificate.Raw)
+		if previous, present := cs.names[cert.Name]; present {
+			if previous == fingerprint {
+				continue
+			}
+			s.log.Info("[cloud] Certificate for '%s' from '%s' has been renewed, replacing its entry", cert.Name, cs.source.Name())
+			if err := s.c.Remove(cert.Name); err != nil {
+				s.log.Err("[cloud] Failed to remove entry for '%s' from '%s': %s", cert.Name, cs.source.Name(), err)
+				continue
+			}
+			delete(cs.names, cert.Name)
+		}
+		opts := cs.opts
+		if opts.Issuer == nil {
+			opts.Issuer = cert.Issuer
+		}
+		if len(opts.Responders) == 0 {
+			opts.Responders = s.upstreamResponders
+		}
+		if err := s.c.AddCertificate(cert.Name, cert.Certificate, opts); err != nil {
+			s.log.Err("[cloud] Failed to add entry for '%s' from '%s': %s", cert.Name, cs.source.Name(), err)
+			continue
+		}
+		cs.names[cert.Name] = fingerprint
+	}
+	for name := range cs.names {
+		if _, present := listed[name]; present {
+			continue
+		}
+		if err := s.c.Remove(name); err != nil {
+			s.log.Err("[cloud] Failed to remove entry for '%s' from '%s': %s", name, cs.source.Name(), err)
+		}
+		delete(cs.names, name)
+	}
+}
+
+func (s *Server) checkCloudSources() {
+	for _, cs := range s.cloudSources {
+		s.checkCloudSource(cs)
+	}
+	s.checkFileDescriptors()
+}
+
+func (s *Server) watchCloudSources() {
+	s.checkCloudSources()
+	ticker := time.NewTicker(s.cloudInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.checkCloudSources()
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+// AWSCredentials are static credentials used to sign requests
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // only required for temporary credentials
+}
+
+// AWSCredentialsFromEnv reads credentials from the standard AWS
+// environment variables
+func AWSCredentialsFromEnv() AWSCredentials {
+	return AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(common.NewSHA256, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := common.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// uriEncode percent encodes every byte of s other than the unreserved
+// characters of RFC 3986, and '/' if slash is false, as Signature
+// Version 4 requires
+func uriEncode(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && slash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalURI returns the path of u encoded once, as S3 expects,
+// rather than the encoding Go would send
+func canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return uriEncode(u.Path, true)
+}
+
+// canonicalQuery returns the query of u sorted by key and value, with
+// both encoded by uriEncode
+func canonicalQuery(u *url.URL) string {
+	var params []string
+	for key, values := range u.Query() {
+		for _, value := range values {
+			params = append(params, uriEncode(key, false)+"="+uriEncode(value, false))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// SignV4 signs req, which has body, using AWS Signature Version 4.
+// Every header set on req is signed, along with Host. The path of req
+// is sent encoded the way it is signed, so that it matches however S3
+// encodes it
+func SignV4(req *http.Request, body []byte, creds AWSCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := canonicalURI(req.URL)
+	req.URL.RawPath = path
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID,
+		scope,
+		signedHeaders,
+		signature,
+	))
+}
+
+// ACM lists the issued certificates in a AWS Certificate Manager
+// region, which includes those attached to ELB load balancers and
+// CloudFront distributions. The credentials need the
+// acm:ListCertificates and acm:GetCertificate permissions
+type ACM struct {
+	Region      string
+	Credentials AWSCredentials
+	Endpoint    string // defaults to https://acm.<Region>.amazonaws.com
+	Client      *http.Client
+}
+
+// Name implements Source
+func (a *ACM) Name() string {
+	return fmt.Sprintf("aws-acm %s", a.Region)
+}
+
+// call makes a ACM API request for action
+func (a *ACM) call(ctx context.Context, action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://acm.%s.amazonaws.com", a.Region)
+	}
+	req, err := http.NewRequest("POST", endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "CertificateManager."+action)
+	SignV4(req, body, a.Credentials, a.Region, "acm", time.Now())
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if err = readJSON(resp, out); err != nil {
+		return fmt.Errorf("%s failed: %s", action, err)
+	}
+	return nil
+}
+
+type acmListRequest struct {
+	CertificateStatuses []string `json:",omitempty"`
+	MaxItems            int      `json:",omitempty"`
+	NextToken           string   `json:",omitempty"`
+}
+
+type acmListResponse struct {
+	CertificateSummaryList []struct {
+		CertificateArn string
+	}
+	NextToken string
+}
+
+type acmGetResponse struct {
+	Certificate      string
+	CertificateChain string
+}
+
+// List implements Source
+func (a *ACM) List(ctx context.Context) ([]Certificate, error) {
+	var arns []string
+	list := acmListRequest{CertificateStatuses: []string{"ISSUED"}, MaxItems: 100}
+	for {
+		var resp acmListResponse
+		if err := a.call(ctx, "ListCertificates", list, &resp); err != nil {
+			return nil, err
+		}
+		for _, summary := range resp.CertificateSummaryList {
+			arns = append(arns, summary.CertificateArn)
+		}
+		if resp.NextToken == "" {
+			break
+		}
+		list.NextToken = resp.NextToken
+	}
+	certs := make([]Certificate, 0, len(arns))
+	for _, arn := range arns {
+		var resp acmGetResponse
+		if err := a.call(ctx, "GetCertificate", map[string]string{"CertificateArn": arn}, &resp); err != nil {
+			return nil, fmt.Errorf("'%s': %s", arn, err)
+		}
+		cert, issuer, err := parseChain([]byte(resp.Certificate + "\n" + resp.CertificateChain))
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %s", arn, err)
+		}
+		// ARNs end with certificate/<id>
+		certs = append(certs, Certificate{
+			Name:        "acm-" + arn[strings.LastIndex(arn, "/")+1:],
+			Certificate: cert,
+			Issuer:      issuer,
+		})
+	}
+	return certs, nil
+}
@@ -0,0 +1,82 @@
+// Package cloud lists the certificates managed by cloud provider APIs,
+// such as those attached to load balancers, so that staples can be
+// maintained for them. Only read-only API calls are made.
+package cloud
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Certificate is a certificate listed by a Source
+type Certificate struct {
+	// Name identifies the certificate, it is unique across every
+	// source and safe to use as a filename
+	Name        string
+	Certificate *x509.Certificate
+	// Issuer is the second certificate in the chain the API returned
+	// for the certificate, nil if there wasn't one
+	Issuer *x509.Certificate
+}
+
+// Source lists the certificates managed by a cloud provider
+type Source interface {
+	// Name describes the source in log messages
+	Name() string
+	// List returns every certificate currently managed by the
+	// provider, if it fails none are returned
+	List(ctx context.Context) ([]Certificate, error)
+}
+
+// maxResponseSize is the largest API response that will be read
+const maxResponseSize = 8 << 20
+
+// parseChain parses a PEM chain, leaf first
+func parseChain(contents []byte) (*x509.Certificate, *x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, cert)
+	}
+	switch len(chain) {
+	case 0:
+		return nil, nil, errors.New("no PEM certificates found")
+	case 1:
+		return chain[0], nil, nil
+	}
+	return chain[0], chain[1], nil
+}
+
+// readJSON decodes the JSON body of a successful response into v
+func readJSON(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(body) > 256 {
+			body = body[:256]
+		}
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,174 @@
+package cloud
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignV4(t *testing.T) {
+	// get-vanilla from the AWS Signature Version 4 test suite
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest failed: %s", err)
+	}
+	creds := AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	SignV4(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Fatalf("Unexpected Authorization header: %s", auth)
+	}
+}
+
+func TestSignV4Encoding(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://bucket.s3.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest failed: %s", err)
+	}
+	// S3 encodes each segment of the key once, leaving only '/' and
+	// the unreserved characters as they are
+	req.URL.Path = "/stapled/a:b+c=d@e f~.resp"
+	req.URL.RawQuery = "prefix=a b&list-type=2"
+	expectedPath := "/stapled/a%3Ab%2Bc%3Dd%40e%20f~.resp"
+	if path := canonicalURI(req.URL); path != expectedPath {
+		t.Fatalf("Unexpected canonical URI: wanted %s, got %s", expectedPath, path)
+	}
+	if query := canonicalQuery(req.URL); query != "list-type=2&prefix=a%20b" {
+		t.Fatalf("Unexpected canonical query: %s", query)
+	}
+	SignV4(req, nil, AWSCredentials{AccessKeyID: "a", SecretAccessKey: "b"}, "us-east-1", "s3", time.Now())
+	if path := req.URL.EscapedPath(); path != expectedPath {
+		t.Fatalf("Request path isn't sent the way it was signed: wanted %s, got %s", expectedPath, path)
+	}
+}
+
+// testChain returns a PEM leaf and issuer
+func testChain(t *testing.T, serial int64) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	issuerTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "issuer"},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	issuerDER, err := x509.CreateCertificate(rand.Reader, issuerTemplate, issuerTemplate, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		OCSPServer:   []string{"http://ocsp.example.com"},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, issuerTemplate, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	encode := func(der []byte) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+	return encode(leafDER), encode(issuerDER)
+}
+
+func TestACMList(t *testing.T) {
+	leaf, issuer := testChain(t, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/") {
+			t.Errorf("Request wasn't signed: %q", r.Header.Get("Authorization"))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.Header.Get("X-Amz-Target") {
+		case "CertificateManager.ListCertificates":
+			// two pages
+			if body["NextToken"] == nil {
+				w.Write([]byte(`{"CertificateSummaryList":[{"CertificateArn":"arn:aws:acm:us-east-1:1:certificate/a"}],"NextToken":"next"}`))
+				return
+			}
+			w.Write([]byte(`{"CertificateSummaryList":[{"CertificateArn":"arn:aws:acm:us-east-1:1:certificate/b"}]}`))
+		case "CertificateManager.GetCertificate":
+			json.NewEncoder(w).Encode(acmGetResponse{Certificate: leaf, CertificateChain: issuer})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	acm := &ACM{Region: "us-east-1", Credentials: AWSCredentials{AccessKeyID: "id", SecretAccessKey: "secret"}, Endpoint: srv.URL}
+	certs, err := acm.List(context.Background())
+	if err != nil {
+		t.Fatalf("List failed: %s", err)
+	}
+	if len(certs) != 2 || certs[0].Name != "acm-a" || certs[1].Name != "acm-b" {
+		t.Fatalf("Unexpected certificates: %+v", certs)
+	}
+	if certs[0].Certificate.SerialNumber.Int64() != 10 || certs[0].Issuer == nil || certs[0].Issuer.Subject.CommonName != "issuer" {
+		t.Fatalf("Unexpected certificate: %+v", certs[0])
+	}
+}
+
+func TestGCPList(t *testing.T) {
+	leaf, issuer := testChain(t, 20)
+	tokens := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		tokens++
+		w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+	})
+	mux.HandleFunc("/projects/proj/aggregated/sslCertificates", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		list := gcpAggregatedList{Items: map[string]struct {
+			SSLCertificates []gcpSSLCertificate `json:"sslCertificates"`
+		}{}}
+		if r.URL.Query().Get("pageToken") == "" {
+			list.Items["global"] = struct {
+				SSLCertificates []gcpSSLCertificate `json:"sslCertificates"`
+			}{[]gcpSSLCertificate{{"www", leaf + issuer}, {"pending", ""}}}
+			list.NextPageToken = "next"
+		} else {
+			list.Items["regions/us-central1"] = struct {
+				SSLCertificates []gcpSSLCertificate `json:"sslCertificates"`
+			}{[]gcpSSLCertificate{{"www", leaf}}}
+		}
+		json.NewEncoder(w).Encode(list)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := &GCP{Project: "proj", Endpoint: srv.URL, TokenURL: srv.URL + "/token"}
+	certs, err := g.List(context.Background())
+	if err != nil {
+		t.Fatalf("List failed: %s", err)
+	}
+	if len(certs) != 2 || certs[0].Name != "gcp-proj-www" || certs[1].Name != "gcp-proj-us-central1-www" {
+		t.Fatalf("Unexpected certificates: %+v", certs)
+	}
+	if certs[0].Issuer == nil || certs[1].Issuer != nil {
+		t.Fatal("Unexpected issuers")
+	}
+	if tokens != 1 {
+		t.Fatalf("Expected the access token to be reused, requested %d", tokens)
+	}
+}
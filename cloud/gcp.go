@@ -0,0 +1,154 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GCPMetadataTokenURL is the metadata server endpoint which returns
+// access tokens for the default service account of a instance
+const GCPMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCP lists the SSL certificates, both self-managed and
+// Google-managed, used by the load balancers in a Google Cloud
+// project. Access tokens are requested from the metadata server, so
+// the instance service account needs the compute.sslCertificates.list
+// permission
+type GCP struct {
+	Project  string
+	Endpoint string // defaults to https://compute.googleapis.com/compute/v1
+	TokenURL string // defaults to GCPMetadataTokenURL
+	Client   *http.Client
+
+	token   string
+	expires time.Time
+	mu      sync.Mutex
+}
+
+// Name implements Source
+func (g *GCP) Name() string {
+	return fmt.Sprintf("gcp %s", g.Project)
+}
+
+func (g *GCP) client() *http.Client {
+	if g.Client == nil {
+		return http.DefaultClient
+	}
+	return g.Client
+}
+
+type gcpToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// accessToken returns a cached access token or requests a new one if
+// it has expired or is about to
+func (g *GCP) accessToken(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Now().Before(g.expires) {
+		return g.token, nil
+	}
+	tokenURL := g.TokenURL
+	if tokenURL == "" {
+		tokenURL = GCPMetadataTokenURL
+	}
+	req, err := http.NewRequest("GET", tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := g.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	var token gcpToken
+	if err = readJSON(resp, &token); err != nil {
+		return "", fmt.Errorf("failed to get access token: %s", err)
+	}
+	g.token = token.AccessToken
+	g.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return g.token, nil
+}
+
+type gcpSSLCertificate struct {
+	Name        string `json:"name"`
+	Certificate string `json:"certificate"`
+}
+
+type gcpAggregatedList struct {
+	Items map[string]struct {
+		SSLCertificates []gcpSSLCertificate `json:"sslCertificates"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// List implements Source
+func (g *GCP) List(ctx context.Context) ([]Certificate, error) {
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = "https://compute.googleapis.com/compute/v1"
+	}
+	listURL := fmt.Sprintf("%s/projects/%s/aggregated/sslCertificates", endpoint, url.PathEscape(g.Project))
+	var certs []Certificate
+	pageToken := ""
+	for {
+		token, err := g.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		u := listURL
+		if pageToken != "" {
+			u += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := g.client().Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		var list gcpAggregatedList
+		if err = readJSON(resp, &list); err != nil {
+			return nil, fmt.Errorf("failed to list SSL certificates: %s", err)
+		}
+		scopes := make([]string, 0, len(list.Items))
+		for scope := range list.Items {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
+		for _, scope := range scopes {
+			for _, sc := range list.Items[scope].SSLCertificates {
+				if sc.Certificate == "" {
+					// Google-managed certificates which haven't been
+					// provisioned yet
+					continue
+				}
+				cert, issuer, err := parseChain([]byte(sc.Certificate))
+				if err != nil {
+					return nil, fmt.Errorf("'%s': %s", sc.Name, err)
+				}
+				// names are only unique within a scope, either global
+				// or regions/<region>
+				name := fmt.Sprintf("gcp-%s-%s", g.Project, sc.Name)
+				if region := strings.TrimPrefix(scope, "regions/"); region != scope {
+					name = fmt.Sprintf("gcp-%s-%s-%s", g.Project, region, sc.Name)
+				}
+				certs = append(certs, Certificate{Name: name, Certificate: cert, Issuer: issuer})
+			}
+		}
+		if list.NextPageToken == "" {
+			return certs, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
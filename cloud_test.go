@@ -0,0 +1,94 @@
+package stapled
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/cloud"
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+type fakeSource struct {
+	certs []cloud.Certificate
+	err   error
+}
+
+func (fs *fakeSource) Name() string {
+	return "fake"
+}
+
+func (fs *fakeSource) List(ctx context.Context) ([]cloud.Certificate, error) {
+	return fs.certs, fs.err
+}
+
+func TestCloudSource(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	cert, err := x509.ParseCertificate(tf.certDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	src := &fakeSource{certs: []cloud.Certificate{{Name: "fake-leaf", Certificate: cert, Issuer: tf.issuer}}}
+	opt := WithCloudSource(src, mcache.CertificateOptions{Labels: map[string]string{"provider": "fake"}})
+	if err = opt(tf.s); err != nil {
+		t.Fatalf("WithCloudSource failed: %s", err)
+	}
+	tf.s.upstreamResponders = []string{tf.upstream.URL}
+
+	entry := func() (mcache.EntryInfo, bool) {
+		for _, info := range tf.s.c.Entries() {
+			if info.Name == "fake-leaf" {
+				return info, true
+			}
+		}
+		return mcache.EntryInfo{}, false
+	}
+	tf.s.checkCloudSources()
+	info, present := entry()
+	if !present {
+		t.Fatal("Entry wasn't added for listed certificate")
+	}
+	if info.Labels["provider"] != "fake" || info.ThisUpdate.IsZero() {
+		t.Fatalf("Unexpected entry for listed certificate: %+v", info)
+	}
+
+	// a certificate renewed in place under the same name replaces the
+	// entry
+	template := *cert
+	template.NotAfter = cert.NotAfter.Add(time.Hour * 24 * 90)
+	renewedDER, err := x509.CreateCertificate(rand.Reader, &template, tf.issuer, cert.PublicKey, tf.key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	renewed, err := x509.ParseCertificate(renewedDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	src.certs = []cloud.Certificate{{Name: "fake-leaf", Certificate: renewed, Issuer: tf.issuer}}
+	tf.s.checkCloudSources()
+	if info, present = entry(); !present || info.Fingerprint != common.Sum256(renewedDER) {
+		t.Fatalf("Entry wasn't replaced for renewed certificate: %+v", info)
+	}
+	if len(tf.s.c.Entries()) != 2 {
+		t.Fatalf("Expected the renewed entry to replace the old one, got %d entries", len(tf.s.c.Entries()))
+	}
+
+	// failing to list shouldn't remove anything
+	src.certs, src.err = nil, errors.New("broken")
+	tf.s.checkCloudSources()
+	if _, present = entry(); !present {
+		t.Fatal("Entry was removed when listing failed")
+	}
+
+	src.err = nil
+	tf.s.checkCloudSources()
+	if _, present = entry(); present {
+		t.Fatal("Entry wasn't removed when certificate was no longer listed")
+	}
+}
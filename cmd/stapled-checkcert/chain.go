@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+// readChain reads every certificate in a PEM file, the leaf should
+// be first and each certificate should be followed by its issuer
+func readChain(filename string) ([]*x509.Certificate, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d: %s", len(chain), err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return chain, nil
+}
+
+// chainRow is the status of a single certificate in a chain
+type chainRow struct {
+	subject string
+	serial  string
+	status  string
+	detail  string
+	problem bool
+}
+
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// checkChain fetches the status of every certificate in chain that
+// has OCSP responders, each certificate is checked using the next as
+// its issuer and the last using lastIssuer, which may be nil if it is
+// self-signed
+func checkChain(logger *log.Logger, client *http.Client, timeout time.Duration, chain []*x509.Certificate, lastIssuer *x509.Certificate) []chainRow {
+	rows := make([]chainRow, len(chain))
+	for i, cert := range chain {
+		row := &rows[i]
+		row.subject = cert.Subject.CommonName
+		if row.subject == "" {
+			row.subject = cert.Subject.String()
+		}
+		row.serial = fmt.Sprintf("%X", cert.SerialNumber)
+		issuer := lastIssuer
+		if i+1 < len(chain) {
+			issuer = chain[i+1]
+		}
+		switch {
+		case selfSigned(cert):
+			row.status, row.detail = "-", "self-signed, not checked"
+		case len(cert.OCSPServer) == 0:
+			row.status, row.detail = "-", "no OCSP URLs"
+		case issuer == nil:
+			row.status, row.detail, row.problem = "error", "issuer isn't in the chain, use -issuer", true
+		default:
+			resp, err := fetch(logger, client, timeout, cert.OCSPServer, cert, issuer)
+			if err != nil {
+				row.status, row.detail, row.problem = "error", err.Error(), true
+				break
+			}
+			row.status = statusName(resp.Status)
+			row.detail = fmt.Sprintf("next update in %s", resp.NextUpdate.Sub(time.Now()).Truncate(time.Minute))
+			if resp.Status == ocsp.Revoked {
+				row.detail = fmt.Sprintf("revoked at %s (reason %d)", resp.RevokedAt.UTC().Format(time.RFC3339), resp.RevocationReason)
+			}
+			row.problem = resp.Status != ocsp.Good
+		}
+	}
+	return rows
+}
+
+// printChain prints rows as a table and returns true if any of the
+// certificates aren't good
+func printChain(w io.Writer, rows []chainRow) bool {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEPTH\tSUBJECT\tSERIAL\tSTATUS\tDETAIL")
+	problems := false
+	for i, row := range rows {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", i, row.subject, row.serial, row.status, row.detail)
+		problems = problems || row.problem
+	}
+	tw.Flush()
+	return problems
+}
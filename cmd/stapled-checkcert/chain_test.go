@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+func TestCheckChain(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := ocsp.CreateResponse(root, root, ocsp.Response{
+			SerialNumber: big.NewInt(2),
+			Status:       ocsp.Revoked,
+			RevokedAt:    time.Now().Add(-time.Hour),
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, key)
+		if err != nil {
+			t.Errorf("ocsp.CreateResponse failed: %s", err)
+			return
+		}
+		w.Write(resp)
+	}))
+	defer srv.Close()
+
+	intermediateTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "intermediate"},
+		NotAfter:              time.Now().Add(time.Hour),
+		OCSPServer:            []string{srv.URL},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	intermediateDER, err := x509.CreateCertificate(rand.Reader, intermediateTemplate, root, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	intermediate, err := x509.ParseCertificate(intermediateDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, intermediate, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+
+	tf, err := ioutil.TempFile("", "chain")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile failed: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	for _, der := range [][]byte{leafDER, intermediateDER, rootDER} {
+		pem.Encode(tf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	tf.Close()
+	chain, err := readChain(tf.Name())
+	if err != nil {
+		t.Fatalf("readChain failed: %s", err)
+	}
+	if len(chain) != 3 {
+		t.Fatalf("Expected 3 certificates in chain, got %d", len(chain))
+	}
+
+	rows := checkChain(log.NewLogger("", "", 0, clock.Default()), http.DefaultClient, time.Second, chain, nil)
+	for i, expected := range []string{"-", "revoked", "-"} {
+		if rows[i].status != expected {
+			t.Fatalf("Expected status %s for certificate %d, got %s (%s)", expected, i, rows[i].status, rows[i].detail)
+		}
+	}
+	buf := new(bytes.Buffer)
+	if !printChain(buf, rows) {
+		t.Fatal("printChain didn't report the revoked intermediate")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("intermediate")) {
+		t.Fatalf("Table doesn't contain the intermediate: %s", buf)
+	}
+}
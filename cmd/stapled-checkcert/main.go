@@ -0,0 +1,291 @@
+// stapled-checkcert fetches the current OCSP status of a certificate
+// using the same fetcher as stapled. If a staple is provided it is
+// validated and compared with the live response, so that a staple
+// copied from a deployed server can be checked. Given a PEM chain it
+// checks the status of every certificate in the chain instead. With
+// -format junit or sarif the results are written in a format CI
+// systems can consume. The -proxy, -source-addr, -insecure-tls, and
+// -post flags make requests take the same network path as the daemon
+// when checking from a bastion host.
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled"
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/log"
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+	"github.com/rolandshoemaker/stapled/version"
+)
+
+// exit codes
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitProblem = 2 // the staple is invalid, outdated, or diverges
+)
+
+var statusNames = map[int]string{
+	ocsp.Good:    "good",
+	ocsp.Revoked: "revoked",
+	ocsp.Unknown: "unknown",
+}
+
+func statusName(status int) string {
+	if name, present := statusNames[status]; present {
+		return name
+	}
+	return fmt.Sprintf("status %d", status)
+}
+
+func describe(resp *ocsp.Response) string {
+	desc := fmt.Sprintf(
+		"%s, produced at %s, this update %s, next update %s",
+		statusName(resp.Status),
+		resp.ProducedAt.UTC().Format(time.RFC3339),
+		resp.ThisUpdate.UTC().Format(time.RFC3339),
+		resp.NextUpdate.UTC().Format(time.RFC3339),
+	)
+	if resp.Status == ocsp.Revoked {
+		desc += fmt.Sprintf(", revoked at %s (reason %d)", resp.RevokedAt.UTC().Format(time.RFC3339), resp.RevocationReason)
+	}
+	return desc
+}
+
+// compareStaple returns the problems with a staple given the live
+// response for the same certificate
+func compareStaple(staple, live *ocsp.Response, now time.Time) []string {
+	problems := []string{}
+	if now.After(staple.NextUpdate) {
+		problems = append(problems, fmt.Sprintf("staple expired %s ago", common.HumanDuration(now.Sub(staple.NextUpdate))))
+	}
+	if staple.Status != live.Status {
+		problems = append(problems, fmt.Sprintf("staple status is %s but live status is %s", statusName(staple.Status), statusName(live.Status)))
+	} else if staple.Status == ocsp.Revoked && !staple.RevokedAt.Equal(live.RevokedAt) {
+		problems = append(problems, "staple revocation time differs from live revocation time")
+	}
+	if staple.ThisUpdate.Before(live.ThisUpdate) {
+		problems = append(problems, fmt.Sprintf("staple is outdated, live response is %s newer", common.HumanDuration(live.ThisUpdate.Sub(staple.ThisUpdate))))
+	}
+	return problems
+}
+
+// describeDrift describes the difference between when a response
+// was fetched and its ProducedAt
+func describeDrift(drift time.Duration) string {
+	if drift < 0 {
+		return fmt.Sprintf("produced %s after it was fetched, is the local clock behind?", common.HumanDuration(-drift))
+	}
+	return fmt.Sprintf("produced %s before it was fetched", common.HumanDuration(drift))
+}
+
+func getIssuer(client *http.Client, cert *x509.Certificate) (*x509.Certificate, error) {
+	for _, issuerURL := range cert.IssuingCertificateURL {
+		resp, err := client.Get(issuerURL)
+		if err != nil {
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if issuer, err := common.ParseCertificate(body); err == nil {
+			return issuer, nil
+		}
+	}
+	return nil, errors.New("no issuer could be retrieved using the certificate AIA URLs, use -issuer")
+}
+
+func fetch(logger *log.Logger, client *http.Client, timeout time.Duration, responders []string, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	request, err := stapledOCSP.CreateRequest(cert.SerialNumber, issuer, common.DefaultRequestHash())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	result, err := stapledOCSP.Fetch(ctx, logger, clock.Default(), stapledOCSP.Backoff{}, responders, client, request, nil, issuer)
+	if err != nil {
+		return nil, err
+	}
+	resp := result.Response
+	if err = stapledOCSP.VerifyResponse(time.Now(), cert.SerialNumber, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func fail(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, msg+"\n", args...)
+	os.Exit(exitError)
+}
+
+func main() {
+	var certFilename, chainFilename, issuerFilename, stapleFilename, responders, format, proxies, sourceAddr string
+	var timeout, driftWarning time.Duration
+	var verbose, printVersion, post, insecureTLS bool
+	flag.StringVar(&certFilename, "cert", "", "Certificate to check (PEM or DER)")
+	flag.StringVar(&chainFilename, "chain", "", "PEM chain, leaf first, to check the status of every certificate in instead of -cert")
+	flag.StringVar(&issuerFilename, "issuer", "", "Issuer of the certificate (PEM or DER), fetched using AIA if not provided")
+	flag.StringVar(&stapleFilename, "staple", "", "DER OCSP response to validate and compare with the live response")
+	flag.StringVar(&responders, "responders", "", "Comma separated OCSP responders to use instead of those in the certificate")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "How long to try fetching the live response for")
+	flag.DurationVar(&driftWarning, "drift-warning", 0, "Warn if the live response was produced further than this from when it was fetched")
+	flag.StringVar(&format, "format", formatText, "Output format for -chain, or -cert without -staple or -responders, one of text, junit, or sarif")
+	flag.StringVar(&proxies, "proxy", "", "Comma separated proxies to pick from randomly, the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables are used if not provided")
+	flag.StringVar(&sourceAddr, "source-addr", "", "IP address, or interface name, to make connections from")
+	flag.BoolVar(&insecureTLS, "insecure-tls", false, "Don't verify the certificates of https proxies and responders")
+	flag.BoolVar(&post, "post", false, "Send requests to responders using POST rather than GET")
+	flag.BoolVar(&verbose, "v", false, "Print fetcher log messages")
+	flag.BoolVar(&printVersion, "version", false, "Print version information and exit")
+	flag.Parse()
+
+	if printVersion {
+		fmt.Println(version.Get(nil))
+		return
+	}
+	if certFilename == "" && chainFilename == "" {
+		fail("-cert or -chain is required")
+	}
+	if format != formatText && chainFilename == "" && (stapleFilename != "" || responders != "") {
+		fail("-staple and -responders can only be used with -format text")
+	}
+
+	stdoutLevel := 3
+	if verbose {
+		stdoutLevel = 7
+	}
+	logger := log.NewLogger("", "", stdoutLevel, clock.Default())
+	var proxyList []string
+	if proxies != "" {
+		proxyList = strings.Split(proxies, ",")
+	}
+	transport, err := stapled.NewTransport(stapled.TransportOptions{
+		Proxies:     proxyList,
+		LocalAddr:   sourceAddr,
+		InsecureTLS: insecureTLS,
+	})
+	if err != nil {
+		fail("Failed to configure transport: %s", err)
+	}
+	if post {
+		transport = &postTransport{transport}
+	}
+	client := &http.Client{Timeout: timeout, Transport: transport}
+
+	if chainFilename != "" || format != formatText {
+		var chain []*x509.Certificate
+		var err error
+		filename := chainFilename
+		if filename != "" {
+			chain, err = readChain(filename)
+		} else {
+			filename = certFilename
+			var cert *x509.Certificate
+			cert, err = common.ReadCertificate(filename)
+			chain = []*x509.Certificate{cert}
+		}
+		if err != nil {
+			fail("Failed to read '%s': %s", filename, err)
+		}
+		var lastIssuer *x509.Certificate
+		last := chain[len(chain)-1]
+		if issuerFilename != "" {
+			lastIssuer, err = common.ReadCertificate(issuerFilename)
+			if err != nil {
+				fail("Failed to read issuer '%s': %s", issuerFilename, err)
+			}
+		} else if !selfSigned(last) && len(last.OCSPServer) > 0 {
+			// if the issuer can't be retrieved the last row reports it
+			lastIssuer, _ = getIssuer(client, last)
+		}
+		problems, err := writeReport(os.Stdout, format, filename, checkChain(logger, client, timeout, chain, lastIssuer))
+		if err != nil {
+			fail("Failed to write report: %s", err)
+		}
+		if problems {
+			os.Exit(exitProblem)
+		}
+		return
+	}
+
+	cert, err := common.ReadCertificate(certFilename)
+	if err != nil {
+		fail("Failed to read certificate '%s': %s", certFilename, err)
+	}
+	var issuer *x509.Certificate
+	if issuerFilename != "" {
+		issuer, err = common.ReadCertificate(issuerFilename)
+	} else {
+		issuer, err = getIssuer(client, cert)
+	}
+	if err != nil {
+		fail("Failed to get issuer: %s", err)
+	}
+	ocspServers := cert.OCSPServer
+	if responders != "" {
+		ocspServers = strings.Split(responders, ",")
+	}
+	if len(ocspServers) == 0 {
+		fail("Certificate has no OCSP responders, use -responders")
+	}
+
+	fmt.Printf("Certificate: serial %X, subject '%s'\n", cert.SerialNumber, cert.Subject.CommonName)
+	fmt.Printf("Responders:  %s\n", strings.Join(ocspServers, ", "))
+
+	live, err := fetch(logger, client, timeout, ocspServers, cert, issuer)
+	if err != nil {
+		fail("Failed to fetch live response: %s", err)
+	}
+	fmt.Printf("Live:        %s\n", describe(live))
+	drift := time.Now().Sub(live.ProducedAt)
+	fmt.Printf("Drift:       %s\n", describeDrift(drift))
+	if driftWarning > 0 && (drift > driftWarning || -drift > driftWarning) {
+		fmt.Printf("Warning:     ProducedAt drift is larger than %s\n", driftWarning)
+	}
+
+	if stapleFilename == "" {
+		return
+	}
+	contents, err := ioutil.ReadFile(stapleFilename)
+	if err != nil {
+		fail("Failed to read staple '%s': %s", stapleFilename, err)
+	}
+	staple, err := stapledOCSP.CheckResponse(time.Now(), contents, issuer)
+	if err != nil {
+		fmt.Printf("Staple:      invalid, %s\n", err)
+		os.Exit(exitProblem)
+	}
+	if staple.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		fmt.Printf("Staple:      invalid, staple is for serial %X\n", staple.SerialNumber)
+		os.Exit(exitProblem)
+	}
+	fmt.Printf("Staple:      %s\n", describe(staple))
+	if staple.ThisUpdate.After(time.Now()) {
+		fmt.Println("Staple:      invalid, this update is in the future")
+		os.Exit(exitProblem)
+	}
+
+	problems := compareStaple(staple, live, time.Now())
+	if len(problems) == 0 {
+		fmt.Println("Result:      staple is current")
+		os.Exit(exitOK)
+	}
+	for _, problem := range problems {
+		fmt.Printf("Result:      %s\n", problem)
+	}
+	os.Exit(exitProblem)
+}
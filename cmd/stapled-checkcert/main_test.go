@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestCompareStaple(t *testing.T) {
+	now := time.Now()
+	live := &ocsp.Response{
+		Status:     ocsp.Good,
+		ThisUpdate: now.Add(-time.Hour),
+		NextUpdate: now.Add(time.Hour),
+	}
+
+	for _, test := range []struct {
+		name     string
+		staple   ocsp.Response
+		problems int
+	}{
+		{"current", *live, 0},
+		{"outdated", ocsp.Response{Status: ocsp.Good, ThisUpdate: now.Add(-2 * time.Hour), NextUpdate: now.Add(time.Minute)}, 1},
+		{"expired", ocsp.Response{Status: ocsp.Good, ThisUpdate: now.Add(-3 * time.Hour), NextUpdate: now.Add(-time.Hour)}, 2},
+		{"diverged", ocsp.Response{Status: ocsp.Revoked, ThisUpdate: live.ThisUpdate, NextUpdate: live.NextUpdate}, 1},
+	} {
+		problems := compareStaple(&test.staple, live, now)
+		if len(problems) != test.problems {
+			t.Fatalf("Expected %d problems for %s staple, got %d: %v", test.problems, test.name, len(problems), problems)
+		}
+	}
+}
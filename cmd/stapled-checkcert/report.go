@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rolandshoemaker/stapled/version"
+)
+
+// output formats for -format
+const (
+	formatText  = "text"
+	formatJUnit = "junit"
+	formatSARIF = "sarif"
+)
+
+// writeReport writes rows, checked from the chain in filename, in
+// format and returns true if any of the certificates aren't good
+func writeReport(w io.Writer, format, filename string, rows []chainRow) (bool, error) {
+	problems := false
+	for _, row := range rows {
+		problems = problems || row.problem
+	}
+	switch format {
+	case formatText:
+		return printChain(w, rows), nil
+	case formatJUnit:
+		return problems, writeJUnit(w, filename, rows)
+	case formatSARIF:
+		return problems, writeSARIF(w, filename, rows)
+	default:
+		return false, fmt.Errorf("unknown format '%s', expected text, junit, or sarif", format)
+	}
+}
+
+func (row chainRow) name(depth int) string {
+	return fmt.Sprintf("depth %d: %s (serial %s)", depth, row.subject, row.serial)
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+// writeJUnit writes rows as a JUnit XML test suite with a test case
+// per certificate, certificates which aren't checked are skipped
+func writeJUnit(w io.Writer, filename string, rows []chainRow) error {
+	suite := junitTestSuite{Name: "stapled-checkcert", Tests: len(rows)}
+	for i, row := range rows {
+		tc := junitTestCase{ClassName: filename, Name: row.name(i)}
+		switch {
+		case row.problem:
+			tc.Failure = &junitMessage{Message: row.detail, Type: row.status}
+			suite.Failures++
+		case row.status == "-":
+			tc.Skipped = &junitMessage{Message: row.detail}
+			suite.Skipped++
+		default:
+			tc.SystemOut = fmt.Sprintf("%s, %s", row.status, row.detail)
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// the subset of SARIF 2.1.0 needed to report problems with a chain
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+	} `json:"physicalLocation"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name    string      `json:"name"`
+			Version string      `json:"version"`
+			Rules   []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+// sarifRules are the rules results may be reported for, keyed by the
+// chainRow status they are reported for
+var sarifRules = map[string]sarifRule{
+	"revoked": {"ocsp-revoked", sarifMessage{"Certificate is revoked"}},
+	"unknown": {"ocsp-unknown", sarifMessage{"Responder doesn't know the certificate"}},
+	"error":   {"ocsp-unavailable", sarifMessage{"Certificate status couldn't be fetched"}},
+}
+
+// ruleFor returns the rule for a problem with status, statuses
+// without a rule of their own are reported as unknown
+func ruleFor(status string) sarifRule {
+	if rule, present := sarifRules[status]; present {
+		return rule
+	}
+	return sarifRules["unknown"]
+}
+
+// writeSARIF writes a SARIF log with a error result for each
+// certificate in rows that isn't good
+func writeSARIF(w io.Writer, filename string, rows []chainRow) error {
+	var run sarifRun
+	run.Tool.Driver.Name = "stapled-checkcert"
+	run.Tool.Driver.Version = version.Version
+	run.Results = []sarifResult{}
+	used := map[string]bool{}
+	for i, row := range rows {
+		if !row.problem {
+			continue
+		}
+		rule := ruleFor(row.status)
+		if !used[rule.ID] {
+			used[rule.ID] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+		}
+		result := sarifResult{
+			RuleID:    rule.ID,
+			Level:     "error",
+			Message:   sarifMessage{fmt.Sprintf("%s is %s: %s", row.name(i), row.status, row.detail)},
+			Locations: make([]sarifLocation, 1),
+		}
+		result.Locations[0].PhysicalLocation.ArtifactLocation.URI = strings.TrimPrefix(filename, "./")
+		run.Results = append(run.Results, result)
+	}
+	if run.Tool.Driver.Rules == nil {
+		run.Tool.Driver.Rules = []sarifRule{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
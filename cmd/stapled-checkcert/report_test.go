@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+)
+
+var reportRows = []chainRow{
+	{subject: "leaf", serial: "3", status: "revoked", detail: "revoked at 2026-01-01T00:00:00Z (reason 1)", problem: true},
+	{subject: "intermediate", serial: "2", status: "good", detail: "next update in 1h0m0s"},
+	{subject: "root", serial: "1", status: "-", detail: "self-signed, not checked"},
+}
+
+func TestWriteJUnit(t *testing.T) {
+	buf := new(bytes.Buffer)
+	problems, err := writeReport(buf, formatJUnit, "chain.pem", reportRows)
+	if err != nil {
+		t.Fatalf("writeReport failed: %s", err)
+	}
+	if !problems {
+		t.Fatal("Expected problems to be reported")
+	}
+	var suite junitTestSuite
+	if err = xml.Unmarshal(buf.Bytes(), &suite); err != nil {
+		t.Fatalf("Failed to parse JUnit report: %s", err)
+	}
+	if suite.Tests != 3 || suite.Failures != 1 || suite.Skipped != 1 || len(suite.TestCases) != 3 {
+		t.Fatalf("Unexpected test suite: %+v", suite)
+	}
+	leaf := suite.TestCases[0]
+	if leaf.ClassName != "chain.pem" || leaf.Failure == nil || leaf.Failure.Type != "revoked" {
+		t.Fatalf("Unexpected test case for revoked certificate: %+v", leaf)
+	}
+	if suite.TestCases[1].Failure != nil || suite.TestCases[1].Skipped != nil {
+		t.Fatalf("Unexpected test case for good certificate: %+v", suite.TestCases[1])
+	}
+	if suite.TestCases[2].Skipped == nil {
+		t.Fatalf("Expected unchecked certificate to be skipped: %+v", suite.TestCases[2])
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	rows := append([]chainRow{{subject: "other", serial: "4", status: "error", detail: "timed out", problem: true}}, reportRows...)
+	buf := new(bytes.Buffer)
+	if _, err := writeReport(buf, formatSARIF, "./certs/chain.pem", rows); err != nil {
+		t.Fatalf("writeReport failed: %s", err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("Failed to parse SARIF report: %s", err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("Unexpected SARIF log: %+v", log)
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || len(run.Results) != 2 {
+		t.Fatalf("Expected 2 rules and results, got %d and %d", len(run.Tool.Driver.Rules), len(run.Results))
+	}
+	for i, ruleID := range []string{"ocsp-unavailable", "ocsp-revoked"} {
+		result := run.Results[i]
+		if result.RuleID != ruleID || result.Level != "error" || result.Locations[0].PhysicalLocation.ArtifactLocation.URI != "certs/chain.pem" {
+			t.Fatalf("Unexpected result: %+v", result)
+		}
+	}
+
+	buf.Reset()
+	if problems, err := writeReport(buf, formatSARIF, "chain.pem", reportRows[1:]); err != nil || problems {
+		t.Fatalf("Expected no problems, got %t: %v", problems, err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil || len(log.Runs[0].Results) != 0 {
+		t.Fatalf("Expected a SARIF log with no results: %s", buf)
+	}
+
+	if _, err := writeReport(buf, "xml", "chain.pem", reportRows); err == nil {
+		t.Fatal("Expected an error for a unknown format")
+	}
+}
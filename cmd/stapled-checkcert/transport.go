@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// postTransport sends the GET requests made by the fetcher to OCSP
+// responders as POST requests instead, with the request in the body.
+// Requests whose path doesn't end in a base64 encoded OCSP request,
+// such as for issuers, are sent unchanged
+type postTransport struct {
+	next http.RoundTripper
+}
+
+func (pt *postTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	escaped := req.URL.EscapedPath()
+	i := strings.LastIndex(escaped, "/")
+	if req.Method != "GET" || i < 0 {
+		return pt.next.RoundTrip(req)
+	}
+	encoded, err := url.PathUnescape(escaped[i+1:])
+	if err != nil {
+		return pt.next.RoundTrip(req)
+	}
+	body, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return pt.next.RoundTrip(req)
+	}
+	if _, err = ocsp.ParseRequest(body); err != nil {
+		return pt.next.RoundTrip(req)
+	}
+	u := *req.URL
+	u.RawPath = ""
+	if u.Path, err = url.PathUnescape(escaped[:i+1]); err != nil {
+		return nil, err
+	}
+	post, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	post.Header.Set("Content-Type", "application/ocsp-request")
+	return pt.next.RoundTrip(post.WithContext(req.Context()))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestPostTransport(t *testing.T) {
+	request, err := (&ocsp.Request{
+		HashAlgorithm:  crypto.SHA1,
+		IssuerNameHash: bytes.Repeat([]byte{0xff}, 20),
+		IssuerKeyHash:  bytes.Repeat([]byte{0xfe}, 20),
+		SerialNumber:   big.NewInt(1337),
+	}).Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %s", err)
+	}
+	var method, path string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &postTransport{http.DefaultTransport}}
+
+	resp, err := client.Get(srv.URL + "/ocsp/" + url.QueryEscape(base64.StdEncoding.EncodeToString(request)))
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	resp.Body.Close()
+	if method != "POST" || path != "/ocsp/" || !bytes.Equal(body, request) {
+		t.Fatalf("Request wasn't sent using POST: %s %s %x", method, path, body)
+	}
+
+	resp, err = client.Get(srv.URL + "/issuer.der")
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	resp.Body.Close()
+	if method != "GET" || path != "/issuer.der" {
+		t.Fatalf("Request that isn't a OCSP request was changed: %s %s", method, path)
+	}
+}
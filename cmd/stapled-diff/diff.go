@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+// entry is the metadata listed for a entry by the /entries admin
+// endpoint
+type entry struct {
+	Name           string     `json:"name"`
+	ID             string     `json:"id"`
+	Serial         string     `json:"serial"`
+	Status         string     `json:"status"`
+	ThisUpdate     *time.Time `json:"thisUpdate"`
+	NextUpdate     *time.Time `json:"nextUpdate"`
+	ResponseSHA256 string     `json:"responseSHA256"`
+}
+
+type entryList struct {
+	Entries []entry `json:"entries"`
+	Next    string  `json:"next"`
+}
+
+// listLimit is the page size requested from /entries
+const listLimit = 10000
+
+// listEntries fetches every entry from the admin API at base
+func listEntries(client *http.Client, base string) ([]entry, error) {
+	base = strings.TrimSuffix(base, "/")
+	var entries []entry
+	after := ""
+	for {
+		u := fmt.Sprintf("%s/entries?limit=%d&after=%s", base, listLimit, url.QueryEscape(after))
+		resp, err := client.Get(u)
+		if err != nil {
+			return nil, err
+		}
+		var list entryList
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("'%s' returned status %d", u, resp.StatusCode)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode '%s': %s", u, err)
+		}
+		entries = append(entries, list.Entries...)
+		if list.Next == "" {
+			return entries, nil
+		}
+		after = list.Next
+	}
+}
+
+// divergence is a entry that differs between the two instances
+type divergence struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Problem string `json:"problem"`
+}
+
+// compareEntries returns the entries that differ between the a and
+// b instances, matched by ID so that entries created in different
+// ways are compared. Responses for the same entry are reported if
+// one instance's is more than maxLag older than the other's, or
+// either has expired
+func compareEntries(a, b []entry, maxLag time.Duration, now time.Time) []divergence {
+	byID := func(entries []entry) map[string]entry {
+		m := make(map[string]entry, len(entries))
+		for _, e := range entries {
+			m[e.ID] = e
+		}
+		return m
+	}
+	as, bs := byID(a), byID(b)
+	divergences := []divergence{}
+	add := func(e entry, problem string, args ...interface{}) {
+		divergences = append(divergences, divergence{e.ID, e.Name, fmt.Sprintf(problem, args...)})
+	}
+	for id, ea := range as {
+		eb, present := bs[id]
+		if !present {
+			add(ea, "only on A")
+			continue
+		}
+		for _, side := range []struct {
+			name string
+			e    entry
+		}{{"A", ea}, {"B", eb}} {
+			if side.e.ThisUpdate == nil {
+				add(side.e, "no response on %s", side.name)
+			} else if now.After(*side.e.NextUpdate) {
+				add(side.e, "response on %s expired %s ago", side.name, common.HumanDuration(now.Sub(*side.e.NextUpdate)))
+			}
+		}
+		if ea.ThisUpdate == nil || eb.ThisUpdate == nil {
+			continue
+		}
+		if ea.Status != eb.Status {
+			add(ea, "status is %s on A but %s on B", ea.Status, eb.Status)
+		}
+		if lag := ea.ThisUpdate.Sub(*eb.ThisUpdate); lag > maxLag {
+			add(ea, "response on B is %s older than on A", common.HumanDuration(lag))
+		} else if -lag > maxLag {
+			add(ea, "response on A is %s older than on B", common.HumanDuration(-lag))
+		}
+	}
+	for id, eb := range bs {
+		if _, present := as[id]; !present {
+			add(eb, "only on B")
+		}
+	}
+	sort.SliceStable(divergences, func(i, j int) bool {
+		if divergences[i].Name != divergences[j].Name {
+			return divergences[i].Name < divergences[j].Name
+		}
+		return divergences[i].ID < divergences[j].ID
+	})
+	return divergences
+}
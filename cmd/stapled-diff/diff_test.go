@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareEntries(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	a := []entry{
+		{Name: "same", ID: "1", Status: "good", ThisUpdate: at(-time.Hour), NextUpdate: at(time.Hour)},
+		{Name: "lagging", ID: "2", Status: "good", ThisUpdate: at(-time.Hour), NextUpdate: at(time.Hour)},
+		{Name: "revoked", ID: "3", Status: "good", ThisUpdate: at(-time.Hour), NextUpdate: at(time.Hour)},
+		{Name: "missing", ID: "4"},
+		{Name: "a-only", ID: "5"},
+	}
+	b := []entry{
+		{Name: "same-renamed", ID: "1", Status: "good", ThisUpdate: at(-time.Minute), NextUpdate: at(time.Hour)},
+		{Name: "lagging", ID: "2", Status: "good", ThisUpdate: at(-4 * time.Hour), NextUpdate: at(-time.Hour)},
+		{Name: "revoked", ID: "3", Status: "revoked", ThisUpdate: at(-time.Hour), NextUpdate: at(time.Hour)},
+		{Name: "missing", ID: "4", Status: "good", ThisUpdate: at(-time.Hour), NextUpdate: at(time.Hour)},
+		{Name: "b-only", ID: "6"},
+	}
+	var problems []string
+	for _, d := range compareEntries(a, b, time.Hour, now) {
+		problems = append(problems, d.Name+": "+d.Problem)
+	}
+	expected := []string{
+		"a-only: only on A",
+		"b-only: only on B",
+		"lagging: response on B expired 1 hour ago",
+		"lagging: response on B is 3 hours older than on A",
+		"missing: no response on A",
+		"revoked: status is good on A but revoked on B",
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Fatalf("Unexpected divergences: %q", problems)
+	}
+}
+
+func TestListEntries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := entryList{Entries: []entry{{Name: "a", ID: "1"}}, Next: "a"}
+		if r.URL.Query().Get("after") == "a" {
+			list = entryList{Entries: []entry{{Name: "b", ID: "2"}}}
+		}
+		json.NewEncoder(w).Encode(list)
+	}))
+	defer srv.Close()
+
+	entries, err := listEntries(new(http.Client), srv.URL+"/")
+	if err != nil {
+		t.Fatalf("listEntries failed: %s", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "a" || entries[1].Name != "b" {
+		t.Fatalf("Unexpected entries: %v", entries)
+	}
+}
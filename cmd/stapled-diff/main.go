@@ -0,0 +1,85 @@
+// stapled-diff compares the entries, and the freshness of their
+// responses, of two stapled instances using their admin APIs. It is
+// used to check that replicas, or the two sides of a blue/green
+// deployment, are serving the same staples.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/version"
+)
+
+// exit codes
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitDiverged = 2 // the instances differ
+)
+
+// report is written by -json
+type report struct {
+	A           int          `json:"a"`
+	B           int          `json:"b"`
+	Divergences []divergence `json:"divergences"`
+}
+
+func fail(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, msg+"\n", args...)
+	os.Exit(exitError)
+}
+
+func main() {
+	var timeout, maxLag time.Duration
+	var asJSON, printVersion bool
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "How long to wait for each admin API request")
+	flag.DurationVar(&maxLag, "max-lag", time.Hour, "How much older the response for a entry on one instance can be than on the other")
+	flag.BoolVar(&asJSON, "json", false, "Write the divergences as JSON")
+	flag.BoolVar(&printVersion, "version", false, "Print version information and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <admin URL A> <admin URL B>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if printVersion {
+		fmt.Println(version.Get(nil))
+		return
+	}
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(exitError)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	a, err := listEntries(client, flag.Arg(0))
+	if err != nil {
+		fail("Failed to list entries on A: %s", err)
+	}
+	b, err := listEntries(client, flag.Arg(1))
+	if err != nil {
+		fail("Failed to list entries on B: %s", err)
+	}
+	divergences := compareEntries(a, b, maxLag, time.Now())
+
+	if asJSON {
+		err = json.NewEncoder(os.Stdout).Encode(report{len(a), len(b), divergences})
+		if err != nil {
+			fail("Failed to write report: %s", err)
+		}
+	} else {
+		for _, d := range divergences {
+			fmt.Printf("%s (%s): %s\n", d.Name, d.ID, d.Problem)
+		}
+		fmt.Printf("%d entries on A, %d on B, %d divergences\n", len(a), len(b), len(divergences))
+	}
+	if len(divergences) > 0 {
+		os.Exit(exitDiverged)
+	}
+	os.Exit(exitOK)
+}
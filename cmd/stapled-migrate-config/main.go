@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/version"
+)
+
+func main() {
+	var inFilename, outFilename string
+	var printVersion bool
+	flag.StringVar(&inFilename, "in", "", "Legacy YAML configuration file")
+	flag.StringVar(&outFilename, "out", "", "Path to write the migrated configuration to (defaults to stdout)")
+	flag.BoolVar(&printVersion, "version", false, "Print version information and exit")
+	flag.Parse()
+
+	if printVersion {
+		fmt.Println(version.Get(nil))
+		return
+	}
+
+	if inFilename == "" {
+		fmt.Fprintln(os.Stderr, "-in is required")
+		os.Exit(1)
+	}
+
+	configBytes, err := ioutil.ReadFile(inFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read configuration file '%s': %s\n", inFilename, err)
+		os.Exit(1)
+	}
+	var legacy config.LegacyConfiguration
+	err = yaml.Unmarshal(configBytes, &legacy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse legacy configuration file: %s\n", err)
+		os.Exit(1)
+	}
+
+	out, warnings, err := legacy.MigrateYAML()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to migrate configuration: %s\n", err)
+		os.Exit(1)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	if outFilename == "" {
+		os.Stdout.Write(out)
+		return
+	}
+	err = ioutil.WriteFile(outFilename, out, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write migrated configuration to '%s': %s\n", outFilename, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,404 @@
+// stapled-selftest runs a stapled server against a fake CA and OCSP
+// responder, using a temporary configuration, and checks that the
+// basic functionality works end-to-end.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+	"gopkg.in/yaml.v2"
+
+	"github.com/rolandshoemaker/stapled"
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/log"
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+	"github.com/rolandshoemaker/stapled/version"
+)
+
+// responseLifetime is kept short so that refreshes happen quickly
+const responseLifetime = 20 * time.Second
+
+// fakeCA issues certificates and acts as their OCSP responder
+type fakeCA struct {
+	key    *rsa.PrivateKey
+	cert   *x509.Certificate
+	srv    *httptest.Server
+	broken bool
+	mu     sync.Mutex
+}
+
+func newFakeCA() (*fakeCA, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stapled self-test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	ca := &fakeCA{key: key, cert: cert}
+	ca.srv = httptest.NewServer(ca)
+	return ca, nil
+}
+
+func (ca *fakeCA) setBroken(broken bool) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.broken = broken
+}
+
+func (ca *fakeCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	broken := ca.broken
+	ca.mu.Unlock()
+	if broken {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+		SerialNumber: req.SerialNumber,
+		Status:       ocsp.Good,
+		ThisUpdate:   now.Add(-responseLifetime / 2),
+		NextUpdate:   now.Add(responseLifetime / 2),
+	}, ca.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(resp)
+}
+
+func (ca *fakeCA) issue(serial int64) ([]byte, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("leaf-%d", serial)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+		OCSPServer:   []string{ca.srv.URL},
+	}
+	return x509.CreateCertificate(rand.Reader, template, ca.cert, ca.key.Public(), ca.key)
+}
+
+// query asks a stapled responder for the status of serial
+func (ca *fakeCA) query(addr string, serial int64) (*ocsp.Response, error) {
+	nameHash, keyHash, err := common.HashIssuer(crypto.SHA1, ca.cert.RawSubject, ca.cert.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	req, err := (&ocsp.Request{
+		HashAlgorithm:  crypto.SHA1,
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  keyHash,
+		SerialNumber:   big.NewInt(serial),
+	}).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Post("http://"+addr, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return stapledOCSP.CheckResponse(time.Now(), body, ca.cert)
+}
+
+// waitFor calls f until it returns nil or the timeout expires
+func waitFor(timeout time.Duration, f func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := f()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+type selfTest struct {
+	dir      string
+	ca       *fakeCA
+	conf     *config.Configuration
+	logger   *log.Logger
+	s        *stapled.Server
+	addr     string
+	timeout  time.Duration
+	lastA    time.Time
+	failures int
+}
+
+func (st *selfTest) start() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	st.addr = l.Addr().String()
+	st.s, err = stapled.NewFromConfig(st.conf, st.logger, clock.Default(), stapled.WithResponderListener(l))
+	if err != nil {
+		l.Close()
+		return err
+	}
+	go st.s.Run()
+	return nil
+}
+
+func (st *selfTest) writeCert(name string, serial int64) error {
+	der, err := st.ca.issue(serial)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(st.dir, "certs", name+".der"), der, 0644)
+}
+
+func (st *selfTest) run(name string, f func() error) {
+	err := f()
+	if err != nil {
+		st.failures++
+		fmt.Printf("FAIL  %s: %s\n", name, err)
+		return
+	}
+	fmt.Printf("PASS  %s\n", name)
+}
+
+func (st *selfTest) expectGood(serial int64) (*ocsp.Response, error) {
+	resp, err := st.ca.query(st.addr, serial)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != ocsp.Good {
+		return nil, fmt.Errorf("unexpected status %d", resp.Status)
+	}
+	return resp, nil
+}
+
+func (st *selfTest) checkInitialLoad() error {
+	if err := st.writeCert("a", 100); err != nil {
+		return err
+	}
+	if err := st.start(); err != nil {
+		return err
+	}
+	return waitFor(st.timeout, func() error {
+		resp, err := st.expectGood(100)
+		if err != nil {
+			return err
+		}
+		st.lastA = resp.ThisUpdate
+		return nil
+	})
+}
+
+func (st *selfTest) checkDiskCache() error {
+	return waitFor(st.timeout, func() error {
+		contents, err := ioutil.ReadFile(filepath.Join(st.dir, "responses", "a.resp"))
+		if err != nil {
+			return err
+		}
+		_, err = stapledOCSP.CheckResponse(time.Now(), contents, st.ca.cert)
+		return err
+	})
+}
+
+func (st *selfTest) checkAdd() error {
+	if err := st.writeCert("b", 200); err != nil {
+		return err
+	}
+	return waitFor(st.timeout, func() error {
+		_, err := st.expectGood(200)
+		return err
+	})
+}
+
+func (st *selfTest) checkRefresh() error {
+	return waitFor(st.timeout+responseLifetime, func() error {
+		resp, err := st.expectGood(100)
+		if err != nil {
+			return err
+		}
+		if !resp.ThisUpdate.After(st.lastA) {
+			return errors.New("response hasn't been refreshed")
+		}
+		return nil
+	})
+}
+
+func (st *selfTest) checkRemove() error {
+	if err := os.Remove(filepath.Join(st.dir, "certs", "a.der")); err != nil {
+		return err
+	}
+	return waitFor(st.timeout, func() error {
+		_, err := st.ca.query(st.addr, 100)
+		if err == nil {
+			return errors.New("removed certificate is still being served")
+		}
+		if respErr, ok := err.(ocsp.ResponseError); !ok || respErr.Status != ocsp.Unauthorized {
+			return fmt.Errorf("unexpected error for removed certificate: %s", err)
+		}
+		return nil
+	})
+}
+
+func (st *selfTest) checkRestartFromDisk() error {
+	ctx, cancel := context.WithTimeout(context.Background(), st.timeout)
+	defer cancel()
+	if err := st.s.Shutdown(ctx); err != nil {
+		return err
+	}
+	// responses must come from the disk cache, not the CA
+	st.ca.setBroken(true)
+	defer st.ca.setBroken(false)
+	if err := st.start(); err != nil {
+		return err
+	}
+	return waitFor(st.timeout, func() error {
+		_, err := st.expectGood(200)
+		return err
+	})
+}
+
+func main() {
+	var verbose, printVersion, keep bool
+	var timeout time.Duration
+	flag.BoolVar(&verbose, "v", false, "Print stapled log messages")
+	flag.BoolVar(&keep, "keep", false, "Don't remove the temporary directory after running")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "How long to wait for each check to pass")
+	flag.BoolVar(&printVersion, "version", false, "Print version information and exit")
+	flag.Parse()
+
+	if printVersion {
+		fmt.Println(version.Get(nil))
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "stapled-selftest")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create temporary directory: %s\n", err)
+		os.Exit(1)
+	}
+	if !keep {
+		defer os.RemoveAll(dir)
+	}
+	for _, sub := range []string{"certs", "issuers", "responses"} {
+		if err = os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create temporary directory: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	ca, err := newFakeCA()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create fake CA: %s\n", err)
+		os.Exit(1)
+	}
+	defer ca.srv.Close()
+	err = ioutil.WriteFile(filepath.Join(dir, "issuers", "ca.der"), ca.cert.Raw, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write issuer: %s\n", err)
+		os.Exit(1)
+	}
+
+	confYAML := fmt.Sprintf(`
+definitions:
+  cert-watch-folder: %s
+  cert-watch-interval: 1s
+  issuer-folder: %s
+fetcher:
+  timeout: 5s
+  monitor-interval: 1s
+  ignore-proxy-environment: true
+disk:
+  cache-folder: %s
+supported-hashes:
+  sha1: true
+  sha256: true
+`,
+		filepath.Join(dir, "certs"),
+		filepath.Join(dir, "issuers"),
+		filepath.Join(dir, "responses"),
+	)
+	err = ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte(confYAML), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write configuration: %s\n", err)
+		os.Exit(1)
+	}
+	var conf config.Configuration
+	if err = yaml.Unmarshal([]byte(confYAML), &conf); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse configuration: %s\n", err)
+		os.Exit(1)
+	}
+
+	stdoutLevel := 3
+	if verbose {
+		stdoutLevel = 7
+	}
+	st := &selfTest{
+		dir:     dir,
+		ca:      ca,
+		conf:    &conf,
+		logger:  log.NewLogger("", "", stdoutLevel, clock.Default()),
+		timeout: timeout,
+	}
+	st.run("load certificate from watch folder", st.checkInitialLoad)
+	st.run("persist response to disk cache", st.checkDiskCache)
+	st.run("add certificate to watch folder", st.checkAdd)
+	st.run("refresh response before expiry", st.checkRefresh)
+	st.run("remove certificate from watch folder", st.checkRemove)
+	st.run("restart using disk cache", st.checkRestartFromDisk)
+
+	if st.failures > 0 {
+		fmt.Printf("%d checks failed\n", st.failures)
+		os.Exit(1)
+	}
+	fmt.Println("All checks passed")
+}
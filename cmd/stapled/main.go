@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/jmhodges/clock"
+	"gopkg.in/yaml.v2"
+
+	"github.com/rolandshoemaker/stapled"
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/version"
+)
+
+// checkConfig prints the problems with a configuration file along
+// with the lines they were found on and returns the number of errors
+func checkConfig(filename string, src []byte) int {
+	lines := strings.Split(string(src), "\n")
+	errors := 0
+	for _, p := range stapled.CheckConfig(src) {
+		if !p.Warning {
+			errors++
+		}
+		if p.Line == 0 {
+			fmt.Printf("%s: %s\n", filename, p)
+			continue
+		}
+		fmt.Printf("%s:%d: %s\n", filename, p.Line, p)
+		if p.Line <= len(lines) {
+			fmt.Printf("    %4d | %s\n", p.Line, strings.TrimRight(lines[p.Line-1], "\r"))
+		}
+	}
+	return errors
+}
+
+// runDryRun prints the result of a dry run for each certificate and
+// returns the exit code
+func runDryRun(conf *config.Configuration, logger *log.Logger, clk clock.Clock) int {
+	results, err := stapled.DryRun(conf, logger, clk)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Dry run failed: %s\n", err)
+		return 1
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %s\n", r.Certificate, r.Err)
+			continue
+		}
+		fmt.Printf("OK   %s (%s)\n", r.Certificate, r.Responder)
+	}
+	fmt.Printf("%d certificates, %d would fail\n", len(results), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
+// validateEntry implements the validate-entry command, which prints
+// the entry that would be created for a certificate as YAML, and
+// returns the exit code
+func validateEntry(args []string) int {
+	var configFilename, certFilename string
+	var responders stringList
+	var vo stapled.ValidateOptions
+	fs := flag.NewFlagSet("validate-entry", flag.ExitOnError)
+	fs.StringVar(&certFilename, "cert", "", "Certificate file to build the entry for")
+	fs.StringVar(&vo.Issuer, "issuer", "", "Issuer certificate file, if not set it is resolved the way the daemon would")
+	fs.StringVar(&configFilename, "config", "", "YAML configuration file, if set the settings of the definition or watch folder the certificate is in are used")
+	fs.Var(&responders, "responder", "Responder to use instead of those in the certificate, can be repeated")
+	fs.Parse(args)
+	if certFilename == "" {
+		fmt.Fprintln(os.Stderr, "-cert is required")
+		fs.Usage()
+		return 2
+	}
+	vo.Responders = responders
+
+	var conf config.Configuration
+	if configFilename != "" {
+		configBytes, err := ioutil.ReadFile(configFilename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read configuration file '%s': %s\n", configFilename, err)
+			return 1
+		}
+		if err = yaml.Unmarshal(configBytes, &conf); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse configuration file: %s\n", err)
+			return 1
+		}
+	}
+	// only errors are logged so they don't drown out the entry
+	clk := clock.Default()
+	logger := log.NewLogger("", "", 3, clk)
+	desc, err := stapled.ValidateEntry(&conf, logger, clk, certFilename, vo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build entry: %s\n", err)
+		return 1
+	}
+	out, err := yaml.Marshal(desc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to marshal entry: %s\n", err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
+
+// reloadOnHangup reloads the proxy configuration from the
+// configuration file, and the manifest, each time SIGHUP is received
+func reloadOnHangup(filename string, s *stapled.Server, logger *log.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		logger.Info("Received SIGHUP, reloading proxy configuration from '%s'", filename)
+		configBytes, err := ioutil.ReadFile(filename)
+		if err != nil {
+			logger.Err("Failed to read configuration file '%s': %s", filename, err)
+			continue
+		}
+		var conf config.Configuration
+		if err = yaml.Unmarshal(configBytes, &conf); err != nil {
+			logger.Err("Failed to parse configuration file: %s", err)
+			continue
+		}
+		if err = s.ReloadProxies(&conf); err != nil {
+			logger.Err("Failed to reload proxy configuration: %s", err)
+		}
+		if err = s.ReloadManifest(); err != nil {
+			logger.Err("Failed to reload manifest: %s", err)
+		}
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate-entry" {
+		os.Exit(validateEntry(os.Args[2:]))
+	}
+
+	var configFilename string
+	var printVersion, onlyCheckConfig, dryRun bool
+
+	flag.StringVar(&configFilename, "config", "example.yaml", "YAML configuration file")
+	flag.BoolVar(&printVersion, "version", false, "Print version information and exit")
+	flag.BoolVar(&onlyCheckConfig, "check-config", false, "Check the configuration file for problems and exit, exits non-zero if there are any errors")
+	flag.BoolVar(&dryRun, "dry-run", false, "Build the request for each certificate and send one to each responder, without starting any servers or writing to the disk cache, exits non-zero if any certificate would fail")
+	flag.Parse()
+
+	if printVersion {
+		fmt.Println(version.Get(nil))
+		return
+	}
+
+	configBytes, err := ioutil.ReadFile(configFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read configuration file '%s': %s", configFilename, err)
+		os.Exit(1)
+	}
+	if onlyCheckConfig {
+		if errors := checkConfig(configFilename, configBytes); errors > 0 {
+			fmt.Printf("%s: %d errors\n", configFilename, errors)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: OK\n", configFilename)
+		return
+	}
+	var conf config.Configuration
+	err = yaml.Unmarshal(configBytes, &conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse configuration file: %s", err)
+		os.Exit(1)
+	}
+
+	clk := clock.Default()
+	logger := log.NewLogger(conf.Syslog.Network, conf.Syslog.Addr, conf.Syslog.StdoutLevel, clk)
+	if dryRun {
+		os.Exit(runDryRun(&conf, logger, clk))
+	}
+	logger.Info("Starting stapled %s", version.Get(stapled.EnabledFeatures(&conf)))
+
+	logger.Info("Initializing stapled")
+	s, err := stapled.NewFromConfig(&conf, logger, clk)
+	if err != nil {
+		logger.Err("Failed to initialize stapled: %s", err)
+		os.Exit(1)
+	}
+
+	go reloadOnHangup(configFilename, s, logger)
+
+	logger.Info("Running stapled")
+	err = s.Run()
+	if err != nil {
+		logger.Err("stapled failed: %s", err)
+	}
+	logger.Close()
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"hash"
 	"io/ioutil"
-	mrand "math/rand"
 	"net/http"
 	"net/url"
 	"os"
@@ -16,6 +15,7 @@ import (
 	"time"
 
 	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/rand"
 )
 
 func HumanDuration(d time.Duration) string {
@@ -65,7 +65,7 @@ func (bf *BasicFailer) Fail(logger *log.Logger, msg string) {
 }
 
 func randomURL(urls []*url.URL) *url.URL {
-	return urls[mrand.Intn(len(urls))]
+	return urls[rand.Intn(len(urls))]
 }
 
 func ProxyFunc(proxies []string) (func(*http.Request) (*url.URL, error), error) {
@@ -121,3 +121,30 @@ func HashNameAndPKI(h hash.Hash, name, pki []byte) ([]byte, []byte, error) {
 	pkiHash := h.Sum(nil)
 	return nameHash[:], pkiHash[:], nil
 }
+
+// tlsFeatureOID is the id-pe-tlsfeature extension from RFC 7633
+var tlsFeatureOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// statusRequestFeature is the status_request TLS extension
+const statusRequestFeature = 5
+
+// MustStaple checks if a certificate has the TLS feature extension
+// with status_request, meaning clients that enforce it will fail
+// handshakes that don't include a staple
+func MustStaple(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(tlsFeatureOID) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, f := range features {
+			if f == statusRequestFeature {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -3,6 +3,8 @@ package common
 import (
 	"bytes"
 	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"net/url"
 	"testing"
 	"time"
@@ -85,3 +87,27 @@ func TestProxyFuncy(t *testing.T) {
 		t.Fatalf("Function returned from ProxyFunc returned URL not in provided list: %s", random.String())
 	}
 }
+
+func TestMustStaple(t *testing.T) {
+	issuer, err := ReadCertificate("../testdata/test-issuer.der")
+	if err != nil {
+		t.Fatalf("Failed to read test issuer: %s", err)
+	}
+	if MustStaple(issuer) {
+		t.Fatal("MustStaple returned true for certificate without the TLS feature extension")
+	}
+	for _, test := range []struct {
+		value    []byte
+		expected bool
+	}{
+		{[]byte{0x30, 0x03, 0x02, 0x01, 0x05}, true},                   // status_request
+		{[]byte{0x30, 0x06, 0x02, 0x01, 0x11, 0x02, 0x01, 0x05}, true}, // status_request_v2, status_request
+		{[]byte{0x30, 0x03, 0x02, 0x01, 0x11}, false},                  // status_request_v2
+		{[]byte{0x05}, false},                                          // malformed
+	} {
+		cert := &x509.Certificate{Extensions: []pkix.Extension{{Id: tlsFeatureOID, Value: test.value}}}
+		if MustStaple(cert) != test.expected {
+			t.Fatalf("MustStaple returned %t for extension %x", !test.expected, test.value)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package common
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+
+	// register the hashes used by the default provider
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// HashProvider creates the hash functions used by stapled, it can be
+// replaced with SetHashProvider so that builds can use FIPS validated
+// implementations
+type HashProvider interface {
+	// New returns a hash.Hash computing h, or a error if the provider
+	// doesn't implement it
+	New(h crypto.Hash) (hash.Hash, error)
+}
+
+type stdlibHashes struct{}
+
+func (stdlibHashes) New(h crypto.Hash) (hash.Hash, error) {
+	if !h.Available() {
+		return nil, fmt.Errorf("hash %d isn't available", h)
+	}
+	return h.New(), nil
+}
+
+// StdlibHashes is the default HashProvider, it uses the crypto
+// package implementations
+var StdlibHashes HashProvider = stdlibHashes{}
+
+// ApprovedHashes are the hashes approved by FIPS 180-4 for new uses
+// that stapled supports, SHA-1 isn't included
+var ApprovedHashes = []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+// ErrHashNotApproved is returned by NewHash for hashes that have been
+// disabled with RestrictHashes
+var ErrHashNotApproved = errors.New("hash isn't approved")
+
+var hashes = struct {
+	sync.RWMutex
+	provider HashProvider
+	approved map[crypto.Hash]bool // nil allows every hash
+}{provider: StdlibHashes}
+
+// SetHashProvider replaces the provider used by NewHash, it should be
+// called before any entries or servers are created
+func SetHashProvider(p HashProvider) {
+	hashes.Lock()
+	defer hashes.Unlock()
+	hashes.provider = p
+}
+
+// RestrictHashes disables every hash that isn't in approved, if
+// approved is empty every hash is allowed. SHA-256 is used
+// internally and is always allowed
+func RestrictHashes(approved []crypto.Hash) {
+	hashes.Lock()
+	defer hashes.Unlock()
+	if len(approved) == 0 {
+		hashes.approved = nil
+		return
+	}
+	hashes.approved = map[crypto.Hash]bool{crypto.SHA256: true}
+	for _, h := range approved {
+		hashes.approved[h] = true
+	}
+}
+
+// HashApproved checks if h hasn't been disabled by RestrictHashes
+func HashApproved(h crypto.Hash) bool {
+	hashes.RLock()
+	defer hashes.RUnlock()
+	return hashes.approved == nil || hashes.approved[h]
+}
+
+// NewHash returns a hash.Hash computing h from the current provider
+func NewHash(h crypto.Hash) (hash.Hash, error) {
+	hashes.RLock()
+	provider, approved := hashes.provider, hashes.approved
+	hashes.RUnlock()
+	if approved != nil && !approved[h] {
+		return nil, fmt.Errorf("%w: %d", ErrHashNotApproved, h)
+	}
+	return provider.New(h)
+}
+
+// NewSHA256 returns a SHA-256 hash.Hash from the current provider, it
+// panics if the provider can't create one since stapled can't work
+// without it
+func NewSHA256() hash.Hash {
+	h, err := NewHash(crypto.SHA256)
+	if err != nil {
+		panic(fmt.Sprintf("hash provider can't create SHA-256: %s", err))
+	}
+	return h
+}
+
+// Sum256 returns the SHA-256 digest of data, see NewSHA256
+func Sum256(data []byte) [32]byte {
+	var sum [32]byte
+	h := NewSHA256()
+	h.Write(data)
+	h.Sum(sum[:0])
+	return sum
+}
+
+// DefaultRequestHash returns the hash upstream requests are built with
+// when one isn't configured, SHA-1, or SHA-256 if SHA-1 isn't approved
+func DefaultRequestHash() crypto.Hash {
+	if HashApproved(crypto.SHA1) {
+		return crypto.SHA1
+	}
+	return crypto.SHA256
+}
+
+// HashIssuer computes the issuer name and key hashes of a OCSP CertID
+// using h from the current provider, see HashNameAndPKI
+func HashIssuer(h crypto.Hash, name, pki []byte) ([]byte, []byte, error) {
+	hh, err := NewHash(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	return HashNameAndPKI(hh, name, pki)
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"testing"
+)
+
+type countingHashes struct {
+	created map[crypto.Hash]int
+}
+
+func (ch *countingHashes) New(h crypto.Hash) (hash.Hash, error) {
+	ch.created[h]++
+	return StdlibHashes.New(h)
+}
+
+func TestHashProvider(t *testing.T) {
+	provider := &countingHashes{created: make(map[crypto.Hash]int)}
+	SetHashProvider(provider)
+	defer SetHashProvider(StdlibHashes)
+
+	if sum := Sum256([]byte("stapled")); sum != sha256.Sum256([]byte("stapled")) {
+		t.Fatalf("Unexpected SHA-256 digest: %x", sum)
+	}
+	issuer, err := ReadCertificate("../testdata/test-issuer.der")
+	if err != nil {
+		t.Fatalf("Failed to read test issuer: %s", err)
+	}
+	if _, _, err = HashIssuer(crypto.SHA1, issuer.RawSubject, issuer.RawSubjectPublicKeyInfo); err != nil {
+		t.Fatalf("HashIssuer failed: %s", err)
+	}
+	if provider.created[crypto.SHA256] != 1 || provider.created[crypto.SHA1] != 1 {
+		t.Fatalf("Hashes weren't created by the provider: %v", provider.created)
+	}
+}
+
+func TestRestrictHashes(t *testing.T) {
+	RestrictHashes(ApprovedHashes)
+	defer RestrictHashes(nil)
+
+	if _, err := NewHash(crypto.SHA1); !errors.Is(err, ErrHashNotApproved) {
+		t.Fatalf("Expected ErrHashNotApproved for SHA-1, got: %v", err)
+	}
+	if HashApproved(crypto.SHA1) {
+		t.Fatal("SHA-1 is approved")
+	}
+	if h := DefaultRequestHash(); h != crypto.SHA256 {
+		t.Fatalf("Expected requests to use SHA-256, got %d", h)
+	}
+	for _, h := range ApprovedHashes {
+		if _, err := NewHash(h); err != nil {
+			t.Fatalf("Failed to create approved hash %d: %s", h, err)
+		}
+	}
+
+	RestrictHashes(nil)
+	if _, err := NewHash(crypto.SHA1); err != nil {
+		t.Fatalf("Failed to create SHA-1 without restrictions: %s", err)
+	}
+	if h := DefaultRequestHash(); h != crypto.SHA1 {
+		t.Fatalf("Expected requests to use SHA-1, got %d", h)
+	}
+}
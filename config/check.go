@@ -0,0 +1,287 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Problem describes a issue found while checking a configuration,
+// Key is the dotted path of the problematic key, e.g.
+// definitions.certificates[0].issuer, and Line is the line it is
+// defined on, or zero if unknown
+type Problem struct {
+	Key     string
+	Line    int
+	Warning bool
+	Message string
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	if p.Key == "" {
+		return fmt.Sprintf("%s: %s", level, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", level, p.Key, p.Message)
+}
+
+// SortProblems sorts problems by line, problems without a line are
+// sorted last
+func SortProblems(problems []Problem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if (a.Line == 0) != (b.Line == 0) {
+			return b.Line == 0
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Key < b.Key
+	})
+}
+
+func stripComment(line string) string {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return ""
+	}
+	if i := strings.Index(line, " #"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// KeyLines maps the dotted path of each key in a YAML document to
+// the line it is defined on. It only understands block style mappings
+// and sequences, which is all stapled configuration files use
+func KeyLines(src []byte) map[string]int {
+	type level struct {
+		indent int
+		path   string
+		seq    bool
+	}
+	lines := map[string]int{}
+	seqCounts := map[string]int{}
+	stack := []level{{indent: -1}}
+	for i, raw := range strings.Split(string(src), "\n") {
+		line := strings.TrimRight(stripComment(raw), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		for strings.HasPrefix(trimmed, "-") && (len(trimmed) == 1 || trimmed[1] == ' ') {
+			// sequence items may be at the same indent as their key
+			for len(stack) > 1 && (stack[len(stack)-1].indent > indent || (stack[len(stack)-1].indent == indent && stack[len(stack)-1].seq)) {
+				stack = stack[:len(stack)-1]
+			}
+			parent := stack[len(stack)-1].path
+			path := fmt.Sprintf("%s[%d]", parent, seqCounts[parent])
+			seqCounts[parent]++
+			lines[path] = i + 1
+			stack = append(stack, level{indent, path, true})
+			rest := strings.TrimLeft(trimmed[1:], " ")
+			indent += len(trimmed) - len(rest)
+			trimmed = rest
+		}
+		if trimmed == "" {
+			continue
+		}
+		for len(stack) > 1 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		colon := strings.Index(trimmed, ":")
+		if colon <= 0 || (colon+1 < len(trimmed) && trimmed[colon+1] != ' ') {
+			continue // scalar sequence item or something we don't understand
+		}
+		key := strings.Trim(trimmed[:colon], `"'`)
+		path := key
+		if parent := stack[len(stack)-1].path; parent != "" {
+			path = parent + "." + key
+		}
+		lines[path] = i + 1
+		stack = append(stack, level{indent, path, false})
+	}
+	return lines
+}
+
+var (
+	durationType        = reflect.TypeOf(ConfigDuration{})
+	sizeType            = reflect.TypeOf(ConfigSize{})
+	supportedHashesType = reflect.TypeOf(SupportedHashes{})
+	hashNames           = map[string]bool{"sha1": true, "sha256": true, "sha384": true, "sha512": true}
+)
+
+// fieldName returns the key yaml.v2 uses for a struct field
+func fieldName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("yaml"), ",")[0]; tag != "" {
+		return tag
+	}
+	return strings.ToLower(f.Name)
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// checkKeys walks a generically parsed document against the type it
+// will be unmarshaled into, reporting unknown keys and malformed
+// values that yaml.v2 would reject without any context
+func checkKeys(path string, v interface{}, t reflect.Type, lines map[string]int, problems *[]Problem) {
+	problem := func(warning bool, msg string, args ...interface{}) {
+		*problems = append(*problems, Problem{Key: path, Line: lines[path], Warning: warning, Message: fmt.Sprintf(msg, args...)})
+	}
+	if v == nil {
+		return
+	}
+	switch t {
+	case durationType:
+		s, ok := v.(string)
+		if !ok {
+			problem(false, "expected a duration such as 30s or 1h")
+			return
+		}
+		if _, err := time.ParseDuration(s); err != nil {
+			problem(false, "invalid duration '%s', expected a duration such as 30s or 1h", s)
+		}
+		return
+	case sizeType:
+		if _, err := ParseSize(fmt.Sprint(v)); err != nil {
+			problem(false, "invalid size '%v', expected a size such as 512MiB or 2GB", v)
+		}
+		return
+	case supportedHashesType:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			problem(false, "expected a mapping of hash names to booleans")
+			return
+		}
+		for k := range m {
+			if name := fmt.Sprint(k); !hashNames[name] {
+				path := joinPath(path, name)
+				*problems = append(*problems, Problem{Key: path, Line: lines[path], Warning: true, Message: "unknown hash, expected one of sha1, sha256, sha384, or sha512"})
+			}
+		}
+		return
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		checkKeys(path, v, t.Elem(), lines, problems)
+	case reflect.Struct:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			problem(false, "expected a mapping")
+			return
+		}
+		fields := map[string]reflect.StructField{}
+		for i := 0; i < t.NumField(); i++ {
+			fields[fieldName(t.Field(i))] = t.Field(i)
+		}
+		for k, fv := range m {
+			name := fmt.Sprint(k)
+			f, present := fields[name]
+			if !present {
+				path := joinPath(path, name)
+				*problems = append(*problems, Problem{Key: path, Line: lines[path], Warning: true, Message: "unknown key"})
+				continue
+			}
+			checkKeys(joinPath(path, name), fv, f.Type, lines, problems)
+		}
+	case reflect.Slice:
+		items, ok := v.([]interface{})
+		if !ok {
+			problem(false, "expected a list")
+			return
+		}
+		for i, item := range items {
+			checkKeys(fmt.Sprintf("%s[%d]", path, i), item, t.Elem(), lines, problems)
+		}
+	case reflect.Map:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			problem(false, "expected a mapping")
+			return
+		}
+		for k, mv := range m {
+			checkKeys(joinPath(path, fmt.Sprint(k)), mv, t.Elem(), lines, problems)
+		}
+	}
+}
+
+// CheckSyntax parses src and returns the configuration along with
+// any problems with its structure, such as unknown keys or malformed
+// durations. Each top level section is decoded separately and those
+// that can't be are left zero, so that the rest of the configuration
+// can still be checked. If src isn't a YAML mapping the configuration
+// is nil
+func CheckSyntax(src []byte) (*Configuration, []Problem) {
+	var generic interface{}
+	if err := yaml.Unmarshal(src, &generic); err != nil {
+		return nil, []Problem{{Message: err.Error()}}
+	}
+	lines := KeyLines(src)
+	problems := []Problem{}
+	checkKeys("", generic, reflect.TypeOf(Configuration{}), lines, &problems)
+
+	var conf Configuration
+	sections, ok := generic.(map[interface{}]interface{})
+	if !ok && generic != nil {
+		if err := yaml.Unmarshal(src, &conf); err != nil {
+			problems = append(problems, Problem{Message: err.Error()})
+		}
+		SortProblems(problems)
+		return nil, problems
+	}
+	for section, err := range decodeSections(sections, &conf) {
+		if !describedUnder(problems, section) {
+			problems = append(problems, Problem{Key: section, Line: lines[section], Message: err.Error()})
+		}
+	}
+	SortProblems(problems)
+	return &conf, problems
+}
+
+// decodeSections decodes each top level section in sections into the
+// matching field of conf, fields whose section can't be decoded are
+// left zero. It returns the errors for those sections keyed by name
+func decodeSections(sections map[interface{}]interface{}, conf *Configuration) map[string]error {
+	failed := map[string]error{}
+	v := reflect.ValueOf(conf).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := fieldName(v.Type().Field(i))
+		section, present := sections[name]
+		if !present {
+			continue
+		}
+		raw, err := yaml.Marshal(section)
+		if err == nil {
+			err = yaml.Unmarshal(raw, v.Field(i).Addr().Interface())
+		}
+		if err != nil {
+			v.Field(i).Set(reflect.Zero(v.Field(i).Type()))
+			failed[name] = err
+		}
+	}
+	return failed
+}
+
+// describedUnder checks if problems contains a error for section or
+// a key within it, which describes why it couldn't be decoded with
+// more context than the yaml.v2 error
+func describedUnder(problems []Problem, section string) bool {
+	for _, p := range problems {
+		if !p.Warning && (p.Key == section || strings.HasPrefix(p.Key, section+".") || strings.HasPrefix(p.Key, section+"[")) {
+			return true
+		}
+	}
+	return false
+}
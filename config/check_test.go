@@ -0,0 +1,76 @@
+package config
+
+import (
+	"testing"
+)
+
+var checkYAML = `definitions:
+  # comment
+  certificates:
+  - certificate: a.der
+    isuer: issuer.der
+  - certificate: b.der
+fetcher:
+  timeout: 10 seconds
+  monitor-interval: 1m # trailing comment
+  proxies:
+    - http://127.0.0.1:3128
+unknown: true
+`
+
+func TestKeyLines(t *testing.T) {
+	lines := KeyLines([]byte(checkYAML))
+	for key, line := range map[string]int{
+		"definitions":                             1,
+		"definitions.certificates":                3,
+		"definitions.certificates[0]":             4,
+		"definitions.certificates[0].isuer":       5,
+		"definitions.certificates[1].certificate": 6,
+		"fetcher.timeout":                         8,
+		"fetcher.monitor-interval":                9,
+		"fetcher.proxies[0]":                      11,
+		"unknown":                                 12,
+	} {
+		if lines[key] != line {
+			t.Fatalf("Expected '%s' on line %d, got %d", key, line, lines[key])
+		}
+	}
+}
+
+func TestCheckSyntax(t *testing.T) {
+	conf, problems := CheckSyntax([]byte(checkYAML))
+	if conf == nil {
+		t.Fatal("Expected a configuration despite the invalid duration")
+	}
+	// the section with the invalid duration is left zero, the others
+	// are decoded
+	if len(conf.Definitions.Certificates) != 2 || conf.Fetcher.MonitorInterval.Duration != 0 {
+		t.Fatalf("Unexpected sections decoded: %+v %+v", conf.Definitions.Certificates, conf.Fetcher)
+	}
+	expected := []Problem{
+		{Key: "definitions.certificates[0].isuer", Line: 5, Warning: true, Message: "unknown key"},
+		{Key: "fetcher.timeout", Line: 8, Message: "invalid duration '10 seconds', expected a duration such as 30s or 1h"},
+		{Key: "unknown", Line: 12, Warning: true, Message: "unknown key"},
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+	for i := range expected {
+		if problems[i] != expected[i] {
+			t.Fatalf("Expected problem '%s', got '%s'", expected[i], problems[i])
+		}
+	}
+
+	conf, problems = CheckSyntax([]byte("fetcher:\n  timeout: 10s\n"))
+	if conf == nil || len(problems) != 0 {
+		t.Fatalf("Expected a valid configuration, got problems: %v", problems)
+	}
+	if conf.Fetcher.Timeout.Duration.String() != "10s" {
+		t.Fatalf("Unexpected timeout %s", conf.Fetcher.Timeout.Duration)
+	}
+
+	_, problems = CheckSyntax([]byte("fetcher: [\n"))
+	if len(problems) != 1 || problems[0].Key != "" {
+		t.Fatalf("Expected a single parse error, got %v", problems)
+	}
+}
@@ -3,12 +3,34 @@ package config
 import (
 	"crypto"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type SupportedHashes []crypto.Hash
 
-func (sh SupportedHashes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+// MarshalYAML outputs the hashes in the same form UnmarshalYAML
+// expects
+func (sh SupportedHashes) MarshalYAML() (interface{}, error) {
+	hashConf := map[string]bool{}
+	for _, h := range sh {
+		switch h {
+		case crypto.SHA1:
+			hashConf["sha1"] = true
+		case crypto.SHA256:
+			hashConf["sha256"] = true
+		case crypto.SHA384:
+			hashConf["sha384"] = true
+		case crypto.SHA512:
+			hashConf["sha512"] = true
+		}
+	}
+	return hashConf, nil
+}
+
+func (sh *SupportedHashes) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var hashConf struct {
 		SHA1   bool
 		SHA256 bool
@@ -19,36 +41,123 @@ func (sh SupportedHashes) UnmarshalYAML(unmarshal func(interface{}) error) error
 		return err
 	}
 	if hashConf.SHA1 {
-		sh = append(sh, crypto.SHA1)
+		*sh = append(*sh, crypto.SHA1)
 	}
 	if hashConf.SHA256 {
-		sh = append(sh, crypto.SHA256)
+		*sh = append(*sh, crypto.SHA256)
 	}
 	if hashConf.SHA384 {
-		sh = append(sh, crypto.SHA384)
+		*sh = append(*sh, crypto.SHA384)
 	}
 	if hashConf.SHA512 {
-		sh = append(sh, crypto.SHA512)
+		*sh = append(*sh, crypto.SHA512)
 	}
-	if len(sh) == 0 {
+	if len(*sh) == 0 {
 		return errors.New("at least one supported hash must be configured")
 	}
 
 	return nil
 }
 
+// RequestExtension is a extension added to the OCSP requests sent
+// upstream, OID is in dotted form and Value is the hex encoded DER
+// extension value
+type RequestExtension struct {
+	OID      string
+	Value    string
+	Critical bool
+}
+
+// BackoffClass describes how upstream failures of a class are
+// retried, a Attempts of zero uses the default and a negative
+// Attempts retries until the fetch times out
+type BackoffClass struct {
+	Delay    ConfigDuration
+	Attempts int
+}
+
 type CertDefinition struct {
 	Certificate            string
-	ResponseName           string
+	ResponseName           string `yaml:"response-name"`
 	Issuer                 string
 	Responders             []string
-	OverrideGlobalUpstream bool `yaml:"override-global-upstream"`
+	OverrideGlobalUpstream bool               `yaml:"override-global-upstream"`
+	RequestExtensions      []RequestExtension `yaml:"request-extensions"`
+	UnknownStatus          string             `yaml:"unknown-status"`
+	// Nonce sends a nonce in every request for the certificate
+	Nonce bool
+}
+
+// IssuerResponders are the default responders for certificates
+// issued by a issuer, matched by SKID, the hex subject key ID of the
+// issuer, or if it isn't set by Subject, see mcache.IssuerResponders
+type IssuerResponders struct {
+	SKID       string
+	Subject    string
+	Responders []string
+}
+
+// WatchFolder describes a folder of certificates to watch and the
+// settings used for the entries created from it
+type WatchFolder struct {
+	Folder            string
+	Issuer            string
+	Responders        []string
+	Proxies           []string
+	Labels            map[string]string
+	RequestExtensions []RequestExtension `yaml:"request-extensions"`
+	ResponseName      string             `yaml:"response-name"`
+	UnknownStatus     string             `yaml:"unknown-status"`
+	// Nonce sends a nonce in every request for the certificates
+	Nonce bool
+}
+
+// AWSACMSource describes a AWS Certificate Manager region to list
+// certificates from, see cloud.ACM
+type AWSACMSource struct {
+	Region          string
+	AccessKeyID     string `yaml:"access-key-id"`
+	SecretAccessKey string `yaml:"secret-access-key"`
+	SessionToken    string `yaml:"session-token"`
+	Labels          map[string]string
+}
+
+// GCPSource describes a Google Cloud project to list load balancer
+// certificates from, see cloud.GCP
+type GCPSource struct {
+	Project string
+	Labels  map[string]string
+}
+
+// NotifierDefinition describes where to send notifications, Type is
+// one of webhook, slack, or pagerduty. If Events is empty every
+// event is sent
+type NotifierDefinition struct {
+	Type       string
+	URL        string
+	RoutingKey string `yaml:"routing-key"`
+	Events     []string
+}
+
+// ExternalBacking is a third-party stable backing, either a Go
+// plugin, see scache.LoadPlugin, which is passed Options, or a helper
+// process run with Command, see scache.ProcessCache
+type ExternalBacking struct {
+	Plugin  string
+	Options map[string]string
+	Command []string
+	Timeout ConfigDuration
 }
 
 type ConfigDuration struct {
 	time.Duration
 }
 
+// MarshalYAML outputs the duration as a golang style duration string
+func (d ConfigDuration) MarshalYAML() (interface{}, error) {
+	return d.Duration.String(), nil
+}
+
 // UnmarshalYAML parses a golang style duration string into a time.Duration
 func (d *ConfigDuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var s string
@@ -63,37 +172,444 @@ func (d *ConfigDuration) UnmarshalYAML(unmarshal func(interface{}) error) error
 	return nil
 }
 
+// ConfigSize is a size in bytes written with a optional unit suffix,
+// e.g. 512MiB or 2GB
+type ConfigSize struct {
+	Bytes int64
+}
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// ParseSize parses a size in bytes with a optional unit suffix
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, multiplier = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return n * multiplier, nil
+}
+
+// MarshalYAML outputs the size in bytes
+func (s ConfigSize) MarshalYAML() (interface{}, error) {
+	return fmt.Sprintf("%dB", s.Bytes), nil
+}
+
+// UnmarshalYAML parses a size with a optional unit suffix
+func (s *ConfigSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	n, err := ParseSize(str)
+	if err != nil {
+		return err
+	}
+	s.Bytes = n
+	return nil
+}
+
 // Configuration holds... well the confugration data
 type Configuration struct {
+	// Syslog.StatsInterval is how often a summary of the cache
+	// statistics is logged, see stapled.WithStatsInterval
 	Syslog struct {
-		Network     string
-		Addr        string
-		StdoutLevel int `yaml:"stdout-level"`
+		Network       string
+		Addr          string
+		StdoutLevel   int            `yaml:"stdout-level"`
+		StatsInterval ConfigDuration `yaml:"stats-interval"`
 	}
 
+	// HTTP.RequestCacheSize is how many distinct GET paths have
+	// their parsed request remembered, see stapled.WithRequestCache.
+	// AccessLog logs each request to the responder, see
+	// stapled.AccessLog. ClientInventory tracks the clients requesting
+	// each serial, see stapled.ClientInventory. MissLog remembers
+	// requests for certificates with unknown issuers, see
+	// stapled.MissLog. Shadow compares a
+	// fraction, Rate, of responses served from the cache with a fresh
+	// response from upstream, see stapled.Shadow.
+	// ExpiredCertificates.Policy is either serve,
+	// the default, grace, or unauthorized, see stapled.ExpiredPolicy
 	HTTP struct {
-		Addr string
+		Addr             string
+		RequestCacheSize int `yaml:"request-cache-size"`
+		AccessLog        struct {
+			Enabled       bool
+			HitSample     int  `yaml:"hit-sample"`
+			HashClientIPs bool `yaml:"hash-client-ips"`
+		} `yaml:"access-log"`
+		ClientInventory struct {
+			Enabled    bool
+			MaxSerials int `yaml:"max-serials"`
+			MaxClients int `yaml:"max-clients"`
+		} `yaml:"client-inventory"`
+		MissLog struct {
+			Enabled     bool
+			MaxRequests int `yaml:"max-requests"`
+		} `yaml:"miss-log"`
+		Shadow struct {
+			Rate          float64
+			Timeout       ConfigDuration
+			MaxConcurrent int `yaml:"max-concurrent"`
+		}
+		ExpiredCertificates struct {
+			Policy string
+			Grace  ConfigDuration
+		} `yaml:"expired-certificates"`
+		// TLS serves the responder over TLS, see
+		// stapled.ResponderTLS
+		TLS struct {
+			Certificate       string
+			Key               string
+			ClientCA          string `yaml:"client-ca"`
+			RequireClientCert bool   `yaml:"require-client-cert"`
+		}
+	}
+
+	// Admin.Socket is the path of a Unix socket serving a line
+	// oriented text protocol, see stapled.WithControlSocket
+	Admin struct {
+		Addr   string
+		Socket string
 	}
 
+	// StableBackings.Selection is either first, the default, or
+	// freshest, see mcache.StableSelection. MissMemo is how long a
+	// request that wasn't found in the stable backings is remembered
+	// for, see mcache.EntryCache.LookupStable. WriteBehind queues
+	// writes to the backings, see scache.WriteBehind. External lists
+	// backings used along with the disk cache. S3 stores responses in
+	// a S3 compatible bucket if S3.Bucket is set, see scache.S3Cache
+	StableBackings struct {
+		Selection string
+		MissMemo  ConfigDuration `yaml:"miss-memo"`
+		External  []ExternalBacking
+		S3        struct {
+			Bucket            string
+			Prefix            string
+			Region            string
+			Endpoint          string
+			AccessKeyID       string `yaml:"access-key-id"`
+			SecretAccessKey   string `yaml:"secret-access-key"`
+			SessionToken      string `yaml:"session-token"`
+			Timeout           ConfigDuration
+			ConsistencyWindow ConfigDuration `yaml:"consistency-window"`
+		}
+		WriteBehind struct {
+			Enabled     bool
+			QueueSize   int `yaml:"queue-size"`
+			BatchSize   int `yaml:"batch-size"`
+			Interval    ConfigDuration
+			MaxAttempts int `yaml:"max-attempts"`
+		} `yaml:"write-behind"`
+	} `yaml:"stable-backings"`
+
+	// Limits constrain the resources used by the process, MaxProcs
+	// sets GOMAXPROCS and MemoryLimit sets the soft memory limit the
+	// garbage collector tries to stay under, it is ignored when built
+	// with Go older than 1.19. If MinFileDescriptors is set stapled
+	// refuses to start when the open file limit is lower
+	Limits struct {
+		MaxProcs           int        `yaml:"max-procs"`
+		MemoryLimit        ConfigSize `yaml:"memory-limit"`
+		MinFileDescriptors uint64     `yaml:"min-file-descriptors"`
+	}
+
+	// Clock compares the local clock with the Date headers returned
+	// by URLs, or the responders of the entries, every Interval and
+	// logs a critical message if they differ by more than
+	// MaxOffset, see stapled.ClockCheck
+	Clock struct {
+		MaxOffset ConfigDuration `yaml:"max-offset"`
+		Interval  ConfigDuration
+		URLs      []string
+		Strict    bool
+	}
+
+	// Cluster.LeaderElection elects a single instance, of those
+	// sharing Disk.CacheFolder, to fetch each response from upstream,
+	// the others read the response it writes to the cache folder.
+	// InstanceID identifies this instance and defaults to the
+	// hostname, RefreshLease is how long a instance keeps the lease
+	// on refreshing a entry once it has taken it
+	Cluster struct {
+		LeaderElection bool           `yaml:"leader-election"`
+		InstanceID     string         `yaml:"instance-id"`
+		RefreshLease   ConfigDuration `yaml:"refresh-lease"`
+
+		// Standby makes the instance the warm standby of another,
+		// it doesn't fetch responses from upstream until it is
+		// promoted. Active is the URL of the admin listener of the
+		// active instance, whose responses are pulled every Interval,
+		// if it is empty responses are only loaded from stable
+		// backings shared with the active instance
+		Standby struct {
+			Enabled  bool
+			Active   string
+			Interval ConfigDuration
+		}
+	}
+
+	// Cloud lists certificates from cloud provider APIs every
+	// Cloud.Interval, 15 minutes by default. AWS credentials are read
+	// from the standard environment variables if they aren't set
+	Cloud struct {
+		Interval ConfigDuration
+		AWSACM   []AWSACMSource `yaml:"aws-acm"`
+		GCP      []GCPSource    `yaml:"gcp"`
+	}
+
+	// Export.BundlePath is where the current responses for every
+	// certificate are written, in Export.BundleFormat, either concat,
+	// the default, tar, or directory, in which case BundlePath is a
+	// folder, see stapled.WithBundleExport. PackPath is
+	// where the packed file served by replicas is written, see
+	// stapled.WithPackExport. IssuerFolder is where every cached
+	// issuer is written, see stapled.WithIssuerExport
+	Export struct {
+		BundlePath   string         `yaml:"bundle-path"`
+		BundleFormat string         `yaml:"bundle-format"`
+		PackPath     string         `yaml:"pack-path"`
+		IssuerFolder string         `yaml:"issuer-folder"`
+		Interval     ConfigDuration `yaml:"interval"`
+	}
+
+	// Replica.PackedResponses is a packed file, written by a primary
+	// with Export.PackPath, which responses not in the cache are
+	// served from. It is checked for a new generation every
+	// CheckInterval, 10 seconds by default, see
+	// stapled.WithPackedResponses
+	Replica struct {
+		PackedResponses string         `yaml:"packed-responses"`
+		CheckInterval   ConfigDuration `yaml:"check-interval"`
+	}
+
+	// Faults injects failures so that alerting and frontends can be
+	// tested in staging, see stapled.Faults. Never set it in
+	// production
+	Faults struct {
+		FetchDropRate  float64        `yaml:"fetch-drop-rate"`
+		FetchDelay     ConfigDuration `yaml:"fetch-delay"`
+		ResponderDelay ConfigDuration `yaml:"responder-delay"`
+		CorruptRate    float64        `yaml:"corrupt-rate"`
+	}
+
+	// Metrics are sent to the StatsD server at Metrics.StatsDAddr,
+	// served for Prometheus at /prometheus on the admin listener,
+	// and logged every Metrics.LogInterval, see the metrics package.
+	// A cache efficiency report is logged every EfficiencyInterval,
+	// see stapled.WithEfficiencyReports
+	Metrics struct {
+		StatsDAddr         string `yaml:"statsd-addr"`
+		StatsDPrefix       string `yaml:"statsd-prefix"`
+		Prometheus         bool
+		LogInterval        ConfigDuration `yaml:"log-interval"`
+		EfficiencyInterval ConfigDuration `yaml:"efficiency-interval"`
+	}
+
+	// Tracing.OTLPEndpoint is the OpenTelemetry collector spans are
+	// exported to using OTLP over HTTP, with Headers set on each
+	// request, every Interval, see tracing.OTLPExporter
+	Tracing struct {
+		OTLPEndpoint string            `yaml:"otlp-endpoint"`
+		Headers      map[string]string `yaml:"headers"`
+		ServiceName  string            `yaml:"service-name"`
+		Interval     ConfigDuration    `yaml:"interval"`
+	}
+
+	// Disk.CheckConsistency compares the responses in CacheFolder
+	// with the configured entries at startup, see
+	// stapled.WithDiskCheck
 	Disk struct {
-		CacheFolder string `yaml:"cache-folder"`
+		CacheFolder      string `yaml:"cache-folder"`
+		ControlFiles     bool   `yaml:"control-files"`
+		CheckConsistency bool   `yaml:"check-consistency"`
+		PruneOrphans     bool   `yaml:"prune-orphans"`
+		// responses are encrypted using a hex or base64 encoded AES
+		// key read from one of these sources, the output of
+		// EncryptionKeyCommand can be used to fetch the key from a KMS
+		EncryptionKeyFile    string   `yaml:"encryption-key-file"`
+		EncryptionKeyEnv     string   `yaml:"encryption-key-env"`
+		EncryptionKeyCommand []string `yaml:"encryption-key-command"`
+	}
+
+	// DNS configures the experimental DNS digest server
+	DNS struct {
+		Addr string `yaml:"addr"`
+		Zone string `yaml:"zone"`
 	}
 
 	SupportedHashes SupportedHashes `yaml:"supported-hashes"`
+	// DisableSHA1 removes SHA-1 from the supported hashes, builds
+	// upstream requests using SHA-256, and rejects SHA-1 requests
+	DisableSHA1 bool `yaml:"disable-sha1"`
+	// ApprovedHashesOnly disables every hash that isn't in
+	// common.ApprovedHashes, it implies DisableSHA1 and also stops
+	// SHA-1 being used anywhere else, such as when checking
+	// delegated responder certificates
+	ApprovedHashesOnly bool `yaml:"approved-hashes-only"`
+	// LightweightProfile enforces the RFC 5019 lightweight profile on
+	// client requests and on upstream requests and responses
+	LightweightProfile bool `yaml:"lightweight-profile"`
 
 	Fetcher struct {
-		Timeout            ConfigDuration
-		Proxies            []string
-		UpstreamResponders []string `yaml:"upstream-responders"`
+		Timeout ConfigDuration
+		// MonitorInterval is how often entries are checked to see if
+		// they need to be refreshed
+		MonitorInterval ConfigDuration `yaml:"monitor-interval"`
+		// Proxies is a static list of proxies to pick from randomly,
+		// if empty ProxyPAC is evaluated if set, otherwise the
+		// HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables
+		// are used unless IgnoreProxyEnvironment is set. Only a
+		// subset of PAC files is supported, see the pac package
+		Proxies                []string
+		ProxyPAC               string `yaml:"proxy-pac"`
+		IgnoreProxyEnvironment bool   `yaml:"ignore-proxy-environment"`
+		// ResponderRewrites replaces responder URL prefixes with
+		// another prefix when requests are sent, e.g. to reach a
+		// responder through a internal mirror. The proxies and
+		// rewrites are reloaded when stapled receives SIGHUP
+		ResponderRewrites  map[string]string `yaml:"responder-rewrites"`
+		UpstreamResponders []string          `yaml:"upstream-responders"`
+		// LocalAddr is the IP address, or the name of the interface
+		// whose address, upstream connections are made from.
+		// ProxyLocalAddrs and ResponderLocalAddrs override it for
+		// connections to specific proxies and, when requests to
+		// them aren't proxied, responders, keyed by URL
+		LocalAddr           string            `yaml:"local-addr"`
+		ProxyLocalAddrs     map[string]string `yaml:"proxy-local-addrs"`
+		ResponderLocalAddrs map[string]string `yaml:"responder-local-addrs"`
+		// ResponderMethods sets the HTTP method used for requests to
+		// responders, keyed by URL, either auto, the default, get, or
+		// post, see stapledOCSP.Method
+		ResponderMethods map[string]string `yaml:"responder-methods"`
+		// NonceResponders lists the responder URLs every request
+		// to includes a nonce, see stapledOCSP.Nonces
+		NonceResponders []string `yaml:"nonce-responders"`
+		// ProxyCA and ResponderCA are PEM files of the CA
+		// certificates trusted for https proxies and responders, the
+		// system roots are used if they aren't set. Requests to the
+		// hosts in NoProxy, or with a leading '.' to hosts in the
+		// domain, are never proxied
+		ProxyCA     string   `yaml:"proxy-ca"`
+		ResponderCA string   `yaml:"responder-ca"`
+		NoProxy     []string `yaml:"no-proxy"`
+		// ResponderCAs maps https responder hosts, and with a
+		// leading '.' domains, to PEM files of the CA certificates
+		// trusted for them in place of ResponderCA, the files are
+		// read again when they change
+		ResponderCAs map[string]string `yaml:"responder-cas"`
+		// ResponderPins maps https responder hosts, and with a
+		// leading '.' domains, to base64 encoded SHA-256 hashes of
+		// subject public key infos, fetches from a responder whose
+		// chain doesn't contain one of them fail
+		ResponderPins map[string][]string `yaml:"responder-pins"`
+		// ResponderCheck controls how delegated responder
+		// certificates without id-pkix-ocsp-nocheck are handled,
+		// either trust, the default, warn, or check, which checks
+		// their status, following at most ResponderCheckDepth
+		// delegated responders, see stapledOCSP.NoCheckPolicy
+		ResponderCheck      string `yaml:"responder-check"`
+		ResponderCheckDepth int    `yaml:"responder-check-depth"`
+		// MaxConcurrentRefreshes limits how many entries are refreshed
+		// at once, entries closest to expiring are refreshed first
+		MaxConcurrentRefreshes int `yaml:"max-concurrent-refreshes"`
+		// Backoff is how long to wait between failed requests, unless
+		// the responder asks for longer with Retry-After, a random
+		// amount of up to BackoffJitter * Backoff is added to each wait
+		Backoff       ConfigDuration
+		BackoffJitter float64 `yaml:"backoff-jitter"`
+		// BackoffClasses overrides Backoff for failures of each class,
+		// network, server, client, or response, and limits how many
+		// failures of the class a fetch makes before giving up, see
+		// stapledOCSP.ClassPolicy
+		BackoffClasses map[string]BackoffClass `yaml:"backoff-classes"`
+		// RetryBudget is the fraction of the requests to each
+		// responder host in a minute which may be retries, with a
+		// minimum of RetryBudgetMin retries a minute, zero disables
+		// the budget, see stapledOCSP.RetryBudget
+		RetryBudget    float64 `yaml:"retry-budget"`
+		RetryBudgetMin int     `yaml:"retry-budget-min"`
+		// RampUpInterval is how long to spread the refreshes of stale
+		// entries over when more than RampUpThreshold entries are
+		// stale, such as after a long downtime
+		RampUpInterval  ConfigDuration `yaml:"ramp-up-interval"`
+		RampUpThreshold int            `yaml:"ramp-up-threshold"`
+		// DriftWarning logs a warning when the average difference
+		// between when responses from a responder are fetched and
+		// their ProducedAt is larger than it
+		DriftWarning ConfigDuration `yaml:"drift-warning"`
+		// HistorySize is how many upstream requests are remembered
+		// for each entry, zero uses the default and a negative
+		// number remembers none
+		HistorySize int `yaml:"history-size"`
+		// Quorum confirms each new response with a second response
+		// from another of the entry's responders or, for entries with
+		// a single responder, through Proxy, see mcache.Quorum
+		Quorum struct {
+			Enabled bool
+			Proxy   string
+		}
+	}
+
+	// GameDay fetches responses for Sample entries from their
+	// fallback responders, and through each of Fetcher.Proxies, every
+	// Interval and sends a fallback-broken notification for those
+	// that fail, see stapled.GameDay
+	GameDay struct {
+		Interval ConfigDuration
+		Sample   int
+		Timeout  ConfigDuration
+	} `yaml:"game-day"`
+
+	Notifications struct {
+		Interval            ConfigDuration
+		RefreshFailingAfter ConfigDuration `yaml:"refresh-failing-after"`
+		CertExpiringWithin  ConfigDuration `yaml:"cert-expiring-within"`
+		DedupWindow         ConfigDuration `yaml:"dedup-window"`
+		MaxPerHour          int            `yaml:"max-per-hour"`
+		Notifiers           []NotifierDefinition
 	}
 
 	Definitions struct {
-		CertWatchFolder string `yaml:"cert-watch-folder"`
-		IssuerFolder    string `yaml:"issuer-folder"`
-		Certificates    []struct {
-			Certificate string
-			Issuer      string
-			Responders  []string
-		}
+		CertWatchFolder   string         `yaml:"cert-watch-folder"`
+		CertWatchFolders  []WatchFolder  `yaml:"cert-watch-folders"`
+		CertWatchInterval ConfigDuration `yaml:"cert-watch-interval"`
+		IssuerFolder      string         `yaml:"issuer-folder"`
+		// MaxIssuers is how many issuers are cached before those
+		// no entries use are evicted, zero uses the default and a
+		// negative number never evicts them
+		MaxIssuers int `yaml:"max-issuers"`
+		// NoResponderPolicy is warn, the default, skip, or fail, see
+		// mcache.NoResponderPolicy
+		NoResponderPolicy string `yaml:"no-responder-policy"`
+		// IssuerResponders are used for certificates without OCSP
+		// URLs when no responders are set for them
+		IssuerResponders []IssuerResponders `yaml:"issuer-responders"`
+		// UnknownStatus controls how fetched responses with the status
+		// Unknown are handled, either serve, the default, retry, or
+		// alert, see mcache.UnknownPolicy. It can be overridden for
+		// each certificate and watch folder
+		UnknownStatus string `yaml:"unknown-status"`
+		Certificates  []CertDefinition
+		// Manifest is a CSV or JSON file listing certificates by
+		// serial and issuer, it is reloaded when stapled receives
+		// SIGHUP, see stapled.ParseManifest
+		Manifest string
 	}
 }
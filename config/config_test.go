@@ -1 +1,41 @@
 package config
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, test := range []struct {
+		in       string
+		expected int64
+	}{
+		{"100", 100},
+		{"100B", 100},
+		{"2KiB", 2048},
+		{"512MiB", 512 << 20},
+		{"2 GB", 2e9},
+	} {
+		n, err := ParseSize(test.in)
+		if err != nil {
+			t.Fatalf("ParseSize failed for '%s': %s", test.in, err)
+		}
+		if n != test.expected {
+			t.Fatalf("Expected '%s' to be %d bytes, got %d", test.in, test.expected, n)
+		}
+	}
+	for _, bad := range []string{"", "MiB", "-1", "1.5GiB", "10PB"} {
+		if _, err := ParseSize(bad); err == nil {
+			t.Fatalf("ParseSize didn't fail for '%s'", bad)
+		}
+	}
+
+	var conf Configuration
+	if err := yaml.Unmarshal([]byte("limits:\n  memory-limit: 1GiB\n"), &conf); err != nil {
+		t.Fatalf("Failed to unmarshal configuration: %s", err)
+	}
+	if conf.Limits.MemoryLimit.Bytes != 1<<30 {
+		t.Fatalf("Unexpected memory limit: %d", conf.Limits.MemoryLimit.Bytes)
+	}
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"crypto"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LegacyConfiguration describes the configuration format used by
+// the original cmd/stapled.go based daemon
+type LegacyConfiguration struct {
+	Syslog struct {
+		Network     string
+		Addr        string
+		StdoutLevel int `yaml:"stdout-level"`
+	}
+
+	HTTP struct {
+		Addr string
+	}
+
+	Disk struct {
+		CacheFolder string `yaml:"cache-folder"`
+		DontCache   bool   `yaml:"dont-cache"`
+	}
+
+	DontDieOnStaleResponse bool `yaml:"dont-die-on-stale-response"`
+	DontSeedCacheFromDisk  bool `yaml:"dont-seed-cache-from-disk"`
+
+	Fetcher struct {
+		Timeout          string
+		BaseBackoff      string `yaml:"base-backoff"`
+		Proxies          []string
+		UpstreamStapleds []string `yaml:"upstream-stapleds"`
+	}
+
+	Definitions struct {
+		CertWatchFolder string `yaml:"cert-watch-folder"`
+		Certificates    []struct {
+			Certificate            string
+			Name                   string
+			Issuer                 string
+			ResponseName           string `yaml:"response-name"`
+			Responders             []string
+			OverrideGlobalUpstream bool `yaml:"override-global-upstream"`
+		}
+	}
+}
+
+// Migrate converts a legacy configuration into the current format,
+// it also returns a list of warnings describing options that were
+// dropped because they no longer exist
+func (lc *LegacyConfiguration) Migrate() (*Configuration, []string, error) {
+	warnings := []string{}
+	conf := &Configuration{}
+
+	conf.Syslog.Network = lc.Syslog.Network
+	conf.Syslog.Addr = lc.Syslog.Addr
+	conf.Syslog.StdoutLevel = lc.Syslog.StdoutLevel
+	conf.HTTP.Addr = lc.HTTP.Addr
+
+	if !lc.Disk.DontCache {
+		conf.Disk.CacheFolder = lc.Disk.CacheFolder
+	} else if lc.Disk.CacheFolder != "" {
+		warnings = append(warnings, "disk.dont-cache no longer exists, disk.cache-folder has been dropped to disable the disk cache")
+	}
+	if lc.DontDieOnStaleResponse {
+		warnings = append(warnings, "dont-die-on-stale-response no longer exists, stale responses are never served")
+	}
+	if lc.DontSeedCacheFromDisk {
+		warnings = append(warnings, "dont-seed-cache-from-disk no longer exists, the cache is always seeded from disk if disk.cache-folder is set")
+	}
+
+	if lc.Fetcher.Timeout != "" {
+		timeout, err := time.ParseDuration(lc.Fetcher.Timeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid fetcher.timeout: %s", err)
+		}
+		conf.Fetcher.Timeout.Duration = timeout
+	}
+	if lc.Fetcher.BaseBackoff != "" {
+		backoff, err := time.ParseDuration(lc.Fetcher.BaseBackoff)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid fetcher.base-backoff: %s", err)
+		}
+		conf.Fetcher.Backoff.Duration = backoff
+	}
+	conf.Fetcher.Proxies = lc.Fetcher.Proxies
+	conf.Fetcher.UpstreamResponders = lc.Fetcher.UpstreamStapleds
+
+	conf.Definitions.CertWatchFolder = lc.Definitions.CertWatchFolder
+	for i, def := range lc.Definitions.Certificates {
+		if def.Name != "" {
+			warnings = append(warnings, fmt.Sprintf("definitions.certificates[%d].name no longer exists, entries are named using the certificate filename", i))
+		}
+		conf.Definitions.Certificates = append(conf.Definitions.Certificates, CertDefinition{
+			Certificate:            def.Certificate,
+			ResponseName:           def.ResponseName,
+			Issuer:                 def.Issuer,
+			Responders:             def.Responders,
+			OverrideGlobalUpstream: def.OverrideGlobalUpstream,
+		})
+	}
+
+	// the legacy daemon always supported every hash
+	conf.SupportedHashes = SupportedHashes{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+	return conf, warnings, nil
+}
+
+// migratedConfiguration holds the fields of Configuration that
+// Migrate sets, so that the migrated configuration is written without
+// every other option at its zero value
+type migratedConfiguration struct {
+	Syslog struct {
+		Network     string `yaml:",omitempty"`
+		Addr        string `yaml:",omitempty"`
+		StdoutLevel int    `yaml:"stdout-level,omitempty"`
+	} `yaml:",omitempty"`
+	HTTP struct {
+		Addr string `yaml:",omitempty"`
+	} `yaml:",omitempty"`
+	Disk struct {
+		CacheFolder string `yaml:"cache-folder,omitempty"`
+	} `yaml:",omitempty"`
+	SupportedHashes SupportedHashes `yaml:"supported-hashes,omitempty"`
+	Fetcher         struct {
+		Timeout            ConfigDuration `yaml:",omitempty"`
+		Backoff            ConfigDuration `yaml:",omitempty"`
+		Proxies            []string       `yaml:",omitempty"`
+		UpstreamResponders []string       `yaml:"upstream-responders,omitempty"`
+	} `yaml:",omitempty"`
+	Definitions struct {
+		CertWatchFolder string                `yaml:"cert-watch-folder,omitempty"`
+		Certificates    []migratedCertificate `yaml:",omitempty"`
+	} `yaml:",omitempty"`
+}
+
+type migratedCertificate struct {
+	Certificate            string
+	ResponseName           string   `yaml:"response-name,omitempty"`
+	Issuer                 string   `yaml:",omitempty"`
+	Responders             []string `yaml:",omitempty"`
+	OverrideGlobalUpstream bool     `yaml:"override-global-upstream,omitempty"`
+}
+
+// MigrateYAML is Migrate but returns the migrated configuration
+// marshaled as YAML, containing only the options set by the legacy
+// configuration
+func (lc *LegacyConfiguration) MigrateYAML() ([]byte, []string, error) {
+	conf, warnings, err := lc.Migrate()
+	if err != nil {
+		return nil, nil, err
+	}
+	var out migratedConfiguration
+	out.Syslog.Network = conf.Syslog.Network
+	out.Syslog.Addr = conf.Syslog.Addr
+	out.Syslog.StdoutLevel = conf.Syslog.StdoutLevel
+	out.HTTP.Addr = conf.HTTP.Addr
+	out.Disk.CacheFolder = conf.Disk.CacheFolder
+	out.SupportedHashes = conf.SupportedHashes
+	out.Fetcher.Timeout = conf.Fetcher.Timeout
+	out.Fetcher.Backoff = conf.Fetcher.Backoff
+	out.Fetcher.Proxies = conf.Fetcher.Proxies
+	out.Fetcher.UpstreamResponders = conf.Fetcher.UpstreamResponders
+	out.Definitions.CertWatchFolder = conf.Definitions.CertWatchFolder
+	for _, def := range conf.Definitions.Certificates {
+		out.Definitions.Certificates = append(out.Definitions.Certificates, migratedCertificate{
+			Certificate:            def.Certificate,
+			ResponseName:           def.ResponseName,
+			Issuer:                 def.Issuer,
+			Responders:             def.Responders,
+			OverrideGlobalUpstream: def.OverrideGlobalUpstream,
+		})
+	}
+	marshaled, err := yaml.Marshal(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	return marshaled, warnings, nil
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var legacyYAML = `
+disk:
+  cache-folder: responses/
+dont-die-on-stale-response: true
+fetcher:
+  timeout: 30s
+  base-backoff: 5s
+  upstream-stapleds:
+    - http://localhost:8080
+definitions:
+  cert-watch-folder: certs/
+  certificates:
+    - certificate: a.der
+      name: a
+      issuer: issuer.der
+`
+
+// migratedYAML is legacyYAML migrated, options the legacy
+// configuration doesn't set aren't included
+var migratedYAML = `disk:
+  cache-folder: responses/
+supported-hashes:
+  sha1: true
+  sha256: true
+  sha384: true
+  sha512: true
+fetcher:
+  timeout: 30s
+  backoff: 5s
+  upstream-responders:
+  - http://localhost:8080
+definitions:
+  cert-watch-folder: certs/
+  certificates:
+  - certificate: a.der
+    issuer: issuer.der
+`
+
+func TestMigrate(t *testing.T) {
+	var lc LegacyConfiguration
+	err := yaml.Unmarshal([]byte(legacyYAML), &lc)
+	if err != nil {
+		t.Fatalf("Failed to parse legacy configuration: %s", err)
+	}
+	conf, warnings, err := lc.Migrate()
+	if err != nil {
+		t.Fatalf("Failed to migrate legacy configuration: %s", err)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, got %d: %s", len(warnings), strings.Join(warnings, ", "))
+	}
+	if conf.Fetcher.Timeout.Duration != 30*time.Second {
+		t.Fatalf("Unexpected fetcher timeout: %s", conf.Fetcher.Timeout.Duration)
+	}
+	if conf.Fetcher.Backoff.Duration != 5*time.Second {
+		t.Fatalf("Base backoff wasn't migrated: %s", conf.Fetcher.Backoff.Duration)
+	}
+	if len(conf.Fetcher.UpstreamResponders) != 1 || conf.Fetcher.UpstreamResponders[0] != "http://localhost:8080" {
+		t.Fatalf("Upstream stapleds weren't migrated to upstream responders: %v", conf.Fetcher.UpstreamResponders)
+	}
+	if len(conf.Definitions.Certificates) != 1 || conf.Definitions.Certificates[0].Issuer != "issuer.der" {
+		t.Fatal("Certificate definitions weren't migrated")
+	}
+
+	out, err := yaml.Marshal(conf)
+	if err != nil {
+		t.Fatalf("Failed to marshal migrated configuration: %s", err)
+	}
+	if !strings.Contains(string(out), "timeout: 30s") {
+		t.Fatalf("Marshaled configuration didn't contain the fetcher timeout:\n%s", out)
+	}
+
+	out, _, err = lc.MigrateYAML()
+	if err != nil {
+		t.Fatalf("MigrateYAML failed: %s", err)
+	}
+	if string(out) != migratedYAML {
+		t.Fatalf("Unexpected migrated configuration:\n%s", out)
+	}
+
+	lc.Fetcher.BaseBackoff = "soon"
+	_, _, err = lc.Migrate()
+	if err == nil {
+		t.Fatal("Migrate didn't fail with a invalid base-backoff")
+	}
+}
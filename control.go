@@ -0,0 +1,127 @@
+package stapled
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// control files, each line of which should contain either a entry
+// name or a hex encoded certificate serial
+const (
+	controlRefresh    = "refresh"
+	controlInvalidate = "invalidate"
+)
+
+// WithControlFolder enables the control file channel for hosts
+// without the admin API. Entries named in folder/refresh are
+// refreshed and entries named in folder/invalidate are invalidated.
+// The folder is checked every second, or immediately when the
+// process receives SIGUSR1
+func WithControlFolder(folder string) Option {
+	return func(s *Server) error {
+		s.controlFolder = folder
+		return nil
+	}
+}
+
+// takeControlFile atomically takes the contents of a control file,
+// so that lines written while it is being processed aren't lost,
+// and returns the non-empty lines
+func takeControlFile(filename string) ([]string, error) {
+	processing := filename + ".processing"
+	err := os.Rename(filename, processing)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer os.Remove(processing)
+	contents, err := ioutil.ReadFile(processing)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// controlTargets returns the names of the entries referenced by a
+// control file line, first matching on entry name and then on hex
+// serial
+func (s *Server) controlTargets(line string) []string {
+	entries := s.c.Entries()
+	for _, info := range entries {
+		if info.Name == line {
+			return []string{line}
+		}
+	}
+	serial, ok := new(big.Int).SetString(strings.TrimPrefix(strings.ToLower(line), "0x"), 16)
+	if !ok {
+		return nil
+	}
+	names := []string{}
+	for _, info := range entries {
+		if info.Serial != nil && info.Serial.Cmp(serial) == 0 {
+			names = append(names, info.Name)
+		}
+	}
+	return names
+}
+
+func (s *Server) checkControlFile(file string, action func(string) error) {
+	filename := filepath.Join(s.controlFolder, file)
+	lines, err := takeControlFile(filename)
+	if err != nil {
+		s.log.Err("[control] Failed to read control file '%s': %s", filename, err)
+		return
+	}
+	for _, line := range lines {
+		names := s.controlTargets(line)
+		if len(names) == 0 {
+			s.log.Warning("[control] No entries found matching '%s' in '%s'", line, filename)
+			continue
+		}
+		for _, name := range names {
+			s.log.Info("[control] Running %s for entry '%s'", file, name)
+			if err := action(name); err != nil {
+				s.log.Err("[control] Failed to %s entry '%s': %s", file, name, err)
+			}
+		}
+	}
+}
+
+func (s *Server) checkControlFolder() {
+	s.checkControlFile(controlRefresh, s.c.Refresh)
+	s.checkControlFile(controlInvalidate, s.c.Invalidate)
+}
+
+func (s *Server) watchControlFolder() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-signals:
+			s.checkControlFolder()
+		case <-ticker.C:
+			s.checkControlFolder()
+		}
+	}
+}
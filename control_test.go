@@ -0,0 +1,56 @@
+package stapled
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestControlFolder(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tf.s.controlFolder = dir
+
+	entries := tf.s.c.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	name, lastSync := entries[0].Name, entries[0].LastSync
+
+	for _, test := range []struct {
+		file    string
+		content string
+	}{
+		{controlRefresh, "unknown\n539\n"},
+		{controlInvalidate, name + "\n"},
+	} {
+		tf.fc.Add(time.Minute)
+		err = ioutil.WriteFile(filepath.Join(dir, test.file), []byte(test.content), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write control file: %s", err)
+		}
+		tf.s.checkControlFolder()
+
+		if _, err = os.Stat(filepath.Join(dir, test.file)); !os.IsNotExist(err) {
+			t.Fatalf("Control file '%s' wasn't removed after being processed", test.file)
+		}
+		info := tf.s.c.Entries()[0]
+		if !info.LastSync.After(lastSync) {
+			t.Fatalf("Entry wasn't synced after writing '%s'", test.file)
+		}
+		lastSync = info.LastSync
+	}
+
+	w := tf.get(nil)
+	if w.Code != 200 {
+		t.Fatalf("Unexpected status code after invalidating entry: %d", w.Code)
+	}
+}
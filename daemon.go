@@ -0,0 +1,1128 @@
+package stapled
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/cloud"
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/mcache"
+	stapledMetrics "github.com/rolandshoemaker/stapled/metrics"
+	"github.com/rolandshoemaker/stapled/notify"
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+	"github.com/rolandshoemaker/stapled/scache"
+	"github.com/rolandshoemaker/stapled/tracing"
+)
+
+// proxySource returns the function used to select a proxy for each
+// upstream request, a static proxy list takes precedence over a PAC
+// file, which takes precedence over the environment
+func proxySource(conf *config.Configuration) (func(*http.Request) (*url.URL, error), error) {
+	if len(conf.Fetcher.Proxies) > 0 {
+		return common.ProxyFunc(conf.Fetcher.Proxies)
+	}
+	if conf.Fetcher.ProxyPAC != "" {
+		pf := &pacFile{filename: conf.Fetcher.ProxyPAC}
+		if _, err := pf.load(); err != nil {
+			return nil, err
+		}
+		return pf.proxy, nil
+	}
+	if conf.Fetcher.IgnoreProxyEnvironment {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment, nil
+}
+
+func newClient(proxyFunc func(*http.Request) (*url.URL, error), upstream *upstreamSettings) *http.Client {
+	transport := http.RoundTripper(newTransport(proxyFunc, upstream))
+	if upstream != nil && len(upstream.pins) > 0 {
+		transport = &pinnedTransport{transport, upstream}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// configFaults returns the faults to inject described by conf
+func configFaults(conf *config.Configuration) Faults {
+	return Faults{
+		FetchDropRate:  conf.Faults.FetchDropRate,
+		FetchDelay:     conf.Faults.FetchDelay.Duration,
+		ResponderDelay: conf.Faults.ResponderDelay.Duration,
+		CorruptRate:    conf.Faults.CorruptRate,
+	}
+}
+
+// metricsOptions creates the metrics sinks described by conf, it
+// returns the sink the cache should report to and the options which
+// configure the server to use them
+func metricsOptions(conf *config.Configuration, logger *log.Logger) (stapledMetrics.Sink, []Option, error) {
+	var sinks []stapledMetrics.Sink
+	var opts []Option
+	if conf.Metrics.StatsDAddr != "" {
+		statsd, err := stapledMetrics.NewStatsD(conf.Metrics.StatsDAddr, conf.Metrics.StatsDPrefix)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to configure statsd: %s", err)
+		}
+		sinks = append(sinks, statsd)
+	}
+	if conf.Metrics.Prometheus {
+		prometheus := stapledMetrics.NewPrometheus("stapled")
+		sinks = append(sinks, prometheus)
+		opts = append(opts, WithPrometheus(prometheus))
+	}
+	if conf.Metrics.LogInterval.Duration > 0 {
+		dump := stapledMetrics.NewLogDump(logger)
+		sinks = append(sinks, dump)
+		opts = append(opts, WithMetricsLog(dump, conf.Metrics.LogInterval.Duration))
+	}
+	if conf.Metrics.EfficiencyInterval.Duration > 0 {
+		opts = append(opts, WithEfficiencyReports(conf.Metrics.EfficiencyInterval.Duration))
+	}
+	sink := stapledMetrics.Multi(sinks...)
+	return sink, append(opts, WithMetrics(sink)), nil
+}
+
+// fetcherDial returns the dialFunc for upstream connections, nil if
+// the default dialer should be used
+func fetcherDial(conf *config.Configuration) (dialFunc, error) {
+	overrides := make(map[string]string, len(conf.Fetcher.ProxyLocalAddrs)+len(conf.Fetcher.ResponderLocalAddrs))
+	for u, addr := range conf.Fetcher.ResponderLocalAddrs {
+		overrides[u] = addr
+	}
+	for u, addr := range conf.Fetcher.ProxyLocalAddrs {
+		overrides[u] = addr
+	}
+	return localAddrDial(conf.Fetcher.LocalAddr, overrides)
+}
+
+// loadIssuers reads the issuer certificates in folder, files which
+// can't be read are logged and skipped
+func loadIssuers(folder string, logger *log.Logger) ([]*x509.Certificate, error) {
+	issuers := []*x509.Certificate{}
+	if folder == "" {
+		return issuers, nil
+	}
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory '%s': %s", folder, err)
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		filename := filepath.Join(folder, fi.Name())
+		issuer, err := common.ReadCertificate(filename)
+		if err != nil {
+			logger.Err("Failed to read issuer '%s': %s", filename, err)
+			continue
+		}
+		issuers = append(issuers, issuer)
+	}
+	return issuers, nil
+}
+
+// issuerResponders parses the default responders for each issuer
+func issuerResponders(conf *config.Configuration) ([]mcache.IssuerResponders, error) {
+	var defaults []mcache.IssuerResponders
+	for i, ir := range conf.Definitions.IssuerResponders {
+		skid, err := hex.DecodeString(strings.Replace(ir.SKID, ":", "", -1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid skid for definitions.issuer-responders[%d]: %s", i, err)
+		}
+		if len(skid) == 0 && ir.Subject == "" {
+			return nil, fmt.Errorf("definitions.issuer-responders[%d] needs a skid or subject", i)
+		}
+		defaults = append(defaults, mcache.IssuerResponders{SKID: skid, Subject: ir.Subject, Responders: ir.Responders})
+	}
+	return defaults, nil
+}
+
+// upstreamConfig returns the settings for upstream connections
+// described by conf
+func upstreamConfig(conf *config.Configuration) (*upstreamSettings, error) {
+	dial, err := fetcherDial(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure fetcher local address: %s", err)
+	}
+	proxyTLS, err := rootsConfig(conf.Fetcher.ProxyCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fetcher.proxy-ca: %s", err)
+	}
+	responderTLS, err := rootsConfig(conf.Fetcher.ResponderCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fetcher.responder-ca: %s", err)
+	}
+	roots, err := parseResponderRoots(conf.Fetcher.ResponderCAs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fetcher.responder-cas: %s", err)
+	}
+	pins, err := parseResponderPins(conf.Fetcher.ResponderPins)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fetcher.responder-pins: %s", err)
+	}
+	return &upstreamSettings{
+		dial:         dial,
+		proxyTLS:     proxyTLS,
+		responderTLS: responderTLS,
+		noProxy:      conf.Fetcher.NoProxy,
+		pins:         pins,
+		roots:        roots,
+	}, nil
+}
+
+// watchFolderOption creates the option for a watch folder, see
+// watchFolderCertOptions
+func watchFolderOption(wf config.WatchFolder, upstream *upstreamSettings, defaultUnknown string) (Option, error) {
+	if wf.Folder == "" {
+		return nil, errors.New("watch folder has no folder")
+	}
+	opts, err := watchFolderCertOptions(wf, upstream, defaultUnknown)
+	if err != nil {
+		return nil, err
+	}
+	return WithCertFolderOptions(wf.Folder, opts), nil
+}
+
+// watchFolderCertOptions creates the options for certificates in a
+// watch folder, loading its issuer and creating a client if it has
+// its own proxies
+func watchFolderCertOptions(wf config.WatchFolder, upstream *upstreamSettings, defaultUnknown string) (mcache.CertificateOptions, error) {
+	extensions, err := requestExtensions(wf.RequestExtensions)
+	if err != nil {
+		return mcache.CertificateOptions{}, fmt.Errorf("invalid request extension for watch folder '%s': %s", wf.Folder, err)
+	}
+	opts := mcache.CertificateOptions{
+		Responders:        wf.Responders,
+		Labels:            wf.Labels,
+		RequestExtensions: extensions,
+		Nonce:             wf.Nonce,
+	}
+	if opts.UnknownPolicy, err = unknownPolicy(wf.UnknownStatus, defaultUnknown); err != nil {
+		return opts, fmt.Errorf("invalid unknown-status for watch folder '%s': %s", wf.Folder, err)
+	}
+	if wf.ResponseName != "" {
+		if opts.ResponseName, err = mcache.ParseResponseName(wf.ResponseName); err != nil {
+			return opts, fmt.Errorf("invalid response name for watch folder '%s': %s", wf.Folder, err)
+		}
+	}
+	if wf.Issuer != "" {
+		issuer, err := common.ReadCertificate(wf.Issuer)
+		if err != nil {
+			return opts, fmt.Errorf("failed to load issuer '%s' for watch folder '%s': %s", wf.Issuer, wf.Folder, err)
+		}
+		opts.Issuer = issuer
+	}
+	if len(wf.Proxies) > 0 {
+		proxyFunc, err := common.ProxyFunc(wf.Proxies)
+		if err != nil {
+			return opts, fmt.Errorf("failed to configure proxies for watch folder '%s': %s", wf.Folder, err)
+		}
+		opts.Client = newClient(proxyFunc, upstream)
+	}
+	return opts, nil
+}
+
+// certDefinitionOptions creates the options for a certificate
+// definition, loading its issuer
+func certDefinitionOptions(def config.CertDefinition, defaultUnknown string) (mcache.CertificateOptions, error) {
+	var opts mcache.CertificateOptions
+	if def.Issuer != "" {
+		issuer, err := common.ReadCertificate(def.Issuer)
+		if err != nil {
+			return opts, fmt.Errorf("failed to load issuer '%s': %s", def.Issuer, err)
+		}
+		opts.Issuer = issuer
+	}
+	extensions, err := requestExtensions(def.RequestExtensions)
+	if err != nil {
+		return opts, fmt.Errorf("invalid request extension for '%s': %s", def.Certificate, err)
+	}
+	opts.Responders = def.Responders
+	opts.RequestExtensions = extensions
+	opts.Nonce = def.Nonce
+	if opts.UnknownPolicy, err = unknownPolicy(def.UnknownStatus, defaultUnknown); err != nil {
+		return opts, fmt.Errorf("invalid unknown-status for '%s': %s", def.Certificate, err)
+	}
+	if def.ResponseName != "" {
+		if opts.ResponseName, err = mcache.ParseResponseName(def.ResponseName); err != nil {
+			return opts, fmt.Errorf("invalid response name for '%s': %s", def.Certificate, err)
+		}
+	}
+	return opts, nil
+}
+
+// noResponderPolicy parses definitions.no-responder-policy
+func noResponderPolicy(conf *config.Configuration) (mcache.NoResponderPolicy, error) {
+	switch conf.Definitions.NoResponderPolicy {
+	case "", "warn":
+		return mcache.WarnNoResponders, nil
+	case "skip":
+		return mcache.SkipNoResponders, nil
+	case "fail":
+		return mcache.FailNoResponders, nil
+	}
+	return 0, fmt.Errorf("unknown definitions.no-responder-policy '%s'", conf.Definitions.NoResponderPolicy)
+}
+
+// unknownPolicy parses the unknown-status of a certificate or watch
+// folder, using fallback if it isn't set
+func unknownPolicy(name, fallback string) (mcache.UnknownPolicy, error) {
+	if name == "" {
+		name = fallback
+	}
+	return mcache.ParseUnknownPolicy(name)
+}
+
+// defaultResponderCheckDepth is how many delegated responder
+// certificates are checked to verify a response if
+// fetcher.responder-check-depth isn't set
+const defaultResponderCheckDepth = 2
+
+// defaultRefreshLease is how long a instance keeps the lease on
+// refreshing a entry if cluster.refresh-lease isn't set
+const defaultRefreshLease = 10 * time.Minute
+
+// defaultRetryBudgetMin is how many retries a minute to each responder
+// host are always allowed when a retry budget is set
+const defaultRetryBudgetMin = 10
+
+// defaults for stable-backings.write-behind
+const (
+	defaultWriteBehindQueueSize   = 10000
+	defaultWriteBehindBatchSize   = 100
+	defaultWriteBehindInterval    = time.Second
+	defaultWriteBehindMaxAttempts = 5
+)
+
+// writeBehind wraps each of the stable backings in a
+// scache.WriteBehind as configured by stable-backings.write-behind
+func writeBehind(conf *config.Configuration, logger *log.Logger, clk clock.Clock, backings []scache.Cache) ([]scache.Cache, []*scache.WriteBehind) {
+	wbConf := conf.StableBackings.WriteBehind
+	if !wbConf.Enabled {
+		return backings, nil
+	}
+	queueSize, batchSize := wbConf.QueueSize, wbConf.BatchSize
+	if queueSize == 0 {
+		queueSize = defaultWriteBehindQueueSize
+	}
+	if batchSize == 0 {
+		batchSize = defaultWriteBehindBatchSize
+	}
+	interval := wbConf.Interval.Duration
+	if interval == 0 {
+		interval = defaultWriteBehindInterval
+	}
+	maxAttempts := wbConf.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultWriteBehindMaxAttempts
+	}
+	wrapped := make([]scache.Cache, len(backings))
+	queues := make([]*scache.WriteBehind, len(backings))
+	for i, b := range backings {
+		queues[i] = scache.NewWriteBehind(logger, clk, b, queueSize, batchSize, interval, maxAttempts)
+		wrapped[i] = queues[i]
+	}
+	return wrapped, queues
+}
+
+// fetchBackoff returns the backoff upstream requests are retried
+// with, without a retry budget
+func fetchBackoff(conf *config.Configuration) (stapledOCSP.Backoff, error) {
+	backoff := stapledOCSP.Backoff{
+		Delay:  conf.Fetcher.Backoff.Duration,
+		Jitter: conf.Fetcher.BackoffJitter,
+	}
+	for name, bc := range conf.Fetcher.BackoffClasses {
+		class, err := stapledOCSP.ParseFailureClass(name)
+		if err != nil {
+			return stapledOCSP.Backoff{}, fmt.Errorf("invalid fetcher.backoff-classes: %s", err)
+		}
+		policy := stapledOCSP.ClassPolicy{Delay: bc.Delay.Duration, Attempts: bc.Attempts}
+		switch class {
+		case stapledOCSP.NetworkFailure:
+			backoff.Network = policy
+		case stapledOCSP.ServerFailure:
+			backoff.Server = policy
+		case stapledOCSP.ClientFailure:
+			backoff.Client = policy
+		case stapledOCSP.ResponseFailure:
+			backoff.Response = policy
+		}
+	}
+	methods := make(map[string]stapledOCSP.Method, len(conf.Fetcher.ResponderMethods))
+	for u, name := range conf.Fetcher.ResponderMethods {
+		method, err := stapledOCSP.ParseMethod(name)
+		if err != nil {
+			return stapledOCSP.Backoff{}, fmt.Errorf("invalid fetcher.responder-methods: %s", err)
+		}
+		methods[u] = method
+	}
+	backoff.Methods = stapledOCSP.NewRequestMethods(methods)
+	if len(conf.Fetcher.NonceResponders) > 0 {
+		if conf.LightweightProfile {
+			return stapledOCSP.Backoff{}, errors.New("fetcher.nonce-responders isn't allowed by lightweight-profile")
+		}
+		backoff.Nonces = &stapledOCSP.Nonces{Responders: make(map[string]bool, len(conf.Fetcher.NonceResponders))}
+		for _, u := range conf.Fetcher.NonceResponders {
+			backoff.Nonces.Responders[strings.TrimSuffix(u, "/")] = true
+		}
+	}
+	return backoff, nil
+}
+
+// clusterIdentity returns the instance ID and refresh lease used for
+// leader election
+func clusterIdentity(conf *config.Configuration) (string, time.Duration, error) {
+	if conf.Disk.CacheFolder == "" {
+		return "", 0, errors.New("cluster.leader-election requires disk.cache-folder to be set to a folder shared between instances")
+	}
+	owner := conf.Cluster.InstanceID
+	if owner == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to get hostname for cluster.instance-id: %s", err)
+		}
+		owner = hostname
+	}
+	if strings.ContainsAny(owner, " \t\n/") {
+		return "", 0, fmt.Errorf("cluster.instance-id '%s' must not contain whitespace or slashes", owner)
+	}
+	lease := conf.Cluster.RefreshLease.Duration
+	if lease == 0 {
+		lease = defaultRefreshLease
+	}
+	return owner, lease, nil
+}
+
+// requestExtensions parses the request extensions for a certificate
+// definition or watch folder
+func requestExtensions(defs []config.RequestExtension) ([]pkix.Extension, error) {
+	var extensions []pkix.Extension
+	for _, def := range defs {
+		ext, err := stapledOCSP.ParseRequestExtension(def.OID, def.Value, def.Critical)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, ext)
+	}
+	return extensions, nil
+}
+
+// notifierTarget creates the target described by the i'th notifier
+// definition
+func notifierTarget(i int, def config.NotifierDefinition, client *http.Client) (notify.Target, error) {
+	target := notify.Target{Name: fmt.Sprintf("%s[%d]", def.Type, i)}
+	for _, name := range def.Events {
+		kind, err := notify.ParseKind(name)
+		if err != nil {
+			return notify.Target{}, err
+		}
+		target.Kinds = append(target.Kinds, kind)
+	}
+	switch def.Type {
+	case "webhook":
+		target.Notifier = &notify.Webhook{URL: def.URL, Client: client}
+	case "slack":
+		target.Notifier = &notify.Slack{URL: def.URL, Client: client}
+	case "pagerduty":
+		target.Notifier = &notify.PagerDuty{RoutingKey: def.RoutingKey, URL: def.URL, Client: client}
+	default:
+		return notify.Target{}, fmt.Errorf("unknown type '%s'", def.Type)
+	}
+	if def.URL == "" && def.Type != "pagerduty" {
+		return notify.Target{}, errors.New("url is required")
+	}
+	if def.RoutingKey == "" && def.Type == "pagerduty" {
+		return notify.Target{}, errors.New("routing-key is required")
+	}
+	return target, nil
+}
+
+// notificationsOption creates the option that enables notifications
+// if any notifiers are configured
+func notificationsOption(conf *config.Configuration, logger *log.Logger, clk clock.Clock) (Option, error) {
+	nc := conf.Notifications
+	client := &http.Client{Timeout: 10 * time.Second}
+	targets := []notify.Target{}
+	for i, def := range nc.Notifiers {
+		target, err := notifierTarget(i, def, client)
+		if err != nil {
+			return nil, fmt.Errorf("notifications.notifiers[%d]: %s", i, err)
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	interval := time.Minute
+	if nc.Interval.Duration != 0 {
+		interval = nc.Interval.Duration
+	}
+	thresholds := NotifyThresholds{
+		RefreshFailingAfter: time.Hour,
+		CertExpiringWithin:  14 * 24 * time.Hour,
+	}
+	if nc.RefreshFailingAfter.Duration != 0 {
+		thresholds.RefreshFailingAfter = nc.RefreshFailingAfter.Duration
+	}
+	if nc.CertExpiringWithin.Duration != 0 {
+		thresholds.CertExpiringWithin = nc.CertExpiringWithin.Duration
+	}
+	dedupWindow := 6 * time.Hour
+	if nc.DedupWindow.Duration != 0 {
+		dedupWindow = nc.DedupWindow.Duration
+	}
+	maxPerHour := 30
+	if nc.MaxPerHour != 0 {
+		maxPerHour = nc.MaxPerHour
+	}
+	d := notify.NewDispatcher(logger, clk, dedupWindow, maxPerHour, targets...)
+	return WithNotifications(d, interval, thresholds), nil
+}
+
+// s3Backing creates the S3 backing, if no credentials are configured
+// the standard AWS environment variables are used
+func s3Backing(conf *config.Configuration, logger *log.Logger, clk clock.Clock) (*scache.S3Cache, error) {
+	s3 := conf.StableBackings.S3
+	creds := cloud.AWSCredentials{
+		AccessKeyID:     s3.AccessKeyID,
+		SecretAccessKey: s3.SecretAccessKey,
+		SessionToken:    s3.SessionToken,
+	}
+	if creds.AccessKeyID == "" {
+		creds = cloud.AWSCredentialsFromEnv()
+	}
+	return scache.NewS3(logger, clk, scache.S3Options{
+		Bucket:            s3.Bucket,
+		Prefix:            s3.Prefix,
+		Region:            s3.Region,
+		Endpoint:          s3.Endpoint,
+		Credentials:       creds,
+		Timeout:           s3.Timeout.Duration,
+		ConsistencyWindow: s3.ConsistencyWindow.Duration,
+	})
+}
+
+// externalBacking loads a plugin or creates a helper process backing
+func externalBacking(eb config.ExternalBacking, logger *log.Logger, clk clock.Clock) (scache.Cache, error) {
+	if eb.Plugin != "" {
+		return scache.LoadPlugin(eb.Plugin, eb.Options)
+	}
+	return scache.NewProcess(logger, clk, eb.Command, eb.Timeout.Duration)
+}
+
+// diskEncryptionKey reads the disk cache encryption key from the
+// configured source, it returns nil if encryption isn't enabled
+func diskEncryptionKey(conf *config.Configuration) ([]byte, error) {
+	d := conf.Disk
+	sources := 0
+	for _, set := range []bool{d.EncryptionKeyFile != "", d.EncryptionKeyEnv != "", len(d.EncryptionKeyCommand) > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, errors.New("only one of disk.encryption-key-file, disk.encryption-key-env, and disk.encryption-key-command can be set")
+	}
+	var encoded string
+	switch {
+	case d.EncryptionKeyFile != "":
+		contents, err := ioutil.ReadFile(d.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(contents)
+	case d.EncryptionKeyEnv != "":
+		encoded = os.Getenv(d.EncryptionKeyEnv)
+		if encoded == "" {
+			return nil, fmt.Errorf("environment variable '%s' is empty", d.EncryptionKeyEnv)
+		}
+	case len(d.EncryptionKeyCommand) > 0:
+		output, err := exec.Command(d.EncryptionKeyCommand[0], d.EncryptionKeyCommand[1:]...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("key command failed: %s", err)
+		}
+		encoded = string(output)
+	default:
+		return nil, nil
+	}
+	return scache.ParseKey(encoded)
+}
+
+// configureHashes restricts the hashes that can be created to
+// common.ApprovedHashes if conf requires it
+func configureHashes(conf *config.Configuration) {
+	if conf.ApprovedHashesOnly {
+		common.RestrictHashes(common.ApprovedHashes)
+	} else {
+		common.RestrictHashes(nil)
+	}
+}
+
+// sha1Disabled checks if conf disables SHA-1, either directly or by
+// only allowing approved hashes
+func sha1Disabled(conf *config.Configuration) bool {
+	return conf.DisableSHA1 || conf.ApprovedHashesOnly
+}
+
+// withoutSHA1 returns hashes with SHA-1 removed
+func withoutSHA1(hashes config.SupportedHashes) config.SupportedHashes {
+	filtered := config.SupportedHashes{}
+	for _, h := range hashes {
+		if h != crypto.SHA1 {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered
+}
+
+// responderTLS returns the TLS settings of the responder, the zero
+// value if it isn't served over TLS
+func responderTLS(conf *config.Configuration) ResponderTLS {
+	return ResponderTLS{
+		Certificate:       conf.HTTP.TLS.Certificate,
+		Key:               conf.HTTP.TLS.Key,
+		ClientCA:          conf.HTTP.TLS.ClientCA,
+		RequireClientCert: conf.HTTP.TLS.RequireClientCert,
+	}
+}
+
+// EnabledFeatures returns the names of the optional features
+// enabled by the configuration
+func EnabledFeatures(conf *config.Configuration) []string {
+	features := []string{}
+	if conf.HTTP.TLS.Certificate != "" {
+		features = append(features, "responder-tls")
+		if conf.HTTP.TLS.ClientCA != "" {
+			features = append(features, "responder-client-certs")
+		}
+	}
+	if conf.Disk.CacheFolder != "" {
+		features = append(features, "disk-cache")
+		if conf.Disk.ControlFiles {
+			features = append(features, "control-files")
+		}
+		if conf.Disk.CheckConsistency {
+			features = append(features, "disk-check")
+		}
+		if conf.Disk.EncryptionKeyFile != "" || conf.Disk.EncryptionKeyEnv != "" || len(conf.Disk.EncryptionKeyCommand) > 0 {
+			features = append(features, "disk-encryption")
+		}
+	}
+	if conf.Definitions.CertWatchFolder != "" || len(conf.Definitions.CertWatchFolders) > 0 {
+		features = append(features, "cert-watch-folder")
+	}
+	if conf.Definitions.Manifest != "" {
+		features = append(features, "manifest")
+	}
+	if len(conf.Definitions.IssuerResponders) > 0 {
+		features = append(features, "issuer-responders")
+	}
+	if len(conf.Fetcher.Proxies) > 0 {
+		features = append(features, "proxies")
+	} else if conf.Fetcher.ProxyPAC != "" {
+		features = append(features, "proxy-pac")
+	}
+	if len(conf.Fetcher.UpstreamResponders) > 0 {
+		features = append(features, "upstream-responders")
+	}
+	if conf.Fetcher.RetryBudget > 0 {
+		features = append(features, "retry-budget")
+	}
+	if conf.Fetcher.LocalAddr != "" || len(conf.Fetcher.ProxyLocalAddrs) > 0 || len(conf.Fetcher.ResponderLocalAddrs) > 0 {
+		features = append(features, "local-addr")
+	}
+	if conf.Fetcher.ProxyCA != "" || conf.Fetcher.ResponderCA != "" || len(conf.Fetcher.ResponderCAs) > 0 {
+		features = append(features, "upstream-ca")
+	}
+	if len(conf.Cloud.AWSACM) > 0 {
+		features = append(features, "aws-acm")
+	}
+	if len(conf.Cloud.GCP) > 0 {
+		features = append(features, "gcp")
+	}
+	if conf.HTTP.RequestCacheSize > 0 {
+		features = append(features, "request-cache")
+	}
+	if conf.Clock.MaxOffset.Duration > 0 {
+		features = append(features, "clock-check")
+	}
+	if conf.HTTP.AccessLog.Enabled {
+		features = append(features, "access-log")
+	}
+	if conf.HTTP.ClientInventory.Enabled {
+		features = append(features, "client-inventory")
+	}
+	if conf.Fetcher.Quorum.Enabled {
+		features = append(features, "quorum")
+	}
+	if conf.HTTP.MissLog.Enabled {
+		features = append(features, "miss-log")
+	}
+	if conf.HTTP.Shadow.Rate > 0 {
+		features = append(features, "shadow")
+	}
+	if policy := conf.HTTP.ExpiredCertificates.Policy; policy != "" && policy != "serve" {
+		features = append(features, "expired-"+policy)
+	}
+	if conf.Metrics.StatsDAddr != "" {
+		features = append(features, "statsd")
+	}
+	if conf.Metrics.Prometheus {
+		features = append(features, "prometheus")
+	}
+	if conf.Tracing.OTLPEndpoint != "" {
+		features = append(features, "otlp-tracing")
+	}
+	if configFaults(conf).Enabled() {
+		features = append(features, "fault-injection")
+	}
+	if conf.Admin.Addr != "" {
+		features = append(features, "admin")
+	}
+	if conf.Admin.Socket != "" {
+		features = append(features, "control-socket")
+	}
+	if conf.Syslog.StatsInterval.Duration > 0 {
+		features = append(features, "stats-log")
+	}
+	if conf.DNS.Addr != "" {
+		features = append(features, "dns")
+	}
+	if len(conf.Notifications.Notifiers) > 0 {
+		features = append(features, "notifications")
+	}
+	if conf.GameDay.Interval.Duration > 0 {
+		features = append(features, "game-day")
+	}
+	if sha1Disabled(conf) {
+		features = append(features, "no-sha1")
+	}
+	if conf.ApprovedHashesOnly {
+		features = append(features, "approved-hashes-only")
+	}
+	if conf.LightweightProfile {
+		features = append(features, "lightweight-profile")
+	}
+	if conf.Cluster.LeaderElection {
+		features = append(features, "leader-election")
+	}
+	if conf.Cluster.Standby.Enabled {
+		features = append(features, "standby")
+	}
+	if len(conf.Fetcher.ResponderPins) > 0 {
+		features = append(features, "responder-pins")
+	}
+	if conf.StableBackings.WriteBehind.Enabled {
+		features = append(features, "write-behind")
+	}
+	if len(conf.StableBackings.External) > 0 {
+		features = append(features, "external-backings")
+	}
+	if conf.StableBackings.S3.Bucket != "" {
+		features = append(features, "s3")
+	}
+	if conf.Export.BundlePath != "" {
+		features = append(features, "bundle-export")
+	}
+	if conf.Export.PackPath != "" {
+		features = append(features, "pack-export")
+	}
+	if conf.Export.IssuerFolder != "" {
+		features = append(features, "issuer-export")
+	}
+	if conf.Replica.PackedResponses != "" {
+		features = append(features, "packed-responses")
+	}
+	return features
+}
+
+// cloudOptions returns the options for the cloud sources in conf,
+// their entries use the unknown policy
+func cloudOptions(conf *config.Configuration, unknown mcache.UnknownPolicy) []Option {
+	var opts []Option
+	client := &http.Client{Timeout: time.Minute}
+	for _, src := range conf.Cloud.AWSACM {
+		creds := cloud.AWSCredentials{
+			AccessKeyID:     src.AccessKeyID,
+			SecretAccessKey: src.SecretAccessKey,
+			SessionToken:    src.SessionToken,
+		}
+		if creds.AccessKeyID == "" {
+			creds = cloud.AWSCredentialsFromEnv()
+		}
+		acm := &cloud.ACM{Region: src.Region, Credentials: creds, Client: client}
+		opts = append(opts, WithCloudSource(acm, mcache.CertificateOptions{Labels: src.Labels, UnknownPolicy: unknown}))
+	}
+	for _, src := range conf.Cloud.GCP {
+		gcp := &cloud.GCP{Project: src.Project, Client: client}
+		opts = append(opts, WithCloudSource(gcp, mcache.CertificateOptions{Labels: src.Labels, UnknownPolicy: unknown}))
+	}
+	if len(opts) > 0 && conf.Cloud.Interval.Duration != 0 {
+		opts = append(opts, WithCloudInterval(conf.Cloud.Interval.Duration))
+	}
+	return opts
+}
+
+// NewFromConfig creates the cache described by conf, loads the
+// configured certificate definitions into it, and returns a Server
+// that serves from it. Any extra options are applied after those
+// derived from conf
+func NewFromConfig(conf *config.Configuration, logger *log.Logger, clk clock.Clock, extra ...Option) (*Server, error) {
+	fdLimit, err := applyLimits(conf, logger)
+	if err != nil {
+		return nil, err
+	}
+	configureHashes(conf)
+	timeout := time.Second * time.Duration(10)
+	if conf.Fetcher.Timeout.Duration != 0 {
+		timeout = conf.Fetcher.Timeout.Duration
+	}
+	monitorTick := time.Minute
+	if conf.Fetcher.MonitorInterval.Duration != 0 {
+		monitorTick = conf.Fetcher.MonitorInterval.Duration
+	}
+
+	proxyFunc, err := proxySource(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure proxies: %s", err)
+	}
+	upstream, err := upstreamConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	transport := newReloadableTransport(proxyFunc, conf.Fetcher.ResponderRewrites, upstream)
+	client := &http.Client{Transport: transport}
+	faults := configFaults(conf)
+	if faults.Enabled() {
+		logger.Warning("Fault injection is enabled, this must never be used in production: %+v", faults)
+	}
+	injectFetchFaults(client, faults)
+
+	stableBackings := []scache.Cache{}
+	var disk *scache.DiskCache
+	if conf.Disk.CacheFolder != "" {
+		key, err := diskEncryptionKey(conf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load disk encryption key: %s", err)
+		}
+		if key != nil {
+			if disk, err = scache.NewEncryptedDisk(logger, clk, conf.Disk.CacheFolder, key); err != nil {
+				return nil, err
+			}
+		} else {
+			disk = scache.NewDisk(logger, clk, conf.Disk.CacheFolder)
+		}
+		stableBackings = append(stableBackings, disk)
+	}
+	if conf.StableBackings.S3.Bucket != "" {
+		s3, err := s3Backing(conf, logger, clk)
+		if err != nil {
+			return nil, fmt.Errorf("stable-backings.s3: %s", err)
+		}
+		stableBackings = append(stableBackings, s3)
+	}
+	for i, eb := range conf.StableBackings.External {
+		backing, err := externalBacking(eb, logger, clk)
+		if err != nil {
+			return nil, fmt.Errorf("stable-backings.external[%d]: %s", i, err)
+		}
+		stableBackings = append(stableBackings, backing)
+	}
+
+	issuers, err := loadIssuers(conf.Definitions.IssuerFolder, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := conf.SupportedHashes
+	if sha1Disabled(conf) {
+		hashes = withoutSHA1(hashes)
+		if len(hashes) == 0 {
+			return nil, errors.New("SHA-1 is disabled but it is the only supported hash")
+		}
+	}
+
+	stableBackings, writeQueues := writeBehind(conf, logger, clk, stableBackings)
+	stableBackings = injectStableFaults(stableBackings, faults)
+	c := mcache.NewEntryCache(clk, logger, monitorTick, stableBackings, client, timeout, issuers, hashes, false)
+	if sha1Disabled(conf) {
+		c.SetRequestHash(crypto.SHA256)
+	}
+	if conf.LightweightProfile {
+		c.SetLightweightProfile(true)
+	}
+	c.SetMaxConcurrentRefreshes(conf.Fetcher.MaxConcurrentRefreshes)
+	switch conf.StableBackings.Selection {
+	case "", "first":
+		c.SetStableSelection(mcache.FirstStable)
+	case "freshest":
+		c.SetStableSelection(mcache.FreshestStable)
+	default:
+		return nil, fmt.Errorf("unknown stable-backings.selection '%s'", conf.StableBackings.Selection)
+	}
+	switch conf.Fetcher.ResponderCheck {
+	case "", "trust":
+	case "warn":
+		c.SetResponderCheck(stapledOCSP.WarnResponder, 0)
+	case "check":
+		depth := conf.Fetcher.ResponderCheckDepth
+		if depth == 0 {
+			depth = defaultResponderCheckDepth
+		}
+		c.SetResponderCheck(stapledOCSP.CheckResponder, depth)
+	default:
+		return nil, fmt.Errorf("unknown fetcher.responder-check '%s'", conf.Fetcher.ResponderCheck)
+	}
+	if conf.Cluster.LeaderElection {
+		owner, lease, err := clusterIdentity(conf)
+		if err != nil {
+			return nil, err
+		}
+		c.SetLeaderElection(owner, lease)
+	}
+	if conf.Cluster.Standby.Enabled {
+		c.SetStandby()
+	}
+	defaultResponders, err := issuerResponders(conf)
+	if err != nil {
+		return nil, err
+	}
+	c.SetIssuerResponders(defaultResponders)
+	noResponders, err := noResponderPolicy(conf)
+	if err != nil {
+		return nil, err
+	}
+	c.SetNoResponderPolicy(noResponders)
+	if max := conf.Definitions.MaxIssuers; max != 0 {
+		if max < 0 {
+			max = 0
+		}
+		c.SetMaxIssuers(max)
+	}
+	if conf.StableBackings.MissMemo.Duration != 0 {
+		c.SetStableMissMemo(conf.StableBackings.MissMemo.Duration)
+	}
+	c.SetRefreshRamp(conf.Fetcher.RampUpInterval.Duration, conf.Fetcher.RampUpThreshold)
+	c.SetDriftWarning(conf.Fetcher.DriftWarning.Duration)
+	if size := conf.Fetcher.HistorySize; size != 0 {
+		if size < 0 {
+			size = 0
+		}
+		c.SetFetchHistory(size)
+	}
+	if conf.Fetcher.Quorum.Enabled {
+		quorum := &mcache.Quorum{}
+		if conf.Fetcher.Quorum.Proxy != "" {
+			proxy, err := url.Parse(conf.Fetcher.Quorum.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fetcher.quorum.proxy: %s", err)
+			}
+			quorum.Client = &http.Client{Transport: transport.withProxy(proxy)}
+		}
+		c.SetQuorum(quorum)
+	}
+	sink, metricsOpts, err := metricsOptions(conf, logger)
+	if err != nil {
+		return nil, err
+	}
+	c.SetMetrics(sink)
+	for _, wb := range writeQueues {
+		wb.SetMetrics(sink)
+	}
+	if len(writeQueues) > 0 {
+		metricsOpts = append(metricsOpts, WithWriteBehind(writeQueues...))
+	}
+	if conf.Tracing.OTLPEndpoint != "" {
+		exporter, err := tracing.NewOTLPExporter(conf.Tracing.OTLPEndpoint, conf.Tracing.Headers, conf.Tracing.ServiceName, conf.Tracing.Interval.Duration, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure tracing: %s", err)
+		}
+		metricsOpts = append(metricsOpts, WithOTLPExporter(exporter))
+	}
+	backoff, err := fetchBackoff(conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Fetcher.RetryBudget > 0 {
+		minRetries := conf.Fetcher.RetryBudgetMin
+		if minRetries == 0 {
+			minRetries = defaultRetryBudgetMin
+		}
+		backoff.Budget = stapledOCSP.NewRetryBudget(clk, conf.Fetcher.RetryBudget, minRetries)
+	}
+	c.SetFetchBackoff(backoff)
+
+	defaultUnknown, err := mcache.ParseUnknownPolicy(conf.Definitions.UnknownStatus)
+	if err != nil {
+		return nil, fmt.Errorf("invalid definitions.unknown-status: %s", err)
+	}
+	expiredPolicy, err := ParseExpiredPolicy(conf.HTTP.ExpiredCertificates.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http.expired-certificates.policy: %s", err)
+	}
+	logger.Info("Loading certificates")
+	for _, def := range conf.Definitions.Certificates {
+		certOpts, err := certDefinitionOptions(def, conf.Definitions.UnknownStatus)
+		if err != nil {
+			return nil, err
+		}
+		err = c.AddFromCertificateWithOptions(def.Certificate, certOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load entry: %s", err)
+		}
+	}
+
+	opts := []Option{
+		WithCache(c),
+		WithLogger(logger),
+		WithClock(clk),
+		WithResponderAddr(conf.HTTP.Addr),
+		WithUpstreamResponders(conf.Fetcher.UpstreamResponders),
+		WithCertFolderOptions(conf.Definitions.CertWatchFolder, mcache.CertificateOptions{UnknownPolicy: defaultUnknown}),
+		WithFeatures(EnabledFeatures(conf)),
+		WithReloadableTransport(transport),
+		WithFileDescriptorLimit(fdLimit),
+		WithRequestCache(conf.HTTP.RequestCacheSize),
+		WithAccessLog(AccessLog{
+			Enabled:       conf.HTTP.AccessLog.Enabled,
+			HitSample:     conf.HTTP.AccessLog.HitSample,
+			HashClientIPs: conf.HTTP.AccessLog.HashClientIPs,
+		}),
+		WithClientInventory(ClientInventory{
+			Enabled:    conf.HTTP.ClientInventory.Enabled,
+			MaxSerials: conf.HTTP.ClientInventory.MaxSerials,
+			MaxClients: conf.HTTP.ClientInventory.MaxClients,
+		}),
+		WithMissLog(MissLog{
+			Enabled:     conf.HTTP.MissLog.Enabled,
+			MaxRequests: conf.HTTP.MissLog.MaxRequests,
+		}),
+		WithExpiredCertificates(expiredPolicy, conf.HTTP.ExpiredCertificates.Grace.Duration),
+	}
+	for _, wf := range conf.Definitions.CertWatchFolders {
+		opt, err := watchFolderOption(wf, upstream, conf.Definitions.UnknownStatus)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	opts = append(opts, cloudOptions(conf, defaultUnknown)...)
+	if rt := responderTLS(conf); rt != (ResponderTLS{}) {
+		opts = append(opts, WithResponderTLS(rt))
+	}
+	if conf.HTTP.Shadow.Rate > 0 {
+		opts = append(opts, WithShadowMode(Shadow{
+			Rate:          conf.HTTP.Shadow.Rate,
+			Timeout:       conf.HTTP.Shadow.Timeout.Duration,
+			MaxConcurrent: conf.HTTP.Shadow.MaxConcurrent,
+		}))
+	}
+	if conf.Cluster.Standby.Enabled {
+		opts = append(opts, WithStandby(Standby{
+			Active:   conf.Cluster.Standby.Active,
+			Interval: conf.Cluster.Standby.Interval.Duration,
+		}))
+	}
+	if conf.Definitions.Manifest != "" {
+		opts = append(opts, WithManifest(conf.Definitions.Manifest, mcache.CertificateOptions{UnknownPolicy: defaultUnknown}))
+	}
+	if conf.Definitions.CertWatchInterval.Duration != 0 {
+		opts = append(opts, WithCertFolderInterval(conf.Definitions.CertWatchInterval.Duration))
+	}
+	if conf.Disk.ControlFiles {
+		if conf.Disk.CacheFolder == "" {
+			return nil, errors.New("disk.control-files requires disk.cache-folder to be set")
+		}
+		controlFolder := filepath.Join(conf.Disk.CacheFolder, "control")
+		if err = os.MkdirAll(controlFolder, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create control folder '%s': %s", controlFolder, err)
+		}
+		opts = append(opts, WithControlFolder(controlFolder))
+	}
+	if conf.Disk.CheckConsistency {
+		if disk == nil {
+			return nil, errors.New("disk.check-consistency requires disk.cache-folder to be set")
+		}
+		opts = append(opts, WithDiskCheck(disk, conf.Disk.PruneOrphans))
+	}
+	notifications, err := notificationsOption(conf, logger, clk)
+	if err != nil {
+		return nil, err
+	}
+	if notifications != nil {
+		opts = append(opts, notifications)
+	}
+	if sha1Disabled(conf) {
+		opts = append(opts, WithoutSHA1())
+	}
+	if conf.LightweightProfile {
+		opts = append(opts, WithLightweightProfile())
+	}
+	if faults.ResponderDelay > 0 {
+		opts = append(opts, WithResponderDelay(faults.ResponderDelay))
+	}
+	if conf.Export.BundlePath != "" {
+		format := conf.Export.BundleFormat
+		if format == "" {
+			format = BundleConcat
+		}
+		opts = append(opts, WithBundleExport(conf.Export.BundlePath, format, conf.Export.Interval.Duration))
+	}
+	if conf.Export.PackPath != "" {
+		opts = append(opts, WithPackExport(conf.Export.PackPath, conf.Export.Interval.Duration))
+	}
+	if conf.Export.IssuerFolder != "" {
+		opts = append(opts, WithIssuerExport(conf.Export.IssuerFolder, conf.Export.Interval.Duration))
+	}
+	if conf.Replica.PackedResponses != "" {
+		opts = append(opts, WithPackedResponses(conf.Replica.PackedResponses, conf.Replica.CheckInterval.Duration))
+	}
+	if conf.Admin.Addr != "" {
+		opts = append(opts, WithAdminAddr(conf.Admin.Addr))
+	}
+	if conf.Admin.Socket != "" {
+		opts = append(opts, WithControlSocket(conf.Admin.Socket))
+	}
+	if conf.Syslog.StatsInterval.Duration > 0 {
+		opts = append(opts, WithStatsInterval(conf.Syslog.StatsInterval.Duration))
+	}
+	if conf.Clock.MaxOffset.Duration > 0 {
+		opts = append(opts, WithClockCheck(ClockCheck{
+			URLs:      conf.Clock.URLs,
+			Threshold: conf.Clock.MaxOffset.Duration,
+			Interval:  conf.Clock.Interval.Duration,
+			Strict:    conf.Clock.Strict,
+		}))
+	}
+	if conf.GameDay.Interval.Duration > 0 {
+		opts = append(opts, WithGameDay(GameDay{
+			Interval: conf.GameDay.Interval.Duration,
+			Sample:   conf.GameDay.Sample,
+			Timeout:  conf.GameDay.Timeout.Duration,
+			Proxies:  conf.Fetcher.Proxies,
+		}))
+	}
+	if conf.DNS.Addr != "" {
+		opts = append(opts, WithDNS(conf.DNS.Addr, conf.DNS.Zone))
+	}
+	opts = append(opts, metricsOpts...)
+	return NewServer(append(opts, extra...)...)
+}
@@ -0,0 +1,79 @@
+package stapled
+
+import (
+	"github.com/rolandshoemaker/stapled/scache"
+)
+
+// DiskReport compares the responses in the disk cache with the
+// entries in the memory cache when stapled starts
+type DiskReport struct {
+	Orphans []string `json:"orphans"` // responses that don't belong to any entry
+	Cold    []string `json:"cold"`    // entries without a response on disk
+	Pruned  int      `json:"pruned"`  // orphans that were removed
+}
+
+// WithDiskCheck compares the responses in disk with the configured
+// entries once the watch folders have first been scanned, if prune is
+// set orphaned responses are removed
+func WithDiskCheck(disk *scache.DiskCache, prune bool) Option {
+	return func(s *Server) error {
+		s.diskCheck = disk
+		s.diskPrune = prune
+		return nil
+	}
+}
+
+// compareDisk returns the names in onDisk that aren't in entries, and
+// the names in entries that aren't in onDisk, both must be sorted
+func compareDisk(onDisk, entries []string) (orphans, cold []string) {
+	i, j := 0, 0
+	for i < len(onDisk) || j < len(entries) {
+		switch {
+		case j == len(entries) || (i < len(onDisk) && onDisk[i] < entries[j]):
+			orphans = append(orphans, onDisk[i])
+			i++
+		case i == len(onDisk) || entries[j] < onDisk[i]:
+			cold = append(cold, entries[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return orphans, cold
+}
+
+// checkDisk compares the disk cache with the entries, orphans are
+// only pruned if every entry is known at startup since entries from
+// cloud sources or requests are created later and would otherwise
+// lose their responses
+func (s *Server) checkDisk() {
+	onDisk, err := s.diskCheck.List()
+	if err != nil {
+		s.log.Err("[disk-check] Failed to list disk cache: %s", err)
+		return
+	}
+	report := &DiskReport{}
+	report.Orphans, report.Cold = compareDisk(onDisk, s.c.StableNames())
+	for _, name := range report.Orphans {
+		s.log.Warning("[disk-check] Response '%s' doesn't belong to any entry", name)
+	}
+	for _, name := range report.Cold {
+		s.log.Info("[disk-check] Entry '%s' has no response on disk", name)
+	}
+	switch {
+	case !s.diskPrune || len(report.Orphans) == 0:
+	case len(s.cloudSources) > 0 || len(s.upstreamResponders) > 0:
+		s.log.Warning("[disk-check] Not pruning orphaned responses, entries from cloud sources or upstream responders aren't known at startup")
+	default:
+		for _, name := range report.Orphans {
+			if err := s.diskCheck.Remove(name); err != nil {
+				s.log.Err("[disk-check] Failed to remove orphaned response '%s': %s", name, err)
+				continue
+			}
+			report.Pruned++
+		}
+	}
+	s.log.Info("[disk-check] %d responses on disk, %d orphaned (%d pruned), %d entries without a response", len(onDisk), len(report.Orphans), report.Pruned, len(report.Cold))
+	s.diskReport = report
+}
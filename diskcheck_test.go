@@ -0,0 +1,69 @@
+package stapled
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rolandshoemaker/stapled/scache"
+)
+
+func TestCompareDisk(t *testing.T) {
+	orphans, cold := compareDisk([]string{"a", "b", "d"}, []string{"b", "c", "d", "e"})
+	if !reflect.DeepEqual(orphans, []string{"a"}) {
+		t.Fatalf("Unexpected orphans: %v", orphans)
+	}
+	if !reflect.DeepEqual(cold, []string{"c", "e"}) {
+		t.Fatalf("Unexpected cold entries: %v", cold)
+	}
+}
+
+func TestCheckDisk(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	dir, err := ioutil.TempDir("", "disk-check")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.MkdirAll(filepath.Join(dir, "old"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	for _, name := range []string{"old/orphan.resp", "orphan.resp", "orphan.lock"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), tf.response, 0644); err != nil {
+			t.Fatalf("Failed to write '%s': %s", name, err)
+		}
+	}
+	disk := scache.NewDisk(tf.s.log, tf.fc, dir)
+
+	if err = WithDiskCheck(disk, true)(tf.s); err != nil {
+		t.Fatalf("WithDiskCheck failed: %s", err)
+	}
+	tf.s.checkDisk()
+	report := tf.s.diskReport
+	if !reflect.DeepEqual(report.Orphans, []string{"old/orphan", "orphan"}) {
+		t.Fatalf("Unexpected orphans: %v", report.Orphans)
+	}
+	if !reflect.DeepEqual(report.Cold, tf.s.c.StableNames()) {
+		t.Fatalf("Unexpected cold entries: %v", report.Cold)
+	}
+	if report.Pruned != 2 {
+		t.Fatalf("Expected 2 orphans to be pruned, got %d", report.Pruned)
+	}
+	if names, err := disk.List(); err != nil || len(names) != 0 {
+		t.Fatalf("Orphans weren't removed: %v %v", names, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "orphan.lock")); err != nil {
+		t.Fatalf("Lease file was removed: %s", err)
+	}
+
+	// orphans may belong to entries created for upstream responders
+	ioutil.WriteFile(filepath.Join(dir, "orphan.resp"), tf.response, 0644)
+	tf.s.upstreamResponders = []string{tf.upstream.URL}
+	tf.s.checkDisk()
+	if tf.s.diskReport.Pruned != 0 {
+		t.Fatal("Orphans were pruned with upstream responders configured")
+	}
+}
@@ -0,0 +1,277 @@
+// Package dnsdigest implements a experimental, extremely minimal,
+// DNS server that publishes digests of the responses held in the
+// cache as TXT records so that monitoring systems which can only
+// poll DNS can check on the state of the cache.
+//
+// Records are published at <serial>.<zone> where serial is the hex
+// encoded certificate serial number, each entry with a matching
+// serial gets a single TXT record of the form
+//
+//	v=stapled1 name=<entry name> sha256=<response digest> this-update=<unix> next-update=<unix>
+//
+// Only UDP is served and EDNS0 isn't supported, so answers are limited
+// to 512 bytes. If the records for a serial don't fit those that do
+// are sent with the TC bit set.
+package dnsdigest
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+const (
+	typeTXT  = 16
+	typeANY  = 255
+	classIN  = 1
+	classANY = 255
+
+	rcodeSuccess  = 0
+	rcodeFormErr  = 1
+	rcodeNXDomain = 3
+	rcodeNotImp   = 4
+	rcodeRefused  = 5
+
+	headerLen = 12
+	recordTTL = 60
+
+	// maxUDPSize is the largest message sent, the limit for UDP
+	// without EDNS0
+	maxUDPSize = 512
+	flagTC     = 0x0200
+)
+
+// Source provides the entries that records are generated from, it is
+// queried for each DNS query so it should be indexed by serial
+type Source interface {
+	EntriesForSerial(serial *big.Int) []mcache.EntryInfo
+}
+
+// Server answers TXT queries for a single zone
+type Server struct {
+	log    *log.Logger
+	addr   string
+	zone   string
+	source Source
+
+	conn net.PacketConn
+	mu   sync.Mutex
+}
+
+// New creates a Server which will listen on addr (UDP) and answer
+// queries for names under zone
+func New(logger *log.Logger, addr, zone string, source Source) *Server {
+	zone = strings.ToLower(strings.Trim(zone, "."))
+	return &Server{
+		log:    logger,
+		addr:   addr,
+		zone:   zone,
+		source: source,
+	}
+}
+
+// ListenAndServe listens on the configured address and answers
+// queries until the listener fails
+func (s *Server) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	s.log.Info("[dns] Listening for queries on '%s' for zone '%s'", s.addr, s.zone)
+	buf := make([]byte, maxUDPSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		resp := s.handle(buf[:n])
+		if resp == nil {
+			continue
+		}
+		if _, err = conn.WriteTo(resp, addr); err != nil {
+			s.log.Err("[dns] Failed to write response to '%s': %s", addr, err)
+		}
+	}
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+type question struct {
+	name  string
+	raw   []byte // name + type + class as it appeared in the query
+	qtype uint16
+	class uint16
+}
+
+func parseQuery(msg []byte) (uint16, uint16, *question, error) {
+	if len(msg) < headerLen {
+		return 0, 0, nil, errors.New("message shorter than header")
+	}
+	id := binary.BigEndian.Uint16(msg[0:2])
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&0x8000 != 0 {
+		return id, flags, nil, errors.New("message is not a query")
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return id, flags, nil, errors.New("message must contain exactly one question")
+	}
+	labels := []string{}
+	offset := headerLen
+	for {
+		if offset >= len(msg) {
+			return id, flags, nil, errors.New("truncated question name")
+		}
+		l := int(msg[offset])
+		offset++
+		if l == 0 {
+			break
+		}
+		if l&0xc0 != 0 {
+			return id, flags, nil, errors.New("compressed question names are not supported")
+		}
+		if offset+l > len(msg) {
+			return id, flags, nil, errors.New("truncated question label")
+		}
+		labels = append(labels, string(msg[offset:offset+l]))
+		offset += l
+	}
+	if offset+4 > len(msg) {
+		return id, flags, nil, errors.New("truncated question")
+	}
+	q := &question{
+		name:  strings.ToLower(strings.Join(labels, ".")),
+		raw:   msg[headerLen : offset+4],
+		qtype: binary.BigEndian.Uint16(msg[offset : offset+2]),
+		class: binary.BigEndian.Uint16(msg[offset+2 : offset+4]),
+	}
+	return id, flags, q, nil
+}
+
+// buildResponse builds a response with as many of answers as fit in
+// maxUDPSize, setting the TC bit if any don't
+func buildResponse(id, queryFlags uint16, rcode int, q *question, answers [][]byte) []byte {
+	// QR + AA, copy opcode and RD from the query
+	flags := uint16(0x8400) | (queryFlags & 0x7900) | uint16(rcode)
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	if q == nil {
+		binary.BigEndian.PutUint16(msg[2:4], flags)
+		return msg
+	}
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	msg = append(msg, q.raw...)
+	included := 0
+	for _, txt := range answers {
+		// the name pointer, type, class, TTL, and RDLENGTH take 12 bytes
+		if len(msg)+12+len(txt) > maxUDPSize {
+			flags |= flagTC
+			break
+		}
+		included++
+		// name is a pointer to the question name
+		rr := []byte{0xc0, headerLen}
+		rr = append(rr, 0, typeTXT, 0, classIN)
+		rr = append(rr, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(rr[6:10], recordTTL)
+		rr = append(rr, byte(len(txt)>>8), byte(len(txt)))
+		rr = append(rr, txt...)
+		msg = append(msg, rr...)
+	}
+	binary.BigEndian.PutUint16(msg[2:4], flags)
+	binary.BigEndian.PutUint16(msg[6:8], uint16(included))
+	return msg
+}
+
+// txtData encodes a string as TXT RDATA, splitting it into
+// multiple character-strings if needed
+func txtData(s string) []byte {
+	rdata := []byte{}
+	for len(s) > 0 {
+		chunk := s
+		if len(chunk) > 255 {
+			chunk = chunk[:255]
+		}
+		rdata = append(rdata, byte(len(chunk)))
+		rdata = append(rdata, chunk...)
+		s = s[len(chunk):]
+	}
+	return rdata
+}
+
+func formatRecord(info mcache.EntryInfo) string {
+	return fmt.Sprintf(
+		"v=stapled1 name=%s sha256=%s this-update=%d next-update=%d",
+		info.Name,
+		hex.EncodeToString(info.ResponseDigest[:]),
+		info.ThisUpdate.Unix(),
+		info.NextUpdate.Unix(),
+	)
+}
+
+// records returns the TXT records for the serial encoded in the
+// first label of name, and whether the name is within the zone
+func (s *Server) records(name string) ([][]byte, bool) {
+	if !strings.HasSuffix(name, "."+s.zone) {
+		return nil, false
+	}
+	label := strings.TrimSuffix(name, "."+s.zone)
+	serialBytes, err := hex.DecodeString(label)
+	if err != nil {
+		return nil, true
+	}
+	infos := s.source.EntriesForSerial(new(big.Int).SetBytes(serialBytes))
+	records := make([][]byte, 0, len(infos))
+	for _, info := range infos {
+		records = append(records, txtData(formatRecord(info)))
+	}
+	return records, true
+}
+
+func (s *Server) handle(msg []byte) []byte {
+	id, flags, q, err := parseQuery(msg)
+	if err != nil {
+		if len(msg) < headerLen || flags&0x8000 != 0 {
+			// either garbage or a response, don't reply
+			return nil
+		}
+		return buildResponse(id, flags, rcodeFormErr, nil, nil)
+	}
+	if (flags>>11)&0xf != 0 {
+		return buildResponse(id, flags, rcodeNotImp, q, nil)
+	}
+	if q.class != classIN && q.class != classANY {
+		return buildResponse(id, flags, rcodeRefused, q, nil)
+	}
+	records, inZone := s.records(q.name)
+	if !inZone {
+		return buildResponse(id, flags, rcodeRefused, q, nil)
+	}
+	if len(records) == 0 {
+		return buildResponse(id, flags, rcodeNXDomain, q, nil)
+	}
+	if q.qtype != typeTXT && q.qtype != typeANY {
+		// name exists but has no records of the requested type
+		return buildResponse(id, flags, rcodeSuccess, q, nil)
+	}
+	return buildResponse(id, flags, rcodeSuccess, q, records)
+}
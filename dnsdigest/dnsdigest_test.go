@@ -0,0 +1,123 @@
+package dnsdigest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+type staticSource []mcache.EntryInfo
+
+func (ss staticSource) EntriesForSerial(serial *big.Int) []mcache.EntryInfo {
+	infos := []mcache.EntryInfo{}
+	for _, info := range ss {
+		if info.Serial.Cmp(serial) == 0 {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+func buildQuery(id uint16, name string, qtype uint16) []byte {
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	binary.BigEndian.PutUint16(msg[2:4], 0x0100) // RD
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	for _, l := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(l)))
+		msg = append(msg, l...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, classIN)
+	return msg
+}
+
+func TestHandle(t *testing.T) {
+	now := time.Now()
+	s := New(nil, "", "stapled.example.", staticSource{
+		{
+			Name:       "test",
+			Serial:     big.NewInt(0x1337),
+			ThisUpdate: now,
+			NextUpdate: now.Add(time.Hour),
+		},
+	})
+
+	resp := s.handle(buildQuery(7, "1337.stapled.example", typeTXT))
+	if resp == nil {
+		t.Fatal("handle didn't return a response")
+	}
+	if id := binary.BigEndian.Uint16(resp[0:2]); id != 7 {
+		t.Fatalf("Unexpected response ID: wanted 7, got %d", id)
+	}
+	if rcode := resp[3] & 0xf; rcode != rcodeSuccess {
+		t.Fatalf("Unexpected rcode: wanted %d, got %d", rcodeSuccess, rcode)
+	}
+	if an := binary.BigEndian.Uint16(resp[6:8]); an != 1 {
+		t.Fatalf("Unexpected answer count: wanted 1, got %d", an)
+	}
+	if !bytes.Contains(resp, []byte("name=test ")) {
+		t.Fatal("Answer didn't contain the entry name")
+	}
+
+	resp = s.handle(buildQuery(8, "abcd.stapled.example", typeTXT))
+	if rcode := resp[3] & 0xf; rcode != rcodeNXDomain {
+		t.Fatalf("Unexpected rcode for missing serial: wanted %d, got %d", rcodeNXDomain, rcode)
+	}
+
+	resp = s.handle(buildQuery(9, "1337.other.example", typeTXT))
+	if rcode := resp[3] & 0xf; rcode != rcodeRefused {
+		t.Fatalf("Unexpected rcode for name outside zone: wanted %d, got %d", rcodeRefused, rcode)
+	}
+
+	if resp = s.handle([]byte{1, 2, 3}); resp != nil {
+		t.Fatal("handle replied to a truncated message")
+	}
+}
+
+func TestTXTData(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	rdata := txtData(long)
+	if len(rdata) != 302 {
+		t.Fatalf("Unexpected RDATA length: wanted 302, got %d", len(rdata))
+	}
+	if rdata[0] != 255 || rdata[256] != 45 {
+		t.Fatal("TXT data wasn't split into the expected character-strings")
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	now := time.Now()
+	entries := staticSource{}
+	for i := 0; i < 10; i++ {
+		entries = append(entries, mcache.EntryInfo{
+			Name:       strings.Repeat("a", 20) + string(rune('a'+i)),
+			Serial:     big.NewInt(0x1337),
+			ThisUpdate: now,
+			NextUpdate: now.Add(time.Hour),
+		})
+	}
+	s := New(nil, "", "stapled.example.", entries)
+
+	resp := s.handle(buildQuery(7, "1337.stapled.example", typeTXT))
+	if len(resp) > maxUDPSize {
+		t.Fatalf("Response is larger than %d bytes: %d", maxUDPSize, len(resp))
+	}
+	if flags := binary.BigEndian.Uint16(resp[2:4]); flags&flagTC == 0 {
+		t.Fatal("TC bit wasn't set on a truncated response")
+	}
+	an := int(binary.BigEndian.Uint16(resp[6:8]))
+	if an == 0 || an >= len(entries) || bytes.Count(resp, []byte("v=stapled1")) != an {
+		t.Fatalf("Unexpected answer count for a truncated response: %d", an)
+	}
+
+	s = New(nil, "", "stapled.example.", entries[:1])
+	resp = s.handle(buildQuery(8, "1337.stapled.example", typeTXT))
+	if flags := binary.BigEndian.Uint16(resp[2:4]); flags&flagTC != 0 {
+		t.Fatal("TC bit was set on a complete response")
+	}
+}
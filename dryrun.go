@@ -0,0 +1,278 @@
+package stapled
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/mcache"
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+)
+
+// DryRunResult is the result of checking a single certificate
+type DryRunResult struct {
+	Certificate string // path of the certificate file
+	Responder   string // responder that was contacted, if any
+	Err         error  // why a response couldn't be fetched, nil if it could
+}
+
+// dryRunCert is a certificate file and the settings its entry would
+// be created with
+type dryRunCert struct {
+	filename   string
+	issuer     string
+	responders []string
+	extensions []config.RequestExtension
+}
+
+// dryRunCerts lists the certificate files conf would create entries
+// for, watch folders are read once
+func dryRunCerts(conf *config.Configuration) ([]dryRunCert, error) {
+	var certs []dryRunCert
+	for _, def := range conf.Definitions.Certificates {
+		certs = append(certs, dryRunCert{def.Certificate, def.Issuer, def.Responders, def.RequestExtensions})
+	}
+	folders := conf.Definitions.CertWatchFolders
+	if conf.Definitions.CertWatchFolder != "" {
+		folders = append([]config.WatchFolder{{Folder: conf.Definitions.CertWatchFolder}}, folders...)
+	}
+	for _, wf := range folders {
+		files, err := ioutil.ReadDir(wf.Folder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory '%s': %s", wf.Folder, err)
+		}
+		responders := wf.Responders
+		if len(responders) == 0 {
+			responders = conf.Fetcher.UpstreamResponders
+		}
+		for _, fi := range files {
+			if fi.IsDir() {
+				continue
+			}
+			certs = append(certs, dryRunCert{filepath.Join(wf.Folder, fi.Name()), wf.Issuer, responders, wf.RequestExtensions})
+		}
+	}
+	return certs, nil
+}
+
+// dryRunRequest is a request built for a certificate which would be
+// sent to responders
+type dryRunRequest struct {
+	serial     *big.Int
+	issuer     *x509.Certificate
+	request    []byte
+	responders []string
+}
+
+// DryRun loads the certificates described by conf, resolves their
+// issuers, builds their requests, and sends a single request to each
+// distinct responder, without starting any servers or writing to the
+// disk cache. Certificates which share a responder are reported with
+// the result of the request sent to it
+func DryRun(conf *config.Configuration, logger *log.Logger, clk clock.Clock) ([]DryRunResult, error) {
+	timeout := 10 * time.Second
+	if conf.Fetcher.Timeout.Duration != 0 {
+		timeout = conf.Fetcher.Timeout.Duration
+	}
+	proxyFunc, err := proxySource(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure proxies: %s", err)
+	}
+	upstream, err := upstreamConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: newReloadableTransport(proxyFunc, conf.Fetcher.ResponderRewrites, upstream)}
+	issuers, err := loadIssuers(conf.Definitions.IssuerFolder, logger)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := dryRunCerts(conf)
+	if err != nil {
+		return nil, err
+	}
+	defaultResponders, err := issuerResponders(conf)
+	if err != nil {
+		return nil, err
+	}
+	configureHashes(conf)
+	requestHash := crypto.SHA1
+	if sha1Disabled(conf) {
+		requestHash = crypto.SHA256
+	}
+
+	results := make([]DryRunResult, len(certs))
+	requests := make([]*dryRunRequest, len(certs))
+	aia := make(map[string]*x509.Certificate)
+	for i, dc := range certs {
+		results[i].Certificate = dc.filename
+		requests[i], results[i].Err = buildDryRunRequest(client, timeout, dc, issuers, aia, defaultResponders, requestHash)
+	}
+
+	// send a request to each distinct responder, using the first
+	// certificate that uses it
+	probed := make(map[string]error)
+	for i, req := range requests {
+		if req == nil {
+			continue
+		}
+		results[i].Err = errors.New("no responder answered")
+		for _, responder := range req.responders {
+			err, present := probed[responder]
+			if !present {
+				logger.Info("[dry-run] Sending request for '%s' to '%s'", results[i].Certificate, responder)
+				err = probeResponder(client, timeout, clk, responder, req)
+				probed[responder] = err
+			}
+			results[i].Responder, results[i].Err = responder, err
+			if err == nil {
+				break
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Certificate < results[j].Certificate })
+	return results, nil
+}
+
+// buildDryRunRequest parses a certificate, resolves its issuer, and
+// builds the request that would be sent for it
+func buildDryRunRequest(client *http.Client, timeout time.Duration, dc dryRunCert, issuers []*x509.Certificate, aia map[string]*x509.Certificate, defaultResponders []mcache.IssuerResponders, requestHash crypto.Hash) (*dryRunRequest, error) {
+	cert, err := common.ReadCertificate(dc.filename)
+	if err != nil {
+		return nil, err
+	}
+	responders := dc.responders
+	if len(responders) == 0 {
+		responders = cert.OCSPServer
+	}
+	if len(responders) == 0 {
+		responders = mcache.MatchIssuerResponders(defaultResponders, cert.AuthorityKeyId, cert.Issuer.String())
+	}
+	if len(responders) == 0 {
+		return nil, mcache.ErrNoResponders
+	}
+	var issuer *x509.Certificate
+	if dc.issuer != "" {
+		if issuer, err = common.ReadCertificate(dc.issuer); err != nil {
+			return nil, fmt.Errorf("failed to load issuer '%s': %s", dc.issuer, err)
+		}
+	} else {
+		issuer = findIssuer(cert, issuers)
+		for _, u := range cert.IssuingCertificateURL {
+			if issuer != nil {
+				break
+			}
+			if issuer = aia[u]; issuer == nil {
+				if issuer, err = fetchIssuer(client, timeout, u); err == nil {
+					aia[u] = issuer
+				}
+			}
+		}
+		if issuer == nil {
+			return nil, mcache.ErrNoIssuer
+		}
+	}
+	nameHash, keyHash, err := common.HashIssuer(requestHash, issuer.RawSubject, issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	var extensions []pkix.Extension
+	for _, def := range dc.extensions {
+		ext, err := stapledOCSP.ParseRequestExtension(def.OID, def.Value, def.Critical)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, ext)
+	}
+	request, err := stapledOCSP.MarshalRequest(&ocsp.Request{
+		HashAlgorithm:  requestHash,
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  keyHash,
+		SerialNumber:   cert.SerialNumber,
+	}, extensions)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := make([]string, len(responders))
+	for i, r := range responders {
+		trimmed[i] = strings.TrimSuffix(r, "/")
+	}
+	return &dryRunRequest{cert.SerialNumber, issuer, request, trimmed}, nil
+}
+
+// findIssuer returns the certificate in issuers whose subject, and
+// key ID if cert has a authority key ID, match the issuer of cert
+func findIssuer(cert *x509.Certificate, issuers []*x509.Certificate) *x509.Certificate {
+	for _, issuer := range issuers {
+		if bytes.Equal(issuer.RawSubject, cert.RawIssuer) && (len(cert.AuthorityKeyId) == 0 || bytes.Equal(issuer.SubjectKeyId, cert.AuthorityKeyId)) {
+			return issuer
+		}
+	}
+	return nil
+}
+
+func fetchIssuer(client *http.Client, timeout time.Duration, u string) (*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return common.ParseCertificate(body)
+}
+
+// probeResponder sends req to responder once and checks the response
+// is valid
+func probeResponder(client *http.Client, timeout time.Duration, clk clock.Clock, responder string, req *dryRunRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	httpReq, err := http.NewRequest("GET", responder+"/"+url.QueryEscape(base64.StdEncoding.EncodeToString(req.request)), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("responder returned status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	parsed, err := stapledOCSP.ParseResponse(clk.Now(), body, req.issuer)
+	if err != nil {
+		return err
+	}
+	return stapledOCSP.VerifyResponse(clk.Now(), req.serial, parsed)
+}
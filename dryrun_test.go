@@ -0,0 +1,79 @@
+package stapled
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+func TestDryRun(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	dir, err := ioutil.TempDir("", "dry-run")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	issuers := filepath.Join(dir, "issuers")
+	certs := filepath.Join(dir, "certs")
+	for _, folder := range []string{issuers, certs} {
+		if err = os.Mkdir(folder, 0700); err != nil {
+			t.Fatalf("os.Mkdir failed: %s", err)
+		}
+	}
+	write := func(filename string, contents []byte) {
+		if err := ioutil.WriteFile(filename, contents, 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile failed: %s", err)
+		}
+	}
+	write(filepath.Join(certs, "leaf.der"), tf.certDER)
+	write(filepath.Join(dir, "orphan.der"), tf.certDER)
+
+	conf := &config.Configuration{}
+	conf.Definitions.CertWatchFolder = certs
+	conf.Definitions.IssuerFolder = issuers
+	conf.Definitions.Certificates = []config.CertDefinition{{Certificate: filepath.Join(dir, "orphan.der"), Responders: []string{tf.upstream.URL}}}
+	conf.Fetcher.UpstreamResponders = []string{tf.upstream.URL}
+	conf.Fetcher.IgnoreProxyEnvironment = true
+
+	// the issuer folder is empty so neither certificate has a issuer
+	results, err := DryRun(conf, tf.s.log, tf.fc)
+	if err != nil {
+		t.Fatalf("DryRun failed: %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if !errors.Is(r.Err, mcache.ErrNoIssuer) {
+			t.Fatalf("Expected ErrNoIssuer for '%s', got %v", r.Certificate, r.Err)
+		}
+	}
+
+	write(filepath.Join(issuers, "issuer.der"), tf.issuer.Raw)
+	results, err = DryRun(conf, tf.s.log, tf.fc)
+	if err != nil {
+		t.Fatalf("DryRun failed: %s", err)
+	}
+	for _, r := range results {
+		if r.Err != nil || r.Responder != tf.upstream.URL {
+			t.Fatalf("Unexpected result for '%s': %+v", r.Certificate, r)
+		}
+	}
+
+	tf.fc.Add(48 * time.Hour)
+	results, err = DryRun(conf, tf.s.log, tf.fc)
+	if err != nil {
+		t.Fatalf("DryRun failed: %s", err)
+	}
+	if results[0].Err == nil {
+		t.Fatal("DryRun didn't report a stale response")
+	}
+}
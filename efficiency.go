@@ -0,0 +1,149 @@
+package stapled
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+// maxLoggedEntries is the number of duplicate responses and wasteful
+// entries logged with a efficiency report, /efficiency lists all of
+// them
+const maxLoggedEntries = 5
+
+// WithEfficiencyReports logs a cache efficiency report, see
+// mcache.EntryCache.Efficiency, every interval
+func WithEfficiencyReports(interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("efficiency report interval must be positive")
+		}
+		s.efficiencyInterval = interval
+		return nil
+	}
+}
+
+// fetchEfficiency is the JSON form of mcache.FetchEfficiency, the
+// ratios are of Fetches
+type fetchEfficiency struct {
+	Fetches          int64   `json:"fetches"`
+	Unchanged        int64   `json:"unchanged"`
+	NotModified      int64   `json:"notModified"`
+	UnchangedRatio   float64 `json:"unchangedRatio"`
+	NotModifiedRatio float64 `json:"notModifiedRatio"`
+}
+
+func newFetchEfficiency(fe mcache.FetchEfficiency) fetchEfficiency {
+	out := fetchEfficiency{Fetches: fe.Fetches, Unchanged: fe.Unchanged, NotModified: fe.NotModified}
+	if fe.Fetches > 0 {
+		out.UnchangedRatio = float64(fe.Unchanged) / float64(fe.Fetches)
+		out.NotModifiedRatio = float64(fe.NotModified) / float64(fe.Fetches)
+	}
+	return out
+}
+
+// duplicateResponse is the JSON form of mcache.DuplicateResponse
+type duplicateResponse struct {
+	ResponseSHA256 string   `json:"responseSHA256"`
+	Entries        []string `json:"entries"`
+}
+
+// wastefulEntry is the JSON form of mcache.EntryEfficiency
+type wastefulEntry struct {
+	Name string `json:"name"`
+	fetchEfficiency
+}
+
+// efficiencyReport is the body of /efficiency
+type efficiencyReport struct {
+	Entries    int                        `json:"entries"`
+	Duplicates []duplicateResponse        `json:"duplicates"`
+	Wasteful   []wastefulEntry            `json:"wasteful"`
+	Responders map[string]fetchEfficiency `json:"responders"`
+	Total      fetchEfficiency            `json:"total"`
+}
+
+func newEfficiencyReport(report mcache.EfficiencyReport) efficiencyReport {
+	out := efficiencyReport{
+		Entries:    report.Entries,
+		Duplicates: make([]duplicateResponse, len(report.Duplicates)),
+		Wasteful:   make([]wastefulEntry, len(report.Wasteful)),
+		Responders: make(map[string]fetchEfficiency, len(report.Responders)),
+		Total:      newFetchEfficiency(report.Total),
+	}
+	for i, dup := range report.Duplicates {
+		out.Duplicates[i] = duplicateResponse{hex.EncodeToString(dup.Digest[:]), dup.Names}
+	}
+	for i, ee := range report.Wasteful {
+		out.Wasteful[i] = wastefulEntry{ee.Name, newFetchEfficiency(ee.FetchEfficiency)}
+	}
+	for responder, fe := range report.Responders {
+		out.Responders[responder] = newFetchEfficiency(fe)
+	}
+	return out
+}
+
+// efficiencyHandler serves a cache efficiency report at /efficiency
+func (s *Server) efficiencyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeJSON(w, r, newEfficiencyReport(s.c.Efficiency()))
+}
+
+// logEfficiency logs a summary of a cache efficiency report
+func (s *Server) logEfficiency() {
+	report := newEfficiencyReport(s.c.Efficiency())
+	s.log.Info(
+		"[efficiency] %d fetches, %.0f%% unchanged and %.0f%% not modified, %d responses held by more than one entry, %d entries refreshed more often than their responses change",
+		report.Total.Fetches,
+		100*report.Total.UnchangedRatio,
+		100*report.Total.NotModifiedRatio,
+		len(report.Duplicates),
+		len(report.Wasteful),
+	)
+	for i, dup := range report.Duplicates {
+		if i == maxLoggedEntries {
+			s.log.Info("[efficiency] %d more responses are held by more than one entry, see /efficiency", len(report.Duplicates)-i)
+			break
+		}
+		s.log.Info("[efficiency] Entries %s hold the same response", strings.Join(dup.Entries, ", "))
+	}
+	for i, we := range report.Wasteful {
+		if i == maxLoggedEntries {
+			s.log.Info("[efficiency] %d more entries are refreshed more often than their responses change, see /efficiency", len(report.Wasteful)-i)
+			break
+		}
+		s.log.Info("[efficiency] Entry '%s' got its current response back from %d of %d fetches", we.Name, we.Unchanged, we.Fetches)
+	}
+	responders := make([]string, 0, len(report.Responders))
+	for responder := range report.Responders {
+		responders = append(responders, responder)
+	}
+	sort.Strings(responders)
+	for _, responder := range responders {
+		fe := report.Responders[responder]
+		s.log.Info("[efficiency] Responder '%s': %d fetches, %.0f%% unchanged and %.0f%% not modified", responder, fe.Fetches, 100*fe.UnchangedRatio, 100*fe.NotModifiedRatio)
+	}
+}
+
+// watchEfficiency logs a cache efficiency report every interval
+func (s *Server) watchEfficiency() {
+	ticker := time.NewTicker(s.efficiencyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.logEfficiency()
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package stapled
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEfficiencyHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	name := tf.s.c.Entries()[0].Name
+	for i := 0; i < 4; i++ {
+		if err := tf.s.c.Refresh(name); err != nil {
+			t.Fatalf("Failed to refresh entry: %s", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	tf.s.efficiencyHandler(w, httptest.NewRequest("GET", "/efficiency", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", w.Code)
+	}
+	var report efficiencyReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to parse efficiency report: %s", err)
+	}
+	responder := report.Responders[tf.upstream.URL]
+	if responder.Fetches != 5 || responder.Unchanged != 4 || responder.UnchangedRatio != 0.8 {
+		t.Fatalf("Unexpected responder efficiency: %+v", responder)
+	}
+	if len(report.Wasteful) != 1 || report.Wasteful[0].Name != name || report.Total != responder {
+		t.Fatalf("Unexpected efficiency report: %+v", report)
+	}
+	if len(report.Duplicates) != 0 {
+		t.Fatalf("Unexpected duplicate responses: %+v", report.Duplicates)
+	}
+	tf.s.logEfficiency()
+
+	w = httptest.NewRecorder()
+	tf.s.efficiencyHandler(w, httptest.NewRequest("POST", "/efficiency", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405 for POST, got %d", w.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package stapled
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+// ExpiredPolicy controls how requests for entries whose certificate
+// has expired are answered, frontends often keep serving a
+// certificate for a while after it expires
+type ExpiredPolicy int
+
+const (
+	// ServeExpired answers with the cached response as if the
+	// certificate hadn't expired
+	ServeExpired ExpiredPolicy = iota
+	// GraceExpired answers with the cached response until the grace
+	// period has passed since the certificate expired, logging and
+	// counting each request, and with unauthorized after it
+	GraceExpired
+	// RejectExpired answers with unauthorized as soon as the
+	// certificate expires
+	RejectExpired
+)
+
+// ParseExpiredPolicy parses the name of a ExpiredPolicy, either
+// serve, the default if name is empty, grace, or unauthorized
+func ParseExpiredPolicy(name string) (ExpiredPolicy, error) {
+	switch name {
+	case "", "serve":
+		return ServeExpired, nil
+	case "grace":
+		return GraceExpired, nil
+	case "unauthorized":
+		return RejectExpired, nil
+	}
+	return 0, fmt.Errorf("unknown policy '%s', expected serve, grace, or unauthorized", name)
+}
+
+type expiredCertificates struct {
+	policy ExpiredPolicy
+	grace  time.Duration
+}
+
+// WithExpiredCertificates sets how requests for entries whose
+// certificate has expired are answered, grace is only used by
+// GraceExpired. Entries that weren't created from a certificate are
+// always served
+func WithExpiredCertificates(policy ExpiredPolicy, grace time.Duration) Option {
+	return func(s *Server) error {
+		if grace < 0 {
+			return fmt.Errorf("expired certificate grace period must not be negative")
+		}
+		s.expired = expiredCertificates{policy: policy, grace: grace}
+		return nil
+	}
+}
+
+// rejectExpired checks if pr should be answered
+// with unauthorized because its certificate has expired
+func (s *Server) rejectExpired(pr *parsedRequest) bool {
+	notAfter, present := s.c.CertificateNotAfter(pr.key)
+	now := s.clk.Now()
+	if !present || notAfter.IsZero() || !now.After(notAfter) {
+		return false
+	}
+	expiredFor := now.Sub(notAfter)
+	serial := pr.request.SerialNumber
+	if s.expired.policy == GraceExpired && expiredFor <= s.expired.grace {
+		s.log.Info("[responder] Serving final response for serial %x, its certificate expired %s ago", serial, common.HumanDuration(expiredFor))
+		s.metrics.Counter("responder.expired-grace", 1)
+		return false
+	}
+	s.log.Info("[responder] Rejecting request for serial %x, its certificate expired %s ago", serial, common.HumanDuration(expiredFor))
+	s.metrics.Counter("responder.expired-rejected", 1)
+	return true
+}
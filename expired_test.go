@@ -0,0 +1,60 @@
+package stapled
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/http"
+	"testing"
+	"time"
+
+	stapledMetrics "github.com/rolandshoemaker/stapled/metrics"
+)
+
+func TestExpiredCertificates(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	cert, err := x509.ParseCertificate(tf.certDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	sink := stapledMetrics.NewAggregate()
+	if err = WithMetrics(sink)(tf.s); err != nil {
+		t.Fatalf("WithMetrics failed: %s", err)
+	}
+	if err = WithExpiredCertificates(GraceExpired, 2*time.Hour)(tf.s); err != nil {
+		t.Fatalf("WithExpiredCertificates failed: %s", err)
+	}
+
+	for _, test := range []struct {
+		sinceExpiry time.Duration
+		policy      ExpiredPolicy
+		served      bool
+	}{
+		{-time.Hour, RejectExpired, true},
+		{time.Hour, GraceExpired, true},
+		{3 * time.Hour, GraceExpired, false},
+		{time.Hour, RejectExpired, false},
+		{3 * time.Hour, ServeExpired, true},
+	} {
+		tf.s.expired.policy = test.policy
+		tf.fc.Set(cert.NotAfter.Add(test.sinceExpiry))
+		w := tf.get(nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code: %d", w.Code)
+		}
+		if served := bytes.Equal(w.Body.Bytes(), tf.response); served != test.served {
+			t.Fatalf("Expected response to be served %t %s after expiry with policy %d, got %t", test.served, test.sinceExpiry, test.policy, served)
+		}
+		if !test.served && !bytes.Equal(w.Body.Bytes(), unauthorizedErrorResponse) {
+			t.Fatalf("Unexpected response: %x", w.Body.Bytes())
+		}
+	}
+	snap := sink.Snapshot()
+	if snap.Counters["responder.expired-grace"] != 1 || snap.Counters["responder.expired-rejected"] != 2 {
+		t.Fatalf("Unexpected responder counters: %v", snap.Counters)
+	}
+
+	if _, err = ParseExpiredPolicy("drop"); err == nil {
+		t.Fatal("ParseExpiredPolicy accepted a unknown policy")
+	}
+}
@@ -0,0 +1,237 @@
+package stapled
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+const (
+	// BundleConcat writes a line for each staple containing the hex
+	// SHA-256 fingerprint of the certificate and the base64 DER
+	// response separated by a space
+	BundleConcat = "concat"
+	// BundleTar writes a tar archive containing a file for each
+	// staple named <hex SHA-256 fingerprint>.ocsp containing the DER
+	// response
+	BundleTar = "tar"
+	// BundleDirectory writes a folder named generation-<N> containing
+	// the same files as BundleTar inside the bundle path, which is a
+	// folder, and then points the symlink current at it, so a frontend
+	// reading current always sees a complete set of staples
+	BundleDirectory = "directory"
+)
+
+// currentGeneration is the symlink to the latest generation written
+// by BundleDirectory
+const currentGeneration = "current"
+
+// keepGenerations is how many generations are kept, including the
+// current one, so that a frontend which resolved current before it
+// was swapped can finish reading the previous generation
+const keepGenerations = 2
+
+// staplePath returns the name of the file containing a staple in tar
+// and directory bundles
+func staplePath(staple mcache.Staple) string {
+	return hex.EncodeToString(staple.Fingerprint[:]) + ".ocsp"
+}
+
+// WriteBundle writes staples to w in format, either BundleConcat or
+// BundleTar
+func WriteBundle(w io.Writer, format string, staples []mcache.Staple, modTime time.Time) error {
+	switch format {
+	case BundleConcat:
+		for _, staple := range staples {
+			_, err := fmt.Fprintf(w, "%s %s\n", hex.EncodeToString(staple.Fingerprint[:]), base64.StdEncoding.EncodeToString(staple.Response))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case BundleTar:
+		tw := tar.NewWriter(w)
+		for _, staple := range staples {
+			err := tw.WriteHeader(&tar.Header{
+				Name:    staplePath(staple),
+				Mode:    0644,
+				Size:    int64(len(staple.Response)),
+				ModTime: modTime,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err = tw.Write(staple.Response); err != nil {
+				return err
+			}
+		}
+		return tw.Close()
+	}
+	return fmt.Errorf("unknown bundle format '%s', expected %s or %s", format, BundleConcat, BundleTar)
+}
+
+// generations returns the generation numbers in dir, in ascending
+// order
+func generations(dir string) ([]int, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	gens := []int{}
+	for _, info := range infos {
+		if !info.IsDir() || !strings.HasPrefix(info.Name(), "generation-") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(info.Name(), "generation-"))
+		if err == nil {
+			gens = append(gens, n)
+		}
+	}
+	sort.Ints(gens)
+	return gens, nil
+}
+
+// WriteGeneration writes staples to a new generation folder in dir
+// and atomically swaps the current symlink to it, so that readers
+// never see a partially written set. The oldest generations are
+// removed once there are more than keepGenerations. It returns the
+// path of the new generation
+func WriteGeneration(dir string, staples []mcache.Staple) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	gens, err := generations(dir)
+	if err != nil {
+		return "", err
+	}
+	next := 1
+	if len(gens) > 0 {
+		next = gens[len(gens)-1] + 1
+	}
+	name := fmt.Sprintf("generation-%d", next)
+	tmp, err := ioutil.TempDir(dir, "."+name+".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	for _, staple := range staples {
+		if err = ioutil.WriteFile(filepath.Join(tmp, staplePath(staple)), staple.Response, 0644); err != nil {
+			return "", err
+		}
+	}
+	if err = os.Chmod(tmp, 0755); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return "", err
+	}
+	// the symlink is relative so that dir can be moved or mounted
+	// elsewhere, and is created under a temporary name and renamed
+	// over current since a symlink can't be replaced in place
+	link := filepath.Join(dir, "."+currentGeneration+".tmp")
+	os.Remove(link)
+	if err = os.Symlink(name, link); err != nil {
+		return "", err
+	}
+	if err = os.Rename(link, filepath.Join(dir, currentGeneration)); err != nil {
+		os.Remove(link)
+		return "", err
+	}
+	gens = append(gens, next)
+	for len(gens) > keepGenerations {
+		os.RemoveAll(filepath.Join(dir, fmt.Sprintf("generation-%d", gens[0])))
+		gens = gens[1:]
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// bundleDigest identifies a set of staples so that the bundle is
+// only rewritten when a response changes
+func bundleDigest(staples []mcache.Staple) [32]byte {
+	h := common.NewSHA256()
+	for _, staple := range staples {
+		h.Write(staple.Fingerprint[:])
+		h.Write(staple.Response)
+	}
+	var digest [32]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// WithBundleExport periodically writes the current responses for
+// every certificate to a single file at path, using format, so that
+// TLS terminators can load them in bulk. The file is replaced
+// atomically and only when a response has changed. If format is
+// BundleDirectory path is a folder, see WriteGeneration
+func WithBundleExport(path, format string, interval time.Duration) Option {
+	return func(s *Server) error {
+		if format != BundleConcat && format != BundleTar && format != BundleDirectory {
+			return fmt.Errorf("unknown bundle format '%s', expected %s, %s, or %s", format, BundleConcat, BundleTar, BundleDirectory)
+		}
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		s.bundlePath, s.bundleFormat, s.bundleInterval = path, format, interval
+		return nil
+	}
+}
+
+// exportBundle writes the bundle if the staples have changed since
+// the last time it was written
+func (s *Server) exportBundle() error {
+	staples := s.c.Staples()
+	digest := bundleDigest(staples)
+	if digest == s.bundleDigest {
+		return nil
+	}
+	if s.bundleFormat == BundleDirectory {
+		path, err := WriteGeneration(s.bundlePath, staples)
+		if err != nil {
+			return err
+		}
+		s.bundleDigest = digest
+		s.log.Info("[export] Wrote %d staples to '%s'", len(staples), path)
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	if err := WriteBundle(buf, s.bundleFormat, staples, s.clk.Now()); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.bundlePath, buf.Bytes()); err != nil {
+		return err
+	}
+	s.bundleDigest = digest
+	s.log.Info("[export] Wrote %d staples to '%s'", len(staples), s.bundlePath)
+	return nil
+}
+
+func (s *Server) watchBundle() {
+	if err := s.exportBundle(); err != nil {
+		s.log.Err("[export] Failed to write bundle to '%s': %s", s.bundlePath, err)
+	}
+	ticker := time.NewTicker(s.bundleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.exportBundle(); err != nil {
+				s.log.Err("[export] Failed to write bundle to '%s': %s", s.bundlePath, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package stapled
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+func TestWriteBundle(t *testing.T) {
+	staples := []mcache.Staple{
+		{Name: "a", Fingerprint: [32]byte{1}, Response: []byte{1, 2, 3}},
+		{Name: "b", Fingerprint: [32]byte{2}, Response: []byte{4}},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteBundle(buf, BundleConcat, staples, time.Time{}); err != nil {
+		t.Fatalf("WriteBundle failed: %s", err)
+	}
+	expected := "0100000000000000000000000000000000000000000000000000000000000000 AQID\n" +
+		"0200000000000000000000000000000000000000000000000000000000000000 BA==\n"
+	if buf.String() != expected {
+		t.Fatalf("Unexpected concat bundle: %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteBundle(buf, BundleTar, staples, time.Time{}); err != nil {
+		t.Fatalf("WriteBundle failed: %s", err)
+	}
+	tr := tar.NewReader(buf)
+	for _, staple := range staples {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Failed to read tar bundle: %s", err)
+		}
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Failed to read tar bundle: %s", err)
+		}
+		if hdr.Name != hex.EncodeToString(staple.Fingerprint[:])+".ocsp" || !bytes.Equal(contents, staple.Response) {
+			t.Fatalf("Unexpected file in tar bundle: %s %v", hdr.Name, contents)
+		}
+	}
+
+	if err := WriteBundle(buf, "zip", staples, time.Time{}); err == nil {
+		t.Fatal("WriteBundle didn't fail with unknown format")
+	}
+	if bundleDigest(staples) == bundleDigest(staples[:1]) {
+		t.Fatal("bundleDigest didn't change when staples changed")
+	}
+}
+
+func TestWriteGeneration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	staples := []mcache.Staple{
+		{Name: "a", Fingerprint: [32]byte{1}, Response: []byte{1, 2, 3}},
+		{Name: "b", Fingerprint: [32]byte{2}, Response: []byte{4}},
+	}
+	for i := 1; i <= 3; i++ {
+		staples[0].Response = []byte{byte(i)}
+		path, err := WriteGeneration(dir, staples)
+		if err != nil {
+			t.Fatalf("WriteGeneration failed: %s", err)
+		}
+		if expected := filepath.Join(dir, fmt.Sprintf("generation-%d", i)); path != expected {
+			t.Fatalf("Expected generation %s, got %s", expected, path)
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(dir, "current", staplePath(staples[0])))
+		if err != nil {
+			t.Fatalf("Failed to read staple through current: %s", err)
+		}
+		if !bytes.Equal(contents, staples[0].Response) {
+			t.Fatalf("current doesn't point at generation %d, read %v", i, contents)
+		}
+	}
+	gens, err := generations(dir)
+	if err != nil {
+		t.Fatalf("generations failed: %s", err)
+	}
+	if len(gens) != 2 || gens[0] != 2 || gens[1] != 3 {
+		t.Fatalf("Expected generations 2 and 3 to be kept, got %v", gens)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ioutil.ReadDir failed: %s", err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("Expected two generations and current, found %d files", len(infos))
+	}
+}
@@ -0,0 +1,116 @@
+package stapled
+
+import (
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/rand"
+	"github.com/rolandshoemaker/stapled/scache"
+)
+
+// Faults describes failures to inject so that alerting, and the
+// behavior of frontends when stapled misbehaves, can be tested in
+// staging. It must never be used in production
+type Faults struct {
+	FetchDropRate  float64       // fraction of upstream requests that fail
+	FetchDelay     time.Duration // added before each upstream request
+	ResponderDelay time.Duration // added before each OCSP response is served
+	CorruptRate    float64       // fraction of stable backing reads whose response is corrupted
+}
+
+// Enabled checks if any faults are injected
+func (f Faults) Enabled() bool {
+	return f.FetchDropRate > 0 || f.FetchDelay > 0 || f.ResponderDelay > 0 || f.CorruptRate > 0
+}
+
+// errInjectedFault is returned for upstream requests that are dropped
+var errInjectedFault = errors.New("injected fault: upstream request dropped")
+
+// faultTransport drops and delays upstream requests
+type faultTransport struct {
+	next     http.RoundTripper
+	dropRate float64
+	delay    time.Duration
+}
+
+func (ft *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if ft.delay > 0 {
+		t := time.NewTimer(ft.delay)
+		select {
+		case <-req.Context().Done():
+			t.Stop()
+			return nil, req.Context().Err()
+		case <-t.C:
+		}
+	}
+	if ft.dropRate > 0 && rand.Float64() < ft.dropRate {
+		return nil, errInjectedFault
+	}
+	return ft.next.RoundTrip(req)
+}
+
+// faultyCache corrupts a fraction of the responses read from a stable
+// backing. The parsed response is returned intact so that the entry
+// accepts it, and serves the corrupted bytes
+type faultyCache struct {
+	scache.Cache
+	rate float64
+}
+
+func (fc *faultyCache) Read(name string, serial *big.Int, issuer *x509.Certificate) (*ocsp.Response, []byte) {
+	resp, respBytes := fc.Cache.Read(name, serial, issuer)
+	if resp == nil || len(respBytes) == 0 || rand.Float64() >= fc.rate {
+		return resp, respBytes
+	}
+	corrupted := append([]byte(nil), respBytes...)
+	// the response ends with the signature, so flipping the last
+	// byte keeps the DER parseable but breaks the signature
+	corrupted[len(corrupted)-1] ^= 0xff
+	return resp, corrupted
+}
+
+// Unwrap returns the wrapped cache so that leader election still
+// finds it, see scache.Unwrap
+func (fc *faultyCache) Unwrap() scache.Cache {
+	return fc.Cache
+}
+
+// injectFetchFaults wraps the transport of client so that upstream
+// requests are dropped and delayed as described by f
+func injectFetchFaults(client *http.Client, f Faults) {
+	if f.FetchDropRate <= 0 && f.FetchDelay <= 0 {
+		return
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &faultTransport{next: next, dropRate: f.FetchDropRate, delay: f.FetchDelay}
+}
+
+// injectStableFaults wraps each of the stable backings so that
+// responses read from them are corrupted as described by f
+func injectStableFaults(backings []scache.Cache, f Faults) []scache.Cache {
+	if f.CorruptRate <= 0 {
+		return backings
+	}
+	wrapped := make([]scache.Cache, len(backings))
+	for i, b := range backings {
+		wrapped[i] = &faultyCache{Cache: b, rate: f.CorruptRate}
+	}
+	return wrapped
+}
+
+// WithResponderDelay delays every OCSP response by d, it is used to
+// inject faults, see Faults
+func WithResponderDelay(d time.Duration) Option {
+	return func(s *Server) error {
+		s.responderDelay = d
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package stapled
+
+import (
+	"bytes"
+	"crypto/x509"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/scache"
+)
+
+type staticCache struct {
+	resp      *ocsp.Response
+	respBytes []byte
+}
+
+func (sc *staticCache) Read(string, *big.Int, *x509.Certificate) (*ocsp.Response, []byte) {
+	return sc.resp, sc.respBytes
+}
+
+func (sc *staticCache) Write(string, []byte) {}
+
+func TestFetchFaults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client := &http.Client{}
+	injectFetchFaults(client, Faults{})
+	if client.Transport != nil {
+		t.Fatal("Transport was wrapped without any fetch faults")
+	}
+
+	injectFetchFaults(client, Faults{FetchDropRate: 1})
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("Request wasn't dropped with a drop rate of 1")
+	}
+
+	client = &http.Client{}
+	injectFetchFaults(client, Faults{FetchDropRate: 0.000001, FetchDelay: 1})
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Delayed request failed: %s", err)
+	}
+	resp.Body.Close()
+}
+
+func TestStableFaults(t *testing.T) {
+	sc := &staticCache{resp: &ocsp.Response{}, respBytes: []byte{1, 2, 3}}
+	backings := []scache.Cache{sc}
+	if wrapped := injectStableFaults(backings, Faults{}); wrapped[0] != sc {
+		t.Fatal("Stable backing was wrapped without a corrupt rate")
+	}
+
+	wrapped := injectStableFaults(backings, Faults{CorruptRate: 1})
+	resp, respBytes := wrapped[0].Read("name", nil, nil)
+	if resp != sc.resp {
+		t.Fatal("Parsed response wasn't returned intact")
+	}
+	if bytes.Equal(respBytes, sc.respBytes) || len(respBytes) != len(sc.respBytes) {
+		t.Fatalf("Response wasn't corrupted: %x", respBytes)
+	}
+	if !bytes.Equal(sc.respBytes, []byte{1, 2, 3}) {
+		t.Fatal("Response returned by the stable backing was modified")
+	}
+}
@@ -0,0 +1,211 @@
+package stapled
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/mcache"
+	"github.com/rolandshoemaker/stapled/notify"
+	"github.com/rolandshoemaker/stapled/rand"
+)
+
+// GameDay periodically fetches responses for a small sample of
+// entries from the responders and proxies that are only used when
+// another fails, so that a broken fallback is found before a real
+// outage of the primary relies on it. Probed responses are verified
+// but never replace the responses being served
+type GameDay struct {
+	Interval time.Duration
+	Sample   int           // entries probed each game day, three by default
+	Timeout  time.Duration // for each probe, 30 seconds by default
+	// Proxies are each used to fetch a response for one of the
+	// sampled entries from its primary responder
+	Proxies []string
+}
+
+// gameDay holds the game day settings and the fallbacks found broken
+// by the last game day
+type gameDay struct {
+	GameDay
+	mu      sync.Mutex
+	proxies []*url.URL
+	broken  map[string]string // responder URL or proxy -> error
+}
+
+// WithGameDay runs a game day every gd.Interval
+func WithGameDay(gd GameDay) Option {
+	return func(s *Server) error {
+		if gd.Interval <= 0 {
+			return errors.New("game day interval must be positive")
+		}
+		if gd.Sample < 0 || gd.Timeout < 0 {
+			return errors.New("game day sample and timeout must not be negative")
+		}
+		if gd.Sample == 0 {
+			gd.Sample = 3
+		}
+		if gd.Timeout == 0 {
+			gd.Timeout = 30 * time.Second
+		}
+		s.gameDay = &gameDay{GameDay: gd}
+		return s.gameDay.setProxies(gd.Proxies)
+	}
+}
+
+// setProxies replaces the proxies that are probed
+func (gd *gameDay) setProxies(proxies []string) error {
+	parsed := make([]*url.URL, len(proxies))
+	for i, p := range proxies {
+		u, err := url.Parse(p)
+		if err != nil {
+			return fmt.Errorf("failed to parse proxy '%s': %s", p, err)
+		}
+		parsed[i] = u
+	}
+	gd.mu.Lock()
+	defer gd.mu.Unlock()
+	gd.proxies = parsed
+	return nil
+}
+
+// gameDayProbe is a fallback path to check, a responder, fetched
+// without a proxy override, or a proxy, used to fetch from responder
+type gameDayProbe struct {
+	subject   string // reported in logs and events
+	entry     string
+	responder string
+	proxy     *url.URL // nil if the responder is being checked
+}
+
+// sampleEntries returns up to n randomly picked entries which have a
+// response
+func sampleEntries(infos []mcache.EntryInfo, n int) []mcache.EntryInfo {
+	candidates := []mcache.EntryInfo{}
+	for _, info := range infos {
+		if !info.ThisUpdate.IsZero() && len(info.Responders) > 0 {
+			candidates = append(candidates, info)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+	for i := range candidates {
+		j := i + rand.Intn(len(candidates)-i)
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+// probes returns the fallback paths of the sampled entries
+func (gd *gameDay) probes(sample []mcache.EntryInfo) []gameDayProbe {
+	probes := []gameDayProbe{}
+	for _, info := range sample {
+		primary := info.Responder
+		if primary == "" {
+			primary = info.Responders[0]
+		}
+		for _, responder := range info.Responders {
+			if responder != primary {
+				probes = append(probes, gameDayProbe{subject: responder, entry: info.Name, responder: responder})
+			}
+		}
+	}
+	if len(sample) == 0 {
+		return probes
+	}
+	gd.mu.Lock()
+	defer gd.mu.Unlock()
+	for i, proxy := range gd.proxies {
+		info := sample[i%len(sample)]
+		responder := info.Responder
+		if responder == "" {
+			responder = info.Responders[0]
+		}
+		probes = append(probes, gameDayProbe{subject: proxy.Redacted(), entry: info.Name, responder: responder, proxy: proxy})
+	}
+	return probes
+}
+
+// probeClient returns the client a probe uses, nil uses the client of
+// the entry, and a function to call once it is done with
+func (s *Server) probeClient(probe gameDayProbe) (*http.Client, func()) {
+	if probe.proxy == nil {
+		return nil, func() {}
+	}
+	if s.transport != nil {
+		rt := s.transport.withProxy(probe.proxy)
+		return &http.Client{Transport: rt}, rt.transport.CloseIdleConnections
+	}
+	t := newTransport(http.ProxyURL(probe.proxy), nil)
+	return &http.Client{Transport: t}, t.CloseIdleConnections
+}
+
+// runGameDay probes the fallback paths of a sample of entries and
+// records those that are broken
+func (s *Server) runGameDay() {
+	sample := sampleEntries(s.c.Entries(), s.gameDay.Sample)
+	broken := map[string]string{}
+	for _, probe := range s.gameDay.probes(sample) {
+		client, done := s.probeClient(probe)
+		ctx, cancel := context.WithTimeout(context.Background(), s.gameDay.Timeout)
+		err := s.c.ProbeResponder(ctx, probe.entry, probe.responder, client)
+		cancel()
+		done()
+		s.metrics.Counter("gameday.checks", 1)
+		if err != nil {
+			s.metrics.Counter("gameday.failures", 1)
+			s.log.Err("[game-day] Fallback '%s' failed to fetch a response for '%s' from '%s': %s", probe.subject, probe.entry, probe.responder, err)
+			broken[probe.subject] = err.Error()
+			continue
+		}
+		s.log.Info("[game-day] Fallback '%s' fetched a response for '%s' from '%s'", probe.subject, probe.entry, probe.responder)
+	}
+	s.gameDay.mu.Lock()
+	s.gameDay.broken = broken
+	s.gameDay.mu.Unlock()
+}
+
+// gameDayEvents returns a event for each fallback the last game day
+// found broken
+func (s *Server) gameDayEvents(now time.Time) []notify.Event {
+	if s.gameDay == nil {
+		return nil
+	}
+	s.gameDay.mu.Lock()
+	defer s.gameDay.mu.Unlock()
+	subjects := make([]string, 0, len(s.gameDay.broken))
+	for subject := range s.gameDay.broken {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	events := make([]notify.Event, len(subjects))
+	for i, subject := range subjects {
+		events[i] = notify.Event{
+			Kind:    notify.FallbackBroken,
+			Subject: subject,
+			Message: fmt.Sprintf("fallback '%s' failed during the last game day: %s", subject, s.gameDay.broken[subject]),
+			Time:    now,
+		}
+	}
+	return events
+}
+
+func (s *Server) watchGameDay() {
+	ticker := time.NewTicker(s.gameDay.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.runGameDay()
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package stapled
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/notify"
+	stapledRand "github.com/rolandshoemaker/stapled/rand"
+)
+
+// fixedSource is a math/rand source which always returns n
+type fixedSource int64
+
+func (fs fixedSource) Int63() int64 { return int64(fs) }
+func (fs fixedSource) Seed(int64)   {}
+
+func TestGameDay(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(tf.response)
+	}))
+	defer working.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	brokenProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer brokenProxy.Close()
+
+	f, err := ioutil.TempFile("", "cert")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile failed: %s", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(tf.certDER); err != nil {
+		t.Fatalf("Failed to write certificate: %s", err)
+	}
+	f.Close()
+	// the first responder is picked for the initial fetch, so the
+	// others are fallbacks
+	restore := stapledRand.SetSource(fixedSource(0))
+	err = tf.s.c.AddFromCertificate(f.Name(), tf.issuer, []string{tf.upstream.URL, broken.URL, working.URL})
+	restore()
+	if err != nil {
+		t.Fatalf("Failed to add entry to cache: %s", err)
+	}
+
+	// plain HTTP proxies are sent the request for the responder, which
+	// the working proxy answers itself
+	err = WithGameDay(GameDay{
+		Interval: time.Hour,
+		Sample:   10,
+		Proxies:  []string{working.URL, brokenProxy.URL},
+	})(tf.s)
+	if err != nil {
+		t.Fatalf("WithGameDay failed: %s", err)
+	}
+	tf.s.runGameDay()
+	events := tf.s.gameDayEvents(tf.fc.Now())
+	if len(events) != 2 {
+		t.Fatalf("Expected two events, got %d: %v", len(events), events)
+	}
+	subjects := map[string]bool{}
+	for _, event := range events {
+		if event.Kind != notify.FallbackBroken {
+			t.Fatalf("Expected a fallback-broken event, got %v", event)
+		}
+		subjects[event.Subject] = true
+	}
+	if !subjects[broken.URL] || !subjects[brokenProxy.URL] {
+		t.Fatalf("Expected events for %s and %s, got %v", broken.URL, brokenProxy.URL, events)
+	}
+}
@@ -0,0 +1,157 @@
+package stapled
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+// issuerMaxAge is how long clients may cache a issuer certificate
+// served by /issuer/ for, it never changes for a given key hash
+const issuerMaxAge = 24 * time.Hour
+
+// IssuerKeyHash returns the hex SHA-256 hash of the public key of
+// issuer, as used in OCSP requests, which identifies it in /issuer/
+// and in the issuer export folder
+func IssuerKeyHash(issuer *x509.Certificate) (string, error) {
+	_, keyHash, err := common.HashIssuer(crypto.SHA256, issuer.RawSubject, issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(keyHash), nil
+}
+
+func pemIssuer(issuer *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.Raw})
+}
+
+// issuerHandler serves the issuer certificate identified by
+// /issuer/<hex issuer key hash>, the key hash may use any of the
+// supported hashes. It is served as DER, or as PEM if the format
+// query parameter is pem or the client only accepts PEM
+func (s *Server) issuerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keyHash, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/issuer/"))
+	if err != nil || len(keyHash) == 0 {
+		http.Error(w, "expected /issuer/<issuer key hash>", http.StatusBadRequest)
+		return
+	}
+	issuer, present := s.c.IssuerByKeyHash(keyHash)
+	if !present {
+		http.Error(w, "issuer not found", http.StatusNotFound)
+		return
+	}
+	body, contentType := issuer.Raw, "application/pkix-cert"
+	if r.URL.Query().Get("format") == "pem" || r.Header.Get("Accept") == "application/x-pem-file" {
+		body, contentType = pemIssuer(issuer), "application/x-pem-file"
+	}
+	eTag := responseETag(body)
+	w.Header().Set("ETag", eTag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(issuerMaxAge/time.Second)))
+	w.Header().Set("Vary", "Accept")
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, eTag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "GET" {
+		w.Write(body)
+	}
+}
+
+// WithIssuerExport periodically writes every cached issuer to folder
+// as <hex SHA-256 issuer key hash>.pem, so that provisioning systems
+// can assemble chains without fetching issuers themselves. Files are
+// only written for issuers that aren't already in the folder
+func WithIssuerExport(folder string, interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		s.issuerExport = &issuerExport{folder: folder, interval: interval, written: make(map[string]bool)}
+		return nil
+	}
+}
+
+type issuerExport struct {
+	folder   string
+	interval time.Duration
+	written  map[string]bool // key hashes of the issuers in folder
+}
+
+// exportIssuers writes the issuers which haven't been written yet
+func (s *Server) exportIssuers() error {
+	ie := s.issuerExport
+	for _, issuer := range s.c.Issuers() {
+		keyHash, err := IssuerKeyHash(issuer)
+		if err != nil {
+			return err
+		}
+		if ie.written[keyHash] {
+			continue
+		}
+		path := filepath.Join(ie.folder, keyHash+".pem")
+		if _, err = os.Stat(path); err != nil {
+			if err = writeFileAtomic(path, pemIssuer(issuer)); err != nil {
+				return err
+			}
+			s.log.Info("[export] Wrote issuer '%s' to '%s'", issuer.Subject, path)
+		}
+		ie.written[keyHash] = true
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with contents using a temporary file
+// in the same folder, so readers never see a partial file
+func writeFileAtomic(path string, contents []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *Server) watchIssuerExport() {
+	if err := s.exportIssuers(); err != nil {
+		s.log.Err("[export] Failed to write issuers to '%s': %s", s.issuerExport.folder, err)
+	}
+	ticker := time.NewTicker(s.issuerExport.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.exportIssuers(); err != nil {
+				s.log.Err("[export] Failed to write issuers to '%s': %s", s.issuerExport.folder, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package stapled
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIssuerHandler(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	keyHash, err := IssuerKeyHash(tf.issuer)
+	if err != nil {
+		t.Fatalf("IssuerKeyHash failed: %s", err)
+	}
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		tf.s.issuerHandler(w, r)
+		return w
+	}
+
+	w := get("/issuer/"+keyHash, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), tf.issuer.Raw) || w.Header().Get("Content-Type") != "application/pkix-cert" {
+		t.Fatalf("Unexpected DER issuer response with Content-Type '%s'", w.Header().Get("Content-Type"))
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=86400" {
+		t.Fatalf("Unexpected Cache-Control: %s", cc)
+	}
+	w = get("/issuer/"+keyHash, http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("Unexpected status code for matching ETag: %d", w.Code)
+	}
+
+	w = get("/issuer/"+keyHash+"?format=pem", nil)
+	block, _ := pem.Decode(w.Body.Bytes())
+	if block == nil || !bytes.Equal(block.Bytes, tf.issuer.Raw) || w.Header().Get("Content-Type") != "application/x-pem-file" {
+		t.Fatalf("Unexpected PEM issuer response: %s", w.Body.String())
+	}
+
+	for path, code := range map[string]int{
+		"/issuer/zz":         http.StatusBadRequest,
+		"/issuer/":           http.StatusBadRequest,
+		"/issuer/0102030405": http.StatusNotFound,
+	} {
+		if w = get(path, nil); w.Code != code {
+			t.Fatalf("Expected %d for '%s', got %d", code, path, w.Code)
+		}
+	}
+}
+
+func TestIssuerExport(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	dir, err := ioutil.TempDir("", "issuer-export")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = WithIssuerExport(dir, time.Minute)(tf.s); err != nil {
+		t.Fatalf("WithIssuerExport failed: %s", err)
+	}
+	if err = tf.s.exportIssuers(); err != nil {
+		t.Fatalf("exportIssuers failed: %s", err)
+	}
+	keyHash, err := IssuerKeyHash(tf.issuer)
+	if err != nil {
+		t.Fatalf("IssuerKeyHash failed: %s", err)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(dir, keyHash+".pem"))
+	if err != nil {
+		t.Fatalf("Failed to read exported issuer: %s", err)
+	}
+	if block, _ := pem.Decode(contents); block == nil || !bytes.Equal(block.Bytes, tf.issuer.Raw) {
+		t.Fatal("Exported issuer doesn't match")
+	}
+}
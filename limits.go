@@ -0,0 +1,82 @@
+package stapled
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+// fdReserve is the number of file descriptors assumed to be used by
+// things other than upstream requests, listeners, the disk cache,
+// logging, and so on
+const fdReserve = 64
+
+// fdWarningFraction is the fraction of the open file limit that the
+// estimated number of file descriptors needed must exceed for a
+// warning to be logged
+const fdWarningFraction = 0.8
+
+// openFileLimit returns the soft limit on open file descriptors
+func openFileLimit() (uint64, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, err
+	}
+	return uint64(rl.Cur), nil
+}
+
+// applyLimits applies the process resource limits in conf, it returns
+// the open file limit, or zero if it couldn't be determined
+func applyLimits(conf *config.Configuration, logger *log.Logger) (uint64, error) {
+	if conf.Limits.MaxProcs > 0 {
+		previous := runtime.GOMAXPROCS(conf.Limits.MaxProcs)
+		logger.Info("Set GOMAXPROCS to %d (was %d)", conf.Limits.MaxProcs, previous)
+	}
+	if conf.Limits.MemoryLimit.Bytes > 0 {
+		if setMemoryLimit(conf.Limits.MemoryLimit.Bytes) {
+			logger.Info("Set soft memory limit to %d bytes", conf.Limits.MemoryLimit.Bytes)
+		} else {
+			logger.Warning("Ignoring limits.memory-limit, stapled was built with a version of Go older than 1.19")
+		}
+	}
+	limit, err := openFileLimit()
+	if err != nil {
+		logger.Warning("Failed to get open file limit: %s", err)
+		return 0, nil
+	}
+	if conf.Limits.MinFileDescriptors > 0 && limit < conf.Limits.MinFileDescriptors {
+		return 0, fmt.Errorf("open file limit is %d but limits.min-file-descriptors is %d, raise it with ulimit -n or LimitNOFILE", limit, conf.Limits.MinFileDescriptors)
+	}
+	return limit, nil
+}
+
+// WithFileDescriptorLimit logs a warning when the number of entries,
+// each of which may open a socket when refreshed, approaches limit
+func WithFileDescriptorLimit(limit uint64) Option {
+	return func(s *Server) error {
+		s.fdLimit = limit
+		return nil
+	}
+}
+
+// checkFileDescriptors warns, once, when the file descriptors that
+// may be needed to refresh every entry at once approach the limit.
+// It is called whenever entries are added, so from several goroutines
+func (s *Server) checkFileDescriptors() {
+	if s.fdLimit == 0 {
+		return
+	}
+	needed := uint64(s.c.Len()) + fdReserve
+	if float64(needed) < float64(s.fdLimit)*fdWarningFraction {
+		atomic.StoreUint32(&s.fdWarned, 0)
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&s.fdWarned, 0, 1) {
+		return
+	}
+	s.log.Warning("%d entries may need up to %d file descriptors when refreshed at once but the open file limit is %d, raise it or set fetcher.max-concurrent-refreshes", s.c.Len(), needed, s.fdLimit)
+}
@@ -0,0 +1,116 @@
+package stapled
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// dialFunc makes the connections used for upstream requests
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func newDialer(local net.IP) *net.Dialer {
+	d := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if local != nil {
+		d.LocalAddr = &net.TCPAddr{IP: local}
+	}
+	return d
+}
+
+// resolveLocalAddr parses a IP address or, if addr isn't one, returns
+// the first IPv4 address of the interface named addr, or the first
+// IPv6 address if it has no IPv4 addresses
+func resolveLocalAddr(addr string) (net.IP, error) {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(addr)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' isn't a IP address or interface: %s", addr, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var v6 net.IP
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+		if v6 == nil {
+			v6 = ipNet.IP
+		}
+	}
+	if v6 == nil {
+		return nil, fmt.Errorf("interface '%s' has no addresses", addr)
+	}
+	return v6, nil
+}
+
+// hostPort returns the host and port connections to the URL u are
+// made to
+func hostPort(u string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Host == "" {
+		return "", errors.New("URL has no host")
+	}
+	if parsed.Port() != "" {
+		return parsed.Host, nil
+	}
+	port := "80"
+	if parsed.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(parsed.Hostname(), port), nil
+}
+
+// localAddrDial returns a dialFunc which makes connections from the
+// local address localAddr, a IP address or interface name, unless
+// the URL of the host being connected to, a proxy or responder, is in
+// overrides, which maps URLs to local addresses. If localAddr is
+// empty and there are no overrides it returns nil so that the default
+// dialer is used
+func localAddrDial(localAddr string, overrides map[string]string) (dialFunc, error) {
+	if localAddr == "" && len(overrides) == 0 {
+		return nil, nil
+	}
+	var def net.IP
+	if localAddr != "" {
+		var err error
+		if def, err = resolveLocalAddr(localAddr); err != nil {
+			return nil, err
+		}
+	}
+	fallback := newDialer(def)
+	dialers := make(map[string]*net.Dialer, len(overrides))
+	for u, addr := range overrides {
+		host, err := hostPort(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL '%s': %s", u, err)
+		}
+		ip, err := resolveLocalAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local address for '%s': %s", u, err)
+		}
+		dialers[host] = newDialer(ip)
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if d, present := dialers[addr]; present {
+			return d.DialContext(ctx, network, addr)
+		}
+		return fallback.DialContext(ctx, network, addr)
+	}, nil
+}
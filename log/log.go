@@ -5,57 +5,128 @@ import (
 	"log/syslog"
 	"os"
 	"path"
+	"sync"
+	"time"
 
 	"github.com/jmhodges/clock"
 )
 
 // Logger provides a syslog logger
 type Logger struct {
-	SyslogWriter *syslog.Writer
+	syslogWriter *syslog.Writer // nil while syslog is unavailable
 	stdoutLevel  int
 	clk          clock.Clock
+	mu           sync.RWMutex
+	stop         chan struct{} // closed by Close
+	closeOnce    sync.Once
 }
 
 const defaultPriority = syslog.LOG_INFO | syslog.LOG_LOCAL0
 
-// NewLogger creates a new Logger
+// syslogRetryInterval is how often a Logger which couldn't connect
+// to syslog tries to connect again
+var syslogRetryInterval = 30 * time.Second
+
+// NewLogger creates a new Logger. If syslog can't be connected to,
+// for instance in a container without /dev/log, every message is
+// printed to stdout, regardless of level, until a connection is made
+// by retrying in the background
 func NewLogger(network, addr string, level int, clk clock.Clock) *Logger {
 	if level == 0 {
 		level = 7
 	}
+	log := &Logger{stdoutLevel: level, clk: clk, stop: make(chan struct{})}
 	syslogger, err := syslog.Dial(network, addr, defaultPriority, "stapled")
 	if err != nil {
-		panic(err)
+		log.print(fmt.Sprintf("[log] Failed to connect to syslog, logging to stdout until it is available: %s", err))
+		go log.reconnect(network, addr, syslogRetryInterval)
+		return log
+	}
+	log.syslogWriter = syslogger
+	return log
+}
+
+// reconnect tries to connect to syslog every interval until it
+// succeeds or the Logger is closed
+func (log *Logger) reconnect(network, addr string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-log.stop:
+			return
+		case <-ticker.C:
+		}
+		syslogger, err := syslog.Dial(network, addr, defaultPriority, "stapled")
+		if err != nil {
+			continue
+		}
+		log.mu.Lock()
+		select {
+		case <-log.stop:
+			// closed while dialing
+			log.mu.Unlock()
+			syslogger.Close()
+			return
+		default:
+		}
+		log.syslogWriter = syslogger
+		log.mu.Unlock()
+		log.Notice("[log] Connected to syslog")
+		return
+	}
+}
+
+// Close stops trying to connect to syslog and closes the connection
+// to it, messages logged afterwards are printed to stdout
+func (log *Logger) Close() error {
+	log.closeOnce.Do(func() { close(log.stop) })
+	log.mu.Lock()
+	w := log.syslogWriter
+	log.syslogWriter = nil
+	log.mu.Unlock()
+	if w == nil {
+		return nil
 	}
-	return &Logger{syslogger, level, clk}
+	return w.Close()
+}
+
+func (log *Logger) print(msg string) {
+	fmt.Printf("%s %11s %s\n",
+		log.clk.Now().Format("15:04:05"),
+		path.Base(os.Args[0]),
+		msg,
+	)
 }
 
 func (log *Logger) logAtLevel(level syslog.Priority, msg string) {
-	if int(level) <= log.stdoutLevel {
-		fmt.Printf("%s %11s %s\n",
-			log.clk.Now().Format("15:04:05"),
-			path.Base(os.Args[0]),
-			msg,
-		)
+	log.mu.RLock()
+	w := log.syslogWriter
+	log.mu.RUnlock()
+	if w == nil || int(level) <= log.stdoutLevel {
+		log.print(msg)
+	}
+	if w == nil {
+		return
 	}
 
 	switch level {
 	case syslog.LOG_ALERT:
-		log.SyslogWriter.Alert(msg)
+		w.Alert(msg)
 	case syslog.LOG_CRIT:
-		log.SyslogWriter.Crit(msg)
+		w.Crit(msg)
 	case syslog.LOG_DEBUG:
-		log.SyslogWriter.Debug(msg)
+		w.Debug(msg)
 	case syslog.LOG_EMERG:
-		log.SyslogWriter.Emerg(msg)
+		w.Emerg(msg)
 	case syslog.LOG_ERR:
-		log.SyslogWriter.Err(msg)
+		w.Err(msg)
 	case syslog.LOG_INFO:
-		log.SyslogWriter.Info(msg)
+		w.Info(msg)
 	case syslog.LOG_WARNING:
-		log.SyslogWriter.Warning(msg)
+		w.Warning(msg)
 	case syslog.LOG_NOTICE:
-		log.SyslogWriter.Notice(msg)
+		w.Notice(msg)
 	}
 }
 
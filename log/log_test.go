@@ -1 +1,78 @@
 package log
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+)
+
+func TestSyslogUnavailable(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "stapled-log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	addr := filepath.Join(tmpDir, "log")
+
+	syslogRetryInterval = 10 * time.Millisecond
+	defer func() { syslogRetryInterval = 30 * time.Second }()
+	// stdout level of -1 so nothing is printed once syslog is connected
+	logger := NewLogger("unixgram", addr, -1, clock.Default())
+	defer logger.Close()
+	logger.Info("logged while syslog is unavailable")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen on '%s': %s", addr, err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	// the logger announces it has connected
+	if _, err = conn.Read(buf); err != nil {
+		t.Fatalf("Logger didn't reconnect to syslog: %s", err)
+	}
+	logger.mu.RLock()
+	connected := logger.syslogWriter != nil
+	logger.mu.RUnlock()
+	if !connected {
+		t.Fatal("Logger didn't attach the syslog writer")
+	}
+}
+
+func TestCloseStopsReconnecting(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "stapled-log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	addr := filepath.Join(tmpDir, "log")
+
+	syslogRetryInterval = 10 * time.Millisecond
+	defer func() { syslogRetryInterval = 30 * time.Second }()
+	logger := NewLogger("unixgram", addr, -1, clock.Default())
+	if err = logger.Close(); err != nil {
+		t.Fatalf("Failed to close logger: %s", err)
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen on '%s': %s", addr, err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err = conn.Read(make([]byte, 1024)); err == nil {
+		t.Fatal("Closed logger reconnected to syslog")
+	}
+	logger.mu.RLock()
+	connected := logger.syslogWriter != nil
+	logger.mu.RUnlock()
+	if connected {
+		t.Fatal("Closed logger attached a syslog writer")
+	}
+}
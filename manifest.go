@@ -0,0 +1,272 @@
+package stapled
+
+import (
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+// ManifestEntry is a certificate listed in a manifest, entries are
+// created from the serial and issuer without the certificate itself
+type ManifestEntry struct {
+	Serial string `json:"serial"` // hex
+	// Issuer is the path of the issuer certificate, or the hex SHA-1
+	// or SHA-256 hash of the public key of a issuer in the issuer
+	// folder
+	Issuer    string            `json:"issuer"`
+	Responder string            `json:"responder"` // defaults to the upstream responders
+	Labels    map[string]string `json:"labels"`
+}
+
+// manifestEntryName returns the name of the entry created for the
+// manifest entry with serial, it includes the canonical ID rather than
+// just the serial since serials are only unique per issuer
+func manifestEntryName(issuer *x509.Certificate, serial *big.Int) string {
+	return "manifest-" + mcache.EntryID(issuer, serial)
+}
+
+// key identifies the contents of me, a entry whose key changes is
+// replaced when the manifest is reloaded
+func (me ManifestEntry) key() string {
+	labels := make([]string, 0, len(me.Labels))
+	for k, v := range me.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return strings.Join([]string{strings.ToLower(me.Serial), me.Issuer, me.Responder, strings.Join(labels, ";")}, "|")
+}
+
+// ParseManifest parses a manifest, if filename ends in .json it is a
+// JSON array of ManifestEntry, otherwise it is CSV with a header row
+// naming the serial, issuer, responder, and label columns, the last
+// two are optional. Labels are written as key=value pairs separated
+// by ';'
+func ParseManifest(filename string, contents []byte) ([]ManifestEntry, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		var entries []ManifestEntry
+		if err := json.Unmarshal(contents, &entries); err != nil {
+			return nil, err
+		}
+		for i, me := range entries {
+			if me.Serial == "" || me.Issuer == "" {
+				return nil, fmt.Errorf("entry %d: serial and issuer are required", i)
+			}
+		}
+		return entries, nil
+	}
+	r := csv.NewReader(strings.NewReader(string(contents)))
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %s", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"serial", "issuer"} {
+		if _, present := columns[required]; !present {
+			return nil, fmt.Errorf("header has no %s column", required)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, present := columns[name]; present && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	var entries []ManifestEntry
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		me := ManifestEntry{
+			Serial:    field(row, "serial"),
+			Issuer:    field(row, "issuer"),
+			Responder: field(row, "responder"),
+		}
+		if me.Serial == "" || me.Issuer == "" {
+			return nil, fmt.Errorf("line %d: serial and issuer are required", line)
+		}
+		if label := field(row, "label"); label != "" {
+			me.Labels = map[string]string{}
+			for _, pair := range strings.Split(label, ";") {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 || kv[0] == "" {
+					return nil, fmt.Errorf("line %d: malformed label '%s', expected key=value", line, pair)
+				}
+				me.Labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		}
+		entries = append(entries, me)
+	}
+	return entries, nil
+}
+
+// parseManifestSerial parses the hex serial of a manifest entry
+func parseManifestSerial(serial string) (*big.Int, error) {
+	s, ok := new(big.Int).SetString(strings.TrimPrefix(strings.ToLower(serial), "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("malformed serial '%s'", serial)
+	}
+	return s, nil
+}
+
+// issuerKeyHash returns the decoded hash if issuer is a hex SHA-1 or
+// SHA-256 hash rather than a path
+func issuerKeyHash(issuer string) ([]byte, bool) {
+	if len(issuer) != 40 && len(issuer) != 64 {
+		return nil, false
+	}
+	hash, err := hex.DecodeString(issuer)
+	return hash, err == nil
+}
+
+// manifest is a manifest file and the entries created from it
+type manifest struct {
+	filename string
+	opts     mcache.CertificateOptions
+	mu       sync.Mutex
+	entries  map[string]string // entry name -> ManifestEntry.key
+	names    map[string]string // ManifestEntry.key -> entry name
+}
+
+// WithManifest creates entries for the certificates listed in the
+// manifest filename, see ParseManifest, when the server starts and
+// each time ReloadManifest is called, using opts
+func WithManifest(filename string, opts mcache.CertificateOptions) Option {
+	return func(s *Server) error {
+		s.manifest = &manifest{
+			filename: filename,
+			opts:     opts,
+			entries:  make(map[string]string),
+			names:    make(map[string]string),
+		}
+		return nil
+	}
+}
+
+// ReloadManifest reads the manifest again, adding entries for new
+// rows, replacing the entries for rows that changed, and removing the
+// entries for rows that are gone. If the manifest can't be read or
+// parsed the existing entries are kept
+func (s *Server) ReloadManifest() error {
+	if s.manifest == nil {
+		return nil
+	}
+	m := s.manifest
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	contents, err := ioutil.ReadFile(m.filename)
+	if err != nil {
+		return err
+	}
+	entries, err := ParseManifest(m.filename, contents)
+	if err != nil {
+		return fmt.Errorf("failed to parse manifest '%s': %s", m.filename, err)
+	}
+	issuers := map[string]*x509.Certificate{}
+	listed := make(map[string]struct{}, len(entries))
+	added, failed := 0, 0
+	for _, me := range entries {
+		key := me.key()
+		if name, present := m.names[key]; present {
+			// unchanged rows keep their entry
+			listed[name] = struct{}{}
+			continue
+		}
+		serial, err := parseManifestSerial(me.Serial)
+		if err != nil {
+			s.log.Err("[manifest] Skipping entry in '%s': %s", m.filename, err)
+			failed++
+			continue
+		}
+		opts := m.opts
+		if opts.Issuer, err = s.manifestIssuer(me.Issuer, issuers); err != nil {
+			s.log.Err("[manifest] Failed to add entry for serial %x from '%s': %s", serial, m.filename, err)
+			failed++
+			continue
+		}
+		name := manifestEntryName(opts.Issuer, serial)
+		if _, present := listed[name]; present {
+			s.log.Err("[manifest] Skipping duplicate entry '%s' in '%s'", name, m.filename)
+			failed++
+			continue
+		}
+		listed[name] = struct{}{}
+		if existing, present := m.entries[name]; present {
+			if err := s.c.Remove(name); err != nil {
+				s.log.Err("[manifest] Failed to remove changed entry '%s': %s", name, err)
+			}
+			delete(m.entries, name)
+			delete(m.names, existing)
+		}
+		if me.Responder != "" {
+			opts.Responders = []string{me.Responder}
+		} else if len(opts.Responders) == 0 && len(s.c.DefaultResponders(opts.Issuer)) == 0 {
+			opts.Responders = s.upstreamResponders
+		}
+		if len(me.Labels) > 0 {
+			opts.Labels = me.Labels
+		}
+		if err := s.c.AddFromSerial(name, m.filename, serial, opts); err != nil {
+			s.log.Err("[manifest] Failed to add entry '%s' from '%s': %s", name, m.filename, err)
+			failed++
+			continue
+		}
+		m.entries[name] = key
+		m.names[key] = name
+		added++
+	}
+	removed := 0
+	for name := range m.entries {
+		if _, present := listed[name]; present {
+			continue
+		}
+		if err := s.c.Remove(name); err != nil {
+			s.log.Err("[manifest] Failed to remove entry '%s': %s", name, err)
+		}
+		delete(m.names, m.entries[name])
+		delete(m.entries, name)
+		removed++
+	}
+	s.log.Info("[manifest] Loaded '%s', %d entries added, %d removed, %d failed", m.filename, added, removed, failed)
+	return nil
+}
+
+// manifestIssuer loads the issuer of a manifest entry, issuers read
+// from files are kept in loaded so each is read once
+func (s *Server) manifestIssuer(issuer string, loaded map[string]*x509.Certificate) (*x509.Certificate, error) {
+	if hash, ok := issuerKeyHash(issuer); ok {
+		cert, present := s.c.IssuerByKeyHash(hash)
+		if !present {
+			return nil, mcache.ErrNoIssuer
+		}
+		return cert, nil
+	}
+	if cert, present := loaded[issuer]; present {
+		return cert, nil
+	}
+	cert, err := common.ReadCertificate(issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load issuer '%s': %s", issuer, err)
+	}
+	loaded[issuer] = cert
+	return cert, nil
+}
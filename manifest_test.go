@@ -0,0 +1,145 @@
+package stapled
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+func TestParseManifest(t *testing.T) {
+	entries, err := ParseManifest("certs.csv", []byte("# exported from the inventory\nissuer, serial, label\nissuer.der, 0539, app=web;env=prod\n"))
+	if err != nil {
+		t.Fatalf("ParseManifest failed: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Serial != "0539" || entries[0].Issuer != "issuer.der" || entries[0].Labels["env"] != "prod" {
+		t.Fatalf("Unexpected entries: %+v", entries)
+	}
+	if _, err = ParseManifest("certs.csv", []byte("serial,responder\n0539,http://ocsp\n")); err == nil {
+		t.Fatal("ParseManifest accepted a manifest without a issuer column")
+	}
+	if _, err = ParseManifest("certs.csv", []byte("serial,issuer,label\n0539,issuer.der,app\n")); err == nil {
+		t.Fatal("ParseManifest accepted a malformed label")
+	}
+
+	entries, err = ParseManifest("certs.json", []byte(`[{"serial": "0539", "issuer": "issuer.der", "responder": "http://ocsp"}]`))
+	if err != nil {
+		t.Fatalf("ParseManifest failed: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Responder != "http://ocsp" {
+		t.Fatalf("Unexpected entries: %+v", entries)
+	}
+}
+
+func TestReloadManifest(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	issuerFile := filepath.Join(dir, "issuer.der")
+	if err = ioutil.WriteFile(issuerFile, tf.issuer.Raw, 0644); err != nil {
+		t.Fatalf("Failed to write issuer: %s", err)
+	}
+	// a second issuer which has issued a certificate with the same
+	// serial
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %s", err)
+	}
+	otherTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "other issuer"},
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	otherDER, err := x509.CreateCertificate(rand.Reader, otherTemplate, otherTemplate, key.Public(), key)
+	if err != nil {
+		t.Fatalf("Failed to create other issuer: %s", err)
+	}
+	other, err := x509.ParseCertificate(otherDER)
+	if err != nil {
+		t.Fatalf("Failed to parse other issuer: %s", err)
+	}
+	otherFile := filepath.Join(dir, "other.der")
+	if err = ioutil.WriteFile(otherFile, otherDER, 0644); err != nil {
+		t.Fatalf("Failed to write issuer: %s", err)
+	}
+	otherResponse, err := ocsp.CreateResponse(other, other, ocsp.Response{
+		SerialNumber: big.NewInt(0x539),
+		Status:       ocsp.Good,
+		ThisUpdate:   tf.fc.Now().Add(-time.Hour),
+		NextUpdate:   tf.fc.Now().Add(time.Hour * 24),
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to create response: %s", err)
+	}
+	otherUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(otherResponse)
+	}))
+	defer otherUpstream.Close()
+	name := manifestEntryName(tf.issuer, big.NewInt(0x539))
+	otherName := manifestEntryName(other, big.NewInt(0x539))
+	if name == otherName {
+		t.Fatalf("Entries with the same serial from different issuers have the same name '%s'", name)
+	}
+
+	filename := filepath.Join(dir, "certs.csv")
+	contents := fmt.Sprintf("serial,issuer,responder,label\n539,%s,%s,app=web\n539,%s,%s,app=mail\n", issuerFile, tf.upstream.URL, otherFile, otherUpstream.URL)
+	if err = ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %s", err)
+	}
+	if err = WithManifest(filename, mcache.CertificateOptions{})(tf.s); err != nil {
+		t.Fatalf("WithManifest failed: %s", err)
+	}
+
+	if err = tf.s.ReloadManifest(); err != nil {
+		t.Fatalf("ReloadManifest failed: %s", err)
+	}
+	labels := map[string]string{}
+	for _, info := range tf.s.c.Entries() {
+		if info.Name == name || info.Name == otherName {
+			if info.Source != filename {
+				t.Fatalf("Unexpected entry: %+v", info)
+			}
+			labels[info.Name] = info.Labels["app"]
+		}
+	}
+	if labels[name] != "web" || labels[otherName] != "mail" {
+		t.Fatalf("Unexpected entries created for the manifest: %v", labels)
+	}
+
+	contents = fmt.Sprintf("serial,issuer,responder,label\n539,%s,%s,app=mail\n", otherFile, otherUpstream.URL)
+	if err = ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %s", err)
+	}
+	if err = tf.s.ReloadManifest(); err != nil {
+		t.Fatalf("ReloadManifest failed: %s", err)
+	}
+	remaining := map[string]bool{}
+	for _, info := range tf.s.c.Entries() {
+		remaining[info.Name] = true
+	}
+	if remaining[name] {
+		t.Fatal("Entry wasn't removed when its row was removed from the manifest")
+	}
+	if !remaining[otherName] {
+		t.Fatal("Entry for the same serial from another issuer was removed")
+	}
+}
@@ -0,0 +1,107 @@
+package mcache
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minAIABackoff is how long a issuer URL that couldn't be fetched is
+// skipped for, it doubles with each consecutive failure up to
+// maxAIABackoff
+const (
+	minAIABackoff = time.Minute
+	maxAIABackoff = 6 * time.Hour
+)
+
+// aiaFailure is the consecutive failures of a issuer URL
+type aiaFailure struct {
+	failures int
+	retryAt  time.Time
+}
+
+// aiaTracker remembers the issuer URLs that couldn't be fetched so
+// that certificates whose issuer can't be fetched don't request it
+// every time a watch folder is scanned, it is shared between all
+// entries in a EntryCache
+type aiaTracker struct {
+	mu    sync.Mutex
+	urls  map[string]*aiaFailure
+	hosts map[string]int64 // host -> total failures
+}
+
+func newAIATracker() *aiaTracker {
+	return &aiaTracker{
+		urls:  make(map[string]*aiaFailure),
+		hosts: make(map[string]int64),
+	}
+}
+
+// backingOff checks if u failed recently and shouldn't be fetched
+// until the returned time
+func (at *aiaTracker) backingOff(u string, now time.Time) (time.Time, bool) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	f, present := at.urls[u]
+	if !present || !now.Before(f.retryAt) {
+		return time.Time{}, false
+	}
+	return f.retryAt, true
+}
+
+// failed records a failure to fetch u and returns how long it will
+// be skipped for
+func (at *aiaTracker) failed(u string, now time.Time) time.Duration {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	f, present := at.urls[u]
+	if !present {
+		f = &aiaFailure{}
+		at.urls[u] = f
+	}
+	backoff := minAIABackoff
+	for i := 0; i < f.failures && backoff < maxAIABackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxAIABackoff {
+		backoff = maxAIABackoff
+	}
+	f.failures++
+	f.retryAt = now.Add(backoff)
+	at.hosts[aiaHost(u)]++
+	return backoff
+}
+
+// succeeded forgets any failures of u
+func (at *aiaTracker) succeeded(u string) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	delete(at.urls, u)
+}
+
+func (at *aiaTracker) snapshot() map[string]int64 {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	snapshot := make(map[string]int64, len(at.hosts))
+	for host, failures := range at.hosts {
+		snapshot[host] = failures
+	}
+	return snapshot
+}
+
+// aiaHost returns the host of a issuer URL, failures of URLs that
+// can't be parsed are counted under "unknown"
+func aiaHost(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Hostname() == "" {
+		return "unknown"
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// aiaMetricName is the name of the counter of failures for host,
+// dots are replaced so that hosts don't add levels to StatsD names
+func aiaMetricName(host string) string {
+	return "aia.failures." + strings.Replace(host, ".", "_", -1)
+}
@@ -0,0 +1,78 @@
+package mcache
+
+import (
+	"context"
+	"time"
+)
+
+// boost raises the refresh priority and frequency of a entry until
+// it expires, see EntryCache.Boost
+type boost struct {
+	until    time.Time
+	interval time.Duration
+}
+
+// boosted returns the boost of the entry if it hasn't expired
+func (e *Entry) boosted(now time.Time) (boost, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if !now.Before(e.boost.until) {
+		return boost{}, false
+	}
+	return e.boost, true
+}
+
+// boostDue checks if a boosted entry should be refreshed because its
+// response was fetched more than the boost interval ago
+func (e *Entry) boostDue(now time.Time, lastSync time.Time) bool {
+	b, present := e.boosted(now)
+	return present && !lastSync.Add(b.interval).After(now)
+}
+
+// Boost raises the refresh priority and frequency of the entries with
+// a name, canonical ID, or certificate fingerprint in nameOrIDs until
+// until, for example ahead of deploying their certificates to new
+// frontends. Boosted entries are refreshed before any others, and on
+// every monitor tick once their response was fetched more than
+// interval ago, even if it isn't in its update window. Each entry is
+// also refreshed immediately in the background. Boosting a entry
+// again replaces its boost. It returns the names of the boosted
+// entries and those of nameOrIDs that aren't in the cache
+func (c *EntryCache) Boost(nameOrIDs []string, until time.Time, interval time.Duration) ([]string, []string) {
+	boosted, missing := []string{}, []string{}
+	for _, nameOrID := range nameOrIDs {
+		e, present := c.get(nameOrID)
+		if !present {
+			missing = append(missing, nameOrID)
+			continue
+		}
+		e.mu.Lock()
+		e.boost = boost{until: until, interval: interval}
+		e.mu.Unlock()
+		e.info("Refreshes boosted until %s, every %s", until, interval)
+		boosted = append(boosted, e.name)
+		go func() {
+			ctx, cancel := context.WithTimeout(c.ctx, c.requestTimeout)
+			defer cancel()
+			if err := e.fetchResponse(ctx, c.StableBackings, c.clientFor(e)); err != nil && err != ErrRefreshInProgress && err != ErrStandby {
+				e.err("Failed to refresh boosted response: %s", err)
+			}
+		}()
+	}
+	return boosted, missing
+}
+
+// Boosts returns when the boost of each boosted entry expires, keyed
+// by entry name
+func (c *EntryCache) Boosts() map[string]time.Time {
+	now := c.clk.Now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	boosts := make(map[string]time.Time)
+	for name, e := range c.entries {
+		if b, present := e.boosted(now); present {
+			boosts[name] = b.until
+		}
+	}
+	return boosts
+}
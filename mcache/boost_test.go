@@ -0,0 +1,56 @@
+package mcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBoost(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	fc, c := tf.fc, tf.c
+	for _, name := range []string{"a", "b"} {
+		tf.addEntry(t, name)
+	}
+	if requests := tf.upstreamRequests(); requests != 2 {
+		t.Fatalf("Expected 2 requests, got %d", requests)
+	}
+
+	boosted, missing := c.Boost([]string{"b", "missing"}, fc.Now().Add(time.Hour), 10*time.Minute)
+	if len(boosted) != 1 || boosted[0] != "b" || len(missing) != 1 || missing[0] != "missing" {
+		t.Fatalf("Unexpected boosted and missing entries: %v %v", boosted, missing)
+	}
+	// boosted entries are refreshed immediately
+	for i := 0; tf.upstreamRequests() != 3; i++ {
+		if i == 100 {
+			t.Fatal("Boosted entry wasn't refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if order, n := c.refreshOrder(); n != 1 || order[0].name != "b" {
+		t.Fatalf("Expected the boosted entry to be refreshed first, got %d boosted", n)
+	}
+	if boosts := c.Boosts(); len(boosts) != 1 || !boosts["b"].Equal(fc.Now().Add(time.Hour)) {
+		t.Fatalf("Unexpected boosts: %v", boosts)
+	}
+
+	// only the boosted entry is refreshed once the interval has passed,
+	// the other isn't in its update window yet
+	fc.Add(11 * time.Minute)
+	c.refreshAll()
+	if requests := tf.upstreamRequests(); requests != 4 {
+		t.Fatalf("Expected only the boosted entry to be refreshed, got %d requests", requests)
+	}
+	fc.Add(5 * time.Minute)
+	c.refreshAll()
+	if requests := tf.upstreamRequests(); requests != 4 {
+		t.Fatalf("Boosted entry was refreshed before the interval had passed, got %d requests", requests)
+	}
+
+	// the boost reverts once it expires
+	fc.Add(time.Hour)
+	c.refreshAll()
+	if requests := tf.upstreamRequests(); requests != 4 || len(c.Boosts()) != 0 {
+		t.Fatalf("Expected the boost to have expired, got %d requests", requests)
+	}
+}
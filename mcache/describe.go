@@ -0,0 +1,108 @@
+package mcache
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+func (up UnknownPolicy) String() string {
+	switch up {
+	case ServeUnknown:
+		return "serve"
+	case RetryUnknown:
+		return "retry"
+	case AlertUnknown:
+		return "alert"
+	}
+	return fmt.Sprintf("UnknownPolicy(%d)", int(up))
+}
+
+// EntryDescription describes the entry that would be created for a
+// certificate, see DescribeCertificate
+type EntryDescription struct {
+	Name              string            `yaml:"name"`
+	ID                string            `yaml:"id"`
+	Source            string            `yaml:"source"`
+	Serial            string            `yaml:"serial"`
+	Fingerprint       string            `yaml:"fingerprint"`
+	NotAfter          string            `yaml:"not-after"`
+	MustStaple        bool              `yaml:"must-staple"`
+	Issuer            string            `yaml:"issuer"`
+	IssuerSource      string            `yaml:"issuer-source"` // provided, issuer-cache, or aia
+	Responders        []string          `yaml:"responders"`
+	ResponseName      string            `yaml:"response-name"` // name of the response in the stable backings
+	RequestHash       string            `yaml:"request-hash"`
+	Request           string            `yaml:"request"` // base64 DER
+	RequestExtensions []string          `yaml:"request-extensions,omitempty"`
+	Labels            map[string]string `yaml:"labels,omitempty"`
+	UnknownStatus     string            `yaml:"unknown-status"`
+}
+
+// DescribeCertificate goes through the same steps as
+// AddFromCertificateWithOptions, deriving the name of the entry,
+// resolving its responders and issuer, which may be fetched using AIA,
+// and building its request, but doesn't load or fetch a response or
+// add the entry to the cache
+func (c *EntryCache) DescribeCertificate(filename string, opts CertificateOptions) (*EntryDescription, error) {
+	cert, err := common.ReadCertificate(filename)
+	if err != nil {
+		return nil, err
+	}
+	name, err := c.reserveName(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, present := c.entries[name]; !present {
+			c.releaseName(filepath.Clean(filename))
+		}
+	}()
+	issuerSource := "provided"
+	if opts.Issuer == nil {
+		issuerSource = "aia"
+		if c.issuers.getFromCertificate(cert.RawIssuer, cert.AuthorityKeyId) != nil {
+			issuerSource = "issuer-cache"
+		}
+	}
+	e, err := c.buildEntry(name, filepath.Clean(filename), cert, opts)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, fmt.Errorf("'%s' would be skipped: %w", name, ErrNoResponders)
+	}
+	if err = e.buildRequest(); err != nil {
+		return nil, fmt.Errorf("'%s': failed to build request: %s", name, err)
+	}
+	requestHash := e.requestHash
+	if requestHash == 0 {
+		requestHash = common.DefaultRequestHash()
+	}
+	desc := &EntryDescription{
+		Name:          e.name,
+		ID:            e.id,
+		Source:        e.source,
+		Serial:        fmt.Sprintf("%x", e.serial),
+		Fingerprint:   fmt.Sprintf("%x", e.fingerprint),
+		NotAfter:      e.notAfter.UTC().Format(time.RFC3339),
+		MustStaple:    e.mustStaple,
+		Issuer:        e.issuer.Subject.String(),
+		IssuerSource:  issuerSource,
+		Responders:    e.responders,
+		ResponseName:  e.stableName(),
+		RequestHash:   requestHash.String(),
+		Request:       base64.StdEncoding.EncodeToString(e.request),
+		Labels:        e.labels,
+		UnknownStatus: e.unknownPolicy.String(),
+	}
+	for _, ext := range e.extensions {
+		desc.RequestExtensions = append(desc.RequestExtensions, ext.Id.String())
+	}
+	return desc, nil
+}
@@ -0,0 +1,81 @@
+package mcache
+
+import (
+	"sync"
+	"time"
+)
+
+// DriftStats describes how long before they were fetched the
+// responses from a responder were produced, i.e. the fetch time
+// minus the response ProducedAt. A large sustained drift indicates
+// a problem with the CA signing pipeline, a negative drift usually
+// indicates the local clock is behind
+type DriftStats struct {
+	Last    time.Duration
+	Average time.Duration // exponentially weighted, recent samples count the most
+	Min     time.Duration
+	Max     time.Duration
+	Samples int
+}
+
+// driftWeight is the divisor used to weight new samples into the
+// average, a new sample moves the average 1/driftWeight of the way
+const driftWeight = 5
+
+// driftTracker records the ProducedAt drift of each responder, it is
+// shared between all entries in a EntryCache
+type driftTracker struct {
+	warnAbove  time.Duration
+	responders map[string]*DriftStats
+	mu         sync.Mutex
+}
+
+func newDriftTracker() *driftTracker {
+	return &driftTracker{responders: make(map[string]*DriftStats)}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// record adds a sample for responder and returns the updated stats
+// and whether the average drift is above the warning threshold
+func (dt *driftTracker) record(responder string, drift time.Duration) (DriftStats, bool) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	stats, present := dt.responders[responder]
+	if !present {
+		stats = &DriftStats{Average: drift, Min: drift, Max: drift}
+		dt.responders[responder] = stats
+	} else {
+		stats.Average += (drift - stats.Average) / driftWeight
+		if drift < stats.Min {
+			stats.Min = drift
+		}
+		if drift > stats.Max {
+			stats.Max = drift
+		}
+	}
+	stats.Last = drift
+	stats.Samples++
+	return *stats, dt.warnAbove > 0 && abs(stats.Average) > dt.warnAbove
+}
+
+func (dt *driftTracker) setWarnAbove(threshold time.Duration) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	dt.warnAbove = threshold
+}
+
+func (dt *driftTracker) snapshot() map[string]DriftStats {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	snapshot := make(map[string]DriftStats, len(dt.responders))
+	for responder, stats := range dt.responders {
+		snapshot[responder] = *stats
+	}
+	return snapshot
+}
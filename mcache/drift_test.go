@@ -0,0 +1,38 @@
+package mcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDriftTracker(t *testing.T) {
+	dt := newDriftTracker()
+	dt.setWarnAbove(time.Hour)
+
+	stats, warn := dt.record("a", 10*time.Minute)
+	if warn || stats.Average != 10*time.Minute || stats.Samples != 1 {
+		t.Fatalf("Unexpected stats after first sample: %+v (warn %t)", stats, warn)
+	}
+	// a single outlier shouldn't push the average over the threshold
+	stats, warn = dt.record("a", 4*time.Hour)
+	if warn || stats.Average != 56*time.Minute || stats.Max != 4*time.Hour || stats.Last != 4*time.Hour {
+		t.Fatalf("Unexpected stats after outlier: %+v (warn %t)", stats, warn)
+	}
+	// but a sustained drift should
+	for i := 0; i < 3; i++ {
+		stats, warn = dt.record("a", 4*time.Hour)
+	}
+	if !warn {
+		t.Fatalf("Expected warning for sustained drift: %+v", stats)
+	}
+
+	// negative drift is compared by magnitude
+	if _, warn = dt.record("b", -2*time.Hour); !warn {
+		t.Fatal("Expected warning for negative drift")
+	}
+
+	snapshot := dt.snapshot()
+	if len(snapshot) != 2 || snapshot["a"].Samples != 5 || snapshot["b"].Min != -2*time.Hour {
+		t.Fatalf("Unexpected snapshot: %+v", snapshot)
+	}
+}
@@ -0,0 +1,149 @@
+package mcache
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+// FetchEfficiency counts the successful upstream fetches which didn't
+// produce a new response. A entry or responder with mostly unchanged
+// fetches is refreshed more often than its responses change, and one
+// with mostly NotModified fetches is answering conditional requests
+// from the shared conditional cache
+type FetchEfficiency struct {
+	Fetches     int64
+	Unchanged   int64 // returned the response already held
+	NotModified int64 // answered with 304 Not Modified
+}
+
+// efficiencyTracker records the efficiency of the fetches of each
+// entry and responder, it is shared between all entries in a
+// EntryCache
+type efficiencyTracker struct {
+	mu         sync.Mutex
+	entries    map[string]*FetchEfficiency
+	responders map[string]*FetchEfficiency
+}
+
+func newEfficiencyTracker() *efficiencyTracker {
+	return &efficiencyTracker{
+		entries:    make(map[string]*FetchEfficiency),
+		responders: make(map[string]*FetchEfficiency),
+	}
+}
+
+func (fe *FetchEfficiency) add(unchanged, notModified bool) {
+	fe.Fetches++
+	if unchanged {
+		fe.Unchanged++
+	}
+	if notModified {
+		fe.NotModified++
+	}
+}
+
+// record adds a successful fetch by entry from responder
+func (et *efficiencyTracker) record(entry, responder string, unchanged, notModified bool) {
+	if et == nil {
+		return
+	}
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	counts(et.entries, entry).add(unchanged, notModified)
+	counts(et.responders, responder).add(unchanged, notModified)
+}
+
+// counts returns the counts for key, adding them if they are missing
+func counts(m map[string]*FetchEfficiency, key string) *FetchEfficiency {
+	fe, present := m[key]
+	if !present {
+		fe = &FetchEfficiency{}
+		m[key] = fe
+	}
+	return fe
+}
+
+// forget drops the counts of a removed entry
+func (et *efficiencyTracker) forget(entry string) {
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	delete(et.entries, entry)
+}
+
+// DuplicateResponse is a response body held by more than one entry,
+// usually the same certificate loaded under several names
+type DuplicateResponse struct {
+	Digest [32]byte // SHA-256 of the response
+	Names  []string // sorted
+}
+
+// EntryEfficiency is the fetch efficiency of a entry
+type EntryEfficiency struct {
+	Name string
+	FetchEfficiency
+}
+
+// EfficiencyReport describes how much of the work done keeping the
+// cache fresh was wasted, to guide the tuning of refresh windows and
+// of deduplication between instances
+type EfficiencyReport struct {
+	Entries    int
+	Duplicates []DuplicateResponse // sorted by the first name of each
+	// Wasteful are the entries most of whose fetches, and at least
+	// minWastedFetches, were unchanged, most unchanged first
+	Wasteful   []EntryEfficiency
+	Responders map[string]FetchEfficiency
+	Total      FetchEfficiency
+}
+
+// minWastedFetches is the number of unchanged fetches a entry needs
+// before it can be reported as wasteful, so that entries that have
+// only been refreshed a couple of times aren't
+const minWastedFetches = 3
+
+// Efficiency reports the duplicate responses in the cache and the
+// efficiency of the fetches made since it was created
+func (c *EntryCache) Efficiency() EfficiencyReport {
+	report := EfficiencyReport{Responders: map[string]FetchEfficiency{}}
+	digests := map[[32]byte][]string{}
+	c.mu.RLock()
+	report.Entries = len(c.entries)
+	for name, e := range c.entries {
+		if response := e.current().response; response != nil {
+			digest := common.Sum256(response)
+			digests[digest] = append(digests[digest], name)
+		}
+	}
+	c.efficiency.mu.Lock()
+	for name, fe := range c.efficiency.entries {
+		if _, present := c.entries[name]; present && fe.Unchanged >= minWastedFetches && fe.Unchanged*2 > fe.Fetches {
+			report.Wasteful = append(report.Wasteful, EntryEfficiency{name, *fe})
+		}
+	}
+	for responder, fe := range c.efficiency.responders {
+		report.Responders[responder] = *fe
+		report.Total.Fetches += fe.Fetches
+		report.Total.Unchanged += fe.Unchanged
+		report.Total.NotModified += fe.NotModified
+	}
+	c.efficiency.mu.Unlock()
+	c.mu.RUnlock()
+
+	for digest, names := range digests {
+		if len(names) > 1 {
+			sort.Strings(names)
+			report.Duplicates = append(report.Duplicates, DuplicateResponse{digest, names})
+		}
+	}
+	sort.Slice(report.Duplicates, func(i, j int) bool { return report.Duplicates[i].Names[0] < report.Duplicates[j].Names[0] })
+	sort.Slice(report.Wasteful, func(i, j int) bool {
+		a, b := report.Wasteful[i], report.Wasteful[j]
+		if a.Unchanged != b.Unchanged {
+			return a.Unchanged > b.Unchanged
+		}
+		return a.Name < b.Name
+	})
+	return report
+}
@@ -0,0 +1,38 @@
+package mcache
+
+import "testing"
+
+func TestEfficiency(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	c := tf.c
+	for _, name := range []string{"b", "a"} {
+		tf.addEntry(t, name)
+	}
+	for i := 0; i < minWastedFetches; i++ {
+		if err := c.Refresh("a"); err != nil {
+			t.Fatalf("Failed to refresh entry: %s", err)
+		}
+	}
+
+	report := c.Efficiency()
+	if report.Entries != 2 || len(report.Duplicates) != 1 || len(report.Duplicates[0].Names) != 2 || report.Duplicates[0].Names[0] != "a" {
+		t.Fatalf("Expected both entries to hold the same response, got %+v", report.Duplicates)
+	}
+	// the first fetch of each entry produced a new response
+	expected := FetchEfficiency{Fetches: 1 + minWastedFetches, Unchanged: minWastedFetches}
+	if len(report.Wasteful) != 1 || report.Wasteful[0].Name != "a" || report.Wasteful[0].FetchEfficiency != expected {
+		t.Fatalf("Expected only entry a to be wasteful, got %+v", report.Wasteful)
+	}
+	expected.Fetches++
+	if report.Responders[tf.srv.URL] != expected || report.Total != expected {
+		t.Fatalf("Unexpected responder efficiency: %+v", report.Responders)
+	}
+
+	if err := c.Remove("a"); err != nil {
+		t.Fatalf("Failed to remove entry: %s", err)
+	}
+	if report = c.Efficiency(); len(report.Duplicates) != 0 || len(report.Wasteful) != 0 || report.Total != expected {
+		t.Fatalf("Unexpected report after removing a entry: %+v", report)
+	}
+}
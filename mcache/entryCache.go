@@ -4,18 +4,21 @@ import (
 	"bytes"
 	"context"
 	"crypto"
-	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash"
 	"io/ioutil"
 	"math/big"
-	mrand "math/rand"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/jmhodges/clock"
@@ -24,37 +27,132 @@ import (
 	"github.com/rolandshoemaker/stapled/common"
 	"github.com/rolandshoemaker/stapled/config"
 	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/metrics"
 	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+	"github.com/rolandshoemaker/stapled/pack"
+	"github.com/rolandshoemaker/stapled/rand"
 	"github.com/rolandshoemaker/stapled/scache"
+	"github.com/rolandshoemaker/stapled/tracing"
 )
 
+// responseState is the response held by a entry and its metadata, it
+// is never modified once stored so lookups can read it without taking
+// the entry lock and never see a partially updated response
+type responseState struct {
+	response         []byte
+	digest           [32]byte // SHA-256 of response
+	eTag             string
+	responder        string // responder the response was last fetched from
+	maxAge           time.Duration
+	lastSync         time.Time
+	nextUpdate       time.Time
+	thisUpdate       time.Time
+	status           int
+	revokedAt        time.Time
+	revocationReason int
+}
+
+// emptyState is the state of a entry that hasn't loaded a response
+var emptyState = &responseState{}
+
+// CachedResponse is a response along with the values derived from it
+// that are served with it, they are computed once when the response is
+// stored rather than for every request
+type CachedResponse struct {
+	Response   []byte
+	Digest     [32]byte // SHA-256 of Response
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+func (st *responseState) cached() CachedResponse {
+	return CachedResponse{st.response, st.digest, st.thisUpdate, st.nextUpdate}
+}
+
 // Entry represents a cache entry
 type Entry struct {
-	name     string
-	log      *log.Logger
-	clk      clock.Clock
-	lastSync time.Time
+	name   string
+	id     string // canonical ID, see entryID
+	source string // certificate file the entry was created from, if any
+	log    *log.Logger
+	clk    clock.Clock
 
 	// cert related
-	serial *big.Int
-	issuer *x509.Certificate
+	serial      *big.Int
+	issuer      *x509.Certificate
+	notAfter    time.Time // zero if the entry wasn't created from a certificate
+	fingerprint [32]byte  // SHA-256 of the certificate, zero if the entry wasn't created from one
+	mustStaple  bool      // certificate has the TLS feature extension with status_request
 
 	// request related
 	responders []string
+	client     *http.Client // overrides the EntryCache client if set
 	timeout    time.Duration
 	request    []byte
+	extensions []pkix.Extension // added to request when it is built
+	nonce      bool             // send a nonce to every responder
 
-	// response related
-	maxAge           time.Duration
-	eTag             string
-	response         []byte
-	responseFilename string
-	nextUpdate       time.Time
-	thisUpdate       time.Time
+	labels        map[string]string
+	unknownPolicy UnknownPolicy
+
+	// response related, state holds a *responseState and is replaced
+	// while holding mu so that updates don't race
+	state            atomic.Value
+	responseFilename string // name in the stable backings, if it isn't name
+
+	// set while refreshes are failing
+	failingSince time.Time
+	lastError    string
+
+	boost        boost  // zero unless the entry has been boosted, see EntryCache.Boost
+	disagreement string // set while the last new response was rejected by the quorum
+
+	// shared between all entries in a EntryCache
+	fetchCache      *stapledOCSP.ConditionalCache
+	drift           *driftTracker
+	efficiency      *efficiencyTracker
+	counters        *fetchCounters
+	inflight        *refreshTracker
+	fetchBackoff    stapledOCSP.Backoff
+	stableSelection StableSelection
+	requestHash     crypto.Hash // used to build the upstream request, SHA-1 if zero
+	lightweight     bool        // enforce the RFC 5019 lightweight profile
+	election        *leaderElection
+	responderCheck  *stapledOCSP.ResponderChecker
+	quorum          *Quorum      // nil unless new responses are confirmed
+	standby         *standby     // nil unless the cache is a standby
+	metrics         metrics.Sink // nil drops metrics
+
+	history *fetchHistory // nil if no upstream requests are remembered
 
 	mu *sync.RWMutex
 }
 
+// stableName returns the name the response is stored under in the
+// stable backings
+func (e *Entry) stableName() string {
+	if e.responseFilename != "" {
+		return e.responseFilename
+	}
+	return e.name
+}
+
+// sink returns the metrics sink of the entry
+func (e *Entry) sink() metrics.Sink {
+	if e.metrics == nil {
+		return metrics.Discard
+	}
+	return e.metrics
+}
+
+// current returns the response state, it doesn't require the lock
+func (e *Entry) current() *responseState {
+	if st, ok := e.state.Load().(*responseState); ok {
+		return st
+	}
+	return emptyState
+}
+
 // NewEntry creates a basic unpopulated Entry
 func NewEntry(log *log.Logger, clk clock.Clock) *Entry {
 	return &Entry{
@@ -66,11 +164,38 @@ func NewEntry(log *log.Logger, clk clock.Clock) *Entry {
 
 func (e *Entry) init(ctx context.Context, stableBackings []scache.Cache, client *http.Client) error {
 	if e.issuer == nil {
-		return errors.New("entry must have non-nil issuer")
+		return ErrNoIssuer
+	}
+	if e.lightweight && len(e.extensions) > 0 {
+		return fmt.Errorf("%w: request extensions can't be sent upstream", stapledOCSP.ErrNotLightweight)
 	}
+	if e.lightweight && e.nonce {
+		return fmt.Errorf("%w: nonces can't be sent upstream", stapledOCSP.ErrNotLightweight)
+	}
+	if err := e.buildRequest(); err != nil {
+		return err
+	}
+	if e.loadFromStable(ctx, stableBackings) {
+		return nil
+	}
+	err := e.refreshResponse(ctx, stableBackings, client)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// buildRequest builds the request sent upstream for e, if it wasn't
+// provided, and normalizes its responders
+func (e *Entry) buildRequest() error {
 	if e.request == nil {
-		issuerNameHash, issuerKeyHash, err := common.HashNameAndPKI(
-			crypto.SHA1.New(),
+		requestHash := e.requestHash
+		if requestHash == 0 {
+			requestHash = common.DefaultRequestHash()
+		}
+		issuerNameHash, issuerKeyHash, err := common.HashIssuer(
+			requestHash,
 			e.issuer.RawSubject,
 			e.issuer.RawSubjectPublicKeyInfo,
 		)
@@ -78,12 +203,12 @@ func (e *Entry) init(ctx context.Context, stableBackings []scache.Cache, client
 			return err
 		}
 		ocspRequest := &ocsp.Request{
-			HashAlgorithm:  crypto.SHA1,
+			HashAlgorithm:  requestHash,
 			IssuerNameHash: issuerNameHash,
 			IssuerKeyHash:  issuerKeyHash,
 			SerialNumber:   e.serial,
 		}
-		e.request, err = ocspRequest.Marshal()
+		e.request, err = stapledOCSP.MarshalRequest(ocspRequest, e.extensions)
 		if err != nil {
 			return err
 		}
@@ -91,20 +216,184 @@ func (e *Entry) init(ctx context.Context, stableBackings []scache.Cache, client
 	for i := range e.responders {
 		e.responders[i] = strings.TrimSuffix(e.responders[i], "/")
 	}
+	return nil
+}
+
+// StableSelection controls which response is used when a entry is
+// loaded from multiple stable backings
+type StableSelection int
+
+const (
+	// FirstStable uses the first valid response, in the order the
+	// backings were provided
+	FirstStable StableSelection = iota
+	// FreshestStable reads every backing and uses the valid response
+	// with the latest ThisUpdate, backings holding a older response,
+	// or none, have the freshest response written back to them
+	FreshestStable
+)
+
+// loadFromStable loads a response from the stable backings according
+// to the entry stable selection policy, it returns false if no valid
+// response was found
+func (e *Entry) loadFromStable(ctx context.Context, stableBackings []scache.Cache) bool {
+	if e.stableSelection != FreshestStable {
+		for _, s := range stableBackings {
+			resp, respBytes := e.readStable(ctx, s)
+			if resp == nil {
+				continue
+			}
+			e.updateResponse(ctx, "", 0, "", resp, respBytes, nil)
+			return true // return first response from a stable cache backing
+		}
+		return false
+	}
+
+	type stableRead struct {
+		backing   scache.Cache
+		resp      *ocsp.Response
+		respBytes []byte
+	}
+	reads := make([]stableRead, len(stableBackings))
+	var freshest *stableRead
+	for i, s := range stableBackings {
+		resp, respBytes := e.readStable(ctx, s)
+		reads[i] = stableRead{s, resp, respBytes}
+		if resp != nil && (freshest == nil || resp.ThisUpdate.After(freshest.resp.ThisUpdate)) {
+			freshest = &reads[i]
+		}
+	}
+	if freshest == nil {
+		return false
+	}
+	e.updateResponse(ctx, "", 0, "", freshest.resp, freshest.respBytes, nil)
+	for _, r := range reads {
+		if r.resp == nil || !bytes.Equal(r.respBytes, freshest.respBytes) {
+			e.info("Repairing stable backing with a older or missing response")
+			e.writeStable(ctx, r.backing, freshest.respBytes)
+		}
+	}
+	return true
+}
+
+// readStable reads the response for e from s
+func (e *Entry) readStable(ctx context.Context, s scache.Cache) (*ocsp.Response, []byte) {
+	_, span := tracing.Start(ctx, "stable.read")
+	defer span.End()
+	span.SetAttribute("entry", e.name)
+	span.SetAttribute("backing", fmt.Sprintf("%T", s))
+	resp, respBytes := s.Read(e.stableName(), e.serial, e.issuer)
+	span.SetAttribute("found", resp != nil)
+	return resp, respBytes
+}
+
+// writeStable writes response to s as the response for e
+func (e *Entry) writeStable(ctx context.Context, s scache.Cache, response []byte) {
+	_, span := tracing.Start(ctx, "stable.write")
+	defer span.End()
+	span.SetAttribute("entry", e.name)
+	span.SetAttribute("backing", fmt.Sprintf("%T", s))
+	s.Write(e.stableName(), response) // logging is internal
+}
+
+// leaderElection elects a single instance, of those sharing a stable
+// backing which implements scache.Locker, to fetch each response
+type leaderElection struct {
+	owner string
+	lease time.Duration
+}
+
+// leads returns true if this instance should fetch the response for
+// name, which it always should if election is disabled or none of
+// the backings support it
+func (le *leaderElection) leads(name string, stableBackings []scache.Cache) bool {
+	if le == nil {
+		return true
+	}
 	for _, s := range stableBackings {
-		resp, respBytes := s.Read(e.name, e.serial, e.issuer)
-		if resp == nil {
-			continue
+		if locker, ok := scache.Unwrap(s).(scache.Locker); ok {
+			return locker.Lock(name, le.owner, le.lease)
 		}
-		e.updateResponse("", 0, resp, respBytes, nil)
-		return nil // return first response from a stable cache backing
 	}
-	err := e.refreshResponse(ctx, stableBackings, client)
-	if err != nil {
-		return err
+	return true
+}
+
+// followLeader loads the response fetched by the instance which holds
+// the refresh lease from the stable backings, it returns false if
+// they don't contain a newer response than the current one
+func (e *Entry) followLeader(ctx context.Context, stableBackings []scache.Cache) bool {
+	current := e.current().thisUpdate
+	for _, s := range stableBackings {
+		resp, respBytes := e.readStable(ctx, s)
+		if resp != nil && resp.ThisUpdate.After(current) {
+			e.updateResponse(ctx, "", 0, "", resp, respBytes, nil)
+			e.info("Loaded response refreshed by the instance holding the refresh lease")
+			return true
+		}
 	}
+	return false
+}
 
-	return nil
+// EntryInfo is a read-only snapshot of the metadata for a
+// cache entry
+type EntryInfo struct {
+	Name             string
+	ID               string // <hex SHA-256 issuer key hash>:<hex serial>
+	Source           string // certificate file the entry was created from, if any
+	Serial           *big.Int
+	LastSync         time.Time
+	ThisUpdate       time.Time
+	NextUpdate       time.Time
+	ResponseDigest   [32]byte
+	Responder        string // empty if the response was loaded from a stable backing or imported
+	Labels           map[string]string
+	Status           int
+	RevokedAt        time.Time // only set if Status is ocsp.Revoked
+	RevocationReason int
+	Responders       []string
+	NotAfter         time.Time
+	Fingerprint      [32]byte // zero if the entry wasn't created from a certificate
+	MustStaple       bool
+	FailingSince     time.Time
+	LastError        string
+	UnknownPolicy    UnknownPolicy
+	BoostedUntil     time.Time // zero unless the entry is boosted, see EntryCache.Boost
+	Disagreement     string    // set while new responses are rejected by the quorum, see Quorum
+}
+
+// Info returns a snapshot of the entry metadata
+func (e *Entry) Info() EntryInfo {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	st := e.current()
+	var boostedUntil time.Time
+	if e.clk.Now().Before(e.boost.until) {
+		boostedUntil = e.boost.until
+	}
+	return EntryInfo{
+		Name:             e.name,
+		ID:               e.id,
+		Source:           e.source,
+		Serial:           e.serial,
+		LastSync:         st.lastSync,
+		ThisUpdate:       st.thisUpdate,
+		NextUpdate:       st.nextUpdate,
+		ResponseDigest:   common.Sum256(st.response),
+		Responder:        st.responder,
+		Labels:           e.labels,
+		Status:           st.status,
+		RevokedAt:        st.revokedAt,
+		RevocationReason: st.revocationReason,
+		Responders:       e.responders,
+		NotAfter:         e.notAfter,
+		Fingerprint:      e.fingerprint,
+		MustStaple:       e.mustStaple,
+		FailingSince:     e.failingSince,
+		LastError:        e.lastError,
+		UnknownPolicy:    e.unknownPolicy,
+		BoostedUntil:     boostedUntil,
+		Disagreement:     e.disagreement,
+	}
 }
 
 // info makes a Info log.Logger call tagged with the entry name
@@ -119,70 +408,189 @@ func (e *Entry) err(msg string, args ...interface{}) {
 
 // updateResponse updates the actual response body/metadata
 // stored in the entry
-func (e *Entry) updateResponse(eTag string, maxAge int, resp *ocsp.Response, respBytes []byte, stableBackings []scache.Cache) {
+func (e *Entry) updateResponse(ctx context.Context, eTag string, maxAge int, responder string, resp *ocsp.Response, respBytes []byte, stableBackings []scache.Cache) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	e.eTag = eTag
-	e.maxAge = time.Second * time.Duration(maxAge)
-	e.lastSync = e.clk.Now()
+	st := *e.current()
+	st.eTag = eTag
+	st.responder = responder
+	st.maxAge = time.Second * time.Duration(maxAge)
+	st.lastSync = e.clk.Now()
 	if resp != nil {
 		e.info("Updating with new response, expires in %s", common.HumanDuration(resp.NextUpdate.Sub(e.clk.Now())))
-		e.response = respBytes
-		e.nextUpdate = resp.NextUpdate
-		e.thisUpdate = resp.ThisUpdate
+		st.response = respBytes
+		st.digest = common.Sum256(respBytes)
+		st.nextUpdate = resp.NextUpdate
+		st.thisUpdate = resp.ThisUpdate
+		st.status = resp.Status
+		st.revokedAt = resp.RevokedAt
+		st.revocationReason = resp.RevocationReason
+	}
+	e.state.Store(&st)
+	if resp != nil {
 		for _, s := range stableBackings {
-			s.Write(e.name, e.response) // logging is internal
+			e.writeStable(ctx, s, st.response)
 		}
 	}
 }
 
 // refreshResponse fetches and verifies a response and replaces
-// the current response if it is valid and newer
+// the current response if it is valid and newer, if it is time
+// to update the entry
 func (e *Entry) refreshResponse(ctx context.Context, stableBackings []scache.Cache, client *http.Client) error {
 	if !e.timeToUpdate() {
 		return nil
 	}
-	resp, respBytes, eTag, maxAge, err := stapledOCSP.Fetch(
+	if !e.standby.fetches() {
+		// the active instance refreshes the response, a standby only
+		// loads it once it is written to shared stable backings
+		e.followLeader(ctx, stableBackings)
+		return nil
+	}
+	if len(e.responders) == 0 {
+		// entries promoted from the stable backings without any
+		// upstream responders can only be refreshed from them
+		if !e.loadFromStable(ctx, stableBackings) {
+			return errors.New("no responders to fetch a response from and no response in stable backings")
+		}
+		return nil
+	}
+	if !e.election.leads(e.name, stableBackings) {
+		if e.followLeader(ctx, stableBackings) {
+			return nil
+		}
+		if e.current().response != nil {
+			e.info("Waiting for the instance holding the refresh lease to refresh the response")
+			return nil
+		}
+		// nothing to serve at all, fetching a duplicate response is
+		// better than not having one
+	}
+	return e.fetchResponse(ctx, stableBackings, client)
+}
+
+// recordResult tracks how long refreshes have been failing for
+func (e *Entry) recordResult(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err == nil {
+		e.failingSince = time.Time{}
+		e.lastError = ""
+		return
+	}
+	if e.failingSince.IsZero() {
+		e.failingSince = e.clk.Now()
+	}
+	e.lastError = err.Error()
+}
+
+// fetchResponse fetches and verifies a response and replaces the
+// current response if it is valid and newer
+func (e *Entry) fetchResponse(ctx context.Context, stableBackings []scache.Cache, client *http.Client) (err error) {
+	ctx, span := tracing.Start(ctx, "entry.refresh")
+	span.SetAttribute("entry", e.name)
+	span.SetAttribute("serial", fmt.Sprintf("%x", e.serial))
+	defer func() { tracing.End(span, err) }()
+	if !e.standby.fetches() {
+		return ErrStandby
+	}
+	sink := e.sink()
+	if !e.inflight.begin(e.name) {
+		e.counters.skip()
+		sink.Counter("fetch.skipped", 1)
+		return ErrRefreshInProgress
+	}
+	defer e.inflight.end(e.name)
+	defer func() {
+		e.recordResult(err)
+		e.counters.record(err)
+		if err != nil {
+			sink.Counter("fetch.failures", 1)
+			var fe *stapledOCSP.FetchError
+			if errors.As(err, &fe) && fe.Class != 0 {
+				sink.Counter("fetch.failures."+fe.Class.String(), 1)
+			}
+		} else {
+			sink.Counter("fetch.refreshes", 1)
+		}
+	}()
+	if e.history != nil {
+		ctx = stapledOCSP.WithAttemptObserver(ctx, e.history.observe)
+	}
+	started := e.clk.Now()
+	result, err := stapledOCSP.Fetch(
 		ctx,
 		e.log,
+		e.clk,
+		e.fetchBackoff,
 		e.responders,
 		client,
 		e.request,
-		e.eTag,
+		e.fetchCache,
 		e.issuer,
 	)
+	sink.Timing("fetch.duration", e.clk.Now().Sub(started))
 	if err != nil {
 		return err
 	}
+	e.counters.addBytes(result.BytesRead)
+	sink.Counter("fetch.upstream-bytes", int64(result.BytesRead))
 
-	if resp != nil {
-		err = stapledOCSP.VerifyResponse(e.clk.Now(), e.serial, resp)
-		if err != nil {
-			return err
+	if err = e.verifyResponse(ctx, client, result.Response); err != nil {
+		e.history.reject(err)
+		return err
+	}
+	if result.Response.Status == ocsp.Unknown {
+		e.counters.unknown()
+		sink.Counter("fetch.unknown", 1)
+		switch e.unknownPolicy {
+		case RetryUnknown:
+			e.history.reject(ErrUnknownStatus)
+			return ErrUnknownStatus
+		case AlertUnknown:
+			e.err("Responder '%s' returned the status Unknown, the certificate may not have been issued by the expected issuer", result.Responder)
 		}
 	}
 
-	e.mu.RLock()
-	if resp == nil || bytes.Compare(respBytes, e.response) == 0 {
-		e.mu.RUnlock()
+	unchanged := bytes.Equal(result.Body, e.current().response)
+	e.efficiency.record(e.name, result.Responder, unchanged, result.BytesRead == 0)
+	if unchanged {
 		e.info("Response hasn't changed since last sync")
-		e.updateResponse(eTag, maxAge, nil, nil, stableBackings)
+		e.updateResponse(ctx, result.ETag, result.MaxAge, result.Responder, nil, nil, stableBackings)
 		return nil
 	}
-	e.mu.RUnlock()
 
-	e.updateResponse(eTag, maxAge, resp, respBytes, stableBackings)
+	if result, err = e.confirmResponse(ctx, client, result); err != nil {
+		e.history.reject(err)
+		return err
+	}
+	e.updateResponse(ctx, result.ETag, result.MaxAge, result.Responder, result.Response, result.Body, stableBackings)
 	e.info("Response has been refreshed")
+	e.recordDrift(result.Responder, e.clk.Now().Sub(result.Response.ProducedAt))
 	return nil
 }
 
+// recordDrift records the ProducedAt drift of a newly fetched
+// response, only new responses are recorded since a unchanged
+// response will have drifted by however long it has been cached for
+func (e *Entry) recordDrift(responder string, drift time.Duration) {
+	if e.drift == nil {
+		return
+	}
+	if stats, warn := e.drift.record(responder, drift); warn {
+		e.log.Warning("[entry:%s] Responses from '%s' have a average ProducedAt drift of %s (this response %s)", e.name, responder, stats.Average, drift)
+	}
+}
+
 // refreshAndLog is a small wrapper around refreshResponse
 // for when a caller wants to run it in a goroutine and doesn't
 // want to handle the returned error itself
 func (e *Entry) refreshAndLog(ctx context.Context, stableBackings []scache.Cache, client *http.Client) {
 	err := e.refreshResponse(ctx, stableBackings, client)
-	if err != nil {
-		e.err("Failed to refresh response", err)
+	if err == ErrRefreshInProgress {
+		e.info("Refresh skipped, already running")
+	} else if err != nil {
+		e.err("Failed to refresh response: %s", err)
 	}
 }
 
@@ -190,19 +598,22 @@ func (e *Entry) refreshAndLog(ctx context.Context, stableBackings []scache.Cache
 // because cache parameters expired or it is in it's update window
 func (e *Entry) timeToUpdate() bool {
 	now := e.clk.Now()
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	if e.response == nil {
+	st := e.current()
+	if st.response == nil {
 		// not fetched anything previously
 		return true
 	}
-	if e.nextUpdate.Before(now) {
+	if st.nextUpdate.Before(now) {
 		e.info("Stale response, updating immediately")
 		return true
 	}
-	if e.maxAge > 0 {
+	if e.boostDue(now, st.lastSync) {
+		e.info("Boosted response is older than the boost interval, updating immediately")
+		return true
+	}
+	if st.maxAge > 0 {
 		// cache max age has expired
-		if e.lastSync.Add(e.maxAge).Before(now) {
+		if st.lastSync.Add(st.maxAge).Before(now) {
 			e.info("max-age has expired, updating immediately")
 			return true
 		}
@@ -211,14 +622,17 @@ func (e *Entry) timeToUpdate() bool {
 	// update window is last quarter of NextUpdate - ThisUpdate
 	// TODO: support using NextPublish instead of ThisUpdate if provided
 	// in responses
-	windowSize := e.nextUpdate.Sub(e.thisUpdate) / 4
-	updateWindowStarts := e.nextUpdate.Add(-windowSize)
+	windowSize := st.nextUpdate.Sub(st.thisUpdate) / 4
+	updateWindowStarts := st.nextUpdate.Add(-windowSize)
 	if updateWindowStarts.After(now) {
 		return false
 	}
 
 	// randomly pick time in update window
-	updateTime := updateWindowStarts.Add(time.Second * time.Duration(mrand.Intn(int(windowSize.Seconds()))))
+	updateTime := updateWindowStarts
+	if windowSize > 0 {
+		updateTime = updateTime.Add(time.Duration(rand.Int63n(int64(windowSize))))
+	}
 	if updateTime.Before(now) {
 		e.info("Time to update")
 		return true
@@ -233,40 +647,142 @@ type EntryCache struct {
 	clk            clock.Clock
 	requestTimeout time.Duration
 	entries        map[string]*Entry   // one-to-one map keyed on name -> entry
-	lookupMap      map[[32]byte]*Entry // many-to-one map keyed on sha256 hashed OCSP requests -> entry
+	sources        map[string]string   // certificate filename -> entry name
+	sourceNames    map[string]string   // entry name -> certificate filename
+	lookupMap      *lookupMap          // many-to-one map keyed on sha256 hashed OCSP requests -> entry
+	serials        map[string][]*Entry // hex serial -> entries, see EntriesForSerial
 	StableBackings []scache.Cache
 	issuers        *issuerCache
+	fetchCache     *stapledOCSP.ConditionalCache
+	drift          *driftTracker
+	efficiency     *efficiencyTracker
+	aia            *aiaTracker
+	counters       *fetchCounters
+	inflight       *refreshTracker
 	client         *http.Client
 	hashes         config.SupportedHashes
-	mu             sync.RWMutex
+
+	maxConcurrentRefreshes int
+	fetchBackoff           stapledOCSP.Backoff
+	historySize            int
+	stableSelection        StableSelection
+	requestHash            crypto.Hash
+	lightweight            bool
+	rampInterval           time.Duration
+	rampThreshold          int
+	stableMissMemo         time.Duration
+	noResponderPolicy      NoResponderPolicy
+	issuerResponders       []IssuerResponders
+	election               *leaderElection
+	responderCheck         *stapledOCSP.ResponderChecker
+	quorum                 *Quorum
+	standby                *standby
+	metrics                metrics.Sink
+
+	// parent of the contexts used for every upstream request,
+	// canceled by Close
+	ctx          context.Context
+	cancel       context.CancelFunc
+	stableMisses map[[32]byte]time.Time // lookup key -> when the miss expires
+
+	mu sync.RWMutex
 }
 
+// conditionalCacheSize is the maximum number of responses kept in the
+// shared conditional request cache
+const conditionalCacheSize = 4096
+
+// defaultStableMissMemo is how long a request that wasn't found in
+// the stable backings is remembered for before they are read again
+const defaultStableMissMemo = 30 * time.Second
+
+// defaultMaxIssuers is the number of issuers cached before issuers
+// no entry uses are evicted
+const defaultMaxIssuers = 1024
+
+// maxStableMisses bounds the number of remembered stable backing
+// misses, when exceeded expired misses are dropped and if that isn't
+// enough every miss is forgotten
+const maxStableMisses = 8192
+
 // NewEntryCache constructs a EntryCache, starts the monitor, and returns it
 func NewEntryCache(clk clock.Clock, logger *log.Logger, monitorTick time.Duration, stableBackings []scache.Cache, client *http.Client, timeout time.Duration, issuers []*x509.Certificate, supportedHashes config.SupportedHashes, disableMonitor bool) *EntryCache {
 	c := &EntryCache{
 		log:            logger,
 		entries:        make(map[string]*Entry),
-		lookupMap:      make(map[[32]byte]*Entry),
+		sources:        make(map[string]string),
+		sourceNames:    make(map[string]string),
+		lookupMap:      newLookupMap(),
+		serials:        make(map[string][]*Entry),
 		StableBackings: stableBackings,
 		client:         client,
 		requestTimeout: timeout,
 		clk:            clk,
 		issuers:        newIssuerCache(issuers, supportedHashes),
+		fetchCache:     stapledOCSP.NewConditionalCache(conditionalCacheSize),
+		drift:          newDriftTracker(),
+		efficiency:     newEfficiencyTracker(),
+		aia:            newAIATracker(),
+		counters:       new(fetchCounters),
+		inflight:       newRefreshTracker(),
 		hashes:         supportedHashes,
+		stableMissMemo: defaultStableMissMemo,
+		historySize:    defaultHistorySize,
+		stableMisses:   make(map[[32]byte]time.Time),
+	}
+	c.issuers.onEvict = func(issuer *x509.Certificate) {
+		c.log.Info("[cache] Evicted issuer '%s' from the issuer cache, no entries use it", issuer.Subject)
 	}
+	c.issuers.setMax(defaultMaxIssuers)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 	if !disableMonitor {
 		go c.monitor(monitorTick)
 	}
 	return c
 }
 
-func hashEntry(h hash.Hash, name, pkiBytes []byte, serial *big.Int) ([32]byte, error) {
-	issuerNameHash, issuerKeyHash, err := common.HashNameAndPKI(h, name, pkiBytes)
+// keyHasher holds the state needed to compute a lookup key, they
+// are pooled so that lookups on the hot path don't allocate
+type keyHasher struct {
+	h      hash.Hash
+	serial [32]byte
+	sum    [32]byte
+}
+
+var keyHashers = sync.Pool{
+	New: func() interface{} { return &keyHasher{h: common.NewSHA256()} },
+}
+
+// lookupKey computes the lookupMap key for a issuer name hash,
+// issuer key hash, and serial
+func lookupKey(nameHash, keyHash []byte, serial *big.Int) [32]byte {
+	kh := keyHashers.Get().(*keyHasher)
+	defer keyHashers.Put(kh)
+
+	var serialBytes []byte
+	if n := (serial.BitLen() + 7) / 8; n <= len(kh.serial) {
+		serialBytes = serial.FillBytes(kh.serial[:n])
+	} else {
+		serialBytes = serial.Bytes()
+	}
+	kh.h.Reset()
+	kh.h.Write(serialBytes)
+	serialHash := kh.h.Sum(kh.sum[:0])
+
+	kh.h.Reset()
+	kh.h.Write(nameHash)
+	kh.h.Write(keyHash)
+	kh.h.Write(serialHash)
+	kh.h.Sum(kh.sum[:0])
+	return kh.sum
+}
+
+func hashEntry(h crypto.Hash, name, pkiBytes []byte, serial *big.Int) ([32]byte, error) {
+	issuerNameHash, issuerKeyHash, err := common.HashIssuer(h, name, pkiBytes)
 	if err != nil {
 		return [32]byte{}, err
 	}
-	serialHash := sha256.Sum256(serial.Bytes())
-	return sha256.Sum256(append(append(issuerNameHash, issuerKeyHash...), serialHash[:]...)), nil
+	return lookupKey(issuerNameHash, issuerKeyHash, serial), nil
 }
 
 func allHashes(e *Entry, supportedHashes config.SupportedHashes) ([][32]byte, error) {
@@ -274,7 +790,7 @@ func allHashes(e *Entry, supportedHashes config.SupportedHashes) ([][32]byte, er
 	// these should be configurable in case people don't care about
 	// supporting all of these hash algs
 	for _, h := range supportedHashes {
-		hashed, err := hashEntry(h.New(), e.issuer.RawSubject, e.issuer.RawSubjectPublicKeyInfo, e.serial)
+		hashed, err := hashEntry(h, e.issuer.RawSubject, e.issuer.RawSubjectPublicKeyInfo, e.serial)
 		if err != nil {
 			return nil, err
 		}
@@ -284,30 +800,134 @@ func allHashes(e *Entry, supportedHashes config.SupportedHashes) ([][32]byte, er
 }
 
 func hashRequest(request *ocsp.Request) [32]byte {
-	serialHash := sha256.Sum256(request.SerialNumber.Bytes())
-	return sha256.Sum256(append(append(request.IssuerNameHash, request.IssuerKeyHash...), serialHash[:]...))
+	return lookupKey(request.IssuerNameHash, request.IssuerKeyHash, request.SerialNumber)
 }
 
 func (c *EntryCache) lookup(request *ocsp.Request) (*Entry, bool) {
-	hash := hashRequest(request)
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	e, present := c.lookupMap[hash]
-	return e, present
+	return c.lookupMap.get(hashRequest(request))
 }
 
 // LookupResponse looks up a entry in the cache and returns it's
 // response if the entry exists
 func (c *EntryCache) LookupResponse(request *ocsp.Request) ([]byte, bool) {
-	e, present := c.lookup(request)
+	return c.LookupResponseByKey(RequestKeyFor(request))
+}
+
+// RequestKey identifies the certificate a OCSP request is for, it
+// can be kept so that repeated requests skip hashing the request
+type RequestKey [32]byte
+
+// RequestKeyFor returns the RequestKey for a request
+func RequestKeyFor(request *ocsp.Request) RequestKey {
+	return RequestKey(hashRequest(request))
+}
+
+// LookupResponseByKey is LookupResponse for a request that has
+// already been hashed using RequestKeyFor
+func (c *EntryCache) LookupResponseByKey(key RequestKey) ([]byte, bool) {
+	e, present := c.lookupMap.get(key)
 	if present {
-		e.mu.RLock()
-		defer e.mu.RUnlock()
-		return e.response, present
+		return e.current().response, present
 	}
 	return nil, present
 }
 
+// LookupCached is LookupResponseByKey but also returns the values
+// derived from the response when it was stored
+func (c *EntryCache) LookupCached(key RequestKey) (CachedResponse, bool) {
+	e, present := c.lookupMap.get(key)
+	if present {
+		return e.current().cached(), present
+	}
+	return CachedResponse{}, present
+}
+
+// CertificateNotAfter returns when the certificate of the entry for
+// key expires, it is zero if the entry wasn't created from a
+// certificate
+func (c *EntryCache) CertificateNotAfter(key RequestKey) (time.Time, bool) {
+	e, present := c.lookupMap.get(key)
+	if !present {
+		return time.Time{}, false
+	}
+	return e.notAfter, true
+}
+
+// recentStableMiss returns true if the stable backings were recently
+// read for key and didn't contain a response
+func (c *EntryCache) recentStableMiss(key [32]byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	expires, present := c.stableMisses[key]
+	return present && c.clk.Now().Before(expires)
+}
+
+func (c *EntryCache) recordStableMiss(key [32]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stableMissMemo <= 0 {
+		return
+	}
+	now := c.clk.Now()
+	if len(c.stableMisses) >= maxStableMisses {
+		for k, expires := range c.stableMisses {
+			if !now.Before(expires) {
+				delete(c.stableMisses, k)
+			}
+		}
+		if len(c.stableMisses) >= maxStableMisses {
+			c.stableMisses = make(map[[32]byte]time.Time)
+		}
+	}
+	c.stableMisses[key] = now.Add(c.stableMissMemo)
+}
+
+// LookupStable looks for a response to a request which isn't in
+// memory in the stable backings, which may have been populated by a
+// peer or a previous process. If one is found a entry is created for
+// it using the upstream responders, or if there are none the entry
+// is refreshed from the stable backings. Misses are remembered for
+// a short time so that repeated requests don't repeatedly read the
+// backings
+func (c *EntryCache) LookupStable(req *ocsp.Request, upstream []string) (CachedResponse, bool) {
+	if len(c.StableBackings) == 0 {
+		return CachedResponse{}, false
+	}
+	key := hashRequest(req)
+	if c.recentStableMiss(key) {
+		return CachedResponse{}, false
+	}
+	e, err := c.requestEntry(req, upstream)
+	if err != nil {
+		c.recordStableMiss(key)
+		return CachedResponse{}, false
+	}
+	if !e.loadFromStable(c.ctx, c.StableBackings) {
+		c.recordStableMiss(key)
+		return CachedResponse{}, false
+	}
+	c.log.Info("[cache] Promoting response for '%s' from stable backings", e.name)
+	c.addSingle(e, key)
+	return e.current().cached(), true
+}
+
+// SetMaxIssuers sets the number of issuers that are cached before the
+// least recently used issuers that no entries use are evicted, zero
+// means there is no limit. Issuers the cache was created with are
+// never evicted
+func (c *EntryCache) SetMaxIssuers(max int) {
+	c.issuers.setMax(max)
+}
+
+// SetStableMissMemo sets how long LookupStable remembers a request
+// wasn't in the stable backings, zero disables remembering misses
+func (c *EntryCache) SetStableMissMemo(memo time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stableMissMemo = memo
+	c.stableMisses = make(map[[32]byte]time.Time)
+}
+
 func (c *EntryCache) addSingle(e *Entry, key [32]byte) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -317,7 +937,54 @@ func (c *EntryCache) addSingle(e *Entry, key [32]byte) {
 	}
 	c.log.Info("[cache] Adding entry for '%s'", e.name)
 	c.entries[e.name] = e
-	c.lookupMap[key] = e
+	c.indexSerial(e)
+	c.issuers.ref(e.issuer)
+	c.logDuplicates(e, c.lookupMap.set(key, e))
+}
+
+// indexSerial adds e to the serial index, c.mu must be held
+func (c *EntryCache) indexSerial(e *Entry) {
+	key := e.serial.Text(16)
+	c.serials[key] = append(c.serials[key], e)
+}
+
+// unindexSerial removes e from the serial index, c.mu must be held
+func (c *EntryCache) unindexSerial(e *Entry) {
+	key := e.serial.Text(16)
+	entries := c.serials[key]
+	for i, other := range entries {
+		if other == e {
+			entries = append(entries[:i:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(c.serials, key)
+		return
+	}
+	c.serials[key] = entries
+}
+
+// EntriesForSerial returns the metadata for every entry whose
+// certificate has serial, it uses a index rather than scanning every
+// entry so it can be used for each request
+func (c *EntryCache) EntriesForSerial(serial *big.Int) []EntryInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entries := c.serials[serial.Text(16)]
+	infos := make([]EntryInfo, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, e.Info())
+	}
+	return infos
+}
+
+// logDuplicates logs the names of entries for the same certificate as
+// e so that the duplicates can be cleaned up
+func (c *EntryCache) logDuplicates(e *Entry, others []string) {
+	for _, other := range others {
+		c.log.Warning("[cache] Entries '%s' and '%s' are for the same certificate, the freshest response of the two will be served", e.name, other)
+	}
 }
 
 // this cache structure seems kind of gross but... idk i think it's prob
@@ -329,25 +996,49 @@ func (c *EntryCache) add(e *Entry) error {
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if _, present := c.entries[e.name]; present {
+	if old, present := c.entries[e.name]; present {
 		// log or fail...?
 		c.log.Warning("[cache] Overwriting cache entry '%s'", e.name)
+		c.issuers.release(old.issuer)
+		c.unindexSerial(old)
 	} else {
 		c.log.Info("[cache] Adding entry for '%s'", e.name)
 	}
 	c.entries[e.name] = e
+	c.indexSerial(e)
+	c.issuers.ref(e.issuer)
+	duplicates := make(map[string]bool)
 	for _, h := range hashes {
-		c.lookupMap[h] = e
+		for _, other := range c.lookupMap.set(h, e) {
+			duplicates[other] = true
+		}
 	}
+	others := make([]string, 0, len(duplicates))
+	for other := range duplicates {
+		others = append(others, other)
+	}
+	sort.Strings(others)
+	c.logDuplicates(e, others)
 	return nil
 }
 
-func getIssuer(uri string) (*x509.Certificate, error) {
-	resp, err := http.Get(uri)
+// getIssuer fetches a issuer from a AIA URL
+func getIssuer(ctx context.Context, client *http.Client, uri string) (*x509.Certificate, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("issuer URL returned status %d", resp.StatusCode)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -355,55 +1046,562 @@ func getIssuer(uri string) (*x509.Certificate, error) {
 	return common.ParseCertificate(body)
 }
 
-// AddFromCertificate creates an entry from a certificate on disk and
-// adds it to the cache, a issuer or set of OCSP responders can be
-// provided
-func (c *EntryCache) AddFromCertificate(filename string, issuer *x509.Certificate, responders []string) error {
-	e := NewEntry(c.log, c.clk)
-	e.name = strings.TrimSuffix(
+// nameFromFilename returns the name used for entries created from
+// certificate files
+func nameFromFilename(filename string) string {
+	return strings.TrimSuffix(
 		filepath.Base(filename),
 		filepath.Ext(filename),
 	)
-	cert, err := common.ReadCertificate(filename)
-	if err != nil {
-		return err
-	}
-	e.serial = cert.SerialNumber
-	e.responders = cert.OCSPServer
-	if len(responders) > 0 {
-		e.responders = responders
-	}
-	e.issuer = issuer
-	if e.issuer == nil {
-		// check issuer cache
-		if e.issuer = c.issuers.getFromCertificate(cert.RawIssuer, cert.AuthorityKeyId); e.issuer == nil {
-			// fetch from AIA
-			for _, issuerURL := range cert.IssuingCertificateURL {
-				e.issuer, err = getIssuer(issuerURL)
-				if err != nil {
-					e.log.Err("Failed to retrieve issuer from '%s': %s", issuerURL, err)
-					continue
-				}
-				c.issuers.add(e.issuer)
-				break
-			}
-		}
-	} else {
-		c.issuers.add(issuer)
+}
+
+// EntryID returns the canonical ID of the entry for serial, which
+// unlike its name is the same however the entry was created. It is
+// empty if the issuer isn't known
+func EntryID(issuer *x509.Certificate, serial *big.Int) string {
+	if issuer == nil {
+		return ""
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
-	defer cancel()
-	err = e.init(ctx, c.StableBackings, c.client)
+	_, keyHash, err := common.HashIssuer(crypto.SHA256, issuer.RawSubject, issuer.RawSubjectPublicKeyInfo)
 	if err != nil {
-		return err
+		return ""
 	}
-	return c.add(e)
+	return fmt.Sprintf("%x:%x", keyHash, serial)
 }
 
-// AddFromRequest creates an entry from a OCSP request and adds it to
-// the cache, a set of upstream OCSP responders can be provided
-func (c *EntryCache) AddFromRequest(req *ocsp.Request, upstream []string) ([]byte, error) {
+// nameTaken returns true if name is used by a entry or reserved for
+// one being created from a certificate file, must be called with the
+// lock held
+func (c *EntryCache) nameTaken(name string) bool {
+	_, present := c.entries[name]
+	_, reserved := c.sourceNames[name]
+	return present || reserved
+}
+
+// reserveName returns the name for the entry created from filename,
+// the filename without its folder or extension. If that is already
+// used by a entry from a different file, such as a/site.pem and
+// b/site.pem, a hash of the path is appended so that the entries
+// don't overwrite each other, the name is the same each time the
+// file is loaded as long as the files are loaded in the same order
+func (c *EntryCache) reserveName(filename string) (string, error) {
+	filename = filepath.Clean(filename)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name, present := c.sources[filename]; present {
+		return name, nil
+	}
+	name := nameFromFilename(filename)
+	if c.nameTaken(name) {
+		sum := common.Sum256([]byte(filename))
+		disambiguated := fmt.Sprintf("%s-%x", name, sum[:4])
+		if c.nameTaken(disambiguated) {
+			return "", fmt.Errorf("entry name '%s' for '%s' is already in use", disambiguated, filename)
+		}
+		other := c.sourceNames[name]
+		if other == "" {
+			other = "a entry that wasn't created from a file"
+		}
+		c.log.Warning("[cache] Entry name '%s' for '%s' is already used by '%s', naming it '%s'", name, filename, other, disambiguated)
+		name = disambiguated
+	}
+	c.sources[filename] = name
+	c.sourceNames[name] = filename
+	return name, nil
+}
+
+// releaseName releases the name reserved for the entry created from
+// filename, must be called with the lock held
+func (c *EntryCache) releaseName(filename string) {
+	delete(c.sourceNames, c.sources[filename])
+	delete(c.sources, filename)
+}
+
+// ParseFingerprint parses a hex SHA-256 certificate fingerprint, in
+// either case and optionally with the bytes separated by colons, as
+// scanners and inventory tools usually print them
+func ParseFingerprint(s string) ([32]byte, bool) {
+	var fingerprint [32]byte
+	if strings.Contains(s, ":") {
+		if strings.Count(s, ":") != len(fingerprint)-1 {
+			return fingerprint, false
+		}
+		s = strings.Replace(s, ":", "", -1)
+	}
+	if len(s) != hex.EncodedLen(len(fingerprint)) {
+		return fingerprint, false
+	}
+	if _, err := hex.Decode(fingerprint[:], []byte(s)); err != nil {
+		return fingerprint, false
+	}
+	return fingerprint, true
+}
+
+// get returns the entry with a name, canonical ID, or SHA-256
+// certificate fingerprint, see ParseFingerprint
+func (c *EntryCache) get(nameOrID string) (*Entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if e, present := c.entries[nameOrID]; present {
+		return e, true
+	}
+	for _, e := range c.entries {
+		if e.id != "" && e.id == nameOrID {
+			return e, true
+		}
+	}
+	if fingerprint, ok := ParseFingerprint(nameOrID); ok && fingerprint != [32]byte{} {
+		for _, e := range c.entries {
+			if e.fingerprint == fingerprint {
+				return e, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Find returns the metadata for the entry with a name, canonical ID,
+// or SHA-256 certificate fingerprint, see ParseFingerprint
+func (c *EntryCache) Find(nameOrID string) (EntryInfo, bool) {
+	e, present := c.get(nameOrID)
+	if !present {
+		return EntryInfo{}, false
+	}
+	return e.Info(), true
+}
+
+// newEntry creates a Entry which shares the cache fetch settings
+func (c *EntryCache) newEntry() *Entry {
 	e := NewEntry(c.log, c.clk)
+	e.fetchCache = c.fetchCache
+	e.drift = c.drift
+	e.efficiency = c.efficiency
+	e.counters = c.counters
+	e.inflight = c.inflight
+	c.mu.RLock()
+	e.fetchBackoff = c.fetchBackoff
+	e.stableSelection = c.stableSelection
+	e.requestHash = c.requestHash
+	e.lightweight = c.lightweight
+	e.election = c.election
+	e.responderCheck = c.responderCheck
+	e.quorum = c.quorum
+	e.standby = c.standby
+	e.metrics = c.metrics
+	e.history = newFetchHistory(c.historySize)
+	c.mu.RUnlock()
+	return e
+}
+
+// CertificateOptions describes how the entry for a certificate
+// should be populated, the zero value uses the cached issuer (or
+// AIA), the OCSP servers in the certificate, and the EntryCache
+// client
+type CertificateOptions struct {
+	Issuer     *x509.Certificate
+	Responders []string
+	Client     *http.Client
+	Labels     map[string]string // reported in EntryInfo
+	// RequestExtensions are added to the requests sent upstream,
+	// see stapledOCSP.ParseRequestExtension
+	RequestExtensions []pkix.Extension
+	// Nonce sends a nonce to every responder of the entry, on top of
+	// those the fetch backoff sends one to, see stapledOCSP.Nonces
+	Nonce bool
+	// ResponseName is the name the response is stored under in the
+	// stable backings, the entry name is used if it is nil, see
+	// ParseResponseName
+	ResponseName  *template.Template
+	UnknownPolicy UnknownPolicy
+}
+
+// UnknownPolicy controls how fetched responses with the status
+// Unknown are handled, which usually means the responder doesn't know
+// about the certificate because it doesn't match the issuer
+type UnknownPolicy int
+
+const (
+	// ServeUnknown caches and serves Unknown responses like any other
+	ServeUnknown UnknownPolicy = iota
+	// RetryUnknown doesn't cache Unknown responses, the refresh fails
+	// with ErrUnknownStatus and is retried as if the responder was
+	// unavailable
+	RetryUnknown
+	// AlertUnknown caches and serves Unknown responses and logs a
+	// error each time one is fetched
+	AlertUnknown
+)
+
+// ParseUnknownPolicy parses the name of a UnknownPolicy, either serve,
+// the default if name is empty, retry, or alert
+func ParseUnknownPolicy(name string) (UnknownPolicy, error) {
+	switch name {
+	case "", "serve":
+		return ServeUnknown, nil
+	case "retry":
+		return RetryUnknown, nil
+	case "alert":
+		return AlertUnknown, nil
+	}
+	return 0, fmt.Errorf("unknown policy '%s', expected serve, retry, or alert", name)
+}
+
+// ErrUnknownStatus is returned when a response with the status Unknown
+// is fetched for a entry using RetryUnknown
+var ErrUnknownStatus = errors.New("responder returned the status Unknown")
+
+// NoResponderPolicy controls what happens when a certificate is added
+// which doesn't contain any OCSP URLs and no responders are provided
+type NoResponderPolicy int
+
+const (
+	// WarnNoResponders logs a warning and adds the entry, it can only
+	// be populated from the stable backings
+	WarnNoResponders NoResponderPolicy = iota
+	// SkipNoResponders logs a warning and doesn't add the entry
+	SkipNoResponders
+	// FailNoResponders returns ErrNoResponders
+	FailNoResponders
+)
+
+// ErrNoResponders is returned when a certificate without any OCSP
+// responders is added and the FailNoResponders policy is used
+var ErrNoResponders = errors.New("certificate has no OCSP URLs and no responders are configured")
+
+// IssuerResponders are the default responders for certificates
+// issued by a issuer, used when a certificate has no OCSP URLs and no
+// responders are provided. The issuer is matched by subject key ID
+// against the authority key ID of the certificate if SKID is set,
+// otherwise by subject, in the format of pkix.Name.String
+type IssuerResponders struct {
+	SKID       []byte
+	Subject    string
+	Responders []string
+}
+
+// MatchIssuerResponders returns the responders of the first entry in
+// defaults matching the issuer identified by skid and subject
+func MatchIssuerResponders(defaults []IssuerResponders, skid []byte, subject string) []string {
+	for _, ir := range defaults {
+		if len(ir.SKID) > 0 && bytes.Equal(ir.SKID, skid) || len(ir.SKID) == 0 && ir.Subject == subject {
+			return ir.Responders
+		}
+	}
+	return nil
+}
+
+// SetIssuerResponders sets the default responders for the
+// certificates issued by each issuer, the first match is used
+func (c *EntryCache) SetIssuerResponders(defaults []IssuerResponders) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.issuerResponders = defaults
+}
+
+// DefaultResponders returns the default responders for certificates
+// issued by issuer, see SetIssuerResponders
+func (c *EntryCache) DefaultResponders(issuer *x509.Certificate) []string {
+	return c.defaultResponders(issuer.SubjectKeyId, issuer.Subject.String())
+}
+
+func (c *EntryCache) defaultResponders(skid []byte, subject string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return MatchIssuerResponders(c.issuerResponders, skid, subject)
+}
+
+// SetResponderCheck sets how delegated responder certificates without
+// id-pkix-ocsp-nocheck are handled, see stapledOCSP.NoCheckPolicy,
+// by default they are trusted
+func (c *EntryCache) SetResponderCheck(policy stapledOCSP.NoCheckPolicy, maxDepth int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responderCheck = stapledOCSP.NewResponderChecker(c.log, c.clk, policy, maxDepth)
+}
+
+// SetLeaderElection enables electing a single instance, identified
+// by owner, to fetch each response from upstream when the stable
+// backings are shared between instances, see scache.Locker. The
+// instance which takes the lease on a entry keeps it for lease, the
+// others load the response it fetches from the stable backings
+func (c *EntryCache) SetLeaderElection(owner string, lease time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.election = &leaderElection{owner, lease}
+}
+
+// SetNoResponderPolicy sets what happens when a certificate without
+// any responders is added, the default is WarnNoResponders
+func (c *EntryCache) SetNoResponderPolicy(policy NoResponderPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.noResponderPolicy = policy
+}
+
+// AddFromCertificate creates an entry from a certificate on disk and
+// adds it to the cache, a issuer or set of OCSP responders can be
+// provided
+func (c *EntryCache) AddFromCertificate(filename string, issuer *x509.Certificate, responders []string) error {
+	return c.AddFromCertificateWithOptions(filename, CertificateOptions{
+		Issuer:     issuer,
+		Responders: responders,
+	})
+}
+
+// AddFromCertificateWithOptions creates an entry from a certificate
+// on disk using opts and adds it to the cache
+func (c *EntryCache) AddFromCertificateWithOptions(filename string, opts CertificateOptions) error {
+	cert, err := common.ReadCertificate(filename)
+	if err != nil {
+		return err
+	}
+	name, err := c.reserveName(filename)
+	if err != nil {
+		return err
+	}
+	err = c.addCertificate(name, filepath.Clean(filename), cert, opts)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, present := c.entries[name]; !present {
+		// failed or skipped
+		c.releaseName(filepath.Clean(filename))
+	}
+	return err
+}
+
+// AddCertificate creates an entry named name from a certificate that
+// isn't on disk, such as one retrieved from a API, using opts and
+// adds it to the cache. The name is used as the filename of the
+// response in the stable backings
+func (c *EntryCache) AddCertificate(name string, cert *x509.Certificate, opts CertificateOptions) error {
+	return c.addCertificate(name, "", cert, opts)
+}
+
+// AddFromSerial creates a entry for the certificate with serial
+// issued by opts.Issuer, which is required, and adds it to the cache.
+// Since there is no certificate the responders must be set in opts,
+// or for the issuer with SetIssuerResponders, and the entry has no
+// fingerprint or expiry
+func (c *EntryCache) AddFromSerial(name, source string, serial *big.Int, opts CertificateOptions) error {
+	if opts.Issuer == nil {
+		return fmt.Errorf("'%s': %w", name, ErrNoIssuer)
+	}
+	return c.addCertificate(name, source, &x509.Certificate{SerialNumber: serial}, opts)
+}
+
+// IssuerByKeyHash returns the issuer in the issuer cache whose public
+// key hashes to keyHash using one of the supported hashes
+func (c *EntryCache) IssuerByKeyHash(keyHash []byte) (*x509.Certificate, bool) {
+	issuer, _ := c.issuers.getFromKeyHash(keyHash)
+	return issuer, issuer != nil
+}
+
+// KnownIssuer checks if the issuer named by a OCSP request is in the
+// issuer cache
+func (c *EntryCache) KnownIssuer(req *ocsp.Request) bool {
+	return c.issuers.getFromRequest(req.IssuerNameHash, req.IssuerKeyHash) != nil
+}
+
+// Issuers returns every issuer in the issuer cache, those loaded from
+// the issuer folder, provided with certificates, and fetched using AIA
+func (c *EntryCache) Issuers() []*x509.Certificate {
+	return c.issuers.all()
+}
+
+func (c *EntryCache) addCertificate(name, source string, cert *x509.Certificate, opts CertificateOptions) error {
+	e, err := c.buildEntry(name, source, cert, opts)
+	if err != nil || e == nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.requestTimeout)
+	defer cancel()
+	err = e.init(ctx, c.StableBackings, c.clientFor(e))
+	if err != nil {
+		return err
+	}
+	return c.add(e)
+}
+
+// buildEntry creates a uninitialized entry for cert, resolving its
+// responders and issuer, it returns a nil entry if the certificate
+// is skipped
+func (c *EntryCache) buildEntry(name, source string, cert *x509.Certificate, opts CertificateOptions) (*Entry, error) {
+	e := c.newEntry()
+	e.name = name
+	e.source = source
+	e.client = opts.Client
+	e.labels = opts.Labels
+	e.unknownPolicy = opts.UnknownPolicy
+	e.extensions = opts.RequestExtensions
+	if opts.Nonce {
+		e.nonce = true
+		// fetchBackoff is a copy of the one shared by the cache
+		e.fetchBackoff.Nonces = &stapledOCSP.Nonces{All: true}
+	}
+	var err error
+	e.serial = cert.SerialNumber
+	e.notAfter = cert.NotAfter
+	if cert.Raw != nil {
+		e.fingerprint = common.Sum256(cert.Raw)
+	}
+	e.mustStaple = common.MustStaple(cert)
+	e.responders = cert.OCSPServer
+	if len(opts.Responders) > 0 {
+		e.responders = opts.Responders
+	}
+	if len(e.responders) == 0 {
+		skid, subject := cert.AuthorityKeyId, cert.Issuer.String()
+		if opts.Issuer != nil {
+			skid, subject = opts.Issuer.SubjectKeyId, opts.Issuer.Subject.String()
+		}
+		e.responders = c.defaultResponders(skid, subject)
+	}
+	if len(e.responders) == 0 {
+		c.mu.RLock()
+		policy := c.noResponderPolicy
+		c.mu.RUnlock()
+		switch policy {
+		case SkipNoResponders:
+			c.log.Warning("[cache] Skipping '%s', it has no OCSP URLs and no responders are configured", name)
+			return nil, nil
+		case FailNoResponders:
+			return nil, fmt.Errorf("'%s': %w", name, ErrNoResponders)
+		default:
+			c.log.Warning("[cache] Certificate '%s' has no OCSP URLs and no responders are configured, its response can only be loaded from the stable backings", name)
+		}
+	}
+	e.issuer = opts.Issuer
+	if e.issuer == nil {
+		// check issuer cache
+		if e.issuer = c.issuers.getFromCertificate(cert.RawIssuer, cert.AuthorityKeyId); e.issuer == nil {
+			// fetch from AIA
+			ctx, cancel := context.WithTimeout(c.ctx, c.requestTimeout)
+			defer cancel()
+			for _, issuerURL := range cert.IssuingCertificateURL {
+				if retryAt, skip := c.aia.backingOff(issuerURL, c.clk.Now()); skip {
+					e.info("Not retrieving issuer from '%s' until %s, it failed recently", issuerURL, retryAt)
+					continue
+				}
+				e.issuer, err = getIssuer(ctx, c.clientFor(e), issuerURL)
+				if err != nil {
+					backoff := c.aia.failed(issuerURL, c.clk.Now())
+					c.mu.RLock()
+					sink := c.metrics
+					c.mu.RUnlock()
+					if sink != nil {
+						sink.Counter(aiaMetricName(aiaHost(issuerURL)), 1)
+					}
+					e.err("Failed to retrieve issuer from '%s', not retrying it for %s: %s", issuerURL, backoff, err)
+					continue
+				}
+				c.aia.succeeded(issuerURL)
+				c.issuers.add(e.issuer)
+				break
+			}
+		}
+	} else {
+		c.issuers.add(opts.Issuer)
+	}
+	if e.issuer == nil {
+		return nil, fmt.Errorf("'%s': %w", name, ErrNoIssuer)
+	}
+	e.id = EntryID(e.issuer, e.serial)
+	if opts.ResponseName != nil {
+		if e.responseFilename, err = renderResponseName(opts.ResponseName, name, e.serial, e.issuer); err != nil {
+			return nil, fmt.Errorf("'%s': %s", name, err)
+		}
+	}
+	return e, nil
+}
+
+// verifyResponse checks a fetched response is valid for e
+func (e *Entry) verifyResponse(ctx context.Context, client *http.Client, resp *ocsp.Response) (err error) {
+	ctx, span := tracing.Start(ctx, "ocsp.verify-response")
+	defer func() { tracing.End(span, err) }()
+	if err = stapledOCSP.VerifyResponse(e.clk.Now(), e.serial, resp); err != nil {
+		return err
+	}
+	if e.lightweight {
+		if err = stapledOCSP.CheckLightweightResponse(resp); err != nil {
+			return err
+		}
+	}
+	return e.responderCheck.Check(ctx, client, resp, e.issuer)
+}
+
+// probeBackoff makes a single request for each probe so a broken
+// responder is reported quickly instead of being retried
+var probeBackoff = stapledOCSP.Backoff{
+	Network:  stapledOCSP.ClassPolicy{Attempts: 1},
+	Server:   stapledOCSP.ClassPolicy{Attempts: 1},
+	Client:   stapledOCSP.ClassPolicy{Attempts: 1},
+	Response: stapledOCSP.ClassPolicy{Attempts: 1},
+}
+
+// ProbeResponder fetches and verifies a response for the entry name
+// from responder, using client, or the client the entry uses if it
+// is nil, without replacing the response of the entry. It is used to
+// check that responders and proxies which are only used when others
+// fail work
+func (c *EntryCache) ProbeResponder(ctx context.Context, name, responder string, client *http.Client) error {
+	c.mu.RLock()
+	e, present := c.entries[name]
+	c.mu.RUnlock()
+	if !present {
+		return fmt.Errorf("entry '%s' is not in the cache", name)
+	}
+	if client == nil {
+		client = c.clientFor(e)
+	}
+	_, err := e.fetchUncached(ctx, []string{responder}, client)
+	return err
+}
+
+// ErrNotCached is returned by FetchFresh when there is no entry for
+// the request
+var ErrNotCached = errors.New("no entry for the request is cached")
+
+// FetchFresh fetches and verifies a response for the entry for key
+// from its responders without replacing the response of the entry,
+// so that the response being served can be compared with what the
+// responders currently return
+func (c *EntryCache) FetchFresh(ctx context.Context, key RequestKey) (*ocsp.Response, error) {
+	e, present := c.lookupMap.get(key)
+	if !present {
+		return nil, ErrNotCached
+	}
+	result, err := e.fetchUncached(ctx, e.responders, c.clientFor(e))
+	if err != nil {
+		return nil, err
+	}
+	return result.Response, nil
+}
+
+// fetchUncached fetches a response from one of responders, without
+// retrying, and verifies it, the response isn't cached
+func (e *Entry) fetchUncached(ctx context.Context, responders []string, client *http.Client) (*stapledOCSP.Result, error) {
+	result, err := stapledOCSP.Fetch(ctx, e.log, e.clk, probeBackoff, responders, client, e.request, nil, e.issuer)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.verifyResponse(ctx, client, result.Response); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// clientFor returns the client that should be used to fetch
+// responses for e
+func (c *EntryCache) clientFor(e *Entry) *http.Client {
+	if e.client != nil {
+		return e.client
+	}
+	return c.client
+}
+
+// requestEntry creates a uninitialized entry from a OCSP request
+func (c *EntryCache) requestEntry(req *ocsp.Request, upstream []string) (*Entry, error) {
+	e := c.newEntry()
 	e.serial = req.SerialNumber
 	var err error
 	e.request, err = req.Marshal()
@@ -411,21 +1609,335 @@ func (c *EntryCache) AddFromRequest(req *ocsp.Request, upstream []string) ([]byt
 		return nil, err
 	}
 	e.responders = upstream
-	serialHash := sha256.Sum256(e.serial.Bytes())
-	key := sha256.Sum256(append(append(req.IssuerNameHash, req.IssuerKeyHash...), serialHash[:]...))
+	serialHash := common.Sum256(e.serial.Bytes())
+	key := common.Sum256(append(append(req.IssuerNameHash, req.IssuerKeyHash...), serialHash[:]...))
 	e.name = fmt.Sprintf("%X", key)
 	e.issuer = c.issuers.getFromRequest(req.IssuerNameHash, req.IssuerKeyHash)
 	if e.issuer == nil {
-		return nil, errors.New("No issuer in cache for request")
+		return nil, ErrNoIssuer
+	}
+	e.id = EntryID(e.issuer, e.serial)
+	return e, nil
+}
+
+// AddFromRequest creates an entry from a OCSP request and adds it to
+// the cache, a set of upstream OCSP responders can be provided
+func (c *EntryCache) AddFromRequest(req *ocsp.Request, upstream []string) (CachedResponse, error) {
+	e, err := c.requestEntry(req, upstream)
+	if err != nil {
+		return CachedResponse{}, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	key := hashRequest(req)
+	ctx, cancel := context.WithTimeout(c.ctx, c.requestTimeout)
 	defer cancel()
 	err = e.init(ctx, c.StableBackings, c.client)
 	if err != nil {
-		return nil, err
+		return CachedResponse{}, err
 	}
 	c.addSingle(e, key)
-	return e.response, nil
+	return e.current().cached(), nil
+}
+
+// RetryAfter returns how long a client that can't be given a response
+// because AddFromRequest returned err should wait before asking again,
+// which is how long the fetch for the entry would have backed off for
+// before its next attempt, or the default backoff delay if the fetch
+// was skipped because one was already running
+func (c *EntryCache) RetryAfter(err error) time.Duration {
+	var fe *stapledOCSP.FetchError
+	if errors.As(err, &fe) && fe.Wait > 0 {
+		return fe.Wait
+	}
+	return c.fetchBackoff.DelayFor(0)
+}
+
+// Entries returns a snapshot of the metadata for every entry
+// currently in the cache
+func (c *EntryCache) Entries() []EntryInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	infos := make([]EntryInfo, 0, len(c.entries))
+	for _, e := range c.entries {
+		infos = append(infos, e.Info())
+	}
+	return infos
+}
+
+// StableNames returns the names the responses of every entry are
+// stored under in the stable backings, sorted
+func (c *EntryCache) StableNames() []string {
+	c.mu.RLock()
+	names := make([]string, 0, len(c.entries))
+	for _, e := range c.entries {
+		names = append(names, e.stableName())
+	}
+	c.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// PackResponses returns the current response of every entry that has
+// one with the lookup keys of the requests it answers, see pack.Write
+func (c *EntryCache) PackResponses() []pack.Response {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	responses := make([]pack.Response, 0, len(c.entries))
+	for _, e := range c.entries {
+		st := e.current()
+		if st.response == nil {
+			continue
+		}
+		keys, err := allHashes(e, c.hashes)
+		if err != nil {
+			c.log.Err("[cache] Failed to hash '%s' for the packed file: %s", e.name, err)
+			continue
+		}
+		responses = append(responses, pack.Response{Keys: keys, ThisUpdate: st.thisUpdate, NextUpdate: st.nextUpdate, Response: st.response})
+	}
+	return responses
+}
+
+// Len returns the number of entries in the cache
+func (c *EntryCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// Staple is the current response for a certificate
+type Staple struct {
+	Name        string
+	Fingerprint [32]byte // SHA-256 of the certificate
+	Response    []byte
+}
+
+// Staples returns the current response for every entry created from
+// a certificate, sorted by certificate fingerprint
+func (c *EntryCache) Staples() []Staple {
+	c.mu.RLock()
+	staples := make([]Staple, 0, len(c.entries))
+	for _, e := range c.entries {
+		response := e.current().response
+		e.mu.RLock()
+		if e.fingerprint != [32]byte{} && response != nil {
+			staples = append(staples, Staple{e.name, e.fingerprint, response})
+		}
+		e.mu.RUnlock()
+	}
+	c.mu.RUnlock()
+	sort.Slice(staples, func(i, j int) bool {
+		return bytes.Compare(staples[i].Fingerprint[:], staples[j].Fingerprint[:]) < 0
+	})
+	return staples
+}
+
+// LookupEntry returns the metadata for the entry with serial whose
+// issuer public key hashes to issuerKeyHash using one of the supported
+// hashes, it is intended for tooling rather than the request path
+func (c *EntryCache) LookupEntry(issuerKeyHash []byte, serial *big.Int) (EntryInfo, bool) {
+	e := c.lookupEntry(issuerKeyHash, serial)
+	if e == nil {
+		return EntryInfo{}, false
+	}
+	return e.Info(), true
+}
+
+// lookupEntry returns the entry with the certificate identified by a
+// issuer key hash, using any of the supported hashes, and serial
+func (c *EntryCache) lookupEntry(issuerKeyHash []byte, serial *big.Int) *Entry {
+	hashes := []crypto.Hash{}
+	for _, h := range c.hashes {
+		if h.Size() == len(issuerKeyHash) {
+			hashes = append(hashes, h)
+		}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, e := range c.serials[serial.Text(16)] {
+		for _, h := range hashes {
+			_, keyHash, err := common.HashIssuer(h, e.issuer.RawSubject, e.issuer.RawSubjectPublicKeyInfo)
+			if err == nil && bytes.Equal(keyHash, issuerKeyHash) {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// ErrNoIssuer is returned when a entry can't be created because the
+// issuer of the certificate isn't known, either because it isn't in
+// the issuer cache or, for certificates, it couldn't be fetched
+var ErrNoIssuer = errors.New("issuer is not in the issuer cache")
+
+// ErrUnknownIssuer is returned by LookupStatus when there is no
+// entry for the certificate and its issuer isn't known, it is the
+// same as ErrNoIssuer
+var ErrUnknownIssuer = ErrNoIssuer
+
+// LookupStatus is like LookupEntry but if there is no entry for the
+// certificate one is created using the upstream responders, if the
+// issuer is known. The new entry is kept fresh like any other entry,
+// so subsequent lookups are served from the cache
+func (c *EntryCache) LookupStatus(issuerKeyHash []byte, serial *big.Int, upstream []string) (EntryInfo, error) {
+	if info, present := c.LookupEntry(issuerKeyHash, serial); present {
+		return info, nil
+	}
+	issuer, h := c.issuers.getFromKeyHash(issuerKeyHash)
+	if issuer == nil {
+		return EntryInfo{}, ErrUnknownIssuer
+	}
+	if len(upstream) == 0 {
+		return EntryInfo{}, errors.New("no upstream responders are configured")
+	}
+	nameHash, keyHash, err := common.HashIssuer(h, issuer.RawSubject, issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	_, err = c.AddFromRequest(&ocsp.Request{
+		HashAlgorithm:  h,
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  keyHash,
+		SerialNumber:   serial,
+	}, upstream)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	info, present := c.LookupEntry(issuerKeyHash, serial)
+	if !present {
+		return EntryInfo{}, errors.New("entry was removed before it could be read")
+	}
+	return info, nil
+}
+
+// Refresh immediately fetches a new response for the entry with a
+// name, canonical ID, or certificate fingerprint, regardless of
+// whether it is in its update window
+func (c *EntryCache) Refresh(name string) error {
+	e, present := c.get(name)
+	if !present {
+		return fmt.Errorf("entry '%s' is not in the cache", name)
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.requestTimeout)
+	defer cancel()
+	return e.fetchResponse(ctx, c.StableBackings, c.clientFor(e))
+}
+
+// Invalidate discards the response held for the entry with a name,
+// canonical ID, or certificate fingerprint, and any validators for
+// it, and then fetches a new response. Until a
+// new response is fetched the entry has no response to serve. The
+// response held by the stable backings is only replaced once a new
+// response is fetched
+func (c *EntryCache) Invalidate(name string) error {
+	e, present := c.get(name)
+	if !present {
+		return fmt.Errorf("entry '%s' is not in the cache", name)
+	}
+	if !e.standby.fetches() {
+		// the response couldn't be replaced
+		return ErrStandby
+	}
+	e.mu.Lock()
+	st := *e.current()
+	st.response = nil
+	st.digest = [32]byte{}
+	st.eTag = ""
+	st.maxAge = 0
+	st.thisUpdate = time.Time{}
+	st.nextUpdate = time.Time{}
+	e.state.Store(&st)
+	e.mu.Unlock()
+	c.fetchCache.Forget(e.responders, e.request)
+	e.info("Response has been invalidated")
+	ctx, cancel := context.WithTimeout(c.ctx, c.requestTimeout)
+	defer cancel()
+	return e.fetchResponse(ctx, c.StableBackings, c.clientFor(e))
+}
+
+// ErrNoEntry is returned by Import when there is no entry for the
+// certificate a response is for
+var ErrNoEntry = errors.New("no entry for the certificate the response is for")
+
+// Import replaces the response for the entries for the certificate
+// a DER response is for, so that responses fetched out of band can be
+// served. The response is verified as if it had been fetched from a
+// upstream responder and must be at least as new as the current
+// response. It returns the names of the entries that were updated
+func (c *EntryCache) Import(body []byte) ([]string, error) {
+	return c.importResponse(body, false)
+}
+
+// ErrNotNewer is returned by ImportNewer when the response isn't newer
+// than the current response of any entry it is for
+var ErrNotNewer = errors.New("response isn't newer than the current response")
+
+// ImportNewer is like Import but entries whose current response is at
+// least as new as the response are skipped, rather than updated or
+// failing the import
+func (c *EntryCache) ImportNewer(body []byte) ([]string, error) {
+	return c.importResponse(body, true)
+}
+
+func (c *EntryCache) importResponse(body []byte, newerOnly bool) ([]string, error) {
+	// parsed without a issuer only to find the entries to verify it
+	// against
+	unverified, err := ocsp.ParseResponse(body, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response: %s", err)
+	}
+	var candidates []*Entry
+	c.mu.RLock()
+	for _, e := range c.entries {
+		if e.serial.Cmp(unverified.SerialNumber) == 0 {
+			candidates = append(candidates, e)
+		}
+	}
+	c.mu.RUnlock()
+	if len(candidates) == 0 {
+		return nil, ErrNoEntry
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].name < candidates[j].name })
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.requestTimeout)
+	defer cancel()
+	var imported []string
+	notNewer := false
+	for _, e := range candidates {
+		resp, err := stapledOCSP.ParseResponse(c.clk.Now(), body, e.issuer)
+		if err != nil {
+			// a certificate with the same serial from a different
+			// issuer
+			continue
+		}
+		if newerOnly && !resp.ThisUpdate.After(e.current().thisUpdate) {
+			notNewer = true
+			continue
+		}
+		if err = stapledOCSP.VerifyResponse(c.clk.Now(), e.serial, resp); err != nil {
+			return imported, err
+		}
+		if e.lightweight {
+			if err = stapledOCSP.CheckLightweightResponse(resp); err != nil {
+				return imported, err
+			}
+		}
+		if err = e.responderCheck.Check(ctx, c.clientFor(e), resp, e.issuer); err != nil {
+			return imported, err
+		}
+		if resp.ThisUpdate.Before(e.current().thisUpdate) {
+			return imported, fmt.Errorf("response is older than the current response for '%s'", e.name)
+		}
+		e.updateResponse(ctx, "", 0, "", resp, body, c.StableBackings)
+		e.recordResult(nil)
+		e.info("Response has been imported")
+		imported = append(imported, e.name)
+	}
+	if len(imported) == 0 && notNewer {
+		return nil, ErrNotNewer
+	} else if len(imported) == 0 {
+		return nil, errors.New("response isn't signed by the issuer of any entry with its serial")
+	}
+	return imported, nil
 }
 
 // Remove removes a entry from the cache
@@ -436,30 +1948,239 @@ func (c *EntryCache) Remove(name string) error {
 	if !present {
 		return fmt.Errorf("entry '%s' is not in the cache", name)
 	}
-	e.mu.Lock()
 	delete(c.entries, name)
+	c.unindexSerial(e)
+	c.efficiency.forget(name)
+	c.issuers.release(e.issuer)
+	if e.source != "" {
+		c.releaseName(e.source)
+	}
 	hashes, err := allHashes(e, c.hashes)
 	if err != nil {
 		return err
 	}
 	for _, h := range hashes {
-		delete(c.lookupMap, h)
+		c.lookupMap.delete(h, name)
 	}
 	c.log.Info("[cache] Removed entry for '%s' from cache", name)
 	return nil
 }
 
+// RemoveFromCertificate removes the entry created by AddFromCertificate
+// for a certificate file from the cache
+func (c *EntryCache) RemoveFromCertificate(filename string) error {
+	c.mu.RLock()
+	name, present := c.sources[filepath.Clean(filename)]
+	c.mu.RUnlock()
+	if !present {
+		return fmt.Errorf("no entry was created from '%s'", filename)
+	}
+	return c.Remove(name)
+}
+
+// SetMetrics sets the sink the entries added after it is called, and
+// the cache, report metrics to
+func (c *EntryCache) SetMetrics(sink metrics.Sink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = sink
+}
+
+// SetMaxConcurrentRefreshes sets the maximum number of entries that
+// will be refreshed concurrently during each monitor tick, zero means
+// there is no limit
+func (c *EntryCache) SetMaxConcurrentRefreshes(max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxConcurrentRefreshes = max
+}
+
+// SetFetchBackoff sets how long to wait between failed requests
+// when fetching responses for entries added after it is called, the
+// zero value uses stapledOCSP.DefaultBackoff
+func (c *EntryCache) SetFetchBackoff(backoff stapledOCSP.Backoff) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetchBackoff = backoff
+}
+
+// RetryBudget returns the state of the retry budget for each
+// responder host, it is empty if there is no retry budget
+func (c *EntryCache) RetryBudget() map[string]stapledOCSP.RetryBudgetStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.fetchBackoff.Budget.Stats()
+}
+
+// SetRequestHash sets the hash used to build upstream requests for
+// entries added after it is called, the default is SHA-1 as it is
+// the only hash responders are required to support
+func (c *EntryCache) SetRequestHash(h crypto.Hash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requestHash = h
+}
+
+// SetLightweightProfile enforces the RFC 5019 lightweight profile on
+// upstream requests and responses, entries with request extensions
+// fail to initialize and responses without a NextUpdate are rejected.
+// It only applies to entries added after it is called
+func (c *EntryCache) SetLightweightProfile(enforce bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lightweight = enforce
+}
+
+// SetRefreshRamp spreads the start of refreshes for stale entries
+// evenly over interval when more than threshold entries are stale,
+// such as after the host was suspended or the daemon was down for a
+// long time, so that the upstream responders aren't sent every
+// request at once. A interval of zero, the default, disables this
+func (c *EntryCache) SetRefreshRamp(interval time.Duration, threshold int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rampInterval = interval
+	c.rampThreshold = threshold
+}
+
+// SetDriftWarning logs a warning whenever a response is fetched
+// from a responder whose average ProducedAt drift is further than
+// threshold from zero, a threshold of zero, the default, disables
+// the warning
+func (c *EntryCache) SetDriftWarning(threshold time.Duration) {
+	c.drift.setWarnAbove(threshold)
+}
+
+// ResponderDrift returns the ProducedAt drift of each responder a
+// new response has been fetched from
+func (c *EntryCache) ResponderDrift() map[string]DriftStats {
+	return c.drift.snapshot()
+}
+
+// AIAFailures returns the number of failed issuer fetches from each
+// host certificate AIA URLs point to
+func (c *EntryCache) AIAFailures() map[string]int64 {
+	return c.aia.snapshot()
+}
+
+// SetStableSelection sets how a response is chosen when entries
+// added after it is called are loaded from the stable backings
+func (c *EntryCache) SetStableSelection(selection StableSelection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stableSelection = selection
+}
+
+// refreshOrder returns a snapshot of the entries in the cache ordered
+// by how soon their current responses expire, entries without a
+// response come first. Boosted entries come before all of the others,
+// it also returns how many there are
+func (c *EntryCache) refreshOrder() ([]*Entry, int) {
+	c.mu.RLock()
+	entries := make([]*Entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	c.mu.RUnlock()
+	now := c.clk.Now()
+	nextUpdates := make(map[*Entry]time.Time, len(entries))
+	boosted := make(map[*Entry]bool)
+	for _, e := range entries {
+		nextUpdates[e] = e.current().nextUpdate
+		if _, present := e.boosted(now); present {
+			boosted[e] = true
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if boosted[entries[i]] != boosted[entries[j]] {
+			return boosted[entries[i]]
+		}
+		return nextUpdates[entries[i]].Before(nextUpdates[entries[j]])
+	})
+	return entries, len(boosted)
+}
+
+// rampSpacing returns how many of the entries at the start of order,
+// which is sorted by refreshOrder, are stale and how long to wait
+// between starting each of their refreshes
+func rampSpacing(order []*Entry, now time.Time, interval time.Duration, threshold int) (int, time.Duration) {
+	stale := 0
+	for _, e := range order {
+		if !e.current().nextUpdate.Before(now) {
+			break
+		}
+		stale++
+	}
+	if interval <= 0 || stale <= threshold {
+		return stale, 0
+	}
+	return stale, interval / time.Duration(stale)
+}
+
+// refreshAll refreshes every entry that needs it, starting with those
+// whose responses expire soonest so that when concurrency is limited
+// the most urgent entries aren't starved. If there is a backlog of
+// stale entries their refreshes are spread out according to the
+// refresh ramp, boosted entries are refreshed first and aren't spread
+// out. It returns once all of the refreshes have finished
+func (c *EntryCache) refreshAll() {
+	c.mu.RLock()
+	max := c.maxConcurrentRefreshes
+	rampInterval, rampThreshold := c.rampInterval, c.rampThreshold
+	sink := c.metrics
+	c.mu.RUnlock()
+	var sem chan struct{}
+	if max > 0 {
+		sem = make(chan struct{}, max)
+	}
+	order, boosted := c.refreshOrder()
+	stale, spacing := rampSpacing(order[boosted:], c.clk.Now(), rampInterval, rampThreshold)
+	if sink != nil {
+		sink.Gauge("cache.entries", float64(len(order)))
+		sink.Gauge("cache.stale", float64(stale))
+		sink.Gauge("cache.issuers", float64(c.issuers.len()))
+	}
+	if spacing > 0 {
+		c.log.Warning("[cache] %d entries are stale, spreading their refreshes over %s", stale, rampInterval)
+	}
+	wg := new(sync.WaitGroup)
+	for i, entry := range order {
+		if spacing > 0 && i > boosted && i < boosted+stale {
+			c.clk.Sleep(spacing)
+		}
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		wg.Add(1)
+		go func(e *Entry) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			ctx, cancel := context.WithTimeout(c.ctx, c.requestTimeout)
+			defer cancel()
+			e.refreshAndLog(ctx, c.StableBackings, c.clientFor(e))
+		}(entry)
+	}
+	wg.Wait()
+}
+
+// monitor refreshes entries every tick, waiting using the cache clock,
+// since a fake clock doesn't block a cache using one should be created
+// with the monitor disabled and refreshAll called directly
 func (c *EntryCache) monitor(tick time.Duration) {
-	ticker := time.NewTicker(tick)
-	for range ticker.C {
-		c.mu.RLock()
-		defer c.mu.RUnlock()
-		for _, entry := range c.entries {
-			go func(e *Entry) {
-				ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
-				defer cancel()
-				e.refreshAndLog(ctx, c.StableBackings, c.client)
-			}(entry)
+	for {
+		c.clk.Sleep(tick)
+		if c.ctx.Err() != nil {
+			return
 		}
+		c.refreshAll()
 	}
 }
+
+// Close cancels any upstream requests in progress, such as issuer
+// and response fetches for entries being added, and stops the
+// monitor, the cache continues to serve the responses it holds
+func (c *EntryCache) Close() {
+	c.cancel()
+}
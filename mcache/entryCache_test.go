@@ -6,11 +6,18 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"fmt"
 	"io/ioutil"
 	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -19,8 +26,11 @@ import (
 	"golang.org/x/crypto/ocsp"
 
 	"github.com/rolandshoemaker/stapled/common"
-
+	"github.com/rolandshoemaker/stapled/config"
 	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/metrics"
+	stapledRand "github.com/rolandshoemaker/stapled/rand"
+	"github.com/rolandshoemaker/stapled/scache"
 )
 
 var everyHash = []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512}
@@ -34,12 +44,13 @@ func TestEntryCache(t *testing.T) {
 		t.Fatalf("Failed to read test issuer: %s", err)
 	}
 	e := &Entry{
-		mu:       new(sync.RWMutex),
-		name:     "test.der",
-		serial:   big.NewInt(1337),
-		issuer:   issuer,
-		response: []byte{5, 0, 1},
+		mu:     new(sync.RWMutex),
+		clk:    fc,
+		name:   "test.der",
+		serial: big.NewInt(1337),
+		issuer: issuer,
 	}
+	e.state.Store(&responseState{response: []byte{5, 0, 1}})
 
 	err = c.add(e)
 	if err != nil {
@@ -63,15 +74,21 @@ func TestEntryCache(t *testing.T) {
 		if !present {
 			t.Fatal("Didn't find response that should be in cache")
 		}
-		if bytes.Compare(response, e.response) != 0 {
+		if bytes.Compare(response, e.current().response) != 0 {
 			t.Fatal("Cache returned wrong response")
 		}
 	}
+	if infos := c.EntriesForSerial(big.NewInt(1337)); len(infos) != 1 || infos[0].Name != "test.der" {
+		t.Fatalf("Unexpected entries for serial: %+v", infos)
+	}
 
 	err = c.Remove("test.der")
 	if err != nil {
 		t.Fatalf("Failed to remove entry from cache: %s", err)
 	}
+	if infos := c.EntriesForSerial(big.NewInt(1337)); len(infos) != 0 {
+		t.Fatalf("Removed entry is still indexed by serial: %+v", infos)
+	}
 
 	for _, h := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
 		nameHash, pkHash, err := common.HashNameAndPKI(h.New(), issuer.RawSubject, issuer.RawSubjectPublicKeyInfo)
@@ -242,13 +259,15 @@ func TestEntryCache(t *testing.T) {
 	br.response = response
 
 	as := &aiaServer{otherOtherCert}
-	asSrv := http.Server{Addr: "localhost:8081", Handler: as}
-	go func() {
-		err := asSrv.ListenAndServe()
-		if err != nil {
-			t.Fatalf("HTTP test server failed: %s", err)
-		}
-	}()
+	asSrv := http.Server{Handler: as}
+	// listen before adding the certificate, the issuer is only
+	// fetched once
+	asListener, err := net.Listen("tcp", "localhost:8081")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	defer asListener.Close()
+	go asSrv.Serve(asListener)
 
 	err = c.AddFromCertificate(ootf.Name(), nil, []string{"http://localhost:8080"})
 	if err != nil {
@@ -273,3 +292,745 @@ func (br *basicResponder) basicFetchHandler(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusOK)
 	w.Write(br.response)
 }
+
+func TestRefreshOrder(t *testing.T) {
+	fc := clock.NewFake()
+	c := NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, nil, new(http.Client), time.Minute, nil, everyHash, true)
+	for i, offset := range []time.Duration{time.Hour, 0, time.Minute, -time.Minute} {
+		e := NewEntry(c.log, fc)
+		e.name = fmt.Sprintf("%d", i)
+		if offset != 0 {
+			e.state.Store(&responseState{nextUpdate: fc.Now().Add(offset)})
+		}
+		c.entries[e.name] = e
+	}
+	order := []string{}
+	entries, _ := c.refreshOrder()
+	for _, e := range entries {
+		order = append(order, e.name)
+	}
+	if strings.Join(order, ",") != "1,3,2,0" {
+		t.Fatalf("Unexpected refresh order: %s", strings.Join(order, ","))
+	}
+}
+
+// fixedSource is a math/rand source which always returns n
+type fixedSource int64
+
+func (fs fixedSource) Int63() int64 { return int64(fs) }
+func (fs fixedSource) Seed(int64)   {}
+
+func TestUpdateWindow(t *testing.T) {
+	fc := clock.NewFake()
+	e := NewEntry(log.NewLogger("", "", 10, fc), fc)
+	// the update window is the last hour of the response's lifetime,
+	// the first half of which has passed
+	window := time.Hour
+	nextUpdate := fc.Now().Add(window / 2)
+	e.state.Store(&responseState{response: []byte{1}, thisUpdate: nextUpdate.Add(-4 * window), nextUpdate: nextUpdate})
+
+	restore := stapledRand.SetSource(fixedSource(0))
+	if !e.timeToUpdate() {
+		t.Fatal("Expected entry to be updated when the start of the window is picked")
+	}
+	restore()
+
+	n := int64(1 << 62)
+	defer stapledRand.SetSource(fixedSource(n))()
+	expected := nextUpdate.Add(-window).Add(time.Duration(n % int64(window))).Before(fc.Now())
+	if e.timeToUpdate() != expected {
+		t.Fatalf("Expected timeToUpdate to be %t with the picked update time", expected)
+	}
+}
+
+func TestRampSpacing(t *testing.T) {
+	fc := clock.NewFake()
+	order := []*Entry{}
+	for _, offset := range []time.Duration{-time.Hour, -time.Minute, -time.Second, time.Hour} {
+		e := NewEntry(nil, fc)
+		e.state.Store(&responseState{nextUpdate: fc.Now().Add(offset)})
+		order = append(order, e)
+	}
+	for _, test := range []struct {
+		interval  time.Duration
+		threshold int
+		spacing   time.Duration
+	}{
+		{0, 0, 0},
+		{time.Minute, 3, 0},
+		{time.Minute, 2, 20 * time.Second},
+	} {
+		stale, spacing := rampSpacing(order, fc.Now(), test.interval, test.threshold)
+		if stale != 3 {
+			t.Fatalf("Expected 3 stale entries, got %d", stale)
+		}
+		if spacing != test.spacing {
+			t.Fatalf("Expected spacing of %s with interval %s and threshold %d, got %s", test.spacing, test.interval, test.threshold, spacing)
+		}
+	}
+}
+
+func TestLookupKey(t *testing.T) {
+	nameHash, keyHash := []byte{1, 2, 3}, []byte{4, 5, 6}
+	for _, serial := range []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1337),
+		new(big.Int).Lsh(big.NewInt(1), 159),
+		new(big.Int).Lsh(big.NewInt(1), 400),
+	} {
+		serialHash := sha256.Sum256(serial.Bytes())
+		expected := sha256.Sum256(append(append([]byte{1, 2, 3}, keyHash...), serialHash[:]...))
+		if key := lookupKey(nameHash, keyHash, serial); key != expected {
+			t.Fatalf("lookupKey returned wrong key for serial %s", serial)
+		}
+	}
+	if !bytes.Equal(nameHash, []byte{1, 2, 3}) {
+		t.Fatal("lookupKey modified the name hash")
+	}
+}
+
+func newLookupBenchmarkCache(t testing.TB) (*EntryCache, *ocsp.Request, *ocsp.Request) {
+	fc := clock.NewFake()
+	c := NewEntryCache(fc, log.NewLogger("", "", 3, fc), time.Minute, nil, new(http.Client), time.Minute, nil, everyHash, true)
+	issuer, err := common.ReadCertificate("../testdata/test-issuer.der")
+	if err != nil {
+		t.Fatalf("Failed to read test issuer: %s", err)
+	}
+	serial, _ := new(big.Int).SetString("3ab1c5f2e7d94b0a8c6e1f2d3a4b5c6d7e8f9012", 16)
+	e := &Entry{
+		mu:     new(sync.RWMutex),
+		name:   "test.der",
+		serial: serial,
+		issuer: issuer,
+	}
+	e.state.Store(&responseState{response: []byte{5, 0, 1}})
+	err = c.add(e)
+	if err != nil {
+		t.Fatalf("Failed to add entry to cache: %s", err)
+	}
+	nameHash, pkHash, err := common.HashNameAndPKI(crypto.SHA1.New(), issuer.RawSubject, issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		t.Fatalf("Failed to hash subject and public key info: %s", err)
+	}
+	hit := &ocsp.Request{HashAlgorithm: crypto.SHA1, IssuerNameHash: nameHash, IssuerKeyHash: pkHash, SerialNumber: serial}
+	miss := &ocsp.Request{HashAlgorithm: crypto.SHA1, IssuerNameHash: nameHash, IssuerKeyHash: pkHash, SerialNumber: big.NewInt(1)}
+	return c, hit, miss
+}
+
+func TestLookupResponseAllocs(t *testing.T) {
+	c, hit, miss := newLookupBenchmarkCache(t)
+	for _, req := range []*ocsp.Request{hit, miss} {
+		allocs := testing.AllocsPerRun(100, func() {
+			c.LookupResponse(req)
+		})
+		if allocs != 0 {
+			t.Fatalf("LookupResponse allocated %.1f times per call for serial %s", allocs, req.SerialNumber)
+		}
+	}
+}
+
+func TestLookupResponseDuringUpdate(t *testing.T) {
+	c, hit, _ := newLookupBenchmarkCache(t)
+	e, present := c.get("test.der")
+	if !present {
+		t.Fatal("Entry isn't in the cache")
+	}
+	// a entry being updated holds its lock, lookups shouldn't wait for it
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	done := make(chan []byte)
+	go func() {
+		response, _ := c.LookupResponse(hit)
+		done <- response
+	}()
+	select {
+	case response := <-done:
+		if !bytes.Equal(response, []byte{5, 0, 1}) {
+			t.Fatalf("Unexpected response: %v", response)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("LookupResponse blocked on the entry lock")
+	}
+}
+
+func BenchmarkLookupResponseHit(b *testing.B) {
+	c, hit, _ := newLookupBenchmarkCache(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.LookupResponse(hit)
+	}
+}
+
+func BenchmarkLookupResponseMiss(b *testing.B) {
+	c, _, miss := newLookupBenchmarkCache(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.LookupResponse(miss)
+	}
+}
+
+func BenchmarkLookupResponseParallel(b *testing.B) {
+	c, hit, _ := newLookupBenchmarkCache(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.LookupResponse(hit)
+		}
+	})
+}
+
+func BenchmarkLookupResponseParallelWithWrites(b *testing.B) {
+	c, hit, _ := newLookupBenchmarkCache(b)
+	issuer, err := common.ReadCertificate("../testdata/test-issuer.der")
+	if err != nil {
+		b.Fatalf("Failed to read test issuer: %s", err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(0); ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			name := fmt.Sprintf("writer-%d.der", i%64)
+			c.add(&Entry{
+				mu:     new(sync.RWMutex),
+				name:   name,
+				serial: big.NewInt(i % 64),
+				issuer: issuer,
+			})
+			c.Remove(name)
+		}
+	}()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.LookupResponse(hit)
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+type memStable struct {
+	resp      *ocsp.Response
+	respBytes []byte
+	reads     int
+	writes    int
+	lastRead  string
+}
+
+func (ms *memStable) Read(name string, _ *big.Int, _ *x509.Certificate) (*ocsp.Response, []byte) {
+	ms.reads++
+	ms.lastRead = name
+	return ms.resp, ms.respBytes
+}
+
+func (ms *memStable) Write(name string, respBytes []byte) {
+	ms.writes++
+	ms.respBytes = respBytes
+}
+
+func TestLoadFromStable(t *testing.T) {
+	fc := clock.NewFake()
+	now := fc.Now()
+	older := &ocsp.Response{ThisUpdate: now.Add(-2 * time.Hour), NextUpdate: now.Add(time.Hour)}
+	newer := &ocsp.Response{ThisUpdate: now.Add(-time.Hour), NextUpdate: now.Add(2 * time.Hour)}
+
+	for _, test := range []struct {
+		selection StableSelection
+		expected  []byte
+		writes    []int
+	}{
+		{FirstStable, []byte{1}, []int{0, 0, 0}},
+		{FreshestStable, []byte{2}, []int{1, 0, 1}},
+	} {
+		backings := []*memStable{
+			{resp: older, respBytes: []byte{1}},
+			{resp: newer, respBytes: []byte{2}},
+			{},
+		}
+		e := NewEntry(log.NewLogger("", "", 10, fc), fc)
+		e.name = "test"
+		e.stableSelection = test.selection
+		if !e.loadFromStable(context.Background(), []scache.Cache{backings[0], backings[1], backings[2]}) {
+			t.Fatal("loadFromStable didn't find a response")
+		}
+		if response := e.current().response; !bytes.Equal(response, test.expected) {
+			t.Fatalf("Unexpected response selected with policy %d: %v", test.selection, response)
+		}
+		for i, b := range backings {
+			if b.writes != test.writes[i] {
+				t.Fatalf("Expected %d writes to backing %d with policy %d, got %d", test.writes[i], i, test.selection, b.writes)
+			}
+		}
+	}
+
+	e := NewEntry(log.NewLogger("", "", 10, fc), fc)
+	e.stableSelection = FreshestStable
+	if e.loadFromStable(context.Background(), []scache.Cache{&memStable{}}) {
+		t.Fatal("loadFromStable found a response in empty backings")
+	}
+}
+
+func TestEntryRequestHash(t *testing.T) {
+	fc := clock.NewFake()
+	issuer, _, _ := newTestIssuer(t)
+	stable := &memStable{resp: &ocsp.Response{NextUpdate: fc.Now().Add(time.Hour)}, respBytes: []byte{1}}
+	for _, test := range []struct {
+		requestHash crypto.Hash
+		expected    crypto.Hash
+	}{
+		{0, crypto.SHA1},
+		{crypto.SHA256, crypto.SHA256},
+	} {
+		e := NewEntry(log.NewLogger("", "", 10, fc), fc)
+		e.serial = big.NewInt(1)
+		e.issuer = issuer
+		e.requestHash = test.requestHash
+		if err := e.init(context.Background(), []scache.Cache{stable}, nil); err != nil {
+			t.Fatalf("init failed: %s", err)
+		}
+		req, err := ocsp.ParseRequest(e.request)
+		if err != nil {
+			t.Fatalf("Failed to parse request: %s", err)
+		}
+		if req.HashAlgorithm != test.expected {
+			t.Fatalf("Expected request using %d, got %d", test.expected, req.HashAlgorithm)
+		}
+	}
+}
+
+func TestLookupStable(t *testing.T) {
+	fc := clock.NewFake()
+	issuer, err := common.ReadCertificate("../testdata/test-issuer.der")
+	if err != nil {
+		t.Fatalf("Failed to read test issuer: %s", err)
+	}
+	stable := &memStable{}
+	c := NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, []scache.Cache{stable}, nil, time.Second, []*x509.Certificate{issuer}, config.SupportedHashes{crypto.SHA1}, true)
+	nameHash, keyHash, err := common.HashNameAndPKI(crypto.SHA1.New(), issuer.RawSubject, issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		t.Fatalf("Failed to hash issuer: %s", err)
+	}
+	req := &ocsp.Request{HashAlgorithm: crypto.SHA1, IssuerNameHash: nameHash, IssuerKeyHash: keyHash, SerialNumber: big.NewInt(7)}
+
+	if _, present := c.LookupStable(req, nil); present {
+		t.Fatal("LookupStable found a response in empty backings")
+	}
+	stable.resp = &ocsp.Response{ThisUpdate: fc.Now(), NextUpdate: fc.Now().Add(time.Hour)}
+	stable.respBytes = []byte{1}
+	if _, present := c.LookupStable(req, nil); present || stable.reads != 1 {
+		t.Fatalf("Expected miss to be remembered, backing was read %d times", stable.reads)
+	}
+
+	fc.Add(defaultStableMissMemo + time.Second)
+	cached, present := c.LookupStable(req, nil)
+	if !present || !bytes.Equal(cached.Response, []byte{1}) {
+		t.Fatalf("LookupStable didn't return response from backings: %v", cached.Response)
+	}
+	if cached.Digest != common.Sum256([]byte{1}) || !cached.NextUpdate.Equal(stable.resp.NextUpdate) || !cached.ThisUpdate.Equal(stable.resp.ThisUpdate) {
+		t.Fatalf("LookupStable returned unexpected derived values: %+v", cached)
+	}
+	if response, present := c.LookupResponse(req); !present || !bytes.Equal(response, []byte{1}) {
+		t.Fatal("Response from backings wasn't promoted into memory")
+	}
+}
+
+func TestNoResponderPolicy(t *testing.T) {
+	fc := clock.NewFake()
+	issuer, issuerDER, _ := newTestIssuer(t)
+	tf, err := ioutil.TempFile("", "cert")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile failed: %s", err)
+	}
+	defer tf.Close()
+	if _, err = tf.Write(issuerDER); err != nil {
+		t.Fatalf("tf.Write failed: %s", err)
+	}
+
+	stable := &memStable{resp: &ocsp.Response{NextUpdate: fc.Now().Add(time.Hour)}, respBytes: []byte{1}}
+	for _, test := range []struct {
+		policy NoResponderPolicy
+		added  bool
+		err    bool
+	}{
+		{WarnNoResponders, true, false},
+		{SkipNoResponders, false, false},
+		{FailNoResponders, false, true},
+	} {
+		c := NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, []scache.Cache{stable}, nil, time.Second, nil, config.SupportedHashes{crypto.SHA1}, true)
+		c.SetNoResponderPolicy(test.policy)
+		err := c.AddFromCertificateWithOptions(tf.Name(), CertificateOptions{Issuer: issuer})
+		if (err != nil) != test.err {
+			t.Fatalf("Unexpected error with policy %d: %v", test.policy, err)
+		}
+		if added := len(c.Entries()) == 1; added != test.added {
+			t.Fatalf("Expected entry to be added with policy %d: %t, got %t", test.policy, test.added, added)
+		}
+	}
+
+	c := NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, []scache.Cache{stable}, nil, time.Second, nil, config.SupportedHashes{crypto.SHA1}, true)
+	c.SetNoResponderPolicy(FailNoResponders)
+	c.SetIssuerResponders([]IssuerResponders{
+		{SKID: []byte{1, 2, 3}, Responders: []string{"http://other.invalid"}},
+		{Subject: issuer.Subject.String(), Responders: []string{"http://ocsp.invalid"}},
+	})
+	if err = c.AddFromCertificateWithOptions(tf.Name(), CertificateOptions{Issuer: issuer}); err != nil {
+		t.Fatalf("Failed to add certificate with issuer responders: %s", err)
+	}
+	if entries := c.Entries(); len(entries) != 1 || len(entries[0].Responders) != 1 || entries[0].Responders[0] != "http://ocsp.invalid" {
+		t.Fatalf("Issuer responders weren't used: %+v", entries)
+	}
+}
+
+func TestEntryNameCollisions(t *testing.T) {
+	fc := clock.NewFake()
+	issuer, issuerDER, _ := newTestIssuer(t)
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var files []string
+	for _, sub := range []string{"a", "b"} {
+		if err = os.Mkdir(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatalf("os.Mkdir failed: %s", err)
+		}
+		filename := filepath.Join(dir, sub, "site.pem")
+		if err = ioutil.WriteFile(filename, issuerDER, 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile failed: %s", err)
+		}
+		files = append(files, filename)
+	}
+
+	stable := &memStable{resp: &ocsp.Response{NextUpdate: fc.Now().Add(time.Hour)}, respBytes: []byte{1}}
+	c := NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, []scache.Cache{stable}, nil, time.Second, nil, config.SupportedHashes{crypto.SHA1}, true)
+	for _, filename := range files {
+		if err = c.AddFromCertificateWithOptions(filename, CertificateOptions{Issuer: issuer}); err != nil {
+			t.Fatalf("Failed to add '%s': %s", filename, err)
+		}
+	}
+	entries := c.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	names := map[string]string{}
+	for _, info := range entries {
+		names[info.Source] = info.Name
+		if info.ID != EntryID(issuer, big.NewInt(1)) || !strings.HasSuffix(info.ID, ":1") {
+			t.Fatalf("Unexpected ID for '%s': %s", info.Name, info.ID)
+		}
+	}
+	disambiguated := names[files[1]]
+	if names[files[0]] != "site" || !strings.HasPrefix(disambiguated, "site-") {
+		t.Fatalf("Unexpected names: %v", names)
+	}
+	if _, present := c.get(entries[0].ID); !present {
+		t.Fatal("Entry wasn't found by its ID")
+	}
+
+	// the name is stable across removal and re-adding
+	if err = c.RemoveFromCertificate(files[1]); err != nil {
+		t.Fatalf("Failed to remove '%s': %s", files[1], err)
+	}
+	if entries = c.Entries(); len(entries) != 1 || entries[0].Name != "site" {
+		t.Fatalf("Removed the wrong entry: %+v", entries)
+	}
+	if err = c.AddFromCertificateWithOptions(files[1], CertificateOptions{Issuer: issuer}); err != nil {
+		t.Fatalf("Failed to re-add '%s': %s", files[1], err)
+	}
+	if _, present := c.get(disambiguated); !present {
+		t.Fatalf("Re-added entry wasn't named '%s'", disambiguated)
+	}
+}
+
+type lockingStable struct {
+	memStable
+	holder string
+}
+
+func (ls *lockingStable) Lock(name, owner string, lease time.Duration) bool {
+	if ls.holder == "" {
+		ls.holder = owner
+	}
+	return ls.holder == owner
+}
+
+func TestLeaderElection(t *testing.T) {
+	fc := clock.NewFake()
+	current := &ocsp.Response{SerialNumber: big.NewInt(1), ThisUpdate: fc.Now().Add(-time.Hour), NextUpdate: fc.Now().Add(-time.Minute)}
+	refreshed := &ocsp.Response{SerialNumber: big.NewInt(1), ThisUpdate: fc.Now(), NextUpdate: fc.Now().Add(time.Hour)}
+	stable := &lockingStable{holder: "leader"}
+
+	e := NewEntry(log.NewLogger("", "", 10, fc), fc)
+	e.name = "test"
+	e.serial = big.NewInt(1)
+	e.responders = []string{"http://responder.invalid"}
+	e.election = &leaderElection{"follower", time.Minute}
+	e.updateResponse(context.Background(), "", 0, "", current, []byte{1}, nil)
+
+	// the leader hasn't refreshed yet, keep the current response
+	if err := e.refreshResponse(context.Background(), []scache.Cache{stable}, nil); err != nil {
+		t.Fatalf("refreshResponse failed: %s", err)
+	}
+	if response := e.current().response; !bytes.Equal(response, []byte{1}) {
+		t.Fatalf("Unexpected response: %v", response)
+	}
+
+	stable.resp, stable.respBytes = refreshed, []byte{2}
+	if err := e.refreshResponse(context.Background(), []scache.Cache{stable}, nil); err != nil {
+		t.Fatalf("refreshResponse failed: %s", err)
+	}
+	if response := e.current().response; !bytes.Equal(response, []byte{2}) {
+		t.Fatalf("Response refreshed by leader wasn't loaded: %v", response)
+	}
+	if stable.writes != 0 {
+		t.Fatal("Follower wrote to the stable backing")
+	}
+}
+
+func TestIssuerFetchCanceled(t *testing.T) {
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "leaf"},
+		IssuingCertificateURL: []string{srv.URL},
+		OCSPServer:            []string{srv.URL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	tf, err := ioutil.TempFile("", "cert")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile failed: %s", err)
+	}
+	defer tf.Close()
+	if _, err = tf.Write(der); err != nil {
+		t.Fatalf("tf.Write failed: %s", err)
+	}
+
+	fc := clock.NewFake()
+	c := NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, nil, nil, time.Hour, nil, everyHash, true)
+	done := make(chan error, 1)
+	go func() { done <- c.AddFromCertificate(tf.Name(), nil, nil) }()
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("AddFromCertificate succeeded without a issuer")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AddFromCertificate didn't return after Close")
+	}
+}
+
+func TestIssuerFetchBackoff(t *testing.T) {
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "leaf"},
+		IssuingCertificateURL: []string{srv.URL},
+		OCSPServer:            []string{srv.URL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	tf, err := ioutil.TempFile("", "cert")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile failed: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	if _, err = tf.Write(der); err != nil {
+		t.Fatalf("tf.Write failed: %s", err)
+	}
+	tf.Close()
+
+	fc := clock.NewFake()
+	c := NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, nil, new(http.Client), time.Minute, nil, everyHash, true)
+	sink := metrics.NewAggregate()
+	c.SetMetrics(sink)
+	for i := 0; i < 2; i++ {
+		if err = c.AddFromCertificate(tf.Name(), nil, nil); err == nil {
+			t.Fatal("AddFromCertificate succeeded without a issuer")
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("Issuer URL was fetched %d times during its backoff", fetches)
+	}
+	host := aiaHost(srv.URL)
+	if failures := c.AIAFailures()[host]; failures != 1 {
+		t.Fatalf("Unexpected failures for '%s': %d", host, failures)
+	}
+	if count := sink.Snapshot().Counters[aiaMetricName(host)]; count != 1 {
+		t.Fatalf("Unexpected failure counter: %d", count)
+	}
+
+	// the backoff doubles after each failure
+	fc.Add(minAIABackoff)
+	c.AddFromCertificate(tf.Name(), nil, nil)
+	fc.Add(minAIABackoff)
+	c.AddFromCertificate(tf.Name(), nil, nil)
+	if fetches != 2 {
+		t.Fatalf("Unexpected number of fetches: %d", fetches)
+	}
+	fc.Add(minAIABackoff)
+	c.AddFromCertificate(tf.Name(), nil, nil)
+	if fetches != 3 {
+		t.Fatalf("Unexpected number of fetches: %d", fetches)
+	}
+}
+
+func TestRefreshInProgress(t *testing.T) {
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-unblock
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	issuer, err := common.ReadCertificate("../testdata/test-issuer.der")
+	if err != nil {
+		t.Fatalf("Failed to read test issuer: %s", err)
+	}
+	fc := clock.NewFake()
+	c := NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, nil, new(http.Client), time.Minute, nil, everyHash, true)
+	newEntry := func() *Entry {
+		e := c.newEntry()
+		e.name = "test.der"
+		e.serial = big.NewInt(1337)
+		e.issuer = issuer
+		e.responders = []string{srv.URL}
+		return e
+	}
+	first, replacement := newEntry(), newEntry()
+
+	// Fetch retries until its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- first.fetchResponse(ctx, nil, c.client) }()
+	<-started
+	// a entry replacing one with the same name shouldn't fetch until
+	// the fetch of the one it replaced has finished
+	if err = replacement.fetchResponse(context.Background(), nil, c.client); err != ErrRefreshInProgress {
+		t.Fatalf("Expected ErrRefreshInProgress while a fetch was running, got: %v", err)
+	}
+	cancel()
+	close(unblock)
+	if err = <-done; err == nil || err == ErrRefreshInProgress {
+		t.Fatalf("Unexpected error from the running fetch: %v", err)
+	}
+	if stats := c.Stats(0); stats.RefreshSkipped != 1 || stats.RefreshFailures != 1 {
+		t.Fatalf("Unexpected refresh counts: %+v", stats)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err = replacement.fetchResponse(ctx, nil, c.client); err == ErrRefreshInProgress {
+		t.Fatal("Fetch was skipped after the running fetch finished")
+	}
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	fc, issuer := tf.fc, tf.issuer
+	tf.serve(tf.sign(t, ocsp.Response{
+		SerialNumber: big.NewInt(1337),
+		Status:       ocsp.Unknown,
+		ThisUpdate:   fc.Now(),
+		NextUpdate:   fc.Now().Add(time.Hour),
+	}))
+
+	for _, test := range []struct {
+		policy UnknownPolicy
+		err    error
+		cached bool
+	}{
+		{ServeUnknown, nil, true},
+		{RetryUnknown, ErrUnknownStatus, false},
+		{AlertUnknown, nil, true},
+	} {
+		c := NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, nil, new(http.Client), time.Minute, nil, everyHash, true)
+		stable := &memStable{}
+		e := c.newEntry()
+		e.name = "test.der"
+		e.serial = big.NewInt(1337)
+		e.issuer = issuer
+		e.responders = []string{tf.srv.URL}
+		e.unknownPolicy = test.policy
+		if err := e.init(context.Background(), []scache.Cache{stable}, c.client); err != test.err {
+			t.Fatalf("Unexpected error with policy %d: %v", test.policy, err)
+		}
+		if cached := e.current().response != nil; cached != test.cached || (stable.writes > 0) != test.cached {
+			t.Fatalf("Unexpected caching with policy %d: in memory %t, %d stable writes", test.policy, cached, stable.writes)
+		}
+		if info := e.Info(); info.UnknownPolicy != test.policy {
+			t.Fatalf("Unexpected policy in entry info: %d", info.UnknownPolicy)
+		}
+		if stats := c.Stats(0); stats.Unknown != 1 {
+			t.Fatalf("Expected 1 unknown response to be counted, got %d", stats.Unknown)
+		}
+	}
+
+	if _, err := ParseUnknownPolicy("ignore"); err == nil {
+		t.Fatal("ParseUnknownPolicy accepted a unknown policy")
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	hexed := strings.Repeat("ab", 32)
+	colons := strings.TrimSuffix(strings.Repeat("AB:", 32), ":")
+	for s, valid := range map[string]bool{
+		hexed:                    true,
+		strings.ToUpper(hexed):   true,
+		colons:                   true,
+		hexed[2:]:                false,
+		hexed + "ab":             false,
+		"ab:" + hexed[2:]:        false,
+		strings.Repeat("zz", 32): false,
+	} {
+		fingerprint, ok := ParseFingerprint(s)
+		if ok != valid {
+			t.Fatalf("Unexpected result parsing %q: %t", s, ok)
+		}
+		if ok && fingerprint[0] != 0xab {
+			t.Fatalf("Unexpected fingerprint parsed from %q: %x", s, fingerprint)
+		}
+	}
+}
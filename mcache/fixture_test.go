@@ -0,0 +1,119 @@
+package mcache
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+// newTestIssuer creates a self-signed issuer, returning it along with
+// its DER encoding and key
+func newTestIssuer(t *testing.T) (*x509.Certificate, []byte, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "issuer"}}
+	issuerDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	issuer, err := x509.ParseCertificate(issuerDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	return issuer, issuerDER, key
+}
+
+// testFixture is a EntryCache without stable backings along with a
+// issuer and a upstream responder serving a response the issuer
+// signed, by default a good response for serial 1337 which is valid
+// for four days
+type testFixture struct {
+	fc       clock.FakeClock
+	c        *EntryCache
+	issuer   *x509.Certificate
+	key      *rsa.PrivateKey // of the issuer
+	srv      *httptest.Server
+	requests int64 // sent to srv
+
+	mu       sync.Mutex
+	response []byte // served by srv
+}
+
+func newTestFixture(t *testing.T) *testFixture {
+	fc := clock.NewFake()
+	fc.Set(time.Now())
+	tf := &testFixture{fc: fc}
+	tf.issuer, _, tf.key = newTestIssuer(t)
+	tf.response = tf.sign(t, ocsp.Response{
+		SerialNumber: big.NewInt(1337),
+		Status:       ocsp.Good,
+		ThisUpdate:   fc.Now(),
+		NextUpdate:   fc.Now().Add(96 * time.Hour),
+	})
+	tf.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&tf.requests, 1)
+		tf.mu.Lock()
+		response := tf.response
+		tf.mu.Unlock()
+		w.Write(response)
+	}))
+	tf.c = NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, nil, new(http.Client), time.Minute, nil, everyHash, true)
+	return tf
+}
+
+func (tf *testFixture) close() {
+	tf.srv.Close()
+}
+
+// sign creates a response signed by the issuer
+func (tf *testFixture) sign(t *testing.T, template ocsp.Response) []byte {
+	response, err := ocsp.CreateResponse(tf.issuer, tf.issuer, template, tf.key)
+	if err != nil {
+		t.Fatalf("ocsp.CreateResponse failed: %s", err)
+	}
+	return response
+}
+
+// serve replaces the response served by the upstream responder
+func (tf *testFixture) serve(response []byte) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	tf.response = response
+}
+
+// upstreamRequests returns the number of requests sent to the upstream
+// responder
+func (tf *testFixture) upstreamRequests() int64 {
+	return atomic.LoadInt64(&tf.requests)
+}
+
+// addEntry adds a entry for serial 1337 fetching from the upstream
+// responder to the cache
+func (tf *testFixture) addEntry(t *testing.T, name string) *Entry {
+	e := tf.c.newEntry()
+	e.name = name
+	e.serial = big.NewInt(1337)
+	e.issuer = tf.issuer
+	e.responders = []string{tf.srv.URL}
+	if err := e.init(context.Background(), nil, tf.c.client); err != nil {
+		t.Fatalf("Failed to initialize entry: %s", err)
+	}
+	tf.c.entries[name] = e
+	return e
+}
@@ -0,0 +1,119 @@
+package mcache
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+)
+
+// defaultHistorySize is the number of upstream requests remembered
+// for each entry
+const defaultHistorySize = 16
+
+// FetchAttempt is a upstream request made to refresh the response
+// of a entry
+type FetchAttempt struct {
+	Time       time.Time
+	Responder  string
+	StatusCode int // zero if no response was received
+	Latency    time.Duration
+	Error      string // empty if a valid response was received
+}
+
+// fetchHistory is a ring buffer of the most recent upstream requests
+// for a entry, a nil *fetchHistory remembers nothing
+type fetchHistory struct {
+	mu       sync.Mutex
+	attempts []FetchAttempt
+	next     int // index the next attempt is written to
+	full     bool
+}
+
+func newFetchHistory(size int) *fetchHistory {
+	if size <= 0 {
+		return nil
+	}
+	return &fetchHistory{attempts: make([]FetchAttempt, size)}
+}
+
+// observe records a attempt reported by stapledOCSP.Fetch
+func (fh *fetchHistory) observe(a stapledOCSP.Attempt) {
+	fa := FetchAttempt{
+		Time:       a.Started,
+		Responder:  a.Responder,
+		StatusCode: a.StatusCode,
+		Latency:    a.Latency,
+	}
+	if a.Err != nil {
+		fa.Error = a.Err.Error()
+	}
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.attempts[fh.next] = fa
+	fh.next = (fh.next + 1) % len(fh.attempts)
+	if fh.next == 0 {
+		fh.full = true
+	}
+}
+
+// reject records that the response received by the last attempt was
+// rejected after it was fetched, for instance because it wasn't valid
+// for the certificate
+func (fh *fetchHistory) reject(err error) {
+	if fh == nil {
+		return
+	}
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if !fh.full && fh.next == 0 {
+		return
+	}
+	last := &fh.attempts[(fh.next+len(fh.attempts)-1)%len(fh.attempts)]
+	if last.Error == "" {
+		last.Error = "response rejected: " + err.Error()
+	}
+}
+
+// list returns the remembered attempts, oldest first
+func (fh *fetchHistory) list() []FetchAttempt {
+	if fh == nil {
+		return nil
+	}
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if !fh.full {
+		return append([]FetchAttempt(nil), fh.attempts[:fh.next]...)
+	}
+	return append(append([]FetchAttempt(nil), fh.attempts[fh.next:]...), fh.attempts[:fh.next]...)
+}
+
+// SetFetchHistory sets how many upstream requests are remembered for
+// each entry added after it is called, zero remembers none
+func (c *EntryCache) SetFetchHistory(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.historySize = size
+}
+
+// FetchHistory returns the most recent upstream requests, oldest
+// first, made for the entry with the certificate identified by a
+// issuer key hash, using any of the supported hashes, and serial
+func (c *EntryCache) FetchHistory(issuerKeyHash []byte, serial *big.Int) ([]FetchAttempt, bool) {
+	e := c.lookupEntry(issuerKeyHash, serial)
+	if e == nil {
+		return nil, false
+	}
+	return e.history.list(), true
+}
+
+// FindHistory is FetchHistory for the entry with a name, canonical ID,
+// or SHA-256 certificate fingerprint, see ParseFingerprint
+func (c *EntryCache) FindHistory(nameOrID string) ([]FetchAttempt, bool) {
+	e, present := c.get(nameOrID)
+	if !present {
+		return nil, false
+	}
+	return e.history.list(), true
+}
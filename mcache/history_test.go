@@ -0,0 +1,31 @@
+package mcache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+)
+
+func TestFetchHistory(t *testing.T) {
+	fh := newFetchHistory(3)
+	fh.reject(errors.New("nothing to reject"))
+	if attempts := fh.list(); len(attempts) != 0 {
+		t.Fatalf("Expected empty history, got %+v", attempts)
+	}
+	for i := 0; i < 5; i++ {
+		fh.observe(stapledOCSP.Attempt{Responder: fmt.Sprintf("%d", i), StatusCode: 200})
+	}
+	fh.reject(errors.New("bad signature"))
+	attempts := fh.list()
+	if len(attempts) != 3 || attempts[0].Responder != "2" || attempts[2].Responder != "4" {
+		t.Fatalf("Expected the three most recent attempts oldest first, got %+v", attempts)
+	}
+	if attempts[2].Error != "response rejected: bad signature" || attempts[1].Error != "" {
+		t.Fatalf("Expected only the last attempt to be rejected, got %+v", attempts)
+	}
+	if newFetchHistory(0).list() != nil {
+		t.Fatal("Expected a disabled history to be empty")
+	}
+}
@@ -0,0 +1,47 @@
+package mcache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrRefreshInProgress is returned when a entry is refreshed while a
+// upstream fetch for a entry with the same name is already running
+var ErrRefreshInProgress = errors.New("refresh skipped, already running")
+
+// refreshTracker tracks the entries with a upstream fetch in progress
+// so that monitor ticks, Refresh, and entries being added don't send
+// duplicate requests upstream or race writing to the stable backings.
+// Entries are tracked by name so a entry that replaced another with
+// the same name waits for the fetch of the one it replaced
+type refreshTracker struct {
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+func newRefreshTracker() *refreshTracker {
+	return &refreshTracker{names: make(map[string]struct{})}
+}
+
+// begin marks name as refreshing, it returns false if it already is
+func (rt *refreshTracker) begin(name string) bool {
+	if rt == nil {
+		return true
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if _, present := rt.names[name]; present {
+		return false
+	}
+	rt.names[name] = struct{}{}
+	return true
+}
+
+func (rt *refreshTracker) end(name string) {
+	if rt == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.names, name)
+}
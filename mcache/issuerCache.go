@@ -1,76 +1,257 @@
 package mcache
 
 import (
-	"crypto/sha256"
+	"bytes"
+	"crypto"
 	"crypto/x509"
 	"sync"
+	"sync/atomic"
 
 	"github.com/rolandshoemaker/stapled/common"
 	"github.com/rolandshoemaker/stapled/config"
 )
 
+// cachedIssuer is a issuer in a issuerCache along with the state used
+// to decide whether it can be evicted
+type cachedIssuer struct {
+	used   uint64 // tick of the last lookup, accessed atomically
+	cert   *x509.Certificate
+	skid   [32]byte   // subjectPlusSKID key
+	keys   [][32]byte // subjectPlusSPKI keys
+	refs   int        // number of entries using the issuer
+	pinned bool       // provided when the cache was created, never evicted
+}
+
+// issuerCache holds the issuers of the entries in the cache. Issuers
+// provided when it is created are kept forever, others, such as those
+// fetched using AIA, are reference counted by the entries using them
+// and once there are more than max issuers those no entry uses are
+// evicted, least recently looked up first
 type issuerCache struct {
-	subjectPlusSKID map[[32]byte]*x509.Certificate
-	subjectPlusSPKI map[[32]byte]*x509.Certificate
+	tick uint64 // accessed atomically
+
+	subjectPlusSKID map[[32]byte]*cachedIssuer
+	subjectPlusSPKI map[[32]byte]*cachedIssuer
+	issuers         []*cachedIssuer
 	hashes          config.SupportedHashes
+	max             int                     // zero is unlimited
+	onEvict         func(*x509.Certificate) // called with mu held
 	mu              sync.RWMutex
 }
 
 func newIssuerCache(issuers []*x509.Certificate, supportedHashes config.SupportedHashes) *issuerCache {
 	ic := &issuerCache{
-		subjectPlusSKID: make(map[[32]byte]*x509.Certificate),
-		subjectPlusSPKI: make(map[[32]byte]*x509.Certificate),
+		subjectPlusSKID: make(map[[32]byte]*cachedIssuer),
+		subjectPlusSPKI: make(map[[32]byte]*cachedIssuer),
 		hashes:          supportedHashes,
 	}
 	for _, issuer := range issuers {
-		ic.add(issuer)
+		if ci, err := ic.insert(issuer, false); err == nil {
+			ci.pinned = true
+		}
 	}
 	return ic
 }
 
+// touch marks ci as the most recently looked up issuer
+func (ic *issuerCache) touch(ci *cachedIssuer) *x509.Certificate {
+	if ci == nil {
+		return nil
+	}
+	atomic.StoreUint64(&ci.used, atomic.AddUint64(&ic.tick, 1))
+	return ci.cert
+}
+
+// skidKey returns the subjectPlusSKID key for a issuer subject and
+// subject key ID
+func skidKey(subject, skid []byte) [32]byte {
+	// work around for a bug of sorts in encoding/asn1
+	// https://github.com/golang/go/issues/14882
+	subj := make([]byte, len(subject))
+	copy(subj, subject)
+	return common.Sum256(append(subj, skid...))
+}
+
 func (ic *issuerCache) getFromCertificate(issuerSubject, akid []byte) *x509.Certificate {
-	subj := make([]byte, len(issuerSubject))
-	copy(subj, issuerSubject)
-	hashed := sha256.Sum256(append(subj, akid...))
+	hashed := skidKey(issuerSubject, akid)
 	ic.mu.RLock()
 	defer ic.mu.RUnlock()
-	return ic.subjectPlusSKID[hashed]
+	return ic.touch(ic.subjectPlusSKID[hashed])
 }
 
 func (ic *issuerCache) getFromRequest(issuerSubjectHash, spkiHash []byte) *x509.Certificate {
-	hashed := sha256.Sum256(append(issuerSubjectHash, spkiHash...))
+	hashed := common.Sum256(append(issuerSubjectHash, spkiHash...))
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	return ic.touch(ic.subjectPlusSPKI[hashed])
+}
+
+// getFromKeyHash returns the issuer whose public key hashes to
+// keyHash using one of the supported hashes, along with that hash
+func (ic *issuerCache) getFromKeyHash(keyHash []byte) (*x509.Certificate, crypto.Hash) {
 	ic.mu.RLock()
 	defer ic.mu.RUnlock()
-	return ic.subjectPlusSPKI[hashed]
+	for _, h := range ic.hashes {
+		if h.Size() != len(keyHash) {
+			continue
+		}
+		for _, ci := range ic.issuers {
+			_, spki, err := common.HashIssuer(h, ci.cert.RawSubject, ci.cert.RawSubjectPublicKeyInfo)
+			if err == nil && bytes.Equal(spki, keyHash) {
+				return ic.touch(ci), h
+			}
+		}
+	}
+	return nil, 0
 }
 
 func allIssuerHashes(i *x509.Certificate, supportedHashes config.SupportedHashes) ([][32]byte, error) {
 	hashes := [][32]byte{}
 	for _, h := range supportedHashes {
-		name, spki, err := common.HashNameAndPKI(h.New(), i.RawSubject, i.RawSubjectPublicKeyInfo)
+		name, spki, err := common.HashIssuer(h, i.RawSubject, i.RawSubjectPublicKeyInfo)
 		if err != nil {
 			return nil, err
 		}
-		hashes = append(hashes, sha256.Sum256(append(name, spki...)))
+		hashes = append(hashes, common.Sum256(append(name, spki...)))
 	}
 	return hashes, nil
 }
 
 func (ic *issuerCache) add(issuer *x509.Certificate) error {
-	// work around for a bug of sorts in encoding/asn1
-	// https://github.com/golang/go/issues/14882
-	subj := make([]byte, len(issuer.RawSubject))
-	copy(subj, issuer.RawSubject)
-	spskid := sha256.Sum256(append(subj, issuer.SubjectKeyId...))
+	_, err := ic.insert(issuer, false)
+	return err
+}
+
+// insert adds issuer to the cache, if it isn't already cached, and
+// returns it, if ref is true it is also referenced. Unused issuers may
+// be evicted to make room but issuer itself never is
+func (ic *issuerCache) insert(issuer *x509.Certificate, ref bool) (*cachedIssuer, error) {
+	key := skidKey(issuer.RawSubject, issuer.SubjectKeyId)
 	otherHashes, err := allIssuerHashes(issuer, ic.hashes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	ic.mu.Lock()
 	defer ic.mu.Unlock()
-	ic.subjectPlusSKID[spskid] = issuer
+	ci, present := ic.subjectPlusSKID[key]
+	if !present {
+		ci = &cachedIssuer{skid: key, keys: otherHashes}
+		ic.subjectPlusSKID[key] = ci
+		ic.issuers = append(ic.issuers, ci)
+	}
+	ci.cert = issuer
 	for _, h := range otherHashes {
-		ic.subjectPlusSPKI[h] = issuer
+		ic.subjectPlusSPKI[h] = ci
+	}
+	if ref {
+		ci.refs++
+	}
+	ic.touch(ci)
+	ic.trim(ci)
+	return ci, nil
+}
+
+// ref records that a entry uses issuer, adding it back to the cache if
+// it was evicted between the entry being built and added
+func (ic *issuerCache) ref(issuer *x509.Certificate) error {
+	if issuer == nil {
+		return nil
+	}
+	key := skidKey(issuer.RawSubject, issuer.SubjectKeyId)
+	ic.mu.Lock()
+	ci, present := ic.subjectPlusSKID[key]
+	if present {
+		ci.refs++
+		ic.mu.Unlock()
+		return nil
+	}
+	ic.mu.Unlock()
+	// the cache is unlocked while the issuer's hashes are computed
+	// so it may have been added again in the meantime, which insert
+	// handles
+	_, err := ic.insert(issuer, true)
+	return err
+}
+
+// release records that a entry no longer uses issuer, once no entries
+// use it it may be evicted
+func (ic *issuerCache) release(issuer *x509.Certificate) {
+	if issuer == nil {
+		return
+	}
+	key := skidKey(issuer.RawSubject, issuer.SubjectKeyId)
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ci, present := ic.subjectPlusSKID[key]
+	if !present || ci.refs == 0 {
+		return
+	}
+	ci.refs--
+	if ci.refs == 0 {
+		ic.trim(nil)
+	}
+}
+
+// setMax sets the maximum number of issuers, evicting unused issuers
+// if there are already more
+func (ic *issuerCache) setMax(max int) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.max = max
+	ic.trim(nil)
+}
+
+// trim evicts the least recently looked up unused issuers, other than
+// keep, until there are at most max, or there are no more that can be
+// evicted. ic.mu must be held
+func (ic *issuerCache) trim(keep *cachedIssuer) {
+	for ic.max > 0 && len(ic.issuers) > ic.max {
+		oldest := -1
+		for i, ci := range ic.issuers {
+			if ci == keep || ci.pinned || ci.refs > 0 {
+				continue
+			}
+			if oldest < 0 || atomic.LoadUint64(&ci.used) < atomic.LoadUint64(&ic.issuers[oldest].used) {
+				oldest = i
+			}
+		}
+		if oldest < 0 {
+			return
+		}
+		ic.evict(oldest)
+	}
+}
+
+// evict removes the issuer at index i of ic.issuers. ic.mu must be held
+func (ic *issuerCache) evict(i int) {
+	ci := ic.issuers[i]
+	ic.issuers = append(ic.issuers[:i], ic.issuers[i+1:]...)
+	delete(ic.subjectPlusSKID, ci.skid)
+	for _, h := range ci.keys {
+		// another issuer with the same subject and key, but a
+		// different subject key ID, may have replaced it
+		if ic.subjectPlusSPKI[h] == ci {
+			delete(ic.subjectPlusSPKI, h)
+		}
+	}
+	if ic.onEvict != nil {
+		ic.onEvict(ci.cert)
+	}
+}
+
+// len returns the number of cached issuers
+func (ic *issuerCache) len() int {
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	return len(ic.issuers)
+}
+
+func (ic *issuerCache) all() []*x509.Certificate {
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	all := make([]*x509.Certificate, len(ic.issuers))
+	for i, ci := range ic.issuers {
+		all[i] = ci.cert
 	}
-	return nil
+	return all
 }
@@ -3,6 +3,7 @@ package mcache
 import (
 	"crypto"
 	"crypto/x509"
+	"fmt"
 	"testing"
 
 	"github.com/rolandshoemaker/stapled/common"
@@ -39,3 +40,74 @@ func TestIssuerCache(t *testing.T) {
 	ic = newIssuerCache([]*x509.Certificate{testIssuer}, everyHash)
 	tester(ic, testIssuer)
 }
+
+func TestIssuerCacheEviction(t *testing.T) {
+	testIssuer, err := common.ReadCertificate("../testdata/test-issuer.der")
+	if err != nil {
+		t.Fatalf("Failed to read ../testdata/test-issuer.der: %s", err)
+	}
+	issuer := func(i int) *x509.Certificate {
+		return &x509.Certificate{
+			RawSubject:              []byte(fmt.Sprintf("issuer %d", i)),
+			SubjectKeyId:            []byte{byte(i)},
+			RawSubjectPublicKeyInfo: testIssuer.RawSubjectPublicKeyInfo,
+		}
+	}
+	cached := func(ic *issuerCache, issuer *x509.Certificate) bool {
+		return ic.getFromCertificate(issuer.RawSubject, issuer.SubjectKeyId) != nil
+	}
+
+	pinned := issuer(0)
+	ic := newIssuerCache([]*x509.Certificate{pinned}, everyHash)
+	evicted := 0
+	ic.onEvict = func(*x509.Certificate) { evicted++ }
+	ic.setMax(4)
+
+	used, unused := issuer(1), issuer(2)
+	if err = ic.ref(used); err != nil {
+		t.Fatalf("Failed to reference issuer: %s", err)
+	}
+	if err = ic.add(unused); err != nil {
+		t.Fatalf("Failed to add issuer: %s", err)
+	}
+	// looking up the unused issuer makes the next one the least
+	// recently used
+	lru := issuer(3)
+	if err = ic.add(lru); err != nil {
+		t.Fatalf("Failed to add issuer: %s", err)
+	}
+	cached(ic, unused)
+	if err = ic.add(issuer(4)); err != nil {
+		t.Fatalf("Failed to add issuer: %s", err)
+	}
+	if ic.len() != 4 || evicted != 1 || cached(ic, lru) {
+		t.Fatalf("Expected the least recently used issuer to be evicted, %d issuers are cached and %d were evicted", ic.len(), evicted)
+	}
+	if !cached(ic, pinned) || !cached(ic, used) || !cached(ic, unused) {
+		t.Fatal("Expected the pinned, used, and recently used issuers to be kept")
+	}
+
+	// once the last entry using it is removed it can be evicted
+	for i := 5; i < 8; i++ {
+		if err = ic.ref(issuer(i)); err != nil {
+			t.Fatalf("Failed to reference issuer: %s", err)
+		}
+	}
+	if ic.len() != 5 || !cached(ic, used) {
+		t.Fatalf("Expected only unused issuers to be evicted, %d issuers are cached", ic.len())
+	}
+	ic.release(used)
+	if cached(ic, used) {
+		t.Fatal("Expected issuer to be evicted once it was released")
+	}
+	if !cached(ic, pinned) {
+		t.Fatal("Expected pinned issuer to never be evicted")
+	}
+	nameHash, keyHash, err := common.HashNameAndPKI(crypto.SHA1.New(), used.RawSubject, used.RawSubjectPublicKeyInfo)
+	if err != nil {
+		t.Fatalf("Failed to hash issuer: %s", err)
+	}
+	if ic.getFromRequest(nameHash, keyHash) != nil {
+		t.Fatal("Expected evicted issuer to not be found by request")
+	}
+}
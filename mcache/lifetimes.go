@@ -0,0 +1,104 @@
+package mcache
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultLifetimeBuckets are the upper bounds used to bucket the
+// remaining lifetime of responses if none are provided
+var DefaultLifetimeBuckets = []time.Duration{
+	time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+	48 * time.Hour,
+	72 * time.Hour,
+	96 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// IssuerLifetimes is a histogram of how long the responses held for
+// the certificates of a issuer have left until their NextUpdate, and
+// the validity windows (NextUpdate - ThisUpdate) of the responses
+type IssuerLifetimes struct {
+	Issuer        string // issuer subject
+	IssuerKeyHash string // hex SHA-256, the prefix of the entry IDs
+	// Counts has a count for each bucket, the entries whose response
+	// expires at or before the bucket, and after the previous one,
+	// and a final count for those that expire after the last bucket
+	Counts      []int
+	Expired     int
+	NoResponse  int
+	MinValidity time.Duration
+	MaxValidity time.Duration
+	AvgValidity time.Duration
+}
+
+// Lifetimes buckets the entries in the cache by the remaining
+// lifetime of their responses for each issuer, sorted by issuer
+// subject, which can be used to predict refresh load and to spot CAs
+// that have shortened the validity of their responses
+func (c *EntryCache) Lifetimes(buckets []time.Duration) []IssuerLifetimes {
+	if len(buckets) == 0 {
+		buckets = DefaultLifetimeBuckets
+	}
+	now := c.clk.Now()
+	issuers := make(map[string]*IssuerLifetimes)
+	validityTotals := make(map[string]time.Duration)
+	c.mu.RLock()
+	for _, e := range c.entries {
+		keyHash := e.id
+		if i := strings.Index(keyHash, ":"); i >= 0 {
+			keyHash = keyHash[:i]
+		}
+		il, present := issuers[keyHash]
+		if !present {
+			il = &IssuerLifetimes{IssuerKeyHash: keyHash, Counts: make([]int, len(buckets)+1)}
+			if e.issuer != nil {
+				il.Issuer = e.issuer.Subject.String()
+			}
+			issuers[keyHash] = il
+		}
+		st := e.current()
+		if st.response == nil {
+			il.NoResponse++
+			continue
+		}
+		validity := st.nextUpdate.Sub(st.thisUpdate)
+		if il.MinValidity == 0 || validity < il.MinValidity {
+			il.MinValidity = validity
+		}
+		if validity > il.MaxValidity {
+			il.MaxValidity = validity
+		}
+		validityTotals[keyHash] += validity
+		remaining := st.nextUpdate.Sub(now)
+		if remaining <= 0 {
+			il.Expired++
+			continue
+		}
+		il.Counts[sort.Search(len(buckets), func(i int) bool { return remaining <= buckets[i] })]++
+	}
+	c.mu.RUnlock()
+
+	lifetimes := make([]IssuerLifetimes, 0, len(issuers))
+	for keyHash, il := range issuers {
+		withResponse := il.Expired
+		for _, count := range il.Counts {
+			withResponse += count
+		}
+		if withResponse > 0 {
+			il.AvgValidity = validityTotals[keyHash] / time.Duration(withResponse)
+		}
+		lifetimes = append(lifetimes, *il)
+	}
+	sort.Slice(lifetimes, func(i, j int) bool {
+		if lifetimes[i].Issuer != lifetimes[j].Issuer {
+			return lifetimes[i].Issuer < lifetimes[j].Issuer
+		}
+		return lifetimes[i].IssuerKeyHash < lifetimes[j].IssuerKeyHash
+	})
+	return lifetimes
+}
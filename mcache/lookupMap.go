@@ -0,0 +1,103 @@
+package mcache
+
+import (
+	"sync"
+	"time"
+)
+
+// lookupShards is the number of shards the lookup map is split into,
+// shards are selected using the first byte of the key
+const lookupShards = 256
+
+type lookupShard struct {
+	mu      sync.RWMutex
+	entries map[[32]byte][]*Entry
+	_       [32]byte // keep shards on separate cache lines
+}
+
+// lookupMap is a many-to-one map of sha256 hashed OCSP requests to
+// entries, split into shards so that lookups only contend with writes
+// to keys in the same shard. A key usually maps to a single entry but
+// may map to several if the same certificate is loaded under different
+// names, in which case the entry with the freshest response is used
+type lookupMap struct {
+	shards [lookupShards]lookupShard
+}
+
+func newLookupMap() *lookupMap {
+	lm := &lookupMap{}
+	for i := range lm.shards {
+		lm.shards[i].entries = make(map[[32]byte][]*Entry)
+	}
+	return lm
+}
+
+func (lm *lookupMap) get(key [32]byte) (*Entry, bool) {
+	shard := &lm.shards[key[0]]
+	shard.mu.RLock()
+	entries := shard.entries[key]
+	shard.mu.RUnlock()
+	switch len(entries) {
+	case 0:
+		return nil, false
+	case 1:
+		return entries[0], true
+	}
+	return freshest(entries), true
+}
+
+// freshest returns the entry with the most recently produced response,
+// or the first entry if none of them have a response
+func freshest(entries []*Entry) *Entry {
+	var best *Entry
+	var bestUpdate time.Time
+	for _, e := range entries {
+		st := e.current()
+		thisUpdate, hasResponse := st.thisUpdate, st.response != nil
+		if hasResponse && (best == nil || thisUpdate.After(bestUpdate)) {
+			best, bestUpdate = e, thisUpdate
+		}
+	}
+	if best == nil {
+		return entries[0]
+	}
+	return best
+}
+
+// set maps key to e, replacing any entry with the same name, it
+// returns the names of the other entries key maps to
+func (lm *lookupMap) set(key [32]byte, e *Entry) []string {
+	shard := &lm.shards[key[0]]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	// entries is copied rather than modified in place since get reads
+	// it without holding the lock
+	var entries []*Entry
+	var others []string
+	for _, existing := range shard.entries[key] {
+		if existing.name != e.name {
+			entries = append(entries, existing)
+			others = append(others, existing.name)
+		}
+	}
+	shard.entries[key] = append(entries, e)
+	return others
+}
+
+// delete removes the mapping of key to the entry named name
+func (lm *lookupMap) delete(key [32]byte, name string) {
+	shard := &lm.shards[key[0]]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	var entries []*Entry
+	for _, existing := range shard.entries[key] {
+		if existing.name != name {
+			entries = append(entries, existing)
+		}
+	}
+	if len(entries) == 0 {
+		delete(shard.entries, key)
+		return
+	}
+	shard.entries[key] = entries
+}
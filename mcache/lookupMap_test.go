@@ -0,0 +1,94 @@
+package mcache
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLookupMap(t *testing.T) {
+	lm := newLookupMap()
+	entries := map[[32]byte]*Entry{}
+	for i := 0; i < 1000; i++ {
+		key := sha256.Sum256([]byte(fmt.Sprintf("%d", i)))
+		e := &Entry{name: fmt.Sprintf("%d", i)}
+		entries[key] = e
+		lm.set(key, e)
+	}
+	for key, e := range entries {
+		found, present := lm.get(key)
+		if !present {
+			t.Fatalf("Didn't find entry '%s'", e.name)
+		}
+		if found != e {
+			t.Fatalf("Found wrong entry for '%s': '%s'", e.name, found.name)
+		}
+	}
+	for key, e := range entries {
+		lm.delete(key, e.name)
+	}
+	for key, e := range entries {
+		if _, present := lm.get(key); present {
+			t.Fatalf("Found entry '%s' which should've been deleted", e.name)
+		}
+	}
+}
+
+func TestLookupMapDuplicates(t *testing.T) {
+	lm := newLookupMap()
+	key := sha256.Sum256([]byte("cert"))
+	now := time.Now()
+	older := &Entry{name: "older", mu: new(sync.RWMutex)}
+	older.state.Store(&responseState{response: []byte{1}, thisUpdate: now.Add(-time.Hour)})
+	newer := &Entry{name: "newer", mu: new(sync.RWMutex)}
+	newer.state.Store(&responseState{response: []byte{2}, thisUpdate: now})
+	empty := &Entry{name: "empty", mu: new(sync.RWMutex)}
+
+	if others := lm.set(key, empty); len(others) != 0 {
+		t.Fatalf("Unexpected duplicates for first entry: %q", others)
+	}
+	lm.set(key, newer)
+	if others := lm.set(key, older); len(others) != 2 || others[0] != "empty" || others[1] != "newer" {
+		t.Fatalf("Unexpected duplicates: %q", others)
+	}
+	if found, _ := lm.get(key); found != newer {
+		t.Fatalf("Expected the freshest entry, got '%s'", found.name)
+	}
+	// replacing a entry with the same name shouldn't add a duplicate
+	lm.set(key, older)
+	lm.delete(key, "newer")
+	if found, _ := lm.get(key); found != older {
+		t.Fatalf("Expected the remaining entry with a response, got '%s'", found.name)
+	}
+	lm.delete(key, "older")
+	if found, _ := lm.get(key); found != empty {
+		t.Fatalf("Expected the remaining entry, got '%s'", found.name)
+	}
+	lm.delete(key, "empty")
+	if _, present := lm.get(key); present {
+		t.Fatal("Found entry after every duplicate was deleted")
+	}
+}
+
+func TestLookupMapConcurrent(t *testing.T) {
+	lm := newLookupMap()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := sha256.Sum256([]byte(fmt.Sprintf("%d-%d", i, j)))
+				lm.set(key, &Entry{})
+				if _, present := lm.get(key); !present {
+					t.Errorf("Didn't find key that was just set")
+					return
+				}
+				lm.delete(key, "")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
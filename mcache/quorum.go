@@ -0,0 +1,98 @@
+package mcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/crypto/ocsp"
+
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+)
+
+// Quorum makes each refresh which fetches a new response confirm it
+// with a second response, fetched from another of the entry's
+// responders or, for entries with a single responder, through Client.
+// The new response is only used if both have the same status, in
+// which case the freshest of the two is used
+type Quorum struct {
+	// Client confirms the responses of entries with a single
+	// responder, for instance by sending requests through a different
+	// proxy, if it is nil their responses aren't confirmed
+	Client *http.Client
+}
+
+var statusNames = map[int]string{
+	ocsp.Good:    "good",
+	ocsp.Revoked: "revoked",
+	ocsp.Unknown: "unknown",
+}
+
+// ErrQuorumDisagreement is returned when the response confirming a
+// new response has a different status
+var ErrQuorumDisagreement = errors.New("responders disagree on the status of the certificate")
+
+// SetQuorum makes entries added after it is called confirm new
+// responses, see Quorum, a nil quorum disables confirmation
+func (c *EntryCache) SetQuorum(quorum *Quorum) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.quorum = quorum
+}
+
+// quorumSource returns the responders and client a response fetched
+// from responder is confirmed with, it returns false if there is no
+// second source to confirm it with
+func (e *Entry) quorumSource(responder string, client *http.Client) ([]string, *http.Client, bool) {
+	others := []string{}
+	for _, r := range e.responders {
+		if r != responder {
+			others = append(others, r)
+		}
+	}
+	if len(others) > 0 {
+		return others, client, true
+	}
+	if e.quorum.Client != nil {
+		return []string{responder}, e.quorum.Client, true
+	}
+	return nil, nil, false
+}
+
+// confirmResponse confirms result with a second response if the
+// entry uses a quorum, it returns the freshest of the two
+func (e *Entry) confirmResponse(ctx context.Context, client *http.Client, result *stapledOCSP.Result) (*stapledOCSP.Result, error) {
+	if e.quorum == nil {
+		return result, nil
+	}
+	sink := e.sink()
+	responders, client, ok := e.quorumSource(result.Responder, client)
+	if !ok {
+		e.info("Response from '%s' can't be confirmed, there is no other responder or quorum client", result.Responder)
+		sink.Counter("fetch.quorum.unconfirmed", 1)
+		return result, nil
+	}
+	confirming, err := e.fetchUncached(ctx, responders, client)
+	if err != nil {
+		sink.Counter("fetch.quorum.failures", 1)
+		return nil, fmt.Errorf("failed to confirm response from '%s': %w", result.Responder, err)
+	}
+	if confirming.Response.Status != result.Response.Status {
+		sink.Counter("fetch.quorum.disagreements", 1)
+		msg := fmt.Sprintf("'%s' returned %s but '%s' returned %s", result.Responder, statusNames[result.Response.Status], confirming.Responder, statusNames[confirming.Response.Status])
+		e.mu.Lock()
+		e.disagreement = msg
+		e.mu.Unlock()
+		e.err("Rejecting new response, %s", msg)
+		return nil, fmt.Errorf("%w: %s", ErrQuorumDisagreement, msg)
+	}
+	sink.Counter("fetch.quorum.confirmed", 1)
+	e.mu.Lock()
+	e.disagreement = ""
+	e.mu.Unlock()
+	if confirming.Response.ThisUpdate.After(result.Response.ThisUpdate) {
+		return confirming, nil
+	}
+	return result, nil
+}
@@ -0,0 +1,105 @@
+package mcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// countingTransport counts the requests sent through it
+type countingTransport struct {
+	requests int64
+}
+
+func (ct *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&ct.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestQuorum(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	fc, c, issuer := tf.fc, tf.c, tf.issuer
+	newResponse := func(status int, age time.Duration) []byte {
+		template := ocsp.Response{
+			SerialNumber: big.NewInt(1337),
+			Status:       status,
+			ThisUpdate:   fc.Now().Add(-age),
+			NextUpdate:   fc.Now().Add(96 * time.Hour),
+		}
+		if status == ocsp.Revoked {
+			template.RevokedAt = fc.Now().Add(-age)
+		}
+		return tf.sign(t, template)
+	}
+	older, fresher := newResponse(ocsp.Good, 2*time.Hour), newResponse(ocsp.Good, time.Hour)
+	a, b := &basicResponder{older}, &basicResponder{fresher}
+	srvA := httptest.NewServer(http.HandlerFunc(a.basicFetchHandler))
+	defer srvA.Close()
+	srvB := httptest.NewServer(http.HandlerFunc(b.basicFetchHandler))
+	defer srvB.Close()
+
+	c.SetQuorum(&Quorum{})
+	newEntry := func(responders ...string) *Entry {
+		e := c.newEntry()
+		e.name = "test"
+		e.serial = big.NewInt(1337)
+		e.issuer = issuer
+		e.responders = responders
+		if err := e.buildRequest(); err != nil {
+			t.Fatalf("Failed to build request: %s", err)
+		}
+		return e
+	}
+
+	// the freshest response is used when the responders agree, whichever
+	// is fetched from first
+	e := newEntry(srvA.URL, srvB.URL)
+	if err := e.fetchResponse(context.Background(), nil, c.client); err != nil {
+		t.Fatalf("fetchResponse failed: %s", err)
+	}
+	if !bytes.Equal(e.current().response, fresher) {
+		t.Fatal("Expected the fresher response to be used")
+	}
+
+	// a response the other responder disagrees with is rejected
+	a.response = newResponse(ocsp.Revoked, 0)
+	b.response = newResponse(ocsp.Good, 0)
+	if err := e.fetchResponse(context.Background(), nil, c.client); !errors.Is(err, ErrQuorumDisagreement) {
+		t.Fatalf("Expected ErrQuorumDisagreement, got: %v", err)
+	}
+	if !bytes.Equal(e.current().response, fresher) || e.Info().Disagreement == "" {
+		t.Fatal("Expected the current response to be kept and the disagreement reported")
+	}
+	a.response = b.response
+	if err := e.fetchResponse(context.Background(), nil, c.client); err != nil {
+		t.Fatalf("fetchResponse failed: %s", err)
+	}
+	if e.Info().Disagreement != "" {
+		t.Fatal("Disagreement wasn't cleared once the responders agreed")
+	}
+
+	// a entry with a single responder isn't confirmed without a client
+	e = newEntry(srvA.URL)
+	if err := e.fetchResponse(context.Background(), nil, c.client); err != nil || e.current().response == nil {
+		t.Fatalf("Expected a unconfirmed response to be used: %v", err)
+	}
+	// and is confirmed through the quorum client if there is one
+	ct := &countingTransport{}
+	c.SetQuorum(&Quorum{Client: &http.Client{Transport: ct}})
+	e = newEntry(srvA.URL)
+	if err := e.fetchResponse(context.Background(), nil, c.client); err != nil || e.current().response == nil {
+		t.Fatalf("fetchResponse failed: %v", err)
+	}
+	if atomic.LoadInt64(&ct.requests) != 1 {
+		t.Fatalf("Expected the response to be confirmed through the quorum client, got %d requests", ct.requests)
+	}
+}
@@ -0,0 +1,69 @@
+package mcache
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// ResponseNameData is the data a response name template is executed
+// with, values have any '/' replaced with '_' so that they can't add
+// path components
+type ResponseNameData struct {
+	Name     string // entry name
+	Serial   string // hex serial of the certificate
+	IssuerCN string // common name of the issuer, or its subject if it has none
+}
+
+// ParseResponseName parses a template for the name the response for
+// a entry is stored under in the stable backings, for example
+// "{{.IssuerCN}}/{{.Serial}}", see ResponseNameData
+func ParseResponseName(tmpl string) (*template.Template, error) {
+	t, err := template.New("response-name").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	// check the template only uses known fields
+	probe := &x509.Certificate{Subject: pkix.Name{CommonName: "issuer"}}
+	if _, err = renderResponseName(t, "name", big.NewInt(1), probe); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// renderResponseName executes t for a entry, the result must be a
+// relative path inside the stable backings
+func renderResponseName(t *template.Template, name string, serial *big.Int, issuer *x509.Certificate) (string, error) {
+	clean := func(s string) string {
+		return strings.Replace(s, "/", "_", -1)
+	}
+	data := ResponseNameData{
+		Name:   clean(name),
+		Serial: fmt.Sprintf("%x", serial),
+	}
+	if issuer != nil {
+		data.IssuerCN = issuer.Subject.CommonName
+		if data.IssuerCN == "" {
+			data.IssuerCN = issuer.Subject.String()
+		}
+		data.IssuerCN = clean(data.IssuerCN)
+	}
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, data); err != nil {
+		return "", err
+	}
+	rendered := buf.String()
+	switch cleaned := path.Clean(rendered); {
+	case rendered == "":
+		return "", errors.New("response name is empty")
+	case path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../"):
+		return "", fmt.Errorf("response name '%s' is outside of the stable backings", rendered)
+	}
+	return rendered, nil
+}
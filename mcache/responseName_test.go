@@ -0,0 +1,79 @@
+package mcache
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/scache"
+)
+
+func TestRenderResponseName(t *testing.T) {
+	issuer := &x509.Certificate{Subject: pkix.Name{CommonName: "Example/CA"}}
+	for _, test := range []struct {
+		tmpl     string
+		expected string
+		err      bool
+	}{
+		{"{{.Name}}", "site", false},
+		{"{{.IssuerCN}}/{{.Serial}}", "Example_CA/539", false},
+		{"out-{{.Name}}-{{.Serial}}", "out-site-539", false},
+		{"../{{.Name}}", "", true},
+		{"/{{.Name}}", "", true},
+		{"{{.Missing}}", "", true},
+		{"{{.Name", "", true},
+	} {
+		tmpl, err := ParseResponseName(test.tmpl)
+		var rendered string
+		if err == nil {
+			rendered, err = renderResponseName(tmpl, "site", big.NewInt(1337), issuer)
+		}
+		if (err != nil) != test.err {
+			t.Fatalf("Unexpected error for '%s': %v", test.tmpl, err)
+		}
+		if rendered != test.expected {
+			t.Fatalf("Expected '%s' to render '%s', got '%s'", test.tmpl, test.expected, rendered)
+		}
+	}
+}
+
+func TestResponseName(t *testing.T) {
+	fc := clock.NewFake()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(10), Subject: pkix.Name{CommonName: "issuer"}}
+	issuerDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	issuer, err := x509.ParseCertificate(issuerDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+
+	stable := &memStable{resp: &ocsp.Response{NextUpdate: fc.Now().Add(time.Hour)}, respBytes: []byte{1}}
+	c := NewEntryCache(fc, log.NewLogger("", "", 10, fc), time.Minute, []scache.Cache{stable}, nil, time.Second, nil, config.SupportedHashes{crypto.SHA1}, true)
+	c.SetNoResponderPolicy(WarnNoResponders)
+	tmpl, err := ParseResponseName("{{.IssuerCN}}/{{.Serial}}")
+	if err != nil {
+		t.Fatalf("ParseResponseName failed: %s", err)
+	}
+	if err = c.AddCertificate("site", issuer, CertificateOptions{Issuer: issuer, ResponseName: tmpl}); err != nil {
+		t.Fatalf("AddCertificate failed: %s", err)
+	}
+	if stable.lastRead != "issuer/a" {
+		t.Fatalf("Expected response to be read from 'issuer/a', got '%s'", stable.lastRead)
+	}
+}
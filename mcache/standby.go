@@ -0,0 +1,76 @@
+package mcache
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+)
+
+// ErrStandby is returned instead of fetching a response from upstream
+// while the cache is a standby which hasn't been promoted
+var ErrStandby = errors.New("instance is a standby, responses aren't fetched from upstream until it is promoted")
+
+// standby is shared by the entries of a cache in standby mode, see
+// EntryCache.SetStandby
+type standby struct {
+	promoted int32 // accessed atomically
+}
+
+// fetches checks if entries may fetch responses from upstream, which
+// they always may unless the cache is a standby
+func (sb *standby) fetches() bool {
+	return sb == nil || atomic.LoadInt32(&sb.promoted) == 1
+}
+
+// SetStandby makes the cache the warm standby of a active instance.
+// Entries added after it is called never fetch responses from
+// upstream, when they are added, refreshed, or boosted, until Promote
+// is called. Instead they load the responses the active instance
+// writes to shared stable backings when they need refreshing, and
+// responses can be loaded from the active instance using Import
+func (c *EntryCache) SetStandby() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.standby = &standby{}
+}
+
+// Standby checks if the cache is a standby which hasn't been promoted
+func (c *EntryCache) Standby() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.standby.fetches()
+}
+
+// Promote takes over refreshing responses from the active instance,
+// entries which need refreshing are refreshed straight away in the
+// background. It returns false if the cache isn't a standby or has
+// already been promoted
+func (c *EntryCache) Promote() bool {
+	c.mu.RLock()
+	sb := c.standby
+	c.mu.RUnlock()
+	if sb == nil || !atomic.CompareAndSwapInt32(&sb.promoted, 0, 1) {
+		return false
+	}
+	c.log.Info("[cache] Promoted from standby, refreshing responses from upstream")
+	go c.refreshAll()
+	return true
+}
+
+// Responses returns the current response of every entry which has
+// one, sorted by entry name, Fingerprint is zero for entries which
+// weren't created from a certificate
+func (c *EntryCache) Responses() []Staple {
+	c.mu.RLock()
+	responses := make([]Staple, 0, len(c.entries))
+	for _, e := range c.entries {
+		if response := e.current().response; response != nil {
+			e.mu.RLock()
+			responses = append(responses, Staple{e.name, e.fingerprint, response})
+			e.mu.RUnlock()
+		}
+	}
+	c.mu.RUnlock()
+	sort.Slice(responses, func(i, j int) bool { return responses[i].Name < responses[j].Name })
+	return responses
+}
@@ -0,0 +1,54 @@
+package mcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStandby(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	c, response := tf.c, tf.response
+	if c.Standby() || c.Promote() {
+		t.Fatal("Cache is a standby before SetStandby was called")
+	}
+	c.SetStandby()
+	e := tf.addEntry(t, "a")
+	if err := c.Refresh("a"); err != ErrStandby {
+		t.Fatalf("Expected ErrStandby refreshing a entry, got %v", err)
+	}
+	c.refreshAll()
+	if requests := tf.upstreamRequests(); requests != 0 || !c.Standby() {
+		t.Fatalf("Standby fetched %d responses from upstream", requests)
+	}
+	if names, err := c.Import(response); err != nil || len(names) != 1 {
+		t.Fatalf("Failed to import response into the standby: %v %s", names, err)
+	}
+	if names, err := c.ImportNewer(response); err != ErrNotNewer || len(names) != 0 {
+		t.Fatalf("Expected ErrNotNewer importing the current response again, got: %v %v", names, err)
+	}
+	if responses := c.Responses(); len(responses) != 1 || responses[0].Name != "a" {
+		t.Fatalf("Unexpected responses: %v", responses)
+	}
+
+	// once promoted entries which need refreshing are refreshed from
+	// upstream
+	e.mu.Lock()
+	e.state.Store(&responseState{})
+	e.mu.Unlock()
+	if !c.Promote() || c.Standby() {
+		t.Fatal("Failed to promote the standby")
+	}
+	if c.Promote() {
+		t.Fatal("Standby was promoted twice")
+	}
+	for i := 0; tf.upstreamRequests() != 1; i++ {
+		if i == 100 {
+			t.Fatal("Promoted standby didn't refresh its entries")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.Refresh("a"); err != nil {
+		t.Fatalf("Refreshing a entry of the promoted standby failed: %s", err)
+	}
+}
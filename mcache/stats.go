@@ -0,0 +1,83 @@
+package mcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// fetchCounters counts the upstream fetches made by the entries in a
+// EntryCache, it is shared between all of them
+type fetchCounters struct {
+	refreshes int64
+	failures  int64
+	skipped   int64
+	bytesRead int64
+	unknowns  int64
+}
+
+func (fc *fetchCounters) record(err error) {
+	if fc == nil {
+		return
+	}
+	if err != nil {
+		atomic.AddInt64(&fc.failures, 1)
+		return
+	}
+	atomic.AddInt64(&fc.refreshes, 1)
+}
+
+func (fc *fetchCounters) skip() {
+	if fc == nil {
+		return
+	}
+	atomic.AddInt64(&fc.skipped, 1)
+}
+
+func (fc *fetchCounters) unknown() {
+	if fc == nil {
+		return
+	}
+	atomic.AddInt64(&fc.unknowns, 1)
+}
+
+func (fc *fetchCounters) addBytes(n int) {
+	if fc == nil {
+		return
+	}
+	atomic.AddInt64(&fc.bytesRead, int64(n))
+}
+
+// CacheStats summarizes the state of a EntryCache, the counters are
+// totals since it was created
+type CacheStats struct {
+	Entries         int
+	Refreshes       int64 // upstream fetches that succeeded
+	RefreshFailures int64 // upstream fetches that failed
+	RefreshSkipped  int64 // refreshes skipped because one was already running
+	UpstreamBytes   int64 // response bodies read from upstream responders
+	Unknown         int64 // fetched responses with the status Unknown
+	NearExpiry      int   // entries whose response expires within the window passed to Stats
+	NoResponse      int   // entries without a response
+}
+
+// Stats returns the current stats, responses that expire before
+// expiryWindow has passed are counted as near expiry
+func (c *EntryCache) Stats(expiryWindow time.Duration) CacheStats {
+	stats := CacheStats{
+		Refreshes:       atomic.LoadInt64(&c.counters.refreshes),
+		RefreshFailures: atomic.LoadInt64(&c.counters.failures),
+		RefreshSkipped:  atomic.LoadInt64(&c.counters.skipped),
+		UpstreamBytes:   atomic.LoadInt64(&c.counters.bytesRead),
+		Unknown:         atomic.LoadInt64(&c.counters.unknowns),
+	}
+	cutoff := c.clk.Now().Add(expiryWindow)
+	for _, info := range c.Entries() {
+		stats.Entries++
+		if info.ThisUpdate.IsZero() {
+			stats.NoResponse++
+		} else if info.NextUpdate.Before(cutoff) {
+			stats.NearExpiry++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,13 @@
+//go:build go1.19
+// +build go1.19
+
+package stapled
+
+import "runtime/debug"
+
+// setMemoryLimit sets the soft memory limit the garbage collector
+// tries to stay under, it reports whether the limit could be set
+func setMemoryLimit(limit int64) bool {
+	debug.SetMemoryLimit(limit)
+	return true
+}
@@ -0,0 +1,10 @@
+//go:build !go1.19
+// +build !go1.19
+
+package stapled
+
+// setMemoryLimit does nothing since the soft memory limit was added
+// in Go 1.19
+func setMemoryLimit(limit int64) bool {
+	return false
+}
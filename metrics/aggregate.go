@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TimingStats summarizes the timings recorded for a name
+type TimingStats struct {
+	Count int64
+	Sum   time.Duration
+	Max   time.Duration
+}
+
+// Snapshot is a copy of the values held by a Aggregate
+type Snapshot struct {
+	Counters map[string]int64
+	Gauges   map[string]float64
+	Timings  map[string]TimingStats
+}
+
+// names returns the sorted names of every metric in the snapshot
+func (s Snapshot) names() []string {
+	seen := make(map[string]bool)
+	for name := range s.Counters {
+		seen[name] = true
+	}
+	for name := range s.Gauges {
+		seen[name] = true
+	}
+	for name := range s.Timings {
+		seen[name] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Aggregate is a Sink that keeps the current value of each metric in
+// memory, the Prometheus and LogDump sinks are built on it
+type Aggregate struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+	timings  map[string]TimingStats
+}
+
+// NewAggregate creates a empty Aggregate
+func NewAggregate() *Aggregate {
+	return &Aggregate{
+		counters: make(map[string]int64),
+		gauges:   make(map[string]float64),
+		timings:  make(map[string]TimingStats),
+	}
+}
+
+// Counter implements Sink
+func (a *Aggregate) Counter(name string, delta int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.counters[name] += delta
+}
+
+// Gauge implements Sink
+func (a *Aggregate) Gauge(name string, value float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.gauges[name] = value
+}
+
+// Timing implements Sink
+func (a *Aggregate) Timing(name string, d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ts := a.timings[name]
+	ts.Count++
+	ts.Sum += d
+	if d > ts.Max {
+		ts.Max = d
+	}
+	a.timings[name] = ts
+}
+
+// Snapshot returns a copy of the current values
+func (a *Aggregate) Snapshot() Snapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := Snapshot{
+		Counters: make(map[string]int64, len(a.counters)),
+		Gauges:   make(map[string]float64, len(a.gauges)),
+		Timings:  make(map[string]TimingStats, len(a.timings)),
+	}
+	for name, v := range a.counters {
+		s.Counters[name] = v
+	}
+	for name, v := range a.gauges {
+		s.Gauges[name] = v
+	}
+	for name, v := range a.timings {
+		s.Timings[name] = v
+	}
+	return s
+}
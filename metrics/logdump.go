@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+// LogDump is a Sink which logs the current value of every metric when
+// Dump is called, for deployments without a metrics system
+type LogDump struct {
+	*Aggregate
+	log *log.Logger
+}
+
+// NewLogDump creates a LogDump which logs to logger
+func NewLogDump(logger *log.Logger) *LogDump {
+	return &LogDump{Aggregate: NewAggregate(), log: logger}
+}
+
+// Dump logs the current value of every metric on a single line,
+// timings are logged as their count and average
+func (ld *LogDump) Dump() {
+	snap := ld.Snapshot()
+	names := snap.names()
+	if len(names) == 0 {
+		return
+	}
+	fields := make([]string, 0, len(names))
+	for _, name := range names {
+		if v, ok := snap.Counters[name]; ok {
+			fields = append(fields, fmt.Sprintf("%s=%d", name, v))
+		}
+		if v, ok := snap.Gauges[name]; ok {
+			fields = append(fields, fmt.Sprintf("%s=%g", name, v))
+		}
+		if ts, ok := snap.Timings[name]; ok && ts.Count > 0 {
+			fields = append(fields, fmt.Sprintf("%s.count=%d %s.avg=%s", name, ts.Count, name, ts.Sum/time.Duration(ts.Count)))
+		}
+	}
+	ld.log.Info("[metrics] %s", strings.Join(fields, " "))
+}
+
+// Run calls Dump every interval until stop is closed
+func (ld *LogDump) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ld.Dump()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Package metrics defines the interface stapled reports metrics
+// through, and sinks which aggregate them for Prometheus, send them to
+// StatsD, or periodically log them, so that embedders can bridge them
+// to their own telemetry
+package metrics
+
+import "time"
+
+// Sink receives metrics, names are dot separated, for example
+// "fetch.failures". Implementations must be safe for concurrent use
+type Sink interface {
+	// Counter adds delta to a counter
+	Counter(name string, delta int64)
+	// Gauge sets the current value of a gauge
+	Gauge(name string, value float64)
+	// Timing records how long a operation took
+	Timing(name string, d time.Duration)
+}
+
+type discard struct{}
+
+func (discard) Counter(string, int64)        {}
+func (discard) Gauge(string, float64)        {}
+func (discard) Timing(string, time.Duration) {}
+
+// Discard is a Sink that drops every metric
+var Discard Sink = discard{}
+
+type multi []Sink
+
+func (m multi) Counter(name string, delta int64) {
+	for _, s := range m {
+		s.Counter(name, delta)
+	}
+}
+
+func (m multi) Gauge(name string, value float64) {
+	for _, s := range m {
+		s.Gauge(name, value)
+	}
+}
+
+func (m multi) Timing(name string, d time.Duration) {
+	for _, s := range m {
+		s.Timing(name, d)
+	}
+}
+
+// Multi returns a Sink that sends metrics to each of sinks, Discard
+// if there are none
+func Multi(sinks ...Sink) Sink {
+	switch len(sinks) {
+	case 0:
+		return Discard
+	case 1:
+		return sinks[0]
+	}
+	return multi(sinks)
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggregate(t *testing.T) {
+	a := NewAggregate()
+	sink := Multi(a, Discard)
+	sink.Counter("fetch.refreshes", 1)
+	sink.Counter("fetch.refreshes", 2)
+	sink.Gauge("cache.entries", 5)
+	sink.Gauge("cache.entries", 3)
+	sink.Timing("fetch.duration", time.Second)
+	sink.Timing("fetch.duration", 3*time.Second)
+
+	snap := a.Snapshot()
+	if v := snap.Counters["fetch.refreshes"]; v != 3 {
+		t.Fatalf("Expected counter to be 3, got %d", v)
+	}
+	if v := snap.Gauges["cache.entries"]; v != 3 {
+		t.Fatalf("Expected gauge to be 3, got %g", v)
+	}
+	if ts := snap.Timings["fetch.duration"]; ts.Count != 2 || ts.Sum != 4*time.Second || ts.Max != 3*time.Second {
+		t.Fatalf("Unexpected timing stats: %+v", ts)
+	}
+	a.Counter("fetch.refreshes", 1)
+	if v := snap.Counters["fetch.refreshes"]; v != 3 {
+		t.Fatal("Snapshot was modified by a later update")
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus("stapled")
+	p.Counter("fetch.upstream-bytes", 10)
+	p.Gauge("cache.entries", 2)
+	p.Timing("responder.duration", 500*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/prometheus", nil))
+	expected := strings.Join([]string{
+		"# TYPE stapled_cache_entries gauge",
+		"stapled_cache_entries 2",
+		"# TYPE stapled_fetch_upstream_bytes_total counter",
+		"stapled_fetch_upstream_bytes_total 10",
+		"# TYPE stapled_responder_duration_seconds summary",
+		"stapled_responder_duration_seconds_sum 0.5",
+		"stapled_responder_duration_seconds_count 1",
+		"",
+	}, "\n")
+	if body := w.Body.String(); body != expected {
+		t.Fatalf("Unexpected exposition, expected:\n%s\ngot:\n%s", expected, body)
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+	sd, err := NewStatsD(conn.LocalAddr().String(), "stapled")
+	if err != nil {
+		t.Fatalf("NewStatsD failed: %s", err)
+	}
+	defer sd.Close()
+
+	sd.Counter("fetch.failures", 2)
+	sd.Gauge("cache.entries", 1.5)
+	sd.Timing("fetch.duration", 1500*time.Microsecond)
+	buf := make([]byte, 512)
+	for _, expected := range []string{
+		"stapled.fetch.failures:2|c",
+		"stapled.cache.entries:1.5|g",
+		"stapled.fetch.duration:1.5|ms",
+	} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Failed to read packet: %s", err)
+		}
+		if got := string(buf[:n]); got != expected {
+			t.Fatalf("Expected '%s', got '%s'", expected, got)
+		}
+	}
+}
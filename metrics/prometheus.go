@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Prometheus is a Sink which serves the current value of each metric
+// in the Prometheus text exposition format. Counters are exposed with
+// a _total suffix and timings as summaries in seconds
+type Prometheus struct {
+	*Aggregate
+	namespace string
+}
+
+// NewPrometheus creates a Prometheus sink, namespace is prefixed to
+// every metric name
+func NewPrometheus(namespace string) *Prometheus {
+	return &Prometheus{Aggregate: NewAggregate(), namespace: namespace}
+}
+
+// promName converts a dot separated metric name to a Prometheus one
+func (p *Prometheus) promName(name string) string {
+	if p.namespace != "" {
+		name = p.namespace + "_" + name
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// ServeHTTP writes the current values
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snap := p.Snapshot()
+	buf := new(bytes.Buffer)
+	for _, name := range snap.names() {
+		if v, ok := snap.Counters[name]; ok {
+			n := p.promName(name) + "_total"
+			fmt.Fprintf(buf, "# TYPE %s counter\n%s %d\n", n, n, v)
+		}
+		if v, ok := snap.Gauges[name]; ok {
+			n := p.promName(name)
+			fmt.Fprintf(buf, "# TYPE %s gauge\n%s %g\n", n, n, v)
+		}
+		if ts, ok := snap.Timings[name]; ok {
+			n := p.promName(name) + "_seconds"
+			fmt.Fprintf(buf, "# TYPE %s summary\n%s_sum %g\n%s_count %d\n", n, n, ts.Sum.Seconds(), n, ts.Count)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
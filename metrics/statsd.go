@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// StatsD is a Sink which sends each metric to a StatsD server over
+// UDP as it is reported, metrics that can't be sent are dropped
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsD creates a StatsD sink sending to addr, prefix and a '.'
+// are prepended to every metric name if prefix isn't empty
+func NewStatsD(addr, prefix string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsD{conn: conn, prefix: prefix}, nil
+}
+
+func (sd *StatsD) send(name, value, kind string) {
+	// errors are ignored, there is nothing to do about a lost packet
+	fmt.Fprintf(sd.conn, "%s%s:%s|%s", sd.prefix, name, value, kind)
+}
+
+// Counter implements Sink
+func (sd *StatsD) Counter(name string, delta int64) {
+	sd.send(name, fmt.Sprint(delta), "c")
+}
+
+// Gauge implements Sink
+func (sd *StatsD) Gauge(name string, value float64) {
+	sd.send(name, fmt.Sprint(value), "g")
+}
+
+// Timing implements Sink, durations are sent in milliseconds
+func (sd *StatsD) Timing(name string, d time.Duration) {
+	sd.send(name, fmt.Sprintf("%g", float64(d)/float64(time.Millisecond)), "ms")
+}
+
+// Close closes the connection to the StatsD server
+func (sd *StatsD) Close() error {
+	return sd.conn.Close()
+}
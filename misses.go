@@ -0,0 +1,170 @@
+package stapled
+
+import (
+	"container/list"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// MissLog records requests for certificates whose issuer stapled
+// doesn't know, so operators running stapled in front of varied
+// traffic can find the issuers and certificates worth adding
+type MissLog struct {
+	Enabled bool
+	// MaxRequests is how many distinct requests are remembered, the
+	// least recently seen is forgotten once there are more
+	MaxRequests int
+}
+
+const defaultMissLogRequests = 1000
+
+// WithMissLog configures the log of requests for unknown issuers
+// served on the admin listener at /misses
+func WithMissLog(ml MissLog) Option {
+	return func(s *Server) error {
+		if ml.MaxRequests < 0 {
+			return errors.New("miss log max requests must not be negative")
+		}
+		if !ml.Enabled {
+			return nil
+		}
+		if ml.MaxRequests == 0 {
+			ml.MaxRequests = defaultMissLogRequests
+		}
+		s.missLog = newMissLog(ml.MaxRequests)
+		return nil
+	}
+}
+
+// missedRequest is a distinct request, by issuer and serial, for a
+// certificate whose issuer isn't known
+type missedRequest struct {
+	HashAlgorithm  string    `json:"hashAlgorithm"`
+	IssuerNameHash string    `json:"issuerNameHash"`
+	IssuerKeyHash  string    `json:"issuerKeyHash"`
+	Serial         string    `json:"serial"`
+	Requests       uint64    `json:"requests"`
+	FirstSeen      time.Time `json:"firstSeen"`
+	LastSeen       time.Time `json:"lastSeen"`
+}
+
+// missedIssuer summarizes the remembered requests for a issuer
+type missedIssuer struct {
+	HashAlgorithm  string    `json:"hashAlgorithm"`
+	IssuerNameHash string    `json:"issuerNameHash"`
+	IssuerKeyHash  string    `json:"issuerKeyHash"`
+	Requests       uint64    `json:"requests"`
+	Serials        int       `json:"serials"`
+	LastSeen       time.Time `json:"lastSeen"`
+}
+
+// missReport is the body of /misses
+type missReport struct {
+	Issuers  []missedIssuer  `json:"issuers"`  // most requests first
+	Requests []missedRequest `json:"requests"` // most requests first
+}
+
+// missLog remembers up to maxRequests missed requests, forgetting the
+// least recently seen first
+type missLog struct {
+	maxRequests int
+
+	mu       sync.Mutex
+	requests map[string]*list.Element
+	lru      *list.List // of *missedRequest, most recently seen first
+}
+
+func newMissLog(maxRequests int) *missLog {
+	return &missLog{
+		maxRequests: maxRequests,
+		requests:    make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// record records a request for a certificate with a unknown issuer
+func (ml *missLog) record(req *ocsp.Request, now time.Time) {
+	if ml == nil {
+		return
+	}
+	nameHash, keyHash := hex.EncodeToString(req.IssuerNameHash), hex.EncodeToString(req.IssuerKeyHash)
+	serial := req.SerialNumber.Text(16)
+	key := nameHash + "/" + keyHash + "/" + serial
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	if elem, present := ml.requests[key]; present {
+		ml.lru.MoveToFront(elem)
+		mr := elem.Value.(*missedRequest)
+		mr.Requests++
+		mr.LastSeen = now
+		return
+	}
+	ml.requests[key] = ml.lru.PushFront(&missedRequest{
+		HashAlgorithm:  req.HashAlgorithm.String(),
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  keyHash,
+		Serial:         serial,
+		Requests:       1,
+		FirstSeen:      now,
+		LastSeen:       now,
+	})
+	if ml.lru.Len() > ml.maxRequests {
+		oldest := ml.lru.Back()
+		ml.lru.Remove(oldest)
+		mr := oldest.Value.(*missedRequest)
+		delete(ml.requests, mr.IssuerNameHash+"/"+mr.IssuerKeyHash+"/"+mr.Serial)
+	}
+}
+
+// report returns the remembered requests and a summary of them for
+// each issuer
+func (ml *missLog) report() missReport {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	report := missReport{Issuers: []missedIssuer{}, Requests: make([]missedRequest, 0, ml.lru.Len())}
+	issuers := map[string]int{}
+	for elem := ml.lru.Front(); elem != nil; elem = elem.Next() {
+		mr := elem.Value.(*missedRequest)
+		report.Requests = append(report.Requests, *mr)
+		key := mr.IssuerNameHash + "/" + mr.IssuerKeyHash
+		i, present := issuers[key]
+		if !present {
+			// the list is most recently seen first, so the first
+			// request for a issuer is the last one seen
+			i = len(report.Issuers)
+			issuers[key] = i
+			report.Issuers = append(report.Issuers, missedIssuer{
+				HashAlgorithm:  mr.HashAlgorithm,
+				IssuerNameHash: mr.IssuerNameHash,
+				IssuerKeyHash:  mr.IssuerKeyHash,
+				LastSeen:       mr.LastSeen,
+			})
+		}
+		report.Issuers[i].Requests += mr.Requests
+		report.Issuers[i].Serials++
+	}
+	sort.SliceStable(report.Issuers, func(i, j int) bool { return report.Issuers[i].Requests > report.Issuers[j].Requests })
+	sort.SliceStable(report.Requests, func(i, j int) bool { return report.Requests[i].Requests > report.Requests[j].Requests })
+	return report
+}
+
+// missesHandler serves the requests seen for certificates with unknown
+// issuers at /misses
+func (s *Server) missesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.missLog == nil {
+		http.Error(w, "miss log isn't enabled", http.StatusNotImplemented)
+		return
+	}
+	s.writeJSON(w, r, s.missLog.report())
+}
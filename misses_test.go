@@ -0,0 +1,73 @@
+package stapled
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestMissLog(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	if err := WithMissLog(MissLog{Enabled: true, MaxRequests: 2})(tf.s); err != nil {
+		t.Fatalf("WithMissLog failed: %s", err)
+	}
+	parsed, err := ocsp.ParseRequest(tf.request)
+	if err != nil {
+		t.Fatalf("Failed to parse request: %s", err)
+	}
+	send := func(keyHash []byte, serial int64) {
+		req := *parsed
+		req.IssuerKeyHash = keyHash
+		req.SerialNumber = big.NewInt(serial)
+		der, err := req.Marshal()
+		if err != nil {
+			t.Fatalf("Failed to marshal request: %s", err)
+		}
+		tf.s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/"+base64.StdEncoding.EncodeToString(der), nil))
+	}
+	unknown := bytes.Repeat([]byte{1}, len(parsed.IssuerKeyHash))
+	send(unknown, 1)
+	send(unknown, 1)
+	send(unknown, 2)
+	// a serial without a response from a known issuer isn't a miss
+	send(parsed.IssuerKeyHash, 3)
+	// nor is a request answered from the cache
+	tf.get(nil)
+	// requests for unknown issuers are also recorded when responses
+	// are fetched from upstream
+	tf.s.upstreamResponders = []string{tf.upstream.URL}
+	send(unknown, 1)
+
+	w := httptest.NewRecorder()
+	tf.s.missesHandler(w, httptest.NewRequest("GET", "/misses", nil))
+	var report missReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to parse /misses: %s", err)
+	}
+	if len(report.Requests) != 2 || report.Requests[0].Serial != "1" || report.Requests[0].Requests != 3 || report.Requests[0].HashAlgorithm != "SHA-1" {
+		t.Fatalf("Unexpected missed requests: %+v", report.Requests)
+	}
+	if len(report.Issuers) != 1 || report.Issuers[0].Requests != 4 || report.Issuers[0].Serials != 2 {
+		t.Fatalf("Unexpected missed issuers: %+v", report.Issuers)
+	}
+
+	// the least recently seen request is forgotten
+	send(unknown, 4)
+	if report = tf.s.missLog.report(); len(report.Requests) != 2 || report.Requests[0].Serial != "1" || report.Requests[1].Serial != "4" {
+		t.Fatalf("Expected the least recently seen request to be forgotten, got %+v", report.Requests)
+	}
+
+	tf.s.missLog = nil
+	w = httptest.NewRecorder()
+	tf.s.missesHandler(w, httptest.NewRequest("GET", "/misses", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("Expected 501 with the miss log disabled, got %d", w.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package stapled
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/mcache"
+	"github.com/rolandshoemaker/stapled/notify"
+)
+
+// NotifyThresholds controls when events are generated for entries,
+// a zero threshold disables the related event
+type NotifyThresholds struct {
+	RefreshFailingAfter time.Duration
+	CertExpiringWithin  time.Duration
+}
+
+// WithNotifications checks the cache for problems every interval and
+// sends events describing them to d
+func WithNotifications(d *notify.Dispatcher, interval time.Duration, thresholds NotifyThresholds) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("notification interval must be positive")
+		}
+		s.notifier = d
+		s.notifyInterval = interval
+		s.notifyThresholds = thresholds
+		return nil
+	}
+}
+
+// notificationEvents returns the events that are active for infos
+func notificationEvents(now time.Time, infos []mcache.EntryInfo, thresholds NotifyThresholds) []notify.Event {
+	events := []notify.Event{}
+	event := func(kind notify.Kind, subject, msg string, args ...interface{}) {
+		events = append(events, notify.Event{
+			Kind:    kind,
+			Subject: subject,
+			Message: fmt.Sprintf(msg, args...),
+			Time:    now,
+		})
+	}
+
+	// responder -> whether every entry using it is failing
+	responderDown := map[string]bool{}
+	for _, info := range infos {
+		failing := !info.FailingSince.IsZero()
+		for _, responder := range info.Responders {
+			down, present := responderDown[responder]
+			responderDown[responder] = failing && (down || !present)
+		}
+		if failing && thresholds.RefreshFailingAfter > 0 {
+			if failingFor := now.Sub(info.FailingSince); failingFor >= thresholds.RefreshFailingAfter {
+				event(notify.RefreshFailing, info.Name, "refreshing '%s' has been failing for %s: %s", info.Name, common.HumanDuration(failingFor), info.LastError)
+			}
+		}
+		if info.Status == ocsp.Revoked && !info.ThisUpdate.IsZero() {
+			event(notify.Revoked, info.Name, "certificate for '%s' (serial %X) has been revoked", info.Name, info.Serial)
+		}
+		if info.Status == ocsp.Unknown && !info.ThisUpdate.IsZero() && info.UnknownPolicy == mcache.AlertUnknown {
+			event(notify.UnknownStatus, info.Name, "responder for '%s' (serial %X) returned the status unknown", info.Name, info.Serial)
+		}
+		if info.Disagreement != "" {
+			event(notify.QuorumDisagreement, info.Name, "responders for '%s' (serial %X) disagree on its status, %s", info.Name, info.Serial, info.Disagreement)
+		}
+		if !info.NotAfter.IsZero() && thresholds.CertExpiringWithin > 0 {
+			if remaining := info.NotAfter.Sub(now); remaining <= thresholds.CertExpiringWithin {
+				if remaining > 0 {
+					event(notify.CertExpiring, info.Name, "certificate for '%s' expires in %s", info.Name, common.HumanDuration(remaining))
+				} else {
+					event(notify.CertExpiring, info.Name, "certificate for '%s' has expired", info.Name)
+				}
+			}
+		}
+	}
+	responders := []string{}
+	for responder, down := range responderDown {
+		if down {
+			responders = append(responders, responder)
+		}
+	}
+	sort.Strings(responders)
+	for _, responder := range responders {
+		event(notify.ResponderDown, responder, "every entry using responder '%s' is failing to refresh", responder)
+	}
+	return events
+}
+
+func (s *Server) checkNotifications() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.notifyInterval)
+	defer cancel()
+	now := s.clk.Now()
+	events := notificationEvents(now, s.c.Entries(), s.notifyThresholds)
+	s.notifier.Update(ctx, append(events, s.gameDayEvents(now)...))
+}
+
+func (s *Server) watchNotifications() {
+	ticker := time.NewTicker(s.notifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.checkNotifications()
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package stapled
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/mcache"
+	"github.com/rolandshoemaker/stapled/notify"
+)
+
+func TestNotificationEvents(t *testing.T) {
+	now := time.Now()
+	thresholds := NotifyThresholds{
+		RefreshFailingAfter: time.Hour,
+		CertExpiringWithin:  24 * time.Hour,
+	}
+	infos := []mcache.EntryInfo{
+		{
+			Name:       "healthy",
+			Serial:     big.NewInt(1),
+			Status:     ocsp.Good,
+			ThisUpdate: now,
+			Responders: []string{"http://a", "http://b"},
+			NotAfter:   now.Add(30 * 24 * time.Hour),
+		},
+		{
+			Name:         "failing",
+			Serial:       big.NewInt(2),
+			Status:       ocsp.Good,
+			ThisUpdate:   now,
+			Responders:   []string{"http://b"},
+			FailingSince: now.Add(-2 * time.Hour),
+			LastError:    "broken",
+		},
+		{
+			Name:         "recently-failing",
+			Serial:       big.NewInt(3),
+			Status:       ocsp.Good,
+			ThisUpdate:   now,
+			Responders:   []string{"http://b", "http://c"},
+			FailingSince: now.Add(-time.Minute),
+		},
+		{
+			Name:       "revoked",
+			Serial:     big.NewInt(4),
+			Status:     ocsp.Revoked,
+			ThisUpdate: now,
+			NotAfter:   now.Add(time.Hour),
+		},
+		{
+			Name:          "unknown",
+			Serial:        big.NewInt(5),
+			Status:        ocsp.Unknown,
+			ThisUpdate:    now,
+			UnknownPolicy: mcache.AlertUnknown,
+		},
+		{
+			Name:       "unknown-served",
+			Serial:     big.NewInt(6),
+			Status:     ocsp.Unknown,
+			ThisUpdate: now,
+		},
+		{
+			Name:         "disagreement",
+			Serial:       big.NewInt(7),
+			Disagreement: "'http://a' returned good but 'http://b' returned revoked",
+		},
+	}
+
+	got := map[string]bool{}
+	for _, e := range notificationEvents(now, infos, thresholds) {
+		got[string(e.Kind)+" "+e.Subject] = true
+	}
+	expected := []string{
+		string(notify.RefreshFailing) + " failing",
+		string(notify.Revoked) + " revoked",
+		string(notify.CertExpiring) + " revoked",
+		string(notify.ResponderDown) + " http://c",
+		string(notify.UnknownStatus) + " unknown",
+		string(notify.QuorumDisagreement) + " disagreement",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %v", len(expected), len(got), got)
+	}
+	for _, e := range expected {
+		if !got[e] {
+			t.Fatalf("Missing event '%s', got %v", e, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// PagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Webhook POSTs events as JSON objects to a URL
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+type webhookEvent struct {
+	Kind    Kind      `json:"kind"`
+	Subject string    `json:"subject"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Notify implements Notifier
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, w.Client, w.URL, webhookEvent{e.Kind, e.Subject, e.Message, e.Time})
+}
+
+// Slack sends events to a Slack incoming webhook
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify implements Notifier
+func (s *Slack) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, s.Client, s.URL, map[string]string{"text": e.Summary()})
+}
+
+// PagerDuty triggers incidents using the PagerDuty Events API v2,
+// events for the same kind and subject share a dedup key so they
+// are grouped into a single incident
+type PagerDuty struct {
+	RoutingKey string
+	URL        string // defaults to PagerDutyEventsURL
+	Client     *http.Client
+}
+
+type pagerDutyPayload struct {
+	Summary   string `json:"summary"`
+	Source    string `json:"source"`
+	Severity  string `json:"severity"`
+	Timestamp string `json:"timestamp"`
+	Class     string `json:"class"`
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+var pagerDutySeverities = map[Kind]string{
+	RefreshFailing: "error",
+	Revoked:        "critical",
+	ResponderDown:  "error",
+	CertExpiring:   "warning",
+}
+
+// Notify implements Notifier
+func (pd *PagerDuty) Notify(ctx context.Context, e Event) error {
+	url := pd.URL
+	if url == "" {
+		url = PagerDutyEventsURL
+	}
+	source, err := os.Hostname()
+	if err != nil {
+		source = "stapled"
+	}
+	return postJSON(ctx, pd.Client, url, pagerDutyEvent{
+		RoutingKey:  pd.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    "stapled " + e.key(),
+		Payload: pagerDutyPayload{
+			Summary:   e.Summary(),
+			Source:    source,
+			Severity:  pagerDutySeverities[e.Kind],
+			Timestamp: e.Time.UTC().Format(time.RFC3339),
+			Class:     string(e.Kind),
+		},
+	})
+}
@@ -0,0 +1,181 @@
+// Package notify sends notifications about problems with the cache
+// to external alerting systems, deduplicating and throttling them so
+// that a persistent problem doesn't page someone every minute
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+// Kind is the type of a Event
+type Kind string
+
+// Event kinds
+const (
+	// RefreshFailing is sent when a entry has failed to refresh for
+	// longer than the configured threshold
+	RefreshFailing Kind = "refresh-failing"
+	// Revoked is sent when the response for a entry has the revoked
+	// status
+	Revoked Kind = "revoked"
+	// ResponderDown is sent when every entry that uses a responder is
+	// failing to refresh
+	ResponderDown Kind = "responder-down"
+	// CertExpiring is sent when the certificate for a entry expires
+	// within the configured threshold
+	CertExpiring Kind = "cert-expiring"
+	// UnknownStatus is sent when the response for a entry using the
+	// alert unknown policy has the unknown status
+	UnknownStatus Kind = "unknown-status"
+	// FallbackBroken is sent when a game day finds that a responder or
+	// proxy which is only used when another fails doesn't work
+	FallbackBroken Kind = "fallback-broken"
+	// QuorumDisagreement is sent while the new responses for a entry
+	// are rejected because two responders disagree on its status
+	QuorumDisagreement Kind = "quorum-disagreement"
+)
+
+// Kinds contains every Kind
+var Kinds = []Kind{RefreshFailing, Revoked, ResponderDown, CertExpiring, UnknownStatus, FallbackBroken, QuorumDisagreement}
+
+// ParseKind parses the name of a Kind
+func ParseKind(name string) (Kind, error) {
+	for _, k := range Kinds {
+		if string(k) == name {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event '%s'", name)
+}
+
+// Event describes a problem with the subject, a entry name or a
+// responder URL
+type Event struct {
+	Kind    Kind
+	Subject string
+	Message string
+	Time    time.Time
+}
+
+func (e Event) key() string {
+	return string(e.Kind) + " " + e.Subject
+}
+
+// Summary returns a single line description of the event
+func (e Event) Summary() string {
+	return fmt.Sprintf("[stapled] %s: %s", e.Kind, e.Message)
+}
+
+// Notifier sends events to a external system
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// Target is a Notifier along with the event kinds it should be sent,
+// if Kinds is empty every kind is sent
+type Target struct {
+	Name     string
+	Notifier Notifier
+	Kinds    []Kind
+}
+
+func (t Target) wants(k Kind) bool {
+	if len(t.Kinds) == 0 {
+		return true
+	}
+	for _, want := range t.Kinds {
+		if want == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher sends events to targets, a event for the same kind and
+// subject is only sent once per dedup window while it remains active
+// and at most maxPerHour events are sent each hour
+type Dispatcher struct {
+	log         *log.Logger
+	clk         clock.Clock
+	targets     []Target
+	dedupWindow time.Duration
+	maxPerHour  int
+
+	sent   map[string]time.Time // event key -> last sent
+	recent []time.Time          // send times within the last hour
+	mu     sync.Mutex
+}
+
+// NewDispatcher creates a Dispatcher, a maxPerHour of zero means
+// there is no limit
+func NewDispatcher(logger *log.Logger, clk clock.Clock, dedupWindow time.Duration, maxPerHour int, targets ...Target) *Dispatcher {
+	return &Dispatcher{
+		log:         logger,
+		clk:         clk,
+		targets:     targets,
+		dedupWindow: dedupWindow,
+		maxPerHour:  maxPerHour,
+		sent:        make(map[string]time.Time),
+	}
+}
+
+// allow checks if a event should be sent and if so records it as sent
+func (d *Dispatcher) allow(e Event) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.clk.Now()
+	if last, present := d.sent[e.key()]; present && now.Sub(last) < d.dedupWindow {
+		return false
+	}
+	hourAgo := now.Add(-time.Hour)
+	for len(d.recent) > 0 && !d.recent[0].After(hourAgo) {
+		d.recent = d.recent[1:]
+	}
+	if d.maxPerHour > 0 && len(d.recent) >= d.maxPerHour {
+		d.log.Warning("[notify] Dropping '%s' event for '%s', sent %d events in the last hour", e.Kind, e.Subject, len(d.recent))
+		return false
+	}
+	d.sent[e.key()] = now
+	d.recent = append(d.recent, now)
+	return true
+}
+
+// Update sends the currently active events which haven't been sent
+// recently and forgets events which are no longer active, so that
+// they are sent immediately if they become active again
+func (d *Dispatcher) Update(ctx context.Context, active []Event) {
+	activeKeys := make(map[string]struct{}, len(active))
+	for _, e := range active {
+		activeKeys[e.key()] = struct{}{}
+	}
+	d.mu.Lock()
+	for k := range d.sent {
+		if _, present := activeKeys[k]; !present {
+			delete(d.sent, k)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, e := range active {
+		if !d.allow(e) {
+			continue
+		}
+		for _, t := range d.targets {
+			if !t.wants(e.Kind) {
+				continue
+			}
+			if err := t.Notifier.Notify(ctx, e); err != nil {
+				d.log.Err("[notify] Failed to send '%s' event for '%s' to '%s': %s", e.Kind, e.Subject, t.Name, err)
+				continue
+			}
+			d.log.Info("[notify] Sent '%s' event for '%s' to '%s'", e.Kind, e.Subject, t.Name)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+type recorder struct {
+	events []Event
+}
+
+func (r *recorder) Notify(ctx context.Context, e Event) error {
+	r.events = append(r.events, e)
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	fc := clock.NewFake()
+	all, revoked := &recorder{}, &recorder{}
+	d := NewDispatcher(log.NewLogger("", "", 10, fc), fc, time.Hour, 3,
+		Target{Name: "all", Notifier: all},
+		Target{Name: "revoked", Notifier: revoked, Kinds: []Kind{Revoked}},
+	)
+
+	failing := Event{Kind: RefreshFailing, Subject: "a"}
+	revokedEvent := Event{Kind: Revoked, Subject: "b"}
+	d.Update(context.Background(), []Event{failing, revokedEvent})
+	if len(all.events) != 2 || len(revoked.events) != 1 {
+		t.Fatalf("Unexpected number of events sent: %d and %d", len(all.events), len(revoked.events))
+	}
+
+	// still active within the dedup window
+	fc.Add(time.Minute)
+	d.Update(context.Background(), []Event{failing, revokedEvent})
+	if len(all.events) != 2 {
+		t.Fatalf("Active events were resent within the dedup window: %d", len(all.events))
+	}
+
+	// resolved and then active again
+	d.Update(context.Background(), []Event{revokedEvent})
+	d.Update(context.Background(), []Event{failing, revokedEvent})
+	if len(all.events) != 3 {
+		t.Fatalf("Event wasn't resent after being resolved: %d", len(all.events))
+	}
+
+	// throttled, three events have been sent in the last hour
+	d.Update(context.Background(), []Event{failing, revokedEvent, {Kind: CertExpiring, Subject: "c"}})
+	if len(all.events) != 3 {
+		t.Fatalf("Events weren't throttled: %d", len(all.events))
+	}
+	fc.Add(time.Hour)
+	d.Update(context.Background(), []Event{failing, revokedEvent, {Kind: CertExpiring, Subject: "c"}})
+	if len(all.events) != 6 {
+		t.Fatalf("Expected events to be sent after dedup window and throttle expired: %d", len(all.events))
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	for _, k := range Kinds {
+		parsed, err := ParseKind(string(k))
+		if err != nil || parsed != k {
+			t.Fatalf("Failed to parse '%s': %s", k, err)
+		}
+	}
+	if _, err := ParseKind("everything"); err == nil {
+		t.Fatal("ParseKind didn't fail with unknown kind")
+	}
+}
+
+func TestBackends(t *testing.T) {
+	var body map[string]interface{}
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contents, _ := ioutil.ReadAll(r.Body)
+		body = map[string]interface{}{}
+		json.Unmarshal(contents, &body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	e := Event{Kind: Revoked, Subject: "a", Message: "a has been revoked", Time: time.Now()}
+	client := new(http.Client)
+
+	if err := (&Webhook{URL: srv.URL, Client: client}).Notify(context.Background(), e); err != nil {
+		t.Fatalf("Webhook.Notify failed: %s", err)
+	}
+	if body["kind"] != "revoked" || body["subject"] != "a" {
+		t.Fatalf("Unexpected webhook body: %v", body)
+	}
+
+	if err := (&Slack{URL: srv.URL, Client: client}).Notify(context.Background(), e); err != nil {
+		t.Fatalf("Slack.Notify failed: %s", err)
+	}
+	if body["text"] != e.Summary() {
+		t.Fatalf("Unexpected Slack body: %v", body)
+	}
+
+	if err := (&PagerDuty{RoutingKey: "key", URL: srv.URL, Client: client}).Notify(context.Background(), e); err != nil {
+		t.Fatalf("PagerDuty.Notify failed: %s", err)
+	}
+	payload, _ := body["payload"].(map[string]interface{})
+	if body["routing_key"] != "key" || body["event_action"] != "trigger" || body["dedup_key"] != "stapled revoked a" || payload["severity"] != "critical" {
+		t.Fatalf("Unexpected PagerDuty body: %v", body)
+	}
+
+	status = http.StatusInternalServerError
+	if err := (&Webhook{URL: srv.URL, Client: client}).Notify(context.Background(), e); err == nil {
+		t.Fatal("Webhook.Notify didn't fail with a non-2xx response")
+	}
+}
@@ -0,0 +1,29 @@
+package ocsp
+
+import (
+	"context"
+	"time"
+)
+
+// Attempt describes a request Fetch made to a upstream responder
+type Attempt struct {
+	Started    time.Time
+	Responder  string
+	StatusCode int // zero if no response was received
+	Latency    time.Duration
+	Err        error // nil if a valid response was received
+}
+
+type attemptObserverKey struct{}
+
+// WithAttemptObserver returns a copy of ctx which makes Fetch call
+// observe with each request it makes once the request has finished
+func WithAttemptObserver(ctx context.Context, observe func(Attempt)) context.Context {
+	return context.WithValue(ctx, attemptObserverKey{}, observe)
+}
+
+// attemptObserver returns the observer set on ctx, or nil
+func attemptObserver(ctx context.Context) func(Attempt) {
+	observe, _ := ctx.Value(attemptObserverKey{}).(func(Attempt))
+	return observe
+}
@@ -0,0 +1,121 @@
+package ocsp
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+)
+
+// retryBudgetWindow is how long requests are counted for before the
+// budget for a host is reset
+const retryBudgetWindow = time.Minute
+
+// RetryBudget limits the fraction of the requests sent to each
+// responder host which may be retries, so that when a responder is
+// failing the entries which fetch from it give up until their next
+// refresh rather than multiplying the load on it by retrying
+type RetryBudget struct {
+	ratio      float64
+	minRetries int64
+	clk        clock.Clock
+	hosts      map[string]*budgetWindow
+	mu         sync.Mutex
+}
+
+type budgetWindow struct {
+	start    time.Time
+	requests int64
+	retries  int64
+	rejected int64 // over the lifetime of the budget
+}
+
+// NewRetryBudget creates a RetryBudget which allows ratio of the
+// requests to each host in a minute to be retries, with a minimum of
+// minRetries retries a minute so that a single failure to a quiet
+// responder can still be retried
+func NewRetryBudget(clk clock.Clock, ratio float64, minRetries int) *RetryBudget {
+	return &RetryBudget{
+		ratio:      ratio,
+		minRetries: int64(minRetries),
+		clk:        clk,
+		hosts:      make(map[string]*budgetWindow),
+	}
+}
+
+// window returns the current window for host, must be called with
+// the lock held
+func (rb *RetryBudget) window(host string) *budgetWindow {
+	now := rb.clk.Now()
+	w, present := rb.hosts[host]
+	if !present {
+		w = &budgetWindow{start: now}
+		rb.hosts[host] = w
+	} else if now.Sub(w.start) >= retryBudgetWindow {
+		w.start, w.requests, w.retries = now, 0, 0
+	}
+	return w
+}
+
+// request records a request, including retries, to host
+func (rb *RetryBudget) request(host string) {
+	if rb == nil {
+		return
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.window(host).requests++
+}
+
+// retry returns true, and records the retry, if a retry to host is
+// within the budget
+func (rb *RetryBudget) retry(host string) bool {
+	if rb == nil {
+		return true
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	w := rb.window(host)
+	if w.retries >= rb.minRetries && float64(w.retries+1) > rb.ratio*float64(w.requests) {
+		w.rejected++
+		return false
+	}
+	w.retries++
+	return true
+}
+
+// RetryBudgetStats describes the state of the budget for a host
+type RetryBudgetStats struct {
+	Requests int64 // in the current window, including retries
+	Retries  int64 // in the current window
+	Rejected int64 // retries that were over the budget
+}
+
+// Stats returns the current stats for each host that has been sent
+// requests
+func (rb *RetryBudget) Stats() map[string]RetryBudgetStats {
+	stats := make(map[string]RetryBudgetStats)
+	if rb == nil {
+		return stats
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	for host := range rb.hosts {
+		w := rb.window(host)
+		stats[host] = RetryBudgetStats{
+			Requests: w.requests,
+			Retries:  w.retries,
+			Rejected: w.rejected,
+		}
+	}
+	return stats
+}
+
+// responderHost returns the host budgets are kept for responder
+func responderHost(responder string) string {
+	if u, err := url.Parse(responder); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return responder
+}
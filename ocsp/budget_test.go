@@ -0,0 +1,84 @@
+package ocsp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+func TestRetryBudget(t *testing.T) {
+	fc := clock.NewFake()
+	rb := NewRetryBudget(fc, 0.2, 1)
+
+	// the minimum is always allowed
+	rb.request("a")
+	if !rb.retry("a") {
+		t.Fatal("Retry within the minimum was rejected")
+	}
+	if rb.retry("a") {
+		t.Fatal("Retry over the budget was allowed")
+	}
+	for i := 0; i < 9; i++ {
+		rb.request("a")
+	}
+	if !rb.retry("a") {
+		t.Fatal("Retry within the ratio was rejected")
+	}
+	if rb.retry("a") {
+		t.Fatal("Retry over the ratio was allowed")
+	}
+	// hosts have separate budgets
+	if !rb.retry("b") {
+		t.Fatal("Retry to a different host was rejected")
+	}
+
+	stats := rb.Stats()["a"]
+	if stats.Requests != 10 || stats.Retries != 2 || stats.Rejected != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	fc.Add(retryBudgetWindow)
+	stats = rb.Stats()["a"]
+	if stats.Requests != 0 || stats.Retries != 0 || stats.Rejected != 2 {
+		t.Fatalf("Unexpected stats after the window passed: %+v", stats)
+	}
+	if !rb.retry("a") {
+		t.Fatal("Retry was rejected after the window passed")
+	}
+
+	var nilBudget *RetryBudget
+	nilBudget.request("a")
+	if !nilBudget.retry("a") {
+		t.Fatal("Retry was rejected without a budget")
+	}
+}
+
+func TestFetchRetryBudget(t *testing.T) {
+	logger := log.NewLogger("", "", 0, clock.Default())
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	fc := clock.NewFake()
+	backoff := Backoff{Delay: time.Second, Budget: NewRetryBudget(fc, 0, 2)}
+	_, err := Fetch(context.Background(), logger, fc, backoff, []string{srv.URL}, new(http.Client), []byte{1, 2, 3}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "retry budget") {
+		t.Fatalf("Expected Fetch to fail with a exhausted retry budget, got: %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("Expected 3 requests, got %d", requests)
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if stats := backoff.Budget.Stats()[host]; stats.Requests != 3 || stats.Retries != 2 || stats.Rejected != 1 {
+		t.Fatalf("Unexpected stats for '%s': %+v", host, stats)
+	}
+}
@@ -0,0 +1,90 @@
+package ocsp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// FailureClass classifies why a upstream request failed so that
+// failures retrying won't fix, such as the responder rejecting the
+// request, can be backed off differently to CA outages, and the two
+// told apart in logs and metrics
+type FailureClass int
+
+const (
+	// NetworkFailure is a request that couldn't be sent, or whose
+	// response couldn't be read
+	NetworkFailure FailureClass = iota + 1
+	// ServerFailure is a 5xx, 408, or 429 response, which usually
+	// means the responder is overloaded or unavailable
+	ServerFailure
+	// ClientFailure is any other non-200 response, which usually
+	// means the request is wrong and won't succeed if it is retried
+	ClientFailure
+	// ResponseFailure is a response which couldn't be parsed, or had
+	// a OCSP error status
+	ResponseFailure
+)
+
+// FailureClasses lists every FailureClass
+var FailureClasses = []FailureClass{NetworkFailure, ServerFailure, ClientFailure, ResponseFailure}
+
+func (fc FailureClass) String() string {
+	switch fc {
+	case NetworkFailure:
+		return "network"
+	case ServerFailure:
+		return "server"
+	case ClientFailure:
+		return "client"
+	case ResponseFailure:
+		return "response"
+	}
+	return "none"
+}
+
+// ParseFailureClass parses the name of a FailureClass, as returned by
+// its String method
+func ParseFailureClass(name string) (FailureClass, error) {
+	for _, fc := range FailureClasses {
+		if fc.String() == name {
+			return fc, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown failure class '%s', expected network, server, client, or response", name)
+}
+
+// classifyStatus returns the class of a failed request which got a
+// response with status code
+func classifyStatus(code int) FailureClass {
+	switch {
+	case code >= 500, code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return ServerFailure
+	}
+	return ClientFailure
+}
+
+// classifyResponseStatus returns the class of a failed request which
+// got a OCSP error response with status
+func classifyResponseStatus(status ocsp.ResponseStatus) FailureClass {
+	switch status {
+	case ocsp.TryLater, ocsp.InternalError:
+		return ServerFailure
+	case ocsp.Malformed, ocsp.SignatureRequired, ocsp.Unauthorized:
+		return ClientFailure
+	}
+	return ResponseFailure
+}
+
+// ClassPolicy describes how Fetch retries failures of a class. Delay
+// overrides Backoff.Delay if it is set. Attempts is how many failures
+// of the class Fetch makes before giving up, zero uses the default,
+// which is unlimited for every class except ClientFailure, which
+// isn't retried, and a negative Attempts is unlimited
+type ClassPolicy struct {
+	Delay    time.Duration
+	Attempts int
+}
@@ -0,0 +1,55 @@
+package ocsp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrStaleResponse is returned by VerifyResponse when the NextUpdate
+	// of a response has passed
+	ErrStaleResponse = errors.New("stale OCSP response")
+	// ErrMalformedResponse is returned by VerifyResponse when a response
+	// isn't valid for the certificate it was requested for
+	ErrMalformedResponse = errors.New("malformed OCSP response")
+	// ErrResponderUnavailable matches a *FetchError for which the
+	// responder couldn't provide a response before Fetch gave up
+	ErrResponderUnavailable = errors.New("responder unavailable")
+	// ErrUnauthorized is returned, wrapped in a *FetchError, when a
+	// responder says it isn't authoritative for a certificate
+	ErrUnauthorized = errors.New("responder returned unauthorized")
+	// errBudgetExhausted stops Fetch when the retry budget is exhausted
+	errBudgetExhausted = errors.New("retry budget is exhausted, not retrying")
+)
+
+// FetchError is returned by Fetch when it couldn't fetch a response,
+// Err is why it stopped, the Context error, ErrUnauthorized, or a
+// exhausted retry budget, and Last is the last failed request, if one
+// was made, whose FailureClass is Class. Wait is how long Fetch would
+// have backed off before its next attempt. It matches
+// ErrResponderUnavailable unless the responder returned unauthorized
+type FetchError struct {
+	Responder string
+	Last      error
+	Err       error
+	Class     FailureClass  // zero if no request failed
+	Wait      time.Duration // zero if no request failed
+}
+
+func (fe *FetchError) Error() string {
+	if fe.Last == nil || fe.Last == fe.Err {
+		return fmt.Sprintf("failed to fetch response from '%s': %s", fe.Responder, fe.Err)
+	}
+	return fmt.Sprintf("failed to fetch response from '%s': %s, last error: %s", fe.Responder, fe.Err, fe.Last)
+}
+
+// Unwrap returns Err
+func (fe *FetchError) Unwrap() error {
+	return fe.Err
+}
+
+// Is matches ErrResponderUnavailable unless Err is ErrUnauthorized
+func (fe *FetchError) Is(target error) bool {
+	return target == ErrResponderUnavailable && fe.Err != ErrUnauthorized
+}
@@ -0,0 +1,113 @@
+package ocsp
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Method is how Fetch sends requests to a responder
+type Method int
+
+const (
+	// AutoMethod uses GET unless the URL would be longer than the
+	// RFC 5019 limit, or the responder has rejected a GET request,
+	// in which case POST is used
+	AutoMethod Method = iota
+	// GETMethod always sends requests base64 encoded in the URL path
+	GETMethod
+	// POSTMethod always sends requests as the body of a POST with
+	// the content type application/ocsp-request
+	POSTMethod
+)
+
+func (m Method) String() string {
+	switch m {
+	case GETMethod:
+		return "get"
+	case POSTMethod:
+		return "post"
+	}
+	return "auto"
+}
+
+// ParseMethod parses the name of a Method, as returned by its String
+// method
+func ParseMethod(name string) (Method, error) {
+	for _, m := range []Method{AutoMethod, GETMethod, POSTMethod} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown method '%s', expected auto, get, or post", name)
+}
+
+// maxGETLength is the length, including the scheme and host, below
+// which RFC 5019 requires requests to be sent using GET, longer
+// requests are sent using POST by AutoMethod
+const maxGETLength = 255
+
+// RequestMethods holds the Method used for each responder, and
+// remembers the responders which rejected GET requests so AutoMethod
+// uses POST for them from then on. A nil RequestMethods uses
+// AutoMethod for every responder and only switches to POST for the
+// rest of a single Fetch
+type RequestMethods struct {
+	methods map[string]Method // responder URL -> method
+
+	mu          sync.Mutex
+	rejectedGET map[string]bool
+}
+
+// NewRequestMethods creates a RequestMethods, responders missing from
+// methods use AutoMethod
+func NewRequestMethods(methods map[string]Method) *RequestMethods {
+	return &RequestMethods{methods: methods, rejectedGET: make(map[string]bool)}
+}
+
+// method returns the Method configured for responder
+func (rm *RequestMethods) method(responder string) Method {
+	if rm == nil {
+		return AutoMethod
+	}
+	return rm.methods[responder]
+}
+
+// useGET checks if request should be sent to responder using GET
+func (rm *RequestMethods) useGET(responder string, request []byte) bool {
+	switch rm.method(responder) {
+	case GETMethod:
+		return true
+	case POSTMethod:
+		return false
+	}
+	if len(requestURL(responder, request)) >= maxGETLength {
+		return false
+	}
+	if rm == nil {
+		return true
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return !rm.rejectedGET[responder]
+}
+
+// rejectGET records that responder rejected a GET request, it returns
+// false if the responder's Method doesn't allow falling back to POST
+func (rm *RequestMethods) rejectGET(responder string) bool {
+	if rm.method(responder) != AutoMethod {
+		return false
+	}
+	if rm != nil {
+		rm.mu.Lock()
+		rm.rejectedGET[responder] = true
+		rm.mu.Unlock()
+	}
+	return true
+}
+
+// rejectsGET checks if a response status means the responder doesn't
+// accept GET requests, or the URL of one
+func rejectsGET(status int) bool {
+	return status == http.StatusMethodNotAllowed || status == http.StatusRequestURITooLong || status == http.StatusNotImplemented
+}
@@ -0,0 +1,85 @@
+package ocsp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+func TestFetchMethods(t *testing.T) {
+	logger := log.NewLogger("", "", 0, clock.Default())
+	issuer, key := newTestIssuer(t)
+	response := signResponse(t, issuer, key, nil)
+
+	// acceptGET controls whether the responder accepts GET requests,
+	// POST requests are always accepted
+	acceptGET := true
+	var methods []string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == "POST" {
+			body, _ = ioutil.ReadAll(r.Body)
+			if r.Header.Get("Content-Type") != "application/ocsp-request" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		} else if !acceptGET {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write(response)
+	}))
+	defer srv.Close()
+	fetch := func(backoff Backoff, request []byte) error {
+		methods = nil
+		_, err := Fetch(context.Background(), logger, clock.Default(), backoff, []string{srv.URL}, new(http.Client), request, nil, issuer)
+		return err
+	}
+
+	rm := NewRequestMethods(nil)
+	if err := fetch(Backoff{Methods: rm}, []byte{1, 2, 3}); err != nil || len(methods) != 1 || methods[0] != "GET" {
+		t.Fatalf("Expected a single GET request, got %v: %v", methods, err)
+	}
+	// requests whose URL would be too long are sent using POST
+	long := bytes.Repeat([]byte{1}, 256)
+	if err := fetch(Backoff{Methods: rm}, long); err != nil || len(methods) != 1 || methods[0] != "POST" || !bytes.Equal(body, long) {
+		t.Fatalf("Expected a long request to be sent using POST, got %v: %v", methods, err)
+	}
+
+	// a rejected GET is retried immediately using POST, and POST is
+	// used for the responder from then on
+	acceptGET = false
+	if err := fetch(Backoff{Methods: rm}, []byte{1, 2, 3}); err != nil || len(methods) != 2 || methods[1] != "POST" {
+		t.Fatalf("Expected a GET request followed by a POST request, got %v: %v", methods, err)
+	}
+	if err := fetch(Backoff{Methods: rm}, []byte{1, 2, 3}); err != nil || len(methods) != 1 || methods[0] != "POST" {
+		t.Fatalf("Expected a single POST request, got %v: %v", methods, err)
+	}
+	// without a RequestMethods only the current fetch falls back
+	if err := fetch(Backoff{}, []byte{1, 2, 3}); err != nil || len(methods) != 2 || methods[1] != "POST" {
+		t.Fatalf("Expected a GET request followed by a POST request, got %v: %v", methods, err)
+	}
+
+	// a responder fixed to GET doesn't fall back
+	fixed := NewRequestMethods(map[string]Method{srv.URL: GETMethod})
+	if err := fetch(Backoff{Methods: fixed}, []byte{1, 2, 3}); err == nil || len(methods) != 1 {
+		t.Fatalf("Expected a single rejected GET request, got %v: %v", methods, err)
+	}
+	fixed = NewRequestMethods(map[string]Method{srv.URL: POSTMethod})
+	acceptGET = true
+	if err := fetch(Backoff{Methods: fixed}, []byte{1, 2, 3}); err != nil || len(methods) != 1 || methods[0] != "POST" {
+		t.Fatalf("Expected a single POST request, got %v: %v", methods, err)
+	}
+
+	if _, err := ParseMethod("put"); err == nil {
+		t.Fatal("ParseMethod accepted a unknown method")
+	}
+}
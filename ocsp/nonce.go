@@ -0,0 +1,127 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// idPKIXOCSPNonce is the OID of the nonce extension, see RFC 8954
+var idPKIXOCSPNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+// nonceLength is the length of the nonces Fetch sends, the longest
+// RFC 8954 allows
+const nonceLength = 32
+
+// ErrNonceMismatch is returned, as the Last error of a *FetchError,
+// when a responder echoes a different nonce than the one it was sent
+var ErrNonceMismatch = errors.New("response nonce doesn't match the request nonce")
+
+// Nonces decides which responders Fetch sends a random nonce to, a
+// new one for every attempt. Many responders, especially those behind
+// CDNs, strip nonces, so a response without a nonce is accepted, but
+// a response which echoes a nonce must echo the one sent. Requests
+// with a nonce are never conditional. A nil Nonces never sends one
+type Nonces struct {
+	// All sends a nonce to every responder
+	All bool
+	// Responders are the responder URLs sent a nonce if All isn't set
+	Responders map[string]bool
+}
+
+// send checks if requests to responder should include a nonce
+func (n *Nonces) send(responder string) bool {
+	return n != nil && (n.All || n.Responders[responder])
+}
+
+// extensibleRequest is a OCSPRequest with every optional field, so
+// that requests which can't carry a nonce can be found
+type extensibleRequest struct {
+	TBSRequest struct {
+		Version           int           `asn1:"explicit,tag:0,default:0,optional"`
+		RequestorName     asn1.RawValue `asn1:"explicit,tag:1,optional"`
+		RequestList       asn1.RawValue
+		RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+	}
+	OptionalSignature asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+// addNonce returns a random nonce and a copy of the DER request with
+// it added as a request extension, replacing any nonce it already had
+func addNonce(request []byte) ([]byte, []byte, error) {
+	var parsed extensibleRequest
+	if rest, err := asn1.Unmarshal(request, &parsed); err != nil {
+		return nil, nil, err
+	} else if len(rest) > 0 {
+		return nil, nil, errors.New("trailing data after request")
+	}
+	tbs := parsed.TBSRequest
+	if tbs.Version != 0 || len(tbs.RequestorName.FullBytes) > 0 || len(parsed.OptionalSignature.FullBytes) > 0 {
+		return nil, nil, errors.New("a nonce can't be added to signed requests")
+	}
+	nonce := make([]byte, nonceLength)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	value, err := asn1.Marshal(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	extensions := []pkix.Extension{{Id: idPKIXOCSPNonce, Value: value}}
+	for _, ext := range tbs.RequestExtensions {
+		if !ext.Id.Equal(idPKIXOCSPNonce) {
+			extensions = append(extensions, ext)
+		}
+	}
+	der, err := asn1.Marshal(struct {
+		TBSRequest tbsRequestWithExtensions
+	}{
+		tbsRequestWithExtensions{
+			RequestList:       tbs.RequestList,
+			RequestExtensions: extensions,
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return nonce, der, nil
+}
+
+// responseData is the part of a ResponseData needed to find its
+// response extensions, which ocsp.Response doesn't expose
+type responseData struct {
+	Version            int `asn1:"explicit,tag:0,default:0,optional"`
+	ResponderID        asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          []asn1.RawValue
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// checkNonce checks that resp either doesn't echo a nonce or echoes
+// nonce. Some responders echo the nonce without wrapping it in a
+// OCTET STRING, so either form is accepted
+func checkNonce(resp *ocsp.Response, nonce []byte) error {
+	var rd responseData
+	if _, err := asn1.Unmarshal(resp.TBSResponseData, &rd); err != nil {
+		return err
+	}
+	for _, ext := range rd.ResponseExtensions {
+		if !ext.Id.Equal(idPKIXOCSPNonce) {
+			continue
+		}
+		if bytes.Equal(ext.Value, nonce) {
+			return nil
+		}
+		var echoed []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &echoed); err == nil && len(rest) == 0 && bytes.Equal(echoed, nonce) {
+			return nil
+		}
+		return ErrNonceMismatch
+	}
+	return nil
+}
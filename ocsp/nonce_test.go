@@ -0,0 +1,151 @@
+package ocsp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+// withResponseExtensions re-signs a response created by
+// ocsp.CreateResponse, which can't set response extensions, with
+// extensions added
+func withResponseExtensions(t *testing.T, response []byte, key *rsa.PrivateKey, extensions []pkix.Extension) []byte {
+	parsed, err := ocsp.ParseResponse(response, nil)
+	if err != nil {
+		t.Fatalf("ocsp.ParseResponse failed: %s", err)
+	}
+	var rd responseData
+	if _, err = asn1.Unmarshal(parsed.TBSResponseData, &rd); err != nil {
+		t.Fatalf("asn1.Unmarshal failed: %s", err)
+	}
+	rd.ResponseExtensions = extensions
+	tbs, err := asn1.Marshal(rd)
+	if err != nil {
+		t.Fatalf("asn1.Marshal failed: %s", err)
+	}
+	digest := crypto.SHA256.New()
+	digest.Write(tbs)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest.Sum(nil))
+	if err != nil {
+		t.Fatalf("rsa.SignPKCS1v15 failed: %s", err)
+	}
+	basic, err := asn1.Marshal(struct {
+		TBSResponseData    asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Signature          asn1.BitString
+	}{
+		asn1.RawValue{FullBytes: tbs},
+		pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, Parameters: asn1.NullRawValue},
+		asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	})
+	if err != nil {
+		t.Fatalf("asn1.Marshal failed: %s", err)
+	}
+	type responseBytes struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	}
+	der, err := asn1.Marshal(struct {
+		Status   asn1.Enumerated
+		Response responseBytes `asn1:"explicit,tag:0"`
+	}{0, responseBytes{asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}, basic}})
+	if err != nil {
+		t.Fatalf("asn1.Marshal failed: %s", err)
+	}
+	return der
+}
+
+func TestFetchNonce(t *testing.T) {
+	logger := log.NewLogger("", "", 0, clock.Default())
+	issuer, key := newTestIssuer(t)
+	response := signResponse(t, issuer, key, nil)
+	other := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}, Value: []byte{5, 0}}
+	request, err := MarshalRequest(&ocsp.Request{
+		HashAlgorithm:  crypto.SHA1,
+		IssuerNameHash: []byte{1, 2, 3},
+		IssuerKeyHash:  []byte{4, 5, 6},
+		SerialNumber:   big.NewInt(0),
+	}, []pkix.Extension{other})
+	if err != nil {
+		t.Fatalf("MarshalRequest failed: %s", err)
+	}
+
+	// echo is the nonce extension value the responder echoes, nil
+	// echoes the one it was sent
+	var echo []byte
+	var sent [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method == "GET" {
+			body, _ = base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/"))
+		}
+		var req extensibleRequest
+		if _, err := asn1.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var nonce []byte
+		for _, ext := range req.TBSRequest.RequestExtensions {
+			if ext.Id.Equal(idPKIXOCSPNonce) {
+				nonce = ext.Value
+			} else if !ext.Id.Equal(other.Id) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		sent = append(sent, nonce)
+		if nonce == nil || len(req.TBSRequest.RequestExtensions) != 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if echo != nil {
+			nonce = echo
+		}
+		w.Write(withResponseExtensions(t, response, key, []pkix.Extension{{Id: idPKIXOCSPNonce, Value: nonce}}))
+	}))
+	defer srv.Close()
+
+	backoff := Backoff{Nonces: &Nonces{Responders: map[string]bool{srv.URL: true}}, Response: ClassPolicy{Attempts: 1}}
+	if _, err := Fetch(context.Background(), logger, clock.Default(), backoff, []string{srv.URL}, http.DefaultClient, request, nil, issuer); err != nil {
+		t.Fatalf("Fetch failed with a echoed nonce: %s", err)
+	}
+	if _, err := Fetch(context.Background(), logger, clock.Default(), backoff, []string{srv.URL}, http.DefaultClient, request, nil, issuer); err != nil {
+		t.Fatalf("Fetch failed with a echoed nonce: %s", err)
+	}
+	if len(sent) != 2 || string(sent[0]) == string(sent[1]) {
+		t.Fatalf("Expected a different nonce for each request, got %x", sent)
+	}
+
+	echo, _ = asn1.Marshal([]byte{1, 2, 3})
+	_, err = Fetch(context.Background(), logger, clock.Default(), backoff, []string{srv.URL}, http.DefaultClient, request, nil, issuer)
+	var fe *FetchError
+	if !errors.As(err, &fe) || !errors.Is(fe.Last, ErrNonceMismatch) {
+		t.Fatalf("Expected Fetch to fail with ErrNonceMismatch, got %v", err)
+	}
+
+	// responders which strip the nonce are accepted
+	stripped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(response)
+	}))
+	defer stripped.Close()
+	backoff.Nonces = &Nonces{All: true}
+	if _, err := Fetch(context.Background(), logger, clock.Default(), backoff, []string{stripped.URL}, http.DefaultClient, request, nil, issuer); err != nil {
+		t.Fatalf("Fetch failed with a stripped nonce: %s", err)
+	}
+}
@@ -1,35 +1,43 @@
 package ocsp
 
 import (
+	"bytes"
 	"context"
 	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
-	mrand "math/rand"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/jmhodges/clock"
 	"golang.org/x/crypto/ocsp"
 
 	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/rand"
+	"github.com/rolandshoemaker/stapled/tracing"
 )
 
 // VerifyResponse verifies a OCSP response is valid and for the expected
-// certificate
+// certificate, the errors it returns wrap ErrStaleResponse or
+// ErrMalformedResponse
 func VerifyResponse(now time.Time, serial *big.Int, resp *ocsp.Response) error {
 	if resp.ThisUpdate.After(now) {
-		return fmt.Errorf("malformed OCSP response: ThisUpdate is in the future (%s after %s)", resp.ThisUpdate, now)
+		return fmt.Errorf("%w: ThisUpdate is in the future (%s after %s)", ErrMalformedResponse, resp.ThisUpdate, now)
 	}
 	if resp.NextUpdate.Before(now) {
-		return fmt.Errorf("stale OCSP response: NextUpdate is in the past (%s before %s)", resp.NextUpdate, now)
+		return fmt.Errorf("%w: NextUpdate is in the past (%s before %s)", ErrStaleResponse, resp.NextUpdate, now)
 	}
 	if serial.Cmp(resp.SerialNumber) != 0 {
-		return fmt.Errorf("malformed OCSP response: Serial numbers don't match (wanted %x, got %x)", serial.Bytes(), resp.SerialNumber.Bytes())
+		return fmt.Errorf("%w: Serial numbers don't match (wanted %x, got %x)", ErrMalformedResponse, serial.Bytes(), resp.SerialNumber.Bytes())
 	}
 	return nil
 }
@@ -45,84 +53,458 @@ func parseCacheControl(h string) int {
 	return maxAge
 }
 
+type conditionalEntry struct {
+	eTag         string
+	lastModified string
+	body         []byte
+}
+
+// ConditionalCache stores the validators and bodies of previous
+// responses keyed by request URL so that conditional requests can
+// be made, and 304 responses used, by any entry that sends the same
+// request, not just the entry that originally made it
+type ConditionalCache struct {
+	maxEntries int
+	entries    map[string]conditionalEntry
+	mu         sync.Mutex
+}
+
+// NewConditionalCache creates a ConditionalCache that will hold
+// at most maxEntries responses
+func NewConditionalCache(maxEntries int) *ConditionalCache {
+	return &ConditionalCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]conditionalEntry),
+	}
+}
+
+func (cc *ConditionalCache) get(url string) (conditionalEntry, bool) {
+	if cc == nil {
+		return conditionalEntry{}, false
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	ce, present := cc.entries[url]
+	return ce, present
+}
+
+func (cc *ConditionalCache) set(url string, ce conditionalEntry) {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if _, present := cc.entries[url]; !present && len(cc.entries) >= cc.maxEntries {
+		// evict a arbitrary entry, the worst case is a single
+		// unconditional request
+		for k := range cc.entries {
+			delete(cc.entries, k)
+			break
+		}
+	}
+	cc.entries[url] = ce
+}
+
+func (cc *ConditionalCache) remove(url string) {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delete(cc.entries, url)
+}
+
+// Forget removes the stored validators and bodies for request to
+// any of responders
+func (cc *ConditionalCache) Forget(responders []string, request []byte) {
+	for _, responder := range responders {
+		// entries are keyed on the parsed URL used by Fetch
+		u, err := url.Parse(requestURL(responder, request))
+		if err != nil {
+			continue
+		}
+		cc.remove(u.String())
+	}
+}
+
+// requestURL returns the URL for a GET request for request to
+// responder
+func requestURL(responder string, request []byte) string {
+	return fmt.Sprintf(
+		"%s/%s",
+		responder,
+		url.QueryEscape(base64.StdEncoding.EncodeToString(request)),
+	)
+}
+
+// Backoff describes how long Fetch waits before retrying a failed
+// request. A random duration of up to Jitter * Delay is added to
+// each wait so that entries which fail together don't all retry
+// together, a Jitter of 0 makes waits deterministic. If Budget is
+// set retries are only made while they are within it. Network,
+// Server, Client, and Response override how failures of each
+// FailureClass are retried. Methods picks whether requests to each
+// responder use GET or POST, see RequestMethods, and Nonces which
+// requests include a nonce
+type Backoff struct {
+	Delay    time.Duration
+	Jitter   float64
+	Budget   *RetryBudget
+	Methods  *RequestMethods
+	Nonces   *Nonces
+	Network  ClassPolicy
+	Server   ClassPolicy
+	Client   ClassPolicy
+	Response ClassPolicy
+}
+
+// DefaultBackoff is used by Fetch in place of a zero Backoff
+var DefaultBackoff = Backoff{Delay: 10 * time.Second, Jitter: 0.1, Client: ClassPolicy{Attempts: 1}}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Delay == 0 && b.Jitter == 0 {
+		b.Jitter = DefaultBackoff.Jitter
+	}
+	if b.Delay == 0 {
+		b.Delay = DefaultBackoff.Delay
+	}
+	if b.Client.Attempts == 0 {
+		b.Client.Attempts = DefaultBackoff.Client.Attempts
+	}
+	return b
+}
+
+// policy returns the policy for failures of class
+func (b Backoff) policy(class FailureClass) ClassPolicy {
+	var p ClassPolicy
+	switch class {
+	case NetworkFailure:
+		p = b.Network
+	case ServerFailure:
+		p = b.Server
+	case ClientFailure:
+		p = b.Client
+	case ResponseFailure:
+		p = b.Response
+	}
+	if p.Delay == 0 {
+		p.Delay = b.Delay
+	}
+	return p
+}
+
+// DelayFor returns how long Fetch backs off for before retrying a
+// failure of class, before jitter is added, a zero class returns the
+// default delay
+func (b Backoff) DelayFor(class FailureClass) time.Duration {
+	return b.withDefaults().policy(class).Delay
+}
+
+// wait adds jitter to d
+func (b Backoff) wait(d time.Duration) time.Duration {
+	if b.Jitter > 0 && d > 0 {
+		d += time.Duration(rand.Int63n(int64(float64(d)*b.Jitter) + 1))
+	}
+	return d
+}
+
+// traceConnection records the address of the connection req is sent
+// over on span, which is the proxy if it is proxied
+func traceConnection(req *http.Request, span tracing.Span) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			span.SetAttribute("net.peer.addr", info.Conn.RemoteAddr().String())
+			span.SetAttribute("conn.reused", info.Reused)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// sleep waits for d, returning early if ctx is done first. Fake
+// clocks advance when slept on rather than blocking, so a timer is
+// only used for real ones
+func sleep(ctx context.Context, clk clock.Clock, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d <= 0 {
+		return nil
+	}
+	if _, fake := clk.(clock.FakeClock); fake {
+		clk.Sleep(d)
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// maxDrainSize is how much of a unused response body is read before
+// it is closed so that its connection can be reused, the connections
+// of larger ones are closed instead
+const maxDrainSize = 64 << 10
+
+// closeBody drains and closes the body of resp
+func closeBody(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainSize))
+	resp.Body.Close()
+}
+
 func randomResponder(responders []string) string {
-	return responders[mrand.Intn(len(responders))]
+	return responders[rand.Intn(len(responders))]
+}
+
+// Result is a response returned by Fetch along with the metadata
+// needed to cache it
+type Result struct {
+	Response  *ocsp.Response
+	Body      []byte
+	ETag      string
+	MaxAge    int    // seconds, from the Cache-Control header
+	Responder string // the responder the response was fetched from
+	BytesRead int    // size of the body read from the responder, zero for a 304
 }
 
 // Fetch requests a OCSP response from a upstream responder. It will make multiple
-// requests before the Context expires if requests timeout
-func Fetch(ctx context.Context, logger *log.Logger, responders []string, client *http.Client, request []byte, etag string, issuer *x509.Certificate) (*ocsp.Response, []byte, string, int, error) {
+// requests before the Context expires if requests timeout, waiting between them
+// according to backoff using clk, unless the retry budget in backoff
+// is exhausted. If cache is non-nil it is used to make
+// conditional requests and is updated with the validators of any new response,
+// only GET requests are made conditional. Requests are sent using GET or POST
+// according to backoff.Methods, a GET rejected by the responder is retried
+// immediately using POST unless the responder's method is fixed.
+// If it doesn't get a response it returns a *FetchError
+func Fetch(ctx context.Context, logger *log.Logger, clk clock.Clock, backoff Backoff, responders []string, client *http.Client, request []byte, cache *ConditionalCache, issuer *x509.Certificate) (result *Result, err error) {
+	backoff = backoff.withDefaults()
 	responder := randomResponder(responders)
-	backoffSeconds := 0
-	for {
-		if backoffSeconds > 0 {
-			logger.Info("[fetcher] Backing off for %d seconds", backoffSeconds)
-		}
-		timer := time.NewTimer(time.Duration(backoffSeconds) * time.Second)
-		select {
-		case <-ctx.Done():
-			return nil, nil, "", 0, ctx.Err()
-		case <-timer.C:
-		}
-		if backoffSeconds > 0 {
-			backoffSeconds = 0
-		}
-		req, err := http.NewRequest(
-			"GET",
-			fmt.Sprintf(
-				"%s/%s",
-				responder,
-				url.QueryEscape(base64.StdEncoding.EncodeToString(request)),
-			),
-			nil,
-		)
+	host := responderHost(responder)
+	ctx, span := tracing.Start(ctx, "ocsp.fetch")
+	span.SetAttribute("responder", responder)
+	// each attempt is ended when the next one starts, or Fetch returns
+	var attemptSpan tracing.Span
+	var current *Attempt
+	observe := attemptObserver(ctx)
+	endAttempt := func(err error) {
+		if attemptSpan != nil {
+			tracing.End(attemptSpan, err)
+			attemptSpan = nil
+		}
+		if current != nil {
+			current.Latency, current.Err = clk.Now().Sub(current.Started), err
+			if observe != nil {
+				observe(*current)
+			}
+			current = nil
+		}
+	}
+	defer func() {
+		endAttempt(err)
+		tracing.End(span, err)
+	}()
+	var wait time.Duration
+	var last error
+	// set once the responder rejects a GET request
+	postOnly := false
+	var lastClass FailureClass
+	failures := make(map[FailureClass]int)
+	// retry records a failed attempt, returning a error if no more
+	// attempts should be made for failures of class. The next attempt
+	// waits for the delay of class, or retryAfter if the responder
+	// sent a Retry-After header
+	retry := func(class FailureClass, err error, retryAfter time.Duration) error {
+		last, lastClass = err, class
+		failures[class]++
+		policy := backoff.policy(class)
+		wait = policy.Delay
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if policy.Attempts > 0 && failures[class] >= policy.Attempts {
+			return &FetchError{responder, last, fmt.Errorf("%s failure limit of %d reached", class, failures[class]), lastClass, wait}
+		}
+		return nil
+	}
+	for attempt := 1; ; attempt++ {
+		endAttempt(last)
+		backoffSpan := tracing.Span(nil)
+		if wait > 0 {
+			if !backoff.Budget.retry(host) {
+				return nil, &FetchError{responder, last, errBudgetExhausted, lastClass, wait}
+			}
+			wait = backoff.wait(wait)
+			logger.Info("[fetcher] Backing off for %s", wait)
+			_, backoffSpan = tracing.Start(ctx, "ocsp.backoff")
+			backoffSpan.SetAttribute("wait", wait.String())
+		}
+		err := sleep(ctx, clk, wait)
+		if backoffSpan != nil {
+			tracing.End(backoffSpan, err)
+		}
+		if err != nil {
+			return nil, &FetchError{responder, last, err, lastClass, wait}
+		}
+		wait = 0
+		span.SetAttribute("attempts", attempt)
+		attemptRequest, nonce := request, []byte(nil)
+		if backoff.Nonces.send(responder) {
+			nonce, attemptRequest, err = addNonce(request)
+			if err != nil {
+				return nil, &FetchError{responder, nil, fmt.Errorf("failed to add nonce to request: %s", err), 0, 0}
+			}
+		}
+		useGET := !postOnly && backoff.Methods.useGET(responder, attemptRequest)
+		var req *http.Request
+		if useGET {
+			req, err = http.NewRequest("GET", requestURL(responder, attemptRequest), nil)
+		} else {
+			req, err = http.NewRequest("POST", responder, bytes.NewReader(attemptRequest))
+		}
 		if err != nil {
-			return nil, nil, "", 0, err
+			return nil, &FetchError{responder, nil, err, 0, 0}
 		}
-		if etag != "" {
-			req.Header.Set("If-None-Match", etag)
+		if !useGET {
+			req.Header.Set("Content-Type", "application/ocsp-request")
 		}
-		logger.Info("[fetcher] Sending request to '%s'", req.URL)
+		var attemptCtx context.Context
+		attemptCtx, attemptSpan = tracing.Start(ctx, "ocsp.fetch.attempt")
+		attemptSpan.SetAttribute("attempt", attempt)
+		attemptSpan.SetAttribute("url", req.URL.String())
+		attemptSpan.SetAttribute("http.method", req.Method)
+		current = &Attempt{Started: clk.Now(), Responder: responder}
+		if tracing.Enabled() {
+			req = traceConnection(req, attemptSpan)
+		}
+		cached, haveCached := conditionalEntry{}, false
+		if useGET && nonce == nil {
+			cached, haveCached = cache.get(req.URL.String())
+		}
+		if haveCached {
+			if cached.eTag != "" {
+				req.Header.Set("If-None-Match", cached.eTag)
+			}
+			if cached.lastModified != "" {
+				req.Header.Set("If-Modified-Since", cached.lastModified)
+			}
+		}
+		logger.Info("[fetcher] Sending %s request to '%s'", req.Method, req.URL)
+		backoff.Budget.request(host)
 		resp, err := client.Do(req)
 		if err != nil {
-			logger.Err("[fetcher] Request for '%s' failed: %s", req.URL, err)
-			backoffSeconds = 10
+			logger.Warning("[fetcher] Request for '%s' failed: %s", req.URL, err)
+			if err := retry(NetworkFailure, err, 0); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		attemptSpan.SetAttribute("http.status_code", resp.StatusCode)
+		current.StatusCode = resp.StatusCode
+		if useGET && rejectsGET(resp.StatusCode) && backoff.Methods.rejectGET(responder) {
+			closeBody(resp)
+			logger.Warning("[fetcher] Responder '%s' rejected a GET request with status %d, retrying using POST", responder, resp.StatusCode)
+			postOnly = true
+			last = fmt.Errorf("GET request rejected with HTTP status %d", resp.StatusCode)
 			continue
 		}
-		defer resp.Body.Close()
 		if resp.StatusCode != 200 && resp.StatusCode != 304 {
-			logger.Err("[fetcher] Request for '%s' got a non-200 response: %d", req.URL, resp.StatusCode)
-			backoffSeconds = 10
-			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
-				if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
-					backoffSeconds = seconds
-				}
+			closeBody(resp)
+			class := classifyStatus(resp.StatusCode)
+			attemptSpan.SetAttribute("failure.class", class.String())
+			if class == ServerFailure {
+				logger.Warning("[fetcher] Request for '%s' got a server error response, the responder may be unavailable: %d", req.URL, resp.StatusCode)
+			} else {
+				logger.Err("[fetcher] Request for '%s' was rejected by the responder: %d", req.URL, resp.StatusCode)
+			}
+			var retryAfter time.Duration
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+				retryAfter = time.Duration(seconds) * time.Second
+			}
+			if err := retry(class, fmt.Errorf("unexpected HTTP status %d", resp.StatusCode), retryAfter); err != nil {
+				return nil, err
 			}
 			continue
 		}
 		body, err := ioutil.ReadAll(resp.Body)
+		closeBody(resp)
 		if err != nil {
-			logger.Err("[fetcher] Failed to read response body from '%s': %s", req.URL, err)
-			backoffSeconds = 10
+			logger.Warning("[fetcher] Failed to read response body from '%s': %s", req.URL, err)
+			if err := retry(NetworkFailure, err, 0); err != nil {
+				return nil, err
+			}
 			continue
 		}
-		ocspResp, err := ocsp.ParseResponse(body, issuer)
+		bytesRead := len(body)
+		eTag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		if resp.StatusCode == 304 {
+			if !haveCached {
+				logger.Err("[fetcher] Request for '%s' got a unexpected 304 response", req.URL)
+				if err := retry(ResponseFailure, errors.New("unexpected 304 response"), 0); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			logger.Info("[fetcher] Response for '%s' hasn't been modified, using cached response", req.URL)
+			body = cached.body
+			if eTag == "" {
+				eTag = cached.eTag
+			}
+			if lastModified == "" {
+				lastModified = cached.lastModified
+			}
+		}
+		_, verifySpan := tracing.Start(attemptCtx, "ocsp.verify-signature")
+		ocspResp, err := ParseResponse(clk.Now(), body, issuer)
+		tracing.End(verifySpan, err)
 		if err != nil {
+			if useGET {
+				cache.remove(req.URL.String())
+			}
 			if respErr, ok := err.(ocsp.ResponseError); ok {
 				logger.Err(
 					"[fetcher] Request for '%s' returned an unexpected OCSP response status: %s",
 					req.URL,
 					respErr.Status.String(),
 				)
-				backoffSeconds = 10
+				if respErr.Status == ocsp.Unauthorized {
+					// retrying won't change the responder's mind
+					return nil, &FetchError{responder, err, ErrUnauthorized, ClientFailure, 0}
+				}
+				if err := retry(classifyResponseStatus(respErr.Status), err, 0); err != nil {
+					return nil, err
+				}
 				continue
 			}
 			logger.Err("[fetcher] Failed to parse response body from '%s': %s", req.URL, err)
-			backoffSeconds = 10
+			if err := retry(ResponseFailure, err, 0); err != nil {
+				return nil, err
+			}
 			continue
 		}
+		if nonce != nil {
+			if err := checkNonce(ocspResp, nonce); err != nil {
+				logger.Err("[fetcher] Response from '%s' failed the nonce check: %s", req.URL, err)
+				if err := retry(ResponseFailure, err, 0); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
 
-		eTag, cacheControl := resp.Header.Get("ETag"), parseCacheControl(resp.Header.Get("Cache-Control"))
-		return ocspResp, body, eTag, cacheControl, nil
+		if useGET && nonce == nil && (eTag != "" || lastModified != "") {
+			cache.set(req.URL.String(), conditionalEntry{eTag, lastModified, body})
+		}
+		attemptSpan.SetAttribute("not-modified", resp.StatusCode == 304)
+		return &Result{
+			Response:  ocspResp,
+			Body:      body,
+			ETag:      eTag,
+			MaxAge:    parseCacheControl(resp.Header.Get("Cache-Control")),
+			Responder: responder,
+			BytesRead: bytesRead,
+		}, nil
 	}
 }
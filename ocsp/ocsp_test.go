@@ -1,6 +1,7 @@
 package ocsp
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/rand"
@@ -8,9 +9,12 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
@@ -38,22 +42,22 @@ func TestVerifyResponse(t *testing.T) {
 
 	resp.ThisUpdate = resp.ThisUpdate.Add(90 * time.Minute)
 	err = VerifyResponse(now, serial, resp)
-	if err == nil {
-		t.Fatal("VerifyResponse allowed a response with ThisUpdate in the future")
+	if !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("VerifyResponse allowed a response with ThisUpdate in the future: %v", err)
 	}
 	resp.ThisUpdate = thisUpdate
 
 	resp.NextUpdate = resp.NextUpdate.Add(-90 * time.Minute)
 	err = VerifyResponse(now, serial, resp)
-	if err == nil {
-		t.Fatal("VerifyResponse allowed a response with NextUpdate in the past")
+	if !errors.Is(err, ErrStaleResponse) {
+		t.Fatalf("VerifyResponse allowed a response with NextUpdate in the past: %v", err)
 	}
 	resp.NextUpdate = nextUpdate
 
 	resp.SerialNumber = big.NewInt(1)
 	err = VerifyResponse(now, serial, resp)
-	if err == nil {
-		t.Fatal("VerifyResponse allowed a response with the incorrect SerialNumber")
+	if !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("VerifyResponse allowed a response with the incorrect SerialNumber: %v", err)
 	}
 }
 
@@ -173,35 +177,42 @@ func TestFetch(t *testing.T) {
 	}
 
 	// good response
-	returnedResp, _, _, _, err := Fetch(
+	result, err := Fetch(
 		context.Background(),
 		logger,
+		clock.Default(),
+		Backoff{},
 		[]string{"http://localhost:8080"},
 		c,
 		req,
-		"etag!",
+		nil,
 		issuer,
 	)
 	if err != nil {
 		t.Fatalf("Fetch failed: %s", err)
 	}
-	if !reflect.DeepEqual(returnedResp, parsedResp) {
-		t.Fatalf("Unexpected response: wanted %s, got %s", parsedResp, returnedResp)
+	if !reflect.DeepEqual(result.Response, parsedResp) {
+		t.Fatalf("Unexpected response: wanted %v, got %v", parsedResp, result.Response)
+	}
+	if result.Responder != "http://localhost:8080" {
+		t.Fatalf("Unexpected responder: %s", result.Responder)
 	}
 
 	// no responder, timeout context
 	ctx, _ := context.WithTimeout(context.Background(), time.Second*15)
-	_, _, _, _, err = Fetch(
+	_, err = Fetch(
 		ctx,
 		logger,
+		clock.Default(),
+		Backoff{},
 		[]string{"http://localhost:9999"},
 		c,
 		req,
-		"",
+		nil,
 		nil,
 	)
-	if err == nil {
-		t.Fatal("Expected err with bad responder")
+	if !errors.Is(err, ErrResponderUnavailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected ErrResponderUnavailable with bad responder, got: %v", err)
 	}
 
 	// bad responder, timeout context
@@ -211,13 +222,15 @@ func TestFetch(t *testing.T) {
 		t.Fatalf("ocspRequest.Marshal failed: %s", err)
 	}
 	ctx, _ = context.WithTimeout(context.Background(), time.Second*15)
-	_, _, _, _, err = Fetch(
+	_, err = Fetch(
 		ctx,
 		logger,
+		clock.Default(),
+		Backoff{},
 		[]string{"http://localhost:8080"},
 		c,
 		req,
-		"",
+		nil,
 		nil,
 	)
 	if err == nil {
@@ -231,13 +244,15 @@ func TestFetch(t *testing.T) {
 		t.Fatalf("ocspRequest.Marshal failed: %s", err)
 	}
 	ctx, _ = context.WithTimeout(context.Background(), time.Second*15)
-	_, _, _, _, err = Fetch(
+	_, err = Fetch(
 		ctx,
 		logger,
+		clock.Default(),
+		Backoff{},
 		[]string{"http://localhost:8080"},
 		c,
 		req,
-		"",
+		nil,
 		nil,
 	)
 	if err == nil {
@@ -251,13 +266,15 @@ func TestFetch(t *testing.T) {
 		t.Fatalf("ocspRequest.Marshal failed: %s", err)
 	}
 	ctx, _ = context.WithTimeout(context.Background(), time.Second*15)
-	_, _, _, _, err = Fetch(
+	_, err = Fetch(
 		ctx,
 		logger,
+		clock.Default(),
+		Backoff{},
 		[]string{"http://localhost:8080"},
 		c,
 		req,
-		"",
+		nil,
 		nil,
 	)
 	if err == nil {
@@ -272,16 +289,273 @@ func TestFetch(t *testing.T) {
 	}
 	fs.response = ocsp.UnauthorizedErrorResponse
 	ctx, _ = context.WithTimeout(context.Background(), time.Second*15)
-	_, _, _, _, err = Fetch(
+	_, err = Fetch(
 		ctx,
 		logger,
+		clock.Default(),
+		Backoff{},
 		[]string{"http://localhost:8080"},
 		c,
 		req,
-		"",
 		nil,
+		issuer,
 	)
-	if err == nil {
-		t.Fatal("Expected err with bad responder")
+	if !errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrResponderUnavailable) {
+		t.Fatalf("Expected ErrUnauthorized with unauthorized response, got: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("Fetch retried a unauthorized response until the context expired")
+	}
+}
+
+// newTestIssuer creates a self-signed CA certificate, valid for a day
+// either side of now, and its key
+func newTestIssuer(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "issuer"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	issuer, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	return issuer, key
+}
+
+// signResponse creates a response signed by issuer, a good response
+// for serial 0 if template is nil
+func signResponse(t *testing.T, issuer *x509.Certificate, key *rsa.PrivateKey, template *ocsp.Response) []byte {
+	if template == nil {
+		template = &ocsp.Response{SerialNumber: big.NewInt(0), Status: ocsp.Good}
+	}
+	response, err := ocsp.CreateResponse(issuer, issuer, *template, key)
+	if err != nil {
+		t.Fatalf("ocsp.CreateResponse failed: %s", err)
+	}
+	return response
+}
+
+func TestFetchConditional(t *testing.T) {
+	logger := log.NewLogger("", "", 0, clock.Default())
+	issuer, key := newTestIssuer(t)
+	response := signResponse(t, issuer, key, nil)
+
+	requests, notModified := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"a"`)
+		if r.Header.Get("If-None-Match") == `"a"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(response)
+	}))
+	defer srv.Close()
+
+	cache := NewConditionalCache(1)
+	for i := 0; i < 2; i++ {
+		result, err := Fetch(context.Background(), logger, clock.Default(), Backoff{}, []string{srv.URL}, new(http.Client), []byte{1, 2, 3}, cache, issuer)
+		if err != nil {
+			t.Fatalf("Fetch failed: %s", err)
+		}
+		if !bytes.Equal(result.Body, response) {
+			t.Fatal("Fetch returned unexpected response body")
+		}
+		if result.ETag != `"a"` {
+			t.Fatalf("Fetch returned unexpected ETag: %q", result.ETag)
+		}
+	}
+	if requests != 2 || notModified != 1 {
+		t.Fatalf("Expected 2 requests with 1 not modified response, got %d and %d", requests, notModified)
+	}
+
+	// forgetting the request should result in a unconditional request
+	cache.Forget([]string{srv.URL}, []byte{1, 2, 3})
+	if _, err := Fetch(context.Background(), logger, clock.Default(), Backoff{}, []string{srv.URL}, new(http.Client), []byte{1, 2, 3}, cache, issuer); err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+	if requests != 3 || notModified != 1 {
+		t.Fatalf("Expected 3 requests with 1 not modified response, got %d and %d", requests, notModified)
+	}
+
+	// a second URL should evict the first
+	cache.set("other", conditionalEntry{eTag: "b"})
+	if _, present := cache.get(srv.URL + "/AQID"); present {
+		t.Fatal("ConditionalCache grew past maximum size")
+	}
+}
+
+func TestFetchBackoff(t *testing.T) {
+	logger := log.NewLogger("", "", 0, clock.Default())
+
+	issuer, key := newTestIssuer(t)
+	response := signResponse(t, issuer, key, nil)
+
+	for _, test := range []struct {
+		retryAfter string
+		expected   time.Duration
+	}{
+		{"", 5 * time.Second},
+		{"30", 30 * time.Second},
+		{"IM A BANANA", 5 * time.Second},
+	} {
+		failed := false
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !failed {
+				failed = true
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write(response)
+		}))
+		fc := clock.NewFake()
+		start := fc.Now()
+		_, err := Fetch(context.Background(), logger, fc, Backoff{Delay: 5 * time.Second}, []string{srv.URL}, new(http.Client), []byte{1, 2, 3}, nil, issuer)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("Fetch failed: %s", err)
+		}
+		if waited := fc.Now().Sub(start); waited != test.expected {
+			t.Fatalf("Expected Fetch to wait %s with Retry-After %q, waited %s", test.expected, test.retryAfter, waited)
+		}
+	}
+}
+
+func TestFetchReusesConnections(t *testing.T) {
+	logger := log.NewLogger("", "", 0, clock.Default())
+
+	issuer, key := newTestIssuer(t)
+	response := signResponse(t, issuer, key, nil)
+
+	// the bodies of failed attempts are drained so the connection
+	// they were sent on is used for the next attempt
+	failures := 0
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures < 2 {
+			failures++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(bytes.Repeat([]byte("unavailable "), 1024))
+			return
+		}
+		w.Write(response)
+	}))
+	conns := 0
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns++
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	if _, err := Fetch(context.Background(), logger, clock.NewFake(), Backoff{Delay: time.Second}, []string{srv.URL}, new(http.Client), []byte{1, 2, 3}, nil, issuer); err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+	if failures != 2 || conns != 1 {
+		t.Fatalf("Expected 3 attempts using 1 connection, got %d failures using %d connections", failures, conns)
+	}
+}
+
+func TestSleepCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() { done <- sleep(ctx, clock.Default(), time.Hour) }()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sleep didn't return when its context was cancelled")
+	}
+}
+
+func TestBackoffWait(t *testing.T) {
+	b := Backoff{Delay: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if wait := b.wait(b.Delay); wait < time.Second || wait > 1500*time.Millisecond {
+			t.Fatalf("Wait with jitter outside of expected range: %s", wait)
+		}
+	}
+	if wait := (Backoff{Delay: time.Second}).wait(time.Second); wait != time.Second {
+		t.Fatalf("Wait without jitter wasn't exact: %s", wait)
+	}
+	if b := (Backoff{}).withDefaults(); b != DefaultBackoff {
+		t.Fatalf("Zero Backoff didn't use defaults: %v", b)
+	}
+}
+
+func TestFetchBackoffClasses(t *testing.T) {
+	logger := log.NewLogger("", "", 0, clock.Default())
+	issuer, key := newTestIssuer(t)
+	response := signResponse(t, issuer, key, nil)
+
+	for _, test := range []struct {
+		name     string
+		backoff  Backoff
+		statuses []int // returned before a good response
+		requests int
+		waited   time.Duration
+		class    FailureClass // zero if Fetch should succeed
+	}{
+		{"client failure isn't retried", Backoff{Delay: 5 * time.Second}, []int{404}, 1, 0, ClientFailure},
+		{"client failures retried", Backoff{Delay: 5 * time.Second, Client: ClassPolicy{Delay: time.Minute, Attempts: 3}}, []int{404, 400}, 3, 2 * time.Minute, 0},
+		{"server failures use class delay", Backoff{Delay: 5 * time.Second, Server: ClassPolicy{Delay: 2 * time.Second}}, []int{502, 504, 500}, 4, 6 * time.Second, 0},
+		{"too many requests is a server failure", Backoff{Delay: 5 * time.Second}, []int{429}, 2, 5 * time.Second, 0},
+		{"server failure limit", Backoff{Delay: 5 * time.Second, Server: ClassPolicy{Attempts: 2}}, []int{500, 503, 500}, 2, 5 * time.Second, ServerFailure},
+		{"unlimited client failures", Backoff{Delay: 5 * time.Second, Client: ClassPolicy{Attempts: -1}}, []int{404, 404, 404}, 4, 15 * time.Second, 0},
+	} {
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests <= len(test.statuses) {
+				w.WriteHeader(test.statuses[requests-1])
+				return
+			}
+			w.Write(response)
+		}))
+		fc := clock.NewFake()
+		start := fc.Now()
+		_, err := Fetch(context.Background(), logger, fc, test.backoff, []string{srv.URL}, new(http.Client), []byte{1, 2, 3}, nil, issuer)
+		srv.Close()
+		if test.class == 0 && err != nil {
+			t.Fatalf("%s: Fetch failed: %s", test.name, err)
+		} else if test.class != 0 {
+			fe, ok := err.(*FetchError)
+			if !ok || fe.Class != test.class || !errors.Is(err, ErrResponderUnavailable) {
+				t.Fatalf("%s: Expected a %s failure, got: %v", test.name, test.class, err)
+			}
+		}
+		if requests != test.requests {
+			t.Fatalf("%s: Expected %d requests, got %d", test.name, test.requests, requests)
+		}
+		if waited := fc.Now().Sub(start); waited != test.waited {
+			t.Fatalf("%s: Expected Fetch to wait %s, waited %s", test.name, test.waited, waited)
+		}
+	}
+
+	for _, name := range []string{"network", "server", "client", "response"} {
+		if class, err := ParseFailureClass(name); err != nil || class.String() != name {
+			t.Fatalf("Failed to parse failure class '%s': %v", name, err)
+		}
+	}
+	if _, err := ParseFailureClass("other"); err == nil {
+		t.Fatal("Unknown failure class was parsed")
 	}
 }
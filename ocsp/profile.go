@@ -0,0 +1,75 @@
+package ocsp
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ErrNotLightweight is returned by CheckLightweightRequest for
+// requests that don't conform to the RFC 5019 lightweight profile
+var ErrNotLightweight = errors.New("request doesn't conform to the lightweight profile")
+
+// lightweightRequest is a OCSPRequest with every optional field, so
+// that the fields the lightweight profile excludes can be found
+type lightweightRequest struct {
+	TBSRequest struct {
+		Version           int           `asn1:"explicit,tag:0,default:0,optional"`
+		RequestorName     asn1.RawValue `asn1:"explicit,tag:1,optional"`
+		RequestList       []asn1.RawValue
+		RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+	}
+	OptionalSignature asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type lightweightSingleRequest struct {
+	ReqCert                 asn1.RawValue
+	SingleRequestExtensions []pkix.Extension `asn1:"explicit,tag:0,optional"`
+}
+
+// CheckLightweightRequest checks that a DER OCSP request conforms to
+// the RFC 5019 lightweight profile, it must contain a single
+// certificate ID, and no extensions, such as a nonce, or signature.
+// The errors it returns wrap ErrNotLightweight
+func CheckLightweightRequest(der []byte) error {
+	var req lightweightRequest
+	rest, err := asn1.Unmarshal(der, &req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNotLightweight, err)
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("%w: trailing data", ErrNotLightweight)
+	}
+	tbs := req.TBSRequest
+	if len(tbs.RequestList) != 1 {
+		return fmt.Errorf("%w: contains %d certificate IDs", ErrNotLightweight, len(tbs.RequestList))
+	}
+	if len(tbs.RequestExtensions) > 0 {
+		return fmt.Errorf("%w: contains request extensions", ErrNotLightweight)
+	}
+	if len(req.OptionalSignature.FullBytes) > 0 {
+		return fmt.Errorf("%w: is signed", ErrNotLightweight)
+	}
+	var single lightweightSingleRequest
+	if _, err = asn1.Unmarshal(tbs.RequestList[0].FullBytes, &single); err != nil {
+		return fmt.Errorf("%w: %s", ErrNotLightweight, err)
+	}
+	if len(single.SingleRequestExtensions) > 0 {
+		return fmt.Errorf("%w: contains single request extensions", ErrNotLightweight)
+	}
+	return nil
+}
+
+// CheckLightweightResponse checks that a response from a upstream
+// responder conforms to the RFC 5019 lightweight profile, which
+// requires NextUpdate to be set so that the response can be cached.
+// The errors it returns wrap ErrMalformedResponse
+func CheckLightweightResponse(resp *ocsp.Response) error {
+	if resp.NextUpdate.IsZero() {
+		return fmt.Errorf("%w: NextUpdate isn't set", ErrMalformedResponse)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestCheckLightweightRequest(t *testing.T) {
+	req := &ocsp.Request{
+		HashAlgorithm:  crypto.SHA1,
+		IssuerNameHash: bytes.Repeat([]byte{1}, 20),
+		IssuerKeyHash:  bytes.Repeat([]byte{2}, 20),
+		SerialNumber:   big.NewInt(10),
+	}
+	plain, err := req.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	if err = CheckLightweightRequest(plain); err != nil {
+		t.Fatalf("CheckLightweightRequest failed for a plain request: %s", err)
+	}
+
+	nonce := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}, Value: []byte{4, 2, 1, 2}}
+	withNonce, err := MarshalRequest(req, []pkix.Extension{nonce})
+	if err != nil {
+		t.Fatalf("MarshalRequest failed: %s", err)
+	}
+
+	var parsed lightweightRequest
+	if _, err = asn1.Unmarshal(plain, &parsed); err != nil {
+		t.Fatalf("Failed to parse request: %s", err)
+	}
+	single := parsed.TBSRequest.RequestList[0]
+	multiple, err := asn1.Marshal(struct {
+		TBSRequest struct {
+			RequestList []asn1.RawValue
+		}
+	}{struct{ RequestList []asn1.RawValue }{[]asn1.RawValue{single, single}}})
+	if err != nil {
+		t.Fatalf("Failed to marshal request with multiple certificate IDs: %s", err)
+	}
+
+	for name, der := range map[string][]byte{
+		"nonce":     withNonce,
+		"multiple":  multiple,
+		"malformed": plain[:len(plain)-1],
+		"trailing":  append(append([]byte{}, plain...), 0),
+	} {
+		if err = CheckLightweightRequest(der); !errors.Is(err, ErrNotLightweight) {
+			t.Fatalf("CheckLightweightRequest didn't reject %s request: %v", name, err)
+		}
+	}
+}
+
+func TestCheckLightweightResponse(t *testing.T) {
+	if err := CheckLightweightResponse(&ocsp.Response{NextUpdate: time.Now()}); err != nil {
+		t.Fatalf("CheckLightweightResponse failed: %s", err)
+	}
+	if err := CheckLightweightResponse(&ocsp.Response{}); !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("CheckLightweightResponse didn't reject response without NextUpdate: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package ocsp
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+// CreateRequest creates a request for the certificate with serial
+// issued by issuer, hashing the issuer using h from the hash provider
+// instead of the crypto package like ocsp.CreateRequest
+func CreateRequest(serial *big.Int, issuer *x509.Certificate, h crypto.Hash) ([]byte, error) {
+	nameHash, keyHash, err := common.HashIssuer(h, issuer.RawSubject, issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	req := &ocsp.Request{
+		HashAlgorithm:  h,
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  keyHash,
+		SerialNumber:   serial,
+	}
+	return req.Marshal()
+}
+
+// ParseRequestExtension parses a request extension from a dotted OID
+// and a hex encoded DER value
+func ParseRequestExtension(oid, value string, critical bool) (pkix.Extension, error) {
+	var id asn1.ObjectIdentifier
+	for _, c := range strings.Split(oid, ".") {
+		n, err := strconv.Atoi(c)
+		if err != nil || n < 0 {
+			return pkix.Extension{}, fmt.Errorf("invalid OID '%s'", oid)
+		}
+		id = append(id, n)
+	}
+	if len(id) < 2 {
+		return pkix.Extension{}, fmt.Errorf("invalid OID '%s'", oid)
+	}
+	der, err := hex.DecodeString(strings.Replace(value, ":", "", -1))
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("value for extension '%s' isn't hex encoded", oid)
+	}
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(der, &raw); err != nil {
+		return pkix.Extension{}, fmt.Errorf("value for extension '%s' isn't valid DER: %s", oid, err)
+	} else if len(rest) > 0 {
+		return pkix.Extension{}, fmt.Errorf("value for extension '%s' has trailing data", oid)
+	}
+	return pkix.Extension{Id: id, Critical: critical, Value: der}, nil
+}
+
+// tbsRequestList is a TBSRequest as marshalled by ocsp.Request, which
+// doesn't set a version or requestor name
+type tbsRequestList struct {
+	RequestList asn1.RawValue
+}
+
+type tbsRequestWithExtensions struct {
+	RequestList       asn1.RawValue
+	RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+}
+
+// MarshalRequest marshals a OCSP request with the provided request
+// extensions, which ocsp.Request doesn't support. Extensions are
+// only sent upstream, they don't change how a entry is looked up
+func MarshalRequest(req *ocsp.Request, extensions []pkix.Extension) ([]byte, error) {
+	der, err := req.Marshal()
+	if err != nil || len(extensions) == 0 {
+		return der, err
+	}
+	var parsed struct {
+		TBSRequest tbsRequestList
+	}
+	if rest, err := asn1.Unmarshal(der, &parsed); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after request")
+	}
+	return asn1.Marshal(struct {
+		TBSRequest tbsRequestWithExtensions
+	}{
+		tbsRequestWithExtensions{
+			RequestList:       parsed.TBSRequest.RequestList,
+			RequestExtensions: extensions,
+		},
+	})
+}
@@ -0,0 +1,71 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestParseRequestExtension(t *testing.T) {
+	ext, err := ParseRequestExtension("1.3.6.1.4.1.99999.1", "0c:04:74:65:73:74", true)
+	if err != nil {
+		t.Fatalf("ParseRequestExtension failed: %s", err)
+	}
+	if !ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}) || !ext.Critical || !bytes.Equal(ext.Value, []byte{0x0c, 4, 't', 'e', 's', 't'}) {
+		t.Fatalf("Unexpected extension: %+v", ext)
+	}
+	for _, bad := range [][2]string{
+		{"1", "0500"},
+		{"1.a.3", "0500"},
+		{"1.2.3", "zz"},
+		{"1.2.3", "0c05"},
+		{"1.2.3", "050000"},
+	} {
+		if _, err := ParseRequestExtension(bad[0], bad[1], false); err == nil {
+			t.Fatalf("ParseRequestExtension didn't fail for %s=%s", bad[0], bad[1])
+		}
+	}
+}
+
+func TestMarshalRequest(t *testing.T) {
+	req := &ocsp.Request{
+		HashAlgorithm:  crypto.SHA1,
+		IssuerNameHash: bytes.Repeat([]byte{1}, 20),
+		IssuerKeyHash:  bytes.Repeat([]byte{2}, 20),
+		SerialNumber:   big.NewInt(10),
+	}
+	plain, err := req.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	if der, err := MarshalRequest(req, nil); err != nil || !bytes.Equal(der, plain) {
+		t.Fatalf("MarshalRequest without extensions didn't match Marshal: %s", err)
+	}
+
+	ext := pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 3}, Value: []byte{5, 0}}
+	der, err := MarshalRequest(req, []pkix.Extension{ext})
+	if err != nil {
+		t.Fatalf("MarshalRequest failed: %s", err)
+	}
+	parsed, err := ocsp.ParseRequest(der)
+	if err != nil {
+		t.Fatalf("Failed to parse request with extensions: %s", err)
+	}
+	if parsed.SerialNumber.Cmp(req.SerialNumber) != 0 || !bytes.Equal(parsed.IssuerKeyHash, req.IssuerKeyHash) {
+		t.Fatalf("Request with extensions doesn't match original: %+v", parsed)
+	}
+	var withExtensions struct {
+		TBSRequest tbsRequestWithExtensions
+	}
+	if _, err := asn1.Unmarshal(der, &withExtensions); err != nil {
+		t.Fatalf("Failed to unmarshal request extensions: %s", err)
+	}
+	if len(withExtensions.TBSRequest.RequestExtensions) != 1 || !withExtensions.TBSRequest.RequestExtensions[0].Id.Equal(ext.Id) {
+		t.Fatalf("Unexpected request extensions: %+v", withExtensions.TBSRequest.RequestExtensions)
+	}
+}
@@ -0,0 +1,135 @@
+package ocsp
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+// NoCheckPolicy controls how a delegated responder certificate, which
+// signs responses on behalf of the issuer, is treated when it doesn't
+// have the id-pkix-ocsp-nocheck extension and so its own revocation
+// status should be checked (RFC 6960 section 4.2.2.2.1)
+type NoCheckPolicy int
+
+const (
+	// TrustResponder trusts the delegated responder certificate
+	TrustResponder NoCheckPolicy = iota
+	// WarnResponder trusts the delegated responder certificate but
+	// logs a warning
+	WarnResponder
+	// CheckResponder fetches the status of the delegated responder
+	// certificate from the responders in it and rejects the response
+	// if it isn't good
+	CheckResponder
+)
+
+var idPKIXOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+func hasNoCheck(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(idPKIXOCSPNoCheck) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponderChecker applies a NoCheckPolicy to the delegated responder
+// certificates in responses, the status of checked certificates is
+// cached until their responses expire
+type ResponderChecker struct {
+	policy   NoCheckPolicy
+	maxDepth int
+	logger   *log.Logger
+	clk      clock.Clock
+	checked  map[[32]byte]time.Time // certificate fingerprint -> when the check expires
+	mu       sync.Mutex
+}
+
+// NewResponderChecker creates a ResponderChecker, with the
+// CheckResponder policy at most maxDepth delegated responder
+// certificates will be checked to verify a single response
+func NewResponderChecker(logger *log.Logger, clk clock.Clock, policy NoCheckPolicy, maxDepth int) *ResponderChecker {
+	if maxDepth < 1 {
+		maxDepth = 1
+	}
+	return &ResponderChecker{
+		policy:   policy,
+		maxDepth: maxDepth,
+		logger:   logger,
+		clk:      clk,
+		checked:  make(map[[32]byte]time.Time),
+	}
+}
+
+// Check applies the policy to the delegated responder certificate in
+// resp, if there is one, it returns a error if the response should
+// be rejected
+func (rc *ResponderChecker) Check(ctx context.Context, client *http.Client, resp *ocsp.Response, issuer *x509.Certificate) error {
+	if rc == nil || resp.Certificate == nil || hasNoCheck(resp.Certificate) {
+		return nil
+	}
+	switch rc.policy {
+	case WarnResponder:
+		rc.logger.Warning("[fetcher] Delegated responder certificate '%s' doesn't have id-pkix-ocsp-nocheck, trusting it without checking its status", resp.Certificate.Subject)
+	case CheckResponder:
+		return rc.check(ctx, client, resp.Certificate, issuer, rc.maxDepth)
+	}
+	return nil
+}
+
+func (rc *ResponderChecker) check(ctx context.Context, client *http.Client, cert, issuer *x509.Certificate, depth int) error {
+	fingerprint := common.Sum256(cert.Raw)
+	now := rc.clk.Now()
+	rc.mu.Lock()
+	expires, present := rc.checked[fingerprint]
+	rc.mu.Unlock()
+	if present && now.Before(expires) {
+		return nil
+	}
+	if depth < 1 {
+		return fmt.Errorf("more than %d delegated responder certificates need to be checked", rc.maxDepth)
+	}
+	if len(cert.OCSPServer) == 0 {
+		return fmt.Errorf("delegated responder certificate '%s' has no OCSP URLs to check its status with", cert.Subject)
+	}
+	request, err := CreateRequest(cert.SerialNumber, issuer, common.DefaultRequestHash())
+	if err != nil {
+		return err
+	}
+	result, err := Fetch(ctx, rc.logger, rc.clk, Backoff{}, cert.OCSPServer, client, request, nil, issuer)
+	if err != nil {
+		return fmt.Errorf("failed to check status of delegated responder certificate '%s': %s", cert.Subject, err)
+	}
+	if err = VerifyResponse(now, cert.SerialNumber, result.Response); err != nil {
+		return fmt.Errorf("failed to check status of delegated responder certificate '%s': %s", cert.Subject, err)
+	}
+	if result.Response.Status != ocsp.Good {
+		return fmt.Errorf("delegated responder certificate '%s' isn't good, it has status %d", cert.Subject, result.Response.Status)
+	}
+	if signer := result.Response.Certificate; signer != nil && !hasNoCheck(signer) {
+		if bytes.Equal(signer.Raw, cert.Raw) {
+			return errors.New("delegated responder certificate status is signed by itself")
+		}
+		if err = rc.check(ctx, client, signer, issuer, depth-1); err != nil {
+			return err
+		}
+	}
+	rc.mu.Lock()
+	rc.checked[fingerprint] = result.Response.NextUpdate
+	rc.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,73 @@
+package ocsp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+func TestResponderChecker(t *testing.T) {
+	issuer, key := newTestIssuer(t)
+
+	status := ocsp.Good
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+			SerialNumber: big.NewInt(2),
+			Status:       status,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, key)
+		if err != nil {
+			t.Errorf("ocsp.CreateResponse failed: %s", err)
+			return
+		}
+		w.Write(resp)
+	}))
+	defer srv.Close()
+
+	responderTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "responder"},
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{srv.URL},
+	}
+	responderDER, err := x509.CreateCertificate(rand.Reader, responderTemplate, issuer, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	responder, err := x509.ParseCertificate(responderDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	resp := &ocsp.Response{Certificate: responder}
+
+	logger := log.NewLogger("", "", 10, clock.Default())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, policy := range []NoCheckPolicy{TrustResponder, WarnResponder, CheckResponder} {
+		rc := NewResponderChecker(logger, clock.Default(), policy, 1)
+		if err := rc.Check(ctx, http.DefaultClient, resp, issuer); err != nil {
+			t.Fatalf("Check failed with policy %d: %s", policy, err)
+		}
+	}
+
+	status = ocsp.Revoked
+	rc := NewResponderChecker(logger, clock.Default(), CheckResponder, 1)
+	if err := rc.Check(ctx, http.DefaultClient, resp, issuer); err == nil {
+		t.Fatal("Check didn't fail for revoked responder certificate")
+	}
+	if err := NewResponderChecker(logger, clock.Default(), WarnResponder, 1).Check(ctx, http.DefaultClient, resp, issuer); err != nil {
+		t.Fatalf("Check failed with warn policy: %s", err)
+	}
+}
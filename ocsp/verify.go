@@ -0,0 +1,167 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+type verifyResult struct {
+	resp *ocsp.Response
+	err  error
+}
+
+type verifyJob struct {
+	now    time.Time
+	body   []byte
+	issuer *x509.Certificate
+	queued time.Time
+	result chan verifyResult
+}
+
+// VerifyPool parses responses and verifies their signatures using a
+// fixed number of workers, so that bulk refreshes and loading a large
+// cache from disk don't saturate every core
+type VerifyPool struct {
+	jobs    chan verifyJob
+	workers int
+
+	verified int64
+	failed   int64
+	waited   int64 // total nanoseconds jobs spent queued
+}
+
+// NewVerifyPool creates a VerifyPool with workers workers and room
+// for queueSize responses to wait for a worker
+func NewVerifyPool(workers, queueSize int) *VerifyPool {
+	if workers < 1 {
+		workers = 1
+	}
+	vp := &VerifyPool{
+		jobs:    make(chan verifyJob, queueSize),
+		workers: workers,
+	}
+	for i := 0; i < workers; i++ {
+		go vp.work()
+	}
+	return vp
+}
+
+func (vp *VerifyPool) work() {
+	for job := range vp.jobs {
+		atomic.AddInt64(&vp.waited, int64(time.Since(job.queued)))
+		resp, err := CheckResponse(job.now, job.body, job.issuer)
+		if err != nil {
+			atomic.AddInt64(&vp.failed, 1)
+		} else {
+			atomic.AddInt64(&vp.verified, 1)
+		}
+		job.result <- verifyResult{resp, err}
+	}
+}
+
+// ErrNoIssuer is returned by CheckResponse when the issuer a response
+// should be verified against isn't known
+var ErrNoIssuer = errors.New("the issuer of the response isn't known, it can't be verified")
+
+// CheckResponse parses a response and verifies it is signed either by
+// issuer itself or by a delegated responder certificate issuer issued
+// which carries id-kp-OCSPSigning and is valid at now, as required by
+// RFC 6960 section 4.2.2.2. Every response stapled serves or stores is checked by it,
+// usually on a VerifyPool worker through ParseResponse. The signer
+// errors it returns wrap ErrMalformedResponse
+func CheckResponse(now time.Time, body []byte, issuer *x509.Certificate) (*ocsp.Response, error) {
+	if issuer == nil {
+		return nil, ErrNoIssuer
+	}
+	resp, err := ocsp.ParseResponse(body, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSigner(now, resp, issuer); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// checkSigner checks the delegated responder certificate included in
+// a response, if there is one, was issued by issuer, is valid at now
+// and carries id-kp-OCSPSigning. ocsp.ParseResponse only checks its
+// signature
+func checkSigner(now time.Time, resp *ocsp.Response, issuer *x509.Certificate) error {
+	signer := resp.Certificate
+	if signer == nil || bytes.Equal(signer.Raw, issuer.Raw) {
+		return nil
+	}
+	if !bytes.Equal(signer.RawIssuer, issuer.RawSubject) {
+		return fmt.Errorf("%w: responder certificate '%s' was issued by '%s', not '%s'", ErrMalformedResponse, signer.Subject, signer.Issuer, issuer.Subject)
+	}
+	if err := signer.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("%w: responder certificate '%s' wasn't issued by '%s': %s", ErrMalformedResponse, signer.Subject, issuer.Subject, err)
+	}
+	if now.Before(signer.NotBefore) || now.After(signer.NotAfter) {
+		return fmt.Errorf("%w: responder certificate '%s' isn't valid at %s, it is valid from %s until %s", ErrMalformedResponse, signer.Subject, now, signer.NotBefore, signer.NotAfter)
+	}
+	for _, eku := range signer.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageOCSPSigning {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: responder certificate '%s' isn't authorized to sign responses, it lacks id-kp-OCSPSigning", ErrMalformedResponse, signer.Subject)
+}
+
+// ParseResponse is like CheckResponse but the work is done by one of
+// the pool workers, it blocks until the response is verified
+func (vp *VerifyPool) ParseResponse(now time.Time, body []byte, issuer *x509.Certificate) (*ocsp.Response, error) {
+	result := make(chan verifyResult, 1)
+	vp.jobs <- verifyJob{now, body, issuer, time.Now(), result}
+	r := <-result
+	return r.resp, r.err
+}
+
+// VerifyStats describes the work done by a VerifyPool
+type VerifyStats struct {
+	Workers  int
+	Queued   int // responses currently waiting for a worker
+	Verified int64
+	Failed   int64
+	Waited   time.Duration // total time responses spent waiting for a worker
+}
+
+// Stats returns the current VerifyPool stats
+func (vp *VerifyPool) Stats() VerifyStats {
+	return VerifyStats{
+		Workers:  vp.workers,
+		Queued:   len(vp.jobs),
+		Verified: atomic.LoadInt64(&vp.verified),
+		Failed:   atomic.LoadInt64(&vp.failed),
+		Waited:   time.Duration(atomic.LoadInt64(&vp.waited)),
+	}
+}
+
+var (
+	defaultVerifyPool     *VerifyPool
+	defaultVerifyPoolOnce sync.Once
+)
+
+// DefaultVerifyPool returns the pool used by ParseResponse, it has a
+// worker for each CPU and is created when it is first used
+func DefaultVerifyPool() *VerifyPool {
+	defaultVerifyPoolOnce.Do(func() {
+		defaultVerifyPool = NewVerifyPool(runtime.GOMAXPROCS(0), 1024)
+	})
+	return defaultVerifyPool
+}
+
+// ParseResponse parses and verifies a response, see CheckResponse,
+// using the default VerifyPool
+func ParseResponse(now time.Time, body []byte, issuer *x509.Certificate) (*ocsp.Response, error) {
+	return DefaultVerifyPool().ParseResponse(now, body, issuer)
+}
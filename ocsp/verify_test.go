@@ -0,0 +1,103 @@
+package ocsp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestVerifyPool(t *testing.T) {
+	issuer, key := newTestIssuer(t)
+	response := signResponse(t, issuer, key, &ocsp.Response{SerialNumber: big.NewInt(2), Status: ocsp.Good})
+	corrupt := append([]byte{}, response...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	vp := NewVerifyPool(2, 1)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := response
+			if i%2 == 1 {
+				body = corrupt
+			}
+			resp, err := vp.ParseResponse(time.Now(), body, issuer)
+			if i%2 == 0 && (err != nil || resp.SerialNumber.Int64() != 2) {
+				t.Errorf("Failed to verify valid response: %v", err)
+			} else if i%2 == 1 && err == nil {
+				t.Error("Verified corrupt response")
+			}
+		}(i)
+	}
+	wg.Wait()
+	stats := vp.Stats()
+	if stats.Workers != 2 || stats.Verified != 5 || stats.Failed != 5 || stats.Queued != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if _, err := CheckResponse(time.Now(), response, nil); err != ErrNoIssuer {
+		t.Fatalf("Expected ErrNoIssuer without a issuer, got: %v", err)
+	}
+}
+
+func TestVerifyPoolDelegatedSigner(t *testing.T) {
+	issuer, key := newTestIssuer(t)
+	// a different CA with the same name
+	other, otherKey := newTestIssuer(t)
+	responderKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	now := time.Now()
+	responder := func(parent *x509.Certificate, signer *rsa.PrivateKey, notAfter time.Time, ekus ...x509.ExtKeyUsage) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "responder"},
+			NotBefore:    now.Add(-2 * time.Hour),
+			NotAfter:     notAfter,
+			ExtKeyUsage:  ekus,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, responderKey.Public(), signer)
+		if err != nil {
+			t.Fatalf("x509.CreateCertificate failed: %s", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("x509.ParseCertificate failed: %s", err)
+		}
+		return cert
+	}
+
+	vp := NewVerifyPool(1, 1)
+	for _, tc := range []struct {
+		name  string
+		cert  *x509.Certificate
+		valid bool
+	}{
+		{"delegated", responder(issuer, key, now.Add(time.Hour), x509.ExtKeyUsageOCSPSigning), true},
+		{"missing id-kp-OCSPSigning", responder(issuer, key, now.Add(time.Hour), x509.ExtKeyUsageServerAuth), false},
+		{"not issued by the issuer", responder(other, otherKey, now.Add(time.Hour), x509.ExtKeyUsageOCSPSigning), false},
+		{"expired", responder(issuer, key, now.Add(-time.Hour), x509.ExtKeyUsageOCSPSigning), false},
+	} {
+		body, err := ocsp.CreateResponse(issuer, tc.cert, ocsp.Response{SerialNumber: big.NewInt(3), Status: ocsp.Good, Certificate: tc.cert}, responderKey)
+		if err != nil {
+			t.Fatalf("ocsp.CreateResponse failed: %s", err)
+		}
+		_, err = vp.ParseResponse(now, body, issuer)
+		if tc.valid && err != nil {
+			t.Fatalf("%s: failed to verify valid response: %s", tc.name, err)
+		} else if !tc.valid && err == nil {
+			t.Fatalf("%s: verified response from unauthorized responder", tc.name)
+		}
+	}
+	if stats := vp.Stats(); stats.Verified != 1 || stats.Failed != 3 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,670 @@
+// Package pac implements a evaluator for a restricted subset of
+// proxy auto-config files. Since there is no JavaScript engine
+// available only the declarative style most PAC files are written
+// in is supported, a single FindProxyForURL function containing
+// if/else, return, and var statements, with values built from
+// string literals, variables, comparisons, the logical operators
+// and the following helper functions
+//
+//	isPlainHostName(host)
+//	dnsDomainIs(host, domain)
+//	localHostOrDomainIs(host, hostdom)
+//	shExpMatch(str, shexp)
+//	isInNet(host, pattern, mask)
+//	isResolvable(host)
+//	myIpAddress()
+//
+// Anything else, including numbers, assignments to existing
+// variables, other functions, and loops, is rejected when the file
+// is parsed rather than when it is evaluated. Host names are
+// resolved using the Resolver given to Parse and the results are
+// cached for resolveTTL, so that evaluating the script doesn't
+// cause a lookup for every request.
+package pac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+}
+
+func lex(src string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i += end + 4
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, token{tokString, src[i+1 : i+1+end]})
+			i += end + 2
+		case c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] == '$' || (src[j] >= 'a' && src[j] <= 'z') || (src[j] >= 'A' && src[j] <= 'Z') || (src[j] >= '0' && src[j] <= '9')) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, src[i:j]})
+			i = j
+		default:
+			matched := false
+			for _, p := range []string{"===", "!==", "==", "!=", "&&", "||", "(", ")", "{", "}", ";", ",", "!", "="} {
+				if strings.HasPrefix(src[i:], p) {
+					tokens = append(tokens, token{tokPunct, p})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+type env struct {
+	url      string
+	host     string
+	vars     map[string]interface{}
+	resolver *cachingResolver
+}
+
+type expr interface {
+	eval(*env) interface{}
+}
+
+type stmt interface {
+	// exec returns the returned value and whether a return
+	// statement was executed
+	exec(*env) (string, bool)
+}
+
+type stringLit string
+
+func (sl stringLit) eval(*env) interface{} { return string(sl) }
+
+type paramRef int
+
+const (
+	paramURL paramRef = iota
+	paramHost
+)
+
+func (pr paramRef) eval(e *env) interface{} {
+	if pr == paramURL {
+		return e.url
+	}
+	return e.host
+}
+
+type varRef string
+
+func (vr varRef) eval(e *env) interface{} { return e.vars[string(vr)] }
+
+type notExpr struct{ x expr }
+
+func (ne notExpr) eval(e *env) interface{} { return !truthy(ne.x.eval(e)) }
+
+type binaryExpr struct {
+	op   string
+	l, r expr
+}
+
+func (be binaryExpr) eval(e *env) interface{} {
+	switch be.op {
+	case "&&":
+		return truthy(be.l.eval(e)) && truthy(be.r.eval(e))
+	case "||":
+		return truthy(be.l.eval(e)) || truthy(be.r.eval(e))
+	case "==", "===":
+		return be.l.eval(e) == be.r.eval(e)
+	default: // "!=", "!=="
+		return be.l.eval(e) != be.r.eval(e)
+	}
+}
+
+type callExpr struct {
+	fn   func(e *env, args []string) interface{}
+	args []expr
+}
+
+func (ce callExpr) eval(e *env) interface{} {
+	args := make([]string, len(ce.args))
+	for i, a := range ce.args {
+		args[i] = fmt.Sprint(a.eval(e))
+	}
+	return ce.fn(e, args)
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		return t != ""
+	}
+	return false
+}
+
+type ifStmt struct {
+	cond      expr
+	then, els stmt
+}
+
+func (is ifStmt) exec(e *env) (string, bool) {
+	if truthy(is.cond.eval(e)) {
+		return is.then.exec(e)
+	}
+	if is.els != nil {
+		return is.els.exec(e)
+	}
+	return "", false
+}
+
+type returnStmt struct{ x expr }
+
+func (rs returnStmt) exec(e *env) (string, bool) {
+	return fmt.Sprint(rs.x.eval(e)), true
+}
+
+type varStmt struct {
+	name string
+	x    expr
+}
+
+func (vs varStmt) exec(e *env) (string, bool) {
+	e.vars[vs.name] = vs.x.eval(e)
+	return "", false
+}
+
+type blockStmt []stmt
+
+func (bs blockStmt) exec(e *env) (string, bool) {
+	for _, s := range bs {
+		if ret, done := s.exec(e); done {
+			return ret, true
+		}
+	}
+	return "", false
+}
+
+type builtin struct {
+	args int
+	fn   func(e *env, args []string) interface{}
+}
+
+var builtins = map[string]builtin{
+	"isPlainHostName": {1, func(_ *env, a []string) interface{} {
+		return !strings.Contains(a[0], ".")
+	}},
+	"dnsDomainIs": {2, func(_ *env, a []string) interface{} {
+		return strings.HasSuffix(strings.ToLower(a[0]), strings.ToLower(a[1]))
+	}},
+	"localHostOrDomainIs": {2, func(_ *env, a []string) interface{} {
+		host, hostdom := strings.ToLower(a[0]), strings.ToLower(a[1])
+		if host == hostdom {
+			return true
+		}
+		return !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+".")
+	}},
+	"shExpMatch": {2, shExpMatch},
+	"isInNet":    {3, isInNet},
+	"isResolvable": {1, func(e *env, a []string) interface{} {
+		return e.resolver.resolve(a[0]) != nil
+	}},
+	"myIpAddress": {0, myIpAddress},
+}
+
+func shExpMatch(_ *env, a []string) interface{} {
+	pattern := regexp.QuoteMeta(a[1])
+	pattern = strings.Replace(pattern, `\*`, ".*", -1)
+	pattern = strings.Replace(pattern, `\?`, ".", -1)
+	matched, err := regexp.MatchString("^"+pattern+"$", a[0])
+	return err == nil && matched
+}
+
+func isInNet(e *env, a []string) interface{} {
+	ip := net.ParseIP(a[0])
+	if ip == nil {
+		ip = e.resolver.resolve(a[0])
+	}
+	pattern, mask := net.ParseIP(a[1]).To4(), net.ParseIP(a[2]).To4()
+	ip = ip.To4()
+	if ip == nil || pattern == nil || mask == nil {
+		return false
+	}
+	return ip.Mask(net.IPMask(mask)).Equal(pattern.Mask(net.IPMask(mask)))
+}
+
+// myIpAddress returns the address the host name of the machine
+// resolves to, or the loopback address if it doesn't resolve, as
+// browsers do
+func myIpAddress(e *env, _ []string) interface{} {
+	if hostname, err := os.Hostname(); err == nil {
+		if ip := e.resolver.resolve(hostname); ip != nil {
+			return ip.String()
+		}
+	}
+	return "127.0.0.1"
+}
+
+// Resolver looks up the addresses of a host
+type Resolver func(host string) ([]net.IP, error)
+
+// resolveTTL is how long the result of a lookup, including a failed
+// one, is used for
+const resolveTTL = 5 * time.Minute
+
+// resolveTimeout bounds each lookup made by the default resolver,
+// since a lookup blocks the request the script is evaluated for
+const resolveTimeout = 2 * time.Second
+
+func lookupIP(host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+type resolved struct {
+	ip      net.IP
+	expires time.Time
+}
+
+// cachingResolver caches the first address, preferring IPv4 ones,
+// each host resolves to
+type cachingResolver struct {
+	lookup Resolver
+
+	mu    sync.Mutex
+	cache map[string]resolved
+}
+
+// resolve returns the address of host, or nil if it doesn't resolve
+func (cr *cachingResolver) resolve(host string) net.IP {
+	host = strings.ToLower(host)
+	now := time.Now()
+	cr.mu.Lock()
+	r, present := cr.cache[host]
+	cr.mu.Unlock()
+	if present && now.Before(r.expires) {
+		return r.ip
+	}
+	r = resolved{expires: now.Add(resolveTTL)}
+	if addrs, err := cr.lookup(host); err == nil {
+		for _, addr := range addrs {
+			if addr.To4() != nil {
+				r.ip = addr
+				break
+			}
+		}
+		if r.ip == nil && len(addrs) > 0 {
+			r.ip = addrs[0]
+		}
+	}
+	cr.mu.Lock()
+	for h, old := range cr.cache {
+		if now.After(old.expires) {
+			delete(cr.cache, h)
+		}
+	}
+	cr.cache[host] = r
+	cr.mu.Unlock()
+	return r.ip
+}
+
+type parser struct {
+	tokens    []token
+	pos       int
+	urlParam  string
+	hostParam string
+	vars      map[string]bool
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, val string) error {
+	t := p.next()
+	if t.kind != kind || (val != "" && t.val != val) {
+		return fmt.Errorf("expected %q, got %q", val, t.val)
+	}
+	return nil
+}
+
+func (p *parser) accept(val string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.val == val {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseFunction() (stmt, error) {
+	if err := p.expect(tokIdent, "function"); err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokIdent, "FindProxyForURL"); err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokPunct, "("); err != nil {
+		return nil, err
+	}
+	urlParam := p.next()
+	if urlParam.kind != tokIdent {
+		return nil, errors.New("expected url parameter name")
+	}
+	if err := p.expect(tokPunct, ","); err != nil {
+		return nil, err
+	}
+	hostParam := p.next()
+	if hostParam.kind != tokIdent {
+		return nil, errors.New("expected host parameter name")
+	}
+	if err := p.expect(tokPunct, ")"); err != nil {
+		return nil, err
+	}
+	p.urlParam, p.hostParam = urlParam.val, hostParam.val
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q after FindProxyForURL", t.val)
+	}
+	return body, nil
+}
+
+func (p *parser) parseBlock() (stmt, error) {
+	if err := p.expect(tokPunct, "{"); err != nil {
+		return nil, err
+	}
+	block := blockStmt{}
+	for !p.accept("}") {
+		if p.peek().kind == tokEOF {
+			return nil, errors.New("unterminated block")
+		}
+		s, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			block = append(block, s)
+		}
+	}
+	return block, nil
+}
+
+func (p *parser) parseStmt() (stmt, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokPunct && t.val == "{":
+		return p.parseBlock()
+	case t.kind == tokPunct && t.val == ";":
+		p.next()
+		return nil, nil
+	case t.kind == tokIdent && t.val == "var":
+		p.next()
+		name := p.next()
+		if name.kind != tokIdent || name.val == p.urlParam || name.val == p.hostParam {
+			return nil, fmt.Errorf("unexpected %q in var statement", name.val)
+		}
+		if _, present := builtins[name.val]; present {
+			return nil, fmt.Errorf("%s can't be redeclared", name.val)
+		}
+		if err := p.expect(tokPunct, "="); err != nil {
+			return nil, err
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		p.accept(";")
+		p.vars[name.val] = true
+		return varStmt{name.val, x}, nil
+	case t.kind == tokIdent && t.val == "return":
+		p.next()
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		p.accept(";")
+		return returnStmt{x}, nil
+	case t.kind == tokIdent && t.val == "if":
+		p.next()
+		if err := p.expect(tokPunct, "("); err != nil {
+			return nil, err
+		}
+		cond, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(tokPunct, ")"); err != nil {
+			return nil, err
+		}
+		is := ifStmt{cond: cond}
+		if is.then, err = p.parseStmt(); err != nil {
+			return nil, err
+		}
+		if is.then == nil {
+			is.then = blockStmt{}
+		}
+		if t := p.peek(); t.kind == tokIdent && t.val == "else" {
+			p.next()
+			if is.els, err = p.parseStmt(); err != nil {
+				return nil, err
+			}
+		}
+		return is, nil
+	}
+	return nil, fmt.Errorf("unsupported statement starting with %q", t.val)
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{"||", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{"&&", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{x}, nil
+	}
+	l, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"===", "!==", "==", "!="} {
+		if p.accept(op) {
+			r, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return binaryExpr{op, l, r}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokString:
+		return stringLit(t.val), nil
+	case t.kind == tokPunct && t.val == "(":
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(tokPunct, ")")
+	case t.kind == tokIdent && t.val == p.urlParam:
+		return paramURL, nil
+	case t.kind == tokIdent && t.val == p.hostParam:
+		return paramHost, nil
+	case t.kind == tokIdent && p.vars[t.val]:
+		return varRef(t.val), nil
+	case t.kind == tokIdent:
+		b, present := builtins[t.val]
+		if !present {
+			return nil, fmt.Errorf("unsupported identifier %q", t.val)
+		}
+		if err := p.expect(tokPunct, "("); err != nil {
+			return nil, err
+		}
+		ce := callExpr{fn: b.fn}
+		for !p.accept(")") {
+			if len(ce.args) > 0 {
+				if err := p.expect(tokPunct, ","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			ce.args = append(ce.args, arg)
+		}
+		if len(ce.args) != b.args {
+			return nil, fmt.Errorf("%s takes %d arguments, got %d", t.val, b.args, len(ce.args))
+		}
+		return ce, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.val)
+}
+
+// Script is a parsed PAC file
+type Script struct {
+	body     stmt
+	resolver *cachingResolver
+}
+
+// Parse parses the contents of a PAC file, host names are resolved
+// using lookup, or the system resolver if it is nil
+func Parse(src string, lookup Resolver) (*Script, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, vars: make(map[string]bool)}
+	body, err := p.parseFunction()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PAC file: %s", err)
+	}
+	if lookup == nil {
+		lookup = lookupIP
+	}
+	return &Script{body, &cachingResolver{lookup: lookup, cache: make(map[string]resolved)}}, nil
+}
+
+// FindProxyForURL evaluates the script for the URL and returns the
+// raw result string, e.g. "PROXY a:8080; DIRECT"
+func (s *Script) FindProxyForURL(u *url.URL) string {
+	ret, _ := s.body.exec(&env{
+		url:      u.String(),
+		host:     u.Hostname(),
+		vars:     make(map[string]interface{}),
+		resolver: s.resolver,
+	})
+	return ret
+}
+
+// Proxy returns the proxy to use for a request, it is suitable for
+// use as http.Transport.Proxy. The first usable directive in the
+// script result is used, a nil URL is returned for DIRECT
+func (s *Script) Proxy(req *http.Request) (*url.URL, error) {
+	result := s.FindProxyForURL(req.URL)
+	for _, directive := range strings.Split(result, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			return nil, nil
+		case "PROXY", "HTTP", "HTTPS":
+			if len(fields) != 2 {
+				continue
+			}
+			scheme := "http"
+			if strings.ToUpper(fields[0]) == "HTTPS" {
+				scheme = "https"
+			}
+			return url.Parse(scheme + "://" + fields[1])
+		}
+	}
+	if result == "" {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("no usable proxy directive in PAC result %q", result)
+}
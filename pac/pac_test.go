@@ -0,0 +1,131 @@
+package pac
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// testResolver resolves the hosts in addrs and counts the lookups
+// made
+type testResolver struct {
+	addrs   map[string]string
+	lookups int
+}
+
+func (tr *testResolver) lookup(host string) ([]net.IP, error) {
+	tr.lookups++
+	addr, present := tr.addrs[host]
+	if !present {
+		return nil, errors.New("no such host")
+	}
+	return []net.IP{net.ParseIP(addr)}, nil
+}
+
+var testScript = `
+// route internal CAs directly
+function FindProxyForURL(url, host) {
+	if (isPlainHostName(host) || dnsDomainIs(host, ".internal"))
+		return "DIRECT";
+	if (shExpMatch(url, "http://ocsp.*.example.com/*") && !(host == "ocsp.bad.example.com")) {
+		return "PROXY a.example.com:8080; DIRECT";
+	} else if (isInNet(host, "10.0.0.0", "255.0.0.0")) {
+		var proxy = 'HTTPS b.example.com:8443';
+		return proxy;
+	}
+	/* everything else */
+	return "SOCKS c.example.com:1080; PROXY d.example.com:3128";
+}
+`
+
+func TestScript(t *testing.T) {
+	resolver := &testResolver{addrs: map[string]string{
+		"ocsp.bad.example.com":  "192.0.2.7",
+		"ocsp.corp.example.org": "10.2.3.4",
+	}}
+	s, err := Parse(testScript, resolver.lookup)
+	if err != nil {
+		t.Fatalf("Failed to parse test script: %s", err)
+	}
+	for _, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{"http://ocsp", ""},
+		{"http://ocsp.ca.internal/abc", ""},
+		{"http://ocsp.a.example.com/abc", "http://a.example.com:8080"},
+		{"http://ocsp.bad.example.com/abc", "http://d.example.com:3128"},
+		{"http://10.1.2.3/abc", "https://b.example.com:8443"},
+		{"http://ocsp.corp.example.org/abc", "https://b.example.com:8443"},
+		{"http://ocsp.corp.example.org/def", "https://b.example.com:8443"},
+		{"http://ocsp.other.com/abc", "http://d.example.com:3128"},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatalf("Failed to parse test URL: %s", err)
+		}
+		proxy, err := s.Proxy(&http.Request{URL: u})
+		if err != nil {
+			t.Fatalf("Failed to find proxy for %q: %s", tc.url, err)
+		}
+		got := ""
+		if proxy != nil {
+			got = proxy.String()
+		}
+		if got != tc.expected {
+			t.Fatalf("Unexpected proxy for %q: wanted %q, got %q", tc.url, tc.expected, got)
+		}
+	}
+	// ocsp.corp.example.org is only looked up once, and
+	// ocsp.other.com fails once
+	if resolver.lookups != 3 {
+		t.Fatalf("Unexpected number of lookups: wanted 3, got %d", resolver.lookups)
+	}
+}
+
+func TestMyIpAddress(t *testing.T) {
+	s, err := Parse(`
+function FindProxyForURL(url, host) {
+	var ip = myIpAddress();
+	if (isInNet(ip, "127.0.0.0", "255.0.0.0") && !isResolvable(host))
+		return "PROXY a.example.com:8080";
+	return "DIRECT";
+}`, (&testResolver{addrs: map[string]string{"known.example.com": "192.0.2.1"}}).lookup)
+	if err != nil {
+		t.Fatalf("Failed to parse test script: %s", err)
+	}
+	for _, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{"http://known.example.com", "DIRECT"},
+		{"http://unknown.example.com", "PROXY a.example.com:8080"},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatalf("Failed to parse test URL: %s", err)
+		}
+		if got := s.FindProxyForURL(u); got != tc.expected {
+			t.Fatalf("Unexpected result for %q: wanted %q, got %q", tc.url, tc.expected, got)
+		}
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	for _, src := range []string{
+		`function FindProxyForURL(url, host) { var a = 1; return "DIRECT"; }`,
+		`function FindProxyForURL(url, host) { var a = "a"; a = "b"; return a; }`,
+		`function FindProxyForURL(url, host) { return a; var a = "a"; }`,
+		`function FindProxyForURL(url, host) { var host = "a"; return host; }`,
+		`function FindProxyForURL(url, host) { if (dnsResolve(host) == "1.1.1.1") return "DIRECT"; }`,
+		`function FindProxyForURL(url, host) { return dnsDomainIs(host); }`,
+		`function FindProxyForURL(url, host) { return "DIRECT"; `,
+		`function Other(url, host) { return "DIRECT"; }`,
+	} {
+		if _, err := Parse(src, nil); err == nil {
+			t.Fatalf("Parse didn't fail for unsupported script: %s", src)
+		}
+	}
+}
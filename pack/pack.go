@@ -0,0 +1,208 @@
+// Package pack reads and writes packed response files, a sorted index
+// of lookup keys followed by the DER responses they map to. Replicas
+// memory map them so that very large response sets can be served
+// without being loaded into memory, and so that they are available as
+// soon as the file is opened
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+const (
+	magic      = "STPLPACK"
+	version    = 2
+	headerSize = 24 // magic, version, count, generated
+	recordSize = 92 // key, offset, length, next update, this update, digest
+)
+
+// Response is a response and the lookup keys, see
+// mcache.RequestKeyFor, of the requests it answers. Digest is the
+// SHA-256 digest of Response, it is computed by Write and returned by
+// Lookup so that it doesn't need to be computed when the response is
+// served
+type Response struct {
+	Keys       [][32]byte
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	Response   []byte
+	Digest     [32]byte
+}
+
+type record struct {
+	key        [32]byte
+	offset     uint64
+	length     uint32
+	nextUpdate int64
+	thisUpdate int64
+	digest     [32]byte
+}
+
+// Write writes a packed file containing responses to w. If a key is
+// listed for more than one response the one with the latest
+// NextUpdate is kept
+func Write(w io.Writer, responses []Response, generated time.Time) error {
+	byKey := map[[32]byte]int{}
+	for i, r := range responses {
+		for _, key := range r.Keys {
+			if j, present := byKey[key]; !present || r.NextUpdate.After(responses[j].NextUpdate) {
+				byKey[key] = i
+			}
+		}
+	}
+	offsets := make([]uint64, len(responses))
+	offset := uint64(headerSize + recordSize*len(byKey))
+	for i, r := range responses {
+		offsets[i] = offset
+		offset += uint64(len(r.Response))
+	}
+	records := make([]record, 0, len(byKey))
+	for key, i := range byKey {
+		r := responses[i]
+		records = append(records, record{key, offsets[i], uint32(len(r.Response)), r.NextUpdate.Unix(), r.ThisUpdate.Unix(), common.Sum256(r.Response)})
+	}
+	sort.Slice(records, func(i, j int) bool { return bytes.Compare(records[i].key[:], records[j].key[:]) < 0 })
+
+	buf := make([]byte, headerSize, headerSize+recordSize*len(records))
+	copy(buf, magic)
+	binary.BigEndian.PutUint32(buf[8:], version)
+	binary.BigEndian.PutUint32(buf[12:], uint32(len(records)))
+	binary.BigEndian.PutUint64(buf[16:], uint64(generated.UnixNano()))
+	for _, r := range records {
+		var rec [recordSize]byte
+		copy(rec[:], r.key[:])
+		binary.BigEndian.PutUint64(rec[32:], r.offset)
+		binary.BigEndian.PutUint32(rec[40:], r.length)
+		binary.BigEndian.PutUint64(rec[44:], uint64(r.nextUpdate))
+		binary.BigEndian.PutUint64(rec[52:], uint64(r.thisUpdate))
+		copy(rec[60:], r.digest[:])
+		buf = append(buf, rec[:]...)
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	for _, r := range responses {
+		if _, err := w.Write(r.Response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// File is a memory mapped packed file
+type File struct {
+	Generated time.Time // when the file was written
+
+	mu    sync.RWMutex
+	data  []byte // nil once closed
+	count int
+}
+
+// Open memory maps a packed file
+func Open(filename string) (*File, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < headerSize {
+		return nil, errors.New("file is too short to be a packed file")
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	pf, err := parse(data)
+	if err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+	return pf, nil
+}
+
+// parse checks the header of a packed file
+func parse(data []byte) (*File, error) {
+	if len(data) < headerSize || string(data[:8]) != magic {
+		return nil, errors.New("not a packed file")
+	}
+	if v := binary.BigEndian.Uint32(data[8:]); v != version {
+		return nil, errors.New("unsupported packed file version")
+	}
+	count := int(binary.BigEndian.Uint32(data[12:]))
+	if uint64(len(data)) < headerSize+recordSize*uint64(count) {
+		return nil, errors.New("packed file index is truncated")
+	}
+	return &File{
+		Generated: time.Unix(0, int64(binary.BigEndian.Uint64(data[16:]))),
+		data:      data,
+		count:     count,
+	}, nil
+}
+
+// Len returns the number of keys in the file
+func (f *File) Len() int {
+	return f.count
+}
+
+// Lookup returns a copy of the response for key, without its Keys,
+// responses whose NextUpdate is before now aren't returned
+func (f *File) Lookup(key [32]byte, now time.Time) (Response, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.data == nil {
+		return Response{}, false
+	}
+	index := f.data[headerSize : headerSize+recordSize*f.count]
+	i := sort.Search(f.count, func(i int) bool {
+		return bytes.Compare(index[i*recordSize:i*recordSize+32], key[:]) >= 0
+	})
+	if i == f.count {
+		return Response{}, false
+	}
+	rec := index[i*recordSize : (i+1)*recordSize]
+	if !bytes.Equal(rec[:32], key[:]) {
+		return Response{}, false
+	}
+	nextUpdate := int64(binary.BigEndian.Uint64(rec[44:]))
+	if now.Unix() >= nextUpdate {
+		return Response{}, false
+	}
+	offset, length := binary.BigEndian.Uint64(rec[32:]), uint64(binary.BigEndian.Uint32(rec[40:]))
+	if offset > uint64(len(f.data)) || length > uint64(len(f.data))-offset {
+		return Response{}, false
+	}
+	resp := Response{
+		ThisUpdate: time.Unix(int64(binary.BigEndian.Uint64(rec[52:])), 0),
+		NextUpdate: time.Unix(nextUpdate, 0),
+		Response:   make([]byte, length),
+	}
+	copy(resp.Response, f.data[offset:offset+length])
+	copy(resp.Digest[:], rec[60:])
+	return resp, true
+}
+
+// Close unmaps the file, waiting for any lookups in progress
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(f.data)
+	f.data = nil
+	return err
+}
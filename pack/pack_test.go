@@ -0,0 +1,64 @@
+package pack
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+func TestPackedFile(t *testing.T) {
+	now := time.Now()
+	a, b, c, missing := [32]byte{1}, [32]byte{2}, [32]byte{3}, [32]byte{4}
+	responses := []Response{
+		{Keys: [][32]byte{a, b}, ThisUpdate: now.Add(-time.Hour), NextUpdate: now.Add(time.Hour), Response: []byte("first")},
+		{Keys: [][32]byte{c}, NextUpdate: now.Add(-time.Hour), Response: []byte("expired")},
+		// a duplicate of b which expires sooner is dropped
+		{Keys: [][32]byte{b}, NextUpdate: now.Add(time.Minute), Response: []byte("duplicate")},
+	}
+	f, err := ioutil.TempFile("", "pack")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile failed: %s", err)
+	}
+	defer os.Remove(f.Name())
+	if err = Write(f, responses, now); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	f.Close()
+
+	pf, err := Open(f.Name())
+	if err != nil {
+		t.Fatalf("Open failed: %s", err)
+	}
+	if pf.Len() != 3 || !pf.Generated.Equal(time.Unix(0, now.UnixNano())) {
+		t.Fatalf("Unexpected header: %d keys generated %s", pf.Len(), pf.Generated)
+	}
+	for _, key := range [][32]byte{a, b} {
+		resp, ok := pf.Lookup(key, now)
+		if !ok || !bytes.Equal(resp.Response, []byte("first")) {
+			t.Fatalf("Unexpected response for %x: %q %t", key[0], resp.Response, ok)
+		}
+		if resp.Digest != common.Sum256([]byte("first")) || resp.ThisUpdate.Unix() != now.Add(-time.Hour).Unix() || resp.NextUpdate.Unix() != now.Add(time.Hour).Unix() {
+			t.Fatalf("Unexpected values for %x: %+v", key[0], resp)
+		}
+	}
+	if _, ok := pf.Lookup(c, now); ok {
+		t.Fatal("Expired response was returned")
+	}
+	if _, ok := pf.Lookup(missing, now); ok {
+		t.Fatal("Response was returned for a missing key")
+	}
+	if err = pf.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+	if _, ok := pf.Lookup(a, now); ok {
+		t.Fatal("Response was returned after the file was closed")
+	}
+
+	if _, err = parse([]byte("STPLPACK\x00\x00\x00\x02\x00\x00\x00\x09\x00\x00\x00\x00\x00\x00\x00\x00")); err == nil {
+		t.Fatal("parse accepted a truncated index")
+	}
+}
@@ -0,0 +1,174 @@
+package stapled
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/mcache"
+	"github.com/rolandshoemaker/stapled/pack"
+)
+
+// packExport writes the packed file read by replicas, see pack.Write
+type packExport struct {
+	path     string
+	interval time.Duration
+	digest   [32]byte // of the responses last written
+}
+
+// WithPackExport periodically writes the current responses to a
+// packed file at path, which replicas serve using
+// WithPackedResponses. The file is replaced atomically and only when
+// a response has changed
+func WithPackExport(path string, interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		s.packExport = &packExport{path: path, interval: interval}
+		return nil
+	}
+}
+
+// packDigest identifies a set of packed responses so that the packed
+// file is only rewritten when a response changes
+func packDigest(responses []pack.Response) [32]byte {
+	h := common.NewSHA256()
+	for _, r := range responses {
+		for _, key := range r.Keys {
+			h.Write(key[:])
+		}
+		h.Write(r.Response)
+	}
+	var digest [32]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// writePack writes the packed file if the responses have changed since
+// it was last written
+func (s *Server) writePack() error {
+	pe := s.packExport
+	responses := s.c.PackResponses()
+	digest := packDigest(responses)
+	if digest == pe.digest {
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	if err := pack.Write(buf, responses, s.clk.Now()); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(pe.path, buf.Bytes()); err != nil {
+		return err
+	}
+	pe.digest = digest
+	s.log.Info("[pack] Wrote %d responses to '%s'", len(responses), pe.path)
+	return nil
+}
+
+func (s *Server) watchPackExport() {
+	if err := s.writePack(); err != nil {
+		s.log.Err("[pack] Failed to write packed file '%s': %s", s.packExport.path, err)
+	}
+	ticker := time.NewTicker(s.packExport.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.writePack(); err != nil {
+				s.log.Err("[pack] Failed to write packed file '%s': %s", s.packExport.path, err)
+			}
+		}
+	}
+}
+
+// packedResponses is the generation of a packed file being served
+type packedResponses struct {
+	path     string
+	interval time.Duration
+
+	mu      sync.RWMutex
+	file    *pack.File // nil until a generation has been loaded
+	modTime time.Time
+	size    int64
+}
+
+// WithPackedResponses serves the responses in the packed file at path,
+// written by a primary using WithPackExport, for requests that aren't
+// in the cache. The file is memory mapped rather than read, and is
+// checked for a new generation every interval, which replaces the
+// current one atomically
+func WithPackedResponses(path string, interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			interval = 10 * time.Second
+		}
+		s.packed = &packedResponses{path: path, interval: interval}
+		return nil
+	}
+}
+
+// lookupPacked returns the response for key from the packed file
+func (s *Server) lookupPacked(key mcache.RequestKey) (mcache.CachedResponse, bool) {
+	s.packed.mu.RLock()
+	defer s.packed.mu.RUnlock()
+	if s.packed.file == nil {
+		return mcache.CachedResponse{}, false
+	}
+	resp, present := s.packed.file.Lookup(key, s.clk.Now())
+	return mcache.CachedResponse{Response: resp.Response, Digest: resp.Digest, ThisUpdate: resp.ThisUpdate, NextUpdate: resp.NextUpdate}, present
+}
+
+// loadPacked maps the packed file if it has changed since the current
+// generation was loaded, the old generation is unmapped once any
+// lookups using it have finished
+func (s *Server) loadPacked() error {
+	p := s.packed
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	p.mu.RLock()
+	unchanged := p.file != nil && fi.ModTime().Equal(p.modTime) && fi.Size() == p.size
+	p.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	file, err := pack.Open(p.path)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	old := p.file
+	p.file, p.modTime, p.size = file, fi.ModTime(), fi.Size()
+	p.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	s.log.Info("[pack] Loaded %d responses from '%s', generated %s", file.Len(), p.path, file.Generated)
+	return nil
+}
+
+func (s *Server) watchPacked() {
+	ticker := time.NewTicker(s.packed.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			s.packed.mu.Lock()
+			if s.packed.file != nil {
+				s.packed.file.Close()
+			}
+			s.packed.mu.Unlock()
+			return
+		case <-ticker.C:
+			if err := s.loadPacked(); err != nil {
+				s.log.Err("[pack] Failed to load packed file '%s': %s", s.packed.path, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package stapled
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+func TestPackedResponses(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	dir, err := ioutil.TempDir("", "packed")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "staples.pack")
+
+	if err = WithPackExport(path, time.Minute)(tf.s); err != nil {
+		t.Fatalf("WithPackExport failed: %s", err)
+	}
+	if err = tf.s.writePack(); err != nil {
+		t.Fatalf("writePack failed: %s", err)
+	}
+
+	c := mcache.NewEntryCache(tf.fc, tf.s.log, time.Minute, nil, new(http.Client), time.Minute, nil, everyHash, true)
+	replica, err := NewServer(WithCache(c), WithLogger(tf.s.log), WithClock(tf.fc), WithPackedResponses(path, 0))
+	if err != nil {
+		t.Fatalf("NewServer failed: %s", err)
+	}
+	if err = replica.loadPacked(); err != nil {
+		t.Fatalf("loadPacked failed: %s", err)
+	}
+	defer func() { replica.packed.file.Close() }()
+	first := replica.packed.file
+
+	r := httptest.NewRequest("GET", "/"+base64.StdEncoding.EncodeToString(tf.request), nil)
+	w := httptest.NewRecorder()
+	replica.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), tf.response) {
+		t.Fatal("Replica returned unexpected response")
+	}
+	if eTag := w.Header().Get("ETag"); eTag != responseETag(tf.response) {
+		t.Fatalf("Unexpected ETag: %q", eTag)
+	}
+
+	// an unchanged file isn't reloaded, a rewritten one is
+	if err = replica.loadPacked(); err != nil {
+		t.Fatalf("loadPacked failed: %s", err)
+	}
+	if replica.packed.file != first {
+		t.Fatal("Unchanged packed file was reloaded")
+	}
+	tf.s.packExport.digest = [32]byte{}
+	if err = tf.s.writePack(); err != nil {
+		t.Fatalf("writePack failed: %s", err)
+	}
+	later := tf.fc.Now().Add(time.Minute)
+	if err = os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("Failed to change modification time: %s", err)
+	}
+	if err = replica.loadPacked(); err != nil {
+		t.Fatalf("loadPacked failed: %s", err)
+	}
+	if replica.packed.file == first {
+		t.Fatal("Rewritten packed file wasn't reloaded")
+	}
+
+	// responses past their next update aren't served
+	req, err := ocsp.ParseRequest(tf.request)
+	if err != nil {
+		t.Fatalf("Failed to parse request: %s", err)
+	}
+	tf.fc.Add(time.Hour * 25)
+	if _, present := replica.lookupPacked(mcache.RequestKeyFor(req)); present {
+		t.Fatal("Expired response was served from the packed file")
+	}
+}
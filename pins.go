@@ -0,0 +1,118 @@
+package stapled
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+// responderPins maps responder hosts, and with a leading '.' domains,
+// to the SHA-256 hashes of the subject public key infos, at least one
+// of which must be in the chain presented by a https responder
+type responderPins map[string][][]byte
+
+// parseResponderPins parses a map of hosts to base64 encoded SHA-256
+// hashes of subject public key infos, which may be prefixed with
+// "sha256/" as in the HPKP pin-sha256 form
+func parseResponderPins(pins map[string][]string) (responderPins, error) {
+	parsed := make(responderPins, len(pins))
+	for host, hashes := range pins {
+		if len(hashes) == 0 {
+			return nil, fmt.Errorf("host '%s' has no pins", host)
+		}
+		for _, h := range hashes {
+			hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(h, "sha256/"))
+			if err != nil || len(hash) != 32 {
+				return nil, fmt.Errorf("pin '%s' for host '%s' isn't a base64 encoded SHA-256 hash", h, host)
+			}
+			key := strings.ToLower(host)
+			parsed[key] = append(parsed[key], hash)
+		}
+	}
+	return parsed, nil
+}
+
+// matchHost returns the key matching host for which present returns
+// true, the host itself takes precedence over its domains, which are
+// keyed with a leading '.', the most specific first
+func matchHost(host string, present func(key string) bool) (string, bool) {
+	host = strings.ToLower(host)
+	if present(host) {
+		return host, true
+	}
+	for domain := host; ; {
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			return "", false
+		}
+		domain = domain[i+1:]
+		if present("." + domain) {
+			return "." + domain, true
+		}
+	}
+}
+
+// lookup returns the pins for host, see matchHost
+func (rp responderPins) lookup(host string) [][]byte {
+	key, matched := matchHost(host, func(key string) bool {
+		_, present := rp[key]
+		return present
+	})
+	if !matched {
+		return nil
+	}
+	return rp[key]
+}
+
+// check checks the chain presented by host, whose connection state is
+// state or nil if it wasn't reached over https, contains a pinned key
+// if it has any pins
+func (rp responderPins) check(host string, state *tls.ConnectionState) error {
+	pins := rp.lookup(host)
+	if pins == nil {
+		return nil
+	}
+	if state == nil {
+		return fmt.Errorf("'%s' has pinned keys but wasn't reached over https", host)
+	}
+	for _, cert := range state.PeerCertificates {
+		hash := common.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(hash[:], pin) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no certificate presented by '%s' has a pinned key", host)
+}
+
+// checkPins checks the response to a upstream request against the
+// pins of the host it was sent to, the body of responses that fail
+// is closed
+func (us *upstreamSettings) checkPins(req *http.Request, resp *http.Response, err error) (*http.Response, error) {
+	if err != nil || us == nil || len(us.pins) == 0 {
+		return resp, err
+	}
+	if err = us.pins.check(req.URL.Hostname(), resp.TLS); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// pinnedTransport checks the responses of next against the pins of
+// upstream, see ReloadableTransport for the transport the cache uses
+type pinnedTransport struct {
+	next     http.RoundTripper
+	upstream *upstreamSettings
+}
+
+func (pt *pinnedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := pt.next.RoundTrip(req)
+	return pt.upstream.checkPins(req, resp, err)
+}
@@ -0,0 +1,75 @@
+package stapled
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponderPins(t *testing.T) {
+	responder := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer responder.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(responder.Certificate())
+	hash := sha256.Sum256(responder.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(hash[:])
+	other := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	url := responder.URL
+	get := func(pins map[string][]string) error {
+		parsed, err := parseResponderPins(pins)
+		if err != nil {
+			t.Fatalf("Failed to parse pins: %s", err)
+		}
+		resp, err := newClient(nil, &upstreamSettings{responderTLS: &tls.Config{RootCAs: roots}, pins: parsed}).Get(url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err := get(map[string][]string{"127.0.0.1": {other, "sha256/" + pin}}); err != nil {
+		t.Fatalf("Request to responder with a pinned key failed: %s", err)
+	}
+	if err := get(map[string][]string{"127.0.0.1": {other}}); err == nil {
+		t.Fatal("Request to responder without a pinned key succeeded")
+	}
+	if err := get(map[string][]string{"ocsp.example.com": {other}}); err != nil {
+		t.Fatalf("Request to responder without any pins failed: %s", err)
+	}
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	url = plain.URL
+	if err := get(map[string][]string{"127.0.0.1": {pin}}); err == nil {
+		t.Fatal("Request to pinned host over http succeeded")
+	}
+
+	pins, err := parseResponderPins(map[string][]string{"OCSP.example.com": {pin}, ".example.com": {other}})
+	if err != nil {
+		t.Fatalf("Failed to parse pins: %s", err)
+	}
+	for host, expected := range map[string]string{
+		"ocsp.example.com":   pin,
+		"a.ocsp.example.com": other,
+		"example.com":        "",
+		"ocsp.example.org":   "",
+	} {
+		matched := pins.lookup(host)
+		if (expected == "") != (matched == nil) || (matched != nil && base64.StdEncoding.EncodeToString(matched[0]) != expected) {
+			t.Fatalf("Unexpected pins for '%s': %v", host, matched)
+		}
+	}
+	for _, bad := range []map[string][]string{
+		{"ocsp.example.com": {}},
+		{"ocsp.example.com": {"not base64"}},
+		{"ocsp.example.com": {base64.StdEncoding.EncodeToString([]byte{1, 2, 3})}},
+	} {
+		if _, err := parseResponderPins(bad); err == nil {
+			t.Fatalf("Invalid pins were accepted: %v", bad)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package stapled
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/pac"
+)
+
+// drainDelay is how long connections made by a replaced transport
+// are given to finish before they are closed
+var drainDelay = time.Minute
+
+// ReloadableTransport is a http.RoundTripper whose proxies and
+// responder rewrites can be replaced while it is being used, so
+// they can be changed without restarting
+type ReloadableTransport struct {
+	transport *http.Transport
+	rewrites  map[string]string // responder URL prefix -> replacement
+	upstream  *upstreamSettings // kept when the proxies are reloaded
+	mu        sync.RWMutex
+}
+
+// pacFile is a PAC file which is parsed again when its modification
+// time or size changes
+type pacFile struct {
+	filename string
+
+	mu      sync.Mutex
+	script  *pac.Script
+	modTime time.Time
+	size    int64
+}
+
+// load returns the parsed script, parsing the file again if it has
+// changed since it was last parsed. If it can't be read or parsed
+// the script previously parsed is used until it can be
+func (pf *pacFile) load() (*pac.Script, error) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	info, err := os.Stat(pf.filename)
+	if err == nil && pf.script != nil && info.ModTime().Equal(pf.modTime) && info.Size() == pf.size {
+		return pf.script, nil
+	}
+	var script *pac.Script
+	if err == nil {
+		var contents []byte
+		if contents, err = ioutil.ReadFile(pf.filename); err == nil {
+			script, err = pac.Parse(string(contents), nil)
+		}
+	}
+	if err != nil {
+		if pf.script != nil {
+			return pf.script, nil
+		}
+		return nil, err
+	}
+	pf.script, pf.modTime, pf.size = script, info.ModTime(), info.Size()
+	return pf.script, nil
+}
+
+// proxy selects the proxy for req using the current script
+func (pf *pacFile) proxy(req *http.Request) (*url.URL, error) {
+	script, err := pf.load()
+	if err != nil {
+		return nil, err
+	}
+	return script.Proxy(req)
+}
+
+// newTransport creates the transport used for upstream requests, if
+// upstream is nil the defaults are used
+func newTransport(proxyFunc func(*http.Request) (*url.URL, error), upstream *upstreamSettings) *http.Transport {
+	var dial dialFunc
+	if upstream != nil {
+		dial = upstream.dial
+	}
+	if dial == nil {
+		dial = newDialer(nil).DialContext
+	}
+	t := &http.Transport{
+		DialContext:         dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	upstream.configure(t, proxyFunc)
+	return t
+}
+
+// NewReloadableTransport creates a ReloadableTransport using
+// proxyFunc to select proxies and rewrites to replace responder URL
+// prefixes
+func NewReloadableTransport(proxyFunc func(*http.Request) (*url.URL, error), rewrites map[string]string) *ReloadableTransport {
+	return newReloadableTransport(proxyFunc, rewrites, nil)
+}
+
+func newReloadableTransport(proxyFunc func(*http.Request) (*url.URL, error), rewrites map[string]string, upstream *upstreamSettings) *ReloadableTransport {
+	return &ReloadableTransport{
+		transport: newTransport(proxyFunc, upstream),
+		rewrites:  rewrites,
+		upstream:  upstream,
+	}
+}
+
+// Reload replaces the proxies and rewrites, requests which are in
+// flight finish using the old proxies and the connections they used
+// are closed once drained
+func (rt *ReloadableTransport) Reload(proxyFunc func(*http.Request) (*url.URL, error), rewrites map[string]string) {
+	rt.mu.Lock()
+	old := rt.transport
+	rt.transport = newTransport(proxyFunc, rt.upstream)
+	rt.rewrites = rewrites
+	rt.mu.Unlock()
+	old.CloseIdleConnections()
+	time.AfterFunc(drainDelay, old.CloseIdleConnections)
+}
+
+// withProxy returns a transport with the same rewrites and upstream
+// settings as rt which sends every request through proxy
+func (rt *ReloadableTransport) withProxy(proxy *url.URL) *ReloadableTransport {
+	rt.mu.RLock()
+	rewrites := rt.rewrites
+	rt.mu.RUnlock()
+	return newReloadableTransport(http.ProxyURL(proxy), rewrites, rt.upstream)
+}
+
+// rewrite returns the URL a request should be sent to, if a rewrite
+// prefix matches the URL the longest match is replaced
+func rewrite(u *url.URL, rewrites map[string]string) (*url.URL, error) {
+	original := u.String()
+	match := ""
+	for prefix := range rewrites {
+		if strings.HasPrefix(original, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return u, nil
+	}
+	return url.Parse(rewrites[match] + strings.TrimPrefix(original, match))
+}
+
+// RoundTrip implements http.RoundTripper
+func (rt *ReloadableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.RLock()
+	transport, rewrites := rt.transport, rt.rewrites
+	rt.mu.RUnlock()
+	if len(rewrites) > 0 {
+		u, err := rewrite(req.URL, rewrites)
+		if err != nil {
+			return nil, err
+		}
+		if u != req.URL {
+			rewritten := new(http.Request)
+			*rewritten = *req
+			rewritten.URL = u
+			rewritten.Host = ""
+			req = rewritten
+		}
+	}
+	resp, err := transport.RoundTrip(req)
+	return rt.upstream.checkPins(req, resp, err)
+}
+
+// ReloadProxies replaces the proxies and responder rewrites used to
+// fetch responses with the ones in conf. Watch folders with their
+// own proxies are not affected
+func (s *Server) ReloadProxies(conf *config.Configuration) error {
+	if s.transport == nil {
+		return errors.New("proxies can't be reloaded, the cache client doesn't use a ReloadableTransport")
+	}
+	proxyFunc, err := proxySource(conf)
+	if err != nil {
+		return err
+	}
+	s.transport.Reload(proxyFunc, conf.Fetcher.ResponderRewrites)
+	if s.gameDay != nil {
+		if err := s.gameDay.setProxies(conf.Fetcher.Proxies); err != nil {
+			return err
+		}
+	}
+	s.log.Info("[fetcher] Reloaded proxy configuration")
+	return nil
+}
@@ -0,0 +1,239 @@
+package stapled
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRewrite(t *testing.T) {
+	rewrites := map[string]string{
+		"http://ocsp.example.com":     "http://mirror.internal",
+		"http://ocsp.example.com/int": "http://int-mirror.internal/ocsp",
+	}
+	for _, test := range []struct {
+		in, out string
+	}{
+		{"http://ocsp.example.com/MEkw", "http://mirror.internal/MEkw"},
+		{"http://ocsp.example.com/int/MEkw", "http://int-mirror.internal/ocsp/MEkw"},
+		{"http://ocsp.other.com/MEkw", "http://ocsp.other.com/MEkw"},
+	} {
+		u, _ := url.Parse(test.in)
+		rewritten, err := rewrite(u, rewrites)
+		if err != nil {
+			t.Fatalf("rewrite failed: %s", err)
+		}
+		if rewritten.String() != test.out {
+			t.Fatalf("Expected '%s' to be rewritten to '%s', got '%s'", test.in, test.out, rewritten)
+		}
+	}
+}
+
+func TestReloadableTransport(t *testing.T) {
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+	}))
+	defer srv.Close()
+
+	rt := NewReloadableTransport(nil, nil)
+	client := &http.Client{Transport: rt}
+	resp, err := client.Get(srv.URL + "/a")
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	resp.Body.Close()
+	rt.Reload(nil, map[string]string{"http://responder.invalid": srv.URL + "/b"})
+	resp, err = client.Get("http://responder.invalid/c")
+	if err != nil {
+		t.Fatalf("Rewritten request failed: %s", err)
+	}
+	resp.Body.Close()
+	if hits["/a"] != 1 || hits["/b/c"] != 1 {
+		t.Fatalf("Unexpected requests: %v", hits)
+	}
+}
+
+func TestPACFileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stapled-pac")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "proxy.pac")
+	write := func(result string, modTime time.Time) {
+		src := `function FindProxyForURL(url, host) { return "` + result + `"; }`
+		if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+			t.Fatalf("Failed to write PAC file: %s", err)
+		}
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set PAC file modification time: %s", err)
+		}
+	}
+	now := time.Now()
+	write("PROXY a.example.com:8080", now.Add(-time.Hour))
+	pf := &pacFile{filename: filename}
+	req := &http.Request{URL: &url.URL{Scheme: "http", Host: "ocsp.example.com"}}
+	if proxy, err := pf.proxy(req); err != nil || proxy.String() != "http://a.example.com:8080" {
+		t.Fatalf("Unexpected proxy: got %v, %v", proxy, err)
+	}
+
+	write("PROXY b.example.com:8080", now)
+	if proxy, err := pf.proxy(req); err != nil || proxy.String() != "http://b.example.com:8080" {
+		t.Fatalf("PAC file wasn't reloaded: got %v, %v", proxy, err)
+	}
+
+	// a file which can't be parsed is ignored
+	if err := ioutil.WriteFile(filename, []byte("function"), 0644); err != nil {
+		t.Fatalf("Failed to write PAC file: %s", err)
+	}
+	if proxy, err := pf.proxy(req); err != nil || proxy.String() != "http://b.example.com:8080" {
+		t.Fatalf("Previous PAC file wasn't used: got %v, %v", proxy, err)
+	}
+}
+
+func TestLocalAddrDial(t *testing.T) {
+	remotes := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		remotes <- host
+	}))
+	defer srv.Close()
+
+	get := func(dial dialFunc) string {
+		client := newClient(nil, &upstreamSettings{dial: dial})
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err)
+		}
+		resp.Body.Close()
+		return <-remotes
+	}
+	dial, err := localAddrDial("127.0.0.1", nil)
+	if err != nil {
+		t.Fatalf("localAddrDial failed: %s", err)
+	}
+	if remote := get(dial); remote != "127.0.0.1" {
+		t.Fatalf("Connection wasn't made from local-addr, got %s", remote)
+	}
+	dial, err = localAddrDial("127.0.0.1", map[string]string{srv.URL: "127.0.0.2"})
+	if err != nil {
+		t.Fatalf("localAddrDial failed: %s", err)
+	}
+	if remote := get(dial); remote != "127.0.0.2" {
+		t.Fatalf("Connection wasn't made from the override, got %s", remote)
+	}
+
+	if _, err = localAddrDial("not-an-interface", nil); err == nil {
+		t.Fatal("localAddrDial didn't fail for a unknown interface")
+	}
+	for in, out := range map[string]string{
+		"http://ocsp.example.com":       "ocsp.example.com:80",
+		"https://ocsp.example.com/ocsp": "ocsp.example.com:443",
+		"http://127.0.0.1:8080":         "127.0.0.1:8080",
+		"http://[::1]":                  "[::1]:80",
+	} {
+		if hp, err := hostPort(in); err != nil || hp != out {
+			t.Fatalf("Expected hostPort(%q) to return %q, got %q (%v)", in, out, hp, err)
+		}
+	}
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	responder := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("response"))
+	}))
+	defer responder.Close()
+	tunnels := 0
+	proxy := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			t.Errorf("Unexpected proxy request method %s", r.Method)
+			return
+		}
+		tunnels++
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		go func() {
+			io.Copy(upstream, buf)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer proxy.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(responder.Certificate())
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyFunc := func(*http.Request) (*url.URL, error) { return proxyURL, nil }
+
+	get := func(upstream *upstreamSettings) error {
+		resp, err := newClient(proxyFunc, upstream).Get(responder.URL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil || string(body) != "response" {
+			t.Fatalf("Unexpected response: %q (%v)", body, err)
+		}
+		return nil
+	}
+	if err := get(nil); err == nil {
+		t.Fatal("Request succeeded without trusting the proxy or responder")
+	}
+	tunnels = 0
+	err := get(&upstreamSettings{
+		proxyTLS:     &tls.Config{RootCAs: roots},
+		responderTLS: &tls.Config{RootCAs: roots},
+	})
+	if err != nil {
+		t.Fatalf("Request through a https proxy failed: %s", err)
+	}
+	if tunnels != 1 {
+		t.Fatalf("Expected request to be tunneled through the proxy, got %d tunnels", tunnels)
+	}
+
+	tunnels = 0
+	err = get(&upstreamSettings{
+		responderTLS: &tls.Config{RootCAs: roots},
+		noProxy:      []string{"127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("Request to a excluded host failed: %s", err)
+	}
+	if tunnels != 0 {
+		t.Fatal("Request to a excluded host was proxied")
+	}
+
+	for host, excluded := range map[string]bool{
+		"ocsp.internal":        true,
+		"a.corp.example":       true,
+		"corp.example":         true,
+		"notcorp.example":      false,
+		"ocsp.example.com":     false,
+		"ocsp.internal.evil.x": false,
+	} {
+		if proxyExcluded(host, []string{"OCSP.internal", ".corp.example"}) != excluded {
+			t.Fatalf("Expected proxyExcluded(%q) to return %t", host, excluded)
+		}
+	}
+}
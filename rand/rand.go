@@ -0,0 +1,84 @@
+// Package rand provides the randomness stapled uses to spread out
+// refreshes and backoffs and to pick between responders and proxies.
+// By default it is read from crypto/rand, so that instances started
+// at the same time don't make the same choices, and tests can replace
+// it with a deterministic source using SetSource
+package rand
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+// cryptoSource is a math/rand source which reads from crypto/rand,
+// falling back to a math/rand source seeded at start up if it fails
+type cryptoSource struct {
+	fallback mrand.Source64
+}
+
+func newCryptoSource() *cryptoSource {
+	seed := time.Now().UnixNano()
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err == nil {
+		seed = int64(binary.LittleEndian.Uint64(b[:]))
+	}
+	return &cryptoSource{fallback: mrand.NewSource(seed).(mrand.Source64)}
+}
+
+func (cs *cryptoSource) Uint64() uint64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return cs.fallback.Uint64()
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (cs *cryptoSource) Int63() int64 {
+	return int64(cs.Uint64() >> 1)
+}
+
+// Seed does nothing, crypto/rand can't be seeded
+func (cs *cryptoSource) Seed(int64) {}
+
+var (
+	mu sync.Mutex // sources other than cryptoSource aren't safe for concurrent use
+	r  = mrand.New(newCryptoSource())
+)
+
+// SetSource replaces the source of randomness with src, returning a
+// function which restores the previous source
+func SetSource(src mrand.Source) (restore func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	prev := r
+	r = mrand.New(src)
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		r = prev
+	}
+}
+
+// Int63n returns a random number in [0, n), it panics if n <= 0
+func Int63n(n int64) int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return r.Int63n(n)
+}
+
+// Intn returns a random number in [0, n), it panics if n <= 0
+func Intn(n int) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return r.Intn(n)
+}
+
+// Float64 returns a random number in [0.0, 1.0)
+func Float64() float64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return r.Float64()
+}
@@ -0,0 +1,34 @@
+package rand
+
+import (
+	mrand "math/rand"
+	"testing"
+)
+
+func TestSetSource(t *testing.T) {
+	draw := func() []int64 {
+		restore := SetSource(mrand.NewSource(1))
+		defer restore()
+		return []int64{Int63n(1000), int64(Intn(1000)), int64(Float64() * 1000)}
+	}
+	first, second := draw(), draw()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Expected the same source to give the same numbers, got %v and %v", first, second)
+		}
+	}
+}
+
+func TestCryptoSource(t *testing.T) {
+	seen := make(map[int64]bool)
+	for i := 0; i < 100; i++ {
+		n := Int63n(1 << 62)
+		if n < 0 || n >= 1<<62 {
+			t.Fatalf("Int63n returned %d, which is out of range", n)
+		}
+		seen[n] = true
+	}
+	if len(seen) < 99 {
+		t.Fatalf("Expected 100 random numbers to be distinct, got %d distinct numbers", len(seen))
+	}
+}
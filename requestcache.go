@@ -0,0 +1,99 @@
+package stapled
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+// WithRequestCache remembers the parsed requests for the last size
+// distinct GET paths so that clients which repeat the same request,
+// as web servers stapling responses do, skip decoding, parsing, and
+// hashing it
+func WithRequestCache(size int) Option {
+	return func(s *Server) error {
+		if size < 0 {
+			return fmt.Errorf("invalid request cache size %d", size)
+		}
+		if size > 0 {
+			s.requests = newRequestCache(size)
+		}
+		return nil
+	}
+}
+
+// parsedRequest is a OCSP request along with its lookup key
+type parsedRequest struct {
+	request *ocsp.Request
+	key     mcache.RequestKey
+}
+
+func newParsedRequest(request *ocsp.Request) *parsedRequest {
+	return &parsedRequest{request: request, key: mcache.RequestKeyFor(request)}
+}
+
+// requestCache is a LRU cache of GET paths to the requests they
+// contain
+type requestCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // most recently used first
+	paths map[string]*list.Element
+}
+
+type requestCacheItem struct {
+	path    string
+	request *parsedRequest
+}
+
+func newRequestCache(size int) *requestCache {
+	return &requestCache{
+		size:  size,
+		order: list.New(),
+		paths: make(map[string]*list.Element, size),
+	}
+}
+
+func (rc *requestCache) get(path string) (*parsedRequest, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	elem, present := rc.paths[path]
+	if !present {
+		return nil, false
+	}
+	rc.order.MoveToFront(elem)
+	return elem.Value.(*requestCacheItem).request, true
+}
+
+func (rc *requestCache) add(path string, pr *parsedRequest) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if elem, present := rc.paths[path]; present {
+		elem.Value.(*requestCacheItem).request = pr
+		rc.order.MoveToFront(elem)
+		return
+	}
+	rc.paths[path] = rc.order.PushFront(&requestCacheItem{path, pr})
+	if rc.order.Len() > rc.size {
+		oldest := rc.order.Back()
+		rc.order.Remove(oldest)
+		delete(rc.paths, oldest.Value.(*requestCacheItem).path)
+	}
+}
+
+func (rc *requestCache) len() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.order.Len()
+}
+
+// cacheableGET checks if a request is a GET without a body, which
+// are the only requests whose path is cached
+func cacheableGET(r *http.Request) bool {
+	return r.Method == "GET" && r.ContentLength <= 0 && len(r.TransferEncoding) == 0
+}
@@ -0,0 +1,152 @@
+package stapled
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rootsFile is a PEM file of CA certificates which is loaded again
+// when its modification time or size changes
+type rootsFile struct {
+	filename string
+
+	mu      sync.Mutex
+	pool    *x509.CertPool
+	modTime time.Time
+	size    int64
+}
+
+// load returns the certificates in the file, reading it again if it
+// has changed since it was last read. If it can't be read, for
+// instance because it is being replaced, the certificates previously
+// read are used until it can be
+func (rf *rootsFile) load() (*x509.CertPool, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	info, err := os.Stat(rf.filename)
+	if err == nil && rf.pool != nil && info.ModTime().Equal(rf.modTime) && info.Size() == rf.size {
+		return rf.pool, nil
+	}
+	var pool *x509.CertPool
+	if err == nil {
+		pool, err = loadCertPool(rf.filename)
+	}
+	if err != nil {
+		if rf.pool != nil {
+			return rf.pool, nil
+		}
+		return nil, err
+	}
+	rf.pool, rf.modTime, rf.size = pool, info.ModTime(), info.Size()
+	return pool, nil
+}
+
+// responderRoots maps https responder hosts, and with a leading '.'
+// domains, to the CA certificates trusted for them in place of those
+// trusted for every responder, see matchHost
+type responderRoots map[string]*rootsFile
+
+// parseResponderRoots loads a map of hosts to PEM files of CA
+// certificates
+func parseResponderRoots(files map[string]string) (responderRoots, error) {
+	roots := make(responderRoots, len(files))
+	for host, filename := range files {
+		rf := &rootsFile{filename: filename}
+		if _, err := rf.load(); err != nil {
+			return nil, fmt.Errorf("CA certificates for host '%s': %s", host, err)
+		}
+		roots[strings.ToLower(host)] = rf
+	}
+	return roots, nil
+}
+
+// pool returns the CA certificates trusted for host, or nil if it
+// doesn't have its own
+func (rr responderRoots) pool(host string) (*x509.CertPool, error) {
+	key, matched := matchHost(host, func(key string) bool {
+		_, present := rr[key]
+		return present
+	})
+	if !matched {
+		return nil, nil
+	}
+	return rr[key].load()
+}
+
+// hostConfig returns the config used to verify the responder at
+// addr when it isn't reached through a proxy, base, nil meaning the
+// system roots, unless it has its own CA certificates
+func (rr responderRoots) hostConfig(addr string, base *tls.Config) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := rr.pool(host)
+	if err != nil || roots == nil {
+		return base, err
+	}
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+	config.RootCAs = roots
+	return config, nil
+}
+
+// config returns a copy of base, nil meaning the system roots, which
+// verifies each responder using its own CA certificates if it has
+// any, for connections whose config can't be picked for each host,
+// those tunneled through a proxy. Verification
+// is done by VerifyConnection using the name sent in SNI, so
+// responders addressed by IP address can't be verified this way
+func (rr responderRoots) config(base *tls.Config) *tls.Config {
+	if len(rr) == 0 || (base != nil && base.InsecureSkipVerify) {
+		return base
+	}
+	config := &tls.Config{}
+	var fallback *x509.CertPool
+	if base != nil {
+		config = base.Clone()
+		fallback = base.RootCAs
+	}
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		return rr.verify(cs, fallback)
+	}
+	return config
+}
+
+// verify verifies the chain presented by a responder against its own
+// CA certificates, or fallback if it doesn't have any
+func (rr responderRoots) verify(cs tls.ConnectionState, fallback *x509.CertPool) error {
+	if cs.ServerName == "" {
+		return errors.New("responders addressed by IP address can't be verified through a proxy when fetcher.responder-cas is set")
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("responder didn't present a certificate")
+	}
+	roots, err := rr.pool(cs.ServerName)
+	if err != nil {
+		return err
+	}
+	if roots == nil {
+		roots = fallback
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
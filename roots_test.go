@@ -0,0 +1,118 @@
+package stapled
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResponderRoots(t *testing.T) {
+	responder := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer responder.Close()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "other"}, IsCA: true, BasicConstraintsValid: true}
+	otherDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "stapled-roots")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	modTime := time.Now()
+	write := func(der []byte) {
+		if err := ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile failed: %s", err)
+		}
+		// make sure the change is noticed on filesystems with coarse
+		// modification times
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(bundle, modTime, modTime); err != nil {
+			t.Fatalf("os.Chtimes failed: %s", err)
+		}
+	}
+	get := func(roots responderRoots) error {
+		resp, err := newClient(nil, &upstreamSettings{roots: roots}).Get(responder.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	write(responder.Certificate().Raw)
+	roots, err := parseResponderRoots(map[string]string{"127.0.0.1": bundle})
+	if err != nil {
+		t.Fatalf("Failed to load roots: %s", err)
+	}
+	if err := get(roots); err != nil {
+		t.Fatalf("Request to responder trusted by its roots failed: %s", err)
+	}
+	write(otherDER)
+	if err := get(roots); err == nil {
+		t.Fatal("Request succeeded after the roots were replaced")
+	}
+	if err := ioutil.WriteFile(bundle, []byte("not PEM"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+	if err := get(roots); err == nil {
+		t.Fatal("Request succeeded after the roots were replaced with a invalid file")
+	}
+	write(responder.Certificate().Raw)
+	if err := get(roots); err != nil {
+		t.Fatalf("Request failed after the roots were restored: %s", err)
+	}
+
+	other, err := parseResponderRoots(map[string]string{".example.com": bundle})
+	if err != nil {
+		t.Fatalf("Failed to load roots: %s", err)
+	}
+	if err := get(other); err == nil {
+		t.Fatal("Request to responder without its own roots succeeded without trusting the system roots")
+	}
+
+	// connections tunneled through proxies are verified using the
+	// name sent in SNI
+	dial := func(serverName string, roots responderRoots) error {
+		config := roots.config(nil).Clone()
+		config.ServerName = serverName
+		conn, err := tls.Dial("tcp", responder.Listener.Addr().String(), config)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	named, err := parseResponderRoots(map[string]string{"example.com": bundle})
+	if err != nil {
+		t.Fatalf("Failed to load roots: %s", err)
+	}
+	if err := dial("example.com", named); err != nil {
+		t.Fatalf("Connection to responder trusted by its roots failed: %s", err)
+	}
+	if err := dial("example.com", other); err == nil {
+		t.Fatal("Connection to responder without its own roots succeeded without trusting the system roots")
+	}
+	if err := dial("127.0.0.1", roots); err == nil {
+		t.Fatal("Connection to responder addressed by IP address succeeded")
+	}
+	if _, err := parseResponderRoots(map[string]string{"127.0.0.1": filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Fatal("Missing roots file was accepted")
+	}
+}
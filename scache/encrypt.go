@@ -0,0 +1,81 @@
+package scache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+// encryptedMagic prefixes encrypted response files, it is followed
+// by the nonce and then the AES-GCM sealed response
+var encryptedMagic = []byte("stapled-aesgcm-v1\n")
+
+// ParseKey parses a hex or base64 encoded 16, 24, or 32 byte AES key
+func ParseKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("key must be hex or base64 encoded")
+		}
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, errors.New("key must be 16, 24, or 32 bytes")
+}
+
+// NewEncryptedDisk creates a DiskCache that encrypts responses using
+// AES-GCM with key and a random nonce per file. The name of each file
+// is authenticated along with its contents so that files can't be
+// swapped. Unencrypted files written before encryption was enabled
+// are still read, and are replaced with encrypted files the next
+// time the response is written
+func NewEncryptedDisk(logger *log.Logger, clk clock.Clock, path string, key []byte) (*DiskCache, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	dc := NewDisk(logger, clk, path)
+	dc.aead = aead
+	return dc, nil
+}
+
+func isEncrypted(contents []byte) bool {
+	return bytes.HasPrefix(contents, encryptedMagic)
+}
+
+func (dc *DiskCache) encrypt(name string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, dc.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, encryptedMagic...), nonce...)
+	return dc.aead.Seal(sealed, nonce, plaintext, []byte(path.Base(name))), nil
+}
+
+func (dc *DiskCache) decrypt(name string, contents []byte) ([]byte, error) {
+	contents = contents[len(encryptedMagic):]
+	if len(contents) < dc.aead.NonceSize() {
+		return nil, errors.New("encrypted response is truncated")
+	}
+	nonce, ciphertext := contents[:dc.aead.NonceSize()], contents[dc.aead.NonceSize():]
+	return dc.aead.Open(nil, nonce, ciphertext, []byte(path.Base(name)))
+}
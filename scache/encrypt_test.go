@@ -0,0 +1,83 @@
+package scache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+func TestParseKey(t *testing.T) {
+	for encoded, valid := range map[string]bool{
+		strings.Repeat("ab", 32):                       true,
+		strings.Repeat("ab", 16) + "\n":                true,
+		"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=": true,
+		strings.Repeat("ab", 20):                       false,
+		"not a key":                                    false,
+	} {
+		if _, err := ParseKey(encoded); (err == nil) != valid {
+			t.Fatalf("Unexpected result parsing %q: %v", encoded, err)
+		}
+	}
+}
+
+func TestEncryptedDiskCache(t *testing.T) {
+	testResp, testRespBytes, issuer := testResponse(t)
+	fc := clock.NewFake()
+	fc.Set(testResp.ThisUpdate.Add(time.Hour))
+	logger := log.NewLogger("", "", 10, fc)
+	tmpDir, err := ioutil.TempDir("", "stapled-encrypted")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dc, err := NewEncryptedDisk(logger, fc, tmpDir, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("NewEncryptedDisk failed: %s", err)
+	}
+	tf := &testFailer{}
+	dc.failer = tf
+
+	dc.Write("a", testRespBytes)
+	contents, err := ioutil.ReadFile(filepath.Join(tmpDir, "a.resp"))
+	if err != nil {
+		t.Fatalf("Failed to read written response: %s", err)
+	}
+	if !isEncrypted(contents) || bytes.Contains(contents, testRespBytes) {
+		t.Fatal("Written response wasn't encrypted")
+	}
+	if resp, respBytes := dc.Read("a", testResp.SerialNumber, issuer); tf.failed || resp == nil || !bytes.Equal(respBytes, testRespBytes) {
+		t.Fatal("Failed to read encrypted response")
+	}
+
+	// files are bound to their names
+	if err = ioutil.WriteFile(filepath.Join(tmpDir, "b.resp"), contents, 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	if dc.Read("b", testResp.SerialNumber, issuer); !tf.failed {
+		t.Fatal("Read didn't fail for a encrypted response with a different name")
+	}
+
+	// without the key the response can't be read
+	tf.failed = false
+	plain := NewDisk(logger, fc, tmpDir)
+	plain.failer = tf
+	if plain.Read("a", testResp.SerialNumber, issuer); !tf.failed {
+		t.Fatal("Read didn't fail for a encrypted response without a key")
+	}
+
+	// unencrypted responses can still be read
+	tf.failed = false
+	plain.Write("c", testRespBytes)
+	if resp, _ := dc.Read("c", testResp.SerialNumber, issuer); tf.failed || resp == nil {
+		t.Fatal("Failed to read unencrypted response")
+	}
+}
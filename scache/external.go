@@ -0,0 +1,260 @@
+package scache
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"os/exec"
+	"plugin"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/log"
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+)
+
+// logFailer logs failures without exiting, it is used by backings
+// whose failures are usually transient
+type logFailer struct{}
+
+func (logFailer) Fail(logger *log.Logger, msg string) {
+	logger.Err("%s", msg)
+}
+
+// PluginSymbol is the function Go plugins loaded by LoadPlugin must
+// export, with the type func(map[string]string) (scache.Cache, error)
+const PluginSymbol = "NewCache"
+
+// LoadPlugin opens the Go plugin at path and calls its NewCache
+// function with options to create a Cache. The plugin must be built
+// with the same version of Go and of this package as stapled
+func LoadPlugin(path string, options map[string]string) (Cache, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	newCache, ok := sym.(func(map[string]string) (Cache, error))
+	if !ok {
+		return nil, fmt.Errorf("plugin '%s' exports %s with type %T, expected func(map[string]string) (scache.Cache, error)", path, PluginSymbol, sym)
+	}
+	return newCache(options)
+}
+
+// ProcessCache is a stable cache backed by a helper process which is
+// sent a request on each line of its stdin and replies with a line
+// on its stdout. The requests are
+//
+//	read <name>
+//	write <name> <base64 response>
+//
+// and the replies are "ok", followed by the base64 response for
+// reads, "miss" if a read found no response, or "error <message>".
+// Names never contain whitespace. The process is started on the
+// first request, and is killed and restarted on the next request if
+// it exits, fails to reply within the timeout, or replies with
+// something that can't be parsed. Unlike DiskCache failures are
+// logged rather than stopping stapled, since they are usually
+// transient
+type ProcessCache struct {
+	logger  *log.Logger
+	clk     clock.Clock
+	command []string
+	timeout time.Duration
+	failer  common.Failer
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// defaultProcessTimeout is how long a helper process is given to
+// reply if no timeout is set
+const defaultProcessTimeout = 10 * time.Second
+
+// NewProcess creates a ProcessCache which runs command, the first
+// element of which is the program and the rest its arguments. If
+// timeout is zero the default of ten seconds is used
+func NewProcess(logger *log.Logger, clk clock.Clock, command []string, timeout time.Duration) (*ProcessCache, error) {
+	if len(command) == 0 {
+		return nil, errors.New("no command to run")
+	}
+	if timeout <= 0 {
+		timeout = defaultProcessTimeout
+	}
+	return &ProcessCache{
+		logger:  logger,
+		clk:     clk,
+		command: command,
+		timeout: timeout,
+		failer:  logFailer{},
+	}, nil
+}
+
+// start starts the helper process, pc.mu must be held
+func (pc *ProcessCache) start() error {
+	cmd := exec.Command(pc.command[0], pc.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	pc.cmd, pc.stdin, pc.stdout = cmd, stdin, bufio.NewReader(stdout)
+	pc.logger.Info("[process-cache] Started '%s' (pid %d)", pc.command[0], cmd.Process.Pid)
+	return nil
+}
+
+// kill stops the helper process, pc.mu must be held and any reads of
+// its stdout must have finished
+func (pc *ProcessCache) kill() {
+	if pc.cmd == nil {
+		return
+	}
+	pc.stdin.Close()
+	pc.cmd.Process.Kill()
+	pc.cmd.Wait()
+	pc.cmd = nil
+}
+
+// Close stops the helper process, it is restarted if the cache is
+// used again
+func (pc *ProcessCache) Close() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.kill()
+}
+
+// call sends request to the helper process and returns its reply
+// with the status split from the rest of the line
+func (pc *ProcessCache) call(request string) (string, string, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.cmd == nil {
+		if err := pc.start(); err != nil {
+			return "", "", fmt.Errorf("failed to start '%s': %s", pc.command[0], err)
+		}
+	}
+	type reply struct {
+		line string
+		err  error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		if _, err := io.WriteString(pc.stdin, request+"\n"); err != nil {
+			replies <- reply{err: err}
+			return
+		}
+		line, err := pc.stdout.ReadString('\n')
+		replies <- reply{line, err}
+	}()
+	var r reply
+	select {
+	case r = <-replies:
+	case <-time.After(pc.timeout):
+		pc.stdin.Close()
+		pc.cmd.Process.Kill()
+		<-replies
+		r.err = fmt.Errorf("no reply within %s", pc.timeout)
+	}
+	if r.err != nil {
+		pc.kill()
+		return "", "", r.err
+	}
+	status, rest := strings.TrimSuffix(r.line, "\n"), ""
+	if i := strings.IndexByte(status, ' '); i >= 0 {
+		status, rest = status[:i], status[i+1:]
+	}
+	switch status {
+	case "ok", "miss":
+		return status, rest, nil
+	case "error":
+		return "", "", errors.New(rest)
+	}
+	pc.kill()
+	return "", "", fmt.Errorf("unexpected reply %q", r.line)
+}
+
+// checkName checks that name can be sent to the helper process
+func checkName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("name %q is empty or contains whitespace", name)
+	}
+	return nil
+}
+
+// Read reads a OCSP response from the helper process
+func (pc *ProcessCache) Read(name string, serial *big.Int, issuer *x509.Certificate) (*ocsp.Response, []byte) {
+	if err := checkName(name); err != nil {
+		pc.failer.Fail(pc.logger, fmt.Sprintf("[process-cache] Failed to read response: %s", err))
+		return nil, nil
+	}
+	status, encoded, err := pc.call("read " + name)
+	if err != nil {
+		pc.failer.Fail(pc.logger, fmt.Sprintf("[process-cache] Failed to read response for '%s': %s", name, err))
+		return nil, nil
+	}
+	if status == "miss" {
+		return nil, nil
+	}
+	response, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		pc.failer.Fail(pc.logger, fmt.Sprintf("[process-cache] Failed to decode response for '%s': %s", name, err))
+		return nil, nil
+	}
+	parsed, err := stapledOCSP.ParseResponse(pc.clk.Now(), response, issuer)
+	if err != nil {
+		pc.failer.Fail(pc.logger, fmt.Sprintf("[process-cache] Failed to parse response for '%s': %s", name, err))
+		return nil, nil
+	}
+	if err = stapledOCSP.VerifyResponse(pc.clk.Now(), serial, parsed); err != nil {
+		pc.failer.Fail(pc.logger, fmt.Sprintf("[process-cache] Failed to verify response for '%s': %s", name, err))
+		return nil, nil
+	}
+	pc.logger.Info("[process-cache] Loaded valid response for '%s'", name)
+	return parsed, response
+}
+
+// Write writes a OCSP response using the helper process
+func (pc *ProcessCache) Write(name string, content []byte) {
+	if err := pc.WriteChecked(name, content); err != nil {
+		pc.failer.Fail(pc.logger, fmt.Sprintf("[process-cache] %s", err))
+	}
+}
+
+// WriteChecked writes a OCSP response using the helper process,
+// returning any error instead of failing, see CheckedWriter
+func (pc *ProcessCache) WriteChecked(name string, content []byte) error {
+	if err := checkName(name); err != nil {
+		return fmt.Errorf("failed to write response: %s", err)
+	}
+	status, _, err := pc.call("write " + name + " " + base64.StdEncoding.EncodeToString(content))
+	if err != nil {
+		return fmt.Errorf("failed to write response for '%s': %s", name, err)
+	}
+	if status != "ok" {
+		return fmt.Errorf("failed to write response for '%s': unexpected reply '%s'", name, status)
+	}
+	pc.logger.Info("[process-cache] Written new response for '%s'", name)
+	return nil
+}
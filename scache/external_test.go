@@ -0,0 +1,86 @@
+package scache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+// TestHelperProcess isn't a real test, it is run by TestProcessCache
+// as the helper process and stores responses in memory
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("STAPLED_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	stored := map[string]string{}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 2 && fields[0] == "read" && fields[1] == "hang":
+			time.Sleep(time.Minute)
+		case len(fields) == 2 && fields[0] == "read":
+			if response, present := stored[fields[1]]; present {
+				fmt.Printf("ok %s\n", response)
+			} else {
+				fmt.Println("miss")
+			}
+		case len(fields) == 3 && fields[0] == "write":
+			stored[fields[1]] = fields[2]
+			fmt.Println("ok")
+		default:
+			fmt.Println("error bad request")
+		}
+	}
+	os.Exit(0)
+}
+
+func TestProcessCache(t *testing.T) {
+	testResp, testRespBytes, issuer := testResponse(t)
+	fc := clock.NewFake()
+	fc.Set(testResp.ThisUpdate.Add(time.Hour))
+	logger := log.NewLogger("", "", 10, fc)
+
+	os.Setenv("STAPLED_WANT_HELPER_PROCESS", "1")
+	defer os.Unsetenv("STAPLED_WANT_HELPER_PROCESS")
+	pc, err := NewProcess(logger, fc, []string{os.Args[0], "-test.run=TestHelperProcess"}, time.Second)
+	if err != nil {
+		t.Fatalf("NewProcess failed: %s", err)
+	}
+	defer pc.Close()
+	tf := &testFailer{}
+	pc.failer = tf
+
+	if resp, _ := pc.Read("test", testResp.SerialNumber, issuer); resp != nil || tf.failed {
+		t.Fatal("Read didn't miss before a response was written")
+	}
+	if err = pc.WriteChecked("test", testRespBytes); err != nil {
+		t.Fatalf("WriteChecked failed: %s", err)
+	}
+	resp, respBytes := pc.Read("test", testResp.SerialNumber, issuer)
+	if tf.failed || resp == nil || !bytes.Equal(respBytes, testRespBytes) {
+		t.Fatal("Failed to read the written response")
+	}
+	if err = pc.WriteChecked("has space", testRespBytes); err == nil {
+		t.Fatal("WriteChecked didn't fail with a name containing whitespace")
+	}
+
+	// a process that doesn't reply is killed, and a new one, which has
+	// lost the stored responses, is started for the next request
+	if resp, _ = pc.Read("hang", testResp.SerialNumber, issuer); resp != nil || !tf.failed {
+		t.Fatal("Read didn't fail when the process didn't reply")
+	}
+	tf.failed = false
+	if resp, _ = pc.Read("test", testResp.SerialNumber, issuer); resp != nil || tf.failed {
+		t.Fatal("Read didn't miss after the process was restarted")
+	}
+}
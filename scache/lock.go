@@ -0,0 +1,72 @@
+package scache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locker is implemented by stable caches that are shared between
+// multiple instances, it is used to elect a single instance to fetch
+// each response from upstream while the others read it from the
+// shared cache
+type Locker interface {
+	// Lock attempts to take, or renew, the lease on refreshing name
+	// for owner, it returns true if owner holds the lease
+	Lock(name, owner string, lease time.Duration) bool
+}
+
+// Lock implements Locker using lease files stored next to the
+// responses, which requires the cache folder to be shared between
+// instances. Leases are best effort, if two instances take a expired
+// lease at the same moment both may refresh the response
+func (dc *DiskCache) Lock(name, owner string, lease time.Duration) bool {
+	name = path.Join(dc.path, name) + ".lock"
+	now := dc.clk.Now()
+	if contents, err := ioutil.ReadFile(name); err == nil {
+		holder, expires := parseLease(contents)
+		if holder != owner && now.Before(expires) {
+			return false
+		}
+	} else if !os.IsNotExist(err) {
+		dc.logger.Warning("[disk-cache] Failed to read lease '%s', refreshing anyway: %s", name, err)
+		return true
+	}
+	tmpName := fmt.Sprintf("%s.%s.tmp", name, owner)
+	lock := fmt.Sprintf("%s %d\n", owner, now.Add(lease).UnixNano())
+	if err := ioutil.WriteFile(tmpName, []byte(lock), 0644); err != nil {
+		dc.logger.Warning("[disk-cache] Failed to write lease '%s', refreshing anyway: %s", name, err)
+		return true
+	}
+	if err := os.Rename(tmpName, name); err != nil {
+		os.Remove(tmpName)
+		dc.logger.Warning("[disk-cache] Failed to write lease '%s', refreshing anyway: %s", name, err)
+		return true
+	}
+	// another instance may have taken the lease between reading and
+	// writing it, the last one to write it holds it
+	contents, err := ioutil.ReadFile(name)
+	if err != nil {
+		return true
+	}
+	holder, _ := parseLease(contents)
+	return holder == owner
+}
+
+// parseLease parses the contents of a lease file, a malformed lease
+// is treated as expired
+func parseLease(contents []byte) (string, time.Time) {
+	fields := strings.Fields(string(contents))
+	if len(fields) != 2 {
+		return "", time.Time{}
+	}
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}
+	}
+	return fields[0], time.Unix(0, expires)
+}
@@ -0,0 +1,42 @@
+package scache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+func TestDiskCacheLock(t *testing.T) {
+	fc := clock.NewFake()
+	tmpDir, err := ioutil.TempDir("", "stapled-lock")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dc := NewDisk(log.NewLogger("", "", 10, fc), fc, tmpDir)
+
+	if !dc.Lock("test", "a", time.Minute) {
+		t.Fatal("Failed to take unheld lease")
+	}
+	if dc.Lock("test", "b", time.Minute) {
+		t.Fatal("Took lease held by another instance")
+	}
+	if !dc.Lock("test", "a", time.Minute) {
+		t.Fatal("Failed to renew held lease")
+	}
+	if !dc.Lock("other", "b", time.Minute) {
+		t.Fatal("Failed to take lease on another entry")
+	}
+	fc.Add(time.Minute + time.Second)
+	if !dc.Lock("test", "b", time.Minute) {
+		t.Fatal("Failed to take expired lease")
+	}
+	if dc.Lock("test", "a", time.Minute) {
+		t.Fatal("Took lease after it was taken by another instance")
+	}
+}
@@ -0,0 +1,246 @@
+package scache
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/cloud"
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/log"
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+)
+
+// maxS3ObjectSize is the largest object that will be read, far larger
+// than any OCSP response
+const maxS3ObjectSize = 1 << 20
+
+// S3Options describe the bucket a S3Cache stores responses in
+type S3Options struct {
+	Bucket string
+	Prefix string // prepended to the name of each object
+	Region string
+	// Endpoint defaults to https://s3.<Region>.amazonaws.com, objects
+	// are addressed using path style requests so that other S3
+	// compatible stores can be used
+	Endpoint    string
+	Credentials cloud.AWSCredentials
+	Client      *http.Client
+	Timeout     time.Duration // for each request, 10 seconds by default
+	// ConsistencyWindow is how long a response written by this
+	// instance is preferred to a older or missing object, which a
+	// eventually consistent store may return shortly after a write,
+	// one minute by default
+	ConsistencyWindow time.Duration
+}
+
+// writtenResponse is a response S3Cache recently wrote
+type writtenResponse struct {
+	response []byte
+	written  time.Time
+}
+
+// S3Cache is a stable cache storing each response as a object named
+// <prefix><name>.resp in a S3 compatible bucket, so instances without
+// a shared filesystem start with the responses other instances have
+// fetched. Failures are logged rather than stopping stapled, and a
+// object that is missing or older than a response this instance wrote
+// within the consistency window is ignored in favor of the written
+// response
+type S3Cache struct {
+	logger   *log.Logger
+	clk      clock.Clock
+	opts     S3Options
+	endpoint *url.URL
+	failer   common.Failer
+
+	mu      sync.Mutex
+	written map[string]writtenResponse
+}
+
+// NewS3 creates a S3Cache
+func NewS3(logger *log.Logger, clk clock.Clock, opts S3Options) (*S3Cache, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", opts.Region)
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse endpoint: %s", err)
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.ConsistencyWindow <= 0 {
+		opts.ConsistencyWindow = time.Minute
+	}
+	return &S3Cache{
+		logger:   logger,
+		clk:      clk,
+		opts:     opts,
+		endpoint: endpoint,
+		failer:   logFailer{},
+		written:  make(map[string]writtenResponse),
+	}, nil
+}
+
+// objectURL returns the URL of the object for name
+func (sc *S3Cache) objectURL(name string) *url.URL {
+	u := *sc.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + sc.opts.Bucket + "/" + sc.opts.Prefix + name + ".resp"
+	u.RawPath = ""
+	return &u
+}
+
+// do makes a signed request for the object for name, the body of the
+// response must be closed
+func (sc *S3Cache) do(method, name string, body []byte) (*http.Response, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sc.opts.Timeout)
+	req, err := http.NewRequest(method, sc.objectURL(name).String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/ocsp-response")
+	}
+	req.Header.Set("X-Amz-Content-Sha256", fmt.Sprintf("%x", common.Sum256(body)))
+	cloud.SignV4(req, body, sc.opts.Credentials, sc.opts.Region, "s3", sc.clk.Now())
+	resp, err := sc.opts.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return resp, cancel, nil
+}
+
+// get returns the contents of the object for name, or nil if it
+// doesn't exist
+func (sc *S3Cache) get(name string) ([]byte, error) {
+	resp, cancel, err := sc.do("GET", name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxS3ObjectSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > maxS3ObjectSize {
+			return nil, fmt.Errorf("object is larger than %d bytes", maxS3ObjectSize)
+		}
+		return body, nil
+	case http.StatusNotFound:
+		return nil, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+}
+
+// verify parses and verifies response
+func (sc *S3Cache) verify(response []byte, serial *big.Int, issuer *x509.Certificate) (*ocsp.Response, error) {
+	parsed, err := stapledOCSP.ParseResponse(sc.clk.Now(), response, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response: %s", err)
+	}
+	if err = stapledOCSP.VerifyResponse(sc.clk.Now(), serial, parsed); err != nil {
+		return nil, fmt.Errorf("failed to verify response: %s", err)
+	}
+	return parsed, nil
+}
+
+// recentlyWritten returns the response written for name within the
+// consistency window, if there is one
+func (sc *S3Cache) recentlyWritten(name string) (writtenResponse, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	wr, present := sc.written[name]
+	if !present || sc.clk.Now().Sub(wr.written) > sc.opts.ConsistencyWindow {
+		return writtenResponse{}, false
+	}
+	return wr, true
+}
+
+// Read reads a OCSP response from the bucket, the response most
+// recently written for name is used instead if the object read is
+// missing or older, as long as it verifies
+func (sc *S3Cache) Read(name string, serial *big.Int, issuer *x509.Certificate) (*ocsp.Response, []byte) {
+	var parsed *ocsp.Response
+	response, err := sc.get(name)
+	if err != nil {
+		sc.failer.Fail(sc.logger, fmt.Sprintf("[s3-cache] Failed to read response for '%s': %s", name, err))
+	} else if response != nil {
+		if parsed, err = sc.verify(response, serial, issuer); err != nil {
+			sc.logger.Warning("[s3-cache] Ignoring response for '%s': %s", name, err)
+		}
+	}
+	if wr, present := sc.recentlyWritten(name); present {
+		written, err := sc.verify(wr.response, serial, issuer)
+		if err == nil && (parsed == nil || parsed.ThisUpdate.Before(written.ThisUpdate)) {
+			sc.logger.Info("[s3-cache] Object for '%s' is missing or older than the response written %s ago, using the written response", name, sc.clk.Now().Sub(wr.written))
+			return written, wr.response
+		}
+	}
+	if parsed == nil {
+		return nil, nil
+	}
+	sc.logger.Info("[s3-cache] Loaded valid response for '%s'", name)
+	return parsed, response
+}
+
+// Write writes a OCSP response to the bucket
+func (sc *S3Cache) Write(name string, content []byte) {
+	if err := sc.WriteChecked(name, content); err != nil {
+		sc.failer.Fail(sc.logger, fmt.Sprintf("[s3-cache] %s", err))
+	}
+}
+
+// WriteChecked writes a OCSP response to the bucket, returning any
+// error instead of failing, see CheckedWriter
+func (sc *S3Cache) WriteChecked(name string, content []byte) error {
+	resp, cancel, err := sc.do("PUT", name, content)
+	if err != nil {
+		return fmt.Errorf("failed to write response for '%s': %s", name, err)
+	}
+	defer cancel()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to write response for '%s': unexpected status %d: %s", name, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	wr := writtenResponse{response: content, written: sc.clk.Now()}
+	sc.mu.Lock()
+	sc.written[name] = wr
+	for n, w := range sc.written {
+		if wr.written.Sub(w.written) > sc.opts.ConsistencyWindow {
+			delete(sc.written, n)
+		}
+	}
+	sc.mu.Unlock()
+	sc.logger.Info("[s3-cache] Written new response for '%s'", name)
+	return nil
+}
@@ -0,0 +1,99 @@
+package scache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+// testBucket is a in memory S3 bucket, while lagging is set writes
+// are accepted but not stored, as a eventually consistent store may
+// do shortly after a write
+type testBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	lagging bool
+}
+
+func (tb *testBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	switch r.Method {
+	case "GET":
+		object, present := tb.objects[r.URL.Path]
+		if !present {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(object)
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		if !tb.lagging {
+			tb.objects[r.URL.Path] = body
+		}
+	}
+}
+
+func TestS3Cache(t *testing.T) {
+	testResp, testRespBytes, issuer := testResponse(t)
+	fc := clock.NewFake()
+	fc.Set(testResp.ThisUpdate.Add(time.Hour))
+	logger := log.NewLogger("", "", 10, fc)
+	bucket := &testBucket{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+
+	sc, err := NewS3(logger, fc, S3Options{Bucket: "staples", Prefix: "a/", Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewS3 failed: %s", err)
+	}
+	if resp, _ := sc.Read("test", testResp.SerialNumber, issuer); resp != nil {
+		t.Fatal("Read returned a response before one was written")
+	}
+	if err = sc.WriteChecked("test", testRespBytes); err != nil {
+		t.Fatalf("WriteChecked failed: %s", err)
+	}
+	if !bytes.Equal(bucket.objects["/staples/a/test.resp"], testRespBytes) {
+		t.Fatal("Response wasn't written to the expected object")
+	}
+	// a new instance reads the response from the bucket
+	other, err := NewS3(logger, fc, S3Options{Bucket: "staples", Prefix: "a/", Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewS3 failed: %s", err)
+	}
+	if resp, respBytes := other.Read("test", testResp.SerialNumber, issuer); resp == nil || !bytes.Equal(respBytes, testRespBytes) {
+		t.Fatal("Failed to read the written response")
+	}
+
+	// a write the store doesn't return yet is read from memory until
+	// the consistency window has passed
+	bucket.lagging = true
+	if err = sc.WriteChecked("lagging", testRespBytes); err != nil {
+		t.Fatalf("WriteChecked failed: %s", err)
+	}
+	if resp, _ := sc.Read("lagging", testResp.SerialNumber, issuer); resp == nil {
+		t.Fatal("Read didn't return the written response while the object was missing")
+	}
+	fc.Add(2 * time.Minute)
+	if resp, _ := sc.Read("lagging", testResp.SerialNumber, issuer); resp != nil {
+		t.Fatal("Read returned the written response after the consistency window passed")
+	}
+
+	bucket.objects["/staples/a/huge.resp"] = make([]byte, maxS3ObjectSize+1)
+	if _, err = sc.get("huge"); err == nil {
+		t.Fatal("get read a object larger than maxS3ObjectSize")
+	}
+}
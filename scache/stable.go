@@ -1,12 +1,16 @@
 package scache
 
 import (
+	"crypto/cipher"
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"math/big"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/jmhodges/clock"
 	"golang.org/x/crypto/ocsp"
@@ -22,17 +26,36 @@ type Cache interface {
 	Write(string, []byte)
 }
 
+// CheckedWriter is implemented by stable caches that can report why
+// a write failed, so that it can be retried, see WriteBehind
+type CheckedWriter interface {
+	WriteChecked(name string, content []byte) error
+}
+
+// Unwrap returns the cache wrapped by c, if c wraps another cache, so
+// that the interfaces it implements, such as Locker, can be found
+func Unwrap(c Cache) Cache {
+	for {
+		w, ok := c.(interface{ Unwrap() Cache })
+		if !ok {
+			return c
+		}
+		c = w.Unwrap()
+	}
+}
+
 // DiskCache is a on disk stable cache
 type DiskCache struct {
 	logger *log.Logger
 	clk    clock.Clock
 	path   string
 	failer common.Failer
+	aead   cipher.AEAD // if set responses are encrypted, see NewEncryptedDisk
 }
 
 // NewDisk creates a DiskCache
 func NewDisk(logger *log.Logger, clk clock.Clock, path string) *DiskCache {
-	return &DiskCache{logger, clk, path, &common.BasicFailer{}}
+	return &DiskCache{logger: logger, clk: clk, path: path, failer: &common.BasicFailer{}}
 }
 
 // Read reads a OCSP response from disk
@@ -45,7 +68,20 @@ func (dc *DiskCache) Read(name string, serial *big.Int, issuer *x509.Certificate
 	} else if err != nil {
 		return nil, nil // no file exists yet
 	}
-	parsed, err := ocsp.ParseResponse(response, issuer)
+	if isEncrypted(response) {
+		if dc.aead == nil {
+			dc.failer.Fail(dc.logger, fmt.Sprintf("[disk-cache] Response in '%s' is encrypted but no encryption key is configured", name))
+			return nil, nil
+		}
+		response, err = dc.decrypt(name, response)
+		if err != nil {
+			dc.failer.Fail(dc.logger, fmt.Sprintf("[disk-cache] Failed to decrypt response from '%s': %s", name, err))
+			return nil, nil
+		}
+	} else if dc.aead != nil {
+		dc.logger.Warning("[disk-cache] Response in '%s' isn't encrypted, it will be encrypted when it is next written", name)
+	}
+	parsed, err := stapledOCSP.ParseResponse(dc.clk.Now(), response, issuer)
 	if err != nil {
 		dc.failer.Fail(dc.logger, fmt.Sprintf("[disk-cache] Failed to parse response from '%s': %s", name, err))
 		return nil, nil
@@ -61,19 +97,73 @@ func (dc *DiskCache) Read(name string, serial *big.Int, issuer *x509.Certificate
 
 // Write writes a OCSP response to disk
 func (dc *DiskCache) Write(name string, content []byte) {
+	if err := dc.WriteChecked(name, content); err != nil {
+		dc.failer.Fail(dc.logger, fmt.Sprintf("[disk-cache] %s", err))
+	}
+}
+
+// WriteChecked writes a OCSP response to disk, returning any error
+// instead of failing, see CheckedWriter
+func (dc *DiskCache) WriteChecked(name string, content []byte) error {
 	name = path.Join(dc.path, name) + ".resp"
 	tmpName := fmt.Sprintf("%s.tmp", name)
+	if dc.aead != nil {
+		var err error
+		content, err = dc.encrypt(name, content)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt response for '%s': %s", name, err)
+		}
+	}
+	// names may contain directories, see mcache.ParseResponseName
+	if dir := path.Dir(name); dir != path.Clean(dc.path) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory '%s': %s", dir, err)
+		}
+	}
 	err := ioutil.WriteFile(tmpName, content, os.ModePerm)
 	if err != nil {
-		dc.failer.Fail(dc.logger, fmt.Sprintf("[disk-cache] Failed to write response to '%s': %s", tmpName, err))
-		return
+		return fmt.Errorf("failed to write response to '%s': %s", tmpName, err)
 	}
 	err = os.Rename(tmpName, name)
 	if err != nil {
 		os.Remove(tmpName) // silently attempt to remove temporary file
-		dc.failer.Fail(dc.logger, fmt.Sprintf("[disk-cache] Failed to rename '%s' to '%s': %s", tmpName, name, err))
-		return
+		return fmt.Errorf("failed to rename '%s' to '%s': %s", tmpName, name, err)
 	}
 	dc.logger.Info("[disk-cache] Written new response to '%s'", name)
-	return
+	return nil
+}
+
+// List returns the names of the responses stored in the cache folder,
+// relative to it and without the .resp suffix, sorted
+func (dc *DiskCache) List() ([]string, error) {
+	var names []string
+	err := filepath.Walk(dc.path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || !strings.HasSuffix(p, ".resp") {
+			return nil
+		}
+		rel, err := filepath.Rel(dc.path, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, strings.TrimSuffix(filepath.ToSlash(rel), ".resp"))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Remove removes the response stored under name
+func (dc *DiskCache) Remove(name string) error {
+	name = path.Join(dc.path, name) + ".resp"
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	dc.logger.Info("[disk-cache] Removed response '%s'", name)
+	return nil
 }
@@ -1,7 +1,12 @@
 package scache
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"testing"
 	"time"
@@ -21,15 +26,41 @@ func (tf *testFailer) Fail(logger *log.Logger, msg string) {
 	tf.failed = true
 }
 
-func TestDiskCache(t *testing.T) {
-	testRespBytes, err := ioutil.ReadFile("../testdata/ocsp.resp")
+// testResponse creates a issuer and a response it signed, which is
+// valid for a week from its ThisUpdate
+func testResponse(t *testing.T) (*ocsp.Response, []byte, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "issuer"}}
+	issuerDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	issuer, err := x509.ParseCertificate(issuerDER)
 	if err != nil {
-		t.Fatalf("Failed to read test ocsp response: %s", err)
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
 	}
-	testResp, err := ocsp.ParseResponse(testRespBytes, nil)
+	thisUpdate := time.Now().Truncate(time.Hour)
+	respBytes, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+		SerialNumber: big.NewInt(1337),
+		Status:       ocsp.Good,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   thisUpdate.Add(7 * 24 * time.Hour),
+	}, key)
 	if err != nil {
-		t.Fatalf("Failed to parse test ocsp response: %s", err)
+		t.Fatalf("ocsp.CreateResponse failed: %s", err)
 	}
+	resp, err := ocsp.ParseResponse(respBytes, issuer)
+	if err != nil {
+		t.Fatalf("ocsp.ParseResponse failed: %s", err)
+	}
+	return resp, respBytes, issuer
+}
+
+func TestDiskCache(t *testing.T) {
+	testResp, testRespBytes, issuer := testResponse(t)
 
 	fc := clock.NewFake()
 	fc.Set(testResp.ThisUpdate.Add(time.Hour))
@@ -49,7 +80,7 @@ func TestDiskCache(t *testing.T) {
 		t.Fatal("Failed to write response to disk")
 	}
 
-	readResp, bytes := dc.Read("test-write", testResp.SerialNumber, nil)
+	readResp, bytes := dc.Read("test-write", testResp.SerialNumber, issuer)
 	if tf.failed {
 		t.Fatal("Failed to read response from disk")
 	}
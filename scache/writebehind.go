@@ -0,0 +1,191 @@
+package scache
+
+import (
+	"crypto/x509"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/metrics"
+)
+
+// pendingWrite is a response waiting to be written by a WriteBehind
+type pendingWrite struct {
+	content  []byte
+	queued   time.Time
+	attempts int
+}
+
+// WriteBehind wraps a stable cache so that writes are queued and
+// written in batches by Run instead of blocking the refresh that
+// made them. Queued writes for the same name are coalesced so only
+// the newest response is written, and writes that fail are retried
+// on later batches if the wrapped cache implements CheckedWriter.
+// Reads are passed straight through, so a response may not be read
+// back until its write has been flushed
+type WriteBehind struct {
+	next        Cache
+	logger      *log.Logger
+	clk         clock.Clock
+	sink        metrics.Sink
+	queueSize   int
+	batchSize   int
+	interval    time.Duration
+	maxAttempts int
+
+	mu      sync.Mutex
+	pending map[string]*pendingWrite
+	order   []string // names in the order they were first queued
+
+	flushMu sync.Mutex // serializes flushes from Run and Flush
+}
+
+// NewWriteBehind creates a WriteBehind for next. At most queueSize
+// distinct names are queued, once the queue is full writes block
+// and are made directly. Each interval up to batchSize writes are
+// flushed, a failed write is attempted at most maxAttempts times
+func NewWriteBehind(logger *log.Logger, clk clock.Clock, next Cache, queueSize, batchSize int, interval time.Duration, maxAttempts int) *WriteBehind {
+	return &WriteBehind{
+		next:        next,
+		logger:      logger,
+		clk:         clk,
+		sink:        metrics.Discard,
+		queueSize:   queueSize,
+		batchSize:   batchSize,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+		pending:     make(map[string]*pendingWrite),
+	}
+}
+
+// SetMetrics sets the sink the queue depth, and the number of
+// retried, dropped, and overflowing writes, are reported to
+func (wb *WriteBehind) SetMetrics(sink metrics.Sink) {
+	wb.sink = sink
+}
+
+// Unwrap returns the wrapped cache, see Unwrap
+func (wb *WriteBehind) Unwrap() Cache {
+	return wb.next
+}
+
+// Read reads a response from the wrapped cache
+func (wb *WriteBehind) Read(name string, serial *big.Int, issuer *x509.Certificate) (*ocsp.Response, []byte) {
+	return wb.next.Read(name, serial, issuer)
+}
+
+// Write queues content to be written as the response for name,
+// replacing any write for name that is already queued
+func (wb *WriteBehind) Write(name string, content []byte) {
+	wb.mu.Lock()
+	if pw, present := wb.pending[name]; present {
+		pw.content, pw.attempts = content, 0
+		wb.mu.Unlock()
+		return
+	}
+	if len(wb.pending) >= wb.queueSize {
+		wb.mu.Unlock()
+		wb.sink.Counter("stable.write-behind.overflows", 1)
+		wb.next.Write(name, content)
+		return
+	}
+	wb.pending[name] = &pendingWrite{content: content, queued: wb.clk.Now()}
+	wb.order = append(wb.order, name)
+	depth := len(wb.order)
+	wb.mu.Unlock()
+	wb.sink.Gauge("stable.write-behind.depth", float64(depth))
+}
+
+// Depth returns the number of queued writes
+func (wb *WriteBehind) Depth() int {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return len(wb.order)
+}
+
+// Run flushes a batch of queued writes every interval until stop is
+// closed, after which the rest of the queue is flushed
+func (wb *WriteBehind) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(wb.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			wb.Flush()
+			return
+		case <-ticker.C:
+			wb.flushBatch()
+		}
+	}
+}
+
+// Flush writes every queued response, writes which fail are
+// attempted until they succeed or reach the maximum attempts
+func (wb *WriteBehind) Flush() {
+	for wb.Depth() > 0 {
+		wb.flushBatch()
+	}
+}
+
+// take removes up to batchSize writes from the front of the queue
+func (wb *WriteBehind) take() ([]string, []*pendingWrite) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	n := len(wb.order)
+	if n > wb.batchSize {
+		n = wb.batchSize
+	}
+	names := wb.order[:n:n]
+	wb.order = wb.order[n:]
+	writes := make([]*pendingWrite, n)
+	for i, name := range names {
+		writes[i] = wb.pending[name]
+		delete(wb.pending, name)
+	}
+	return names, writes
+}
+
+// flushBatch writes a batch of queued responses, requeuing those that
+// fail unless a newer response has been queued in the meantime
+func (wb *WriteBehind) flushBatch() {
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	names, writes := wb.take()
+	for i, name := range names {
+		pw := writes[i]
+		pw.attempts++
+		err := wb.write(name, pw.content)
+		if err == nil {
+			wb.sink.Timing("stable.write-behind.latency", wb.clk.Now().Sub(pw.queued))
+			continue
+		}
+		if pw.attempts >= wb.maxAttempts {
+			wb.sink.Counter("stable.write-behind.dropped", 1)
+			wb.logger.Err("[write-behind] Dropping response for '%s' after %d failed writes: %s", name, pw.attempts, err)
+			continue
+		}
+		wb.sink.Counter("stable.write-behind.retries", 1)
+		wb.logger.Warning("[write-behind] Failed to write response for '%s', retrying: %s", name, err)
+		wb.mu.Lock()
+		if _, present := wb.pending[name]; !present {
+			wb.pending[name] = pw
+			wb.order = append(wb.order, name)
+		}
+		wb.mu.Unlock()
+	}
+	wb.sink.Gauge("stable.write-behind.depth", float64(wb.Depth()))
+}
+
+// write writes a response to the wrapped cache, errors are only
+// returned if it implements CheckedWriter
+func (wb *WriteBehind) write(name string, content []byte) error {
+	if cw, ok := wb.next.(CheckedWriter); ok {
+		return cw.WriteChecked(name, content)
+	}
+	wb.next.Write(name, content)
+	return nil
+}
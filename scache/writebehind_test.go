@@ -0,0 +1,93 @@
+package scache
+
+import (
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/metrics"
+)
+
+// recordingCache records writes, failing the first failures of them
+type recordingCache struct {
+	writes   map[string][]byte
+	attempts int
+	failures int
+}
+
+func (rc *recordingCache) Read(string, *big.Int, *x509.Certificate) (*ocsp.Response, []byte) {
+	return nil, nil
+}
+
+func (rc *recordingCache) Write(name string, content []byte) {
+	rc.WriteChecked(name, content)
+}
+
+func (rc *recordingCache) WriteChecked(name string, content []byte) error {
+	rc.attempts++
+	if rc.failures > 0 {
+		rc.failures--
+		return errors.New("backing unavailable")
+	}
+	rc.writes[name] = content
+	return nil
+}
+
+func TestWriteBehind(t *testing.T) {
+	fc := clock.NewFake()
+	rc := &recordingCache{writes: make(map[string][]byte)}
+	wb := NewWriteBehind(log.NewLogger("", "", 10, fc), fc, rc, 3, 2, time.Second, 2)
+	agg := metrics.NewAggregate()
+	wb.SetMetrics(agg)
+
+	wb.Write("a", []byte{1})
+	wb.Write("b", []byte{2})
+	wb.Write("a", []byte{3})
+	wb.Write("c", []byte{4})
+	if rc.attempts != 0 {
+		t.Fatal("Queued writes were made before being flushed")
+	}
+	if depth := wb.Depth(); depth != 3 {
+		t.Fatalf("Unexpected queue depth: %d", depth)
+	}
+	wb.Write("d", []byte{5})
+	if string(rc.writes["d"]) != string([]byte{5}) {
+		t.Fatal("Write wasn't made directly when the queue was full")
+	}
+
+	rc.failures = 1
+	wb.flushBatch()
+	if _, present := rc.writes["a"]; present {
+		t.Fatal("Failed write was recorded")
+	}
+	if string(rc.writes["b"]) != string([]byte{2}) {
+		t.Fatal("Second write in batch wasn't made")
+	}
+	if depth := wb.Depth(); depth != 2 {
+		t.Fatalf("Failed write wasn't requeued, queue depth: %d", depth)
+	}
+	wb.Flush()
+	if string(rc.writes["a"]) != string([]byte{3}) || string(rc.writes["c"]) != string([]byte{4}) {
+		t.Fatalf("Unexpected writes after flushing: %v", rc.writes)
+	}
+
+	rc.failures = 2
+	wb.Write("e", []byte{6})
+	wb.Flush()
+	if _, present := rc.writes["e"]; present || wb.Depth() != 0 {
+		t.Fatal("Write wasn't dropped after reaching the maximum attempts")
+	}
+	snap := agg.Snapshot()
+	if snap.Counters["stable.write-behind.retries"] != 2 || snap.Counters["stable.write-behind.dropped"] != 1 || snap.Counters["stable.write-behind.overflows"] != 1 {
+		t.Fatalf("Unexpected counters: %v", snap.Counters)
+	}
+	if Unwrap(wb) != rc {
+		t.Fatal("Unwrap didn't return the wrapped cache")
+	}
+}
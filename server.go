@@ -1,37 +1,307 @@
-package main
+package stapled
 
 import (
+	"crypto"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
-	cflog "github.com/cloudflare/cfssl/log"
-	cfocsp "github.com/cloudflare/cfssl/ocsp"
 	"golang.org/x/crypto/ocsp"
 
-	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/mcache"
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+	"github.com/rolandshoemaker/stapled/tracing"
 )
 
-func (s *stapled) Response(r *ocsp.Request) ([]byte, bool) {
-	if response, present := s.c.LookupResponse(r); present {
-		return response, present
+var (
+	malformedRequestErrorResponse = []byte{0x30, 0x03, 0x0A, 0x01, 0x01}
+	tryLaterErrorResponse         = []byte{0x30, 0x03, 0x0A, 0x01, 0x03}
+	unauthorizedErrorResponse     = []byte{0x30, 0x03, 0x0A, 0x01, 0x06}
+)
+
+// errNoResponse is returned by response when there is no response in
+// memory or the stable backings and there are no upstream responders
+var errNoResponse = errors.New("no response for request")
+
+// Response returns the response for a request, if it isn't in the
+// cache it is looked for in the stable backings and, if upstream
+// responders are configured, a new entry will be created for it
+func (s *Server) Response(r *ocsp.Request) ([]byte, bool) {
+	response, _, err := s.response(newParsedRequest(r))
+	return response.Response, err == nil
+}
+
+// response is Response but returns whether the response was in
+// memory, and why there is no response
+func (s *Server) response(pr *parsedRequest) (mcache.CachedResponse, bool, error) {
+	r := pr.request
+	response, present := s.c.LookupCached(pr.key)
+	if !present && s.packed != nil {
+		response, present = s.lookupPacked(pr.key)
+	}
+	s.recordLookup(present)
+	if present {
+		return response, true, nil
 	}
 	if len(s.upstreamResponders) == 0 {
-		return nil, false
+		// AddFromRequest reads the stable backings before fetching
+		// a response so only read them directly if it won't be used
+		if response, present = s.c.LookupStable(r, nil); !present {
+			if s.missLog != nil && !s.c.KnownIssuer(r) {
+				s.missLog.record(r, s.clk.Now())
+			}
+			return mcache.CachedResponse{}, false, errNoResponse
+		}
+		return response, false, nil
 	}
 
 	response, err := s.c.AddFromRequest(r, s.upstreamResponders)
-	if err != nil {
+	switch {
+	case err == nil:
+		return response, false, nil
+	case errors.Is(err, mcache.ErrRefreshInProgress):
+		// a concurrent request is already fetching the response
+	case errors.Is(err, mcache.ErrNoIssuer):
+		// not a certificate a response can be fetched for
+		s.missLog.record(r, s.clk.Now())
+	default:
 		s.log.Err("Failed to add entry to cache from request: %s", err)
-		return nil, false
 	}
-	return response, true
+	return mcache.CachedResponse{}, false, err
+}
+
+// errorResponse returns the OCSP error response for a request that
+// doesn't have a response, tryLater if one may be available later
+// and unauthorized otherwise
+func errorResponse(err error) []byte {
+	if tryLater(err) {
+		return tryLaterErrorResponse
+	}
+	return unauthorizedErrorResponse
+}
+
+// tryLater checks if a response may be available later for a request
+// that doesn't have one because of err
+func tryLater(err error) bool {
+	return errors.Is(err, mcache.ErrRefreshInProgress) || errors.Is(err, stapledOCSP.ErrResponderUnavailable)
 }
 
-func (s *stapled) initResponder(httpAddr string, logger *log.Logger) {
-	cflog.SetLogger(&log.ResponderLogger{logger})
-	m := http.StripPrefix("/", cfocsp.NewResponder(s))
-	h := http.HandlerFunc(m.ServeHTTP)
-	s.responder = &http.Server{
-		Addr:    httpAddr,
-		Handler: h,
+// retryAfter formats d as the value of a Retry-After header, rounding
+// up to the next whole second
+func retryAfter(d time.Duration) string {
+	seconds := (d + time.Second - 1) / time.Second
+	if seconds < 1 {
+		seconds = 1
 	}
+	return strconv.FormatInt(int64(seconds), 10)
+}
+
+const (
+	// maxRequestSize is the largest DER OCSP request that will be
+	// accepted in the body of a POST request
+	maxRequestSize = 4096
+	// maxGETPathLength is the longest path that will be accepted for
+	// a GET request, allowing for base64 and URL encoding of a
+	// request of maxRequestSize
+	maxGETPathLength = 8192
+)
+
+// readRequest extracts the DER OCSP request from either the path of
+// a GET request or the body of a POST request
+func readRequest(r *http.Request) ([]byte, int, error) {
+	switch r.Method {
+	case "GET":
+		if r.ContentLength > 0 || len(r.TransferEncoding) > 0 {
+			return nil, http.StatusBadRequest, errors.New("GET request has a body")
+		}
+		if len(r.URL.EscapedPath()) > maxGETPathLength {
+			return nil, http.StatusRequestURITooLong, fmt.Errorf("GET request path is longer than %d bytes", maxGETPathLength)
+		}
+		b64Request, err := url.QueryUnescape(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		// QueryUnescape turns '+' into ' ', turn them back so
+		// the base64 can be decoded
+		b64Request = strings.Replace(b64Request, " ", "+", -1)
+		body, err := base64.StdEncoding.DecodeString(b64Request)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		return body, 0, nil
+	case "POST":
+		if r.ContentLength > maxRequestSize {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("POST request body is longer than %d bytes", maxRequestSize)
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if len(body) > maxRequestSize {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("POST request body is longer than %d bytes", maxRequestSize)
+		}
+		return body, 0, nil
+	}
+	return nil, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %q", r.Method)
+}
+
+// responseETag returns a strong ETag for a response
+func responseETag(response []byte) string {
+	return digestETag(common.Sum256(response))
+}
+
+// digestETag returns the strong ETag for a response with the SHA-256
+// digest digest
+func digestETag(digest [32]byte) string {
+	return `"` + hex.EncodeToString(digest[:]) + `"`
+}
+
+// etagMatches checks if a If-None-Match header value matches eTag
+func etagMatches(ifNoneMatch, eTag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == eTag {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP implements a RFC 5019 compliant OCSP responder
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.responderDelay > 0 {
+		t := time.NewTimer(s.responderDelay)
+		select {
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+	started := s.clk.Now()
+	defer func() { s.metrics.Timing("responder.duration", s.clk.Now().Sub(started)) }()
+	result, serial := "error", (*big.Int)(nil)
+	_, span := tracing.Start(r.Context(), "responder.request")
+	span.SetAttribute("http.method", r.Method)
+	defer func() {
+		span.SetAttribute("result", result)
+		if serial != nil && tracing.Enabled() {
+			span.SetAttribute("serial", fmt.Sprintf("%x", serial))
+		}
+		span.End()
+	}()
+	if s.accessLog.Enabled {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		w = sw
+		defer func() { s.logAccess(r, sw.status, result, serial, s.clk.Now().Sub(started)) }()
+	}
+	s.metrics.Counter("responder.requests", 1)
+	// only used when a valid response isn't being returned
+	w.Header().Set("Cache-Control", "max-age=0, no-cache")
+
+	var pr *parsedRequest
+	cached := false
+	if s.requests != nil && cacheableGET(r) {
+		pr, cached = s.requests.get(r.URL.Path)
+	}
+	if !cached {
+		body, status, err := readRequest(r)
+		if err != nil {
+			s.log.Err("[responder] Failed to read request: %s", err)
+			if status == http.StatusMethodNotAllowed {
+				w.Header().Set("Allow", "GET, POST")
+			}
+			if status != http.StatusBadRequest {
+				// don't try to reuse a connection that may still
+				// have a unread body on it
+				w.Header().Set("Connection", "close")
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		request, err := ocsp.ParseRequest(body)
+		if err == nil && s.lightweight {
+			err = stapledOCSP.CheckLightweightRequest(body)
+		}
+		if err != nil {
+			s.log.Err("[responder] Failed to parse request: %s", err)
+			w.Header().Set("Content-Type", "application/ocsp-response")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(malformedRequestErrorResponse)
+			return
+		}
+		pr = newParsedRequest(request)
+		if s.requests != nil && r.Method == "GET" {
+			s.requests.add(r.URL.Path, pr)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	request := pr.request
+	serial = request.SerialNumber
+	s.clients.record(serial, s.clientAddr(r), r.UserAgent(), started)
+	if s.rejectSHA1 && request.HashAlgorithm == crypto.SHA1 {
+		s.log.Warning("[responder] Rejecting SHA-1 request for serial %x from %s (User-Agent '%s')", request.SerialNumber, s.clientAddr(r), r.UserAgent())
+		w.Write(unauthorizedErrorResponse)
+		return
+	}
+
+	response, hit, err := s.response(pr)
+	if err != nil {
+		s.log.Info("[responder] No response found for request: serial %x: %s", request.SerialNumber, err)
+		s.metrics.Counter("responder.error-responses", 1)
+		if tryLater(err) {
+			// pace clients which retry tryLater responses, such as
+			// web servers stapling responses, by the entry's backoff
+			w.Header().Set("Retry-After", retryAfter(s.c.RetryAfter(err)))
+		}
+		w.Write(errorResponse(err))
+		return
+	}
+	if s.expired.policy != ServeExpired && s.rejectExpired(pr) {
+		result = "expired"
+		w.Write(unauthorizedErrorResponse)
+		return
+	}
+	result = "miss"
+	if hit {
+		result = "hit"
+		s.sampleShadow(pr, response.Response)
+	}
+
+	// every response was verified against its issuer when it was
+	// stored, or by the primary that wrote the packed file, and the
+	// values its headers are built from were derived then
+	maxAge := 0
+	if now := s.clk.Now(); now.Before(response.NextUpdate) {
+		maxAge = int(response.NextUpdate.Sub(now) / time.Second)
+	}
+	eTag := digestETag(response.Digest)
+	w.Header().Set("ETag", eTag)
+	w.Header().Set("Last-Modified", response.ThisUpdate.UTC().Format(http.TimeFormat))
+	w.Header().Set("Expires", response.NextUpdate.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge))
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, eTag) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(response.Response)
+}
+
+func (s *Server) initResponder() {
+	s.responder.Handler = s
 }
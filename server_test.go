@@ -1 +1,439 @@
-package main
+package stapled
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/mcache"
+	stapledMetrics "github.com/rolandshoemaker/stapled/metrics"
+	stapledOCSP "github.com/rolandshoemaker/stapled/ocsp"
+)
+
+var everyHash = []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+// testFixture contains a server with a single entry in its cache
+// and the pieces required to make requests for it
+type testFixture struct {
+	s        *Server
+	fc       clock.FakeClock
+	issuer   *x509.Certificate
+	key      *rsa.PrivateKey // of the issuer
+	certDER  []byte
+	request  []byte
+	response []byte
+	upstream *httptest.Server
+}
+
+func (tf *testFixture) close() {
+	tf.upstream.Close()
+}
+
+func newTestFixture(t *testing.T) *testFixture {
+	fc := clock.NewFake()
+	fc.Set(time.Now())
+	logger := log.NewLogger("", "", 10, fc)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	issuerTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "issuer"},
+		IsCA:         true,
+		SubjectKeyId: []byte{1, 2, 3},
+	}
+	issuerDER, err := x509.CreateCertificate(rand.Reader, issuerTemplate, issuerTemplate, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	issuer, err := x509.ParseCertificate(issuerDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	certTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(1337),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotAfter:     fc.Now().Add(time.Hour * 24 * 90),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, certTemplate, issuer, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	response, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+		SerialNumber: certTemplate.SerialNumber,
+		Status:       ocsp.Good,
+		ThisUpdate:   fc.Now().Add(-time.Hour),
+		NextUpdate:   fc.Now().Add(time.Hour * 24),
+	}, key)
+	if err != nil {
+		t.Fatalf("ocsp.CreateResponse failed: %s", err)
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(response)
+	}))
+
+	f, err := ioutil.TempFile("", "cert")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile failed: %s", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(certDER); err != nil {
+		t.Fatalf("Failed to write certificate: %s", err)
+	}
+	f.Close()
+
+	c := mcache.NewEntryCache(fc, logger, time.Minute, nil, new(http.Client), time.Minute, nil, everyHash, true)
+	err = c.AddFromCertificate(f.Name(), issuer, []string{upstream.URL})
+	if err != nil {
+		t.Fatalf("Failed to add entry to cache: %s", err)
+	}
+	s, err := NewServer(WithCache(c), WithLogger(logger), WithClock(fc))
+	if err != nil {
+		t.Fatalf("NewServer failed: %s", err)
+	}
+
+	nameHash, keyHash, err := common.HashNameAndPKI(crypto.SHA1.New(), issuer.RawSubject, issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		t.Fatalf("Failed to hash issuer: %s", err)
+	}
+	request, err := (&ocsp.Request{
+		HashAlgorithm:  crypto.SHA1,
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  keyHash,
+		SerialNumber:   certTemplate.SerialNumber,
+	}).Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %s", err)
+	}
+
+	return &testFixture{
+		s:        s,
+		fc:       fc,
+		issuer:   issuer,
+		key:      key,
+		certDER:  certDER,
+		request:  request,
+		response: response,
+		upstream: upstream,
+	}
+}
+
+func (tf *testFixture) get(header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/"+base64.StdEncoding.EncodeToString(tf.request), nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	tf.s.ServeHTTP(w, r)
+	return w
+}
+
+func TestResponder(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	w := tf.get(nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), tf.response) {
+		t.Fatal("Responder returned unexpected response")
+	}
+	eTag := w.Header().Get("ETag")
+	if eTag != responseETag(tf.response) {
+		t.Fatalf("Unexpected ETag: %q", eTag)
+	}
+
+	w = tf.get(http.Header{"If-None-Match": {`"other", ` + eTag}})
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("Expected 304 with matching If-None-Match, got %d", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Fatal("304 response contained a body")
+	}
+
+	w = tf.get(http.Header{"If-None-Match": {`"other"`}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 with mismatching If-None-Match, got %d", w.Code)
+	}
+
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(tf.request))
+	w = httptest.NewRecorder()
+	tf.s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), tf.response) {
+		t.Fatalf("Unexpected POST response: %d", w.Code)
+	}
+
+	r = httptest.NewRequest("GET", "/not-base64!", nil)
+	w = httptest.NewRecorder()
+	tf.s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for malformed request, got %d", w.Code)
+	}
+}
+
+func TestResponderRequestCache(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	if err := WithRequestCache(2)(tf.s); err != nil {
+		t.Fatalf("WithRequestCache failed: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := tf.get(nil)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), tf.response) {
+			t.Fatalf("Unexpected response from request %d: %d", i, w.Code)
+		}
+		if eTag := w.Header().Get("ETag"); eTag != responseETag(tf.response) {
+			t.Fatalf("Unexpected ETag from request %d: %q", i, eTag)
+		}
+	}
+	pr, present := tf.s.requests.get("/" + base64.StdEncoding.EncodeToString(tf.request))
+	if !present {
+		t.Fatal("GET path wasn't cached")
+	}
+
+	// POST requests and malformed paths aren't cached
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(tf.request))
+	tf.s.ServeHTTP(httptest.NewRecorder(), r)
+	tf.s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/not-base64!", nil))
+	if n := tf.s.requests.len(); n != 1 {
+		t.Fatalf("Expected 1 cached path, got %d", n)
+	}
+
+	// the least recently used path is evicted
+	tf.s.requests.add("/a", pr)
+	tf.s.requests.get("/" + base64.StdEncoding.EncodeToString(tf.request))
+	tf.s.requests.add("/b", pr)
+	if _, present = tf.s.requests.get("/a"); present {
+		t.Fatal("Least recently used path wasn't evicted")
+	}
+	if n := tf.s.requests.len(); n != 2 {
+		t.Fatalf("Expected 2 cached paths, got %d", n)
+	}
+}
+
+func TestResponderMetrics(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	sink := stapledMetrics.NewAggregate()
+	if err := WithMetrics(sink)(tf.s); err != nil {
+		t.Fatalf("WithMetrics failed: %s", err)
+	}
+
+	if w := tf.get(nil); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", w.Code)
+	}
+	snap := sink.Snapshot()
+	if snap.Counters["responder.requests"] != 1 || snap.Counters["responder.hits"] != 1 {
+		t.Fatalf("Unexpected responder counters: %v", snap.Counters)
+	}
+	if ts := snap.Timings["responder.duration"]; ts.Count != 1 {
+		t.Fatalf("Expected 1 responder timing, got %d", ts.Count)
+	}
+}
+
+func TestResponderRejectsBadRequests(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	for _, method := range []string{"PUT", "DELETE", "PATCH", "OPTIONS"} {
+		r := httptest.NewRequest(method, "/", bytes.NewReader(tf.request))
+		w := httptest.NewRecorder()
+		tf.s.ServeHTTP(w, r)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("Expected 405 for %s, got %d", method, w.Code)
+		}
+		if allow := w.Header().Get("Allow"); allow != "GET, POST" {
+			t.Fatalf("Unexpected Allow header for %s: %q", method, allow)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(make([]byte, maxRequestSize+1)))
+	w := httptest.NewRecorder()
+	tf.s.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 for oversized POST body, got %d", w.Code)
+	}
+
+	// no Content-Length, so the limit has to be enforced while reading
+	r = httptest.NewRequest("POST", "/", ioutil.NopCloser(bytes.NewReader(make([]byte, maxRequestSize+1))))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	tf.s.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 for oversized chunked POST body, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest("GET", "/"+strings.Repeat("A", maxGETPathLength), nil)
+	w = httptest.NewRecorder()
+	tf.s.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestURITooLong {
+		t.Fatalf("Expected 414 for oversized GET path, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest("GET", "/"+base64.StdEncoding.EncodeToString(tf.request), bytes.NewReader(tf.request))
+	w = httptest.NewRecorder()
+	tf.s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for GET with a body, got %d", w.Code)
+	}
+}
+
+func TestResponderRejectsSHA1(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	tf.s.rejectSHA1 = true
+
+	w := tf.get(nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), unauthorizedErrorResponse) {
+		t.Fatalf("Expected unauthorized response for SHA-1 request, got %d", w.Code)
+	}
+
+	nameHash, keyHash, err := common.HashNameAndPKI(crypto.SHA256.New(), tf.issuer.RawSubject, tf.issuer.RawSubjectPublicKeyInfo)
+	if err != nil {
+		t.Fatalf("Failed to hash issuer: %s", err)
+	}
+	tf.request, err = (&ocsp.Request{
+		HashAlgorithm:  crypto.SHA256,
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  keyHash,
+		SerialNumber:   big.NewInt(1337),
+	}).Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %s", err)
+	}
+	w = tf.get(nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), tf.response) {
+		t.Fatalf("Expected response for SHA-256 request, got %d", w.Code)
+	}
+}
+
+func TestResponderLightweightProfile(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	tf.s.lightweight = true
+
+	w := tf.get(nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), tf.response) {
+		t.Fatalf("Expected response for lightweight request, got %d", w.Code)
+	}
+
+	request, err := ocsp.ParseRequest(tf.request)
+	if err != nil {
+		t.Fatalf("Failed to parse request: %s", err)
+	}
+	nonce := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}, Value: []byte{4, 2, 1, 2}}
+	tf.request, err = stapledOCSP.MarshalRequest(request, []pkix.Extension{nonce})
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %s", err)
+	}
+	w = tf.get(nil)
+	if w.Code != http.StatusBadRequest || !bytes.Equal(w.Body.Bytes(), malformedRequestErrorResponse) {
+		t.Fatalf("Expected malformedRequest for request with a nonce, got %d", w.Code)
+	}
+}
+
+func TestResponderErrorResponses(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	tf.s.upstreamResponders = []string{unavailable.URL}
+	// fail after the first request rather than retrying until the
+	// request timeout
+	tf.s.c.SetFetchBackoff(stapledOCSP.Backoff{Delay: time.Second, Budget: stapledOCSP.NewRetryBudget(tf.fc, 0, 0)})
+
+	get := func(issuer *x509.Certificate, serial int64) *httptest.ResponseRecorder {
+		nameHash, keyHash, err := common.HashNameAndPKI(crypto.SHA1.New(), issuer.RawSubject, issuer.RawSubjectPublicKeyInfo)
+		if err != nil {
+			t.Fatalf("Failed to hash issuer: %s", err)
+		}
+		req, err := (&ocsp.Request{HashAlgorithm: crypto.SHA1, IssuerNameHash: nameHash, IssuerKeyHash: keyHash, SerialNumber: big.NewInt(serial)}).Marshal()
+		if err != nil {
+			t.Fatalf("Failed to marshal request: %s", err)
+		}
+		w := httptest.NewRecorder()
+		tf.s.ServeHTTP(w, httptest.NewRequest("GET", "/"+base64.StdEncoding.EncodeToString(req), nil))
+		return w
+	}
+	w := get(tf.issuer, 1)
+	if body := w.Body.Bytes(); !bytes.Equal(body, tryLaterErrorResponse) {
+		t.Fatalf("Expected tryLater when the upstream responder is unavailable, got %x", body)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "1" {
+		t.Fatalf("Expected tryLater to have the backoff delay as Retry-After, got '%s'", ra)
+	}
+	other := &x509.Certificate{RawSubject: []byte{1}, RawSubjectPublicKeyInfo: tf.issuer.RawSubjectPublicKeyInfo}
+	w = get(other, 1)
+	if body := w.Body.Bytes(); !bytes.Equal(body, unauthorizedErrorResponse) {
+		t.Fatalf("Expected unauthorized for a unknown issuer, got %x", body)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "" {
+		t.Fatalf("Expected unauthorized to not have a Retry-After header, got '%s'", ra)
+	}
+}
+
+func TestResponderAccessLog(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	if err := WithAccessLog(AccessLog{Enabled: true, HitSample: 3, HashClientIPs: true})(tf.s); err != nil {
+		t.Fatalf("WithAccessLog failed: %s", err)
+	}
+
+	logged := 0
+	for i := 0; i < 9; i++ {
+		if _, ok := tf.s.accessSample("hit"); ok {
+			logged++
+		}
+	}
+	if logged != 3 {
+		t.Fatalf("Expected 3 of 9 hits to be logged, got %d", logged)
+	}
+	for _, result := range []string{"miss", "error"} {
+		if sample, ok := tf.s.accessSample(result); !ok || sample != 1 {
+			t.Fatalf("Expected every %s to be logged", result)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	addr := tf.s.clientAddr(r)
+	if strings.Contains(addr, "192.0.2.1") || !strings.HasPrefix(addr, "client-") {
+		t.Fatalf("Client address wasn't hashed: %s", addr)
+	}
+	r.RemoteAddr = "192.0.2.1:4321"
+	if tf.s.clientAddr(r) != addr {
+		t.Fatal("Hash of client address depends on the port")
+	}
+
+	if w := tf.get(nil); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", w.Code)
+	}
+	if err := WithAccessLog(AccessLog{HitSample: -1})(tf.s); err == nil {
+		t.Fatal("WithAccessLog accepted a negative hit sample")
+	}
+}
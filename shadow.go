@@ -0,0 +1,126 @@
+package stapled
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/rolandshoemaker/stapled/mcache"
+	"github.com/rolandshoemaker/stapled/rand"
+)
+
+// Shadow fetches a fresh response from upstream for a sample of the
+// responses served from the cache and compares the two, so that a
+// cache serving materially different answers than the CA, such as a
+// good status for a certificate that has since been revoked, is
+// noticed
+type Shadow struct {
+	Rate          float64       // fraction of responses served from the cache to check
+	Timeout       time.Duration // for each upstream fetch, 10 seconds by default
+	MaxConcurrent int           // checks running at once, others are skipped, 4 by default
+}
+
+// shadowMode holds the shadow mode settings and the slots limiting
+// the checks running at once
+type shadowMode struct {
+	Shadow
+	slots chan struct{}
+}
+
+// WithShadowMode enables shadow mode, see Shadow
+func WithShadowMode(sh Shadow) Option {
+	return func(s *Server) error {
+		if sh.Rate <= 0 || sh.Rate > 1 {
+			return errors.New("shadow rate must be greater than zero and at most one")
+		}
+		if sh.Timeout < 0 || sh.MaxConcurrent < 0 {
+			return errors.New("shadow timeout and max concurrent checks must not be negative")
+		}
+		if sh.Timeout == 0 {
+			sh.Timeout = 10 * time.Second
+		}
+		if sh.MaxConcurrent == 0 {
+			sh.MaxConcurrent = 4
+		}
+		s.shadow = &shadowMode{Shadow: sh, slots: make(chan struct{}, sh.MaxConcurrent)}
+		return nil
+	}
+}
+
+// responseDivergences returns how upstream materially differs from
+// served, a response served from the cache: its status or revocation
+// details differ, or it was produced after served expired
+func responseDivergences(served, upstream *ocsp.Response) []string {
+	divergences := []string{}
+	if served.Status != upstream.Status {
+		divergences = append(divergences, "status")
+	} else if served.Status == ocsp.Revoked && (!served.RevokedAt.Equal(upstream.RevokedAt) || served.RevocationReason != upstream.RevocationReason) {
+		divergences = append(divergences, "revocation")
+	}
+	if !served.NextUpdate.IsZero() && served.NextUpdate.Before(upstream.ThisUpdate) {
+		divergences = append(divergences, "stale")
+	}
+	return divergences
+}
+
+// sampleShadow checks the response served for pr against upstream in
+// the background if it is sampled
+func (s *Server) sampleShadow(pr *parsedRequest, served []byte) {
+	if s.shadow == nil || rand.Float64() >= s.shadow.Rate {
+		return
+	}
+	select {
+	case s.shadow.slots <- struct{}{}:
+	default:
+		s.metrics.Counter("shadow.skipped", 1)
+		return
+	}
+	go func() {
+		defer func() { <-s.shadow.slots }()
+		s.checkShadow(pr, served)
+	}()
+}
+
+// checkShadow fetches a fresh response for pr and compares it with
+// served, it returns the divergences found
+func (s *Server) checkShadow(pr *parsedRequest, served []byte) []string {
+	// served was verified against its issuer when it was cached
+	parsed, err := ocsp.ParseResponse(served, nil)
+	if err != nil {
+		s.log.Err("[shadow] Failed to parse served response for serial %x: %s", pr.request.SerialNumber, err)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.shadow.Timeout)
+	defer cancel()
+	upstream, err := s.c.FetchFresh(ctx, pr.key)
+	if errors.Is(err, mcache.ErrNotCached) {
+		// served from a packed file, or the entry has been removed
+		return nil
+	}
+	s.metrics.Counter("shadow.checks", 1)
+	if err != nil {
+		s.metrics.Counter("shadow.failures", 1)
+		s.log.Warning("[shadow] Failed to fetch response for serial %x: %s", pr.request.SerialNumber, err)
+		return nil
+	}
+	divergences := responseDivergences(parsed, upstream)
+	if len(divergences) == 0 {
+		return nil
+	}
+	s.metrics.Counter("shadow.divergences", 1)
+	for _, d := range divergences {
+		s.metrics.Counter("shadow.divergences."+d, 1)
+	}
+	s.log.Warning("[shadow] Response served for serial %x differs from upstream (%s): served %s produced at %s, upstream %s produced at %s",
+		pr.request.SerialNumber,
+		strings.Join(divergences, ", "),
+		statusNames[parsed.Status],
+		parsed.ProducedAt,
+		statusNames[upstream.Status],
+		upstream.ProducedAt,
+	)
+	return divergences
+}
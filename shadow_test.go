@@ -0,0 +1,58 @@
+package stapled
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestResponseDivergences(t *testing.T) {
+	now := time.Now()
+	good := &ocsp.Response{Status: ocsp.Good, ThisUpdate: now.Add(-time.Hour), NextUpdate: now.Add(time.Hour)}
+	revoked := &ocsp.Response{Status: ocsp.Revoked, RevokedAt: now.Add(-time.Hour), ThisUpdate: now, NextUpdate: now.Add(2 * time.Hour)}
+	for _, tc := range []struct {
+		served, upstream *ocsp.Response
+		expected         []string
+	}{
+		{good, good, []string{}},
+		{good, revoked, []string{"status"}},
+		{revoked, &ocsp.Response{Status: ocsp.Revoked, RevokedAt: now, ThisUpdate: now}, []string{"revocation"}},
+		{good, &ocsp.Response{Status: ocsp.Good, ThisUpdate: now.Add(2 * time.Hour)}, []string{"stale"}},
+	} {
+		if divergences := responseDivergences(tc.served, tc.upstream); !reflect.DeepEqual(divergences, tc.expected) {
+			t.Fatalf("Expected divergences %v, got %v", tc.expected, divergences)
+		}
+	}
+}
+
+func TestCheckShadow(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	if err := WithShadowMode(Shadow{Rate: 1})(tf.s); err != nil {
+		t.Fatalf("WithShadowMode failed: %s", err)
+	}
+	request, err := ocsp.ParseRequest(tf.request)
+	if err != nil {
+		t.Fatalf("Failed to parse request: %s", err)
+	}
+	pr := newParsedRequest(request)
+
+	if divergences := tf.s.checkShadow(pr, tf.response); len(divergences) != 0 {
+		t.Fatalf("Expected no divergences when serving the upstream response, got %v", divergences)
+	}
+	revoked, err := ocsp.CreateResponse(tf.issuer, tf.issuer, ocsp.Response{
+		SerialNumber: request.SerialNumber,
+		Status:       ocsp.Revoked,
+		RevokedAt:    tf.fc.Now().Add(-time.Hour),
+		ThisUpdate:   tf.fc.Now().Add(-time.Hour),
+		NextUpdate:   tf.fc.Now().Add(time.Hour * 24),
+	}, tf.key)
+	if err != nil {
+		t.Fatalf("ocsp.CreateResponse failed: %s", err)
+	}
+	if divergences := tf.s.checkShadow(pr, revoked); !reflect.DeepEqual(divergences, []string{"status"}) {
+		t.Fatalf("Expected a status divergence, got %v", divergences)
+	}
+}
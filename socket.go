@@ -0,0 +1,188 @@
+package stapled
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// socketCommands describes the commands accepted on the control
+// socket, in the order they are listed by help
+var socketCommands = []string{
+	"list                  list every entry as: name serial status next-update",
+	"status <hex serial>   print the status of the entries for a serial as: name status this-update next-update",
+	"refresh <name>        fetch a new response for a entry",
+	"invalidate <name>     drop the response for a entry and fetch a new one",
+	"help                  print this message",
+	"quit                  close the connection",
+}
+
+// WithControlSocket serves a line oriented text protocol on a Unix
+// socket at path so that scripts without HTTP clients can query and
+// refresh entries. Each command is answered with zero or more lines
+// of output followed by a line containing either OK or ERR and a
+// message. The socket is only accessible by the user stapled runs as
+func WithControlSocket(path string) Option {
+	return func(s *Server) error {
+		s.socketPath = path
+		return nil
+	}
+}
+
+// listenControlSocket creates the socket in a private directory next
+// to socketPath and then moves it into place, so that it is never
+// accessible by other users, even before its mode is set
+func (s *Server) listenControlSocket() (net.Listener, error) {
+	// remove a socket left behind by a previous process
+	if fi, err := os.Lstat(s.socketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(s.socketPath)
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(s.socketPath), ".stapled-socket")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmpPath := filepath.Join(dir, "control")
+	l, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is removed from socketPath by serveControlSocket
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err = os.Chmod(tmpPath, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err = os.Rename(tmpPath, s.socketPath); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *Server) serveControlSocket(l net.Listener) {
+	go func() {
+		<-s.stop
+		l.Close()
+		os.Remove(s.socketPath)
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.stop:
+			default:
+				s.log.Err("[socket] Failed to accept connection: %s", err)
+			}
+			return
+		}
+		go s.handleSocketConn(conn)
+	}
+}
+
+func (s *Server) handleSocketConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	w := bufio.NewWriter(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" {
+			return
+		}
+		if err := s.socketCommand(w, fields[0], fields[1:]); err != nil {
+			fmt.Fprintf(w, "ERR %s\n", err)
+		} else {
+			fmt.Fprintln(w, "OK")
+		}
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// socketCommand runs a single control socket command, writing its
+// output to w
+func (s *Server) socketCommand(w io.Writer, command string, args []string) error {
+	arg := func() (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("%s takes a single argument", command)
+		}
+		return args[0], nil
+	}
+	switch command {
+	case "help":
+		for _, line := range socketCommands {
+			fmt.Fprintln(w, line)
+		}
+	case "list":
+		if len(args) != 0 {
+			return fmt.Errorf("list doesn't take any arguments")
+		}
+		infos := s.c.Entries()
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+		for _, info := range infos {
+			fmt.Fprintf(w, "%s %X %s %s\n", info.Name, info.Serial, s.socketStatus(info.Status, info.ThisUpdate), socketTime(info.NextUpdate))
+		}
+	case "status":
+		hexSerial, err := arg()
+		if err != nil {
+			return err
+		}
+		serial, ok := new(big.Int).SetString(hexSerial, 16)
+		if !ok {
+			return fmt.Errorf("'%s' isn't a hex serial", hexSerial)
+		}
+		found := false
+		for _, info := range s.c.Entries() {
+			if info.Serial.Cmp(serial) == 0 {
+				found = true
+				fmt.Fprintf(w, "%s %s %s %s\n", info.Name, s.socketStatus(info.Status, info.ThisUpdate), socketTime(info.ThisUpdate), socketTime(info.NextUpdate))
+			}
+		}
+		if !found {
+			return fmt.Errorf("no entry for serial %X", serial)
+		}
+	case "refresh":
+		name, err := arg()
+		if err != nil {
+			return err
+		}
+		return s.c.Refresh(name)
+	case "invalidate":
+		name, err := arg()
+		if err != nil {
+			return err
+		}
+		return s.c.Invalidate(name)
+	default:
+		return fmt.Errorf("unknown command '%s', try help", command)
+	}
+	return nil
+}
+
+// socketStatus returns the status name for a entry, or none if it
+// doesn't have a response
+func (s *Server) socketStatus(status int, thisUpdate time.Time) string {
+	if thisUpdate.IsZero() {
+		return "none"
+	}
+	return statusNames[status]
+}
+
+func socketTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,94 @@
+package stapled
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlSocket(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tf.s.socketPath = filepath.Join(dir, "control.sock")
+	l, err := tf.s.listenControlSocket()
+	if err != nil {
+		t.Fatalf("Failed to listen on control socket: %s", err)
+	}
+	go tf.s.serveControlSocket(l)
+	defer close(tf.s.stop)
+
+	fi, err := os.Stat(tf.s.socketPath)
+	if err != nil {
+		t.Fatalf("Failed to stat control socket: %s", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("Unexpected control socket permissions: %s", fi.Mode())
+	}
+	// the private directory the socket was created in is removed
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Fatalf("Unexpected files left next to the control socket: %v, %v", files, err)
+	}
+
+	conn, err := net.Dial("unix", tf.s.socketPath)
+	if err != nil {
+		t.Fatalf("Failed to dial control socket: %s", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	send := func(command string) ([]string, string) {
+		if _, err := fmt.Fprintln(conn, command); err != nil {
+			t.Fatalf("Failed to send '%s': %s", command, err)
+		}
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read reply to '%s': %s", command, err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "OK" || strings.HasPrefix(line, "ERR ") {
+				return lines, line
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	info := tf.s.c.Entries()[0]
+	lines, result := send("list")
+	if result != "OK" || len(lines) != 1 {
+		t.Fatalf("Unexpected reply to list: %q %s", lines, result)
+	}
+	if fields := strings.Fields(lines[0]); len(fields) != 4 || fields[0] != info.Name || fields[1] != "539" || fields[2] != "good" {
+		t.Fatalf("Unexpected entry in list: %q", lines[0])
+	}
+
+	lines, result = send("status 539")
+	if result != "OK" || len(lines) != 1 || !strings.HasPrefix(lines[0], info.Name+" good ") {
+		t.Fatalf("Unexpected reply to status: %q %s", lines, result)
+	}
+	for _, command := range []string{"status 53a", "status zz", "status", "refresh unknown", "bogus"} {
+		if _, result = send(command); !strings.HasPrefix(result, "ERR ") {
+			t.Fatalf("Expected an error for '%s', got %s", command, result)
+		}
+	}
+
+	tf.fc.Add(time.Minute)
+	if _, result = send("refresh " + info.Name); result != "OK" {
+		t.Fatalf("Unexpected reply to refresh: %s", result)
+	}
+	if !tf.s.c.Entries()[0].LastSync.After(info.LastSync) {
+		t.Fatal("Entry wasn't synced after refresh")
+	}
+}
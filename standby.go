@@ -0,0 +1,280 @@
+package stapled
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+// Standby keeps the cache of a warm standby instance filled with the
+// responses of the active instance, so that when it is promoted, by
+// POSTing to /promote on the admin listener or sending it SIGUSR2, it
+// takes over refreshing them without fetching every response from
+// the CA. The cache must be put in standby mode using
+// mcache.EntryCache.SetStandby before entries are added to it
+type Standby struct {
+	// Active is the URL of the admin listener of the active instance,
+	// whose /responses are pulled every Interval. If it is empty the
+	// standby only loads the responses the active instance writes to
+	// shared stable backings
+	Active   string
+	Interval time.Duration // one minute by default
+	Timeout  time.Duration // for each pull, 30 seconds by default
+}
+
+// maxStandbyResponseSize limits the size of each response pulled from
+// the active instance, including its name and JSON encoding. The
+// responses are decoded and imported one at a time so a pull only
+// holds one of them in memory
+const maxStandbyResponseSize = 64 << 10
+
+// valueLimitReader fails once more than limit bytes have been read
+// since it was last reset, so a JSON value decoded from it can't grow
+// the decoder's buffer without bound
+type valueLimitReader struct {
+	r     io.Reader
+	read  int64
+	limit int64
+}
+
+func (vr *valueLimitReader) Read(p []byte) (int, error) {
+	if vr.read >= vr.limit {
+		return 0, fmt.Errorf("value is larger than %d bytes", vr.limit)
+	}
+	if int64(len(p)) > vr.limit-vr.read {
+		p = p[:vr.limit-vr.read]
+	}
+	n, err := vr.r.Read(p)
+	vr.read += int64(n)
+	return n, err
+}
+
+func (vr *valueLimitReader) reset() {
+	vr.read = 0
+}
+
+// standbySync holds the standby settings and the result of the last
+// pull from the active instance
+type standbySync struct {
+	Standby
+	client *http.Client
+
+	mu        sync.Mutex
+	lastPull  time.Time
+	lastError string
+	imported  int
+}
+
+// WithStandby makes the instance the warm standby of a active
+// instance, see Standby
+func WithStandby(sb Standby) Option {
+	return func(s *Server) error {
+		if sb.Interval < 0 || sb.Timeout < 0 {
+			return errors.New("standby interval and timeout must not be negative")
+		}
+		if sb.Active != "" {
+			u, err := url.Parse(sb.Active)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("standby active instance '%s' isn't a http or https URL", sb.Active)
+			}
+		}
+		if sb.Interval == 0 {
+			sb.Interval = time.Minute
+		}
+		if sb.Timeout == 0 {
+			sb.Timeout = 30 * time.Second
+		}
+		s.standby = &standbySync{Standby: sb, client: &http.Client{Timeout: sb.Timeout}}
+		return nil
+	}
+}
+
+// activeResponse is a response served at /responses
+type activeResponse struct {
+	Name     string `json:"name"`
+	Response []byte `json:"response"` // DER, base64 encoded in JSON
+}
+
+// responsesHandler serves the current response of every entry at
+// /responses, for standby instances to pull
+func (s *Server) responsesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	current := s.c.Responses()
+	responses := make([]activeResponse, len(current))
+	for i, staple := range current {
+		responses[i] = activeResponse{Name: staple.Name, Response: staple.Response}
+	}
+	s.writeJSON(w, r, responses)
+}
+
+// pullStandby imports the responses of the active instance which
+// differ from those in the cache, they are verified as if they had
+// been fetched from upstream
+func (s *Server) pullStandby() {
+	imported, err := s.importActive()
+	sb := s.standby
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.lastPull = s.clk.Now()
+	sb.lastError = ""
+	sb.imported = imported
+	if err != nil {
+		sb.lastError = err.Error()
+		s.log.Warning("[standby] Failed to pull responses from the active instance '%s': %s", sb.Active, err)
+		return
+	}
+	if imported > 0 {
+		s.log.Info("[standby] Imported %d responses from the active instance", imported)
+	}
+}
+
+// importActive pulls the responses of the active instance and imports
+// the ones that differ from and are newer than those in the cache, it
+// returns how many entries were updated
+func (s *Server) importActive() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.standby.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(s.standby.Active, "/")+"/responses", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.standby.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+	}
+	body := &valueLimitReader{r: resp.Body, limit: maxStandbyResponseSize}
+	dec := json.NewDecoder(body)
+	if _, err = dec.Token(); err != nil {
+		return 0, fmt.Errorf("failed to decode responses: %s", err)
+	}
+	current := make(map[[32]byte]bool)
+	for _, info := range s.c.Entries() {
+		current[info.ResponseDigest] = true
+	}
+	imported := 0
+	for dec.More() {
+		var ar activeResponse
+		if err = dec.Decode(&ar); err != nil {
+			return imported, fmt.Errorf("failed to decode responses: %s", err)
+		}
+		body.reset()
+		if current[common.Sum256(ar.Response)] {
+			continue
+		}
+		names, err := s.c.ImportNewer(ar.Response)
+		if errors.Is(err, mcache.ErrNoEntry) || errors.Is(err, mcache.ErrNotNewer) {
+			// a certificate only the active instance has, or a
+			// response the standby already has a newer one than
+			continue
+		}
+		if err != nil {
+			s.log.Warning("[standby] Rejected response for '%s' from the active instance: %s", ar.Name, err)
+		}
+		imported += len(names)
+	}
+	return imported, nil
+}
+
+// promote promotes the standby, reporting how it was promoted
+func (s *Server) promote(how string) bool {
+	if !s.c.Promote() {
+		return false
+	}
+	s.log.Warning("[standby] Promoted to active by %s, refreshing responses from upstream", how)
+	return true
+}
+
+// watchStandby pulls the responses of the active instance every
+// interval until the standby is promoted, which it is when the process
+// receives SIGUSR2
+func (s *Server) watchStandby() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+	var tick <-chan time.Time
+	if s.standby.Active != "" {
+		s.pullStandby()
+		ticker := time.NewTicker(s.standby.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for s.c.Standby() {
+		select {
+		case <-s.stop:
+			return
+		case <-signals:
+			s.promote("SIGUSR2")
+		case <-tick:
+			s.pullStandby()
+		}
+	}
+}
+
+// standbyReport is the body of /standby
+type standbyReport struct {
+	Standby   bool       `json:"standby"` // false once promoted
+	Active    string     `json:"active,omitempty"`
+	LastPull  *time.Time `json:"lastPull,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+	Imported  int        `json:"imported"` // entries updated by the last pull
+}
+
+// standbyStatus returns the standby state
+func (s *Server) standbyStatus() standbyReport {
+	report := standbyReport{Standby: s.c.Standby()}
+	if sb := s.standby; sb != nil {
+		sb.mu.Lock()
+		report.Active, report.LastError, report.Imported = sb.Active, sb.lastError, sb.imported
+		if !sb.lastPull.IsZero() {
+			lastPull := sb.lastPull
+			report.LastPull = &lastPull
+		}
+		sb.mu.Unlock()
+	}
+	return report
+}
+
+// standbyHandler serves the standby state at /standby
+func (s *Server) standbyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeJSON(w, r, s.standbyStatus())
+}
+
+// promoteHandler promotes a standby when /promote is POSTed to
+func (s *Server) promoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.promote("admin request") {
+		http.Error(w, "instance isn't a standby", http.StatusConflict)
+		return
+	}
+	s.writeJSON(w, r, s.standbyStatus())
+}
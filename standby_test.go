@@ -0,0 +1,108 @@
+package stapled
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+func TestStandby(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	active := httptest.NewServer(http.HandlerFunc(tf.s.responsesHandler))
+	defer active.Close()
+	var requests int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.Write(tf.response)
+	}))
+	defer upstream.Close()
+
+	f, err := ioutil.TempFile("", "cert")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile failed: %s", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(tf.certDER); err != nil {
+		t.Fatalf("Failed to write certificate: %s", err)
+	}
+	f.Close()
+	logger := log.NewLogger("", "", 10, tf.fc)
+	c := mcache.NewEntryCache(tf.fc, logger, time.Minute, nil, new(http.Client), time.Minute, nil, everyHash, true)
+	c.SetStandby()
+	if err = c.AddFromCertificate(f.Name(), tf.issuer, []string{upstream.URL}); err != nil {
+		t.Fatalf("Failed to add entry to cache: %s", err)
+	}
+	s, err := NewServer(WithCache(c), WithLogger(logger), WithClock(tf.fc), WithStandby(Standby{Active: active.URL}))
+	if err != nil {
+		t.Fatalf("NewServer failed: %s", err)
+	}
+
+	s.pullStandby()
+	status := s.standbyStatus()
+	if !status.Standby || status.Imported != 1 || status.LastError != "" || status.LastPull == nil {
+		t.Fatalf("Unexpected standby status after pull: %+v", status)
+	}
+	if responses := c.Responses(); len(responses) != 1 || !bytes.Equal(responses[0].Response, tf.response) {
+		t.Fatal("Standby didn't import the response of the active instance")
+	}
+	// unchanged responses aren't imported again
+	if s.pullStandby(); s.standbyStatus().Imported != 0 {
+		t.Fatalf("Unchanged response was imported again: %+v", s.standbyStatus())
+	}
+	if requests := atomic.LoadInt64(&requests); requests != 0 {
+		t.Fatalf("Standby fetched %d responses from upstream", requests)
+	}
+
+	promote := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.promoteHandler(w, httptest.NewRequest(method, "/promote", nil))
+		return w
+	}
+	if w := promote("GET"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405 for GET, got %d", w.Code)
+	}
+	w := promote("POST")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status promoting the standby: %d %s", w.Code, w.Body)
+	}
+	var report standbyReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to parse standby status: %s", err)
+	}
+	if report.Standby || c.Standby() {
+		t.Fatal("Standby wasn't promoted")
+	}
+	if w = promote("POST"); w.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 promoting a promoted standby, got %d", w.Code)
+	}
+	if err := c.Refresh(c.Responses()[0].Name); err != nil || atomic.LoadInt64(&requests) == 0 {
+		t.Fatalf("Promoted standby didn't fetch from upstream: %v", err)
+	}
+
+	// each response pulled is bounded
+	huge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name": "a", "response": "`))
+		w.Write(bytes.Repeat([]byte("A"), maxStandbyResponseSize))
+		w.Write([]byte(`"}]`))
+	}))
+	defer huge.Close()
+	s.standby.Active = huge.URL
+	if _, err := s.importActive(); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("Expected a error pulling a oversized response, got: %v", err)
+	}
+
+	if err := WithStandby(Standby{Active: "ftp://example.com"})(s); err == nil {
+		t.Fatal("WithStandby accepted a non-http URL")
+	}
+}
@@ -1,73 +1,535 @@
-package main
+// Package stapled provides a OCSP stapling server, combining a
+// self-updating cache of OCSP responses, the sources that populate
+// it, and a OCSP responder that serves from it. The stapled daemon
+// in cmd/stapled is a thin wrapper around this package which can
+// also be used to embed the whole system in other programs.
+package stapled
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/jmhodges/clock"
 
+	"github.com/rolandshoemaker/stapled/dnsdigest"
 	"github.com/rolandshoemaker/stapled/log"
 	"github.com/rolandshoemaker/stapled/mcache"
+	stapledMetrics "github.com/rolandshoemaker/stapled/metrics"
+	"github.com/rolandshoemaker/stapled/notify"
+	"github.com/rolandshoemaker/stapled/scache"
+	"github.com/rolandshoemaker/stapled/tracing"
 )
 
-type stapled struct {
+// Server serves OCSP responses from a cache and keeps the cache
+// populated from its configured sources
+type Server struct {
+	// accessed atomically, kept first so they are 64-bit aligned
+	hits       int64 // requests answered from memory
+	misses     int64
+	accessHits uint64 // hits considered for the sampled access log
+
 	log                *log.Logger
 	clk                clock.Clock
 	c                  *mcache.EntryCache
 	responder          *http.Server
-	certFolderWatcher  *dirWatcher
-	client             *http.Client
-	entryMonitorTick   time.Duration
+	responderListener  net.Listener
+	admin              *http.Server
+	adminListener      net.Listener
+	dns                *dnsdigest.Server
+	dnsAddr            string
+	dnsZone            string
+	certFolders        []*certFolder
+	certFolderInterval time.Duration
+	cloudSources       []*cloudSource
+	cloudInterval      time.Duration
+	controlFolder      string
+	notifier           *notify.Dispatcher
+	notifyInterval     time.Duration
+	notifyThresholds   NotifyThresholds
 	upstreamResponders []string
+	features           []string
+	rejectSHA1         bool
+	lightweight        bool
+	responderDelay     time.Duration // injected fault, see Faults
+	transport          *ReloadableTransport
+	bundlePath         string
+	bundleFormat       string
+	bundleInterval     time.Duration
+	bundleDigest       [32]byte // of the staples last written to bundlePath
+	fdLimit            uint64
+	fdWarned           uint32 // set while the limit warning applies, accessed atomically
+	socketPath         string
+	statsInterval      time.Duration
+	requests           *requestCache // nil unless WithRequestCache is used
+	accessLog          AccessLog
+	clients            *clientInventory // nil unless WithClientInventory is used
+	gameDay            *gameDay         // nil unless WithGameDay is used
+	shadow             *shadowMode      // nil unless WithShadowMode is used
+	missLog            *missLog         // nil unless WithMissLog is used
+	standby            *standbySync     // nil unless WithStandby is used
+	expired            expiredCertificates
+	otlp               *tracing.OTLPExporter // nil unless WithOTLPExporter is used
+	manifest           *manifest             // nil unless WithManifest is used
+	packExport         *packExport           // nil unless WithPackExport is used
+	issuerExport       *issuerExport         // nil unless WithIssuerExport is used
+	writeBehind        []*scache.WriteBehind // flushed in the background by Run and by Shutdown
+	packed             *packedResponses      // nil unless WithPackedResponses is used
+	clockCheck         *ClockCheck           // nil unless WithClockCheck is used
+	clockState         *clockState
+	diskCheck          *scache.DiskCache // nil unless WithDiskCheck is used
+	diskPrune          bool
+	diskReport         *DiskReport // set by Run before any listeners start
+	clientKey          []byte      // nil unless client addresses are hashed
+	metrics            stapledMetrics.Sink
+	prometheus         *stapledMetrics.Prometheus // served on the admin listener if set
+	metricsLog         *stapledMetrics.LogDump
+	metricsLogInterval time.Duration
+	efficiencyInterval time.Duration // zero unless WithEfficiencyReports is used
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Option configures a Server
+type Option func(*Server) error
+
+// WithCache sets the cache responses are served from, it is required
+func WithCache(c *mcache.EntryCache) Option {
+	return func(s *Server) error {
+		s.c = c
+		return nil
+	}
+}
+
+// WithLogger sets the logger, it is required
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Server) error {
+		s.log = logger
+		return nil
+	}
+}
+
+// WithClock sets the clock, if not set the system clock is used
+func WithClock(clk clock.Clock) Option {
+	return func(s *Server) error {
+		s.clk = clk
+		return nil
+	}
+}
+
+// WithResponderAddr sets the address the OCSP responder listens on
+func WithResponderAddr(addr string) Option {
+	return func(s *Server) error {
+		s.responder.Addr = addr
+		return nil
+	}
+}
+
+// WithResponderListener sets a listener for the OCSP responder to
+// serve on instead of listening on a address
+func WithResponderListener(l net.Listener) Option {
+	return func(s *Server) error {
+		s.responderListener = l
+		return nil
+	}
+}
+
+// WithAdminAddr enables the admin API on the provided address
+func WithAdminAddr(addr string) Option {
+	return func(s *Server) error {
+		s.admin = &http.Server{Addr: addr}
+		return nil
+	}
+}
+
+// WithAdminListener enables the admin API on the provided listener
+func WithAdminListener(l net.Listener) Option {
+	return func(s *Server) error {
+		s.admin = &http.Server{}
+		s.adminListener = l
+		return nil
+	}
+}
+
+// WithDNS enables the experimental DNS digest server, see the
+// dnsdigest package
+func WithDNS(addr, zone string) Option {
+	return func(s *Server) error {
+		if zone == "" {
+			return errors.New("a zone is required for the DNS digest server")
+		}
+		s.dnsAddr, s.dnsZone = addr, zone
+		return nil
+	}
+}
+
+// WithCertFolder adds entries for certificates in folder, which is
+// periodically checked for added and removed certificates. It may
+// be provided multiple times to watch multiple folders
+func WithCertFolder(folder string) Option {
+	return WithCertFolderOptions(folder, mcache.CertificateOptions{})
+}
+
+// WithCertFolderOptions is like WithCertFolder but entries for the
+// certificates in folder are created using opts, if opts doesn't
+// contain any responders the upstream responders are used
+func WithCertFolderOptions(folder string, opts mcache.CertificateOptions) Option {
+	return func(s *Server) error {
+		if folder == "" {
+			return nil
+		}
+		s.certFolders = append(s.certFolders, newCertFolder(folder, opts))
+		return nil
+	}
+}
+
+// WithCertFolderInterval sets how often the certificate folders are
+// checked for changes, the default is 15 seconds
+func WithCertFolderInterval(interval time.Duration) Option {
+	return func(s *Server) error {
+		s.certFolderInterval = interval
+		return nil
+	}
+}
+
+// WithReloadableTransport sets the transport used by the cache
+// client so that its proxies can be replaced using ReloadProxies or
+// the admin API
+func WithReloadableTransport(rt *ReloadableTransport) Option {
+	return func(s *Server) error {
+		s.transport = rt
+		return nil
+	}
+}
+
+// WithUpstreamResponders sets the responders that are used for
+// requests which aren't in the cache and for certificates found
+// in the certificate folder
+func WithUpstreamResponders(responders []string) Option {
+	return func(s *Server) error {
+		s.upstreamResponders = responders
+		return nil
+	}
+}
+
+// WithoutSHA1 rejects requests which identify the certificate using
+// SHA-1 hashes with the unauthorized status, each rejected request is
+// logged so that clients which still use SHA-1 can be found
+func WithoutSHA1() Option {
+	return func(s *Server) error {
+		s.rejectSHA1 = true
+		return nil
+	}
+}
+
+// WithLightweightProfile rejects requests which don't conform to the
+// RFC 5019 lightweight profile, those that contain a nonce or other
+// extensions, more than one certificate ID, or a signature, with the
+// malformedRequest status
+func WithLightweightProfile() Option {
+	return func(s *Server) error {
+		s.lightweight = true
+		return nil
+	}
+}
+
+// WithFeatures sets the list of enabled features reported by the
+// admin API
+func WithFeatures(features []string) Option {
+	return func(s *Server) error {
+		s.features = features
+		return nil
+	}
+}
+
+// WithMetrics sets the sink the responder reports metrics to, the
+// cache reports its own metrics to the sink passed to
+// mcache.EntryCache.SetMetrics
+func WithMetrics(sink stapledMetrics.Sink) Option {
+	return func(s *Server) error {
+		s.metrics = sink
+		return nil
+	}
+}
+
+// WithOTLPExporter sets e as the tracer spans are recorded with, see
+// the tracing package. It is closed, exporting any pending spans, by
+// Shutdown
+func WithOTLPExporter(e *tracing.OTLPExporter) Option {
+	return func(s *Server) error {
+		tracing.SetTracer(e)
+		s.otlp = e
+		return nil
+	}
+}
+
+// WithWriteBehind flushes the queued writes of each of queues in the
+// background, see scache.WriteBehind. Shutdown flushes every queued
+// write before returning
+func WithWriteBehind(queues ...*scache.WriteBehind) Option {
+	return func(s *Server) error {
+		s.writeBehind = append(s.writeBehind, queues...)
+		return nil
+	}
+}
+
+// WithPrometheus serves the metrics aggregated by p at /prometheus on
+// the admin listener, p should also be passed to WithMetrics and
+// mcache.EntryCache.SetMetrics, directly or using stapledMetrics.Multi
+func WithPrometheus(p *stapledMetrics.Prometheus) Option {
+	return func(s *Server) error {
+		s.prometheus = p
+		return nil
+	}
+}
+
+// WithMetricsLog logs the metrics aggregated by dump every interval
+func WithMetricsLog(dump *stapledMetrics.LogDump, interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("metrics log interval must be positive")
+		}
+		s.metricsLog = dump
+		s.metricsLogInterval = interval
+		return nil
+	}
 }
 
-func New(c *mcache.EntryCache, logger *log.Logger, clk clock.Clock, httpAddr string, responders []string, certFolder string) (*stapled, error) {
-	s := &stapled{
-		log:                logger,
-		clk:                clk,
-		c:                  c,
-		upstreamResponders: responders,
-		certFolderWatcher:  newDirWatcher(certFolder),
+// NewServer creates a Server, at least WithCache and WithLogger must
+// be provided
+func NewServer(opts ...Option) (*Server, error) {
+	s := &Server{
+		clk:                clock.Default(),
+		responder:          &http.Server{},
+		certFolderInterval: time.Second * 15,
+		cloudInterval:      time.Minute * 15,
+		metrics:            stapledMetrics.Discard,
+		stop:               make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.c == nil {
+		return nil, errors.New("a cache must be provided")
+	}
+	if s.log == nil {
+		return nil, errors.New("a logger must be provided")
+	}
+	s.initResponder()
+	if s.admin != nil {
+		s.initAdmin()
+	}
+	if s.dnsAddr != "" {
+		s.dns = dnsdigest.New(s.log, s.dnsAddr, s.dnsZone, s.c)
 	}
-	s.initResponder(httpAddr, logger)
 	return s, nil
 }
 
 // this should probably live on cache
-func (s *stapled) checkCertDirectory() {
-	added, removed, err := s.certFolderWatcher.check()
+func (s *Server) checkCertDirectories() {
+	for _, f := range s.certFolders {
+		s.checkCertDirectory(f)
+	}
+	s.checkFileDescriptors()
+}
+
+func (s *Server) checkCertDirectory(f *certFolder) {
+	added, removed, err := f.watcher.check()
 	if err != nil {
-		// log
-		s.log.Err("Failed to poll certificate directory: %s", err)
+		s.log.Err("[watcher] Failed to poll certificate directory '%s': %s", f.watcher.folder, err)
+		f.recordError(s.clk.Now(), err)
 		return
 	}
+	if len(added) > 0 || len(removed) > 0 {
+		s.log.Info("[watcher] Scanned '%s': %d files, %d added, %d removed", f.watcher.folder, len(f.watcher.files), len(added), len(removed))
+	}
+	opts := f.opts
+	if len(opts.Responders) == 0 {
+		opts.Responders = s.upstreamResponders
+	}
+	// certificates are added concurrently so that when a large cache
+	// is loaded from disk at startup the responses are verified by
+	// every worker in the verification pool
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	wg := new(sync.WaitGroup)
 	for _, a := range added {
-		err = s.c.AddFromCertificate(a, nil, s.upstreamResponders)
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(a string) {
+			defer func() { <-sem; wg.Done() }()
+			if err := s.c.AddFromCertificateWithOptions(a, opts); err != nil {
+				s.log.Err("Failed to add entry to cache for new certificate '%s': %s", a, err)
+				f.recordError(s.clk.Now(), err)
+			}
+		}(a)
+	}
+	wg.Wait()
+	for _, r := range removed {
+		err = s.c.RemoveFromCertificate(r)
 		if err != nil {
-			s.log.Err("Failed to add entry to cache for new certificate '%s': %s", a, err)
+			s.log.Err("Failed to remove entry from cache for removed certificate '%s': %s", r, err)
+			f.recordError(s.clk.Now(), err)
 		}
 	}
-	for _, r := range removed {
-		s.c.Remove(r)
+	f.recordScan(s.clk.Now(), len(added), len(removed))
+}
+
+// watcherStats returns the stats of each watched folder
+func (s *Server) watcherStats() []watcherStats {
+	stats := make([]watcherStats, len(s.certFolders))
+	for i, f := range s.certFolders {
+		stats[i] = f.getStats()
 	}
+	return stats
 }
 
-func (s *stapled) watchCertDirectory() {
-	ticker := time.NewTicker(time.Second * 15)
-	for _ = range ticker.C {
-		s.checkCertDirectory()
+func (s *Server) watchCertDirectories() {
+	ticker := time.NewTicker(s.certFolderInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.checkCertDirectories()
+		}
 	}
 }
 
-func (s *stapled) Run() error {
-	if s.certFolderWatcher != nil {
-		s.checkCertDirectory()
-		go s.watchCertDirectory()
+func serve(srv *http.Server, l net.Listener) error {
+	if srv.TLSConfig != nil {
+		// the certificate is provided by TLSConfig
+		if l != nil {
+			return srv.ServeTLS(l, "", "")
+		}
+		return srv.ListenAndServeTLS("", "")
 	}
-	err := s.responder.ListenAndServe()
-	if err != nil {
+	if l != nil {
+		return srv.Serve(l)
+	}
+	return srv.ListenAndServe()
+}
+
+// Run starts all of the configured sources and listeners and blocks
+// until the OCSP responder exits
+func (s *Server) Run() error {
+	s.checkFileDescriptors()
+	if len(s.certFolders) > 0 {
+		s.checkCertDirectories()
+		go s.watchCertDirectories()
+	}
+	if err := s.ReloadManifest(); err != nil {
+		s.log.Err("[manifest] Failed to load manifest: %s", err)
+	}
+	if s.diskCheck != nil {
+		s.checkDisk()
+	}
+	if len(s.cloudSources) > 0 {
+		go s.watchCloudSources()
+	}
+	if s.metricsLog != nil {
+		go s.metricsLog.Run(s.metricsLogInterval, s.stop)
+	}
+	if s.clockCheck != nil {
+		go s.watchClock()
+	}
+	if s.controlFolder != "" {
+		go s.watchControlFolder()
+	}
+	if s.notifier != nil {
+		go s.watchNotifications()
+	}
+	if s.gameDay != nil {
+		go s.watchGameDay()
+	}
+	if s.standby != nil {
+		go s.watchStandby()
+	}
+	if s.efficiencyInterval > 0 {
+		go s.watchEfficiency()
+	}
+	if s.bundlePath != "" {
+		go s.watchBundle()
+	}
+	if s.packExport != nil {
+		go s.watchPackExport()
+	}
+	if s.issuerExport != nil {
+		go s.watchIssuerExport()
+	}
+	for _, wb := range s.writeBehind {
+		go wb.Run(s.stop)
+	}
+	if s.packed != nil {
+		if err := s.loadPacked(); err != nil {
+			s.log.Err("[pack] Failed to load packed file '%s': %s", s.packed.path, err)
+		}
+		go s.watchPacked()
+	}
+	if s.statsInterval > 0 {
+		go s.watchStats()
+	}
+	if s.socketPath != "" {
+		l, err := s.listenControlSocket()
+		if err != nil {
+			return fmt.Errorf("failed to listen on control socket '%s': %s", s.socketPath, err)
+		}
+		go s.serveControlSocket(l)
+	}
+	if s.admin != nil {
+		go func() {
+			err := serve(s.admin, s.adminListener)
+			if err != nil && err != http.ErrServerClosed {
+				s.log.Err("Admin HTTP server died: %s", err)
+			}
+		}()
+	}
+	if s.dns != nil {
+		go func() {
+			err := s.dns.ListenAndServe()
+			select {
+			case <-s.stop:
+				// closed by Shutdown
+			default:
+				s.log.Err("DNS digest server died: %s", err)
+			}
+		}()
+	}
+	err := serve(s.responder, s.responderListener)
+	if err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("HTTP server died: %s", err)
 	}
 	return nil
 }
+
+// Shutdown gracefully stops the listeners and sources, after which
+// Run will return
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.c.Close()
+	for _, wb := range s.writeBehind {
+		wb.Flush()
+	}
+	if s.dns != nil {
+		s.dns.Close()
+	}
+	if s.admin != nil {
+		if err := s.admin.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	err := s.responder.Shutdown(ctx)
+	if s.otlp != nil {
+		tracing.SetTracer(nil)
+		s.otlp.Close()
+	}
+	return err
+}
@@ -1 +1,68 @@
-package main
+package stapled
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+func TestNewServer(t *testing.T) {
+	fc := clock.NewFake()
+	logger := log.NewLogger("", "", 10, fc)
+	c := mcache.NewEntryCache(fc, logger, time.Minute, nil, new(http.Client), time.Minute, nil, nil, true)
+
+	if _, err := NewServer(WithLogger(logger)); err == nil {
+		t.Fatal("NewServer didn't fail without a cache")
+	}
+	if _, err := NewServer(WithCache(c)); err == nil {
+		t.Fatal("NewServer didn't fail without a logger")
+	}
+	if _, err := NewServer(WithCache(c), WithLogger(logger), WithDNS("127.0.0.1:0", "")); err == nil {
+		t.Fatal("NewServer didn't fail with a DNS address but no zone")
+	}
+
+	responderListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %s", err)
+	}
+	adminListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %s", err)
+	}
+	s, err := NewServer(
+		WithCache(c),
+		WithLogger(logger),
+		WithClock(fc),
+		WithResponderListener(responderListener),
+		WithAdminListener(adminListener),
+	)
+	if err != nil {
+		t.Fatalf("NewServer failed: %s", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Run() }()
+
+	resp, err := http.Get("http://" + adminListener.Addr().String() + "/version")
+	if err != nil {
+		t.Fatalf("Failed to query admin API: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code from admin API: %d", resp.StatusCode)
+	}
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+	if err = <-done; err != nil {
+		t.Fatalf("Run returned a error after Shutdown: %s", err)
+	}
+}
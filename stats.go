@@ -0,0 +1,87 @@
+package stapled
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// statsExpiryWindow is how soon a response has to expire to be
+// counted as near expiry in the stats summary
+const statsExpiryWindow = time.Hour
+
+// WithStatsInterval logs a summary of the cache and responder
+// statistics every interval, for deployments without a metrics
+// system
+func WithStatsInterval(interval time.Duration) Option {
+	return func(s *Server) error {
+		s.statsInterval = interval
+		return nil
+	}
+}
+
+// recordLookup counts a request that was, or wasn't, answered from
+// memory
+func (s *Server) recordLookup(hit bool) {
+	if hit {
+		atomic.AddInt64(&s.hits, 1)
+		s.metrics.Counter("responder.hits", 1)
+	} else {
+		atomic.AddInt64(&s.misses, 1)
+		s.metrics.Counter("responder.misses", 1)
+	}
+}
+
+// statsSnapshot holds the counters from the previous summary so that
+// the next one can report what happened in between
+type statsSnapshot struct {
+	hits, misses      int64
+	refreshes, failed int64
+	skipped           int64
+	bytes             int64
+}
+
+// logStats logs a summary of what has happened since prev and returns
+// the counters to pass to the next call
+func (s *Server) logStats(prev statsSnapshot) statsSnapshot {
+	cs := s.c.Stats(statsExpiryWindow)
+	next := statsSnapshot{
+		hits:      atomic.LoadInt64(&s.hits),
+		misses:    atomic.LoadInt64(&s.misses),
+		refreshes: cs.Refreshes,
+		failed:    cs.RefreshFailures,
+		skipped:   cs.RefreshSkipped,
+		bytes:     cs.UpstreamBytes,
+	}
+	hits, misses := next.hits-prev.hits, next.misses-prev.misses
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses) * 100
+	}
+	s.log.Info(
+		"[stats] entries=%d requests=%d hit-rate=%.1f%% refreshes=%d refresh-failures=%d refreshes-skipped=%d upstream-bytes=%d near-expiry=%d no-response=%d",
+		cs.Entries,
+		hits+misses,
+		hitRate,
+		next.refreshes-prev.refreshes,
+		next.failed-prev.failed,
+		next.skipped-prev.skipped,
+		next.bytes-prev.bytes,
+		cs.NearExpiry,
+		cs.NoResponse,
+	)
+	return next
+}
+
+func (s *Server) watchStats() {
+	ticker := time.NewTicker(s.statsInterval)
+	defer ticker.Stop()
+	var prev statsSnapshot
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			prev = s.logStats(prev)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package stapled
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestLogStats(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	req, err := ocsp.ParseRequest(tf.request)
+	if err != nil {
+		t.Fatalf("ocsp.ParseRequest failed: %s", err)
+	}
+	if _, present := tf.s.Response(req); !present {
+		t.Fatal("Response didn't return a response for the cached entry")
+	}
+	first := tf.s.logStats(statsSnapshot{})
+	if first.hits != 1 || first.misses != 0 {
+		t.Fatalf("Unexpected lookup counts: %+v", first)
+	}
+	if first.refreshes != 1 || first.bytes != int64(len(tf.response)) {
+		t.Fatalf("Unexpected fetch counts: %+v", first)
+	}
+
+	req.SerialNumber.SetInt64(1)
+	tf.s.Response(req)
+	second := tf.s.logStats(first)
+	if second.hits != 1 || second.misses != 1 {
+		t.Fatalf("Unexpected lookup counts after miss: %+v", second)
+	}
+
+	stats := tf.s.c.Stats(statsExpiryWindow)
+	if stats.Entries != 1 || stats.NoResponse != 0 || stats.NearExpiry != 0 {
+		t.Fatalf("Unexpected cache stats: %+v", stats)
+	}
+}
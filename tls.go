@@ -0,0 +1,132 @@
+package stapled
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ResponderTLS serves the OCSP responder over TLS, for instance so
+// that it can be used across hosts as a internal staple distribution
+// service. The certificate, key, and client CA files are loaded again
+// when they change, so that they can be rotated without a restart
+type ResponderTLS struct {
+	Certificate string // PEM certificate chain
+	Key         string // PEM private key
+	// ClientCA is a PEM file of the CA certificates client
+	// certificates are verified against, if set clients which present
+	// a certificate must present one it issued, and if
+	// RequireClientCert is set every client must present one
+	ClientCA          string
+	RequireClientCert bool
+}
+
+// keyPairFiles is a certificate and key which are loaded again when
+// the modification time or size of either changes
+type keyPairFiles struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	pair    *tls.Certificate
+	modTime [2]time.Time
+	size    [2]int64
+}
+
+// load returns the key pair, reading it again if either file has
+// changed since it was last read. If the files can't be loaded, for
+// instance because only one of them has been replaced so far, the
+// key pair previously read is used until they can be
+func (kp *keyPairFiles) load() (*tls.Certificate, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	var modTime [2]time.Time
+	var size [2]int64
+	var err error
+	for i, filename := range []string{kp.certFile, kp.keyFile} {
+		var info os.FileInfo
+		if info, err = os.Stat(filename); err != nil {
+			break
+		}
+		modTime[i], size[i] = info.ModTime(), info.Size()
+	}
+	if err == nil && kp.pair != nil && modTime == kp.modTime && size == kp.size {
+		return kp.pair, nil
+	}
+	var pair tls.Certificate
+	if err == nil {
+		pair, err = tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	}
+	if err != nil {
+		if kp.pair != nil {
+			return kp.pair, nil
+		}
+		return nil, err
+	}
+	kp.pair, kp.modTime, kp.size = &pair, modTime, size
+	return kp.pair, nil
+}
+
+// responderTLSConfig builds the config the responder is served with
+func responderTLSConfig(rt ResponderTLS) (*tls.Config, error) {
+	if rt.Certificate == "" || rt.Key == "" {
+		return nil, errors.New("responder TLS requires a certificate and a key")
+	}
+	if rt.RequireClientCert && rt.ClientCA == "" {
+		return nil, errors.New("requiring client certificates requires a client CA")
+	}
+	kp := &keyPairFiles{certFile: rt.Certificate, keyFile: rt.Key}
+	if _, err := kp.load(); err != nil {
+		return nil, fmt.Errorf("failed to load responder certificate: %s", err)
+	}
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return kp.load()
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: getCertificate,
+	}
+	if rt.ClientCA == "" {
+		return config, nil
+	}
+	clientCAs := &rootsFile{filename: rt.ClientCA}
+	if _, err := clientCAs.load(); err != nil {
+		return nil, fmt.Errorf("failed to load client CA certificates: %s", err)
+	}
+	clientAuth := tls.VerifyClientCertIfGiven
+	if rt.RequireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	// the client CAs can only be replaced by returning a new config
+	// for each connection
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := clientCAs.load()
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{
+			MinVersion:     config.MinVersion,
+			NextProtos:     config.NextProtos,
+			GetCertificate: getCertificate,
+			ClientAuth:     clientAuth,
+			ClientCAs:      pool,
+		}, nil
+	}
+	return config, nil
+}
+
+// WithResponderTLS serves the OCSP responder over TLS, see
+// ResponderTLS
+func WithResponderTLS(rt ResponderTLS) Option {
+	return func(s *Server) error {
+		config, err := responderTLSConfig(rt)
+		if err != nil {
+			return err
+		}
+		s.responder.TLSConfig = config
+		return nil
+	}
+}
@@ -0,0 +1,141 @@
+package stapled
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResponderTLS(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	dir, err := ioutil.TempDir("", "stapled-tls")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %s", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, key.Public(), key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %s", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %s", err)
+	}
+	issue := func(serial int64, usage x509.ExtKeyUsage) []byte {
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "stapled"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}, ca, key.Public(), key)
+		if err != nil {
+			t.Fatalf("x509.CreateCertificate failed: %s", err)
+		}
+		return der
+	}
+	modTime := time.Now()
+	write := func(name, blockType string, der []byte) string {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile failed: %s", err)
+		}
+		// make sure the change is noticed on filesystems with coarse
+		// modification times
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatalf("os.Chtimes failed: %s", err)
+		}
+		return filename
+	}
+	rt := ResponderTLS{
+		Certificate:       write("cert.pem", "CERTIFICATE", issue(2, x509.ExtKeyUsageServerAuth)),
+		Key:               write("key.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+		ClientCA:          write("ca.pem", "CERTIFICATE", caDER),
+		RequireClientCert: true,
+	}
+	if err := WithResponderTLS(rt)(tf.s); err != nil {
+		t.Fatalf("WithResponderTLS failed: %s", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	go serve(tf.s.responder, l)
+	defer tf.s.responder.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert := tls.Certificate{Certificate: [][]byte{issue(3, x509.ExtKeyUsageClientAuth)}, PrivateKey: key}
+	post := func(certs []tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}, ForceAttemptHTTP2: true}}
+		resp, err := client.Post("https://"+l.Addr().String(), "application/ocsp-request", bytes.NewReader(tf.request))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(body, tf.response) {
+			t.Fatal("Responder returned unexpected response over TLS")
+		}
+		return resp, nil
+	}
+
+	resp, err := post([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatalf("Request with a client certificate failed: %s", err)
+	}
+	if resp.TLS.PeerCertificates[0].SerialNumber.Int64() != 2 || resp.ProtoMajor != 2 {
+		t.Fatalf("Unexpected connection: serial %s, %s", resp.TLS.PeerCertificates[0].SerialNumber, resp.Proto)
+	}
+	if _, err := post(nil); err == nil {
+		t.Fatal("Request without a client certificate succeeded")
+	}
+
+	// a rotated certificate is used for new connections
+	write("cert.pem", "CERTIFICATE", issue(4, x509.ExtKeyUsageServerAuth))
+	if resp, err = post([]tls.Certificate{clientCert}); err != nil {
+		t.Fatalf("Request after rotating the certificate failed: %s", err)
+	}
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 4 {
+		t.Fatalf("Expected the rotated certificate to be served, got serial %d", serial)
+	}
+
+	if _, err := responderTLSConfig(ResponderTLS{Certificate: rt.Certificate, Key: rt.Key, RequireClientCert: true}); err == nil {
+		t.Fatal("Requiring client certificates without a client CA was accepted")
+	}
+	if _, err := responderTLSConfig(ResponderTLS{Certificate: rt.Certificate, Key: rt.ClientCA}); err == nil {
+		t.Fatal("Certificate without a matching key was accepted")
+	}
+}
@@ -0,0 +1,297 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+const (
+	// maxPendingSpans bounds the spans waiting to be exported, spans
+	// ended while it is full are dropped
+	maxPendingSpans = 4096
+	// defaultExportInterval is how often pending spans are exported
+	defaultExportInterval = 5 * time.Second
+)
+
+// OTLPExporter is a Tracer which exports spans in batches to a
+// OpenTelemetry collector using OTLP over HTTP with the JSON encoding
+type OTLPExporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+	log         *log.Logger
+
+	mu      sync.Mutex
+	pending []*span
+	dropped int
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewOTLPExporter creates a OTLPExporter sending spans to the OTLP
+// HTTP endpoint, the path /v1/traces is used if it doesn't include
+// one, with headers, for example for authentication, set on each
+// request. It exports pending spans every interval, five seconds if
+// it is zero, until Close is called
+func NewOTLPExporter(endpoint string, headers map[string]string, serviceName string, interval time.Duration, logger *log.Logger) (*OTLPExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("OTLP endpoint '%s' must be a http or https URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	if serviceName == "" {
+		serviceName = "stapled"
+	}
+	if interval <= 0 {
+		interval = defaultExportInterval
+	}
+	e := &OTLPExporter{
+		endpoint:    u.String(),
+		headers:     headers,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		log:         logger,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.run(interval)
+	return e, nil
+}
+
+type spanKey struct{}
+
+// span is a span recorded by a OTLPExporter
+type span struct {
+	exporter *OTLPExporter
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // zero for the root of a trace
+	name     string
+	start    time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes []attribute
+	err        error
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// Start implements Tracer
+func (e *OTLPExporter) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &span{exporter: e, name: name, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, attribute{key, value})
+}
+
+func (s *span) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *span) End() {
+	s.mu.Lock()
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.exporter.add(s)
+}
+
+func (e *OTLPExporter) add(s *span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.pending) >= maxPendingSpans {
+		e.dropped++
+		return
+	}
+	e.pending = append(e.pending, s)
+}
+
+func (e *OTLPExporter) run(interval time.Duration) {
+	defer close(e.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			e.export()
+			return
+		case <-ticker.C:
+			e.export()
+		}
+	}
+}
+
+// Close exports any pending spans and stops the exporter, spans ended
+// after it is called are never exported
+func (e *OTLPExporter) Close() {
+	e.stopOnce.Do(func() { close(e.stop) })
+	<-e.done
+}
+
+// export sends the pending spans to the collector, they are dropped
+// if it can't be reached
+func (e *OTLPExporter) export() {
+	e.mu.Lock()
+	spans, dropped := e.pending, e.dropped
+	e.pending, e.dropped = nil, 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		e.log.Warning("[tracing] Dropped %d spans, more than %d were waiting to be exported", dropped, maxPendingSpans)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		e.log.Err("[tracing] Failed to encode spans: %s", err)
+		return
+	}
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		e.log.Err("[tracing] Failed to create export request: %s", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		e.log.Err("[tracing] Failed to export %d spans to '%s': %s", len(spans), e.endpoint, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e.log.Err("[tracing] Failed to export %d spans to '%s': collector returned status %d", len(spans), e.endpoint, resp.StatusCode)
+	}
+}
+
+// the subset of the OTLP JSON encoding that is sent, see
+// opentelemetry-proto/opentelemetry/proto/trace/v1/trace.proto
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 1 is ok, 2 is error
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+// spanKindInternal is the OTLP kind of every span
+const spanKindInternal = 1
+
+func otlpValue(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+}
+
+func (e *OTLPExporter) encode(spans []*span) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+		if s.parentID != ([8]byte{}) {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, a := range s.attributes {
+			o.Attributes = append(o.Attributes, otlpKeyValue{a.key, otlpValue(a.value)})
+		}
+		if s.err != nil {
+			o.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+		encoded = append(encoded, o)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{"service.name", otlpValue(e.serviceName)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/rolandshoemaker/stapled"},
+			Spans: encoded,
+		}},
+	}}}
+}
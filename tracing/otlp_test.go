@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/log"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	var mu sync.Mutex
+	var received []otlpRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, req)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	e, err := NewOTLPExporter(srv.URL, map[string]string{"Authorization": "Bearer token"}, "", time.Hour, log.NewLogger("", "", 0, clock.Default()))
+	if err != nil {
+		t.Fatalf("NewOTLPExporter failed: %s", err)
+	}
+	SetTracer(e)
+	defer SetTracer(nil)
+	if !Enabled() {
+		t.Fatal("Tracing isn't enabled after setting a tracer")
+	}
+	ctx, parent := Start(context.Background(), "parent")
+	parent.SetAttribute("attempt", 2)
+	_, child := Start(ctx, "child")
+	End(child, errors.New("broken"))
+	parent.End()
+	e.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 export request, got %d", len(received))
+	}
+	rs := received[0].ResourceSpans[0]
+	if name := rs.Resource.Attributes[0].Value["stringValue"]; name != "stapled" {
+		t.Fatalf("Unexpected service name: %v", name)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Name != "child" || spans[1].Name != "parent" {
+		t.Fatalf("Unexpected spans: %+v", spans)
+	}
+	c, p := spans[0], spans[1]
+	if c.TraceID != p.TraceID || c.ParentSpanID != p.SpanID || p.ParentSpanID != "" {
+		t.Fatalf("Child isn't in the parent's trace: %+v", spans)
+	}
+	if c.Status.Code != 2 || c.Status.Message != "broken" || p.Status.Code != 1 {
+		t.Fatalf("Unexpected span statuses: %+v", spans)
+	}
+	if len(p.Attributes) != 1 || p.Attributes[0].Value["intValue"] != "2" {
+		t.Fatalf("Unexpected attributes: %+v", p.Attributes)
+	}
+}
+
+func TestStartWithoutTracer(t *testing.T) {
+	ctx := context.Background()
+	got, span := Start(ctx, "dropped")
+	if got != ctx {
+		t.Fatal("Start changed the context without a tracer")
+	}
+	span.SetAttribute("key", "value")
+	End(span, errors.New("ignored"))
+}
@@ -0,0 +1,77 @@
+// Package tracing records spans for the work stapled does fetching,
+// verifying, storing, and serving responses. Spans are dropped unless
+// a Tracer is set with SetTracer, stapled includes one that exports
+// them to a OpenTelemetry collector, see OTLPExporter, and embedders
+// can bridge to their own tracing by implementing Tracer.
+package tracing
+
+import (
+	"context"
+	"sync"
+)
+
+// Span is a timed operation within a trace
+type Span interface {
+	// SetAttribute records a string, integer, float, or bool
+	// describing the operation, other values are formatted as strings
+	SetAttribute(key string, value interface{})
+	// RecordError marks the operation as failed
+	RecordError(err error)
+	// End finishes the operation, the span mustn't be used after it
+	End()
+}
+
+// Tracer starts spans, a span started with a context returned by
+// Start is a child of the span Start returned along with it
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+var current = struct {
+	sync.RWMutex
+	tracer Tracer
+}{}
+
+// SetTracer sets the tracer used by Start, nil drops every span
+func SetTracer(t Tracer) {
+	current.Lock()
+	defer current.Unlock()
+	current.tracer = t
+}
+
+// Enabled checks if a tracer has been set, so that attributes which
+// are expensive to compute can be skipped when spans are dropped
+func Enabled() bool {
+	current.RLock()
+	defer current.RUnlock()
+	return current.tracer != nil
+}
+
+// Start starts a span using the current tracer, if no tracer has been
+// set ctx is returned along with a span that does nothing
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	current.RLock()
+	t := current.tracer
+	current.RUnlock()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if t == nil {
+		return ctx, noopSpan{}
+	}
+	return t.Start(ctx, name)
+}
+
+// End records err, if it isn't nil, on span and ends it
+func End(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,219 @@
+package stapled
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/rolandshoemaker/stapled/common"
+)
+
+// upstreamSettings are the settings of the transports used for
+// upstream requests which are kept when the proxies are reloaded, a
+// nil *upstreamSettings uses the defaults
+type upstreamSettings struct {
+	dial dialFunc // nil uses the default dialer
+	// proxyTLS and responderTLS are used to verify https proxies and
+	// responders, nil uses the system roots
+	proxyTLS     *tls.Config
+	responderTLS *tls.Config
+	// noProxy lists hosts, and with a leading '.' domains, requests
+	// to which are never proxied
+	noProxy []string
+	// pins are the keys https responders must present, see
+	// responderPins
+	pins responderPins
+	// roots are the CA certificates trusted for some responders in
+	// place of responderTLS, see responderRoots
+	roots responderRoots
+}
+
+// loadCertPool reads a PEM file of CA certificates
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(contents) {
+		return nil, fmt.Errorf("'%s' doesn't contain any PEM certificates", filename)
+	}
+	return pool, nil
+}
+
+// proxyExcluded checks if requests to host shouldn't be proxied
+func proxyExcluded(host string, noProxy []string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(entry)
+		if entry == "*" {
+			return true
+		}
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxySet records the addresses of the https proxies a transport
+// has used so that connections to them can be told apart from
+// connections to https responders
+type proxySet struct {
+	mu    sync.RWMutex
+	addrs map[string]bool
+}
+
+func (ps *proxySet) add(addr string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.addrs[addr] = true
+}
+
+func (ps *proxySet) has(addr string) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.addrs[addr]
+}
+
+// configure sets the proxy and TLS settings of t, proxyFunc may be
+// nil if requests are never proxied
+func (us *upstreamSettings) configure(t *http.Transport, proxyFunc func(*http.Request) (*url.URL, error)) {
+	if us == nil {
+		t.Proxy = proxyFunc
+		return
+	}
+	if proxyFunc != nil && len(us.noProxy) > 0 {
+		next := proxyFunc
+		proxyFunc = func(req *http.Request) (*url.URL, error) {
+			if proxyExcluded(req.URL.Hostname(), us.noProxy) {
+				return nil, nil
+			}
+			return next(req)
+		}
+	}
+	t.Proxy = proxyFunc
+	if us.proxyTLS == nil && us.responderTLS == nil && len(us.roots) == 0 {
+		return
+	}
+	// responders reached through a CONNECT tunnel are verified using
+	// TLSClientConfig, DialTLSContext is used for https proxies and
+	// for responders which aren't proxied, which are told apart by
+	// recording the proxies that are used
+	t.TLSClientConfig = us.roots.config(us.responderTLS)
+	proxies := &proxySet{addrs: make(map[string]bool)}
+	if proxyFunc != nil {
+		next := t.Proxy
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			u, err := next(req)
+			if err == nil && u != nil && u.Scheme == "https" {
+				if addr, err := hostPort(u.String()); err == nil {
+					proxies.add(addr)
+				}
+			}
+			return u, err
+		}
+	}
+	dial := t.DialContext
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if proxies.has(addr) {
+			return dialTLS(ctx, dial, network, addr, us.proxyTLS)
+		}
+		config, err := us.roots.hostConfig(addr, us.responderTLS)
+		if err != nil {
+			return nil, err
+		}
+		return dialTLS(ctx, dial, network, addr, config)
+	}
+}
+
+// dialTLS makes a TLS connection to addr verified using config, or
+// the system roots if config is nil
+func dialTLS(ctx context.Context, dial dialFunc, network, addr string, config *tls.Config) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, config)
+	errc := make(chan error, 1)
+	go func() { errc <- tlsConn.Handshake() }()
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// rootsConfig returns a TLS config trusting the CA certificates in
+// filename, or nil if filename is empty
+func rootsConfig(filename string) (*tls.Config, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	pool, err := loadCertPool(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// TransportOptions describe a transport for upstream requests made by
+// tools, such as stapled-checkcert, which should take the same network
+// path as the daemon without a configuration file
+type TransportOptions struct {
+	// Proxies are picked from randomly, if empty the HTTP_PROXY,
+	// HTTPS_PROXY, and NO_PROXY environment variables are used
+	Proxies []string
+	// LocalAddr is the IP address, or the name of the interface whose
+	// address, connections are made from
+	LocalAddr string
+	// InsecureTLS skips verifying https proxies and responders
+	InsecureTLS bool
+}
+
+// NewTransport creates a transport for upstream requests configured
+// the same way as the one the daemon uses
+func NewTransport(opts TransportOptions) (http.RoundTripper, error) {
+	proxyFunc := http.ProxyFromEnvironment
+	if len(opts.Proxies) > 0 {
+		var err error
+		if proxyFunc, err = common.ProxyFunc(opts.Proxies); err != nil {
+			return nil, err
+		}
+	}
+	dial, err := localAddrDial(opts.LocalAddr, nil)
+	if err != nil {
+		return nil, err
+	}
+	upstream := &upstreamSettings{dial: dial}
+	if opts.InsecureTLS {
+		upstream.proxyTLS = &tls.Config{InsecureSkipVerify: true}
+		upstream.responderTLS = upstream.proxyTLS
+	}
+	return newTransport(proxyFunc, upstream), nil
+}
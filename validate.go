@@ -0,0 +1,119 @@
+package stapled
+
+import (
+	"crypto"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/rolandshoemaker/stapled/common"
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/log"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+// ValidateOptions override the settings of the entry described by
+// ValidateEntry
+type ValidateOptions struct {
+	Issuer     string   // issuer certificate file
+	Responders []string // replace the responders of the certificate
+}
+
+// ValidateEntry describes the entry the daemon would create for the
+// certificate in filename using conf, without loading or fetching a
+// response for it. If the certificate is configured in
+// definitions.certificates, or is in one of the watch folders, the
+// settings of the definition or folder are used, the issuer may
+// still be fetched using AIA
+func ValidateEntry(conf *config.Configuration, logger *log.Logger, clk clock.Clock, filename string, vo ValidateOptions) (*mcache.EntryDescription, error) {
+	configureHashes(conf)
+	timeout := 10 * time.Second
+	if conf.Fetcher.Timeout.Duration != 0 {
+		timeout = conf.Fetcher.Timeout.Duration
+	}
+	proxyFunc, err := proxySource(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure proxies: %s", err)
+	}
+	upstream, err := upstreamConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: newReloadableTransport(proxyFunc, conf.Fetcher.ResponderRewrites, upstream)}
+	issuers, err := loadIssuers(conf.Definitions.IssuerFolder, logger)
+	if err != nil {
+		return nil, err
+	}
+	hashes := conf.SupportedHashes
+	if sha1Disabled(conf) {
+		hashes = withoutSHA1(hashes)
+	}
+	c := mcache.NewEntryCache(clk, logger, time.Minute, nil, client, timeout, issuers, hashes, true)
+	defer c.Close()
+	if sha1Disabled(conf) {
+		c.SetRequestHash(crypto.SHA256)
+	}
+	if conf.LightweightProfile {
+		c.SetLightweightProfile(true)
+	}
+	defaultResponders, err := issuerResponders(conf)
+	if err != nil {
+		return nil, err
+	}
+	c.SetIssuerResponders(defaultResponders)
+	noResponders, err := noResponderPolicy(conf)
+	if err != nil {
+		return nil, err
+	}
+	c.SetNoResponderPolicy(noResponders)
+
+	opts, err := definitionOptions(conf, upstream, filename)
+	if err != nil {
+		return nil, err
+	}
+	if vo.Issuer != "" {
+		if opts.Issuer, err = common.ReadCertificate(vo.Issuer); err != nil {
+			return nil, fmt.Errorf("failed to load issuer '%s': %s", vo.Issuer, err)
+		}
+	}
+	if len(vo.Responders) > 0 {
+		opts.Responders = vo.Responders
+	}
+	return c.DescribeCertificate(filename, opts)
+}
+
+// definitionOptions returns the options of the certificate definition
+// for filename, or of the watch folder it is in, whose certificates
+// use fetcher.upstream-responders if the folder doesn't have its own,
+// or the defaults if neither is configured
+func definitionOptions(conf *config.Configuration, upstream *upstreamSettings, filename string) (mcache.CertificateOptions, error) {
+	filename = filepath.Clean(filename)
+	for _, def := range conf.Definitions.Certificates {
+		if filepath.Clean(def.Certificate) == filename {
+			return certDefinitionOptions(def, conf.Definitions.UnknownStatus)
+		}
+	}
+	folders := conf.Definitions.CertWatchFolders
+	if conf.Definitions.CertWatchFolder != "" {
+		folders = append([]config.WatchFolder{{Folder: conf.Definitions.CertWatchFolder}}, folders...)
+	}
+	dir := filepath.Dir(filename)
+	for _, wf := range folders {
+		if filepath.Clean(wf.Folder) != dir {
+			continue
+		}
+		opts, err := watchFolderCertOptions(wf, upstream, conf.Definitions.UnknownStatus)
+		if err == nil && len(opts.Responders) == 0 {
+			opts.Responders = conf.Fetcher.UpstreamResponders
+		}
+		return opts, err
+	}
+	unknown, err := mcache.ParseUnknownPolicy(conf.Definitions.UnknownStatus)
+	if err != nil {
+		return mcache.CertificateOptions{}, fmt.Errorf("invalid definitions.unknown-status: %s", err)
+	}
+	return mcache.CertificateOptions{UnknownPolicy: unknown}, nil
+}
@@ -0,0 +1,70 @@
+package stapled
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rolandshoemaker/stapled/config"
+	"github.com/rolandshoemaker/stapled/mcache"
+)
+
+func TestValidateEntry(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+	dir, err := ioutil.TempDir("", "validate-entry")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, issuerFile := filepath.Join(dir, "site.der"), filepath.Join(dir, "issuer.der")
+	if err = ioutil.WriteFile(certFile, tf.certDER, 0644); err != nil {
+		t.Fatalf("Failed to write certificate: %s", err)
+	}
+	if err = ioutil.WriteFile(issuerFile, tf.issuer.Raw, 0644); err != nil {
+		t.Fatalf("Failed to write issuer: %s", err)
+	}
+
+	conf := &config.Configuration{}
+	desc, err := ValidateEntry(conf, tf.s.log, tf.fc, certFile, ValidateOptions{Issuer: issuerFile, Responders: []string{"http://ocsp.example.com/"}})
+	if err != nil {
+		t.Fatalf("ValidateEntry failed: %s", err)
+	}
+	if desc.Name != "site" || desc.Serial != "539" || desc.IssuerSource != "provided" || desc.Issuer != "CN=issuer" {
+		t.Fatalf("Unexpected entry: %+v", desc)
+	}
+	if len(desc.Responders) != 1 || desc.Responders[0] != "http://ocsp.example.com" {
+		t.Fatalf("Responders weren't normalized: %v", desc.Responders)
+	}
+	if desc.Request == "" || desc.RequestHash != "SHA-1" || desc.UnknownStatus != "serve" {
+		t.Fatalf("Unexpected request: %+v", desc)
+	}
+
+	// the definition for the certificate is used
+	conf.Definitions.Certificates = []config.CertDefinition{{
+		Certificate:   certFile,
+		Issuer:        issuerFile,
+		Responders:    []string{"http://definition.example.com"},
+		ResponseName:  "{{.IssuerCN}}/{{.Serial}}",
+		UnknownStatus: "alert",
+	}}
+	desc, err = ValidateEntry(conf, tf.s.log, tf.fc, certFile, ValidateOptions{})
+	if err != nil {
+		t.Fatalf("ValidateEntry failed: %s", err)
+	}
+	if desc.Responders[0] != "http://definition.example.com" || desc.ResponseName != "issuer/539" || desc.UnknownStatus != "alert" {
+		t.Fatalf("Definition settings weren't used: %+v", desc)
+	}
+
+	// without a issuer or responders
+	conf.Definitions.Certificates = nil
+	conf.Definitions.NoResponderPolicy = "fail"
+	if _, err = ValidateEntry(conf, tf.s.log, tf.fc, certFile, ValidateOptions{Issuer: issuerFile}); !errors.Is(err, mcache.ErrNoResponders) {
+		t.Fatalf("Expected ErrNoResponders, got: %v", err)
+	}
+	if _, err = ValidateEntry(conf, tf.s.log, tf.fc, certFile, ValidateOptions{Responders: []string{"http://ocsp.example.com"}}); !errors.Is(err, mcache.ErrNoIssuer) {
+		t.Fatalf("Expected ErrNoIssuer, got: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+// Package version provides information about the build that is
+// currently running. Version and Commit are intended to be set at
+// build time using
+//
+//	go build -ldflags "-X github.com/rolandshoemaker/stapled/version.Version=v0.1 \
+//		-X github.com/rolandshoemaker/stapled/version.Commit=$(git rev-parse HEAD)"
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+var (
+	// Version is the release version of the build
+	Version = "dev"
+	// Commit is the git commit the build was made from
+	Commit = "unknown"
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	GoVersion string   `json:"goVersion"`
+	Platform  string   `json:"platform"`
+	Features  []string `json:"features,omitempty"`
+}
+
+// Get returns the Info for the running build, features is a list
+// of the optional features that have been enabled
+func Get(features []string) Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+		Platform:  fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+		Features:  features,
+	}
+}
+
+// String returns a single line human readable description of the
+// build
+func (i Info) String() string {
+	s := fmt.Sprintf("%s (commit %s, %s %s)", i.Version, i.Commit, i.GoVersion, i.Platform)
+	if len(i.Features) > 0 {
+		s += fmt.Sprintf(", features: %s", strings.Join(i.Features, ", "))
+	}
+	return s
+}
@@ -0,0 +1,24 @@
+package version
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	info := Get([]string{"a", "b"})
+	if info.GoVersion != runtime.Version() {
+		t.Fatalf("Unexpected Go version: wanted %q, got %q", runtime.Version(), info.GoVersion)
+	}
+	s := info.String()
+	if !strings.HasPrefix(s, Version+" (commit "+Commit) {
+		t.Fatalf("Unexpected version string: %q", s)
+	}
+	if !strings.HasSuffix(s, "features: a, b") {
+		t.Fatalf("Version string didn't contain features: %q", s)
+	}
+	if s = Get(nil).String(); strings.Contains(s, "features") {
+		t.Fatalf("Version string contained features when none were provided: %q", s)
+	}
+}
@@ -1,10 +1,72 @@
-package main
+package stapled
 
 import (
 	"io/ioutil"
 	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rolandshoemaker/stapled/mcache"
 )
 
+// certFolder is a watched folder of certificates along with the
+// options used to create entries for them
+type certFolder struct {
+	watcher *dirWatcher
+	opts    mcache.CertificateOptions
+
+	mu    sync.Mutex
+	stats watcherStats
+}
+
+// watcherStats describes the scans of a watched folder
+type watcherStats struct {
+	Folder      string    `json:"folder"`
+	Files       int       `json:"files"`   // files seen by the last successful scan
+	Scans       int64     `json:"scans"`   // successful scans
+	Added       int64     `json:"added"`   // files added across all scans
+	Removed     int64     `json:"removed"` // files removed across all scans
+	Errors      int64     `json:"errors"`  // failed scans, and files that couldn't be added or removed
+	LastScan    time.Time `json:"lastScan,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt,omitempty"`
+}
+
+func newCertFolder(folder string, opts mcache.CertificateOptions) *certFolder {
+	return &certFolder{
+		watcher: newDirWatcher(folder),
+		opts:    opts,
+		stats:   watcherStats{Folder: folder},
+	}
+}
+
+// recordScan records a successful scan at now
+func (f *certFolder) recordScan(now time.Time, added, removed int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats.Files = len(f.watcher.files)
+	f.stats.Scans++
+	f.stats.Added += int64(added)
+	f.stats.Removed += int64(removed)
+	f.stats.LastScan = now
+}
+
+// recordError records a failed scan, or a file that couldn't be added
+// or removed, at now
+func (f *certFolder) recordError(now time.Time, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats.Errors++
+	f.stats.LastError = err.Error()
+	f.stats.LastErrorAt = now
+}
+
+func (f *certFolder) getStats() watcherStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
 type dirWatcher struct {
 	folder string
 	files  map[string]struct{}
@@ -1,10 +1,14 @@
-package main
+package stapled
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/rolandshoemaker/stapled/mcache"
 )
 
 func TestDirWatcher(t *testing.T) {
@@ -84,3 +88,59 @@ func TestDirWatcher(t *testing.T) {
 		t.Fatalf("Expected 0 removed files in temporary directory, got %d", len(r))
 	}
 }
+
+func TestCertFolderOptions(t *testing.T) {
+	tf := newTestFixture(t)
+	defer tf.close()
+
+	hits := 0
+	otherUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write(tf.response)
+	}))
+	defer otherUpstream.Close()
+
+	folders := []string{}
+	for _, name := range []string{"a", "b"} {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("Failed to create a temporary directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		err = ioutil.WriteFile(filepath.Join(dir, name+".der"), tf.certDER, os.ModePerm)
+		if err != nil {
+			t.Fatalf("Failed to write certificate: %s", err)
+		}
+		folders = append(folders, dir)
+	}
+
+	s, err := NewServer(
+		WithCache(tf.s.c),
+		WithLogger(tf.s.log),
+		WithUpstreamResponders([]string{tf.upstream.URL}),
+		WithCertFolderOptions(folders[0], mcache.CertificateOptions{
+			Issuer: tf.issuer,
+			Labels: map[string]string{"app": "a"},
+		}),
+		WithCertFolderOptions(folders[1], mcache.CertificateOptions{
+			Issuer:     tf.issuer,
+			Responders: []string{otherUpstream.URL},
+			Labels:     map[string]string{"app": "b"},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewServer failed: %s", err)
+	}
+	s.checkCertDirectories()
+
+	if hits != 1 {
+		t.Fatalf("Expected folder responders to be used for one entry, got %d requests", hits)
+	}
+	labels := map[string]string{}
+	for _, info := range s.c.Entries() {
+		labels[info.Name] = info.Labels["app"]
+	}
+	if labels["a"] != "a" || labels["b"] != "b" {
+		t.Fatalf("Entries have unexpected labels: %v", labels)
+	}
+}